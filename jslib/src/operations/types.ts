@@ -45,6 +45,7 @@ export interface UserData {
   email: string;
   emailVerified: boolean;
   pro: boolean;
+  billing: boolean;
   classic: boolean;
 }
 
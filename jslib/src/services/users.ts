@@ -26,6 +26,7 @@ export interface UpdateProfileParams {
 export interface UpdatePasswordParams {
   oldPassword: string;
   newPassword: string;
+  revokeOtherSessions?: boolean;
 }
 
 export interface RegisterParams {
@@ -65,6 +66,7 @@ export interface GetMeResponse {
     email: string;
     email_verified: boolean;
     pro: boolean;
+    billing: boolean;
     classic: boolean;
   };
 }
@@ -91,10 +93,15 @@ export default function init(config: HttpClientConfig) {
       return client.patch('/account/profile', payload);
     },
 
-    updatePassword: ({ oldPassword, newPassword }: UpdatePasswordParams) => {
+    updatePassword: ({
+      oldPassword,
+      newPassword,
+      revokeOtherSessions = false
+    }: UpdatePasswordParams) => {
       const payload = {
         old_password: oldPassword,
-        new_password: newPassword
+        new_password: newPassword,
+        revoke_other_sessions: revokeOtherSessions
       };
 
       return client.patch('/account/password', payload);
@@ -173,6 +180,7 @@ export default function init(config: HttpClientConfig) {
           email: user.email,
           emailVerified: user.email_verified,
           pro: user.pro,
+          billing: user.billing,
           classic: user.classic
         };
       });
@@ -23,9 +23,11 @@ package client
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -38,14 +40,28 @@ import (
 // ErrInvalidLogin is an error for invalid credentials for login
 var ErrInvalidLogin = errors.New("wrong credentials")
 
+// ErrTOTPRequired is an error for a signin without the two-factor authentication code
+// to an account that has the two-factor authentication enabled
+var ErrTOTPRequired = errors.New("two-factor authentication code is required")
+
+// ErrInvalidTOTP is an error for a wrong two-factor authentication code or recovery code
+var ErrInvalidTOTP = errors.New("wrong two-factor authentication code")
+
+// ErrNotLoggedIn is an error for requests that require a session when not logged in
+var ErrNotLoggedIn = errors.New("not logged in. Please log in with 'dnote login'")
+
+// ErrSessionExpired is an error for requests made with a session that is expired
+// or no longer valid
+var ErrSessionExpired = errors.New("your session has expired. Please log in again with 'dnote login'")
+
 // requestOptions contians options for requests
 type requestOptions struct {
 	HTTPClient *http.Client
 }
 
-func getReq(ctx context.DnoteCtx, path, method, body string) (*http.Request, error) {
+func getReq(ctx context.DnoteCtx, path, method string, body io.Reader) (*http.Request, error) {
 	endpoint := fmt.Sprintf("%s%s", ctx.APIEndpoint, path)
-	req, err := http.NewRequest(method, endpoint, strings.NewReader(body))
+	req, err := http.NewRequest(method, endpoint, body)
 	if err != nil {
 		return nil, errors.Wrap(err, "constructing http request")
 	}
@@ -78,11 +94,16 @@ func checkRespErr(res *http.Response) error {
 
 // doReq does a http request to the given path in the api endpoint
 func doReq(ctx context.DnoteCtx, method, path, body string, options *requestOptions) (*http.Response, error) {
-	req, err := getReq(ctx, path, method, body)
+	req, err := getReq(ctx, path, method, strings.NewReader(body))
 	if err != nil {
 		return nil, errors.Wrap(err, "getting request")
 	}
 
+	return do(req, options)
+}
+
+// do makes the given request and checks the response for an error
+func do(req *http.Request, options *requestOptions) (*http.Response, error) {
 	log.Debug("HTTP request: %+v\n", req)
 
 	var hc http.Client
@@ -108,10 +129,15 @@ func doReq(ctx context.DnoteCtx, method, path, body string, options *requestOpti
 // with the appropriate headers. The given path should include the preceding slash.
 func doAuthorizedReq(ctx context.DnoteCtx, method, path, body string, options *requestOptions) (*http.Response, error) {
 	if ctx.SessionKey == "" {
-		return nil, errors.New("no session key found")
+		return nil, ErrNotLoggedIn
 	}
 
-	return doReq(ctx, method, path, body, options)
+	res, err := doReq(ctx, method, path, body, options)
+	if res != nil && res.StatusCode == http.StatusUnauthorized {
+		return res, ErrSessionExpired
+	}
+
+	return res, err
 }
 
 // GetSyncStateResp is the response get sync state endpoint
@@ -155,6 +181,17 @@ type SyncFragNote struct {
 	Body      string    `json:"content"`
 	Public    bool      `json:"public"`
 	Deleted   bool      `json:"deleted"`
+	// Attachments are the metadata of the attachments of the note
+	Attachments []SyncFragAttachment `json:"attachments"`
+}
+
+// SyncFragAttachment represents an attachment of a note in a sync fragment
+type SyncFragAttachment struct {
+	UUID        string `json:"uuid"`
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Hash        string `json:"hash"`
 }
 
 // SyncFragBook represents a book in a sync fragment and contains only the necessary information
@@ -166,7 +203,10 @@ type SyncFragBook struct {
 	UpdatedAt time.Time `json:"updated_at"`
 	AddedOn   int64     `json:"added_on"`
 	Label     string    `json:"label"`
-	Deleted   bool      `json:"deleted"`
+	// ParentUUID is the uuid of the book that contains the book. It is empty
+	// for a top-level book.
+	ParentUUID string `json:"parent_uuid"`
+	Deleted    bool   `json:"deleted"`
 }
 
 // SyncFragment contains a piece of information about the server's state.
@@ -215,11 +255,15 @@ type RespBook struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Label     string    `json:"label"`
+	// ParentUUID is the uuid of the book that contains the book. It is empty
+	// for a top-level book.
+	ParentUUID string `json:"parent_uuid"`
 }
 
 // CreateBookPayload is a payload for creating a book
 type CreateBookPayload struct {
-	Name string `json:"name"`
+	Name       string `json:"name"`
+	ParentUUID string `json:"parent_uuid,omitempty"`
 }
 
 // CreateBookResp is the response from create book api
@@ -227,10 +271,12 @@ type CreateBookResp struct {
 	Book RespBook `json:"book"`
 }
 
-// CreateBook creates a new book in the server
-func CreateBook(ctx context.DnoteCtx, label string) (CreateBookResp, error) {
+// CreateBook creates a new book in the server. If parentUUID is not empty, the
+// book is created in the book with the uuid.
+func CreateBook(ctx context.DnoteCtx, label, parentUUID string) (CreateBookResp, error) {
 	payload := CreateBookPayload{
-		Name: label,
+		Name:       label,
+		ParentUUID: parentUUID,
 	}
 	b, err := json.Marshal(payload)
 	if err != nil {
@@ -251,7 +297,8 @@ func CreateBook(ctx context.DnoteCtx, label string) (CreateBookResp, error) {
 }
 
 type updateBookPayload struct {
-	Name *string `json:"name"`
+	Name       *string `json:"name"`
+	ParentUUID *string `json:"parent_uuid"`
 }
 
 // UpdateBookResp is the response from create book api
@@ -259,10 +306,12 @@ type UpdateBookResp struct {
 	Book RespBook `json:"book"`
 }
 
-// UpdateBook updates a book in the server
-func UpdateBook(ctx context.DnoteCtx, label, uuid string) (UpdateBookResp, error) {
+// UpdateBook updates a book in the server. An empty parentUUID makes the book a
+// top-level book.
+func UpdateBook(ctx context.DnoteCtx, label, parentUUID, uuid string) (UpdateBookResp, error) {
 	payload := updateBookPayload{
-		Name: &label,
+		Name:       &label,
+		ParentUUID: &parentUUID,
 	}
 	b, err := json.Marshal(payload)
 	if err != nil {
@@ -422,6 +471,126 @@ func DeleteNote(ctx context.DnoteCtx, uuid string) (DeleteNoteResp, error) {
 	return resp, nil
 }
 
+type shareNotePayload struct {
+	ExpiresAt *int64 `json:"expires_at"`
+}
+
+// RespNoteShare is the sharing state of a note in the server
+type RespNoteShare struct {
+	NoteUUID  string     `json:"note_uuid"`
+	Public    bool       `json:"public"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	ViewCount int        `json:"view_count"`
+}
+
+// ShareNoteResp is the response from share note api
+type ShareNoteResp struct {
+	Result RespNoteShare `json:"result"`
+}
+
+// ShareNote makes a note in the server publicly accessible through its share link.
+// expiresAt is a unix timestamp in seconds, and the link does not expire if it is nil.
+func ShareNote(ctx context.DnoteCtx, uuid string, expiresAt *int64) (ShareNoteResp, error) {
+	payload := shareNotePayload{
+		ExpiresAt: expiresAt,
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return ShareNoteResp{}, errors.Wrap(err, "marshaling payload")
+	}
+
+	endpoint := fmt.Sprintf("/v3/notes/%s/share", uuid)
+	res, err := doAuthorizedReq(ctx, "POST", endpoint, string(b), nil)
+	if err != nil {
+		return ShareNoteResp{}, errors.Wrap(err, "sharing a note in the server")
+	}
+
+	var resp ShareNoteResp
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return ShareNoteResp{}, errors.Wrap(err, "decoding payload")
+	}
+
+	return resp, nil
+}
+
+// UnshareNote revokes the share link of a note in the server
+func UnshareNote(ctx context.DnoteCtx, uuid string) (ShareNoteResp, error) {
+	endpoint := fmt.Sprintf("/v3/notes/%s/share", uuid)
+	res, err := doAuthorizedReq(ctx, "DELETE", endpoint, "", nil)
+	if err != nil {
+		return ShareNoteResp{}, errors.Wrap(err, "unsharing a note in the server")
+	}
+
+	var resp ShareNoteResp
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return ShareNoteResp{}, errors.Wrap(err, "decoding payload")
+	}
+
+	return resp, nil
+}
+
+// RespAttachment is an attachment in the response
+type RespAttachment struct {
+	UUID        string    `json:"uuid"`
+	NoteUUID    string    `json:"note_uuid"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Hash        string    `json:"hash"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// CreateAttachment uploads the content read from the given reader as an attachment
+// of a note in the server
+func CreateAttachment(ctx context.DnoteCtx, noteUUID, name, contentType string, r io.Reader, size int64) (RespAttachment, error) {
+	if ctx.SessionKey == "" {
+		return RespAttachment{}, ErrNotLoggedIn
+	}
+
+	v := url.Values{}
+	v.Set("name", name)
+	path := fmt.Sprintf("/v3/notes/%s/attachments?%s", noteUUID, v.Encode())
+
+	req, err := getReq(ctx, path, "POST", r)
+	if err != nil {
+		return RespAttachment{}, errors.Wrap(err, "getting request")
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+
+	res, err := do(req, nil)
+	if res != nil && res.StatusCode == http.StatusUnauthorized {
+		return RespAttachment{}, ErrSessionExpired
+	}
+	if err != nil {
+		return RespAttachment{}, errors.Wrap(err, "uploading an attachment to the server")
+	}
+	defer res.Body.Close()
+
+	var resp RespAttachment
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return RespAttachment{}, errors.Wrap(err, "decoding payload")
+	}
+
+	return resp, nil
+}
+
+// GetAttachment downloads the content of an attachment from the server. The caller
+// must close the returned reader.
+func GetAttachment(ctx context.DnoteCtx, uuid string) (io.ReadCloser, error) {
+	res, err := doAuthorizedReq(ctx, "GET", fmt.Sprintf("/v3/attachments/%s", uuid), "", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "downloading an attachment from the server")
+	}
+
+	return res.Body, nil
+}
+
+// GetPublicNoteURL returns the URL at which a shared note can be viewed
+func GetPublicNoteURL(ctx context.DnoteCtx, uuid string) string {
+	return fmt.Sprintf("%s/public/notes/%s", ctx.APIEndpoint, uuid)
+}
+
 // GetBooksResp is a response from get books endpoint
 type GetBooksResp []struct {
 	UUID  string `json:"uuid"`
@@ -465,33 +634,230 @@ func GetPresignin(ctx context.DnoteCtx, email string) (PresigninResponse, error)
 
 // SigninPayload is a payload for /v3/signin
 type SigninPayload struct {
-	Email    string `json:"email"`
-	Passowrd string `json:"password"`
+	Email        string `json:"email"`
+	Passowrd     string `json:"password"`
+	TOTPCode     string `json:"totp_code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+	DeviceName   string `json:"device_name,omitempty"`
 }
 
 // SigninResponse is a response from /v3/signin endpoint
 type SigninResponse struct {
-	Key       string `json:"key"`
-	ExpiresAt int64  `json:"expires_at"`
+	Key                    string `json:"key"`
+	ExpiresAt              int64  `json:"expires_at"`
+	TOTPEnrollmentRequired bool   `json:"totp_enrollment_required"`
+}
+
+// getDeviceName returns the name of this device, with which the session is listed
+// on the server. It returns an empty string if the name cannot be found.
+func getDeviceName() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Debug("getting hostname: %s\n", err.Error())
+		return ""
+	}
+
+	return hostname
+}
+
+// isTOTPCode checks if the given code is a two-factor authentication code
+// rather than a recovery code
+func isTOTPCode(code string) bool {
+	if len(code) != 6 {
+		return false
+	}
+
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
 }
 
-// Signin requests a session token
-func Signin(ctx context.DnoteCtx, email, password string) (SigninResponse, error) {
+// Signin requests a session token. The code is either a two-factor authentication
+// code or a recovery code, and is required only if the account has the two-factor
+// authentication enabled.
+func Signin(ctx context.DnoteCtx, email, password, code string) (SigninResponse, error) {
 	payload := SigninPayload{
-		Email:    email,
-		Passowrd: password,
+		Email:      email,
+		Passowrd:   password,
+		DeviceName: getDeviceName(),
+	}
+	if isTOTPCode(code) {
+		payload.TOTPCode = code
+	} else {
+		payload.RecoveryCode = code
 	}
 	b, err := json.Marshal(payload)
 	if err != nil {
 		return SigninResponse{}, errors.Wrap(err, "marshaling payload")
 	}
 	res, err := doReq(ctx, "POST", "/v3/signin", string(b), nil)
+	if res != nil && res.StatusCode == http.StatusUnauthorized {
+		if res.Header.Get("TOTP-Required") != "" {
+			if code == "" {
+				return SigninResponse{}, ErrTOTPRequired
+			}
+
+			return SigninResponse{}, ErrInvalidTOTP
+		}
+
+		return SigninResponse{}, ErrInvalidLogin
+	}
 	if err != nil {
 		return SigninResponse{}, errors.Wrap(err, "making http request")
 	}
 
-	if res.StatusCode == http.StatusUnauthorized {
-		return SigninResponse{}, ErrInvalidLogin
+	var resp SigninResponse
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return SigninResponse{}, errors.Wrap(err, "decoding payload")
+	}
+
+	return resp, nil
+}
+
+// ErrSSONotEnabled is an error for a single sign-on to a server that does not have it enabled
+var ErrSSONotEnabled = errors.New("single sign-on is not enabled on the server")
+
+// ErrSSOAuthorizationPending is an error for a device authorization that the user
+// has not approved yet
+var ErrSSOAuthorizationPending = errors.New("authorization is pending")
+
+// ErrSSOSlowDown is an error for polling a device authorization too often
+var ErrSSOSlowDown = errors.New("polling too often")
+
+// ErrSSOAccessDenied is an error for a device authorization that the user denied
+var ErrSSOAccessDenied = errors.New("the login was denied")
+
+// ErrSSOExpired is an error for a device authorization that has expired
+var ErrSSOExpired = errors.New("the login code has expired")
+
+// SSODeviceAuthorization is the response from the start single sign-on device api
+type SSODeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// StartSSODevice starts a single sign-on in which the user approves the login
+// in a browser on any device
+func StartSSODevice(ctx context.DnoteCtx) (SSODeviceAuthorization, error) {
+	res, err := doReq(ctx, "POST", "/v3/sso/device", "", nil)
+	if res != nil && res.StatusCode == http.StatusNotFound {
+		return SSODeviceAuthorization{}, ErrSSONotEnabled
+	}
+	if err != nil {
+		return SSODeviceAuthorization{}, errors.Wrap(err, "making http request")
+	}
+
+	var resp SSODeviceAuthorization
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return SSODeviceAuthorization{}, errors.Wrap(err, "decoding payload")
+	}
+
+	return resp, nil
+}
+
+// ssoDeviceErrors maps the errors of the poll single sign-on device api
+var ssoDeviceErrors = map[string]error{
+	"authorization_pending": ErrSSOAuthorizationPending,
+	"slow_down":             ErrSSOSlowDown,
+	"access_denied":         ErrSSOAccessDenied,
+	"expired_token":         ErrSSOExpired,
+}
+
+// PollSSODevice requests a session for the device authorization with the given code.
+// It returns ErrSSOAuthorizationPending until the user approves the login.
+func PollSSODevice(ctx context.DnoteCtx, deviceCode string) (SigninResponse, error) {
+	payload := struct {
+		DeviceCode string `json:"device_code"`
+		DeviceName string `json:"device_name"`
+	}{
+		DeviceCode: deviceCode,
+		DeviceName: getDeviceName(),
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return SigninResponse{}, errors.Wrap(err, "marshaling payload")
+	}
+
+	req, err := getReq(ctx, "/v3/sso/device/token", "POST", strings.NewReader(string(b)))
+	if err != nil {
+		return SigninResponse{}, errors.Wrap(err, "getting request")
+	}
+
+	log.Debug("HTTP request: %+v\n", req)
+
+	hc := http.Client{}
+	res, err := hc.Do(req)
+	if err != nil {
+		return SigninResponse{}, errors.Wrap(err, "making http request")
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return SigninResponse{}, errors.Wrap(err, "reading the response body")
+	}
+
+	if res.StatusCode == http.StatusBadRequest {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(body, &e); err == nil {
+			if ssoErr, ok := ssoDeviceErrors[e.Error]; ok {
+				return SigninResponse{}, ssoErr
+			}
+		}
+	}
+	if res.StatusCode >= 400 {
+		return SigninResponse{}, errors.Errorf(`server responded with an error: response %d "%s"`, res.StatusCode, strings.TrimRight(string(body), "\n"))
+	}
+
+	var resp SigninResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return SigninResponse{}, errors.Wrap(err, "decoding payload")
+	}
+
+	return resp, nil
+}
+
+// GetSessionResp is the response from get session api
+type GetSessionResp struct {
+	Email     string `json:"email"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// GetSession gets the information about the session of the session key in the context.
+// It returns ErrInvalidLogin if the session key is not valid.
+func GetSession(ctx context.DnoteCtx) (GetSessionResp, error) {
+	res, err := doAuthorizedReq(ctx, "GET", "/v3/session", "", nil)
+	if res != nil && res.StatusCode == http.StatusUnauthorized {
+		return GetSessionResp{}, ErrInvalidLogin
+	}
+	if err != nil {
+		return GetSessionResp{}, errors.Wrap(err, "making http request")
+	}
+
+	var resp GetSessionResp
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return GetSessionResp{}, errors.Wrap(err, "decoding payload")
+	}
+
+	return resp, nil
+}
+
+// RefreshSession replaces the session in the context with a new session.
+// The current session key can no longer be used once it succeeds.
+func RefreshSession(ctx context.DnoteCtx) (SigninResponse, error) {
+	res, err := doAuthorizedReq(ctx, "POST", "/v3/session/refresh", "", nil)
+	if err != nil {
+		return SigninResponse{}, errors.Wrap(err, "making http request")
 	}
 
 	var resp SigninResponse
@@ -521,3 +887,50 @@ func Signout(ctx context.DnoteCtx, sessionKey string) error {
 
 	return nil
 }
+
+// RespSession is a session in the response from the sessions api
+type RespSession struct {
+	UUID       string    `json:"uuid"`
+	DeviceName string    `json:"device_name"`
+	ClientType string    `json:"client_type"`
+	IPAddress  string    `json:"ip_address"`
+	Current    bool      `json:"current"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// GetSessions gets the active sessions of the user, most recently used first
+func GetSessions(ctx context.DnoteCtx) ([]RespSession, error) {
+	res, err := doAuthorizedReq(ctx, "GET", "/v3/sessions", "", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "making http request")
+	}
+
+	var resp []RespSession
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return nil, errors.Wrap(err, "decoding payload")
+	}
+
+	return resp, nil
+}
+
+// RevokeSession revokes the session of the given uuid
+func RevokeSession(ctx context.DnoteCtx, uuid string) error {
+	endpoint := fmt.Sprintf("/v3/sessions/%s", uuid)
+	if _, err := doAuthorizedReq(ctx, "DELETE", endpoint, "", nil); err != nil {
+		return errors.Wrap(err, "making http request")
+	}
+
+	return nil
+}
+
+// RevokeOtherSessions revokes all sessions of the user except for the session
+// in the context
+func RevokeOtherSessions(ctx context.DnoteCtx) error {
+	if _, err := doAuthorizedReq(ctx, "DELETE", "/v3/sessions", "", nil); err != nil {
+		return errors.Wrap(err, "making http request")
+	}
+
+	return nil
+}
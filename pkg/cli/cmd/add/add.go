@@ -19,7 +19,6 @@
 package add
 
 import (
-	"database/sql"
 	"time"
 
 	"github.com/dnote/dnote/pkg/cli/context"
@@ -42,7 +41,10 @@ var example = `
  dnote add git
 
  * Skip the editor by providing content directly
- dnote add git -c "time is a part of the commit hash"`
+ dnote add git -c "time is a part of the commit hash"
+
+ * Add a note to a nested book, creating the books if they do not exist
+ dnote add infra/k8s -c "kubectl get pods -A"`
 
 func preRun(cmd *cobra.Command, args []string) error {
 	if len(args) != 1 {
@@ -90,7 +92,7 @@ func getContent(ctx context.DnoteCtx) (string, error) {
 func newRun(ctx context.DnoteCtx) infra.RunEFunc {
 	return func(cmd *cobra.Command, args []string) error {
 		bookName := args[0]
-		if err := validate.BookName(bookName); err != nil {
+		if err := validate.BookPath(bookName); err != nil {
 			return errors.Wrap(err, "invalid book name")
 		}
 
@@ -132,22 +134,10 @@ func writeNote(ctx context.DnoteCtx, bookLabel string, content string, ts int64)
 		return 0, errors.Wrap(err, "beginning a transaction")
 	}
 
-	var bookUUID string
-	err = tx.QueryRow("SELECT uuid FROM books WHERE label = ?", bookLabel).Scan(&bookUUID)
-	if err == sql.ErrNoRows {
-		bookUUID, err = utils.GenerateUUID()
-		if err != nil {
-			return 0, errors.Wrap(err, "generating uuid")
-		}
-
-		b := database.NewBook(bookUUID, bookLabel, 0, false, true)
-		err = b.Insert(tx)
-		if err != nil {
-			tx.Rollback()
-			return 0, errors.Wrap(err, "creating the book")
-		}
-	} else if err != nil {
-		return 0, errors.Wrap(err, "finding the book")
+	bookUUID, err := database.FindOrCreateBook(tx, bookLabel)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
 	}
 
 	noteUUID, err := utils.GenerateUUID()
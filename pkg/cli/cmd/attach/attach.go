@@ -0,0 +1,124 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package attach
+
+import (
+	"mime"
+	"os"
+	"path/filepath"
+
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/cmd/share"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var example = `
+  * Attach a file to a note by id
+  dnote attach 3 ./diagram.png
+
+  * See the attachments of a note
+  dnote cat --attachments 3`
+
+// NewCmd returns a new attach command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "attach <note id> <file>",
+		Short:   "Attach a file to a note",
+		Example: example,
+		PreRunE: preRun,
+		RunE:    newRun(ctx),
+	}
+
+	return cmd
+}
+
+func preRun(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return errors.New("Incorrect number of argument")
+	}
+
+	return nil
+}
+
+// getContentType returns the media type of the file at the given path based on
+// its extension
+func getContentType(path string) string {
+	if t := mime.TypeByExtension(filepath.Ext(path)); t != "" {
+		return t
+	}
+
+	return "application/octet-stream"
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := infra.RequireSession(&ctx); err != nil {
+			return err
+		}
+
+		noteInfo, err := share.GetSyncedNote(ctx, args[0])
+		if err != nil {
+			return err
+		}
+
+		path := args[1]
+		f, err := os.Open(path)
+		if err != nil {
+			return errors.Wrap(err, "opening the file")
+		}
+		defer f.Close()
+
+		fi, err := f.Stat()
+		if err != nil {
+			return errors.Wrap(err, "reading the file information")
+		}
+		if fi.IsDir() {
+			return errors.Errorf("%s is a directory", path)
+		}
+
+		name := filepath.Base(path)
+		resp, err := client.CreateAttachment(ctx, noteInfo.UUID, name, getContentType(path), f, fi.Size())
+		if err != nil {
+			return errors.Wrap(err, "uploading the attachment")
+		}
+
+		// Save the metadata without waiting for the next sync. The content is
+		// downloaded when the attachment is viewed.
+		a := database.Attachment{
+			UUID:        resp.UUID,
+			NoteUUID:    noteInfo.UUID,
+			Name:        resp.Name,
+			ContentType: resp.ContentType,
+			Size:        resp.Size,
+			Hash:        resp.Hash,
+		}
+		if err := a.Insert(ctx.DB); err != nil {
+			return errors.Wrap(err, "saving the attachment")
+		}
+
+		log.Successf("attached %s to the note %d\n", resp.Name, noteInfo.RowID)
+
+		return nil
+	}
+}
@@ -19,8 +19,17 @@
 package cat
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strconv"
 
+	"github.com/dnote/dnote/pkg/cli/client"
+
 	"github.com/dnote/dnote/pkg/cli/context"
 	"github.com/dnote/dnote/pkg/cli/database"
 	"github.com/dnote/dnote/pkg/cli/infra"
@@ -40,6 +49,8 @@ var deprecationWarning = `and "view" will replace it in the future version.
  Run "dnote view --help" for more information.
 `
 
+var attachments bool
+
 func preRun(cmd *cobra.Command, args []string) error {
 	if len(args) != 2 {
 		return errors.New("Incorrect number of arguments")
@@ -55,16 +66,26 @@ func NewCmd(ctx context.DnoteCtx) *cobra.Command {
 		Aliases:    []string{"c"},
 		Short:      "See a note",
 		Example:    example,
-		RunE:       NewRun(ctx),
+		RunE:       newRun(ctx),
 		PreRunE:    preRun,
 		Deprecated: deprecationWarning,
 	}
 
+	f := cmd.Flags()
+	f.BoolVarP(&attachments, "attachments", "", false, "list the attachments of the note and download them")
+
 	return cmd
 }
 
-// NewRun returns a new run function
-func NewRun(ctx context.DnoteCtx) infra.RunEFunc {
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		return NewRun(ctx, attachments)(cmd, args)
+	}
+}
+
+// NewRun returns a new run function. If showAttachments is true, the attachments
+// of the note are listed and downloaded if they are not available locally.
+func NewRun(ctx context.DnoteCtx, showAttachments bool) infra.RunEFunc {
 	return func(cmd *cobra.Command, args []string) error {
 		var noteRowIDArg string
 
@@ -89,6 +110,108 @@ func NewRun(ctx context.DnoteCtx) infra.RunEFunc {
 
 		output.NoteInfo(info)
 
+		if showAttachments {
+			if err := printAttachments(ctx, info.UUID); err != nil {
+				return errors.Wrap(err, "printing attachments")
+			}
+		}
+
 		return nil
 	}
 }
+
+// attachmentPath returns the local path of the given attachment. Attachments are
+// stored by their content hash so that identical content is downloaded once.
+func attachmentPath(ctx context.DnoteCtx, a database.Attachment) string {
+	return filepath.Join(ctx.DnoteDir, "attachments", a.Hash, filepath.Base(a.Name))
+}
+
+// formatSize returns a human readable representation of the given number of bytes
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// downloadAttachment downloads the content of the given attachment to the path,
+// verifying that it matches the hash of the attachment.
+func downloadAttachment(ctx context.DnoteCtx, a database.Attachment, path string) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, "creating the directory")
+	}
+
+	body, err := client.GetAttachment(ctx, a.UUID)
+	if err != nil {
+		return errors.Wrap(err, "requesting the content")
+	}
+	defer body.Close()
+
+	tmp, err := ioutil.TempFile(dir, ".download-")
+	if err != nil {
+		return errors.Wrap(err, "creating a temporary file")
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Wrap(err, "writing the content")
+	}
+
+	if hex.EncodeToString(h.Sum(nil)) != a.Hash {
+		return errors.New("the downloaded content does not match the hash")
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrap(err, "moving the content")
+	}
+
+	return nil
+}
+
+func printAttachments(ctx context.DnoteCtx, noteUUID string) error {
+	attachments, err := database.GetNoteAttachments(ctx.DB, noteUUID)
+	if err != nil {
+		return errors.Wrap(err, "getting attachments")
+	}
+
+	if len(attachments) == 0 {
+		log.Infof("no attachments\n")
+		return nil
+	}
+
+	log.Infof("attachments:\n")
+	for _, a := range attachments {
+		path := attachmentPath(ctx, a)
+
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if ctx.SessionKey == "" {
+				log.Plainf("  %s (%s) not downloaded. Please login to download.\n", a.Name, formatSize(a.Size))
+				continue
+			}
+
+			if err := downloadAttachment(ctx, a, path); err != nil {
+				return errors.Wrapf(err, "downloading %s", a.Name)
+			}
+		} else if err != nil {
+			return errors.Wrap(err, "checking the local copy")
+		}
+
+		log.Plainf("  %s (%s) %s\n", a.Name, formatSize(a.Size), path)
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package cat
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+)
+
+func TestFormatSize(t *testing.T) {
+	testCases := []struct {
+		input    int64
+		expected string
+	}{
+		{
+			input:    0,
+			expected: "0 B",
+		},
+		{
+			input:    1023,
+			expected: "1023 B",
+		},
+		{
+			input:    1024,
+			expected: "1.0 KiB",
+		},
+		{
+			input:    1536,
+			expected: "1.5 KiB",
+		},
+		{
+			input:    10 << 20,
+			expected: "10.0 MiB",
+		},
+		{
+			input:    3 << 30,
+			expected: "3.0 GiB",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("input %d", tc.input), func(t *testing.T) {
+			assert.Equal(t, formatSize(tc.input), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestAttachmentPath(t *testing.T) {
+	ctx := context.DnoteCtx{DnoteDir: "/home/user/.dnote"}
+	hash := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	testCases := []struct {
+		name     string
+		expected string
+	}{
+		{
+			name:     "diagram.png",
+			expected: "/home/user/.dnote/attachments/" + hash + "/diagram.png",
+		},
+		{
+			name:     "../../config",
+			expected: "/home/user/.dnote/attachments/" + hash + "/config",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := database.Attachment{Name: tc.name, Hash: hash}
+			assert.Equal(t, attachmentPath(ctx, a), tc.expected, "result mismatch")
+		})
+	}
+}
@@ -72,6 +72,24 @@ func getName(ctx context.DnoteCtx) (string, error) {
 	return c, nil
 }
 
+// getBookPath returns the path to which the book at the given path is moved when it
+// is given the new name. A bare name renames the book in its parent book, and a
+// name with a leading slash moves the book to the top level.
+func getBookPath(bookPath, name string) string {
+	if strings.HasPrefix(name, "/") {
+		return strings.TrimPrefix(name, "/")
+	}
+	if strings.Contains(name, "/") {
+		return name
+	}
+
+	if idx := strings.LastIndex(bookPath, "/"); idx != -1 {
+		return bookPath[:idx+1] + name
+	}
+
+	return name
+}
+
 func runBook(ctx context.DnoteCtx, bookName string) error {
 	err := validateRunBookFlags()
 	if err != nil {
@@ -89,7 +107,8 @@ func runBook(ctx context.DnoteCtx, bookName string) error {
 		return errors.Wrap(err, "getting name")
 	}
 
-	err = validate.BookName(name)
+	path := getBookPath(bookName, name)
+	err = validate.BookPath(path)
 	if err != nil {
 		return errors.Wrap(err, "validating book name")
 	}
@@ -99,7 +118,7 @@ func runBook(ctx context.DnoteCtx, bookName string) error {
 		return errors.Wrap(err, "beginning a transaction")
 	}
 
-	err = database.UpdateBookName(tx, uuid, name)
+	err = database.MoveBook(tx, uuid, path)
 	if err != nil {
 		tx.Rollback()
 		return errors.Wrap(err, "updating the book name")
@@ -0,0 +1,48 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package edit
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+)
+
+func TestGetBookPath(t *testing.T) {
+	testCases := []struct {
+		bookPath string
+		name     string
+		expected string
+	}{
+		{"js", "javascript", "javascript"},
+		{"k8s", "infra/k8s", "infra/k8s"},
+		{"infra/k8s", "kubernetes", "infra/kubernetes"},
+		{"a/b/c", "d", "a/b/d"},
+		{"infra/k8s", "ops/k8s", "ops/k8s"},
+		{"infra/k8s", "/k8s", "k8s"},
+		{"infra/k8s", "/ops/k8s", "ops/k8s"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s to %s", tc.bookPath, tc.name), func(t *testing.T) {
+			assert.Equal(t, getBookPath(tc.bookPath, tc.name), tc.expected, "path mismatch")
+		})
+	}
+}
@@ -46,6 +46,15 @@ var example = `
 
   * Rename a book without launching an editor
   dnote edit javascript -n js
+
+  * Move a book and the books in it into another book
+  dnote edit k8s -n infra/k8s
+
+  * Rename a nested book, keeping it in its parent book
+  dnote edit infra/k8s -n kubernetes
+
+  * Move a nested book to the top level
+  dnote edit infra/k8s -n /k8s
 `
 
 // NewCmd returns a new edit command
@@ -62,7 +71,7 @@ func NewCmd(ctx context.DnoteCtx) *cobra.Command {
 	f := cmd.Flags()
 	f.StringVarP(&contentFlag, "content", "c", "", "a new content for the note")
 	f.StringVarP(&bookFlag, "book", "b", "", "the name of the book to move the note to")
-	f.StringVarP(&nameFlag, "name", "n", "", "a new name for a book")
+	f.StringVarP(&nameFlag, "name", "n", "", "a new name for a book, a path to move it to, or a name with a leading slash to move it to the top level")
 
 	return cmd
 }
@@ -24,6 +24,7 @@ import (
 	"strings"
 
 	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
 	"github.com/dnote/dnote/pkg/cli/infra"
 	"github.com/dnote/dnote/pkg/cli/log"
 	"github.com/pkg/errors"
@@ -133,19 +134,21 @@ func escapePhrase(s string) (string, error) {
 func doQuery(ctx context.DnoteCtx, query, bookName string) (*sql.Rows, error) {
 	db := ctx.DB
 
-	sql := `SELECT
+	sql := database.BookPaths + ` SELECT
 		notes.rowid,
-		books.label AS book_label,
+		book_paths.path AS book_label,
 		snippet(note_fts, 0, '<dnotehl>', '</dnotehl>', '...', 28)
 	FROM note_fts
 	INNER JOIN notes ON notes.rowid = note_fts.rowid
-	INNER JOIN books ON notes.book_uuid = books.uuid
+	INNER JOIN book_paths ON notes.book_uuid = book_paths.uuid
 	WHERE note_fts MATCH ?`
 	args := []interface{}{query}
 
+	// include the books nested in the book
 	if bookName != "" {
-		sql = fmt.Sprintf("%s AND books.label = ?", sql)
-		args = append(args, bookName)
+		prefix := bookName + "/"
+		sql = fmt.Sprintf("%s AND (book_paths.path = ? OR substr(book_paths.path, 1, length(?)) = ?)", sql)
+		args = append(args, bookName, prefix, prefix)
 	}
 
 	rows, err := db.Query(sql, args...)
@@ -0,0 +1,303 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package git
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var example = `
+  * Mirror the notes to a git repository in ~/notes
+  dnote git init ~/notes
+
+  * Commit the changes made in Dnote, and import the commits made by hand
+  dnote git sync`
+
+// ErrNotInitialized is an error for syncing before setting up a repository
+var ErrNotInitialized = errors.New("the notes are not mirrored to a git repository. Please run 'dnote git init <dir>' first")
+
+// NewCmd returns a new git command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "git",
+		Short:   "Mirror the notes to a git repository",
+		Example: example,
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:     "init <dir>",
+		Short:   "Export the notes to a new git repository in a directory",
+		PreRunE: initPreRun,
+		RunE:    newInitRun(ctx),
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "sync",
+		Short: "Sync the notes with the git repository",
+		RunE:  newSyncRun(ctx),
+	})
+
+	return cmd
+}
+
+func initPreRun(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("Incorrect number of argument")
+	}
+
+	return nil
+}
+
+// getSystem returns the system configuration of the given key, or an empty string
+// if it is not set
+func getSystem(db *database.DB, key string) (string, error) {
+	var ret string
+	err := database.GetSystem(db, key, &ret)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return ret, nil
+}
+
+// checkEmpty checks that the given directory has nothing other than a git directory
+func checkEmpty(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", dir)
+	}
+
+	for _, f := range files {
+		if f.Name() != ".git" {
+			return errors.Errorf("%s is not empty", dir)
+		}
+	}
+
+	return nil
+}
+
+// syncResult is the result of a sync with the repository
+type syncResult struct {
+	// Imported is the number of the files changed in git that were imported
+	Imported int
+	// Changes are the changes committed to the repository
+	Changes []noteChange
+}
+
+// syncRepo imports the commits made in the repository since the last sync, and
+// commits the changes made in Dnote. Nothing is saved if any step fails.
+func syncRepo(ctx context.DnoteCtx, repo repository) (syncResult, error) {
+	clean, err := repo.isClean()
+	if err != nil {
+		return syncResult{}, errors.Wrap(err, "checking the status of the repository")
+	}
+	if !clean {
+		return syncResult{}, errors.Errorf("the repository at %s has uncommitted changes. Please commit them first", repo.dir)
+	}
+
+	lastCommit, err := getSystem(ctx.DB, consts.SystemGitCommit)
+	if err != nil {
+		return syncResult{}, errors.Wrap(err, "getting the last synced commit")
+	}
+	head, err := repo.head()
+	if err != nil {
+		return syncResult{}, errors.Wrap(err, "getting the head")
+	}
+
+	tx, err := ctx.DB.Begin()
+	if err != nil {
+		return syncResult{}, errors.Wrap(err, "beginning a transaction")
+	}
+
+	result, err := syncTx(ctx, tx, repo, lastCommit, head)
+	if err != nil {
+		tx.Rollback()
+		if e := repo.discardChanges(); e != nil {
+			log.Debug("discarding the changes in the repository: %s\n", e)
+		}
+
+		return syncResult{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return syncResult{}, errors.Wrap(err, "committing a transaction")
+	}
+
+	return result, nil
+}
+
+func syncTx(ctx context.DnoteCtx, tx *database.DB, repo repository, lastCommit, head string) (syncResult, error) {
+	m, err := newMirror(ctx, tx, repo)
+	if err != nil {
+		return syncResult{}, errors.Wrap(err, "loading the files")
+	}
+
+	var ret syncResult
+
+	if head != "" && head != lastCommit {
+		ret.Imported, err = m.importCommits(lastCommit, head)
+		if err != nil {
+			return syncResult{}, errors.Wrap(err, "importing commits")
+		}
+	}
+
+	ret.Changes, err = m.export()
+	if err != nil {
+		return syncResult{}, errors.Wrap(err, "exporting notes")
+	}
+
+	if len(ret.Changes) > 0 {
+		if _, err := repo.commitAll(commitMessage(ret.Changes)); err != nil {
+			return syncResult{}, errors.Wrap(err, "committing the changes")
+		}
+
+		head, err = repo.head()
+		if err != nil {
+			return syncResult{}, errors.Wrap(err, "getting the head")
+		}
+	}
+
+	if err := database.UpsertSystem(tx, consts.SystemGitCommit, head); err != nil {
+		return syncResult{}, errors.Wrap(err, "saving the last synced commit")
+	}
+
+	return ret, nil
+}
+
+func initRepo(ctx context.DnoteCtx, dir string) (syncResult, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return syncResult{}, errors.Wrapf(err, "resolving %s", dir)
+	}
+
+	current, err := getSystem(ctx.DB, consts.SystemGitDir)
+	if err != nil {
+		return syncResult{}, errors.Wrap(err, "getting the repository")
+	}
+	if current != "" && current != absDir {
+		ok, err := utils.FileExists(current)
+		if err != nil {
+			return syncResult{}, errors.Wrapf(err, "checking %s", current)
+		}
+		if ok {
+			return syncResult{}, errors.Errorf("the notes are already mirrored to %s", current)
+		}
+	}
+
+	if err := os.MkdirAll(absDir, 0755); err != nil {
+		return syncResult{}, errors.Wrapf(err, "creating %s", absDir)
+	}
+	if err := checkEmpty(absDir); err != nil {
+		return syncResult{}, err
+	}
+
+	repo := repository{dir: absDir}
+	ok, err := utils.FileExists(filepath.Join(absDir, ".git"))
+	if err != nil {
+		return syncResult{}, errors.Wrap(err, "checking the git directory")
+	}
+	if !ok {
+		if err := repo.init(); err != nil {
+			return syncResult{}, errors.Wrap(err, "initializing the repository")
+		}
+	}
+
+	head, err := repo.head()
+	if err != nil {
+		return syncResult{}, errors.Wrap(err, "getting the head")
+	}
+
+	tx, err := ctx.DB.Begin()
+	if err != nil {
+		return syncResult{}, errors.Wrap(err, "beginning a transaction")
+	}
+	if _, err := tx.Exec("DELETE FROM git_files"); err != nil {
+		tx.Rollback()
+		return syncResult{}, errors.Wrap(err, "clearing the files")
+	}
+	if err := database.UpsertSystem(tx, consts.SystemGitDir, absDir); err != nil {
+		tx.Rollback()
+		return syncResult{}, errors.Wrap(err, "saving the repository")
+	}
+	if err := database.UpsertSystem(tx, consts.SystemGitCommit, head); err != nil {
+		tx.Rollback()
+		return syncResult{}, errors.Wrap(err, "saving the last synced commit")
+	}
+	if err := tx.Commit(); err != nil {
+		return syncResult{}, errors.Wrap(err, "committing a transaction")
+	}
+
+	return syncRepo(ctx, repo)
+}
+
+func newInitRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		result, err := initRepo(ctx, args[0])
+		if err != nil {
+			return err
+		}
+
+		log.Successf("exported %s to %s\n", pluralizeNotes(len(result.Changes)), args[0])
+
+		return nil
+	}
+}
+
+func newSyncRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		dir, err := getSystem(ctx.DB, consts.SystemGitDir)
+		if err != nil {
+			return errors.Wrap(err, "getting the repository")
+		}
+		if dir == "" {
+			return ErrNotInitialized
+		}
+
+		result, err := syncRepo(ctx, repository{dir: dir})
+		if err != nil {
+			return err
+		}
+
+		if result.Imported == 0 && len(result.Changes) == 0 {
+			log.Success("already up to date\n")
+			return nil
+		}
+		if result.Imported > 0 {
+			log.Successf("imported %d changed files from git\n", result.Imported)
+		}
+		if len(result.Changes) > 0 {
+			log.Successf("committed changes to %s\n", pluralizeNotes(len(result.Changes)))
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,461 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+var gitEnv = map[string]string{
+	"GIT_AUTHOR_NAME":     "Dnote",
+	"GIT_AUTHOR_EMAIL":    "test@dnote.io",
+	"GIT_COMMITTER_NAME":  "Dnote",
+	"GIT_COMMITTER_EMAIL": "test@dnote.io",
+}
+
+// setupRepoDir skips the test if git is not installed, and returns a temporary
+// directory for a repository and a function to clean it up
+func setupRepoDir(t *testing.T) (string, func()) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	for key, val := range gitEnv {
+		os.Setenv(key, val)
+	}
+
+	dir, err := ioutil.TempDir("", "dnote-git")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating a temporary directory"))
+	}
+
+	return dir, func() {
+		for key := range gitEnv {
+			os.Unsetenv(key)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func mustWriteFile(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(errors.Wrap(err, "creating a directory"))
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "writing a file"))
+	}
+}
+
+func mustReadFile(t *testing.T, path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading a file"))
+	}
+
+	return string(b)
+}
+
+func mustRun(t *testing.T, repo repository, args ...string) string {
+	out, err := repo.run(args...)
+	if err != nil {
+		t.Fatal(errors.Wrapf(err, "running git %s", args[0]))
+	}
+
+	return out
+}
+
+// lastCommitMessage returns the message of the last commit in the repository
+func lastCommitMessage(t *testing.T, repo repository) string {
+	return strings.TrimSpace(mustRun(t, repo, "log", "-1", "--format=%B"))
+}
+
+// setupNotes inserts the books js and css, and a note in each of them
+func setupNotes(t *testing.T, db *database.DB) {
+	database.MustExec(t, "inserting js", db, "INSERT INTO books (uuid, label, usn) VALUES (?, ?, ?)", "js-uuid", "js", 1)
+	database.MustExec(t, "inserting css", db, "INSERT INTO books (uuid, label, usn) VALUES (?, ?, ?)", "css-uuid", "css", 2)
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n1-uuid", "js-uuid", 3, "# Array.map\n\nmaps an array", 1541108743, false, false)
+	database.MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n2-uuid", "css-uuid", 4, "flexbox\n", 1541108744, false, false)
+}
+
+type testNote struct {
+	BookUUID string
+	Body     string
+	Deleted  bool
+	Dirty    bool
+}
+
+func mustGetNote(t *testing.T, db *database.DB, uuid string) testNote {
+	var ret testNote
+	database.MustScan(t, "getting the note", db.QueryRow("SELECT book_uuid, body, deleted, dirty FROM notes WHERE uuid = ?", uuid),
+		&ret.BookUUID, &ret.Body, &ret.Deleted, &ret.Dirty)
+
+	return ret
+}
+
+func mustInitRepo(t *testing.T, ctx context.DnoteCtx, dir string) repository {
+	if _, err := initRepo(ctx, dir); err != nil {
+		t.Fatal(errors.Wrap(err, "initializing the repository"))
+	}
+
+	return repository{dir: dir}
+}
+
+func TestFileName(t *testing.T) {
+	testCases := []struct {
+		body     string
+		expected string
+	}{
+		{
+			body:     "# Array.map\n\nmaps an array",
+			expected: "array-map.md",
+		},
+		{
+			body:     "  kubectl get pods -n <namespace>  ",
+			expected: "kubectl-get-pods-n-namespace.md",
+		},
+		{
+			body:     "\n\nÜber café\nfoo",
+			expected: "über-café.md",
+		},
+		{
+			body:     "",
+			expected: "note.md",
+		},
+		{
+			body:     "!!!",
+			expected: "note.md",
+		},
+		{
+			body:     strings.Repeat("ab ", 30),
+			expected: "ab-ab-ab-ab-ab-ab-ab-ab-ab-ab-ab-ab-ab-ab-ab-ab-ab.md",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.body, func(t *testing.T) {
+			assert.Equal(t, fileName(tc.body), tc.expected, "file name mismatch")
+		})
+	}
+}
+
+func TestCommitMessage(t *testing.T) {
+	testCases := []struct {
+		changes  []noteChange
+		expected string
+	}{
+		{
+			changes:  []noteChange{{Kind: noteAdded, Title: "foo", Book: "js"}},
+			expected: `Add "foo" to js`,
+		},
+		{
+			changes:  []noteChange{{Kind: noteEdited, Title: "foo", Book: "js"}},
+			expected: `Edit "foo" in js`,
+		},
+		{
+			changes:  []noteChange{{Kind: noteMoved, Title: "foo", Book: "js/react", FromBook: "js"}},
+			expected: `Move "foo" from js to js/react`,
+		},
+		{
+			changes:  []noteChange{{Kind: noteRemoved, Title: "foo", Book: "js"}},
+			expected: `Remove "foo" from js`,
+		},
+		{
+			changes: []noteChange{
+				{Kind: noteEdited, Title: "foo", Book: "js"},
+				{Kind: noteAdded, Title: "bar", Book: "css"},
+				{Kind: noteAdded, Title: "baz", Book: "css"},
+			},
+			expected: `Add 2 notes, edit 1 note
+
+- Edit "foo" in js
+- Add "bar" to css
+- Add "baz" to css
+`,
+		},
+	}
+
+	for idx, tc := range testCases {
+		assert.Equal(t, commitMessage(tc.changes), tc.expected, fmt.Sprintf("result mismatch for test case %d", idx))
+	}
+}
+
+func TestParseChanges(t *testing.T) {
+	out := "M\x00js/foo.md\x00R087\x00js/bar.md\x00css/bar.md\x00A\x00css/baz.md\x00D\x00js/qux.md\x00C100\x00js/foo.md\x00js/foo-2.md\x00"
+
+	got, err := parseChanges(out)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "parsing"))
+	}
+
+	assert.DeepEqual(t, got, []fileChange{
+		{Type: changeModified, OldPath: "js/foo.md", Path: "js/foo.md"},
+		{Type: changeRenamed, OldPath: "js/bar.md", Path: "css/bar.md"},
+		{Type: changeAdded, OldPath: "css/baz.md", Path: "css/baz.md"},
+		{Type: changeDeleted, OldPath: "js/qux.md", Path: "js/qux.md"},
+		{Type: changeAdded, OldPath: "js/foo-2.md", Path: "js/foo-2.md"},
+	}, "changes mismatch")
+}
+
+func TestInitRepo(t *testing.T) {
+	dir, cleanup := setupRepoDir(t)
+	defer cleanup()
+
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupNotes(t, ctx.DB)
+	database.MustExec(t, "inserting a deleted note", ctx.DB, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n3-uuid", "js-uuid", 5, "", 1541108745, true, false)
+
+	// execute
+	result, err := initRepo(ctx, dir)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// test
+	repo := repository{dir: dir}
+	assert.Equal(t, len(result.Changes), 2, "change count mismatch")
+	assert.Equal(t, mustReadFile(t, filepath.Join(dir, "js", "array-map.md")), "# Array.map\n\nmaps an array\n", "js file mismatch")
+	assert.Equal(t, mustReadFile(t, filepath.Join(dir, "css", "flexbox.md")), "flexbox\n", "css file mismatch")
+	assert.Equal(t, lastCommitMessage(t, repo), `Add 2 notes
+
+- Add "Array.map" to js
+- Add "flexbox" to css`, "commit message mismatch")
+
+	var fileCount int
+	database.MustScan(t, "counting files", ctx.DB.QueryRow("SELECT count(*) FROM git_files"), &fileCount)
+	assert.Equal(t, fileCount, 2, "file count mismatch")
+
+	var gitDir, gitCommit string
+	database.MustScan(t, "getting the repository", ctx.DB.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemGitDir), &gitDir)
+	database.MustScan(t, "getting the commit", ctx.DB.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemGitCommit), &gitCommit)
+	assert.Equal(t, gitDir, dir, "repository mismatch")
+	assert.Equal(t, gitCommit, strings.TrimSpace(mustRun(t, repo, "rev-parse", "HEAD")), "commit mismatch")
+
+	n1 := mustGetNote(t, ctx.DB, "n1-uuid")
+	assert.Equal(t, n1.Dirty, false, "n1 should not be dirty")
+}
+
+func TestInitRepo_notEmpty(t *testing.T) {
+	dir, cleanup := setupRepoDir(t)
+	defer cleanup()
+
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	mustWriteFile(t, filepath.Join(dir, "README.md"), "foo\n")
+
+	_, err := initRepo(ctx, dir)
+	assert.NotEqual(t, err, nil, "error mismatch")
+
+	var count int
+	database.MustScan(t, "counting system", ctx.DB.QueryRow("SELECT count(*) FROM system WHERE key = ?", consts.SystemGitDir), &count)
+	assert.Equal(t, count, 0, "the repository should not be saved")
+}
+
+func TestSyncRepo_export(t *testing.T) {
+	dir, cleanup := setupRepoDir(t)
+	defer cleanup()
+
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupNotes(t, ctx.DB)
+	repo := mustInitRepo(t, ctx, dir)
+
+	t.Run("edit", func(t *testing.T) {
+		database.MustExec(t, "editing n1", ctx.DB, "UPDATE notes SET body = ?, dirty = ? WHERE uuid = ?", "# Array.map\n\nreturns a new array", true, "n1-uuid")
+
+		result, err := syncRepo(ctx, repo)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "executing"))
+		}
+
+		assert.Equal(t, result.Imported, 0, "imported count mismatch")
+		assert.Equal(t, mustReadFile(t, filepath.Join(dir, "js", "array-map.md")), "# Array.map\n\nreturns a new array\n", "file mismatch")
+		assert.Equal(t, lastCommitMessage(t, repo), `Edit "Array.map" in js`, "commit message mismatch")
+	})
+
+	t.Run("move and remove", func(t *testing.T) {
+		database.MustExec(t, "moving n1", ctx.DB, "UPDATE notes SET book_uuid = ? WHERE uuid = ?", "css-uuid", "n1-uuid")
+		database.MustExec(t, "removing n2", ctx.DB, "UPDATE notes SET deleted = ?, body = ? WHERE uuid = ?", true, "", "n2-uuid")
+
+		if _, err := syncRepo(ctx, repo); err != nil {
+			t.Fatal(errors.Wrap(err, "executing"))
+		}
+
+		ok, err := repo.isClean()
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "checking the status"))
+		}
+		assert.Equal(t, ok, true, "the repository should be clean")
+		assert.Equal(t, mustReadFile(t, filepath.Join(dir, "css", "array-map.md")), "# Array.map\n\nreturns a new array\n", "file mismatch")
+		assert.Equal(t, lastCommitMessage(t, repo), `Move 1 note, remove 1 note
+
+- Remove "flexbox" from css
+- Move "Array.map" from js to css`, "commit message mismatch")
+
+		if _, err := os.Stat(filepath.Join(dir, "js")); !os.IsNotExist(err) {
+			t.Error("the empty book folder should be removed")
+		}
+	})
+
+	t.Run("up to date", func(t *testing.T) {
+		head := strings.TrimSpace(mustRun(t, repo, "rev-parse", "HEAD"))
+
+		result, err := syncRepo(ctx, repo)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "executing"))
+		}
+
+		assert.Equal(t, len(result.Changes), 0, "change count mismatch")
+		assert.Equal(t, strings.TrimSpace(mustRun(t, repo, "rev-parse", "HEAD")), head, "head mismatch")
+	})
+}
+
+func TestSyncRepo_import(t *testing.T) {
+	dir, cleanup := setupRepoDir(t)
+	defer cleanup()
+
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupNotes(t, ctx.DB)
+	database.MustExec(t, "inserting n3", ctx.DB, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n3-uuid", "js-uuid", 5, "closures", 1541108745, false, false)
+	repo := mustInitRepo(t, ctx, dir)
+
+	// execute
+	mustWriteFile(t, filepath.Join(dir, "js", "array-map.md"), "# Array.map\n\nedited in git\n")
+	mustWriteFile(t, filepath.Join(dir, "go", "concurrency", "channels.md"), "channels\n")
+	mustWriteFile(t, filepath.Join(dir, "README.md"), "not a note in a book\n")
+	mustRun(t, repo, "mv", "css/flexbox.md", "js/flexbox.md")
+	mustRun(t, repo, "rm", "-q", "js/closures.md")
+	mustRun(t, repo, "add", "-A")
+	mustRun(t, repo, "commit", "-q", "-m", "Edit by hand")
+
+	result, err := syncRepo(ctx, repo)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// test
+	assert.Equal(t, result.Imported, 4, "imported count mismatch")
+	assert.Equal(t, len(result.Changes), 0, "change count mismatch")
+
+	n1 := mustGetNote(t, ctx.DB, "n1-uuid")
+	assert.Equal(t, n1.Body, "# Array.map\n\nedited in git", "n1 body mismatch")
+	assert.Equal(t, n1.BookUUID, "js-uuid", "n1 book mismatch")
+	assert.Equal(t, n1.Dirty, true, "n1 dirty mismatch")
+
+	n2 := mustGetNote(t, ctx.DB, "n2-uuid")
+	assert.Equal(t, n2.Body, "flexbox\n", "n2 body mismatch")
+	assert.Equal(t, n2.BookUUID, "js-uuid", "n2 book mismatch")
+	assert.Equal(t, n2.Dirty, true, "n2 dirty mismatch")
+
+	n3 := mustGetNote(t, ctx.DB, "n3-uuid")
+	assert.Equal(t, n3.Body, "", "n3 body mismatch")
+	assert.Equal(t, n3.Deleted, true, "n3 deleted mismatch")
+	assert.Equal(t, n3.Dirty, true, "n3 dirty mismatch")
+
+	var newNote testNote
+	var bookLabel string
+	var bookDirty bool
+	database.MustScan(t, "getting the new note", ctx.DB.QueryRow(`SELECT notes.body, notes.dirty, books.label, books.dirty
+		FROM notes INNER JOIN books ON books.uuid = notes.book_uuid
+		WHERE notes.uuid NOT IN (?, ?, ?)`, "n1-uuid", "n2-uuid", "n3-uuid"),
+		&newNote.Body, &newNote.Dirty, &bookLabel, &bookDirty)
+	assert.Equal(t, newNote.Body, "channels", "new note body mismatch")
+	assert.Equal(t, newNote.Dirty, true, "new note dirty mismatch")
+	assert.Equal(t, bookLabel, "concurrency", "new book mismatch")
+	assert.Equal(t, bookDirty, true, "new book dirty mismatch")
+
+	var fileCount int
+	database.MustScan(t, "counting files", ctx.DB.QueryRow("SELECT count(*) FROM git_files"), &fileCount)
+	assert.Equal(t, fileCount, 3, "file count mismatch")
+
+	var gitCommit string
+	database.MustScan(t, "getting the commit", ctx.DB.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemGitCommit), &gitCommit)
+	assert.Equal(t, gitCommit, strings.TrimSpace(mustRun(t, repo, "rev-parse", "HEAD")), "commit mismatch")
+}
+
+func TestSyncRepo_conflict(t *testing.T) {
+	dir, cleanup := setupRepoDir(t)
+	defer cleanup()
+
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupNotes(t, ctx.DB)
+	repo := mustInitRepo(t, ctx, dir)
+
+	database.MustExec(t, "editing n2", ctx.DB, "UPDATE notes SET body = ?, dirty = ? WHERE uuid = ?", "flexbox\nedited in dnote", true, "n2-uuid")
+	mustWriteFile(t, filepath.Join(dir, "css", "flexbox.md"), "flexbox\nedited in git\n")
+	mustRun(t, repo, "commit", "-q", "-a", "-m", "Edit by hand")
+
+	// execute
+	if _, err := syncRepo(ctx, repo); err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// test
+	expected := `<<<<<<< Dnote
+flexbox
+edited in dnote
+=======
+flexbox
+edited in git
+>>>>>>> Git`
+
+	n2 := mustGetNote(t, ctx.DB, "n2-uuid")
+	assert.Equal(t, n2.Body, expected, "n2 body mismatch")
+	assert.Equal(t, mustReadFile(t, filepath.Join(dir, "css", "flexbox.md")), expected+"\n", "file mismatch")
+	assert.Equal(t, lastCommitMessage(t, repo), `Edit "<<<<<<< Dnote" in css`, "commit message mismatch")
+}
+
+func TestSyncRepo_uncommitted(t *testing.T) {
+	dir, cleanup := setupRepoDir(t)
+	defer cleanup()
+
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupNotes(t, ctx.DB)
+	repo := mustInitRepo(t, ctx, dir)
+
+	database.MustExec(t, "editing n1", ctx.DB, "UPDATE notes SET body = ?, dirty = ? WHERE uuid = ?", "edited", true, "n1-uuid")
+	mustWriteFile(t, filepath.Join(dir, "css", "flexbox.md"), "uncommitted\n")
+
+	_, err := syncRepo(ctx, repo)
+	assert.NotEqual(t, err, nil, "error mismatch")
+
+	assert.Equal(t, mustReadFile(t, filepath.Join(dir, "css", "flexbox.md")), "uncommitted\n", "the uncommitted change should be kept")
+	assert.Equal(t, mustReadFile(t, filepath.Join(dir, "js", "array-map.md")), "# Array.map\n\nmaps an array\n", "the note should not be exported")
+}
@@ -0,0 +1,639 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package git
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/dnote/dnote/pkg/cli/validate"
+	"github.com/dnote/dnote/pkg/wikilink"
+	"github.com/pkg/errors"
+)
+
+const (
+	conflictLabelDnote  = "<<<<<<< Dnote\n"
+	conflictLabelDivide = "=======\n"
+	conflictLabelGit    = ">>>>>>> Git\n"
+)
+
+// maxFileNameLength is the maximum length of the name of a file, without the extension
+const maxFileNameLength = 50
+
+// maxTitleLength is the maximum length of the title of a note in a commit message
+const maxTitleLength = 50
+
+// fileContent returns the content of the file of a note with the given body
+func fileContent(body string) string {
+	return strings.TrimRight(body, "\n") + "\n"
+}
+
+// noteBody returns the body of a note with the given file content
+func noteBody(content string) string {
+	return strings.TrimRight(content, "\n")
+}
+
+// isNoteFile checks if the file at the given path in the repository is a note
+func isNoteFile(p string) bool {
+	return strings.HasSuffix(p, ".md")
+}
+
+// fileName returns the name of the file of a note with the given body, made of its title
+func fileName(body string) string {
+	var b strings.Builder
+	dash := false
+
+	for _, r := range strings.ToLower(wikilink.Title(body)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteRune('-')
+			dash = true
+		}
+	}
+
+	name := strings.TrimRight(b.String(), "-")
+	if runes := []rune(name); len(runes) > maxFileNameLength {
+		name = strings.TrimRight(string(runes[:maxFileNameLength]), "-")
+	}
+	if name == "" {
+		name = "note"
+	}
+
+	return name + ".md"
+}
+
+// isSafeDir checks if the given book path can be used as a directory in the
+// repository without escaping it or touching the git directory
+func isSafeDir(bookPath string) bool {
+	for _, name := range strings.Split(bookPath, "/") {
+		if name == "" || name == "." || name == ".." || name == ".git" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// shortTitle returns the title of a note with the given body for a commit message
+func shortTitle(body string) string {
+	title := wikilink.Title(body)
+	if runes := []rune(title); len(runes) > maxTitleLength {
+		title = string(runes[:maxTitleLength]) + "..."
+	}
+	if title == "" {
+		title = "untitled"
+	}
+
+	return title
+}
+
+// reportConflict returns the body of a note that was changed both in Dnote and in git
+func reportConflict(dnoteBody, gitBody string) string {
+	var b strings.Builder
+
+	b.WriteString(conflictLabelDnote)
+	b.WriteString(fileContent(dnoteBody))
+	b.WriteString(conflictLabelDivide)
+	b.WriteString(fileContent(gitBody))
+	b.WriteString(conflictLabelGit)
+
+	return noteBody(b.String())
+}
+
+const (
+	noteAdded   = "Add"
+	noteEdited  = "Edit"
+	noteMoved   = "Move"
+	noteRemoved = "Remove"
+)
+
+// noteChange is a change to a note committed to the repository
+type noteChange struct {
+	Kind  string
+	Title string
+	Book  string
+	// FromBook is the book from which the note moved
+	FromBook string
+}
+
+func (c noteChange) String() string {
+	switch c.Kind {
+	case noteAdded:
+		return fmt.Sprintf("Add \"%s\" to %s", c.Title, c.Book)
+	case noteEdited:
+		return fmt.Sprintf("Edit \"%s\" in %s", c.Title, c.Book)
+	case noteMoved:
+		return fmt.Sprintf("Move \"%s\" from %s to %s", c.Title, c.FromBook, c.Book)
+	default:
+		return fmt.Sprintf("Remove \"%s\" from %s", c.Title, c.Book)
+	}
+}
+
+func pluralizeNotes(count int) string {
+	if count == 1 {
+		return "1 note"
+	}
+
+	return fmt.Sprintf("%d notes", count)
+}
+
+// commitMessage returns the message of a commit of the given changes. A commit of
+// several changes is summarized in the subject and has each change in the body.
+func commitMessage(changes []noteChange) string {
+	if len(changes) == 1 {
+		return changes[0].String()
+	}
+
+	counts := map[string]int{}
+	for _, c := range changes {
+		counts[c.Kind]++
+	}
+
+	var summary []string
+	for _, kind := range []string{noteAdded, noteEdited, noteMoved, noteRemoved} {
+		if counts[kind] == 0 {
+			continue
+		}
+
+		verb := strings.ToLower(kind)
+		if len(summary) == 0 {
+			verb = kind
+		}
+		summary = append(summary, fmt.Sprintf("%s %s", verb, pluralizeNotes(counts[kind])))
+	}
+
+	var b strings.Builder
+	b.WriteString(strings.Join(summary, ", "))
+	b.WriteString("\n\n")
+	for _, c := range changes {
+		b.WriteString("- ")
+		b.WriteString(c.String())
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// mirroredNote is a note with the path of its book
+type mirroredNote struct {
+	RowID int
+	UUID  string
+	Book  string
+	Body  string
+}
+
+// mirror keeps the notes in the database and the files in the repository in sync
+type mirror struct {
+	ctx  context.DnoteCtx
+	tx   *database.DB
+	repo repository
+	// files maps the path of each file in the repository to the uuid of its note
+	files map[string]string
+}
+
+func newMirror(ctx context.DnoteCtx, tx *database.DB, repo repository) (*mirror, error) {
+	rows, err := tx.Query("SELECT path, note_uuid FROM git_files")
+	if err != nil {
+		return nil, errors.Wrap(err, "querying files")
+	}
+	defer rows.Close()
+
+	files := map[string]string{}
+	for rows.Next() {
+		var p, uuid string
+		if err := rows.Scan(&p, &uuid); err != nil {
+			return nil, errors.Wrap(err, "scanning a row for file")
+		}
+
+		files[p] = uuid
+	}
+
+	return &mirror{
+		ctx:   ctx,
+		tx:    tx,
+		repo:  repo,
+		files: files,
+	}, nil
+}
+
+// abs returns the path on the disk of the file at the given path in the repository
+func (m *mirror) abs(p string) string {
+	return filepath.Join(m.repo.dir, filepath.FromSlash(p))
+}
+
+// readFile returns the content of the file at the given path in the repository.
+// It returns an empty string if the file does not exist.
+func (m *mirror) readFile(p string) (string, error) {
+	b, err := ioutil.ReadFile(m.abs(p))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", errors.Wrapf(err, "reading %s", p)
+	}
+
+	return string(b), nil
+}
+
+func (m *mirror) writeFile(p, content string) error {
+	if err := os.MkdirAll(filepath.Dir(m.abs(p)), 0755); err != nil {
+		return errors.Wrapf(err, "creating the directory of %s", p)
+	}
+	if err := ioutil.WriteFile(m.abs(p), []byte(content), 0644); err != nil {
+		return errors.Wrapf(err, "writing %s", p)
+	}
+
+	return nil
+}
+
+// removeEmptyDirs removes the given directory and its parents as long as they are empty
+func (m *mirror) removeEmptyDirs(dir string) {
+	for dir != "." && dir != "/" {
+		if err := os.Remove(m.abs(dir)); err != nil {
+			return
+		}
+
+		dir = path.Dir(dir)
+	}
+}
+
+// uniquePath returns a path for a file with the given name in the given directory
+// that no other file has
+func (m *mirror) uniquePath(dir, name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	for i := 1; ; i++ {
+		p := path.Join(dir, name)
+		if i > 1 {
+			p = path.Join(dir, fmt.Sprintf("%s-%d%s", base, i, ext))
+		}
+
+		if _, ok := m.files[p]; ok {
+			continue
+		}
+		if ok, err := utils.FileExists(m.abs(p)); err == nil && ok {
+			continue
+		}
+
+		return p
+	}
+}
+
+// setFile maps the file at the given path to the note with the given uuid
+func (m *mirror) setFile(p, uuid string) error {
+	if _, err := m.tx.Exec("INSERT OR REPLACE INTO git_files (path, note_uuid) VALUES (?, ?)", p, uuid); err != nil {
+		return errors.Wrapf(err, "saving the file %s", p)
+	}
+
+	m.files[p] = uuid
+	return nil
+}
+
+// unsetFile removes the mapping of the file at the given path
+func (m *mirror) unsetFile(p string) error {
+	if _, err := m.tx.Exec("DELETE FROM git_files WHERE path = ?", p); err != nil {
+		return errors.Wrapf(err, "deleting the file %s", p)
+	}
+
+	delete(m.files, p)
+	return nil
+}
+
+// sortedPaths returns the paths of the mapped files in order
+func (m *mirror) sortedPaths() []string {
+	ret := []string{}
+	for p := range m.files {
+		ret = append(ret, p)
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
+// getNote returns the active note with the given uuid. The second return value
+// is false if no such note exists.
+func (m *mirror) getNote(uuid string) (mirroredNote, bool, error) {
+	var ret mirroredNote
+
+	err := m.tx.QueryRow(database.BookPaths+` SELECT notes.rowid, notes.uuid, book_paths.path, notes.body
+			FROM notes
+			INNER JOIN book_paths ON book_paths.uuid = notes.book_uuid
+			WHERE notes.uuid = ? AND notes.deleted = false`, uuid).
+		Scan(&ret.RowID, &ret.UUID, &ret.Book, &ret.Body)
+	if err == sql.ErrNoRows {
+		return ret, false, nil
+	} else if err != nil {
+		return ret, false, errors.Wrap(err, "finding the note")
+	}
+
+	return ret, true, nil
+}
+
+// getNotes returns all active notes, oldest first
+func (m *mirror) getNotes() ([]mirroredNote, error) {
+	rows, err := m.tx.Query(database.BookPaths + ` SELECT notes.rowid, notes.uuid, book_paths.path, notes.body
+			FROM notes
+			INNER JOIN book_paths ON book_paths.uuid = notes.book_uuid
+			WHERE notes.deleted = false
+			ORDER BY notes.added_on ASC, notes.rowid ASC`)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying notes")
+	}
+	defer rows.Close()
+
+	ret := []mirroredNote{}
+	for rows.Next() {
+		var n mirroredNote
+		if err := rows.Scan(&n.RowID, &n.UUID, &n.Book, &n.Body); err != nil {
+			return nil, errors.Wrap(err, "scanning a row for note")
+		}
+
+		ret = append(ret, n)
+	}
+
+	return ret, nil
+}
+
+// removeNote marks the note with the given uuid as deleted
+func (m *mirror) removeNote(uuid string) error {
+	if _, err := m.tx.Exec("UPDATE notes SET deleted = ?, dirty = ?, body = ? WHERE uuid = ? AND deleted = ?", true, true, "", uuid, false); err != nil {
+		return errors.Wrap(err, "removing the note")
+	}
+	if err := database.DeleteNoteLinks(m.tx, uuid); err != nil {
+		return errors.Wrap(err, "removing the links of the note")
+	}
+
+	return nil
+}
+
+// importRemoval removes the note of the file removed from the given path
+func (m *mirror) importRemoval(p string) error {
+	uuid, ok := m.files[p]
+	if !ok {
+		return nil
+	}
+
+	if err := m.removeNote(uuid); err != nil {
+		return err
+	}
+	if err := m.unsetFile(p); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// importFile imports the file at the given path, which was at oldPath in the
+// commit of the last sync. A file in a different book folder moves its note to
+// the book. A change to the note in both Dnote and git is reported as a conflict.
+// It returns false if the file was skipped.
+func (m *mirror) importFile(lastCommit, oldPath, p string) (bool, error) {
+	content, err := m.readFile(p)
+	if err != nil {
+		return false, err
+	}
+	body := noteBody(content)
+	book := path.Dir(p)
+
+	if book == "." || validate.BookPath(book) != nil {
+		log.Warnf("skipping %s: a note must be in the folder of a valid book\n", p)
+		return false, m.unsetFile(oldPath)
+	}
+	if strings.TrimSpace(body) == "" {
+		if err := m.importRemoval(oldPath); err != nil {
+			return false, err
+		}
+		return true, m.unsetFile(p)
+	}
+
+	note, ok, err := m.getNote(m.files[oldPath])
+	if err != nil {
+		return false, err
+	}
+	if err := m.unsetFile(oldPath); err != nil {
+		return false, err
+	}
+
+	bookUUID, err := database.FindOrCreateBook(m.tx, book)
+	if err != nil {
+		return false, errors.Wrapf(err, "finding the book %s", book)
+	}
+
+	if !ok {
+		uuid, err := utils.GenerateUUID()
+		if err != nil {
+			return false, errors.Wrap(err, "generating uuid")
+		}
+
+		n := database.NewNote(uuid, bookUUID, body, m.ctx.Clock.Now().UnixNano(), 0, 0, false, false, true)
+		if err := n.Insert(m.tx); err != nil {
+			return false, errors.Wrap(err, "creating the note")
+		}
+
+		return true, m.setFile(p, uuid)
+	}
+
+	if err := m.setFile(p, note.UUID); err != nil {
+		return false, err
+	}
+
+	if note.Book != book {
+		if err := database.UpdateNoteBook(m.tx, m.ctx.Clock, note.RowID, bookUUID); err != nil {
+			return false, errors.Wrap(err, "moving the note")
+		}
+	}
+
+	var prev string
+	if lastCommit != "" {
+		// the file might not exist in the commit if it was mapped by an export
+		// whose commit failed
+		prev, _ = m.repo.show(lastCommit, oldPath)
+	}
+	if content == prev {
+		return true, nil
+	}
+
+	newBody := body
+	if fileContent(note.Body) != prev && fileContent(note.Body) != content {
+		log.Warnf("%s was changed both in dnote and in git. Please resolve the conflict\n", p)
+		newBody = reportConflict(note.Body, body)
+	}
+	if newBody == note.Body {
+		return true, nil
+	}
+
+	if err := database.UpdateNoteContent(m.tx, m.ctx.Clock, note.RowID, newBody); err != nil {
+		return false, errors.Wrap(err, "updating the note")
+	}
+
+	return true, nil
+}
+
+// importCommits imports the changes to the notes in the commits between the given
+// commits into the database, and marks the changed notes dirty. It returns the
+// number of the changed files.
+func (m *mirror) importCommits(from, to string) (int, error) {
+	changes, err := m.repo.changes(from, to)
+	if err != nil {
+		return 0, errors.Wrap(err, "getting the changes")
+	}
+
+	count := 0
+	for _, c := range changes {
+		oldIsNote := isNoteFile(c.OldPath)
+		isNote := isNoteFile(c.Path)
+		if !oldIsNote && !isNote {
+			continue
+		}
+
+		imported := true
+		switch {
+		case c.Type == changeDeleted || !isNote:
+			err = m.importRemoval(c.OldPath)
+		case c.Type == changeRenamed && !oldIsNote:
+			imported, err = m.importFile(from, c.Path, c.Path)
+		default:
+			imported, err = m.importFile(from, c.OldPath, c.Path)
+		}
+		if err != nil {
+			return count, errors.Wrapf(err, "importing %s", c.Path)
+		}
+		if imported {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// export writes the notes in the database to the files in the repository, and
+// returns the changes made to them
+func (m *mirror) export() ([]noteChange, error) {
+	notes, err := m.getNotes()
+	if err != nil {
+		return nil, err
+	}
+
+	active := map[string]bool{}
+	for _, n := range notes {
+		active[n.UUID] = true
+	}
+
+	changes := []noteChange{}
+
+	// remove files first so that the notes added in their place can use their paths
+	pathOf := map[string]string{}
+	for _, p := range m.sortedPaths() {
+		uuid := m.files[p]
+		if active[uuid] {
+			if _, ok := pathOf[uuid]; !ok {
+				pathOf[uuid] = p
+				continue
+			}
+		}
+
+		content, err := m.readFile(p)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Remove(m.abs(p)); err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "removing %s", p)
+		}
+		m.removeEmptyDirs(path.Dir(p))
+		if err := m.unsetFile(p); err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, noteChange{Kind: noteRemoved, Title: shortTitle(content), Book: path.Dir(p)})
+	}
+
+	for _, n := range notes {
+		if !isSafeDir(n.Book) {
+			log.Warnf("skipping a note in %s: the book cannot be a folder\n", n.Book)
+			continue
+		}
+
+		content := fileContent(n.Body)
+
+		p, ok := pathOf[n.UUID]
+		if !ok {
+			p = m.uniquePath(n.Book, fileName(n.Body))
+			if err := m.writeFile(p, content); err != nil {
+				return nil, err
+			}
+			if err := m.setFile(p, n.UUID); err != nil {
+				return nil, err
+			}
+
+			changes = append(changes, noteChange{Kind: noteAdded, Title: shortTitle(n.Body), Book: n.Book})
+			continue
+		}
+
+		moved := false
+		if dir := path.Dir(p); dir != n.Book {
+			newPath := m.uniquePath(n.Book, path.Base(p))
+			if err := os.MkdirAll(filepath.Dir(m.abs(newPath)), 0755); err != nil {
+				return nil, errors.Wrapf(err, "creating the directory of %s", newPath)
+			}
+			if err := os.Rename(m.abs(p), m.abs(newPath)); err != nil && !os.IsNotExist(err) {
+				return nil, errors.Wrapf(err, "moving %s", p)
+			}
+			m.removeEmptyDirs(dir)
+			if err := m.unsetFile(p); err != nil {
+				return nil, err
+			}
+			if err := m.setFile(newPath, n.UUID); err != nil {
+				return nil, err
+			}
+
+			changes = append(changes, noteChange{Kind: noteMoved, Title: shortTitle(n.Body), Book: n.Book, FromBook: dir})
+			p = newPath
+			moved = true
+		}
+
+		current, err := m.readFile(p)
+		if err != nil {
+			return nil, err
+		}
+		if current == content {
+			continue
+		}
+		if err := m.writeFile(p, content); err != nil {
+			return nil, err
+		}
+		if !moved {
+			changes = append(changes, noteChange{Kind: noteEdited, Title: shortTitle(n.Body), Book: n.Book})
+		}
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,217 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package git
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// emptyTree is the hash of the tree without any file, from which the changes
+// of the first commit are computed
+const emptyTree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// repository runs git commands in the repository in a directory
+type repository struct {
+	dir string
+}
+
+// run runs git with the given arguments and returns the standard output
+func (r repository) run(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", errors.Wrapf(err, "running git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
+
+// init creates an empty repository
+func (r repository) init() error {
+	if _, err := r.run("init", "-q"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// head returns the hash of the current commit. It returns an empty string if
+// the repository has no commit.
+func (r repository) head() (string, error) {
+	out, err := r.run("rev-parse", "--verify", "-q", "HEAD")
+	if err != nil {
+		if exitErr, ok := errors.Cause(err).(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return strings.TrimSpace(out), nil
+}
+
+// isClean checks if the working tree has no uncommitted change or untracked file
+func (r repository) isClean() (bool, error) {
+	out, err := r.run("status", "--porcelain")
+	if err != nil {
+		return false, err
+	}
+
+	return out == "", nil
+}
+
+// show returns the content of the file at the given path in the given commit
+func (r repository) show(commit, path string) (string, error) {
+	return r.run("show", commit+":"+path)
+}
+
+// changeType is the type of a change to a file between two commits
+type changeType byte
+
+const (
+	changeAdded    changeType = 'A'
+	changeModified changeType = 'M'
+	changeDeleted  changeType = 'D'
+	changeRenamed  changeType = 'R'
+)
+
+// fileChange is a change to a file between two commits. OldPath is the path
+// before the change, and is different from Path only for a rename.
+type fileChange struct {
+	Type    changeType
+	OldPath string
+	Path    string
+}
+
+// parseChanges parses the output of git diff --name-status -z
+func parseChanges(out string) ([]fileChange, error) {
+	ret := []fileChange{}
+
+	fields := strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+	if len(fields) == 1 && fields[0] == "" {
+		return ret, nil
+	}
+
+	for i := 0; i < len(fields); {
+		status := fields[i]
+		if status == "" {
+			return nil, errors.New("empty status")
+		}
+
+		switch status[0] {
+		case 'R', 'C':
+			if i+2 >= len(fields) {
+				return nil, errors.Errorf("missing paths for status %s", status)
+			}
+
+			c := fileChange{Type: changeRenamed, OldPath: fields[i+1], Path: fields[i+2]}
+			// a copy leaves the original file in place
+			if status[0] == 'C' {
+				c = fileChange{Type: changeAdded, OldPath: fields[i+2], Path: fields[i+2]}
+			}
+
+			ret = append(ret, c)
+			i += 3
+		default:
+			if i+1 >= len(fields) {
+				return nil, errors.Errorf("missing path for status %s", status)
+			}
+
+			t := changeType(status[0])
+			// a change of the file mode or type is regarded as a modification
+			if t != changeAdded && t != changeDeleted {
+				t = changeModified
+			}
+
+			ret = append(ret, fileChange{Type: t, OldPath: fields[i+1], Path: fields[i+1]})
+			i += 2
+		}
+	}
+
+	return ret, nil
+}
+
+// changes returns the changes to the files between the given commits. If from
+// is empty, all files in the commit to are regarded as added.
+func (r repository) changes(from, to string) ([]fileChange, error) {
+	if from == "" {
+		from = emptyTree
+	}
+
+	out, err := r.run("diff", "--name-status", "-M", "-z", from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseChanges(out)
+}
+
+// commitAll stages every change in the working tree and commits it with the
+// given message. It returns false if there was nothing to commit.
+func (r repository) commitAll(message string) (bool, error) {
+	if _, err := r.run("add", "-A"); err != nil {
+		return false, err
+	}
+
+	out, err := r.run("status", "--porcelain")
+	if err != nil {
+		return false, err
+	}
+	if out == "" {
+		return false, nil
+	}
+
+	if _, err := r.run("commit", "-q", "-m", message); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// discardChanges restores the working tree to the current commit
+func (r repository) discardChanges() error {
+	head, err := r.head()
+	if err != nil {
+		return err
+	}
+
+	if head != "" {
+		if _, err := r.run("reset", "-q", "--hard"); err != nil {
+			return err
+		}
+	} else {
+		if _, err := r.run("rm", "-r", "-q", "--cached", "--ignore-unmatch", "."); err != nil {
+			return err
+		}
+	}
+	if _, err := r.run("clean", "-f", "-d", "-q"); err != nil {
+		return err
+	}
+
+	return nil
+}
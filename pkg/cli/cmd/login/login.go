@@ -19,12 +19,14 @@
 package login
 
 import (
-	"strconv"
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/dnote/dnote/pkg/cli/client"
-	"github.com/dnote/dnote/pkg/cli/consts"
 	"github.com/dnote/dnote/pkg/cli/context"
-	"github.com/dnote/dnote/pkg/cli/database"
 	"github.com/dnote/dnote/pkg/cli/infra"
 	"github.com/dnote/dnote/pkg/cli/log"
 	"github.com/dnote/dnote/pkg/cli/ui"
@@ -33,7 +35,39 @@ import (
 )
 
 var example = `
-  dnote login`
+  dnote login
+
+  # log in without prompts, reading the password from stdin
+  cat ~/.dnote_password | dnote login --email alice@example.com --password-stdin
+
+  # log in using the credentials in the environment
+  DNOTE_EMAIL=alice@example.com DNOTE_PASSWORD=secret dnote login
+
+  # log in to an account with two-factor authentication
+  dnote login --email alice@example.com --code 123456
+
+  # log in with single sign-on, approving the login in a browser on any device
+  dnote login --sso
+
+  # log in with a token issued by the server administrator
+  dnote login --token 7tmyP1hDbl3ZhWnBH1u3uI
+
+  # show the current login status
+  dnote login --status`
+
+var emailFlag string
+var passwordStdinFlag bool
+var tokenFlag string
+var codeFlag string
+var statusFlag bool
+var ssoFlag bool
+
+// ssoPollInterval is the interval at which the single sign-on is polled if the
+// server does not specify one
+const ssoPollInterval = 5 * time.Second
+
+// pollSleep waits between the polls of the single sign-on
+var pollSleep = time.Sleep
 
 // NewCmd returns a new login command
 func NewCmd(ctx context.DnoteCtx) *cobra.Command {
@@ -44,64 +78,248 @@ func NewCmd(ctx context.DnoteCtx) *cobra.Command {
 		RunE:    newRun(ctx),
 	}
 
+	f := cmd.Flags()
+	f.StringVarP(&emailFlag, "email", "", "", "email to log in with (defaults to $DNOTE_EMAIL)")
+	f.BoolVarP(&passwordStdinFlag, "password-stdin", "", false, "read the password from stdin")
+	f.StringVarP(&tokenFlag, "token", "", "", "log in with a session token issued by the server (defaults to $DNOTE_TOKEN)")
+	f.StringVarP(&codeFlag, "code", "", "", "two-factor authentication code or recovery code (defaults to $DNOTE_TOTP_CODE)")
+	f.BoolVarP(&statusFlag, "status", "", false, "show the login status")
+	f.BoolVarP(&ssoFlag, "sso", "", false, "log in with single sign-on by approving the login in a browser on any device")
+
 	return cmd
 }
 
-// Do dervies credentials on the client side and requests a session token from the server
-func Do(ctx context.DnoteCtx, email, password string) error {
-	signinResp, err := client.Signin(ctx, email, password)
+// Do dervies credentials on the client side and requests a session token from the server.
+// The code is the two-factor authentication code or a recovery code, if any.
+func Do(ctx context.DnoteCtx, email, password, code string) (client.SigninResponse, error) {
+	signinResp, err := client.Signin(ctx, email, password, code)
 	if err != nil {
-		return errors.Wrap(err, "requesting session")
+		return client.SigninResponse{}, errors.Wrap(err, "requesting session")
+	}
+
+	if err := infra.SaveSession(ctx, signinResp.Key, signinResp.ExpiresAt); err != nil {
+		return client.SigninResponse{}, errors.Wrap(err, "saving session")
+	}
+
+	return signinResp, nil
+}
+
+// DoToken verifies the given session token with the server and saves it
+// as the current session
+func DoToken(ctx context.DnoteCtx, token string) (client.GetSessionResp, error) {
+	ctx.SessionKey = token
+
+	session, err := client.GetSession(ctx)
+	if err != nil {
+		return client.GetSessionResp{}, errors.Wrap(err, "verifying token")
+	}
+
+	if err := infra.SaveSession(ctx, token, session.ExpiresAt); err != nil {
+		return client.GetSessionResp{}, errors.Wrap(err, "saving session")
 	}
 
-	db := ctx.DB
-	tx, err := db.Begin()
+	return session, nil
+}
+
+// DoSSO logs in with single sign-on. It prints the code with which the user
+// approves the login in a browser, and polls the server until the user does.
+func DoSSO(ctx context.DnoteCtx) (client.SigninResponse, error) {
+	auth, err := client.StartSSODevice(ctx)
 	if err != nil {
-		return errors.Wrap(err, "beginning a transaction")
+		return client.SigninResponse{}, errors.Wrap(err, "starting single sign-on")
+	}
+
+	log.Plainf("To log in, visit %s and enter the code %s\n", auth.VerificationURI, auth.UserCode)
+	if auth.VerificationURIComplete != "" {
+		log.Plainf("or visit %s\n", auth.VerificationURIComplete)
+	}
+	log.Plain("Waiting for the login to be approved...\n")
+
+	interval := time.Duration(auth.Interval) * time.Second
+	if interval <= 0 {
+		interval = ssoPollInterval
+	}
+
+	for {
+		pollSleep(interval)
+
+		resp, err := client.PollSSODevice(ctx, auth.DeviceCode)
+		switch errors.Cause(err) {
+		case nil:
+			if err := infra.SaveSession(ctx, resp.Key, resp.ExpiresAt); err != nil {
+				return client.SigninResponse{}, errors.Wrap(err, "saving session")
+			}
+
+			return resp, nil
+		case client.ErrSSOAuthorizationPending:
+			continue
+		case client.ErrSSOSlowDown:
+			interval += ssoPollInterval
+		default:
+			return client.SigninResponse{}, errors.Wrap(err, "polling single sign-on")
+		}
 	}
+}
 
-	if err := database.UpsertSystem(tx, consts.SystemSessionKey, signinResp.Key); err != nil {
-		return errors.Wrap(err, "saving session key")
+// readPassword reads the password from the first line of the given reader
+func readPassword(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", errors.Wrap(err, "reading from stdin")
 	}
-	if err := database.UpsertSystem(tx, consts.SystemSessionKeyExpiry, strconv.FormatInt(signinResp.ExpiresAt, 10)); err != nil {
-		return errors.Wrap(err, "saving session key")
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// getCredentials returns the email and password to log in with, reading
+// from the flags, the environment, and the prompts in that order
+func getCredentials() (string, string, error) {
+	email := emailFlag
+	if email == "" {
+		email = os.Getenv("DNOTE_EMAIL")
 	}
 
-	tx.Commit()
+	var password string
+	if passwordStdinFlag {
+		p, err := readPassword(os.Stdin)
+		if err != nil {
+			return "", "", errors.Wrap(err, "reading password")
+		}
+		password = p
+	} else {
+		password = os.Getenv("DNOTE_PASSWORD")
+	}
+
+	if email == "" {
+		if err := ui.PromptInput("email", &email); err != nil {
+			return "", "", errors.Wrap(err, "getting email input")
+		}
+	}
+	if email == "" {
+		return "", "", errors.New("Email is empty")
+	}
+
+	if password == "" && !passwordStdinFlag {
+		if err := ui.PromptPassword("password", &password); err != nil {
+			return "", "", errors.Wrap(err, "getting password input")
+		}
+	}
+	if password == "" {
+		return "", "", errors.New("Password is empty")
+	}
+
+	return email, password, nil
+}
+
+func printStatus(ctx context.DnoteCtx) error {
+	log.Plainf("endpoint: %s\n", ctx.APIEndpoint)
+
+	if ctx.SessionKey == "" {
+		log.Plain("status: not logged in\n")
+		return nil
+	}
+
+	expiry := time.Unix(ctx.SessionKeyExpiry, 0)
+	if ctx.SessionKeyExpiry != 0 && ctx.Clock.Now().After(expiry) {
+		log.Plain("status: session expired. Please log in again with 'dnote login'\n")
+		log.Plainf("expired at: %s\n", expiry.Format(time.RFC3339))
+		return nil
+	}
+
+	session, err := client.GetSession(ctx)
+	if errors.Cause(err) == client.ErrInvalidLogin {
+		log.Plain("status: session is no longer valid. Please log in again with 'dnote login'\n")
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "getting session")
+	}
+
+	log.Plain("status: logged in\n")
+	log.Plainf("email: %s\n", session.Email)
+	log.Plainf("expires at: %s\n", time.Unix(session.ExpiresAt, 0).Format(time.RFC3339))
 
 	return nil
 }
 
 func newRun(ctx context.DnoteCtx) infra.RunEFunc {
 	return func(cmd *cobra.Command, args []string) error {
-		log.Plain("Welcome to Dnote Pro (https://www.getdnote.com).\n")
+		if statusFlag {
+			return printStatus(ctx)
+		}
 
-		var email, password string
-		if err := ui.PromptInput("email", &email); err != nil {
-			return errors.Wrap(err, "getting email input")
+		if ssoFlag {
+			resp, err := DoSSO(ctx)
+			if c := errors.Cause(err); c == client.ErrSSONotEnabled || c == client.ErrSSOAccessDenied || c == client.ErrSSOExpired {
+				log.Errorf("%s\n", c.Error())
+				return nil
+			} else if err != nil {
+				return errors.Wrap(err, "logging in")
+			}
+
+			log.Success("logged in\n")
+			if resp.TOTPEnrollmentRequired {
+				log.Warnf("your organization requires two-factor authentication. Please enable it in the web application to use this session\n")
+			}
+			return nil
+		}
+
+		token := tokenFlag
+		if token == "" {
+			token = os.Getenv("DNOTE_TOKEN")
 		}
-		if email == "" {
-			return errors.New("Email is empty")
+		if token != "" {
+			session, err := DoToken(ctx, token)
+			if errors.Cause(err) == client.ErrInvalidLogin {
+				return errors.New("invalid token")
+			} else if err != nil {
+				return errors.Wrap(err, "logging in")
+			}
+
+			log.Successf("logged in as %s\n", session.Email)
+			return nil
 		}
 
-		if err := ui.PromptPassword("password", &password); err != nil {
-			return errors.Wrap(err, "getting password input")
+		interactive := emailFlag == "" && !passwordStdinFlag && os.Getenv("DNOTE_EMAIL") == ""
+		if interactive {
+			log.Plain("Welcome to Dnote Pro (https://www.getdnote.com).\n")
 		}
-		if password == "" {
-			return errors.New("Password is empty")
+
+		email, password, err := getCredentials()
+		if err != nil {
+			return err
+		}
+
+		code := codeFlag
+		if code == "" {
+			code = os.Getenv("DNOTE_TOTP_CODE")
+		}
+
+		resp, err := Do(ctx, email, password, code)
+		if errors.Cause(err) == client.ErrTOTPRequired {
+			if passwordStdinFlag {
+				return errors.New("two-factor authentication code is required. Please provide it with --code")
+			}
+
+			if err := ui.PromptInput("two-factor authentication code (or a recovery code)", &code); err != nil {
+				return errors.Wrap(err, "getting code input")
+			}
+
+			resp, err = Do(ctx, email, password, code)
 		}
 
-		err := Do(ctx, email, password)
 		if errors.Cause(err) == client.ErrInvalidLogin {
-			log.Error("wrong login\n")
-			return nil
+			return errors.New("wrong login")
+		} else if errors.Cause(err) == client.ErrInvalidTOTP || errors.Cause(err) == client.ErrTOTPRequired {
+			return errors.New("wrong two-factor authentication code")
 		} else if err != nil {
 			return errors.Wrap(err, "logging in")
 		}
 
 		log.Success("logged in\n")
+		if resp.TOTPEnrollmentRequired {
+			log.Warnf("your organization requires two-factor authentication. Please enable it in the web application to use this session\n")
+		}
 
 		return nil
 	}
-
 }
@@ -0,0 +1,243 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package login
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+func TestReadPassword(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input:    "pass1234\n",
+			expected: "pass1234",
+		},
+		{
+			input:    "pass1234\r\n",
+			expected: "pass1234",
+		},
+		{
+			input:    "pass1234",
+			expected: "pass1234",
+		},
+		{
+			input:    "pass 1234\nsecond line\n",
+			expected: "pass 1234",
+		},
+		{
+			input:    "",
+			expected: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := readPassword(strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, got, tc.expected, "result mismatch")
+		})
+	}
+}
+
+func newSessionServer(t *testing.T, validToken string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/session" || r.Method != "GET" {
+			t.Fatalf("unrecognized endpoint reached Method: %s Path: %s", r.Method, r.URL.Path)
+		}
+
+		if r.Header.Get("Authorization") != "Bearer "+validToken {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		resp := client.GetSessionResp{
+			Email:     "alice@example.com",
+			ExpiresAt: 1893456000,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}))
+}
+
+func TestDoToken(t *testing.T) {
+	// set up
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	ts := newSessionServer(t, "someToken")
+	defer ts.Close()
+	ctx.APIEndpoint = ts.URL
+
+	// execute
+	session, err := DoToken(ctx, "someToken")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// test
+	assert.Equal(t, session.Email, "alice@example.com", "email mismatch")
+
+	var sessionKey, sessionKeyExpiry string
+	database.MustScan(t, "getting session key", ctx.DB.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemSessionKey), &sessionKey)
+	database.MustScan(t, "getting session key expiry", ctx.DB.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemSessionKeyExpiry), &sessionKeyExpiry)
+	assert.Equal(t, sessionKey, "someToken", "session key mismatch")
+	assert.Equal(t, sessionKeyExpiry, "1893456000", "session key expiry mismatch")
+}
+
+func TestDoToken_invalid(t *testing.T) {
+	// set up
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	ts := newSessionServer(t, "someToken")
+	defer ts.Close()
+	ctx.APIEndpoint = ts.URL
+
+	// execute
+	_, err := DoToken(ctx, "wrongToken")
+
+	// test
+	assert.Equal(t, errors.Cause(err), client.ErrInvalidLogin, "error mismatch")
+
+	var count int
+	database.MustScan(t, "counting session key", ctx.DB.QueryRow("SELECT count(*) FROM system WHERE key = ?", consts.SystemSessionKey), &count)
+	assert.Equal(t, count, 0, "session key should not be saved")
+}
+
+// newSSOServer returns a server whose single sign-on responds to the polls
+// with the given errors before responding with a session
+func newSSOServer(t *testing.T, pollErrors []string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case r.URL.Path == "/v3/sso/device" && r.Method == "POST":
+			json.NewEncoder(w).Encode(client.SSODeviceAuthorization{
+				DeviceCode:      "someDeviceCode",
+				UserCode:        "ABCD-EFGH",
+				VerificationURI: "https://idp.example.com/activate",
+				ExpiresIn:       600,
+				Interval:        2,
+			})
+		case r.URL.Path == "/v3/sso/device/token" && r.Method == "POST":
+			var payload struct {
+				DeviceCode string `json:"device_code"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				t.Fatal(errors.Wrap(err, "decoding payload"))
+			}
+			assert.Equal(t, payload.DeviceCode, "someDeviceCode", "device code mismatch")
+
+			if len(pollErrors) > 0 {
+				e := pollErrors[0]
+				pollErrors = pollErrors[1:]
+
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": e})
+				return
+			}
+
+			json.NewEncoder(w).Encode(client.SigninResponse{
+				Key:       "someSessionKey",
+				ExpiresAt: 1893456000,
+			})
+		default:
+			t.Fatalf("unrecognized endpoint reached Method: %s Path: %s", r.Method, r.URL.Path)
+		}
+	}))
+}
+
+func TestDoSSO(t *testing.T) {
+	// set up
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	ts := newSSOServer(t, []string{"authorization_pending", "slow_down", "authorization_pending"})
+	defer ts.Close()
+	ctx.APIEndpoint = ts.URL
+
+	var sleeps []time.Duration
+	pollSleep = func(d time.Duration) {
+		sleeps = append(sleeps, d)
+	}
+	defer func() {
+		pollSleep = time.Sleep
+	}()
+
+	// execute
+	resp, err := DoSSO(ctx)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// test
+	assert.Equal(t, resp.Key, "someSessionKey", "key mismatch")
+	assert.DeepEqual(t, sleeps, []time.Duration{2 * time.Second, 2 * time.Second, 7 * time.Second, 7 * time.Second}, "poll intervals mismatch")
+
+	var sessionKey string
+	database.MustScan(t, "getting session key", ctx.DB.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemSessionKey), &sessionKey)
+	assert.Equal(t, sessionKey, "someSessionKey", "session key mismatch")
+}
+
+func TestDoSSO_denied(t *testing.T) {
+	// set up
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	ts := newSSOServer(t, []string{"authorization_pending", "access_denied"})
+	defer ts.Close()
+	ctx.APIEndpoint = ts.URL
+
+	pollSleep = func(d time.Duration) {}
+	defer func() {
+		pollSleep = time.Sleep
+	}()
+
+	// execute
+	_, err := DoSSO(ctx)
+
+	// test
+	assert.Equal(t, errors.Cause(err), client.ErrSSOAccessDenied, "error mismatch")
+
+	var count int
+	database.MustScan(t, "counting session key", ctx.DB.QueryRow("SELECT count(*) FROM system WHERE key = ?", consts.SystemSessionKey), &count)
+	assert.Equal(t, count, 0, "session key should not be saved")
+}
@@ -24,6 +24,7 @@ import (
 	"strings"
 
 	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
 	"github.com/dnote/dnote/pkg/cli/infra"
 	"github.com/dnote/dnote/pkg/cli/log"
 	"github.com/pkg/errors"
@@ -36,6 +37,9 @@ var example = `
 
  * List notes in a book
  dnote ls javascript
+
+ * List the books nested in a book, and the notes in it
+ dnote ls infra
  `
 
 var deprecationWarning = `and "view" will replace it in the future version.
@@ -134,17 +138,27 @@ func printBookLine(info bookInfo, nameOnly bool) {
 	}
 }
 
-func printBooks(ctx context.DnoteCtx, nameOnly bool) error {
-	db := ctx.DB
-
-	rows, err := db.Query(`SELECT books.label, count(notes.uuid) note_count
+// getBookInfos returns the information about the books that are not deleted. If
+// parentPath is not empty, it only returns the books nested in the book at the path.
+func getBookInfos(db *database.DB, parentPath string) ([]bookInfo, error) {
+	query := database.BookPaths + ` SELECT book_paths.path, count(notes.uuid) note_count
 	FROM books
+	INNER JOIN book_paths ON book_paths.uuid = books.uuid
 	LEFT JOIN notes ON notes.book_uuid = books.uuid AND notes.deleted = false
-	WHERE books.deleted = false
-	GROUP BY books.uuid
-	ORDER BY books.label ASC;`)
+	WHERE books.deleted = false`
+	args := []interface{}{}
+
+	if parentPath != "" {
+		prefix := parentPath + "/"
+		query = fmt.Sprintf("%s AND substr(book_paths.path, 1, length(?)) = ?", query)
+		args = append(args, prefix, prefix)
+	}
+
+	query = fmt.Sprintf("%s GROUP BY books.uuid ORDER BY book_paths.path ASC;", query)
+
+	rows, err := db.Query(query, args...)
 	if err != nil {
-		return errors.Wrap(err, "querying books")
+		return nil, errors.Wrap(err, "querying books")
 	}
 	defer rows.Close()
 
@@ -153,12 +167,21 @@ func printBooks(ctx context.DnoteCtx, nameOnly bool) error {
 		var info bookInfo
 		err = rows.Scan(&info.BookLabel, &info.NoteCount)
 		if err != nil {
-			return errors.Wrap(err, "scanning a row")
+			return nil, errors.Wrap(err, "scanning a row")
 		}
 
 		infos = append(infos, info)
 	}
 
+	return infos, nil
+}
+
+func printBooks(ctx context.DnoteCtx, nameOnly bool) error {
+	infos, err := getBookInfos(ctx.DB, "")
+	if err != nil {
+		return err
+	}
+
 	for _, info := range infos {
 		printBookLine(info, nameOnly)
 	}
@@ -170,7 +193,7 @@ func printNotes(ctx context.DnoteCtx, bookName string) error {
 	db := ctx.DB
 
 	var bookUUID string
-	err := db.QueryRow("SELECT uuid FROM books WHERE label = ?", bookName).Scan(&bookUUID)
+	err := db.QueryRow(database.BookPaths+" SELECT uuid FROM book_paths WHERE path = ?", bookName).Scan(&bookUUID)
 	if err == sql.ErrNoRows {
 		return errors.New("book not found")
 	} else if err != nil {
@@ -194,8 +217,17 @@ func printNotes(ctx context.DnoteCtx, bookName string) error {
 		infos = append(infos, info)
 	}
 
+	books, err := getBookInfos(db, bookName)
+	if err != nil {
+		return errors.Wrap(err, "getting nested books")
+	}
+
 	log.Infof("on book %s\n", bookName)
 
+	for _, info := range books {
+		printBookLine(info, false)
+	}
+
 	for _, info := range infos {
 		body, isExcerpt := formatBody(info.Body)
 
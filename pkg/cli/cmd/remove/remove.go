@@ -148,9 +148,9 @@ func runNote(ctx context.DnoteCtx, rowIDArg string) error {
 		return errors.Wrap(err, "beginning a transaction")
 	}
 
-	if _, err = tx.Exec("UPDATE notes SET deleted = ?, dirty = ?, body = ? WHERE uuid = ?", true, true, "", noteInfo.UUID); err != nil {
+	if err := database.RemoveNote(tx, noteInfo.UUID); err != nil {
 		tx.Rollback()
-		return errors.Wrap(err, "removing the note")
+		return err
 	}
 
 	err = tx.Commit()
@@ -164,6 +164,28 @@ func runNote(ctx context.DnoteCtx, rowIDArg string) error {
 	return nil
 }
 
+// removeBook marks the book with the given uuid and its notes as deleted
+func removeBook(tx *database.DB, bookUUID string) error {
+	if _, err := tx.Exec("DELETE FROM note_links WHERE source_uuid IN (SELECT uuid FROM notes WHERE book_uuid = ?)", bookUUID); err != nil {
+		return errors.Wrap(err, "removing the links of notes in the book")
+	}
+	if _, err := tx.Exec("UPDATE notes SET deleted = ?, dirty = ?, body = ? WHERE book_uuid = ?", true, true, "", bookUUID); err != nil {
+		return errors.Wrap(err, "removing notes in the book")
+	}
+
+	// override the label with a random string
+	uniqLabel, err := utils.GenerateUUID()
+	if err != nil {
+		return errors.Wrap(err, "generating uuid to override with")
+	}
+
+	if _, err = tx.Exec("UPDATE books SET deleted = ?, dirty = ?, label = ? WHERE uuid = ?", true, true, uniqLabel, bookUUID); err != nil {
+		return errors.Wrap(err, "removing the book")
+	}
+
+	return nil
+}
+
 func runBook(ctx context.DnoteCtx, bookLabel string) error {
 	db := ctx.DB
 
@@ -172,7 +194,17 @@ func runBook(ctx context.DnoteCtx, bookLabel string) error {
 		return errors.Wrap(err, "finding book uuid")
 	}
 
-	ok, err := maybeConfirm(fmt.Sprintf("delete book '%s' and all its notes?", bookLabel), false)
+	subtree, err := database.GetBookSubtree(db, bookUUID)
+	if err != nil {
+		return errors.Wrap(err, "finding nested books")
+	}
+
+	question := fmt.Sprintf("delete book '%s' and all its notes?", bookLabel)
+	if len(subtree) > 1 {
+		question = fmt.Sprintf("delete book '%s', %d books in it, and all their notes?", bookLabel, len(subtree)-1)
+	}
+
+	ok, err := maybeConfirm(question, false)
 	if err != nil {
 		return errors.Wrap(err, "getting confirmation")
 	}
@@ -186,20 +218,11 @@ func runBook(ctx context.DnoteCtx, bookLabel string) error {
 		return errors.Wrap(err, "beginning a transaction")
 	}
 
-	if _, err = tx.Exec("UPDATE notes SET deleted = ?, dirty = ?, body = ? WHERE book_uuid = ?", true, true, "", bookUUID); err != nil {
-		tx.Rollback()
-		return errors.Wrap(err, "removing notes in the book")
-	}
-
-	// override the label with a random string
-	uniqLabel, err := utils.GenerateUUID()
-	if err != nil {
-		return errors.Wrap(err, "generating uuid to override with")
-	}
-
-	if _, err = tx.Exec("UPDATE books SET deleted = ?, dirty = ?, label = ? WHERE uuid = ?", true, true, uniqLabel, bookUUID); err != nil {
-		tx.Rollback()
-		return errors.Wrap(err, "removing the book")
+	for _, uuid := range subtree {
+		if err := removeBook(tx, uuid); err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
 
 	err = tx.Commit()
@@ -0,0 +1,430 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package serve
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/utils"
+	"github.com/dnote/dnote/pkg/cli/validate"
+	"github.com/dnote/dnote/pkg/wikilink"
+	"github.com/pkg/errors"
+)
+
+// noteResult is a note in a result
+type noteResult struct {
+	ID       int    `json:"id"`
+	UUID     string `json:"uuid"`
+	Book     string `json:"book"`
+	Title    string `json:"title"`
+	Content  string `json:"content,omitempty"`
+	AddedOn  int64  `json:"added_on"`
+	EditedOn int64  `json:"edited_on"`
+	Public   bool   `json:"public"`
+}
+
+func newNoteResult(info database.NoteInfo, withContent bool) noteResult {
+	ret := noteResult{
+		ID:       info.RowID,
+		UUID:     info.UUID,
+		Book:     info.BookLabel,
+		Title:    wikilink.Title(info.Content),
+		AddedOn:  info.AddedOn,
+		EditedOn: info.EditedOn,
+		Public:   info.Public,
+	}
+	if withContent {
+		ret.Content = info.Content
+	}
+
+	return ret
+}
+
+// bookResult is a book in a result
+type bookResult struct {
+	// Name is the path of the book, such as infra/k8s
+	Name      string `json:"name"`
+	NoteCount int    `json:"note_count"`
+}
+
+type listParams struct {
+	Book string `json:"book"`
+}
+
+// listResult is the result of listing the books nested in a book and its notes.
+// Without a book, all books are listed.
+type listResult struct {
+	Books []bookResult `json:"books"`
+	Notes []noteResult `json:"notes"`
+}
+
+type searchParams struct {
+	Query string `json:"query"`
+	Book  string `json:"book"`
+}
+
+type searchResult struct {
+	ID      int    `json:"id"`
+	Book    string `json:"book"`
+	Title   string `json:"title"`
+	Snippet string `json:"snippet"`
+}
+
+type noteParams struct {
+	ID int `json:"id"`
+}
+
+type createParams struct {
+	Book    string `json:"book"`
+	Content string `json:"content"`
+}
+
+type updateParams struct {
+	ID      int    `json:"id"`
+	Content string `json:"content"`
+}
+
+type moveParams struct {
+	ID   int    `json:"id"`
+	Book string `json:"book"`
+}
+
+// handlers returns the handlers of the methods served for the given context
+func handlers(ctx context.DnoteCtx) map[string]handlerFunc {
+	return map[string]handlerFunc{
+		"search": func(params json.RawMessage) (interface{}, error) {
+			var p searchParams
+			if err := decodeParams(params, &p); err != nil {
+				return nil, err
+			}
+
+			return search(ctx, p)
+		},
+		"list": func(params json.RawMessage) (interface{}, error) {
+			var p listParams
+			if len(params) > 0 {
+				if err := decodeParams(params, &p); err != nil {
+					return nil, err
+				}
+			}
+
+			return list(ctx, p)
+		},
+		"get": func(params json.RawMessage) (interface{}, error) {
+			var p noteParams
+			if err := decodeParams(params, &p); err != nil {
+				return nil, err
+			}
+
+			return getNote(ctx.DB, p.ID)
+		},
+		"create": func(params json.RawMessage) (interface{}, error) {
+			var p createParams
+			if err := decodeParams(params, &p); err != nil {
+				return nil, err
+			}
+
+			return createNote(ctx, p)
+		},
+		"update": func(params json.RawMessage) (interface{}, error) {
+			var p updateParams
+			if err := decodeParams(params, &p); err != nil {
+				return nil, err
+			}
+
+			return updateNote(ctx, p)
+		},
+		"move": func(params json.RawMessage) (interface{}, error) {
+			var p moveParams
+			if err := decodeParams(params, &p); err != nil {
+				return nil, err
+			}
+
+			return moveNote(ctx, p)
+		},
+		"delete": func(params json.RawMessage) (interface{}, error) {
+			var p noteParams
+			if err := decodeParams(params, &p); err != nil {
+				return nil, err
+			}
+
+			return nil, deleteNote(ctx, p.ID)
+		},
+	}
+}
+
+// getNoteInfo returns the information about the active note with the given rowid
+func getNoteInfo(db *database.DB, rowID int) (database.NoteInfo, error) {
+	if _, err := database.GetActiveNote(db, rowID); err == sql.ErrNoRows {
+		return database.NoteInfo{}, newError(codeNotFound, "note %d not found", rowID)
+	} else if err != nil {
+		return database.NoteInfo{}, err
+	}
+
+	return database.GetNoteInfo(db, rowID)
+}
+
+func getNote(db *database.DB, rowID int) (noteResult, error) {
+	info, err := getNoteInfo(db, rowID)
+	if err != nil {
+		return noteResult{}, err
+	}
+
+	return newNoteResult(info, true), nil
+}
+
+func search(ctx context.DnoteCtx, p searchParams) ([]searchResult, error) {
+	query := database.FTSQuery(p.Query)
+	if query == "" {
+		return nil, newError(codeInvalidParams, "the query is empty")
+	}
+
+	q := database.BookPaths + ` SELECT notes.rowid, book_paths.path, notes.body, snippet(note_fts, 0, '', '', '...', 28)
+	FROM note_fts
+	INNER JOIN notes ON notes.rowid = note_fts.rowid
+	INNER JOIN book_paths ON notes.book_uuid = book_paths.uuid
+	WHERE note_fts MATCH ? AND notes.deleted = false`
+	args := []interface{}{query}
+
+	// include the books nested in the book
+	if p.Book != "" {
+		prefix := p.Book + "/"
+		q = fmt.Sprintf("%s AND (book_paths.path = ? OR substr(book_paths.path, 1, length(?)) = ?)", q)
+		args = append(args, p.Book, prefix, prefix)
+	}
+
+	rows, err := ctx.DB.Query(fmt.Sprintf("%s ORDER BY rank", q), args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying notes")
+	}
+	defer rows.Close()
+
+	ret := []searchResult{}
+	for rows.Next() {
+		var r searchResult
+		var body string
+		if err := rows.Scan(&r.ID, &r.Book, &body, &r.Snippet); err != nil {
+			return nil, errors.Wrap(err, "scanning a row")
+		}
+
+		r.Title = wikilink.Title(body)
+		ret = append(ret, r)
+	}
+
+	return ret, nil
+}
+
+func list(ctx context.DnoteCtx, p listParams) (listResult, error) {
+	db := ctx.DB
+
+	query := database.BookPaths + ` SELECT book_paths.path, count(notes.uuid)
+	FROM books
+	INNER JOIN book_paths ON book_paths.uuid = books.uuid
+	LEFT JOIN notes ON notes.book_uuid = books.uuid AND notes.deleted = false
+	WHERE books.deleted = false`
+	args := []interface{}{}
+
+	var bookUUID string
+	if p.Book != "" {
+		err := db.QueryRow(database.BookPaths+" SELECT uuid FROM book_paths WHERE path = ?", p.Book).Scan(&bookUUID)
+		if err == sql.ErrNoRows {
+			return listResult{}, newError(codeNotFound, "book '%s' not found", p.Book)
+		} else if err != nil {
+			return listResult{}, errors.Wrap(err, "querying the book")
+		}
+
+		prefix := p.Book + "/"
+		query = fmt.Sprintf("%s AND substr(book_paths.path, 1, length(?)) = ?", query)
+		args = append(args, prefix, prefix)
+	}
+
+	rows, err := db.Query(fmt.Sprintf("%s GROUP BY books.uuid ORDER BY book_paths.path ASC", query), args...)
+	if err != nil {
+		return listResult{}, errors.Wrap(err, "querying books")
+	}
+	defer rows.Close()
+
+	ret := listResult{
+		Books: []bookResult{},
+		Notes: []noteResult{},
+	}
+	for rows.Next() {
+		var b bookResult
+		if err := rows.Scan(&b.Name, &b.NoteCount); err != nil {
+			return listResult{}, errors.Wrap(err, "scanning a row for book")
+		}
+
+		ret.Books = append(ret.Books, b)
+	}
+
+	if bookUUID == "" {
+		return ret, nil
+	}
+
+	noteRows, err := db.Query(`SELECT rowid, uuid, body, added_on, edited_on, public
+	FROM notes
+	WHERE book_uuid = ? AND deleted = false
+	ORDER BY added_on ASC`, bookUUID)
+	if err != nil {
+		return listResult{}, errors.Wrap(err, "querying notes")
+	}
+	defer noteRows.Close()
+
+	for noteRows.Next() {
+		info := database.NoteInfo{BookLabel: p.Book}
+		if err := noteRows.Scan(&info.RowID, &info.UUID, &info.Content, &info.AddedOn, &info.EditedOn, &info.Public); err != nil {
+			return listResult{}, errors.Wrap(err, "scanning a row for note")
+		}
+
+		ret.Notes = append(ret.Notes, newNoteResult(info, false))
+	}
+
+	return ret, nil
+}
+
+func createNote(ctx context.DnoteCtx, p createParams) (noteResult, error) {
+	if err := validate.BookPath(p.Book); err != nil {
+		return noteResult{}, newError(codeInvalidParams, "invalid book name: %s", err.Error())
+	}
+	if p.Content == "" {
+		return noteResult{}, newError(codeInvalidParams, "the content is empty")
+	}
+
+	tx, err := ctx.DB.Begin()
+	if err != nil {
+		return noteResult{}, errors.Wrap(err, "beginning a transaction")
+	}
+
+	bookUUID, err := database.FindOrCreateBook(tx, p.Book)
+	if err != nil {
+		tx.Rollback()
+		return noteResult{}, err
+	}
+
+	noteUUID, err := utils.GenerateUUID()
+	if err != nil {
+		tx.Rollback()
+		return noteResult{}, errors.Wrap(err, "generating uuid")
+	}
+
+	n := database.NewNote(noteUUID, bookUUID, p.Content, ctx.Clock.Now().UnixNano(), 0, 0, false, false, true)
+	if err := n.Insert(tx); err != nil {
+		tx.Rollback()
+		return noteResult{}, errors.Wrap(err, "creating the note")
+	}
+
+	var rowID int
+	if err := tx.QueryRow("SELECT rowid FROM notes WHERE uuid = ?", noteUUID).Scan(&rowID); err != nil {
+		tx.Rollback()
+		return noteResult{}, errors.Wrap(err, "getting the note rowid")
+	}
+
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return noteResult{}, errors.Wrap(err, "committing a transaction")
+	}
+
+	return getNote(ctx.DB, rowID)
+}
+
+func updateNote(ctx context.DnoteCtx, p updateParams) (noteResult, error) {
+	if p.Content == "" {
+		return noteResult{}, newError(codeInvalidParams, "the content is empty")
+	}
+
+	info, err := getNoteInfo(ctx.DB, p.ID)
+	if err != nil {
+		return noteResult{}, err
+	}
+
+	// leave an unchanged note clean so that it is not synced
+	if info.Content != p.Content {
+		tx, err := ctx.DB.Begin()
+		if err != nil {
+			return noteResult{}, errors.Wrap(err, "beginning a transaction")
+		}
+
+		if err := database.UpdateNoteContent(tx, ctx.Clock, p.ID, p.Content); err != nil {
+			tx.Rollback()
+			return noteResult{}, err
+		}
+
+		if err := tx.Commit(); err != nil {
+			tx.Rollback()
+			return noteResult{}, errors.Wrap(err, "committing a transaction")
+		}
+	}
+
+	return getNote(ctx.DB, p.ID)
+}
+
+func moveNote(ctx context.DnoteCtx, p moveParams) (noteResult, error) {
+	if err := validate.BookPath(p.Book); err != nil {
+		return noteResult{}, newError(codeInvalidParams, "invalid book name: %s", err.Error())
+	}
+
+	info, err := getNoteInfo(ctx.DB, p.ID)
+	if err != nil {
+		return noteResult{}, err
+	}
+
+	var bookUUID string
+	err = ctx.DB.QueryRow(database.BookPaths+" SELECT uuid FROM book_paths WHERE path = ?", p.Book).Scan(&bookUUID)
+	if err == sql.ErrNoRows {
+		return noteResult{}, newError(codeNotFound, "book '%s' not found", p.Book)
+	} else if err != nil {
+		return noteResult{}, errors.Wrap(err, "querying the book")
+	}
+
+	if info.BookLabel != p.Book {
+		if err := database.UpdateNoteBook(ctx.DB, ctx.Clock, p.ID, bookUUID); err != nil {
+			return noteResult{}, err
+		}
+	}
+
+	return getNote(ctx.DB, p.ID)
+}
+
+func deleteNote(ctx context.DnoteCtx, rowID int) error {
+	info, err := getNoteInfo(ctx.DB, rowID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := ctx.DB.Begin()
+	if err != nil {
+		return errors.Wrap(err, "beginning a transaction")
+	}
+
+	if err := database.RemoveNote(tx, info.UUID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "committing a transaction")
+	}
+
+	return nil
+}
@@ -0,0 +1,265 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package serve
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const jsonrpcVersion = "2.0"
+
+// error codes defined by JSON-RPC 2.0
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+)
+
+// codeNotFound is an error code for a note or a book that does not exist
+const codeNotFound = -32001
+
+// rpcError is an error sent to the client in a response
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+func newError(code int, format string, a ...interface{}) *rpcError {
+	return &rpcError{
+		Code:    code,
+		Message: fmt.Sprintf(format, a...),
+	}
+}
+
+// request is a request or a notification from the client. A notification has no
+// id and gets no response.
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// framing is the way in which the messages are delimited in a stream
+type framing int
+
+const (
+	// framingLine delimits each message with a newline
+	framingLine framing = iota
+	// framingHeader precedes each message with a Content-Length header, as in
+	// the Language Server Protocol
+	framingHeader
+)
+
+const contentLengthHeader = "content-length:"
+
+// codec reads and writes the messages in a stream. Each message is written back
+// in the framing in which the client sent the request.
+type codec struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+func newCodec(r io.Reader, w io.Writer) *codec {
+	return &codec{
+		r: bufio.NewReader(r),
+		w: w,
+	}
+}
+
+// readHeaders reads the headers following the given first line until an empty
+// line, and returns the content length
+func (c *codec) readHeaders(line string) (int, error) {
+	length := -1
+
+	for {
+		if strings.HasPrefix(strings.ToLower(line), contentLengthHeader) {
+			n, err := strconv.Atoi(strings.TrimSpace(line[len(contentLengthHeader):]))
+			if err != nil || n < 0 {
+				return 0, errors.Errorf("invalid content length: %s", line)
+			}
+
+			length = n
+		}
+
+		l, err := c.r.ReadString('\n')
+		if err != nil {
+			return 0, errors.Wrap(err, "reading a header")
+		}
+		line = strings.TrimRight(l, "\r\n")
+		if line == "" {
+			break
+		}
+	}
+
+	if length == -1 {
+		return 0, errors.New("missing content length")
+	}
+
+	return length, nil
+}
+
+// read reads the next message. It returns io.EOF at the end of the stream.
+func (c *codec) read() ([]byte, framing, error) {
+	for {
+		l, err := c.r.ReadString('\n')
+		if err == io.EOF && l == "" {
+			return nil, framingLine, io.EOF
+		} else if err != nil && err != io.EOF {
+			return nil, framingLine, errors.Wrap(err, "reading a line")
+		}
+
+		line := strings.TrimRight(l, "\r\n")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(strings.ToLower(line), contentLengthHeader) {
+			return []byte(line), framingLine, nil
+		}
+
+		length, err := c.readHeaders(line)
+		if err != nil {
+			return nil, framingHeader, err
+		}
+
+		msg := make([]byte, length)
+		if _, err := io.ReadFull(c.r, msg); err != nil {
+			return nil, framingHeader, errors.Wrap(err, "reading the content")
+		}
+
+		return msg, framingHeader, nil
+	}
+}
+
+// write writes the given message in the given framing
+func (c *codec) write(msg []byte, f framing) error {
+	var b bytes.Buffer
+
+	if f == framingHeader {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n", len(msg))
+		b.Write(msg)
+	} else {
+		b.Write(msg)
+		b.WriteByte('\n')
+	}
+
+	if _, err := c.w.Write(b.Bytes()); err != nil {
+		return errors.Wrap(err, "writing a message")
+	}
+
+	return nil
+}
+
+// handlerFunc handles a request with the given params, and returns the result
+type handlerFunc func(params json.RawMessage) (interface{}, error)
+
+// decodeParams decodes the params of a request into v
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return newError(codeInvalidParams, "missing params")
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return newError(codeInvalidParams, "invalid params: %s", err.Error())
+	}
+
+	return nil
+}
+
+// encodeResponse returns the encoded response to the request with the given id
+func encodeResponse(id json.RawMessage, result interface{}, err error) []byte {
+	res := response{
+		JSONRPC: jsonrpcVersion,
+		ID:      id,
+	}
+
+	if err == nil {
+		b, e := json.Marshal(result)
+		if e != nil {
+			err = errors.Wrap(e, "encoding the result")
+		} else {
+			res.Result = b
+		}
+	}
+	if err != nil {
+		if rpcErr, ok := errors.Cause(err).(*rpcError); ok {
+			res.Error = rpcErr
+		} else {
+			res.Error = newError(codeInternalError, "%s", err.Error())
+		}
+	}
+
+	// a response of the fields above cannot fail to be encoded
+	b, _ := json.Marshal(res)
+
+	return b
+}
+
+// dispatch handles the given message with the given handlers, and returns the
+// encoded response. It returns nil for a notification.
+func dispatch(handlers map[string]handlerFunc, msg []byte) []byte {
+	var req request
+	if err := json.Unmarshal(msg, &req); err != nil {
+		if bytes.HasPrefix(bytes.TrimSpace(msg), []byte("[")) {
+			return encodeResponse(nil, nil, newError(codeInvalidRequest, "batch requests are not supported"))
+		}
+
+		return encodeResponse(nil, nil, newError(codeParseError, "parse error: %s", err.Error()))
+	}
+	if req.JSONRPC != jsonrpcVersion || req.Method == "" {
+		return encodeResponse(req.ID, nil, newError(codeInvalidRequest, "invalid request"))
+	}
+
+	var result interface{}
+	var err error
+
+	handler, ok := handlers[req.Method]
+	if ok {
+		result, err = handler(req.Params)
+	} else {
+		err = newError(codeMethodNotFound, "method not found: %s", req.Method)
+	}
+
+	if len(req.ID) == 0 {
+		return nil
+	}
+
+	return encodeResponse(req.ID, result, err)
+}
@@ -0,0 +1,89 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package serve
+
+import (
+	"io"
+	"os"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var stdioFlag bool
+
+var example = `
+  * Serve the notes to an editor plugin over the standard input and output
+  dnote serve --stdio
+
+  The server speaks JSON-RPC 2.0, with each message either on its own line or
+  preceded by a Content-Length header as in the Language Server Protocol. The
+  methods are search, list, get, create, update, move and delete.`
+
+// NewCmd returns a new serve command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "serve",
+		Short:   "Serve the notes to editors over JSON-RPC",
+		Example: example,
+		RunE:    newRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.BoolVarP(&stdioFlag, "stdio", "", false, "Communicate over the standard input and output")
+
+	return cmd
+}
+
+// serve handles the requests read from r and writes the responses to w until r
+// is closed
+func serve(ctx context.DnoteCtx, r io.Reader, w io.Writer) error {
+	c := newCodec(r, w)
+	h := handlers(ctx)
+
+	for {
+		msg, f, err := c.read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "reading a message")
+		}
+
+		res := dispatch(h, msg)
+		if res == nil {
+			continue
+		}
+
+		if err := c.write(res, f); err != nil {
+			return err
+		}
+	}
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		if !stdioFlag {
+			return errors.New("Please specify the transport. Only --stdio is supported")
+		}
+
+		return serve(ctx, os.Stdin, os.Stdout)
+	}
+}
@@ -0,0 +1,331 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package serve
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/pkg/errors"
+)
+
+// call makes a request to the method with the given params, and decodes the result
+// into result. It returns the error in the response.
+func call(t *testing.T, ctx context.DnoteCtx, method string, params interface{}, result interface{}) *rpcError {
+	p, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "encoding params"))
+	}
+	msg, err := json.Marshal(request{JSONRPC: jsonrpcVersion, ID: json.RawMessage("1"), Method: method, Params: p})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "encoding the request"))
+	}
+
+	var res response
+	if err := json.Unmarshal(dispatch(handlers(ctx), msg), &res); err != nil {
+		t.Fatal(errors.Wrap(err, "decoding the response"))
+	}
+	if res.Error != nil {
+		return res.Error
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(res.Result, result); err != nil {
+			t.Fatal(errors.Wrap(err, "decoding the result"))
+		}
+	}
+
+	return nil
+}
+
+// callError makes a request to the method with the given params, and returns the
+// code of the error in the response
+func callError(t *testing.T, ctx context.DnoteCtx, method string, params interface{}) int {
+	err := call(t, ctx, method, params, nil)
+	if err == nil {
+		t.Fatalf("%s did not respond with an error", method)
+	}
+
+	return err.Code
+}
+
+func mustCall(t *testing.T, ctx context.DnoteCtx, method string, params interface{}, result interface{}) {
+	if err := call(t, ctx, method, params, result); err != nil {
+		t.Fatalf("%s responded with an error: %s", method, err.Message)
+	}
+}
+
+// setupNotes inserts a book js with a nested book react, a book css, and a note in
+// each of js and css
+func setupNotes(t *testing.T, db *database.DB) {
+	database.MustExec(t, "inserting js", db, "INSERT INTO books (uuid, label, usn) VALUES (?, ?, ?)", "js-uuid", "js", 1)
+	database.MustExec(t, "inserting react", db, "INSERT INTO books (uuid, parent_uuid, label, usn) VALUES (?, ?, ?, ?)", "react-uuid", "js-uuid", "react", 2)
+	database.MustExec(t, "inserting css", db, "INSERT INTO books (uuid, label, usn) VALUES (?, ?, ?)", "css-uuid", "css", 3)
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n1-uuid", "js-uuid", 4, "# Array.map\n\nmaps an array", 1541108743, false, false)
+	database.MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n2-uuid", "css-uuid", 5, "flexbox aligns items", 1541108744, false, false)
+}
+
+func mustGetRowID(t *testing.T, db *database.DB, uuid string) int {
+	var ret int
+	database.MustScan(t, "getting rowid", db.QueryRow("SELECT rowid FROM notes WHERE uuid = ?", uuid), &ret)
+
+	return ret
+}
+
+func TestServe(t *testing.T) {
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupNotes(t, ctx.DB)
+
+	getReq := `{"jsonrpc":"2.0","id":1,"method":"get","params":{"id":1}}`
+	notification := `{"jsonrpc":"2.0","method":"list"}`
+	unknownReq := `{"jsonrpc":"2.0","id":"a","method":"unknown"}`
+	input := fmt.Sprintf("%s\n%s\nContent-Length: %d\r\n\r\n%s", getReq, notification, len(unknownReq), unknownReq)
+
+	var output bytes.Buffer
+	if err := serve(ctx, strings.NewReader(input), &output); err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	getRes := `{"jsonrpc":"2.0","id":1,"result":{"id":1,"uuid":"n1-uuid","book":"js","title":"Array.map","content":"# Array.map\n\nmaps an array","added_on":1541108743,"edited_on":0,"public":false}}`
+	unknownRes := `{"jsonrpc":"2.0","id":"a","error":{"code":-32601,"message":"method not found: unknown"}}`
+	expected := fmt.Sprintf("%s\nContent-Length: %d\r\n\r\n%s", getRes, len(unknownRes), unknownRes)
+
+	assert.Equal(t, output.String(), expected, "output mismatch")
+}
+
+func TestDispatch_invalid(t *testing.T) {
+	testCases := []struct {
+		msg          string
+		expectedCode int
+	}{
+		{
+			msg:          `{"jsonrpc":"2.0","id":1,`,
+			expectedCode: codeParseError,
+		},
+		{
+			msg:          `[{"jsonrpc":"2.0","id":1,"method":"list"}]`,
+			expectedCode: codeInvalidRequest,
+		},
+		{
+			msg:          `{"jsonrpc":"1.0","id":1,"method":"list"}`,
+			expectedCode: codeInvalidRequest,
+		},
+		{
+			msg:          `{"jsonrpc":"2.0","id":1,"method":"get"}`,
+			expectedCode: codeInvalidParams,
+		},
+		{
+			msg:          `{"jsonrpc":"2.0","id":1,"method":"get","params":{"id":"1"}}`,
+			expectedCode: codeInvalidParams,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.msg, func(t *testing.T) {
+			var res response
+			if err := json.Unmarshal(dispatch(map[string]handlerFunc{
+				"get": func(params json.RawMessage) (interface{}, error) {
+					var p noteParams
+					return nil, decodeParams(params, &p)
+				},
+			}, []byte(tc.msg)), &res); err != nil {
+				t.Fatal(errors.Wrap(err, "decoding the response"))
+			}
+
+			if res.Error == nil {
+				t.Fatal("the response does not have an error")
+			}
+			assert.Equal(t, res.Error.Code, tc.expectedCode, "code mismatch")
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupNotes(t, ctx.DB)
+
+	t.Run("all books", func(t *testing.T) {
+		var result listResult
+		mustCall(t, ctx, "list", nil, &result)
+
+		assert.DeepEqual(t, result.Books, []bookResult{
+			{Name: "css", NoteCount: 1},
+			{Name: "js", NoteCount: 1},
+			{Name: "js/react", NoteCount: 0},
+		}, "books mismatch")
+		assert.Equal(t, len(result.Notes), 0, "note count mismatch")
+	})
+
+	t.Run("book", func(t *testing.T) {
+		var result listResult
+		mustCall(t, ctx, "list", listParams{Book: "js"}, &result)
+
+		assert.DeepEqual(t, result.Books, []bookResult{{Name: "js/react", NoteCount: 0}}, "books mismatch")
+		assert.DeepEqual(t, result.Notes, []noteResult{
+			{ID: mustGetRowID(t, ctx.DB, "n1-uuid"), UUID: "n1-uuid", Book: "js", Title: "Array.map", AddedOn: 1541108743},
+		}, "notes mismatch")
+	})
+
+	t.Run("nonexistent book", func(t *testing.T) {
+		assert.Equal(t, callError(t, ctx, "list", listParams{Book: "go"}), codeNotFound, "code mismatch")
+	})
+}
+
+func TestSearch(t *testing.T) {
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupNotes(t, ctx.DB)
+	database.MustExec(t, "inserting n3", ctx.DB, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n3-uuid", "react-uuid", 6, "useMemo maps the array once", 1541108745, false, false)
+
+	t.Run("all books", func(t *testing.T) {
+		var result []searchResult
+		mustCall(t, ctx, "search", searchParams{Query: "array"}, &result)
+
+		assert.Equal(t, len(result), 2, "result count mismatch")
+	})
+
+	t.Run("book", func(t *testing.T) {
+		var result []searchResult
+		mustCall(t, ctx, "search", searchParams{Query: "useMemo", Book: "js"}, &result)
+
+		assert.DeepEqual(t, result, []searchResult{
+			{ID: mustGetRowID(t, ctx.DB, "n3-uuid"), Book: "js/react", Title: "useMemo maps the array once", Snippet: "useMemo maps the array once"},
+		}, "result mismatch")
+	})
+
+	t.Run("empty query", func(t *testing.T) {
+		assert.Equal(t, callError(t, ctx, "search", searchParams{Query: " "}), codeInvalidParams, "code mismatch")
+	})
+}
+
+func TestCreate(t *testing.T) {
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupNotes(t, ctx.DB)
+
+	t.Run("new book", func(t *testing.T) {
+		var result noteResult
+		mustCall(t, ctx, "create", createParams{Book: "go/concurrency", Content: "channels"}, &result)
+
+		assert.Equal(t, result.Book, "go/concurrency", "book mismatch")
+		assert.Equal(t, result.Content, "channels", "content mismatch")
+
+		var dirty bool
+		database.MustScan(t, "getting the note", ctx.DB.QueryRow("SELECT dirty FROM notes WHERE uuid = ?", result.UUID), &dirty)
+		assert.Equal(t, dirty, true, "dirty mismatch")
+	})
+
+	t.Run("invalid book", func(t *testing.T) {
+		assert.Equal(t, callError(t, ctx, "create", createParams{Book: "trash", Content: "foo"}), codeInvalidParams, "code mismatch")
+	})
+
+	t.Run("empty content", func(t *testing.T) {
+		assert.Equal(t, callError(t, ctx, "create", createParams{Book: "js", Content: ""}), codeInvalidParams, "code mismatch")
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupNotes(t, ctx.DB)
+	n1RowID := mustGetRowID(t, ctx.DB, "n1-uuid")
+
+	t.Run("unchanged", func(t *testing.T) {
+		mustCall(t, ctx, "update", updateParams{ID: n1RowID, Content: "# Array.map\n\nmaps an array"}, nil)
+
+		var dirty bool
+		database.MustScan(t, "getting the note", ctx.DB.QueryRow("SELECT dirty FROM notes WHERE uuid = ?", "n1-uuid"), &dirty)
+		assert.Equal(t, dirty, false, "dirty mismatch")
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		var result noteResult
+		mustCall(t, ctx, "update", updateParams{ID: n1RowID, Content: "# Array.map\n\nreturns a new array"}, &result)
+
+		assert.Equal(t, result.Content, "# Array.map\n\nreturns a new array", "content mismatch")
+
+		var dirty bool
+		database.MustScan(t, "getting the note", ctx.DB.QueryRow("SELECT dirty FROM notes WHERE uuid = ?", "n1-uuid"), &dirty)
+		assert.Equal(t, dirty, true, "dirty mismatch")
+	})
+
+	t.Run("nonexistent note", func(t *testing.T) {
+		assert.Equal(t, callError(t, ctx, "update", updateParams{ID: 100, Content: "foo"}), codeNotFound, "code mismatch")
+	})
+}
+
+func TestMove(t *testing.T) {
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupNotes(t, ctx.DB)
+	n1RowID := mustGetRowID(t, ctx.DB, "n1-uuid")
+
+	t.Run("existing book", func(t *testing.T) {
+		var result noteResult
+		mustCall(t, ctx, "move", moveParams{ID: n1RowID, Book: "js/react"}, &result)
+
+		assert.Equal(t, result.Book, "js/react", "book mismatch")
+
+		var bookUUID string
+		var dirty bool
+		database.MustScan(t, "getting the note", ctx.DB.QueryRow("SELECT book_uuid, dirty FROM notes WHERE uuid = ?", "n1-uuid"), &bookUUID, &dirty)
+		assert.Equal(t, bookUUID, "react-uuid", "book uuid mismatch")
+		assert.Equal(t, dirty, true, "dirty mismatch")
+	})
+
+	t.Run("nonexistent book", func(t *testing.T) {
+		assert.Equal(t, callError(t, ctx, "move", moveParams{ID: n1RowID, Book: "go"}), codeNotFound, "code mismatch")
+	})
+
+	t.Run("invalid book", func(t *testing.T) {
+		assert.Equal(t, callError(t, ctx, "move", moveParams{ID: n1RowID, Book: "has space"}), codeInvalidParams, "code mismatch")
+	})
+}
+
+func TestDelete(t *testing.T) {
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupNotes(t, ctx.DB)
+	n1RowID := mustGetRowID(t, ctx.DB, "n1-uuid")
+
+	mustCall(t, ctx, "delete", noteParams{ID: n1RowID}, nil)
+
+	var n1 database.Note
+	database.MustScan(t, "getting the note", ctx.DB.QueryRow("SELECT body, deleted, dirty FROM notes WHERE uuid = ?", "n1-uuid"), &n1.Body, &n1.Deleted, &n1.Dirty)
+	assert.Equal(t, n1.Body, "", "body mismatch")
+	assert.Equal(t, n1.Deleted, true, "deleted mismatch")
+	assert.Equal(t, n1.Dirty, true, "dirty mismatch")
+
+	assert.Equal(t, callError(t, ctx, "get", noteParams{ID: n1RowID}), codeNotFound, "code mismatch")
+}
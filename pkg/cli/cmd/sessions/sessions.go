@@ -0,0 +1,160 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sessions
+
+import (
+	"fmt"
+
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var revokeFlag string
+var revokeOthersFlag bool
+
+var example = `
+  * List the devices signed in to your account
+  dnote sessions
+
+  * Revoke a session by its id
+  dnote sessions --revoke 2cc6b2b2-a8e4-45d4-9c0b-bd1d9b5ca7ad
+
+  * Sign out of all devices except this one
+  dnote sessions --revoke-others`
+
+// NewCmd returns a new sessions command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "sessions",
+		Short:   "List and revoke the sessions of your account",
+		Example: example,
+		RunE:    newRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&revokeFlag, "revoke", "", "", "Revoke the session of the given id")
+	f.BoolVarP(&revokeOthersFlag, "revoke-others", "", false, "Revoke all sessions except for the session of this device")
+
+	return cmd
+}
+
+// formatSession returns a line describing the given session
+func formatSession(s client.RespSession) string {
+	deviceName := s.DeviceName
+	if deviceName == "" {
+		deviceName = "unknown device"
+	}
+
+	ret := fmt.Sprintf("%s %s", deviceName, log.ColorGray.Sprintf("(%s, %s)", s.ClientType, s.IPAddress))
+	if s.Current {
+		ret = fmt.Sprintf("%s %s", ret, log.ColorGreen.Sprint("[this device]"))
+	}
+
+	return ret
+}
+
+func listSessions(ctx context.DnoteCtx) error {
+	sessions, err := client.GetSessions(ctx)
+	if err != nil {
+		return errors.Wrap(err, "getting sessions")
+	}
+
+	for _, s := range sessions {
+		log.Plainf("%s\n", formatSession(s))
+		log.Plainf("  id: %s\n", s.UUID)
+		log.Plainf("  last used: %s\n", s.LastUsedAt.Local().Format("Jan 2, 2006 3:04pm (MST)"))
+	}
+
+	return nil
+}
+
+// findSession returns the session of the given uuid among the given sessions
+func findSession(sessions []client.RespSession, uuid string) (client.RespSession, bool) {
+	for _, s := range sessions {
+		if s.UUID == uuid {
+			return s, true
+		}
+	}
+
+	return client.RespSession{}, false
+}
+
+func revokeSession(ctx context.DnoteCtx, uuid string) error {
+	sessions, err := client.GetSessions(ctx)
+	if err != nil {
+		return errors.Wrap(err, "getting sessions")
+	}
+
+	s, ok := findSession(sessions, uuid)
+	if !ok {
+		return errors.Errorf("session %s not found", uuid)
+	}
+	if s.Current {
+		return errors.New("the session is used by this device. Please use 'dnote logout' instead")
+	}
+
+	if err := client.RevokeSession(ctx, uuid); err != nil {
+		return errors.Wrap(err, "revoking the session")
+	}
+
+	log.Successf("revoked the session of %s\n", formatSession(s))
+
+	return nil
+}
+
+func revokeOtherSessions(ctx context.DnoteCtx) error {
+	ok, err := ui.Confirm("sign out of all other devices?", false)
+	if err != nil {
+		return errors.Wrap(err, "getting confirmation")
+	}
+	if !ok {
+		log.Warnf("aborted by user\n")
+		return nil
+	}
+
+	if err := client.RevokeOtherSessions(ctx); err != nil {
+		return errors.Wrap(err, "revoking sessions")
+	}
+
+	log.Success("signed out of all other devices\n")
+
+	return nil
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := infra.RequireSession(&ctx); err != nil {
+			return err
+		}
+
+		if revokeFlag != "" {
+			return revokeSession(ctx, revokeFlag)
+		}
+		if revokeOthersFlag {
+			return revokeOtherSessions(ctx)
+		}
+
+		return listSessions(ctx)
+	}
+}
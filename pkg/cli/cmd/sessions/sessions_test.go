@@ -0,0 +1,99 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sessions
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/pkg/errors"
+)
+
+const (
+	currentUUID = "2cc6b2b2-a8e4-45d4-9c0b-bd1d9b5ca7ad"
+	otherUUID   = "a9f4e0e5-3f1b-4e8c-8d6a-2f2a6c1d8b3e"
+)
+
+// newSessionsServer returns a test server that responds with two sessions, and
+// records the uuids of the sessions revoked with it
+func newSessionsServer(t *testing.T, revoked *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.URL.Path == "/v3/sessions" {
+			fmt.Fprintf(w, `[{"uuid": "%s", "device_name": "laptop", "client_type": "cli", "current": true},
+{"uuid": "%s", "device_name": "desktop", "client_type": "web", "current": false}]`, currentUUID, otherUUID)
+			return
+		}
+		if r.Method == "DELETE" {
+			*revoked = append(*revoked, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		t.Fatalf("unexpected request %s %s", r.Method, r.URL.Path)
+	}))
+}
+
+func TestRevokeSession(t *testing.T) {
+	// set up
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	var revoked []string
+	ts := newSessionsServer(t, &revoked)
+	defer ts.Close()
+	ctx.APIEndpoint = ts.URL
+	ctx.SessionKey = "someKey"
+
+	// execute
+	if err := revokeSession(ctx, otherUUID); err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// test
+	assert.DeepEqual(t, revoked, []string{fmt.Sprintf("/v3/sessions/%s", otherUUID)}, "revoked mismatch")
+}
+
+func TestRevokeSession_invalid(t *testing.T) {
+	testCases := []string{currentUUID, "c1c2a2ab-6b0e-4d3c-9f5e-0a9c2b9d0f11"}
+
+	for _, tc := range testCases {
+		t.Run(tc, func(t *testing.T) {
+			// set up
+			ctx := context.InitTestCtx(t, "../../tmp", nil)
+			defer context.TeardownTestCtx(t, ctx)
+
+			var revoked []string
+			ts := newSessionsServer(t, &revoked)
+			defer ts.Close()
+			ctx.APIEndpoint = ts.URL
+			ctx.SessionKey = "someKey"
+
+			// execute
+			err := revokeSession(ctx, tc)
+
+			// test
+			assert.NotEqual(t, err, nil, "error should not be nil")
+			assert.Equal(t, len(revoked), 0, "no session should be revoked")
+		})
+	}
+}
@@ -0,0 +1,162 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package share
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var expiresFlag string
+
+var example = `
+  * Share a note by id
+  dnote share 3
+
+  * Share a note with a link that expires in 7 days
+  dnote share 3 --expires 7d`
+
+// NewCmd returns a new share command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "share <note id>",
+		Short:   "Share a note with a public link",
+		Example: example,
+		PreRunE: preRun,
+		RunE:    newRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&expiresFlag, "expires", "e", "", "Duration after which the link expires (e.g. 12h, 7d)")
+
+	return cmd
+}
+
+func preRun(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("Incorrect number of argument")
+	}
+
+	return nil
+}
+
+// parseExpires parses a duration string into a unix timestamp in seconds
+// relative to the given time. In addition to the units supported by
+// time.ParseDuration, it supports days with the "d" suffix.
+func parseExpires(val string, now time.Time) (*int64, error) {
+	if val == "" {
+		return nil, nil
+	}
+
+	var d time.Duration
+	if strings.HasSuffix(val, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(val, "d"))
+		if err != nil {
+			return nil, errors.Errorf("invalid duration %s", val)
+		}
+
+		d = time.Duration(days) * 24 * time.Hour
+	} else {
+		var err error
+		d, err = time.ParseDuration(val)
+		if err != nil {
+			return nil, errors.Errorf("invalid duration %s", val)
+		}
+	}
+
+	if d <= 0 {
+		return nil, errors.Errorf("invalid duration %s", val)
+	}
+
+	ret := now.Add(d).Unix()
+	return &ret, nil
+}
+
+// GetSyncedNote returns the note with the given id if it exists in the server.
+// Notes that have never been synced cannot be shared.
+func GetSyncedNote(ctx context.DnoteCtx, rowIDArg string) (database.NoteInfo, error) {
+	noteRowID, err := strconv.Atoi(rowIDArg)
+	if err != nil {
+		return database.NoteInfo{}, errors.Wrap(err, "invalid rowid")
+	}
+
+	noteInfo, err := database.GetNoteInfo(ctx.DB, noteRowID)
+	if err != nil {
+		return noteInfo, err
+	}
+	if noteInfo.USN == 0 {
+		return noteInfo, errors.New("the note has not been synced yet. Please run `dnote sync` first")
+	}
+
+	return noteInfo, nil
+}
+
+// SetPublic updates the local copy of the note with the given sharing state
+// without marking it dirty, because the change has already been made in the server.
+func SetPublic(ctx context.DnoteCtx, uuid string, public bool) error {
+	if _, err := ctx.DB.Exec("UPDATE notes SET public = ? WHERE uuid = ?", public, uuid); err != nil {
+		return errors.Wrap(err, "updating the note")
+	}
+
+	return nil
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := infra.RequireSession(&ctx); err != nil {
+			return err
+		}
+
+		expiresAt, err := parseExpires(expiresFlag, ctx.Clock.Now())
+		if err != nil {
+			return errors.Wrap(err, "parsing the expires flag")
+		}
+
+		noteInfo, err := GetSyncedNote(ctx, args[0])
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.ShareNote(ctx, noteInfo.UUID, expiresAt)
+		if err != nil {
+			return errors.Wrap(err, "sharing the note")
+		}
+
+		if err := SetPublic(ctx, noteInfo.UUID, true); err != nil {
+			return err
+		}
+
+		log.Successf("shared the note %d\n", noteInfo.RowID)
+		if resp.Result.ExpiresAt != nil {
+			log.Infof("expires at: %s\n", resp.Result.ExpiresAt.Local().Format("Jan 2, 2006 3:04pm (MST)"))
+		}
+		log.Plainf("%s\n", client.GetPublicNoteURL(ctx, noteInfo.UUID))
+
+		return nil
+	}
+}
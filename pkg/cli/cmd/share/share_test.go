@@ -0,0 +1,81 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package share
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+)
+
+func TestParseExpires(t *testing.T) {
+	now := time.Date(2019, time.June, 1, 10, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		input    string
+		expected int64
+	}{
+		{
+			input:    "12h",
+			expected: now.Add(12 * time.Hour).Unix(),
+		},
+		{
+			input:    "30m",
+			expected: now.Add(30 * time.Minute).Unix(),
+		},
+		{
+			input:    "7d",
+			expected: now.Add(7 * 24 * time.Hour).Unix(),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("input %s", tc.input), func(t *testing.T) {
+			got, err := parseExpires(tc.input, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, *got, tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestParseExpires_Empty(t *testing.T) {
+	got, err := parseExpires("", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, got, (*int64)(nil), "result mismatch")
+}
+
+func TestParseExpires_Invalid(t *testing.T) {
+	testCases := []string{"foo", "d", "-1d", "0d", "-3h", "7 days"}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("input %s", tc), func(t *testing.T) {
+			_, err := parseExpires(tc, time.Now())
+
+			assert.NotEqual(t, err, nil, "error should not be nil")
+		})
+	}
+}
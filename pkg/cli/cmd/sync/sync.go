@@ -205,10 +205,10 @@ func resolveLabel(tx *database.DB, label string) (string, error) {
 }
 
 // mergeBook inserts or updates the given book in the local database.
-// If a book with a duplicate label exists locally, it renames the duplicate by appending a number.
+// If a book with a duplicate label exists locally in the same parent, it renames the duplicate by appending a number.
 func mergeBook(tx *database.DB, b client.SyncFragBook, mode int) error {
 	var count int
-	if err := tx.QueryRow("SELECT count(*) FROM books WHERE label = ?", b.Label).Scan(&count); err != nil {
+	if err := tx.QueryRow("SELECT count(*) FROM books WHERE label = ? AND parent_uuid = ?", b.Label, b.ParentUUID).Scan(&count); err != nil {
 		return errors.Wrapf(err, "checking for books with a duplicate label %s", b.Label)
 	}
 
@@ -219,20 +219,21 @@ func mergeBook(tx *database.DB, b client.SyncFragBook, mode int) error {
 			return errors.Wrap(err, "getting a new book label for conflict resolution")
 		}
 
-		if _, err := tx.Exec("UPDATE books SET label = ?, dirty = ? WHERE label = ?", newLabel, true, b.Label); err != nil {
+		if _, err := tx.Exec("UPDATE books SET label = ?, dirty = ? WHERE label = ? AND parent_uuid = ?", newLabel, true, b.Label, b.ParentUUID); err != nil {
 			return errors.Wrap(err, "resolving duplicate book label")
 		}
 	}
 
 	if mode == modeInsert {
 		book := database.NewBook(b.UUID, b.Label, b.USN, false, false)
+		book.ParentUUID = b.ParentUUID
 		if err := book.Insert(tx); err != nil {
 			return errors.Wrapf(err, "inserting note with uuid %s", b.UUID)
 		}
 	} else if mode == modeUpdate {
 		// The state from the server overwrites the local state. In other words, the server change always wins.
-		if _, err := tx.Exec("UPDATE books SET usn = ?, uuid = ?, label = ?, deleted = ? WHERE uuid = ?",
-			b.USN, b.UUID, b.Label, b.Deleted, b.UUID); err != nil {
+		if _, err := tx.Exec("UPDATE books SET usn = ?, uuid = ?, label = ?, parent_uuid = ?, deleted = ? WHERE uuid = ?",
+			b.USN, b.UUID, b.Label, b.ParentUUID, b.Deleted, b.UUID); err != nil {
 			return errors.Wrapf(err, "updating local book %s", b.UUID)
 		}
 	}
@@ -282,6 +283,9 @@ func mergeNote(tx *database.DB, serverNote client.SyncFragNote, localNote databa
 			serverNote.USN, serverNote.BookUUID, serverNote.Body, serverNote.EditedOn, serverNote.Deleted, serverNote.Public, false, serverNote.UUID); err != nil {
 			return errors.Wrapf(err, "updating local note %s", serverNote.UUID)
 		}
+		if err := database.UpdateNoteLinks(tx, serverNote.UUID, serverNote.Body); err != nil {
+			return errors.Wrapf(err, "updating links of local note %s", serverNote.UUID)
+		}
 
 		return nil
 	}
@@ -291,10 +295,36 @@ func mergeNote(tx *database.DB, serverNote client.SyncFragNote, localNote databa
 		return errors.Wrapf(err, "reporting note conflict for note %s", localNote.UUID)
 	}
 
-	if _, err := tx.Exec("UPDATE notes SET usn = ?, book_uuid = ?, body = ?, edited_on = ?, deleted = ?  WHERE uuid = ?",
-		serverNote.USN, mr.bookUUID, mr.body, mr.editedOn, serverNote.Deleted, serverNote.UUID); err != nil {
+	// the sharing state of a note is only changed in the server, so always accept the server value
+	if _, err := tx.Exec("UPDATE notes SET usn = ?, book_uuid = ?, body = ?, edited_on = ?, deleted = ?, public = ?  WHERE uuid = ?",
+		serverNote.USN, mr.bookUUID, mr.body, mr.editedOn, serverNote.Deleted, serverNote.Public, serverNote.UUID); err != nil {
 		return errors.Wrapf(err, "updating local note %s", serverNote.UUID)
 	}
+	if err := database.UpdateNoteLinks(tx, serverNote.UUID, mr.body); err != nil {
+		return errors.Wrapf(err, "updating links of local note %s", serverNote.UUID)
+	}
+
+	return nil
+}
+
+// syncAttachments replaces the local metadata of the attachments of the note with
+// the server copy. Attachments are only changed in the server.
+func syncAttachments(tx *database.DB, n client.SyncFragNote) error {
+	attachments := []database.Attachment{}
+	for _, a := range n.Attachments {
+		attachments = append(attachments, database.Attachment{
+			UUID:        a.UUID,
+			NoteUUID:    n.UUID,
+			Name:        a.Name,
+			ContentType: a.ContentType,
+			Size:        a.Size,
+			Hash:        a.Hash,
+		})
+	}
+
+	if err := database.ReplaceNoteAttachments(tx, n.UUID, attachments); err != nil {
+		return errors.Wrapf(err, "replacing attachments of note %s", n.UUID)
+	}
 
 	return nil
 }
@@ -320,6 +350,10 @@ func stepSyncNote(tx *database.DB, n client.SyncFragNote) error {
 		}
 	}
 
+	if err := syncAttachments(tx, n); err != nil {
+		return errors.Wrap(err, "syncing attachments")
+	}
+
 	return nil
 }
 
@@ -344,6 +378,10 @@ func fullSyncNote(tx *database.DB, n client.SyncFragNote) error {
 		}
 	}
 
+	if err := syncAttachments(tx, n); err != nil {
+		return errors.Wrap(err, "syncing attachments")
+	}
+
 	return nil
 }
 
@@ -366,6 +404,12 @@ func syncDeleteNote(tx *database.DB, noteUUID string) error {
 		if err != nil {
 			return errors.Wrapf(err, "deleting local note %s", noteUUID)
 		}
+		if err := database.DeleteNoteAttachments(tx, noteUUID); err != nil {
+			return errors.Wrapf(err, "deleting attachments of local note %s", noteUUID)
+		}
+		if err := database.DeleteNoteLinks(tx, noteUUID); err != nil {
+			return errors.Wrapf(err, "deleting links of local note %s", noteUUID)
+		}
 	}
 
 	return nil
@@ -632,16 +676,35 @@ func stepSync(ctx context.DnoteCtx, tx *database.DB, afterUSN int) error {
 func sendBooks(ctx context.DnoteCtx, tx *database.DB) (bool, error) {
 	isBehind := false
 
-	rows, err := tx.Query("SELECT uuid, label, usn, deleted FROM books WHERE dirty")
+	// send the parent books first so that the server knows the parent of a new book.
+	rows, err := tx.Query(database.BookPaths + ` SELECT books.uuid
+		FROM books
+		INNER JOIN book_paths ON book_paths.uuid = books.uuid
+		WHERE books.dirty
+		ORDER BY book_paths.depth ASC`)
 	if err != nil {
 		return isBehind, errors.Wrap(err, "getting syncable books")
 	}
-	defer rows.Close()
 
+	var uuids []string
 	for rows.Next() {
+		var uuid string
+		if err = rows.Scan(&uuid); err != nil {
+			rows.Close()
+			return isBehind, errors.Wrap(err, "scanning a syncable book")
+		}
+
+		uuids = append(uuids, uuid)
+	}
+	rows.Close()
+
+	for _, uuid := range uuids {
 		var book database.Book
 
-		if err = rows.Scan(&book.UUID, &book.Label, &book.USN, &book.Deleted); err != nil {
+		// read the book after the previous books are sent, because the uuid of
+		// the parent changes if the parent is created in the server.
+		if err = tx.QueryRow("SELECT uuid, label, parent_uuid, usn, deleted FROM books WHERE uuid = ?", uuid).
+			Scan(&book.UUID, &book.Label, &book.ParentUUID, &book.USN, &book.Deleted); err != nil {
 			return isBehind, errors.Wrap(err, "scanning a syncable book")
 		}
 
@@ -659,7 +722,7 @@ func sendBooks(ctx context.DnoteCtx, tx *database.DB) (bool, error) {
 
 				continue
 			} else {
-				resp, err := client.CreateBook(ctx, book.Label)
+				resp, err := client.CreateBook(ctx, book.Label, book.ParentUUID)
 				if err != nil {
 					return isBehind, errors.Wrap(err, "creating a book")
 				}
@@ -694,10 +757,14 @@ func sendBooks(ctx context.DnoteCtx, tx *database.DB) (bool, error) {
 				if err != nil {
 					return isBehind, errors.Wrap(err, "expunging a book locally")
 				}
+				err = expungeDeletedNotes(tx, book.UUID)
+				if err != nil {
+					return isBehind, errors.Wrap(err, "expunging the notes of the book locally")
+				}
 
 				respUSN = resp.Book.USN
 			} else {
-				resp, err := client.UpdateBook(ctx, book.Label, book.UUID)
+				resp, err := client.UpdateBook(ctx, book.Label, book.ParentUUID, book.UUID)
 				if err != nil {
 					return isBehind, errors.Wrap(err, "updating a book")
 				}
@@ -733,6 +800,39 @@ func sendBooks(ctx context.DnoteCtx, tx *database.DB) (bool, error) {
 	return isBehind, nil
 }
 
+// expungeDeletedNotes expunges the deleted notes in the book with the given uuid.
+// It is called after the book is deleted in the server, which deletes the notes in
+// the book, or removes the user from the members of the book if it is shared by
+// another user, in which case the notes can no longer be deleted by the user.
+func expungeDeletedNotes(tx *database.DB, bookUUID string) error {
+	rows, err := tx.Query("SELECT uuid FROM notes WHERE book_uuid = ? AND deleted", bookUUID)
+	if err != nil {
+		return errors.Wrap(err, "querying deleted notes")
+	}
+	defer rows.Close()
+
+	notes := []database.Note{}
+	for rows.Next() {
+		var note database.Note
+		if err := rows.Scan(&note.UUID); err != nil {
+			return errors.Wrap(err, "scanning a deleted note")
+		}
+
+		notes = append(notes, note)
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "iterating deleted notes")
+	}
+
+	for _, note := range notes {
+		if err := note.Expunge(tx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func sendNotes(ctx context.DnoteCtx, tx *database.DB) (bool, error) {
 	isBehind := false
 
@@ -887,8 +987,8 @@ func saveSyncState(tx *database.DB, serverTime int64, serverMaxUSN int) error {
 
 func newRun(ctx context.DnoteCtx) infra.RunEFunc {
 	return func(cmd *cobra.Command, args []string) error {
-		if ctx.SessionKey == "" {
-			return errors.New("not logged in")
+		if err := infra.RequireSession(&ctx); err != nil {
+			return err
 		}
 
 		if err := migrate.Run(ctx, migrate.RemoteSequence, migrate.RemoteMode); err != nil {
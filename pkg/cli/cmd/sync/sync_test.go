@@ -1897,6 +1897,8 @@ func TestSendBooks(t *testing.T) {
 	database.MustExec(t, "inserting n5", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n5-uuid", "b3-uuid", 10, "n5 body", 1541108743, false, false)
 	database.MustExec(t, "inserting n6", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n6-uuid", "b3-uuid", 10, "n6 body", 1541108743, false, false)
 	database.MustExec(t, "inserting n7", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n7-uuid", "b4-uuid", 10, "n7 body", 1541108743, false, false)
+	// a note deleted along with the deleted book. It should be expunged.
+	database.MustExec(t, "inserting n8", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n8-uuid", "b6-uuid", 10, "", 1541108743, true, true)
 
 	var createdLabels []string
 	var updatesUUIDs []string
@@ -2019,6 +2021,85 @@ func TestSendBooks(t *testing.T) {
 	assert.Equal(t, n5.BookUUID, "server-b3-label-uuid", "n5 bookUUID mismatch")
 	assert.Equal(t, n6.BookUUID, "server-b3-label-uuid", "n6 bookUUID mismatch")
 	assert.Equal(t, n7.BookUUID, "server-b4-label-uuid", "n7 bookUUID mismatch")
+
+	var n8Count int
+	database.MustScan(t, "counting n8", db.QueryRow("SELECT count(*) FROM notes WHERE uuid = ?", "n8-uuid"), &n8Count)
+	assert.Equal(t, n8Count, 0, "n8 should have been expunged")
+}
+
+func TestSendBooks_nested(t *testing.T) {
+	// set up
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+	testutils.Login(t, &ctx)
+
+	db := ctx.DB
+
+	database.MustExec(t, "inserting last max usn", db, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemLastMaxUSN, 0)
+	// inserted in the reverse order so that the child would be sent first if not ordered by depth
+	database.MustExec(t, "inserting b3", db, "INSERT INTO books (uuid, label, parent_uuid, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?)", "b3-uuid", "b3-label", "b2-uuid", 0, false, true)
+	database.MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label, parent_uuid, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?)", "b2-uuid", "b2-label", "b1-uuid", 0, false, true)
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, parent_uuid, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?)", "b1-uuid", "b1-label", "", 0, false, true)
+
+	var payloads []client.CreateBookPayload
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.String() == "/v3/books" && r.Method == "POST" {
+			var payload client.CreateBookPayload
+
+			err := json.NewDecoder(r.Body).Decode(&payload)
+			if err != nil {
+				t.Fatalf(errors.Wrap(err, "decoding payload in the test server").Error())
+				return
+			}
+
+			payloads = append(payloads, payload)
+
+			resp := client.CreateBookResp{
+				Book: client.RespBook{
+					UUID: fmt.Sprintf("server-%s-uuid", payload.Name),
+				},
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(resp); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+
+		t.Fatalf("unrecognized endpoint reached Method: %s Path: %s", r.Method, r.URL.Path)
+	}))
+	defer ts.Close()
+
+	ctx.APIEndpoint = ts.URL
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+	}
+
+	if _, err := sendBooks(ctx, tx); err != nil {
+		tx.Rollback()
+		t.Fatalf(errors.Wrap(err, "executing").Error())
+	}
+
+	tx.Commit()
+
+	// test
+	assert.DeepEqual(t, payloads, []client.CreateBookPayload{
+		{Name: "b1-label", ParentUUID: ""},
+		{Name: "b2-label", ParentUUID: "server-b1-label-uuid"},
+		{Name: "b3-label", ParentUUID: "server-b2-label-uuid"},
+	}, "payloads mismatch")
+
+	var b2, b3 database.Book
+	database.MustScan(t, "getting b2", db.QueryRow("SELECT uuid, parent_uuid FROM books WHERE label = ?", "b2-label"), &b2.UUID, &b2.ParentUUID)
+	database.MustScan(t, "getting b3", db.QueryRow("SELECT uuid, parent_uuid FROM books WHERE label = ?", "b3-label"), &b3.UUID, &b3.ParentUUID)
+	assert.Equal(t, b2.ParentUUID, "server-b1-label-uuid", "b2 ParentUUID mismatch")
+	assert.Equal(t, b3.ParentUUID, "server-b2-label-uuid", "b3 ParentUUID mismatch")
 }
 
 func TestSendBooks_isBehind(t *testing.T) {
@@ -3172,3 +3253,136 @@ func TestCleanLocalBooks(t *testing.T) {
 	database.MustScan(t, "getting b3", db.QueryRow("SELECT label FROM books WHERE uuid = ?", "b3-uuid"), &b3.Label)
 	database.MustScan(t, "getting b5", db.QueryRow("SELECT label FROM books WHERE uuid = ?", "b5-uuid"), &b5.Label)
 }
+
+func TestMergeNote_Public(t *testing.T) {
+	b1UUID := "b1-uuid"
+
+	testCases := []struct {
+		clientPublic bool
+		serverPublic bool
+	}{
+		{
+			clientPublic: false,
+			serverPublic: true,
+		},
+		{
+			clientPublic: true,
+			serverPublic: false,
+		},
+	}
+
+	for idx, tc := range testCases {
+		func() {
+			// set up
+			db := database.InitTestDB(t, "../../tmp/.dnote", nil)
+			defer database.CloseTestDB(t, db)
+
+			database.MustExec(t, fmt.Sprintf("inserting b1 for test case %d", idx), db, "INSERT INTO books (uuid, label, usn, dirty) VALUES (?, ?, ?, ?)", b1UUID, "b1-label", 5, false)
+			n1UUID := testutils.MustGenerateUUID(t)
+			database.MustExec(t, fmt.Sprintf("inserting n1 for test case %d", idx), db, "INSERT INTO notes (uuid, book_uuid, usn, added_on, edited_on, body, public, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", n1UUID, b1UUID, 1, 1541232118, 1541219321, "n1 body edited", tc.clientPublic, false, true)
+
+			// execute
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatalf(errors.Wrap(err, fmt.Sprintf("beginning a transaction for test case %d", idx)).Error())
+			}
+
+			fragNote := client.SyncFragNote{
+				UUID:     n1UUID,
+				BookUUID: b1UUID,
+				USN:      21,
+				AddedOn:  1541232118,
+				EditedOn: 1541219321,
+				Body:     "n1 body edited",
+				Public:   tc.serverPublic,
+				Deleted:  false,
+			}
+			var localNote database.Note
+			database.MustScan(t, fmt.Sprintf("getting localNote for test case %d", idx),
+				db.QueryRow("SELECT uuid, book_uuid, usn, added_on, edited_on, body, deleted, dirty FROM notes WHERE uuid = ?", n1UUID),
+				&localNote.UUID, &localNote.BookUUID, &localNote.USN, &localNote.AddedOn, &localNote.EditedOn, &localNote.Body, &localNote.Deleted, &localNote.Dirty)
+
+			if err := mergeNote(tx, fragNote, localNote); err != nil {
+				tx.Rollback()
+				t.Fatalf(errors.Wrap(err, fmt.Sprintf("executing for test case %d", idx)).Error())
+			}
+
+			tx.Commit()
+
+			// test
+			var n1Record database.Note
+			database.MustScan(t, fmt.Sprintf("getting n1Record for test case %d", idx),
+				db.QueryRow("SELECT usn, public, dirty FROM notes WHERE uuid = ?", n1UUID),
+				&n1Record.USN, &n1Record.Public, &n1Record.Dirty)
+
+			assert.Equal(t, n1Record.USN, 21, fmt.Sprintf("n1Record USN mismatch for test case %d", idx))
+			assert.Equal(t, n1Record.Public, tc.serverPublic, fmt.Sprintf("n1Record Public mismatch for test case %d", idx))
+			assert.Equal(t, n1Record.Dirty, true, fmt.Sprintf("n1Record Dirty mismatch for test case %d", idx))
+		}()
+	}
+}
+
+func TestStepSyncNote_Attachments(t *testing.T) {
+	// set up
+	db := database.InitTestDB(t, dbPath, nil)
+	defer database.CloseTestDB(t, db)
+
+	b1UUID := testutils.MustGenerateUUID(t)
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", b1UUID, "b1-label")
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, usn, body, added_on, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?)", "n1-uuid", b1UUID, 10, "n1 body", 1541108743, false, false)
+	database.MustExec(t, "inserting a stale attachment", db, "INSERT INTO attachments (uuid, note_uuid, name, content_type, size, hash) VALUES (?, ?, ?, ?, ?, ?)", "a0-uuid", "n1-uuid", "old.png", "image/png", 1, "h0")
+
+	n := client.SyncFragNote{
+		UUID:     "n1-uuid",
+		BookUUID: b1UUID,
+		USN:      11,
+		AddedOn:  1541108743,
+		Body:     "n1 body",
+		Attachments: []client.SyncFragAttachment{
+			{UUID: "a1-uuid", Name: "diagram.png", ContentType: "image/png", Size: 42, Hash: "h1"},
+			{UUID: "a2-uuid", Name: "notes.txt", ContentType: "text/plain", Size: 7, Hash: "h2"},
+		},
+	}
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+	}
+
+	if err := stepSyncNote(tx, n); err != nil {
+		tx.Rollback()
+		t.Fatalf(errors.Wrap(err, "executing").Error())
+	}
+
+	tx.Commit()
+
+	// test
+	attachments, err := database.GetNoteAttachments(db, "n1-uuid")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting attachments"))
+	}
+
+	assert.DeepEqual(t, attachments, []database.Attachment{
+		{UUID: "a1-uuid", NoteUUID: "n1-uuid", Name: "diagram.png", ContentType: "image/png", Size: 42, Hash: "h1"},
+		{UUID: "a2-uuid", NoteUUID: "n1-uuid", Name: "notes.txt", ContentType: "text/plain", Size: 7, Hash: "h2"},
+	}, "attachments mismatch")
+
+	// execute
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatalf(errors.Wrap(err, "beginning a transaction").Error())
+	}
+
+	if err := syncDeleteNote(tx, "n1-uuid"); err != nil {
+		tx.Rollback()
+		t.Fatalf(errors.Wrap(err, "executing").Error())
+	}
+
+	tx.Commit()
+
+	// test
+	var attachmentCount int
+	database.MustScan(t, "counting attachments", db.QueryRow("SELECT count(*) FROM attachments"), &attachmentCount)
+	assert.Equal(t, attachmentCount, 0, "attachment count mismatch")
+}
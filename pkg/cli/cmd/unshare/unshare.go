@@ -0,0 +1,79 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package unshare
+
+import (
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/cmd/share"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var example = `
+  * Revoke the public link of a note by id
+  dnote unshare 3`
+
+// NewCmd returns a new unshare command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "unshare <note id>",
+		Short:   "Revoke the public link of a note",
+		Example: example,
+		PreRunE: preRun,
+		RunE:    newRun(ctx),
+	}
+
+	return cmd
+}
+
+func preRun(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return errors.New("Incorrect number of argument")
+	}
+
+	return nil
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := infra.RequireSession(&ctx); err != nil {
+			return err
+		}
+
+		noteInfo, err := share.GetSyncedNote(ctx, args[0])
+		if err != nil {
+			return err
+		}
+
+		if _, err := client.UnshareNote(ctx, noteInfo.UUID); err != nil {
+			return errors.Wrap(err, "unsharing the note")
+		}
+
+		if err := share.SetPublic(ctx, noteInfo.UUID, false); err != nil {
+			return err
+		}
+
+		log.Successf("revoked the public link of the note %d\n", noteInfo.RowID)
+
+		return nil
+	}
+}
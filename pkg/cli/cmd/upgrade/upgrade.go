@@ -0,0 +1,116 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package upgrade
+
+import (
+	"fmt"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/cli/ui"
+	"github.com/dnote/dnote/pkg/cli/upgrade"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var versionFlag string
+var sourceFlag string
+var yesFlag bool
+
+var example = `
+  * Upgrade to the latest version
+  dnote upgrade
+
+  * Upgrade to a specific version
+  dnote upgrade --version 0.10.1
+
+  * Upgrade from a mirror of the releases
+  dnote upgrade --source https://mirror.example.com/dnote`
+
+// NewCmd returns a new upgrade command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "upgrade",
+		Short:   "Upgrade dnote to the latest version",
+		Example: example,
+		RunE:    newRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&versionFlag, "version", "", "", "version to upgrade to, instead of the latest version")
+	f.StringVarP(&sourceFlag, "source", "", "", "URL of the release source, instead of the configured one")
+	f.BoolVarP(&yesFlag, "yes", "y", false, "upgrade without confirmation")
+
+	return cmd
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		if sourceFlag != "" {
+			ctx.ReleaseSource = sourceFlag
+		}
+		source := upgrade.GetSource(ctx)
+
+		log.Infof("current version is %s\n", ctx.Version)
+
+		version := versionFlag
+		if version == "" {
+			v, err := upgrade.GetLatestVersion(source)
+			if err != nil {
+				return errors.Wrap(err, "getting the latest version")
+			}
+
+			version = v
+			log.Infof("latest version is %s\n", version)
+		}
+
+		if version == ctx.Version {
+			log.Success("you are up-to-date\n")
+			return nil
+		}
+
+		binaryPath, err := upgrade.GetBinaryPath()
+		if err != nil {
+			return errors.Wrap(err, "getting the binary path")
+		}
+
+		if !yesFlag {
+			question := fmt.Sprintf("replace %s with version %s?", binaryPath, version)
+			ok, err := ui.Confirm(question, true)
+			if err != nil {
+				return errors.Wrap(err, "getting confirmation")
+			}
+			if !ok {
+				log.Warnf("aborted by user\n")
+				return nil
+			}
+		}
+
+		release := upgrade.NewRelease(ctx, version)
+		log.Infof("downloading %s from %s\n", version, source)
+		if err := release.Install(binaryPath); err != nil {
+			return errors.Wrap(err, "upgrading")
+		}
+
+		log.Successf("upgraded to %s\n", version)
+
+		return nil
+	}
+}
@@ -0,0 +1,321 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"strings"
+)
+
+// language is the syntax of a programming language for highlighting code
+type language struct {
+	keywords map[string]bool
+	// ignoreCase makes the keywords case insensitive
+	ignoreCase   bool
+	lineComments []string
+	blockComment []string
+	// quotes are the characters that quote strings. A string quoted with a
+	// backtick can span lines.
+	quotes string
+	// tripleQuotes allows strings quoted with three quotes, which can span lines
+	tripleQuotes bool
+}
+
+func words(s string) map[string]bool {
+	ret := map[string]bool{}
+	for _, w := range strings.Fields(s) {
+		ret[w] = true
+	}
+
+	return ret
+}
+
+const jsKeywords = `async await break case catch class const continue debugger default delete do
+	else export extends false finally for from function get if import in instanceof let new
+	null of return set static super switch this throw true try typeof undefined var void while
+	with yield`
+
+var (
+	langGo = &language{
+		keywords: words(`break case chan const continue default defer else fallthrough false for
+			func go goto if import interface iota map nil package range return select struct switch
+			true type var`),
+		lineComments: []string{"//"},
+		blockComment: []string{"/*", "*/"},
+		quotes:       "\"'`",
+	}
+	langJS = &language{
+		keywords:     words(jsKeywords),
+		lineComments: []string{"//"},
+		blockComment: []string{"/*", "*/"},
+		quotes:       "\"'`",
+	}
+	langTS = &language{
+		keywords: words(jsKeywords + ` abstract any as boolean declare enum implements interface
+			keyof namespace never number private protected public readonly string type unknown`),
+		lineComments: []string{"//"},
+		blockComment: []string{"/*", "*/"},
+		quotes:       "\"'`",
+	}
+	langPython = &language{
+		keywords: words(`False None True and as assert async await break class continue def del
+			elif else except finally for from global if import in is lambda nonlocal not or pass
+			raise return self try while with yield`),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+		tripleQuotes: true,
+	}
+	langRuby = &language{
+		keywords: words(`alias and begin break case class def do else elsif end ensure false for if
+			in module next nil not or redo require rescue retry return self super then true undef
+			unless until when while yield`),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+	}
+	langShell = &language{
+		keywords: words(`alias case cd do done echo elif else esac exit export fi for function if in
+			local read readonly return set shift source then unset until while`),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+	}
+	langSQL = &language{
+		keywords: words(`add all alter and as asc begin between by case check commit create default
+			delete desc distinct drop else end exists foreign from group having if in index inner
+			insert into is join key left like limit not null offset on or order outer primary
+			references returning right rollback select set table then transaction trigger union
+			unique update values view when where with`),
+		ignoreCase:   true,
+		lineComments: []string{"--"},
+		blockComment: []string{"/*", "*/"},
+		quotes:       "'\"",
+	}
+	langC = &language{
+		keywords: words(`abstract auto bool boolean break byte case catch char class const continue
+			default delete do double else enum extends extern false final float for goto if
+			implements import int interface long namespace new null nullptr package private
+			protected public register return short signed sizeof static struct super switch
+			template this throw true try typedef union unsigned using virtual void volatile while`),
+		lineComments: []string{"//"},
+		blockComment: []string{"/*", "*/"},
+		quotes:       "\"'",
+	}
+	langRust = &language{
+		keywords: words(`as async await break const continue crate dyn else enum extern false fn for
+			if impl in let loop match mod move mut pub ref return self Self static struct super
+			trait true type unsafe use where while`),
+		lineComments: []string{"//"},
+		blockComment: []string{"/*", "*/"},
+		quotes:       "\"",
+	}
+	langYAML = &language{
+		keywords:     words("true false null yes no on off"),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+	}
+	langJSON = &language{
+		keywords: words("true false null"),
+		quotes:   "\"",
+	}
+)
+
+// languages are the languages of code blocks that are highlighted, by the name
+// in the info string of a fenced code block
+var languages = map[string]*language{
+	"go":         langGo,
+	"golang":     langGo,
+	"js":         langJS,
+	"javascript": langJS,
+	"jsx":        langJS,
+	"mjs":        langJS,
+	"ts":         langTS,
+	"typescript": langTS,
+	"tsx":        langTS,
+	"py":         langPython,
+	"python":     langPython,
+	"python3":    langPython,
+	"rb":         langRuby,
+	"ruby":       langRuby,
+	"sh":         langShell,
+	"bash":       langShell,
+	"shell":      langShell,
+	"zsh":        langShell,
+	"console":    langShell,
+	"sql":        langSQL,
+	"sqlite":     langSQL,
+	"postgresql": langSQL,
+	"mysql":      langSQL,
+	"c":          langC,
+	"h":          langC,
+	"cpp":        langC,
+	"c++":        langC,
+	"cs":         langC,
+	"csharp":     langC,
+	"java":       langC,
+	"kotlin":     langC,
+	"rs":         langRust,
+	"rust":       langRust,
+	"yaml":       langYAML,
+	"yml":        langYAML,
+	"json":       langJSON,
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$'
+}
+
+func isIdent(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
+
+func writeToken(b *strings.Builder, class, s string) {
+	b.WriteString(`<span class="hl-` + class + `">`)
+	for i := 0; i < len(s); i++ {
+		writeEscaped(b, s[i])
+	}
+	b.WriteString("</span>")
+}
+
+// lineCommentAt returns the line comment marker at the index, or an empty string.
+// A comment starting with # must follow a space so that it is not confused with
+// the # in such as $# of shells.
+func (l *language) lineCommentAt(s string, i int) string {
+	for _, marker := range l.lineComments {
+		if !strings.HasPrefix(s[i:], marker) {
+			continue
+		}
+		if marker == "#" && i > 0 && s[i-1] != ' ' && s[i-1] != '\t' && s[i-1] != '\n' {
+			continue
+		}
+
+		return marker
+	}
+
+	return ""
+}
+
+// stringEnd returns the index after the end of the string starting at the index
+func (l *language) stringEnd(s string, i int) int {
+	q := s[i]
+
+	if l.tripleQuotes {
+		triple := strings.Repeat(string(q), 3)
+		if strings.HasPrefix(s[i:], triple) {
+			if end := strings.Index(s[i+3:], triple); end != -1 {
+				return i + 3 + end + 3
+			}
+
+			return len(s)
+		}
+	}
+
+	j := i + 1
+	for j < len(s) {
+		switch {
+		case s[j] == '\\' && q != '`':
+			j += 2
+			continue
+		case s[j] == q:
+			return j + 1
+		case s[j] == '\n' && q != '`':
+			return j
+		}
+		j++
+	}
+
+	return len(s)
+}
+
+// highlight returns the HTML of the given code with the keywords, the strings,
+// the comments and the numbers marked for the styles. The code of an unknown
+// language is only escaped.
+func highlight(code, lang string) string {
+	var b strings.Builder
+
+	l, ok := languages[lang]
+	if !ok {
+		for i := 0; i < len(code); i++ {
+			writeEscaped(&b, code[i])
+		}
+
+		return b.String()
+	}
+
+	i := 0
+	for i < len(code) {
+		c := code[i]
+
+		if marker := l.lineCommentAt(code, i); marker != "" {
+			end := strings.IndexByte(code[i:], '\n')
+			if end == -1 {
+				end = len(code) - i
+			}
+
+			writeToken(&b, "c", code[i:i+end])
+			i += end
+			continue
+		}
+		if len(l.blockComment) == 2 && strings.HasPrefix(code[i:], l.blockComment[0]) {
+			end := strings.Index(code[i+len(l.blockComment[0]):], l.blockComment[1])
+			if end == -1 {
+				end = len(code)
+			} else {
+				end = i + len(l.blockComment[0]) + end + len(l.blockComment[1])
+			}
+
+			writeToken(&b, "c", code[i:end])
+			i = end
+			continue
+		}
+
+		switch {
+		case strings.IndexByte(l.quotes, c) != -1:
+			end := l.stringEnd(code, i)
+			writeToken(&b, "s", code[i:end])
+			i = end
+		case c >= '0' && c <= '9' && (i == 0 || !isIdent(code[i-1])):
+			end := i + 1
+			for end < len(code) && (isIdent(code[end]) || code[end] == '.') {
+				end++
+			}
+
+			writeToken(&b, "n", code[i:end])
+			i = end
+		case isIdentStart(c):
+			end := i + 1
+			for end < len(code) && isIdent(code[end]) {
+				end++
+			}
+
+			word := code[i:end]
+			if l.ignoreCase {
+				word = strings.ToLower(word)
+			}
+			if l.keywords[word] {
+				writeToken(&b, "k", code[i:end])
+			} else {
+				b.WriteString(code[i:end])
+			}
+			i = end
+		default:
+			writeEscaped(&b, c)
+			i++
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,529 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	headingRegex  = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*))?$`)
+	closingHashes = regexp.MustCompile(`(^|[ \t]+)#+[ \t]*$`)
+	fenceRegex    = regexp.MustCompile("^( {0,3})(```+|~~~+)[ \t]*([^`\\s]*)")
+	quoteRegex    = regexp.MustCompile(`^ {0,3}> ?(.*)$`)
+	listItemRegex = regexp.MustCompile(`^( *)([-*+]|\d{1,9}[.)])( +|$)(.*)$`)
+	autolinkRegex = regexp.MustCompile(`^<(https?://[^\s<>]+)>`)
+	bareURLRegex  = regexp.MustCompile(`^https?://[^\s<>]+`)
+)
+
+// renderer renders the Markdown of notes to HTML. It supports the parts of
+// Markdown commonly used in notes: headings, paragraphs, fenced code blocks,
+// lists, blockquotes, thematic breaks, emphasis, code spans and links, including
+// the links between notes. Line breaks in paragraphs are kept, as in plain text.
+type renderer struct {
+	// resolveLink returns the URL of the note to which the link between notes
+	// with the given text refers. The second return value is false if the link
+	// does not refer to any note.
+	resolveLink func(text string) (string, bool)
+}
+
+func (r renderer) render(src string) string {
+	src = strings.Replace(src, "\r\n", "\n", -1)
+
+	var b strings.Builder
+	r.renderBlocks(&b, strings.Split(src, "\n"))
+
+	return b.String()
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// indentOf returns the width of the indentation of the given line, in which a
+// tab is four spaces wide
+func indentOf(line string) int {
+	ret := 0
+	for _, c := range line {
+		if c == ' ' {
+			ret++
+		} else if c == '\t' {
+			ret += 4
+		} else {
+			break
+		}
+	}
+
+	return ret
+}
+
+// dedent removes the indentation of up to the given width from the given line
+func dedent(line string, width int) string {
+	i, w := 0, 0
+	for i < len(line) && w < width {
+		if line[i] == ' ' {
+			w++
+		} else if line[i] == '\t' {
+			w += 4
+		} else {
+			break
+		}
+		i++
+	}
+
+	return line[i:]
+}
+
+func isThematicBreak(line string) bool {
+	s := strings.TrimSpace(line)
+	if indentOf(line) > 3 || len(s) == 0 || !strings.ContainsAny(s[:1], "-*_") {
+		return false
+	}
+
+	count := 0
+	for _, c := range s {
+		if c == rune(s[0]) {
+			count++
+		} else if c != ' ' && c != '\t' {
+			return false
+		}
+	}
+
+	return count >= 3
+}
+
+// startsBlock checks if the given line starts a block other than a paragraph
+func startsBlock(line string) bool {
+	return fenceRegex.MatchString(line) || headingRegex.MatchString(line) || isThematicBreak(line) ||
+		quoteRegex.MatchString(line) || listItemRegex.MatchString(line)
+}
+
+func (r renderer) renderBlocks(b *strings.Builder, lines []string) {
+	i := 0
+	for i < len(lines) {
+		line := lines[i]
+
+		switch {
+		case isBlank(line):
+			i++
+		case fenceRegex.MatchString(line):
+			i = r.renderFence(b, lines, i)
+		case headingRegex.MatchString(line):
+			m := headingRegex.FindStringSubmatch(line)
+			level := strconv.Itoa(len(m[1]))
+			text := closingHashes.ReplaceAllString(strings.TrimSpace(m[2]), "")
+
+			b.WriteString("<h" + level + ">" + r.renderInline(text) + "</h" + level + ">\n")
+			i++
+		case isThematicBreak(line):
+			b.WriteString("<hr>\n")
+			i++
+		case quoteRegex.MatchString(line):
+			var quoted []string
+			for i < len(lines) && quoteRegex.MatchString(lines[i]) {
+				quoted = append(quoted, quoteRegex.FindStringSubmatch(lines[i])[1])
+				i++
+			}
+
+			b.WriteString("<blockquote>\n")
+			r.renderBlocks(b, quoted)
+			b.WriteString("</blockquote>\n")
+		case listItemRegex.MatchString(line):
+			i = r.renderList(b, lines, i)
+		default:
+			var para []string
+			for i < len(lines) && !isBlank(lines[i]) && (len(para) == 0 || !startsBlock(lines[i])) {
+				para = append(para, strings.TrimSpace(lines[i]))
+				i++
+			}
+
+			b.WriteString("<p>" + r.renderLines(para) + "</p>\n")
+		}
+	}
+}
+
+// renderLines renders the given lines of text, keeping the line breaks
+func (r renderer) renderLines(lines []string) string {
+	rendered := make([]string, len(lines))
+	for i, line := range lines {
+		rendered[i] = r.renderInline(line)
+	}
+
+	return strings.Join(rendered, "<br>\n")
+}
+
+// renderFence renders the fenced code block starting at the given line, and
+// returns the index of the line after it
+func (r renderer) renderFence(b *strings.Builder, lines []string, start int) int {
+	m := fenceRegex.FindStringSubmatch(lines[start])
+	indent := len(m[1])
+	fence := m[2]
+	lang := strings.ToLower(m[3])
+
+	var code []string
+	i := start + 1
+	for ; i < len(lines); i++ {
+		s := strings.TrimSpace(lines[i])
+		if indentOf(lines[i]) <= 3 && strings.HasPrefix(s, fence) && strings.Trim(s, fence[:1]) == "" {
+			i++
+			break
+		}
+
+		code = append(code, dedent(lines[i], indent))
+	}
+
+	b.WriteString("<pre><code")
+	if lang != "" {
+		b.WriteString(` class="language-` + html.EscapeString(lang) + `"`)
+	}
+	b.WriteString(">")
+	b.WriteString(highlight(strings.Join(code, "\n"), lang))
+	b.WriteString("</code></pre>\n")
+
+	return i
+}
+
+// renderList renders the list starting at the given line, and returns the index
+// of the line after it
+func (r renderer) renderList(b *strings.Builder, lines []string, start int) int {
+	m := listItemRegex.FindStringSubmatch(lines[start])
+	indent := len(m[1])
+	ordered := !strings.ContainsAny(m[2], "-*+")
+
+	if ordered {
+		n, _ := strconv.Atoi(strings.TrimRight(m[2], ".)"))
+		if n != 1 {
+			b.WriteString(`<ol start="` + strconv.Itoa(n) + `">` + "\n")
+		} else {
+			b.WriteString("<ol>\n")
+		}
+	} else {
+		b.WriteString("<ul>\n")
+	}
+
+	i := start
+	for i < len(lines) {
+		m := listItemRegex.FindStringSubmatch(lines[i])
+		if m == nil || len(m[1]) != indent || ordered == strings.ContainsAny(m[2], "-*+") || isThematicBreak(lines[i]) {
+			break
+		}
+
+		contentIndent := indent + len(m[2]) + len(m[3])
+		if m[3] == "" {
+			contentIndent++
+		}
+
+		item := []string{m[4]}
+		i++
+
+		for i < len(lines) {
+			line := lines[i]
+
+			if isBlank(line) {
+				// the item continues after blank lines if the next line is indented
+				j := i
+				for j < len(lines) && isBlank(lines[j]) {
+					j++
+				}
+				if j == len(lines) || indentOf(lines[j]) < contentIndent {
+					break
+				}
+
+				for ; i < j; i++ {
+					item = append(item, "")
+				}
+				continue
+			}
+
+			if indentOf(line) > indent {
+				item = append(item, dedent(line, contentIndent))
+			} else if !startsBlock(line) && !isBlank(item[len(item)-1]) {
+				// a lazy continuation of the text of the item
+				item = append(item, strings.TrimSpace(line))
+			} else {
+				break
+			}
+			i++
+		}
+
+		// the leading lines of text are rendered inline, and the rest as blocks
+		text := 0
+		for text < len(item) && !isBlank(item[text]) && (text == 0 || !startsBlock(item[text])) {
+			item[text] = strings.TrimSpace(item[text])
+			text++
+		}
+
+		b.WriteString("<li>" + r.renderLines(item[:text]))
+		if text < len(item) {
+			b.WriteString("\n")
+			r.renderBlocks(b, item[text:])
+		}
+		b.WriteString("</li>\n")
+	}
+
+	if ordered {
+		b.WriteString("</ol>\n")
+	} else {
+		b.WriteString("</ul>\n")
+	}
+
+	return i
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isPunct(c byte) bool {
+	return c < 0x80 && c > ' ' && !isAlnum(c)
+}
+
+// writeEscaped writes the given byte, escaped for HTML
+func writeEscaped(b *strings.Builder, c byte) {
+	switch c {
+	case '&':
+		b.WriteString("&amp;")
+	case '<':
+		b.WriteString("&lt;")
+	case '>':
+		b.WriteString("&gt;")
+	case '"':
+		b.WriteString("&#34;")
+	case '\'':
+		b.WriteString("&#39;")
+	default:
+		b.WriteByte(c)
+	}
+}
+
+// runLength returns the number of the consecutive given characters at the index
+func runLength(s string, i int, c byte) int {
+	n := 0
+	for i+n < len(s) && s[i+n] == c {
+		n++
+	}
+
+	return n
+}
+
+// findCodeSpanEnd returns the index of the run of n backticks closing a code span
+// that starts before the given index. It returns -1 if none exists.
+func findCodeSpanEnd(s string, from, n int) int {
+	for i := from; i < len(s); {
+		if s[i] != '`' {
+			i++
+			continue
+		}
+
+		l := runLength(s, i, '`')
+		if l == n {
+			return i
+		}
+		i += l
+	}
+
+	return -1
+}
+
+// findEmphasisEnd returns the index of the run of n of the given characters
+// closing an emphasis that starts before the given index. It returns -1 if none
+// exists.
+func findEmphasisEnd(s string, from, n int, c byte) int {
+	for i := from; i < len(s); {
+		if s[i] == '`' {
+			l := runLength(s, i, '`')
+			if end := findCodeSpanEnd(s, i+l, l); end != -1 {
+				i = end + l
+				continue
+			}
+		}
+		if s[i] != c {
+			i++
+			continue
+		}
+
+		l := runLength(s, i, c)
+		closes := l == n && i > from && s[i-1] != ' ' && s[i-1] != '\t'
+		if c == '_' && i+l < len(s) && isAlnum(s[i+l]) {
+			closes = false
+		}
+		if closes {
+			return i
+		}
+		i += l
+	}
+
+	return -1
+}
+
+// parseLink parses the inline link such as [text](url) at the start of the given
+// string, and returns its text, its destination and its length
+func parseLink(s string) (string, string, int, bool) {
+	end := strings.IndexByte(s, ']')
+	if end == -1 || end+1 >= len(s) || s[end+1] != '(' {
+		return "", "", 0, false
+	}
+
+	depth := 0
+	for i := end + 2; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+				continue
+			}
+
+			dest := strings.TrimSpace(s[end+2 : i])
+			if idx := strings.Index(dest, ` "`); idx != -1 {
+				dest = strings.TrimSpace(dest[:idx])
+			}
+			dest = strings.TrimSuffix(strings.TrimPrefix(dest, "<"), ">")
+
+			return s[1:end], dest, i + 1, true
+		}
+	}
+
+	return "", "", 0, false
+}
+
+// safeURL returns the given URL if it is safe to link to. Only links to the web,
+// emails and the paths of the same site are allowed.
+func safeURL(u string) (string, bool) {
+	u = strings.TrimSpace(u)
+
+	if idx := strings.IndexAny(u, ":/?#"); idx != -1 && u[idx] == ':' {
+		switch strings.ToLower(u[:idx]) {
+		case "http", "https", "mailto":
+		default:
+			return "", false
+		}
+	}
+
+	return u, true
+}
+
+func writeLink(b *strings.Builder, href, text string) {
+	b.WriteString(`<a href="` + html.EscapeString(href) + `">` + text + "</a>")
+}
+
+// renderInline renders the inline elements in the given text
+func (r renderer) renderInline(s string) string {
+	var b strings.Builder
+
+	i := 0
+	for i < len(s) {
+		c := s[i]
+
+		switch {
+		case c == '\\' && i+1 < len(s) && isPunct(s[i+1]):
+			writeEscaped(&b, s[i+1])
+			i += 2
+		case c == '`':
+			n := runLength(s, i, '`')
+			end := findCodeSpanEnd(s, i+n, n)
+			if end == -1 {
+				b.WriteString(s[i : i+n])
+				i += n
+				continue
+			}
+
+			code := s[i+n : end]
+			if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' {
+				code = code[1 : len(code)-1]
+			}
+
+			b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+			i = end + n
+		case strings.HasPrefix(s[i:], "[["):
+			end := strings.Index(s[i+2:], "]]")
+			if end == -1 || strings.Contains(s[i+2:i+2+end], "[") {
+				b.WriteString("[[")
+				i += 2
+				continue
+			}
+
+			text := strings.TrimSpace(s[i+2 : i+2+end])
+			if href, ok := r.resolveLink(text); ok {
+				b.WriteString(`<a class="note-link" href="` + html.EscapeString(href) + `">` + html.EscapeString(text) + "</a>")
+			} else {
+				b.WriteString(`<span class="broken-link">[[` + html.EscapeString(text) + "]]</span>")
+			}
+			i += end + 4
+		case c == '[':
+			text, dest, n, ok := parseLink(s[i:])
+			if !ok {
+				b.WriteByte('[')
+				i++
+				continue
+			}
+
+			if href, ok := safeURL(dest); ok {
+				writeLink(&b, href, r.renderInline(text))
+			} else {
+				b.WriteString(r.renderInline(text))
+			}
+			i += n
+		case c == '*' || c == '_':
+			n := runLength(s, i, c)
+			opens := n <= 3 && i+n < len(s) && s[i+n] != ' ' && s[i+n] != '\t'
+			if c == '_' && i > 0 && isAlnum(s[i-1]) {
+				opens = false
+			}
+
+			end := -1
+			if opens {
+				end = findEmphasisEnd(s, i+n, n, c)
+			}
+			if end == -1 {
+				b.WriteString(s[i : i+n])
+				i += n
+				continue
+			}
+
+			inner := r.renderInline(s[i+n : end])
+			switch n {
+			case 1:
+				b.WriteString("<em>" + inner + "</em>")
+			case 2:
+				b.WriteString("<strong>" + inner + "</strong>")
+			default:
+				b.WriteString("<strong><em>" + inner + "</em></strong>")
+			}
+			i = end + n
+		case c == '<' && autolinkRegex.MatchString(s[i:]):
+			m := autolinkRegex.FindStringSubmatch(s[i:])
+			writeLink(&b, m[1], html.EscapeString(m[1]))
+			i += len(m[0])
+		case c == 'h' && (i == 0 || !isAlnum(s[i-1])) && bareURLRegex.MatchString(s[i:]):
+			u := strings.TrimRight(bareURLRegex.FindString(s[i:]), ".,;:!?'\")")
+			writeLink(&b, u, html.EscapeString(u))
+			i += len(u)
+		default:
+			writeEscaped(&b, c)
+			i++
+		}
+	}
+
+	return b.String()
+}
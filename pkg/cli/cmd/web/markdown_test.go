@@ -0,0 +1,137 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+)
+
+func TestRender(t *testing.T) {
+	r := renderer{
+		resolveLink: func(text string) (string, bool) {
+			if text == "js/Array.map" {
+				return "/notes/2", true
+			}
+
+			return "", false
+		},
+	}
+
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input:    "# Title\n\nsome *emphasis* and **strong** text",
+			expected: "<h1>Title</h1>\n<p>some <em>emphasis</em> and <strong>strong</strong> text</p>\n",
+		},
+		{
+			input:    "line one\nline two",
+			expected: "<p>line one<br>\nline two</p>\n",
+		},
+		{
+			input:    "use `a < b` here",
+			expected: "<p>use <code>a &lt; b</code> here</p>\n",
+		},
+		{
+			input:    "<script>alert(1)</script>",
+			expected: "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n",
+		},
+		{
+			input:    "[docs](https://golang.org/doc) and [bad](javascript:alert(1))",
+			expected: "<p><a href=\"https://golang.org/doc\">docs</a> and bad</p>\n",
+		},
+		{
+			input:    "see [[js/Array.map]] and [[missing]]",
+			expected: "<p>see <a class=\"note-link\" href=\"/notes/2\">js/Array.map</a> and <span class=\"broken-link\">[[missing]]</span></p>\n",
+		},
+		{
+			input:    "visit https://dnote.io. or <https://example.com>",
+			expected: "<p>visit <a href=\"https://dnote.io\">https://dnote.io</a>. or <a href=\"https://example.com\">https://example.com</a></p>\n",
+		},
+		{
+			input:    "- a\n- b\n  - c",
+			expected: "<ul>\n<li>a</li>\n<li>b\n<ul>\n<li>c</li>\n</ul>\n</li>\n</ul>\n",
+		},
+		{
+			input:    "3. three\n4. four",
+			expected: "<ol start=\"3\">\n<li>three</li>\n<li>four</li>\n</ol>\n",
+		},
+		{
+			input:    "> quoted",
+			expected: "<blockquote>\n<p>quoted</p>\n</blockquote>\n",
+		},
+		{
+			input:    "---",
+			expected: "<hr>\n",
+		},
+		{
+			input:    "```go\nfunc main() {}\n```",
+			expected: "<pre><code class=\"language-go\"><span class=\"hl-k\">func</span> main() {}</code></pre>\n",
+		},
+		{
+			input:    "```\n<b>\n```",
+			expected: "<pre><code>&lt;b&gt;</code></pre>\n",
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("case %d", idx), func(t *testing.T) {
+			assert.Equal(t, r.render(tc.input), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	testCases := []struct {
+		code     string
+		lang     string
+		expected string
+	}{
+		{
+			code:     "x := \"hi\" // greet",
+			lang:     "go",
+			expected: "x := <span class=\"hl-s\">&#34;hi&#34;</span> <span class=\"hl-c\">// greet</span>",
+		},
+		{
+			code:     "SELECT * FROM notes WHERE id = 1",
+			lang:     "sql",
+			expected: "<span class=\"hl-k\">SELECT</span> * <span class=\"hl-k\">FROM</span> notes <span class=\"hl-k\">WHERE</span> id = <span class=\"hl-n\">1</span>",
+		},
+		{
+			code:     "echo $HOME # home",
+			lang:     "sh",
+			expected: "<span class=\"hl-k\">echo</span> $HOME <span class=\"hl-c\"># home</span>",
+		},
+		{
+			code:     "a < b",
+			lang:     "unknown",
+			expected: "a &lt; b",
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("case %d", idx), func(t *testing.T) {
+			assert.Equal(t, highlight(tc.code, tc.lang), tc.expected, "result mismatch")
+		})
+	}
+}
@@ -0,0 +1,174 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"html/template"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var funcs = template.FuncMap{
+	"bookURL": bookURL,
+	"noteURL": noteURL,
+	"date":    formatDate,
+	"indent": func(depth int) int {
+		return depth * 20
+	},
+}
+
+// bookURL returns the URL of the page of the book at the given path
+func bookURL(path string) string {
+	names := strings.Split(path, "/")
+	for i, name := range names {
+		names[i] = url.PathEscape(name)
+	}
+
+	return "/books/" + strings.Join(names, "/")
+}
+
+// noteURL returns the URL of the page of the note with the given rowid
+func noteURL(rowID int) string {
+	return "/notes/" + strconv.Itoa(rowID)
+}
+
+// formatDate formats the given time in Unix nanoseconds
+func formatDate(ts int64) string {
+	return time.Unix(0, ts).Local().Format("Jan 2, 2006")
+}
+
+// layoutTmpl is the layout of the pages. A page can define "query" to fill the
+// search box, and "scope" to limit the search to a book.
+var layoutTmpl = template.Must(template.New("layout").Funcs(funcs).Parse(`{{define "layout"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{template "title" .}} - Dnote</title>
+<style>
+body { margin: 0; background: #f7f7f7; color: #333; font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; line-height: 1.6; }
+a { color: #1d6fa5; text-decoration: none; }
+a:hover { text-decoration: underline; }
+nav { background: #2d2d2d; padding: 10px 24px; display: flex; align-items: center; }
+nav .home { color: #fff; font-weight: bold; margin-right: 24px; }
+nav form { flex: 1; display: flex; max-width: 480px; }
+nav input[type=search] { flex: 1; padding: 4px 8px; border: 0; border-radius: 3px; font-size: 14px; }
+main { max-width: 800px; margin: 24px auto; padding: 24px 32px; background: #fff; border: 1px solid #e4e4e4; border-radius: 4px; }
+h1 { font-size: 22px; margin-top: 0; }
+.breadcrumbs { color: #777; font-size: 14px; margin-bottom: 12px; }
+.meta { color: #777; font-size: 13px; }
+.list { list-style: none; padding: 0; }
+.list li { padding: 6px 0; border-bottom: 1px solid #f0f0f0; }
+.count { color: #999; font-size: 13px; margin-left: 6px; }
+.empty { color: #999; }
+.snippet { color: #555; font-size: 14px; }
+mark { background: #fff3a8; }
+.content { word-wrap: break-word; }
+.content pre { background: #f6f8fa; border-radius: 3px; padding: 12px; overflow-x: auto; line-height: 1.45; }
+.content code { font-family: SFMono-Regular, Consolas, Menlo, monospace; font-size: 13px; background: #f6f8fa; padding: 2px 4px; border-radius: 3px; }
+.content pre code { padding: 0; }
+.content blockquote { margin: 0; padding-left: 16px; border-left: 4px solid #ddd; color: #666; }
+.broken-link { color: #999; }
+.hl-k { color: #a626a4; }
+.hl-s { color: #50a14f; }
+.hl-c { color: #a0a1a7; font-style: italic; }
+.hl-n { color: #986801; }
+</style>
+</head>
+<body>
+<nav>
+<a class="home" href="/">Dnote</a>
+<form action="/search" method="get">
+<input type="search" name="q" value="{{template "query" .}}" placeholder="Search notes" aria-label="Search notes">
+{{template "scope" .}}</form>
+</nav>
+<main>
+{{template "content" .}}
+</main>
+</body>
+</html>
+{{end}}
+{{define "query"}}{{end}}
+{{define "scope"}}{{end}}
+{{define "breadcrumbs"}}{{if .}}<div class="breadcrumbs">{{range $i, $b := .}}{{if $i}} / {{end}}<a href="{{bookURL $b.Path}}">{{$b.Name}}</a>{{end}}</div>{{end}}{{end}}
+`))
+
+// newPage returns the template of a page, which defines the title and the content
+// rendered in the layout
+func newPage(text string) *template.Template {
+	return template.Must(template.Must(layoutTmpl.Clone()).Parse(text))
+}
+
+var indexTmpl = newPage(`{{define "title"}}Books{{end}}
+{{define "content"}}
+<h1>Books</h1>
+{{if .Books}}
+<ul class="list">
+{{range .Books}}<li style="padding-left: {{indent .Depth}}px"><a href="{{bookURL .Path}}">{{.Name}}</a><span class="count">{{.NoteCount}}</span></li>
+{{end}}
+</ul>
+{{else}}
+<p class="empty">No notes yet. Add one with <code>dnote add</code>.</p>
+{{end}}
+{{end}}`)
+
+var bookTmpl = newPage(`{{define "title"}}{{.Path}}{{end}}
+{{define "scope"}}<input type="hidden" name="book" value="{{.Path}}">{{end}}
+{{define "content"}}
+{{template "breadcrumbs" .Breadcrumbs}}
+<h1>{{.Name}}</h1>
+{{if .Books}}
+<ul class="list">
+{{range .Books}}<li><a href="{{bookURL .Path}}">{{.Name}}/</a><span class="count">{{.NoteCount}}</span></li>
+{{end}}
+</ul>
+{{end}}
+{{if .Notes}}
+<ul class="list">
+{{range .Notes}}<li><a href="{{noteURL .RowID}}">{{.Title}}</a> <span class="meta">({{.RowID}}) {{date .AddedOn}}</span></li>
+{{end}}
+</ul>
+{{else}}
+<p class="empty">No notes in this book.</p>
+{{end}}
+{{end}}`)
+
+var noteTmpl = newPage(`{{define "title"}}{{.Title}}{{end}}
+{{define "content"}}
+{{template "breadcrumbs" .Breadcrumbs}}
+<div class="meta">({{.RowID}}) added on {{date .AddedOn}}{{if .EditedOn}}, edited on {{date .EditedOn}}{{end}}</div>
+<div class="content">
+{{.Body}}
+</div>
+{{end}}`)
+
+var searchTmpl = newPage(`{{define "title"}}Search{{end}}
+{{define "query"}}{{.Query}}{{end}}
+{{define "scope"}}{{if .Book}}<input type="hidden" name="book" value="{{.Book}}">{{end}}{{end}}
+{{define "content"}}
+<h1>Search</h1>
+<p class="meta">{{len .Results}} notes match <strong>{{.Query}}</strong>{{if .Book}} in <a href="{{bookURL .Book}}">{{.Book}}</a>{{end}}</p>
+<ul class="list">
+{{range .Results}}<li><a href="{{noteURL .RowID}}">{{.Title}}</a> <span class="meta">{{.Book}}</span>
+<div class="snippet">{{.Snippet}}</div></li>
+{{end}}
+</ul>
+{{end}}`)
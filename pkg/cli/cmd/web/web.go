@@ -0,0 +1,460 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"html/template"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/infra"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/wikilink"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var portFlag int
+
+var example = `
+  * Browse the notes at http://localhost:3030
+  dnote web
+
+  * Use a different port
+  dnote web --port 8080`
+
+// NewCmd returns a new web command
+func NewCmd(ctx context.DnoteCtx) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "web",
+		Short:   "Browse the notes in a web browser",
+		Example: example,
+		RunE:    newRun(ctx),
+	}
+
+	f := cmd.Flags()
+	f.IntVarP(&portFlag, "port", "p", 3030, "The port on which to serve the web interface")
+
+	return cmd
+}
+
+// the markers of the matches in the snippets of the search results, which cannot
+// appear in the escaped text
+const (
+	snippetMatchBegin = "\x02"
+	snippetMatchEnd   = "\x03"
+)
+
+// bookItem is a book in a page
+type bookItem struct {
+	Name string
+	// Path is the path of the book, such as infra/k8s
+	Path      string
+	Depth     int
+	NoteCount int
+}
+
+// noteItem is a note in a page
+type noteItem struct {
+	RowID    int
+	Title    string
+	Book     string
+	AddedOn  int64
+	EditedOn int64
+	Body     template.HTML
+	Snippet  template.HTML
+}
+
+type indexData struct {
+	Books []bookItem
+}
+
+type bookData struct {
+	Name        string
+	Path        string
+	Breadcrumbs []bookItem
+	Books       []bookItem
+	Notes       []noteItem
+}
+
+type noteData struct {
+	noteItem
+	Breadcrumbs []bookItem
+}
+
+type searchData struct {
+	Query   string
+	Book    string
+	Results []noteItem
+}
+
+// breadcrumbs returns the books in the given path, from the top level
+func breadcrumbs(path string) []bookItem {
+	names := strings.Split(path, "/")
+
+	ret := []bookItem{}
+	for i, name := range names {
+		ret = append(ret, bookItem{
+			Name:  name,
+			Path:  strings.Join(names[:i+1], "/"),
+			Depth: i,
+		})
+	}
+
+	return ret
+}
+
+// comparePaths checks if the book at the path a comes before the book at the
+// path b, so that every book is followed by the books nested in it
+func comparePaths(a, b string) bool {
+	as := strings.Split(a, "/")
+	bs := strings.Split(b, "/")
+
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			return as[i] < bs[i]
+		}
+	}
+
+	return len(as) < len(bs)
+}
+
+// queryBooks returns the books that are not deleted, with the number of their notes
+func queryBooks(db *database.DB, where string, args ...interface{}) ([]bookItem, error) {
+	rows, err := db.Query(database.BookPaths+` SELECT books.label, book_paths.path, book_paths.depth, count(notes.uuid)
+	FROM books
+	INNER JOIN book_paths ON book_paths.uuid = books.uuid
+	LEFT JOIN notes ON notes.book_uuid = books.uuid AND notes.deleted = false
+	WHERE books.deleted = false `+where+`
+	GROUP BY books.uuid`, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying books")
+	}
+	defer rows.Close()
+
+	ret := []bookItem{}
+	for rows.Next() {
+		var b bookItem
+		if err := rows.Scan(&b.Name, &b.Path, &b.Depth, &b.NoteCount); err != nil {
+			return nil, errors.Wrap(err, "scanning a row for book")
+		}
+
+		ret = append(ret, b)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return comparePaths(ret[i].Path, ret[j].Path)
+	})
+
+	return ret, nil
+}
+
+// getLinkResolver returns a function that resolves the links in the note with
+// the given uuid to the URLs of the notes to which they refer
+func getLinkResolver(db *database.DB, noteUUID string) (func(string) (string, bool), error) {
+	rows, err := db.Query(`SELECT note_links.text, notes.rowid
+	FROM note_links
+	INNER JOIN notes ON notes.uuid = note_links.target_uuid
+	WHERE note_links.source_uuid = ? AND notes.deleted = false`, noteUUID)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying links")
+	}
+	defer rows.Close()
+
+	targets := map[string]int{}
+	for rows.Next() {
+		var text string
+		var rowID int
+		if err := rows.Scan(&text, &rowID); err != nil {
+			return nil, errors.Wrap(err, "scanning a row for link")
+		}
+
+		targets[text] = rowID
+	}
+
+	return func(text string) (string, bool) {
+		rowID, ok := targets[text]
+		if !ok {
+			return "", false
+		}
+
+		return noteURL(rowID), true
+	}, nil
+}
+
+// formatSnippet returns the HTML of the given snippet with the matches marked
+func formatSnippet(s string) template.HTML {
+	ret := html.EscapeString(strings.Replace(s, "\n", " ", -1))
+	ret = strings.Replace(ret, snippetMatchBegin, "<mark>", -1)
+	ret = strings.Replace(ret, snippetMatchEnd, "</mark>", -1)
+
+	return template.HTML(ret)
+}
+
+type app struct {
+	ctx context.DnoteCtx
+}
+
+func (a app) handleError(w http.ResponseWriter, msg string, err error) {
+	log.Errorf("%s: %s\n", msg, err.Error())
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+func render(w http.ResponseWriter, tmpl *template.Template, data interface{}) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.ExecuteTemplate(w, "layout", data); err != nil {
+		return errors.Wrap(err, "executing template")
+	}
+
+	return nil
+}
+
+func (a app) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	books, err := queryBooks(a.ctx.DB, "")
+	if err != nil {
+		a.handleError(w, "getting books", err)
+		return
+	}
+
+	if err := render(w, indexTmpl, indexData{Books: books}); err != nil {
+		a.handleError(w, "rendering", err)
+	}
+}
+
+func (a app) book(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/books/"), "/")
+
+	var uuid string
+	err := a.ctx.DB.QueryRow(database.BookPaths+` SELECT books.uuid
+	FROM books
+	INNER JOIN book_paths ON book_paths.uuid = books.uuid
+	WHERE book_paths.path = ? AND books.deleted = false`, path).Scan(&uuid)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		a.handleError(w, "finding the book", err)
+		return
+	}
+
+	books, err := queryBooks(a.ctx.DB, "AND books.parent_uuid = ?", uuid)
+	if err != nil {
+		a.handleError(w, "getting books", err)
+		return
+	}
+
+	rows, err := a.ctx.DB.Query(`SELECT rowid, body, added_on, edited_on
+	FROM notes
+	WHERE book_uuid = ? AND deleted = false
+	ORDER BY added_on ASC`, uuid)
+	if err != nil {
+		a.handleError(w, "querying notes", err)
+		return
+	}
+	defer rows.Close()
+
+	notes := []noteItem{}
+	for rows.Next() {
+		var n noteItem
+		var body string
+		if err := rows.Scan(&n.RowID, &body, &n.AddedOn, &n.EditedOn); err != nil {
+			a.handleError(w, "scanning a row for note", err)
+			return
+		}
+
+		n.Title = wikilink.Title(body)
+		notes = append(notes, n)
+	}
+
+	crumbs := breadcrumbs(path)
+	data := bookData{
+		Name:        crumbs[len(crumbs)-1].Name,
+		Path:        path,
+		Breadcrumbs: crumbs[:len(crumbs)-1],
+		Books:       books,
+		Notes:       notes,
+	}
+	if err := render(w, bookTmpl, data); err != nil {
+		a.handleError(w, "rendering", err)
+	}
+}
+
+func (a app) note(w http.ResponseWriter, r *http.Request) {
+	rowID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/notes/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var uuid, body string
+	var n noteItem
+	err = a.ctx.DB.QueryRow(database.BookPaths+` SELECT notes.rowid, notes.uuid, book_paths.path, notes.body, notes.added_on, notes.edited_on
+	FROM notes
+	INNER JOIN book_paths ON book_paths.uuid = notes.book_uuid
+	WHERE notes.rowid = ? AND notes.deleted = false`, rowID).
+		Scan(&n.RowID, &uuid, &n.Book, &body, &n.AddedOn, &n.EditedOn)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		a.handleError(w, "finding the note", err)
+		return
+	}
+
+	resolveLink, err := getLinkResolver(a.ctx.DB, uuid)
+	if err != nil {
+		a.handleError(w, "getting links", err)
+		return
+	}
+
+	n.Title = wikilink.Title(body)
+	n.Body = template.HTML(renderer{resolveLink: resolveLink}.render(body))
+
+	data := noteData{
+		noteItem:    n,
+		Breadcrumbs: breadcrumbs(n.Book),
+	}
+	if err := render(w, noteTmpl, data); err != nil {
+		a.handleError(w, "rendering", err)
+	}
+}
+
+func (a app) search(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	data := searchData{
+		Query:   strings.TrimSpace(q.Get("q")),
+		Book:    strings.Trim(q.Get("book"), "/"),
+		Results: []noteItem{},
+	}
+
+	if query := database.FTSQuery(data.Query); query != "" {
+		sqlQuery := database.BookPaths + ` SELECT notes.rowid, book_paths.path, notes.body,
+		snippet(note_fts, 0, '` + snippetMatchBegin + `', '` + snippetMatchEnd + `', '...', 28)
+	FROM note_fts
+	INNER JOIN notes ON notes.rowid = note_fts.rowid
+	INNER JOIN book_paths ON notes.book_uuid = book_paths.uuid
+	WHERE note_fts MATCH ? AND notes.deleted = false`
+		args := []interface{}{query}
+
+		// include the books nested in the book
+		if data.Book != "" {
+			prefix := data.Book + "/"
+			sqlQuery = fmt.Sprintf("%s AND (book_paths.path = ? OR substr(book_paths.path, 1, length(?)) = ?)", sqlQuery)
+			args = append(args, data.Book, prefix, prefix)
+		}
+
+		rows, err := a.ctx.DB.Query(sqlQuery+" ORDER BY rank", args...)
+		if err != nil {
+			a.handleError(w, "searching notes", err)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var n noteItem
+			var body, snippet string
+			if err := rows.Scan(&n.RowID, &n.Book, &body, &snippet); err != nil {
+				a.handleError(w, "scanning a row for note", err)
+				return
+			}
+
+			n.Title = wikilink.Title(body)
+			n.Snippet = formatSnippet(snippet)
+			data.Results = append(data.Results, n)
+		}
+	}
+
+	if err := render(w, searchTmpl, data); err != nil {
+		a.handleError(w, "rendering", err)
+	}
+}
+
+// readOnly only allows the requests that read
+func readOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// checkHost responds with 403 to the requests whose Host header is not the loopback
+// address at the given port. It prevents a web page on another origin from reading
+// the notes by pointing its own domain at the loopback address, known as DNS rebinding.
+func checkHost(port int, next http.Handler) http.Handler {
+	allowed := map[string]bool{
+		fmt.Sprintf("localhost:%d", port): true,
+		fmt.Sprintf("127.0.0.1:%d", port): true,
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowed[strings.ToLower(r.Host)] {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newRouter returns the handler of the web interface served at the given port
+func newRouter(ctx context.DnoteCtx, port int) http.Handler {
+	a := app{ctx: ctx}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", readOnly(a.index))
+	mux.HandleFunc("/books/", readOnly(a.book))
+	mux.HandleFunc("/notes/", readOnly(a.note))
+	mux.HandleFunc("/search", readOnly(a.search))
+
+	return checkHost(port, mux)
+}
+
+func newRun(ctx context.DnoteCtx) infra.RunEFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		// only listen on the loopback interface so that the notes are not exposed
+		// to the network
+		addr := fmt.Sprintf("127.0.0.1:%d", portFlag)
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return errors.Wrapf(err, "listening on %s", addr)
+		}
+
+		log.Infof("browse the notes at http://localhost:%d. Press Ctrl+C to stop\n", portFlag)
+
+		return http.Serve(ln, newRouter(ctx, portFlag))
+	}
+}
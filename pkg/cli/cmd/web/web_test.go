@@ -0,0 +1,253 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+)
+
+// setupNotes inserts a book js with a nested book react, a book css, a note in
+// each of js and css linking from the former to the latter, and a deleted note
+func setupNotes(t *testing.T, db *database.DB) {
+	database.MustExec(t, "inserting js", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "js-uuid", "js")
+	database.MustExec(t, "inserting react", db, "INSERT INTO books (uuid, parent_uuid, label) VALUES (?, ?, ?)", "react-uuid", "js-uuid", "react")
+	database.MustExec(t, "inserting css", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", "css-uuid", "css")
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n1-uuid", "js-uuid", "# Array.map\n\nsee [[css/flexbox]]\n\n```js\nconst a = [1].map(x => x)\n```", 1541108743)
+	database.MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", "n2-uuid", "css-uuid", "flexbox\n\nflexbox aligns items", 1541108744)
+	database.MustExec(t, "inserting n3", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, deleted) VALUES (?, ?, ?, ?, ?)", "n3-uuid", "css-uuid", "grid aligns items", 1541108745, true)
+	database.MustExec(t, "inserting link", db, "INSERT INTO note_links (source_uuid, target_uuid, text) VALUES (?, ?, ?)", "n1-uuid", "n2-uuid", "css/flexbox")
+}
+
+func mustGetRowID(t *testing.T, db *database.DB, uuid string) int {
+	var ret int
+	database.MustScan(t, "getting rowid", db.QueryRow("SELECT rowid FROM notes WHERE uuid = ?", uuid), &ret)
+
+	return ret
+}
+
+// testPort is the port at which the web interface is served in the tests
+const testPort = 3030
+
+// get makes a request with the given method to the path, and returns the
+// response recorded
+func get(ctx context.DnoteCtx, method, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	r.Host = fmt.Sprintf("localhost:%d", testPort)
+
+	w := httptest.NewRecorder()
+	newRouter(ctx, testPort).ServeHTTP(w, r)
+
+	return w
+}
+
+func assertContains(t *testing.T, body, s string) {
+	if !strings.Contains(body, s) {
+		t.Errorf("%q was not found in the body:\n%s", s, body)
+	}
+}
+
+func assertNotContains(t *testing.T, body, s string) {
+	if strings.Contains(body, s) {
+		t.Errorf("%q was found in the body:\n%s", s, body)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupNotes(t, ctx.DB)
+
+	w := get(ctx, http.MethodGet, "/")
+	assert.Equal(t, w.Code, http.StatusOK, "status mismatch")
+
+	body := w.Body.String()
+	css := strings.Index(body, `href="/books/css"`)
+	js := strings.Index(body, `href="/books/js"`)
+	react := strings.Index(body, `href="/books/js/react"`)
+	if css == -1 || js == -1 || react == -1 {
+		t.Fatalf("books were not listed:\n%s", body)
+	}
+	if !(css < js && js < react) {
+		t.Errorf("books were not listed in order:\n%s", body)
+	}
+}
+
+func TestBook(t *testing.T) {
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupNotes(t, ctx.DB)
+
+	t.Run("top level", func(t *testing.T) {
+		w := get(ctx, http.MethodGet, "/books/js")
+		assert.Equal(t, w.Code, http.StatusOK, "status mismatch")
+
+		body := w.Body.String()
+		assertContains(t, body, `<a href="/books/js/react">react/</a>`)
+		assertContains(t, body, `<input type="hidden" name="book" value="js">`)
+		assertContains(t, body, fmt.Sprintf(`<a href="/notes/%d">Array.map</a>`, mustGetRowID(t, ctx.DB, "n1-uuid")))
+	})
+
+	t.Run("nested", func(t *testing.T) {
+		w := get(ctx, http.MethodGet, "/books/js/react")
+		assert.Equal(t, w.Code, http.StatusOK, "status mismatch")
+		assertContains(t, w.Body.String(), `<a href="/books/js">js</a>`)
+	})
+
+	t.Run("deleted note", func(t *testing.T) {
+		w := get(ctx, http.MethodGet, "/books/css")
+		assert.Equal(t, w.Code, http.StatusOK, "status mismatch")
+		assertNotContains(t, w.Body.String(), "grid")
+	})
+
+	t.Run("nonexistent", func(t *testing.T) {
+		w := get(ctx, http.MethodGet, "/books/go")
+		assert.Equal(t, w.Code, http.StatusNotFound, "status mismatch")
+	})
+}
+
+func TestNote(t *testing.T) {
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupNotes(t, ctx.DB)
+
+	t.Run("found", func(t *testing.T) {
+		w := get(ctx, http.MethodGet, fmt.Sprintf("/notes/%d", mustGetRowID(t, ctx.DB, "n1-uuid")))
+		assert.Equal(t, w.Code, http.StatusOK, "status mismatch")
+
+		body := w.Body.String()
+		assertContains(t, body, "<h1>Array.map</h1>")
+		assertContains(t, body, `<code class="language-js"><span class="hl-k">const</span> a = [<span class="hl-n">1</span>]`)
+		assertContains(t, body, fmt.Sprintf(`<a class="note-link" href="/notes/%d">css/flexbox</a>`, mustGetRowID(t, ctx.DB, "n2-uuid")))
+	})
+
+	testCases := []string{
+		fmt.Sprintf("/notes/%d", mustGetRowID(t, ctx.DB, "n3-uuid")),
+		"/notes/100",
+		"/notes/foo",
+	}
+	for _, path := range testCases {
+		t.Run(path, func(t *testing.T) {
+			w := get(ctx, http.MethodGet, path)
+			assert.Equal(t, w.Code, http.StatusNotFound, "status mismatch")
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	setupNotes(t, ctx.DB)
+
+	n2RowID := mustGetRowID(t, ctx.DB, "n2-uuid")
+
+	t.Run("match", func(t *testing.T) {
+		w := get(ctx, http.MethodGet, "/search?q=aligns")
+		assert.Equal(t, w.Code, http.StatusOK, "status mismatch")
+
+		body := w.Body.String()
+		assertContains(t, body, fmt.Sprintf(`<a href="/notes/%d">flexbox</a>`, n2RowID))
+		assertContains(t, body, "<mark>aligns</mark>")
+		assertNotContains(t, body, "grid")
+	})
+
+	t.Run("within book", func(t *testing.T) {
+		w := get(ctx, http.MethodGet, "/search?q=aligns&book=css")
+		assert.Equal(t, w.Code, http.StatusOK, "status mismatch")
+		assertContains(t, w.Body.String(), fmt.Sprintf(`<a href="/notes/%d">flexbox</a>`, n2RowID))
+	})
+
+	t.Run("within other book", func(t *testing.T) {
+		w := get(ctx, http.MethodGet, "/search?q=aligns&book=js")
+		assert.Equal(t, w.Code, http.StatusOK, "status mismatch")
+		assertNotContains(t, w.Body.String(), fmt.Sprintf(`href="/notes/%d"`, n2RowID))
+	})
+
+	t.Run("quote", func(t *testing.T) {
+		w := get(ctx, http.MethodGet, `/search?q=%22flexbox`)
+		assert.Equal(t, w.Code, http.StatusOK, "status mismatch")
+	})
+}
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	w := get(ctx, http.MethodPost, "/")
+	assert.Equal(t, w.Code, http.StatusMethodNotAllowed, "status mismatch")
+	assert.Equal(t, w.Header().Get("Allow"), "GET, HEAD", "Allow mismatch")
+}
+
+func TestCheckHost(t *testing.T) {
+	ctx := context.InitTestCtx(t, "../../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	testCases := []struct {
+		host     string
+		expected int
+	}{
+		{
+			host:     "localhost:3030",
+			expected: http.StatusOK,
+		},
+		{
+			host:     "127.0.0.1:3030",
+			expected: http.StatusOK,
+		},
+		{
+			host:     "LOCALHOST:3030",
+			expected: http.StatusOK,
+		},
+		{
+			host:     "localhost:8080",
+			expected: http.StatusForbidden,
+		},
+		{
+			host:     "attacker.example.com:3030",
+			expected: http.StatusForbidden,
+		},
+		{
+			host:     "localhost",
+			expected: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.host, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Host = tc.host
+
+			w := httptest.NewRecorder()
+			newRouter(ctx, testPort).ServeHTTP(w, r)
+
+			assert.Equal(t, w.Code, tc.expected, "status mismatch")
+		})
+	}
+}
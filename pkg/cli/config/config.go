@@ -32,6 +32,10 @@ import (
 type Config struct {
 	Editor      string `yaml:"editor"`
 	APIEndpoint string `yaml:"apiEndpoint"`
+	// ReleaseSource is the URL from which 'dnote upgrade' downloads releases
+	ReleaseSource string `yaml:"releaseSource,omitempty"`
+	// ReleasePublicKey is the public key that verifies the releases from the source
+	ReleasePublicKey string `yaml:"releasePublicKey,omitempty"`
 }
 
 // GetPath returns the path to the dnote config file
@@ -45,4 +45,8 @@ var (
 	SystemSessionKey = "session_token"
 	// SystemSessionKeyExpiry is the timestamp at which the session key will expire
 	SystemSessionKeyExpiry = "session_token_expiry"
+	// SystemGitDir is the path of the git repository that mirrors the notes
+	SystemGitDir = "git_dir"
+	// SystemGitCommit is the commit of the git repository at the last mirror sync
+	SystemGitCommit = "git_commit"
 )
@@ -34,6 +34,8 @@ type DnoteCtx struct {
 	SessionKey       string
 	SessionKeyExpiry int64
 	Editor           string
+	ReleaseSource    string
+	ReleasePublicKey string
 	Clock            clock.Clock
 }
 
@@ -24,12 +24,15 @@ import (
 
 // Book holds a metadata and its notes
 type Book struct {
-	UUID    string `json:"uuid"`
-	Label   string `json:"label"`
-	USN     int    `json:"usn"`
-	Notes   []Note `json:"notes"`
-	Deleted bool   `json:"deleted"`
-	Dirty   bool   `json:"dirty"`
+	UUID  string `json:"uuid"`
+	Label string `json:"label"`
+	// ParentUUID is the uuid of the book that contains the book. It is empty
+	// for a top-level book.
+	ParentUUID string `json:"parent_uuid"`
+	USN        int    `json:"usn"`
+	Notes      []Note `json:"notes"`
+	Deleted    bool   `json:"deleted"`
+	Dirty      bool   `json:"dirty"`
 }
 
 // Note represents a note
@@ -70,6 +73,10 @@ func (n Note) Insert(db *DB) error {
 		return errors.Wrapf(err, "inserting note with uuid %s", n.UUID)
 	}
 
+	if err := UpdateNoteLinks(db, n.UUID, n.Body); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -82,6 +89,10 @@ func (n Note) Update(db *DB) error {
 		return errors.Wrapf(err, "updating the note with uuid %s", n.UUID)
 	}
 
+	if err := UpdateNoteLinks(db, n.UUID, n.Body); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -93,18 +104,35 @@ func (n *Note) UpdateUUID(db *DB, newUUID string) error {
 		return errors.Wrapf(err, "updating note uuid from '%s' to '%s'", n.UUID, newUUID)
 	}
 
+	if _, err := db.Exec("UPDATE note_links SET source_uuid = ? WHERE source_uuid = ?", newUUID, n.UUID); err != nil {
+		return errors.Wrap(err, "updating the source of links")
+	}
+	if _, err := db.Exec("UPDATE note_links SET target_uuid = ? WHERE target_uuid = ?", newUUID, n.UUID); err != nil {
+		return errors.Wrap(err, "updating the target of links")
+	}
+	if _, err := db.Exec("UPDATE git_files SET note_uuid = ? WHERE note_uuid = ?", newUUID, n.UUID); err != nil {
+		return errors.Wrap(err, "updating the files of the git mirror")
+	}
+
 	n.UUID = newUUID
 
 	return nil
 }
 
-// Expunge hard-deletes the note from the database
+// Expunge hard-deletes the note, its attachments and its links from the database
 func (n Note) Expunge(db *DB) error {
 	_, err := db.Exec("DELETE FROM notes WHERE uuid = ?", n.UUID)
 	if err != nil {
 		return errors.Wrap(err, "expunging a note locally")
 	}
 
+	if err := DeleteNoteAttachments(db, n.UUID); err != nil {
+		return err
+	}
+	if err := DeleteNoteLinks(db, n.UUID); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -121,8 +149,8 @@ func NewBook(uuid, label string, usn int, deleted, dirty bool) Book {
 
 // Insert inserts a new book
 func (b Book) Insert(db *DB) error {
-	_, err := db.Exec("INSERT INTO books (uuid, label, usn, dirty, deleted) VALUES (?, ?, ?, ?, ?)",
-		b.UUID, b.Label, b.USN, b.Dirty, b.Deleted)
+	_, err := db.Exec("INSERT INTO books (uuid, label, parent_uuid, usn, dirty, deleted) VALUES (?, ?, ?, ?, ?, ?)",
+		b.UUID, b.Label, b.ParentUUID, b.USN, b.Dirty, b.Deleted)
 
 	if err != nil {
 		return errors.Wrapf(err, "inserting book with uuid %s", b.UUID)
@@ -133,8 +161,8 @@ func (b Book) Insert(db *DB) error {
 
 // Update updates the book with the given data
 func (b Book) Update(db *DB) error {
-	_, err := db.Exec("UPDATE books SET label = ?, usn = ?, dirty = ?, deleted = ? WHERE uuid = ?",
-		b.Label, b.USN, b.Dirty, b.Deleted, b.UUID)
+	_, err := db.Exec("UPDATE books SET label = ?, parent_uuid = ?, usn = ?, dirty = ?, deleted = ? WHERE uuid = ?",
+		b.Label, b.ParentUUID, b.USN, b.Dirty, b.Deleted, b.UUID)
 
 	if err != nil {
 		return errors.Wrapf(err, "updating the book with uuid %s", b.UUID)
@@ -143,7 +171,7 @@ func (b Book) Update(db *DB) error {
 	return nil
 }
 
-// UpdateUUID updates the uuid of a book
+// UpdateUUID updates the uuid of a book, and the parent_uuid of its child books
 func (b *Book) UpdateUUID(db *DB, newUUID string) error {
 	_, err := db.Exec("UPDATE books SET uuid = ? WHERE uuid = ?", newUUID, b.UUID)
 
@@ -151,6 +179,10 @@ func (b *Book) UpdateUUID(db *DB, newUUID string) error {
 		return errors.Wrapf(err, "updating book uuid from '%s' to '%s'", b.UUID, newUUID)
 	}
 
+	if _, err := db.Exec("UPDATE books SET parent_uuid = ? WHERE parent_uuid = ?", newUUID, b.UUID); err != nil {
+		return errors.Wrapf(err, "updating the parent of child books of '%s'", b.UUID)
+	}
+
 	b.UUID = newUUID
 
 	return nil
@@ -165,3 +197,26 @@ func (b Book) Expunge(db *DB) error {
 
 	return nil
 }
+
+// Attachment is the metadata of a file attached to a note. The content is
+// downloaded from the server on demand.
+type Attachment struct {
+	UUID        string `json:"uuid"`
+	NoteUUID    string `json:"note_uuid"`
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Hash        string `json:"hash"`
+}
+
+// Insert inserts a new attachment
+func (a Attachment) Insert(db *DB) error {
+	_, err := db.Exec("INSERT INTO attachments (uuid, note_uuid, name, content_type, size, hash) VALUES (?, ?, ?, ?, ?, ?)",
+		a.UUID, a.NoteUUID, a.Name, a.ContentType, a.Size, a.Hash)
+
+	if err != nil {
+		return errors.Wrapf(err, "inserting attachment with uuid %s", a.UUID)
+	}
+
+	return nil
+}
@@ -20,8 +20,12 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
 
+	"github.com/dnote/dnote/pkg/cli/utils"
 	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/wikilink"
 	"github.com/pkg/errors"
 )
 
@@ -83,23 +87,26 @@ func DeleteSystem(db *DB, key string) error {
 
 // NoteInfo is a basic information about a note
 type NoteInfo struct {
-	RowID     int
+	RowID int
+	// BookLabel is the path of the book, such as infra/k8s
 	BookLabel string
 	UUID      string
 	Content   string
 	AddedOn   int64
 	EditedOn  int64
+	USN       int
+	Public    bool
 }
 
 // GetNoteInfo returns a NoteInfo for the note with the given noteRowID
 func GetNoteInfo(db *DB, noteRowID int) (NoteInfo, error) {
 	var ret NoteInfo
 
-	err := db.QueryRow(`SELECT books.label, notes.uuid, notes.body, notes.added_on, notes.edited_on, notes.rowid
+	err := db.QueryRow(BookPaths+` SELECT book_paths.path, notes.uuid, notes.body, notes.added_on, notes.edited_on, notes.rowid, notes.usn, notes.public
 			FROM notes
-			INNER JOIN books ON books.uuid = notes.book_uuid
+			INNER JOIN book_paths ON book_paths.uuid = notes.book_uuid
 			WHERE notes.rowid = ? AND notes.deleted = false`, noteRowID).
-		Scan(&ret.BookLabel, &ret.UUID, &ret.Content, &ret.AddedOn, &ret.EditedOn, &ret.RowID)
+		Scan(&ret.BookLabel, &ret.UUID, &ret.Content, &ret.AddedOn, &ret.EditedOn, &ret.RowID, &ret.USN, &ret.Public)
 	if err == sql.ErrNoRows {
 		return ret, errors.Errorf("note %d not found", noteRowID)
 	} else if err != nil {
@@ -113,15 +120,29 @@ func GetNoteInfo(db *DB, noteRowID int) (NoteInfo, error) {
 type BookInfo struct {
 	RowID int
 	UUID  string
-	Name  string
+	// Name is the path of the book, such as infra/k8s
+	Name string
 }
 
+// BookPaths is a common table expression of the path of every book, such as
+// infra/k8s for a book k8s in a book infra, and its depth from the top level.
+// A book whose parent is not available locally is regarded as a top-level book.
+const BookPaths = `WITH RECURSIVE book_paths(uuid, path, depth) AS (
+		SELECT uuid, label, 0 FROM books
+		WHERE parent_uuid = '' OR parent_uuid NOT IN (SELECT uuid FROM books)
+		UNION ALL
+		SELECT books.uuid, book_paths.path || '/' || books.label, book_paths.depth + 1
+		FROM books
+		INNER JOIN book_paths ON books.parent_uuid = book_paths.uuid
+	)`
+
 // GetBookInfo returns a BookInfo for the book with the given uuid
 func GetBookInfo(db *DB, uuid string) (BookInfo, error) {
 	var ret BookInfo
 
-	err := db.QueryRow(`SELECT books.rowid, books.uuid, books.label
+	err := db.QueryRow(BookPaths+` SELECT books.rowid, books.uuid, book_paths.path
 			FROM books
+			INNER JOIN book_paths ON book_paths.uuid = books.uuid
 			WHERE books.uuid = ? AND books.deleted = false`, uuid).
 		Scan(&ret.RowID, &ret.UUID, &ret.Name)
 	if err == sql.ErrNoRows {
@@ -133,12 +154,12 @@ func GetBookInfo(db *DB, uuid string) (BookInfo, error) {
 	return ret, nil
 }
 
-// GetBookUUID returns a uuid of a book given a label
-func GetBookUUID(db *DB, label string) (string, error) {
+// GetBookUUID returns a uuid of a book given its path, such as infra/k8s
+func GetBookUUID(db *DB, path string) (string, error) {
 	var ret string
-	err := db.QueryRow("SELECT uuid FROM books WHERE label = ?", label).Scan(&ret)
+	err := db.QueryRow(BookPaths+" SELECT uuid FROM book_paths WHERE path = ?", path).Scan(&ret)
 	if err == sql.ErrNoRows {
-		return ret, errors.Errorf("book '%s' not found", label)
+		return ret, errors.Errorf("book '%s' not found", path)
 	} else if err != nil {
 		return ret, errors.Wrap(err, "querying the book")
 	}
@@ -146,6 +167,107 @@ func GetBookUUID(db *DB, label string) (string, error) {
 	return ret, nil
 }
 
+// FindOrCreateBook returns the uuid of the book at the given path, such as
+// infra/k8s. It creates the book, and any book in the path that does not exist.
+func FindOrCreateBook(tx *DB, path string) (string, error) {
+	var parentUUID string
+	names := strings.Split(path, "/")
+
+	for idx, name := range names {
+		var uuid string
+		err := tx.QueryRow(BookPaths+" SELECT uuid FROM book_paths WHERE path = ?", strings.Join(names[:idx+1], "/")).Scan(&uuid)
+		if err == sql.ErrNoRows {
+			uuid, err = utils.GenerateUUID()
+			if err != nil {
+				return "", errors.Wrap(err, "generating uuid")
+			}
+
+			b := NewBook(uuid, name, 0, false, true)
+			b.ParentUUID = parentUUID
+			if err := b.Insert(tx); err != nil {
+				return "", errors.Wrap(err, "creating the book")
+			}
+		} else if err != nil {
+			return "", errors.Wrap(err, "finding the book")
+		}
+
+		parentUUID = uuid
+	}
+
+	return parentUUID, nil
+}
+
+// GetBookSubtree returns the uuids of the book with the given uuid and all of
+// the books nested in it
+func GetBookSubtree(db *DB, uuid string) ([]string, error) {
+	rows, err := db.Query(`WITH RECURSIVE subtree(uuid) AS (
+			SELECT ?
+			UNION ALL
+			SELECT books.uuid FROM books INNER JOIN subtree ON books.parent_uuid = subtree.uuid
+		)
+		SELECT uuid FROM subtree`, uuid)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying books")
+	}
+	defer rows.Close()
+
+	ret := []string{}
+	for rows.Next() {
+		var uuid string
+		if err := rows.Scan(&uuid); err != nil {
+			return nil, errors.Wrap(err, "scanning a row for book")
+		}
+
+		ret = append(ret, uuid)
+	}
+
+	return ret, nil
+}
+
+// MoveBook changes the path of the book with the given uuid and marks it dirty.
+// The book is renamed to the last name in the path and moved into the book at the
+// rest of the path, which must exist. The books nested in the book move with it.
+func MoveBook(db *DB, uuid, path string) error {
+	var parentUUID string
+	name := path
+	if idx := strings.LastIndex(path, "/"); idx != -1 {
+		name = path[idx+1:]
+
+		var err error
+		parentUUID, err = GetBookUUID(db, path[:idx])
+		if err != nil {
+			return err
+		}
+
+		subtree, err := GetBookSubtree(db, uuid)
+		if err != nil {
+			return errors.Wrap(err, "getting the nested books")
+		}
+		for _, u := range subtree {
+			if u == parentUUID {
+				return errors.New("cannot move a book into itself")
+			}
+		}
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT count(*) FROM books WHERE parent_uuid = ? AND label = ? AND uuid != ?", parentUUID, name, uuid).Scan(&count); err != nil {
+		return errors.Wrap(err, "checking for a duplicate book")
+	}
+	if count > 0 {
+		return errors.Errorf("book '%s' already exists", path)
+	}
+
+	_, err := db.Exec(`UPDATE books
+		SET label = ?, parent_uuid = ?, dirty = ?
+		WHERE uuid = ?`, name, parentUUID, true, uuid)
+	if err != nil {
+		return errors.Wrap(err, "updating the book")
+	}
+
+	return nil
+}
+
 // UpdateBookName updates a book name
 func UpdateBookName(db *DB, uuid string, name string) error {
 	_, err := db.Exec(`UPDATE books
@@ -206,6 +328,14 @@ func UpdateNoteContent(db *DB, c clock.Clock, rowID int, content string) error {
 		return errors.Wrap(err, "updating the note")
 	}
 
+	var uuid string
+	if err := db.QueryRow("SELECT uuid FROM notes WHERE rowid = ?", rowID).Scan(&uuid); err != nil {
+		return errors.Wrap(err, "finding the note uuid")
+	}
+	if err := UpdateNoteLinks(db, uuid, content); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -222,3 +352,198 @@ func UpdateNoteBook(db *DB, c clock.Clock, rowID int, bookUUID string) error {
 
 	return nil
 }
+
+// GetNoteAttachments returns the attachments of the note with the given uuid
+func GetNoteAttachments(db *DB, noteUUID string) ([]Attachment, error) {
+	rows, err := db.Query("SELECT uuid, note_uuid, name, content_type, size, hash FROM attachments WHERE note_uuid = ? ORDER BY rowid ASC", noteUUID)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying attachments")
+	}
+	defer rows.Close()
+
+	ret := []Attachment{}
+	for rows.Next() {
+		var a Attachment
+		if err := rows.Scan(&a.UUID, &a.NoteUUID, &a.Name, &a.ContentType, &a.Size, &a.Hash); err != nil {
+			return nil, errors.Wrap(err, "scanning a row for attachment")
+		}
+
+		ret = append(ret, a)
+	}
+
+	return ret, nil
+}
+
+// DeleteNoteAttachments deletes the attachments of the note with the given uuid
+func DeleteNoteAttachments(db *DB, noteUUID string) error {
+	if _, err := db.Exec("DELETE FROM attachments WHERE note_uuid = ?", noteUUID); err != nil {
+		return errors.Wrap(err, "deleting attachments")
+	}
+
+	return nil
+}
+
+// ReplaceNoteAttachments replaces the attachments of the note with the given uuid
+func ReplaceNoteAttachments(db *DB, noteUUID string, attachments []Attachment) error {
+	if err := DeleteNoteAttachments(db, noteUUID); err != nil {
+		return err
+	}
+
+	for _, a := range attachments {
+		if err := a.Insert(db); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolveLink returns the uuid of the note that the given link refers to. It returns
+// an empty string if the link refers to a book and a title that no note has.
+func resolveLink(db *DB, l wikilink.Link) (string, error) {
+	if l.UUID != "" {
+		return l.UUID, nil
+	}
+
+	rows, err := db.Query(BookPaths+` SELECT notes.uuid, notes.body
+			FROM notes
+			INNER JOIN book_paths ON book_paths.uuid = notes.book_uuid
+			WHERE book_paths.path = ? AND notes.deleted = false
+			ORDER BY notes.added_on ASC`, l.Book)
+	if err != nil {
+		return "", errors.Wrap(err, "querying notes in the book")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var uuid, body string
+		if err := rows.Scan(&uuid, &body); err != nil {
+			return "", errors.Wrap(err, "scanning a row for note")
+		}
+
+		if wikilink.Title(body) == l.Title {
+			return uuid, nil
+		}
+	}
+
+	return "", nil
+}
+
+// UpdateNoteLinks replaces the links from the note with the given uuid with the
+// links in the body. Links are stored by the uuid of the target, so that they
+// keep working after the target is moved or its book is renamed. For the same
+// reason, a link that no longer resolves keeps the target it had before.
+func UpdateNoteLinks(db *DB, noteUUID, body string) error {
+	rows, err := db.Query("SELECT text, target_uuid FROM note_links WHERE source_uuid = ?", noteUUID)
+	if err != nil {
+		return errors.Wrap(err, "querying links")
+	}
+
+	prev := map[string]string{}
+	for rows.Next() {
+		var text, target string
+		if err := rows.Scan(&text, &target); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "scanning a row for link")
+		}
+
+		prev[text] = target
+	}
+	rows.Close()
+
+	if err := DeleteNoteLinks(db, noteUUID); err != nil {
+		return err
+	}
+
+	for _, l := range wikilink.Parse(body) {
+		target, err := resolveLink(db, l)
+		if err != nil {
+			return errors.Wrapf(err, "resolving the link %s", l.Text)
+		}
+		if target == "" {
+			target = prev[l.Text]
+		}
+		if target == "" || target == noteUUID {
+			continue
+		}
+
+		if _, err := db.Exec("INSERT INTO note_links (source_uuid, target_uuid, text) VALUES (?, ?, ?)", noteUUID, target, l.Text); err != nil {
+			return errors.Wrap(err, "inserting a link")
+		}
+	}
+
+	return nil
+}
+
+// DeleteNoteLinks deletes the links from the note with the given uuid
+func DeleteNoteLinks(db *DB, noteUUID string) error {
+	if _, err := db.Exec("DELETE FROM note_links WHERE source_uuid = ?", noteUUID); err != nil {
+		return errors.Wrap(err, "deleting links")
+	}
+
+	return nil
+}
+
+// RemoveNote marks the note with the given uuid as deleted and dirty, and deletes
+// its content and its links
+func RemoveNote(db *DB, noteUUID string) error {
+	if _, err := db.Exec("UPDATE notes SET deleted = ?, dirty = ?, body = ? WHERE uuid = ?", true, true, "", noteUUID); err != nil {
+		return errors.Wrap(err, "removing the note")
+	}
+	if err := DeleteNoteLinks(db, noteUUID); err != nil {
+		return errors.Wrap(err, "removing the links of the note")
+	}
+
+	return nil
+}
+
+func queryNoteInfos(db *DB, query string, args ...interface{}) ([]NoteInfo, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying notes")
+	}
+	defer rows.Close()
+
+	ret := []NoteInfo{}
+	for rows.Next() {
+		var info NoteInfo
+		if err := rows.Scan(&info.BookLabel, &info.UUID, &info.Content, &info.AddedOn, &info.EditedOn, &info.RowID, &info.USN, &info.Public); err != nil {
+			return nil, errors.Wrap(err, "scanning a row for note")
+		}
+
+		ret = append(ret, info)
+	}
+
+	return ret, nil
+}
+
+// FTSQuery returns a full text search query matching all of the given keywords.
+// Each keyword is quoted so that it is treated as a string by SQLite FTS5.
+func FTSQuery(s string) string {
+	terms := []string{}
+	for _, term := range strings.Fields(s) {
+		terms = append(terms, fmt.Sprintf("\"%s\"", strings.Replace(term, "\"", "\"\"", -1)))
+	}
+
+	return strings.Join(terms, " ")
+}
+
+// GetNoteLinks returns the notes that the note with the given uuid links to
+func GetNoteLinks(db *DB, noteUUID string) ([]NoteInfo, error) {
+	return queryNoteInfos(db, BookPaths+` SELECT book_paths.path, notes.uuid, notes.body, notes.added_on, notes.edited_on, notes.rowid, notes.usn, notes.public
+			FROM note_links
+			INNER JOIN notes ON notes.uuid = note_links.target_uuid
+			INNER JOIN book_paths ON book_paths.uuid = notes.book_uuid
+			WHERE note_links.source_uuid = ? AND notes.deleted = false
+			ORDER BY note_links.rowid ASC`, noteUUID)
+}
+
+// GetNoteBacklinks returns the notes that link to the note with the given uuid
+func GetNoteBacklinks(db *DB, noteUUID string) ([]NoteInfo, error) {
+	return queryNoteInfos(db, BookPaths+` SELECT book_paths.path, notes.uuid, notes.body, notes.added_on, notes.edited_on, notes.rowid, notes.usn, notes.public
+			FROM note_links
+			INNER JOIN notes ON notes.uuid = note_links.source_uuid
+			INNER JOIN book_paths ON book_paths.uuid = notes.book_uuid
+			WHERE note_links.target_uuid = ? AND notes.deleted = false
+			ORDER BY notes.added_on ASC`, noteUUID)
+}
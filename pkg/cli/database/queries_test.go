@@ -359,6 +359,38 @@ func TestUpdateNoteBook(t *testing.T) {
 	assert.Equal(t, dirty, true, "dirty mismatch")
 }
 
+func TestRemoveNote(t *testing.T) {
+	// set up
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer CloseTestDB(t, db)
+
+	b1UUID := "b1-uuid"
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", b1UUID, "b1-label", 8, false, false)
+	MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, edited_on, usn, public, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", "n1-uuid", b1UUID, "n1 content [[n2-uuid]]", 1542058875, 0, 1, false, false, false)
+	MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, body, added_on, edited_on, usn, public, deleted, dirty) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)", "n2-uuid", b1UUID, "n2 content", 1542058876, 0, 2, false, false, false)
+	MustExec(t, "inserting a link", db, "INSERT INTO note_links (source_uuid, target_uuid, text) VALUES (?, ?, ?)", "n1-uuid", "n2-uuid", "n2-uuid")
+
+	// execute
+	if err := RemoveNote(db, "n1-uuid"); err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// test
+	var n1, n2 Note
+	MustScan(t, "getting n1", db.QueryRow("SELECT body, deleted, dirty FROM notes WHERE uuid = ?", "n1-uuid"), &n1.Body, &n1.Deleted, &n1.Dirty)
+	MustScan(t, "getting n2", db.QueryRow("SELECT body, deleted, dirty FROM notes WHERE uuid = ?", "n2-uuid"), &n2.Body, &n2.Deleted, &n2.Dirty)
+	assert.Equal(t, n1.Body, "", "n1 body mismatch")
+	assert.Equal(t, n1.Deleted, true, "n1 deleted mismatch")
+	assert.Equal(t, n1.Dirty, true, "n1 dirty mismatch")
+	assert.Equal(t, n2.Body, "n2 content", "n2 body mismatch")
+	assert.Equal(t, n2.Deleted, false, "n2 deleted mismatch")
+	assert.Equal(t, n2.Dirty, false, "n2 dirty mismatch")
+
+	var linkCount int
+	MustScan(t, "counting links", db.QueryRow("SELECT count(*) FROM note_links"), &linkCount)
+	assert.Equal(t, linkCount, 0, "link count mismatch")
+}
+
 func TestUpdateBookName(t *testing.T) {
 	// set up
 	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
@@ -382,3 +414,262 @@ func TestUpdateBookName(t *testing.T) {
 	assert.Equal(t, b1.USN, 8, "USN mismatch")
 	assert.Equal(t, b1.Deleted, false, "Deleted mismatch")
 }
+
+func TestGetBookUUID(t *testing.T) {
+	// set up
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer CloseTestDB(t, db)
+
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, parent_uuid) VALUES (?, ?, ?)", "b1-uuid", "infra", "")
+	MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label, parent_uuid) VALUES (?, ?, ?)", "b2-uuid", "k8s", "b1-uuid")
+	MustExec(t, "inserting b3", db, "INSERT INTO books (uuid, label, parent_uuid) VALUES (?, ?, ?)", "b3-uuid", "networking", "b2-uuid")
+	MustExec(t, "inserting b4", db, "INSERT INTO books (uuid, label, parent_uuid) VALUES (?, ?, ?)", "b4-uuid", "k8s", "")
+
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{path: "infra", expected: "b1-uuid"},
+		{path: "infra/k8s", expected: "b2-uuid"},
+		{path: "infra/k8s/networking", expected: "b3-uuid"},
+		{path: "k8s", expected: "b4-uuid"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			got, err := GetBookUUID(db, tc.path)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "executing"))
+			}
+
+			assert.Equal(t, got, tc.expected, "uuid mismatch")
+		})
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := GetBookUUID(db, "networking")
+		assert.Equal(t, err.Error(), "book 'networking' not found", "error mismatch")
+	})
+}
+
+func TestGetBookSubtree(t *testing.T) {
+	// set up
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer CloseTestDB(t, db)
+
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, parent_uuid) VALUES (?, ?, ?)", "b1-uuid", "b1-label", "")
+	MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label, parent_uuid) VALUES (?, ?, ?)", "b2-uuid", "b2-label", "b1-uuid")
+	MustExec(t, "inserting b3", db, "INSERT INTO books (uuid, label, parent_uuid) VALUES (?, ?, ?)", "b3-uuid", "b3-label", "b2-uuid")
+	MustExec(t, "inserting b4", db, "INSERT INTO books (uuid, label, parent_uuid) VALUES (?, ?, ?)", "b4-uuid", "b4-label", "")
+
+	// execute
+	got, err := GetBookSubtree(db, "b2-uuid")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// test
+	assert.DeepEqual(t, got, []string{"b2-uuid", "b3-uuid"}, "subtree mismatch")
+}
+
+func TestMoveBook(t *testing.T) {
+	setup := func(t *testing.T, db *DB) {
+		MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, parent_uuid, usn) VALUES (?, ?, ?, ?)", "b1-uuid", "infra", "", 1)
+		MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label, parent_uuid, usn) VALUES (?, ?, ?, ?)", "b2-uuid", "k8s", "", 2)
+		MustExec(t, "inserting b3", db, "INSERT INTO books (uuid, label, parent_uuid, usn) VALUES (?, ?, ?, ?)", "b3-uuid", "networking", "b2-uuid", 3)
+		MustExec(t, "inserting b4", db, "INSERT INTO books (uuid, label, parent_uuid, usn) VALUES (?, ?, ?, ?)", "b4-uuid", "networking", "b1-uuid", 4)
+	}
+
+	t.Run("into another book", func(t *testing.T) {
+		// set up
+		db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+		defer CloseTestDB(t, db)
+		setup(t, db)
+
+		// execute
+		if err := MoveBook(db, "b2-uuid", "infra/kubernetes"); err != nil {
+			t.Fatal(errors.Wrap(err, "executing"))
+		}
+
+		// test
+		var b2 Book
+		MustScan(t, "getting b2", db.QueryRow("SELECT label, parent_uuid, dirty, usn FROM books WHERE uuid = ?", "b2-uuid"), &b2.Label, &b2.ParentUUID, &b2.Dirty, &b2.USN)
+		assert.Equal(t, b2.Label, "kubernetes", "Label mismatch")
+		assert.Equal(t, b2.ParentUUID, "b1-uuid", "ParentUUID mismatch")
+		assert.Equal(t, b2.Dirty, true, "Dirty mismatch")
+		assert.Equal(t, b2.USN, 2, "USN mismatch")
+
+		// the nested book should move with it
+		b3UUID, err := GetBookUUID(db, "infra/kubernetes/networking")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting b3"))
+		}
+		assert.Equal(t, b3UUID, "b3-uuid", "b3 uuid mismatch")
+	})
+
+	t.Run("to the top level", func(t *testing.T) {
+		// set up
+		db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+		defer CloseTestDB(t, db)
+		setup(t, db)
+
+		// execute
+		if err := MoveBook(db, "b3-uuid", "networking"); err != nil {
+			t.Fatal(errors.Wrap(err, "executing"))
+		}
+
+		// test
+		var b3 Book
+		MustScan(t, "getting b3", db.QueryRow("SELECT label, parent_uuid, dirty FROM books WHERE uuid = ?", "b3-uuid"), &b3.Label, &b3.ParentUUID, &b3.Dirty)
+		assert.Equal(t, b3.Label, "networking", "Label mismatch")
+		assert.Equal(t, b3.ParentUUID, "", "ParentUUID mismatch")
+		assert.Equal(t, b3.Dirty, true, "Dirty mismatch")
+	})
+
+	t.Run("into itself", func(t *testing.T) {
+		// set up
+		db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+		defer CloseTestDB(t, db)
+		setup(t, db)
+
+		// execute
+		err := MoveBook(db, "b2-uuid", "k8s/networking/k8s")
+
+		// test
+		assert.Equal(t, err.Error(), "cannot move a book into itself", "error mismatch")
+
+		var b2 Book
+		MustScan(t, "getting b2", db.QueryRow("SELECT label, parent_uuid, dirty FROM books WHERE uuid = ?", "b2-uuid"), &b2.Label, &b2.ParentUUID, &b2.Dirty)
+		assert.Equal(t, b2.Label, "k8s", "Label mismatch")
+		assert.Equal(t, b2.ParentUUID, "", "ParentUUID mismatch")
+		assert.Equal(t, b2.Dirty, false, "Dirty mismatch")
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		// set up
+		db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+		defer CloseTestDB(t, db)
+		setup(t, db)
+
+		// execute
+		err := MoveBook(db, "b3-uuid", "infra/networking")
+
+		// test
+		assert.Equal(t, err.Error(), "book 'infra/networking' already exists", "error mismatch")
+	})
+
+	t.Run("parent not found", func(t *testing.T) {
+		// set up
+		db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+		defer CloseTestDB(t, db)
+		setup(t, db)
+
+		// execute
+		err := MoveBook(db, "b3-uuid", "web/networking")
+
+		// test
+		assert.Equal(t, err.Error(), "book 'web' not found", "error mismatch")
+	})
+}
+
+func TestUpdateNoteLinks(t *testing.T) {
+	// set up
+	db := InitTestDB(t, "../tmp/dnote-test.db", nil)
+	defer CloseTestDB(t, db)
+
+	b1UUID := "b1-uuid"
+	b2UUID := "b2-uuid"
+	MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", b1UUID, "js", 1, false, false)
+	MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label, usn, deleted, dirty) VALUES (?, ?, ?, ?, ?)", b2UUID, "go", 2, false, false)
+
+	n1UUID := "0a1a5b06-2c31-4a4b-9f3a-6d1e1f4a6b01"
+	n2UUID := "0a1a5b06-2c31-4a4b-9f3a-6d1e1f4a6b02"
+	n3UUID := "0a1a5b06-2c31-4a4b-9f3a-6d1e1f4a6b03"
+	n1 := NewNote(n1UUID, b1UUID, "# closures\ncontent", 1, 0, 0, false, false, true)
+	n2 := NewNote(n2UUID, b2UUID, "goroutines", 2, 0, 0, false, false, true)
+	n3 := NewNote(n3UUID, b1UUID, "see [[js/closures]], [[js/missing]], [[0a1a5b06-2c31-4a4b-9f3a-6d1e1f4a6b02]] and [[js/see]]", 3, 0, 0, false, false, true)
+	for _, n := range []Note{n1, n2, n3} {
+		if err := n.Insert(db); err != nil {
+			t.Fatal(errors.Wrap(err, "inserting a note"))
+		}
+	}
+
+	var n1RowID, n3RowID int
+	MustScan(t, "getting n1 rowid", db.QueryRow("SELECT rowid FROM notes WHERE uuid = ?", n1UUID), &n1RowID)
+	MustScan(t, "getting n3 rowid", db.QueryRow("SELECT rowid FROM notes WHERE uuid = ?", n3UUID), &n3RowID)
+
+	getUUIDs := func(infos []NoteInfo) []string {
+		ret := []string{}
+		for _, info := range infos {
+			ret = append(ret, info.UUID)
+		}
+
+		return ret
+	}
+
+	links, err := GetNoteLinks(db, n3UUID)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting links"))
+	}
+	assert.DeepEqual(t, getUUIDs(links), []string{n1UUID, n2UUID}, "links mismatch")
+
+	// moving the target and renaming its book does not break the link
+	c := clock.NewMock()
+	if err := UpdateNoteBook(db, c, n1RowID, b2UUID); err != nil {
+		t.Fatal(errors.Wrap(err, "moving n1"))
+	}
+	if err := UpdateBookName(db, b1UUID, "javascript"); err != nil {
+		t.Fatal(errors.Wrap(err, "renaming b1"))
+	}
+	if err := UpdateNoteContent(db, c, n3RowID, "see [[js/closures]] and [[0a1a5b06-2c31-4a4b-9f3a-6d1e1f4a6b02]] again"); err != nil {
+		t.Fatal(errors.Wrap(err, "editing n3"))
+	}
+
+	backlinks, err := GetNoteBacklinks(db, n1UUID)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting backlinks"))
+	}
+	assert.DeepEqual(t, getUUIDs(backlinks), []string{n3UUID}, "n1 backlinks mismatch")
+	assert.Equal(t, backlinks[0].BookLabel, "javascript", "book label mismatch")
+
+	// removing a link from the body removes it
+	if err := UpdateNoteContent(db, c, n3RowID, "see [[js/closures]]"); err != nil {
+		t.Fatal(errors.Wrap(err, "editing n3"))
+	}
+
+	backlinks, err = GetNoteBacklinks(db, n2UUID)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting backlinks"))
+	}
+	assert.DeepEqual(t, getUUIDs(backlinks), []string{}, "n2 backlinks mismatch")
+
+	var linkCount int
+	MustScan(t, "counting links", db.QueryRow("SELECT count(*) FROM note_links"), &linkCount)
+	assert.Equal(t, linkCount, 1, "link count mismatch")
+}
+
+func TestFTSQuery(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input:    "",
+			expected: "",
+		},
+		{
+			input:    " merge  sort ",
+			expected: `"merge" "sort"`,
+		},
+		{
+			input:    `say "hi"`,
+			expected: `"say" """hi"""`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			assert.Equal(t, FTSQuery(tc.input), tc.expected, "result mismatch")
+		})
+	}
+}
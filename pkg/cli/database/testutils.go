@@ -34,13 +34,13 @@ var defaultSchemaSQL = `CREATE TABLE books
 		(
 			uuid text PRIMARY KEY,
 			label text NOT NULL
-		, dirty bool DEFAULT false, usn int DEFAULT 0 NOT NULL, deleted bool DEFAULT false);
+		, dirty bool DEFAULT false, usn int DEFAULT 0 NOT NULL, deleted bool DEFAULT false, parent_uuid text NOT NULL DEFAULT '');
 CREATE TABLE system
 		(
 			key string NOT NULL,
 			value text NOT NULL
 		);
-CREATE UNIQUE INDEX idx_books_label ON books(label);
+CREATE UNIQUE INDEX idx_books_parent_uuid_label ON books(parent_uuid, label);
 CREATE UNIQUE INDEX idx_books_uuid ON books(uuid);
 CREATE TABLE IF NOT EXISTS "notes"
 		(
@@ -79,7 +79,31 @@ CREATE TABLE actions
 			timestamp integer NOT NULL
 		);
 CREATE UNIQUE INDEX idx_notes_uuid ON notes(uuid);
-CREATE INDEX idx_notes_book_uuid ON notes(book_uuid);`
+CREATE INDEX idx_notes_book_uuid ON notes(book_uuid);
+CREATE TABLE attachments
+		(
+			uuid text PRIMARY KEY,
+			note_uuid text NOT NULL,
+			name text NOT NULL,
+			content_type text NOT NULL,
+			size integer NOT NULL,
+			hash text NOT NULL
+		);
+CREATE INDEX idx_attachments_note_uuid ON attachments(note_uuid);
+CREATE TABLE note_links
+		(
+			source_uuid text NOT NULL,
+			target_uuid text NOT NULL,
+			text text NOT NULL
+		);
+CREATE INDEX idx_note_links_source_uuid ON note_links(source_uuid);
+CREATE INDEX idx_note_links_target_uuid ON note_links(target_uuid);
+CREATE TABLE git_files
+		(
+			path text PRIMARY KEY,
+			note_uuid text NOT NULL
+		);
+CREATE INDEX idx_git_files_note_uuid ON git_files(note_uuid);`
 
 // MustScan scans the given row and fails a test in case of any errors
 func MustScan(t *testing.T, message string, row *sql.Row, args ...interface{}) {
@@ -161,7 +185,7 @@ func OpenTestDB(t *testing.T, dnoteDir string) *DB {
 
 // MarkMigrationComplete marks all migrations as complete in the database
 func MarkMigrationComplete(t *testing.T, db *DB) {
-	if _, err := db.Exec("INSERT INTO system (key, value) VALUES (? , ?);", consts.SystemSchema, 12); err != nil {
+	if _, err := db.Exec("INSERT INTO system (key, value) VALUES (? , ?);", consts.SystemSchema, 16); err != nil {
 		t.Fatal(errors.Wrap(err, "inserting schema"))
 	}
 	if _, err := db.Exec("INSERT INTO system (key, value) VALUES (? , ?);", consts.SystemRemoteSchema, 1); err != nil {
@@ -7,7 +7,7 @@ __dnote_debug()
 }
 
 # commands are the valid commands
-commands=("add" "view" "edit" "remove" "find"  "sync" "login" "logout" "help" "version")
+commands=("add" "view" "edit" "remove" "find"  "sync" "share" "unshare" "login" "logout" "upgrade" "help" "version")
 
 _complete_root_command() {
     COMPREPLY=($(compgen -W "${commands[*]}" "${current_word}"))
@@ -131,6 +131,8 @@ func SetupCtx(ctx context.DnoteCtx) (context.DnoteCtx, error) {
 		SessionKeyExpiry: sessionKeyExpiry,
 		APIEndpoint:      cf.APIEndpoint,
 		Editor:           cf.Editor,
+		ReleaseSource:    cf.ReleaseSource,
+		ReleasePublicKey: cf.ReleasePublicKey,
 		Clock:            clock.New(),
 	}
 
@@ -217,7 +219,6 @@ func InitDB(ctx context.DnoteCtx) error {
 	}
 
 	_, err = db.Exec(`
-		CREATE UNIQUE INDEX IF NOT EXISTS idx_books_label ON books(label);
 		CREATE UNIQUE INDEX IF NOT EXISTS idx_notes_uuid ON notes(uuid);
 		CREATE UNIQUE INDEX IF NOT EXISTS idx_books_uuid ON books(uuid);
 		CREATE INDEX IF NOT EXISTS idx_notes_book_uuid ON notes(book_uuid);`)
@@ -0,0 +1,95 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package infra
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/pkg/errors"
+)
+
+// sessionRenewalPeriod is the period before the expiry of a session
+// during which the session is renewed
+var sessionRenewalPeriod = 7 * 24 * time.Hour
+
+// SaveSession saves the given session key and its expiry as the current session
+func SaveSession(ctx context.DnoteCtx, key string, expiresAt int64) error {
+	tx, err := ctx.DB.Begin()
+	if err != nil {
+		return errors.Wrap(err, "beginning a transaction")
+	}
+
+	if err := database.UpsertSystem(tx, consts.SystemSessionKey, key); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "saving session key")
+	}
+	if err := database.UpsertSystem(tx, consts.SystemSessionKeyExpiry, strconv.FormatInt(expiresAt, 10)); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "saving session key expiry")
+	}
+
+	tx.Commit()
+
+	return nil
+}
+
+// RequireSession checks that the context has a session that has not expired,
+// and renews the session if it is about to expire. It returns an error that
+// asks the user to log in if there is no usable session.
+func RequireSession(ctx *context.DnoteCtx) error {
+	if ctx.SessionKey == "" {
+		return client.ErrNotLoggedIn
+	}
+
+	now := ctx.Clock.Now()
+	expiry := time.Unix(ctx.SessionKeyExpiry, 0)
+
+	if ctx.SessionKeyExpiry != 0 {
+		if !now.Before(expiry) {
+			return client.ErrSessionExpired
+		}
+		if expiry.Sub(now) > sessionRenewalPeriod {
+			return nil
+		}
+	}
+
+	resp, err := client.RefreshSession(*ctx)
+	if errors.Cause(err) == client.ErrSessionExpired {
+		return client.ErrSessionExpired
+	} else if err != nil {
+		// The current session is still valid. Try again next time.
+		log.Debug("failed to renew the session: %s\n", err.Error())
+		return nil
+	}
+
+	if err := SaveSession(*ctx, resp.Key, resp.ExpiresAt); err != nil {
+		return errors.Wrap(err, "saving the renewed session")
+	}
+
+	ctx.SessionKey = resp.Key
+	ctx.SessionKeyExpiry = resp.ExpiresAt
+
+	return nil
+}
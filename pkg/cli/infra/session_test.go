@@ -0,0 +1,169 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package infra
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/cli/client"
+	"github.com/dnote/dnote/pkg/cli/consts"
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/dnote/dnote/pkg/cli/database"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/pkg/errors"
+)
+
+func TestRequireSession(t *testing.T) {
+	now := time.Date(2019, time.November, 1, 0, 0, 0, 0, time.UTC)
+	newExpiry := now.Add(100 * 24 * time.Hour).Unix()
+
+	testCases := []struct {
+		expiry          int64
+		serverStatus    int
+		expectedErr     error
+		expectedRefresh bool
+		expectedKey     string
+		expectedExpiry  int64
+	}{
+		{
+			// far from the expiry
+			expiry:          now.Add(30 * 24 * time.Hour).Unix(),
+			serverStatus:    http.StatusOK,
+			expectedErr:     nil,
+			expectedRefresh: false,
+			expectedKey:     "oldKey",
+			expectedExpiry:  now.Add(30 * 24 * time.Hour).Unix(),
+		},
+		{
+			// about to expire
+			expiry:          now.Add(24 * time.Hour).Unix(),
+			serverStatus:    http.StatusOK,
+			expectedErr:     nil,
+			expectedRefresh: true,
+			expectedKey:     "newKey",
+			expectedExpiry:  newExpiry,
+		},
+		{
+			// unknown expiry
+			expiry:          0,
+			serverStatus:    http.StatusOK,
+			expectedErr:     nil,
+			expectedRefresh: true,
+			expectedKey:     "newKey",
+			expectedExpiry:  newExpiry,
+		},
+		{
+			// about to expire but the server is unavailable
+			expiry:          now.Add(24 * time.Hour).Unix(),
+			serverStatus:    http.StatusInternalServerError,
+			expectedErr:     nil,
+			expectedRefresh: true,
+			expectedKey:     "oldKey",
+			expectedExpiry:  now.Add(24 * time.Hour).Unix(),
+		},
+		{
+			// about to expire but revoked on the server
+			expiry:          now.Add(24 * time.Hour).Unix(),
+			serverStatus:    http.StatusUnauthorized,
+			expectedErr:     client.ErrSessionExpired,
+			expectedRefresh: true,
+			expectedKey:     "oldKey",
+			expectedExpiry:  now.Add(24 * time.Hour).Unix(),
+		},
+		{
+			// expired
+			expiry:          now.Add(-time.Hour).Unix(),
+			serverStatus:    http.StatusOK,
+			expectedErr:     client.ErrSessionExpired,
+			expectedRefresh: false,
+			expectedKey:     "oldKey",
+			expectedExpiry:  now.Add(-time.Hour).Unix(),
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			// Setup
+			ctx := context.InitTestCtx(t, "../tmp", nil)
+			defer context.TeardownTestCtx(t, ctx)
+
+			c := clock.NewMock()
+			c.SetNow(now)
+			ctx.Clock = c
+
+			database.MustExec(t, "inserting session key", ctx.DB, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemSessionKey, "oldKey")
+			database.MustExec(t, "inserting session key expiry", ctx.DB, "INSERT INTO system (key, value) VALUES (?, ?)", consts.SystemSessionKeyExpiry, tc.expiry)
+			ctx.SessionKey = "oldKey"
+			ctx.SessionKeyExpiry = tc.expiry
+
+			var refreshed bool
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v3/session/refresh" || r.Method != "POST" {
+					t.Fatalf("unrecognized endpoint reached Method: %s Path: %s", r.Method, r.URL.Path)
+				}
+
+				refreshed = true
+
+				if tc.serverStatus != http.StatusOK {
+					http.Error(w, "error", tc.serverStatus)
+					return
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				if err := json.NewEncoder(w).Encode(client.SigninResponse{Key: "newKey", ExpiresAt: newExpiry}); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}))
+			defer ts.Close()
+			ctx.APIEndpoint = ts.URL
+
+			// Execute
+			err := RequireSession(&ctx)
+
+			// Test
+			assert.Equal(t, errors.Cause(err), tc.expectedErr, "error mismatch")
+			assert.Equal(t, refreshed, tc.expectedRefresh, "refresh mismatch")
+			assert.Equal(t, ctx.SessionKey, tc.expectedKey, "context session key mismatch")
+			assert.Equal(t, ctx.SessionKeyExpiry, tc.expectedExpiry, "context session key expiry mismatch")
+
+			var key string
+			var expiry int64
+			database.MustScan(t, "getting session key", ctx.DB.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemSessionKey), &key)
+			database.MustScan(t, "getting session key expiry", ctx.DB.QueryRow("SELECT value FROM system WHERE key = ?", consts.SystemSessionKeyExpiry), &expiry)
+			assert.Equal(t, key, tc.expectedKey, "session key mismatch")
+			assert.Equal(t, expiry, tc.expectedExpiry, "session key expiry mismatch")
+		})
+	}
+}
+
+func TestRequireSession_notLoggedIn(t *testing.T) {
+	ctx := context.InitTestCtx(t, "../tmp", nil)
+	defer context.TeardownTestCtx(t, ctx)
+
+	err := RequireSession(&ctx)
+
+	assert.Equal(t, errors.Cause(err), client.ErrNotLoggedIn, "error mismatch")
+}
@@ -28,17 +28,27 @@ import (
 
 	// commands
 	"github.com/dnote/dnote/pkg/cli/cmd/add"
+	"github.com/dnote/dnote/pkg/cli/cmd/attach"
+	"github.com/dnote/dnote/pkg/cli/cmd/backlinks"
 	"github.com/dnote/dnote/pkg/cli/cmd/cat"
 	"github.com/dnote/dnote/pkg/cli/cmd/edit"
 	"github.com/dnote/dnote/pkg/cli/cmd/find"
+	"github.com/dnote/dnote/pkg/cli/cmd/git"
+	"github.com/dnote/dnote/pkg/cli/cmd/links"
 	"github.com/dnote/dnote/pkg/cli/cmd/login"
 	"github.com/dnote/dnote/pkg/cli/cmd/logout"
 	"github.com/dnote/dnote/pkg/cli/cmd/ls"
 	"github.com/dnote/dnote/pkg/cli/cmd/remove"
 	"github.com/dnote/dnote/pkg/cli/cmd/root"
+	"github.com/dnote/dnote/pkg/cli/cmd/serve"
+	"github.com/dnote/dnote/pkg/cli/cmd/sessions"
+	"github.com/dnote/dnote/pkg/cli/cmd/share"
 	"github.com/dnote/dnote/pkg/cli/cmd/sync"
+	"github.com/dnote/dnote/pkg/cli/cmd/unshare"
+	"github.com/dnote/dnote/pkg/cli/cmd/upgrade"
 	"github.com/dnote/dnote/pkg/cli/cmd/version"
 	"github.com/dnote/dnote/pkg/cli/cmd/view"
+	"github.com/dnote/dnote/pkg/cli/cmd/web"
 )
 
 // apiEndpoint and versionTag are populated during link time
@@ -63,6 +73,16 @@ func main() {
 	root.Register(cat.NewCmd(*ctx))
 	root.Register(view.NewCmd(*ctx))
 	root.Register(find.NewCmd(*ctx))
+	root.Register(share.NewCmd(*ctx))
+	root.Register(unshare.NewCmd(*ctx))
+	root.Register(attach.NewCmd(*ctx))
+	root.Register(links.NewCmd(*ctx))
+	root.Register(backlinks.NewCmd(*ctx))
+	root.Register(upgrade.NewCmd(*ctx))
+	root.Register(sessions.NewCmd(*ctx))
+	root.Register(git.NewCmd(*ctx))
+	root.Register(serve.NewCmd(*ctx))
+	root.Register(web.NewCmd(*ctx))
 
 	if err := root.Execute(); err != nil {
 		log.Errorf("%s\n", err.Error())
@@ -47,6 +47,10 @@ var LocalSequence = []migration{
 	lm10,
 	lm11,
 	lm12,
+	lm13,
+	lm14,
+	lm15,
+	lm16,
 }
 
 // RemoteSequence is a list of remote migrations to be run
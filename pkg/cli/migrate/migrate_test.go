@@ -1097,6 +1097,162 @@ func TestLocalMigration12(t *testing.T) {
 	assert.NotEqual(t, cf.APIEndpoint, "", "apiEndpoint was not populated")
 }
 
+func TestLocalMigration13(t *testing.T) {
+	// set up
+	opts := database.TestDBOptions{SchemaSQLPath: "./fixtures/local-13-pre-schema.sql", SkipMigration: true}
+	ctx := context.InitTestCtx(t, "../tmp", &opts)
+	defer context.TeardownTestCtx(t, ctx)
+
+	db := ctx.DB
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+
+	err = lm13.run(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "failed to run"))
+	}
+
+	tx.Commit()
+
+	// test
+	a1UUID := testutils.MustGenerateUUID(t)
+	n1UUID := testutils.MustGenerateUUID(t)
+	database.MustExec(t, "inserting a1", db, "INSERT INTO attachments (uuid, note_uuid, name, content_type, size, hash) VALUES (?, ?, ?, ?, ?, ?)", a1UUID, n1UUID, "a1.png", "image/png", 42, "h1")
+
+	var name string
+	var size int64
+	database.MustScan(t, "scanning a1", db.QueryRow("SELECT name, size FROM attachments WHERE note_uuid = ?", n1UUID), &name, &size)
+	assert.Equal(t, name, "a1.png", "name mismatch")
+	assert.Equal(t, size, int64(42), "size mismatch")
+}
+
+func TestLocalMigration14(t *testing.T) {
+	// set up
+	opts := database.TestDBOptions{SchemaSQLPath: "./fixtures/local-14-pre-schema.sql", SkipMigration: true}
+	ctx := context.InitTestCtx(t, "../tmp", &opts)
+	defer context.TeardownTestCtx(t, ctx)
+
+	db := ctx.DB
+
+	b1UUID := testutils.MustGenerateUUID(t)
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", b1UUID, "js")
+
+	n1UUID := testutils.MustGenerateUUID(t)
+	n2UUID := testutils.MustGenerateUUID(t)
+	n3UUID := testutils.MustGenerateUUID(t)
+	database.MustExec(t, "inserting n1", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", n1UUID, b1UUID, "# closures\ncontent", 1)
+	database.MustExec(t, "inserting n2", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", n2UUID, b1UUID, fmt.Sprintf("see [[js/closures]] and [[%s]]", n3UUID), 2)
+	database.MustExec(t, "inserting n3", db, "INSERT INTO notes (uuid, book_uuid, body, added_on) VALUES (?, ?, ?, ?)", n3UUID, b1UUID, "[[js/missing]] [[js/n3]]", 3)
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+
+	err = lm14.run(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "failed to run"))
+	}
+
+	tx.Commit()
+
+	// test
+	var linkCount, n2LinkCount int
+	database.MustScan(t, "counting links", db.QueryRow("SELECT count(*) FROM note_links"), &linkCount)
+	database.MustScan(t, "counting links from n2", db.QueryRow("SELECT count(*) FROM note_links WHERE source_uuid = ?", n2UUID), &n2LinkCount)
+	assert.Equal(t, linkCount, 2, "link count mismatch")
+	assert.Equal(t, n2LinkCount, 2, "n2 link count mismatch")
+
+	var target string
+	database.MustScan(t, "scanning the path link", db.QueryRow("SELECT target_uuid FROM note_links WHERE text = ?", "js/closures"), &target)
+	assert.Equal(t, target, n1UUID, "path link target mismatch")
+	database.MustScan(t, "scanning the uuid link", db.QueryRow("SELECT target_uuid FROM note_links WHERE text = ?", n3UUID), &target)
+	assert.Equal(t, target, n3UUID, "uuid link target mismatch")
+}
+
+func TestLocalMigration15(t *testing.T) {
+	// set up
+	opts := database.TestDBOptions{SchemaSQLPath: "./fixtures/local-15-pre-schema.sql", SkipMigration: true}
+	ctx := context.InitTestCtx(t, "../tmp", &opts)
+	defer context.TeardownTestCtx(t, ctx)
+
+	db := ctx.DB
+
+	b1UUID := testutils.MustGenerateUUID(t)
+	database.MustExec(t, "inserting b1", db, "INSERT INTO books (uuid, label) VALUES (?, ?)", b1UUID, "infra")
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+
+	err = lm15.run(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "failed to run"))
+	}
+
+	tx.Commit()
+
+	// test
+	var parentUUID string
+	database.MustScan(t, "scanning b1", db.QueryRow("SELECT parent_uuid FROM books WHERE uuid = ?", b1UUID), &parentUUID)
+	assert.Equal(t, parentUUID, "", "b1 parent_uuid mismatch")
+
+	// the same label is allowed in different parents
+	b2UUID := testutils.MustGenerateUUID(t)
+	database.MustExec(t, "inserting b2", db, "INSERT INTO books (uuid, label, parent_uuid) VALUES (?, ?, ?)", b2UUID, "infra", b1UUID)
+
+	b3UUID := testutils.MustGenerateUUID(t)
+	if _, err := db.Exec("INSERT INTO books (uuid, label, parent_uuid) VALUES (?, ?, ?)", b3UUID, "infra", b1UUID); err == nil {
+		t.Error("expected an error inserting a duplicate label in the same parent")
+	}
+}
+
+func TestLocalMigration16(t *testing.T) {
+	// set up
+	opts := database.TestDBOptions{SchemaSQLPath: "./fixtures/local-16-pre-schema.sql", SkipMigration: true}
+	ctx := context.InitTestCtx(t, "../tmp", &opts)
+	defer context.TeardownTestCtx(t, ctx)
+
+	db := ctx.DB
+
+	// execute
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning a transaction"))
+	}
+
+	err = lm16.run(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "failed to run"))
+	}
+
+	tx.Commit()
+
+	// test
+	n1UUID := testutils.MustGenerateUUID(t)
+	database.MustExec(t, "inserting a file", db, "INSERT INTO git_files (path, note_uuid) VALUES (?, ?)", "infra/k8s/pods.md", n1UUID)
+
+	var noteUUID string
+	database.MustScan(t, "scanning the file", db.QueryRow("SELECT note_uuid FROM git_files WHERE path = ?", "infra/k8s/pods.md"), &noteUUID)
+	assert.Equal(t, noteUUID, n1UUID, "note_uuid mismatch")
+
+	// a path is mirrored by only one note
+	if _, err := db.Exec("INSERT INTO git_files (path, note_uuid) VALUES (?, ?)", "infra/k8s/pods.md", n1UUID); err == nil {
+		t.Error("expected an error inserting a duplicate path")
+	}
+}
+
 func TestRemoteMigration1(t *testing.T) {
 	// set up
 	opts := database.TestDBOptions{SchemaSQLPath: "./fixtures/remote-1-pre-schema.sql", SkipMigration: true}
@@ -31,6 +31,7 @@ import (
 	"github.com/dnote/dnote/pkg/cli/context"
 	"github.com/dnote/dnote/pkg/cli/database"
 	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/wikilink"
 	"github.com/pkg/errors"
 )
 
@@ -550,6 +551,125 @@ var lm12 = migration{
 	},
 }
 
+var lm13 = migration{
+	name: "create-attachments",
+	run: func(ctx context.DnoteCtx, tx *database.DB) error {
+		_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS attachments
+		(
+			uuid text PRIMARY KEY,
+			note_uuid text NOT NULL,
+			name text NOT NULL,
+			content_type text NOT NULL,
+			size integer NOT NULL,
+			hash text NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_attachments_note_uuid ON attachments(note_uuid);`)
+		if err != nil {
+			return errors.Wrap(err, "creating attachments table")
+		}
+
+		return nil
+	},
+}
+
+var lm14 = migration{
+	name: "create-note-links",
+	run: func(ctx context.DnoteCtx, tx *database.DB) error {
+		_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS note_links
+		(
+			source_uuid text NOT NULL,
+			target_uuid text NOT NULL,
+			text text NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_note_links_source_uuid ON note_links(source_uuid);
+		CREATE INDEX IF NOT EXISTS idx_note_links_target_uuid ON note_links(target_uuid);`)
+		if err != nil {
+			return errors.Wrap(err, "creating note_links table")
+		}
+
+		type note struct {
+			uuid     string
+			bookName string
+			body     string
+		}
+
+		rows, err := tx.Query(`SELECT notes.uuid, books.label, notes.body
+			FROM notes
+			INNER JOIN books ON books.uuid = notes.book_uuid
+			WHERE notes.deleted = false
+			ORDER BY notes.added_on ASC`)
+		if err != nil {
+			return errors.Wrap(err, "querying notes")
+		}
+		defer rows.Close()
+
+		var notes []note
+		for rows.Next() {
+			var n note
+			if err := rows.Scan(&n.uuid, &n.bookName, &n.body); err != nil {
+				return errors.Wrap(err, "scanning note")
+			}
+
+			notes = append(notes, n)
+		}
+
+		// resolve the links against the notes that existed before the upgrade
+		for _, n := range notes {
+			for _, l := range wikilink.Parse(n.body) {
+				target := l.UUID
+				if target == "" {
+					for _, t := range notes {
+						if t.bookName == l.Book && wikilink.Title(t.body) == l.Title {
+							target = t.uuid
+							break
+						}
+					}
+				}
+				if target == "" || target == n.uuid {
+					continue
+				}
+
+				if _, err := tx.Exec("INSERT INTO note_links (source_uuid, target_uuid, text) VALUES (?, ?, ?)", n.uuid, target, l.Text); err != nil {
+					return errors.Wrap(err, "inserting a link")
+				}
+			}
+		}
+
+		return nil
+	},
+}
+
+var lm15 = migration{
+	name: "add-parent-uuid-to-books",
+	run: func(ctx context.DnoteCtx, tx *database.DB) error {
+		_, err := tx.Exec(`ALTER TABLE books ADD COLUMN parent_uuid text NOT NULL DEFAULT '';
+		DROP INDEX IF EXISTS idx_books_label;
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_books_parent_uuid_label ON books(parent_uuid, label);`)
+		if err != nil {
+			return errors.Wrap(err, "adding parent_uuid to books")
+		}
+
+		return nil
+	},
+}
+
+var lm16 = migration{
+	name: "create-git-files",
+	run: func(ctx context.DnoteCtx, tx *database.DB) error {
+		_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS git_files
+		(
+			path text PRIMARY KEY,
+			note_uuid text NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_git_files_note_uuid ON git_files(note_uuid);`)
+		if err != nil {
+			return errors.Wrap(err, "creating git_files table")
+		}
+
+		return nil
+	},
+}
+
 var rm1 = migration{
 	name: "sync-book-uuids-from-server",
 	run: func(ctx context.DnoteCtx, tx *database.DB) error {
@@ -26,6 +26,7 @@ import (
 
 	"github.com/dnote/dnote/pkg/cli/database"
 	"github.com/dnote/dnote/pkg/cli/log"
+	"github.com/dnote/dnote/pkg/wikilink"
 )
 
 // NoteInfo prints a note information
@@ -37,6 +38,9 @@ func NoteInfo(info database.NoteInfo) {
 	}
 	log.Infof("note id: %d\n", info.RowID)
 	log.Infof("note uuid: %s\n", info.UUID)
+	if info.Public {
+		log.Infof("shared: yes\n")
+	}
 
 	fmt.Printf("\n------------------------content------------------------\n")
 	fmt.Printf("%s", info.Content)
@@ -49,3 +53,13 @@ func BookInfo(info database.BookInfo) {
 	log.Infof("book id: %d\n", info.RowID)
 	log.Infof("book uuid: %s\n", info.UUID)
 }
+
+// NoteList prints the book, the id and the title of each of the given notes
+func NoteList(infos []database.NoteInfo) {
+	for _, info := range infos {
+		bookLabel := log.ColorYellow.Sprintf("(%s)", info.BookLabel)
+		rowid := log.ColorYellow.Sprintf("(%d)", info.RowID)
+
+		log.Plainf("%s %s %s\n", bookLabel, rowid, wikilink.Title(info.Content))
+	}
+}
@@ -7,9 +7,15 @@
 # platform. Set GOOS and GOARCH environment variables to disable xgo and instead
 # compile for a specific platform.
 #
+# If DNOTE_SIGNING_KEY is set to the path of a PEM encoded ed25519 private key, the
+# checksums are signed with it and the binaries embed its public key so that
+# 'dnote upgrade' can verify the releases. A key can be generated by:
+# openssl genpkey -algorithm ed25519 -out signing-key.pem
+#
 # use:
 # ./scripts/build.sh 0.4.8
 # GOOS=linux GOARCH=amd64 ./scripts/build.sh 0.4.8
+# DNOTE_SIGNING_KEY=path/to/signing-key.pem ./scripts/build.sh 0.4.8
 
 set -ex
 
@@ -37,6 +43,13 @@ fi
 
 goVersion=1.12.x
 
+signingKey=${DNOTE_SIGNING_KEY:-}
+publicKey=""
+if [ -n "$signingKey" ]; then
+  # the raw public key is the last 32 bytes of the DER encoding
+  publicKey=$(openssl pkey -in "$signingKey" -pubout -outform DER | tail -c 32 | base64 | tr -d '\n')
+fi
+
 get_binary_name() {
   platform=$1
 
@@ -56,7 +69,7 @@ build() {
 
   # build binary
   destDir="$outputDir/$platform-$arch"
-  ldflags="-X main.apiEndpoint=https://api.dnote.io -X main.versionTag=$version"
+  ldflags="-X main.apiEndpoint=https://api.dnote.io -X main.versionTag=$version -X github.com/dnote/dnote/pkg/cli/upgrade.PublicKey=$publicKey"
   tags="fts5"
 
   mkdir -p "$destDir"
@@ -96,6 +109,17 @@ build() {
   popd
 }
 
+sign_checksums() {
+  checksums="$outputDir/dnote_${version}_checksums.txt"
+
+  if [ -z "$signingKey" ]; then
+    echo "DNOTE_SIGNING_KEY is not set. skipping signing the checksums."
+    return
+  fi
+
+  openssl pkeyutl -sign -rawin -inkey "$signingKey" -in "$checksums" | base64 | tr -d '\n' > "$checksums.sig"
+}
+
 if [ -z "$GOOS" ] && [ -z "$GOARCH" ]; then
   # fetch tool
   go get -u github.com/karalabe/xgo
@@ -106,3 +130,5 @@ if [ -z "$GOOS" ] && [ -z "$GOARCH" ]; then
 else
   build "$GOOS" "$GOARCH" true
 fi
+
+sign_checksums
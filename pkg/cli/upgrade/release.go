@@ -0,0 +1,395 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package upgrade
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/dnote/dnote/pkg/cli/context"
+	"github.com/google/go-github/github"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ed25519"
+)
+
+// DefaultSource is the release source used if none is configured. The files of a
+// release are served under the path named after its tag, such as cli-v0.4.8.
+const DefaultSource = "https://github.com/dnote/dnote/releases/download"
+
+// PublicKey is the base64 encoded ed25519 public key that verifies the signature of
+// the checksums of a release. It is populated during link time.
+var PublicKey string
+
+// Release is a release of the CLI in a release source
+type Release struct {
+	Source    string
+	Version   string
+	PublicKey string
+	OS        string
+	Arch      string
+}
+
+// NewRelease returns the release of the given version for the current platform,
+// from the release source and with the public key in the configuration if any.
+func NewRelease(ctx context.DnoteCtx, version string) Release {
+	return Release{
+		Source:    GetSource(ctx),
+		Version:   version,
+		PublicKey: getPublicKey(ctx),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+}
+
+// GetSource returns the release source configured in the context, or the default
+// source if none is configured
+func GetSource(ctx context.DnoteCtx) string {
+	if ctx.ReleaseSource != "" {
+		return strings.TrimSuffix(ctx.ReleaseSource, "/")
+	}
+
+	return DefaultSource
+}
+
+func getPublicKey(ctx context.DnoteCtx) string {
+	if ctx.ReleasePublicKey != "" {
+		return ctx.ReleasePublicKey
+	}
+
+	return PublicKey
+}
+
+func (r Release) binaryName() string {
+	if r.OS == "windows" {
+		return "dnote.exe"
+	}
+
+	return "dnote"
+}
+
+func (r Release) tarballName() string {
+	return fmt.Sprintf("dnote_%s_%s_%s.tar.gz", r.Version, r.OS, r.Arch)
+}
+
+func (r Release) checksumsName() string {
+	return fmt.Sprintf("dnote_%s_checksums.txt", r.Version)
+}
+
+func (r Release) fileURL(name string) string {
+	return fmt.Sprintf("%s/cli-v%s/%s", r.Source, r.Version, name)
+}
+
+var httpClient = http.Client{
+	Timeout: 5 * time.Minute,
+}
+
+func download(url string) ([]byte, error) {
+	res, err := httpClient.Get(url)
+	if err != nil {
+		return nil, errors.Wrapf(err, "requesting %s", url)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("requesting %s: server responded with %d", url, res.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading the response from %s", url)
+	}
+
+	return body, nil
+}
+
+// GetLatestVersion returns the latest stable version in the given release source.
+// A release source other than the default one must serve the tag of the latest
+// stable release at /latest.
+func GetLatestVersion(source string) (string, error) {
+	var tag string
+	if source == DefaultSource {
+		t, err := fetchLatestStableTag(github.NewClient(nil), 1)
+		if err != nil {
+			return "", errors.Wrap(err, "fetching the latest stable release")
+		}
+
+		tag = t
+	} else {
+		b, err := download(source + "/latest")
+		if err != nil {
+			return "", errors.Wrap(err, "fetching the latest stable release")
+		}
+
+		tag = strings.TrimSpace(string(b))
+	}
+
+	// releases are tagged in a form of cli-v1.0.0
+	if !strings.HasPrefix(tag, "cli-v") {
+		return "", errors.Errorf("unexpected release tag '%s'", tag)
+	}
+
+	return strings.TrimPrefix(tag, "cli-v"), nil
+}
+
+// verifySignature checks that the signature, encoded in base64, is the signature of
+// the message by the private key of the given public key
+func verifySignature(publicKey string, message, signature []byte) error {
+	if publicKey == "" {
+		return errors.New("no public key is available to verify the release")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("invalid public key")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return errors.Wrap(err, "decoding the signature")
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(key), message, sig) {
+		return errors.New("the signature of the checksums does not match")
+	}
+
+	return nil
+}
+
+// verifyChecksum checks that the sha256 checksum of the data matches the checksum of
+// the file with the given name in the checksums, which is in the output format of shasum.
+func verifyChecksum(checksums []byte, name string, data []byte) error {
+	var want string
+
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		if strings.TrimPrefix(fields[1], "*") == name {
+			want = fields[0]
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "reading the checksums")
+	}
+	if want == "" {
+		return errors.Errorf("no checksum was found for %s", name)
+	}
+
+	sum := sha256.Sum256(data)
+	got := hex.EncodeToString(sum[:])
+	if got != strings.ToLower(want) {
+		return errors.Errorf("checksum mismatch for %s. expected %s but got %s", name, want, got)
+	}
+
+	return nil
+}
+
+// extractBinary returns the content of the file with the given name in the gzipped tarball
+func extractBinary(tarball []byte, name string) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(tarball))
+	if err != nil {
+		return nil, errors.Wrap(err, "reading gzip")
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "reading tar")
+		}
+
+		if header.Typeflag != tar.TypeReg || path.Base(header.Name) != name {
+			continue
+		}
+
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", header.Name)
+		}
+
+		return b, nil
+	}
+
+	return nil, errors.Errorf("%s was not found in the release", name)
+}
+
+// Fetch downloads the release and returns the binary in it, after verifying the
+// signature of the checksums and the checksum of the release.
+func (r Release) Fetch() ([]byte, error) {
+	checksums, err := download(r.fileURL(r.checksumsName()))
+	if err != nil {
+		return nil, errors.Wrap(err, "downloading the checksums")
+	}
+	signature, err := download(r.fileURL(r.checksumsName() + ".sig"))
+	if err != nil {
+		return nil, errors.Wrap(err, "downloading the signature")
+	}
+	if err := verifySignature(r.PublicKey, checksums, signature); err != nil {
+		return nil, errors.Wrap(err, "verifying the signature")
+	}
+
+	tarball, err := download(r.fileURL(r.tarballName()))
+	if err != nil {
+		return nil, errors.Wrap(err, "downloading the release")
+	}
+	if err := verifyChecksum(checksums, r.tarballName(), tarball); err != nil {
+		return nil, errors.Wrap(err, "verifying the checksum")
+	}
+
+	return extractBinary(tarball, r.binaryName())
+}
+
+// checkBinary checks that the binary at the given path runs and reports the given version.
+// The binary is run against an empty temporary home directory so that it does not
+// initialize or migrate the data of the current installation.
+func checkBinary(binaryPath, version string) error {
+	homeDir, err := ioutil.TempDir("", "dnote-upgrade-home")
+	if err != nil {
+		return errors.Wrap(err, "creating a temporary home directory")
+	}
+	defer os.RemoveAll(homeDir)
+
+	cmd := exec.Command(binaryPath, "version")
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("HOME=%s", homeDir),
+		fmt.Sprintf("DNOTE_HOME_DIR=%s", homeDir),
+		fmt.Sprintf("DNOTE_DIR=%s", filepath.Join(homeDir, ".dnote")),
+		fmt.Sprintf("XDG_CONFIG_HOME=%s", filepath.Join(homeDir, ".config")),
+		fmt.Sprintf("XDG_DATA_HOME=%s", filepath.Join(homeDir, ".local", "share")),
+		fmt.Sprintf("XDG_CACHE_HOME=%s", filepath.Join(homeDir, ".cache")),
+	)
+
+	out, err := cmd.Output()
+	if err != nil {
+		return errors.Wrap(err, "running the new binary")
+	}
+
+	if strings.TrimSpace(string(out)) != fmt.Sprintf("dnote %s", version) {
+		return errors.Errorf("unexpected version from the new binary: %s", strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// replaceBinary atomically replaces the file at the given path with the data, and
+// runs the check against the new file. If anything fails, the original file is
+// restored. The replacement is staged in the same directory so that it can be
+// renamed over the original.
+func replaceBinary(binaryPath string, data []byte, check func(string) error) error {
+	info, err := os.Stat(binaryPath)
+	if err != nil {
+		return errors.Wrap(err, "reading the current binary")
+	}
+
+	dir := filepath.Dir(binaryPath)
+	tmp, err := ioutil.TempFile(dir, ".dnote-upgrade-")
+	if err != nil {
+		return errors.Wrapf(err, "creating a file in %s", dir)
+	}
+	tmpPath := tmp.Name()
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, info.Mode().Perm())
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrap(err, "writing the new binary")
+	}
+
+	backupPath := binaryPath + ".old"
+	os.Remove(backupPath)
+	if err := os.Link(binaryPath, backupPath); err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrap(err, "backing up the current binary")
+	}
+
+	if err := os.Rename(tmpPath, binaryPath); err != nil {
+		os.Remove(tmpPath)
+		os.Remove(backupPath)
+		return errors.Wrap(err, "replacing the current binary")
+	}
+
+	if err := check(binaryPath); err != nil {
+		if rbErr := os.Rename(backupPath, binaryPath); rbErr != nil {
+			return errors.Wrapf(rbErr, "rolling back after a failed check (%s). the previous binary is at %s", err.Error(), backupPath)
+		}
+
+		return errors.Wrap(err, "checking the new binary. rolled back to the previous binary")
+	}
+
+	os.Remove(backupPath)
+
+	return nil
+}
+
+// Install downloads and verifies the release, and replaces the binary at the given
+// path with the binary in the release.
+func (r Release) Install(binaryPath string) error {
+	b, err := r.Fetch()
+	if err != nil {
+		return err
+	}
+
+	return replaceBinary(binaryPath, b, func(p string) error {
+		return checkBinary(p, r.Version)
+	})
+}
+
+// GetBinaryPath returns the path to the running binary, with symlinks resolved
+func GetBinaryPath() (string, error) {
+	p, err := os.Executable()
+	if err != nil {
+		return "", errors.Wrap(err, "finding the executable")
+	}
+
+	ret, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", errors.Wrap(err, "resolving the executable path")
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,457 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package upgrade
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ed25519"
+)
+
+func makeTarball(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for name, content := range files {
+		header := &tar.Header{
+			Name:     name,
+			Mode:     0755,
+			Size:     int64(len(content)),
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(errors.Wrap(err, "writing a tar header"))
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(errors.Wrap(err, "writing a tar entry"))
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(errors.Wrap(err, "closing the tar writer"))
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(errors.Wrap(err, "closing the gzip writer"))
+	}
+
+	return buf.Bytes()
+}
+
+func makeChecksums(name string, data []byte) []byte {
+	sum := sha256.Sum256(data)
+
+	return []byte(fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), name))
+}
+
+func makeKey(t *testing.T) (string, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "generating a key"))
+	}
+
+	return base64.StdEncoding.EncodeToString(pub), priv
+}
+
+func sign(priv ed25519.PrivateKey, message []byte) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, message)))
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	data := []byte("release content")
+	checksums := append([]byte("0000  dnote_0.1.0_darwin_amd64.tar.gz\n"), makeChecksums("dnote_0.1.0_linux_amd64.tar.gz", data)...)
+
+	testCases := []struct {
+		name     string
+		fileName string
+		data     []byte
+		expected string
+	}{
+		{
+			name:     "match",
+			fileName: "dnote_0.1.0_linux_amd64.tar.gz",
+			data:     data,
+			expected: "",
+		},
+		{
+			name:     "mismatch",
+			fileName: "dnote_0.1.0_darwin_amd64.tar.gz",
+			data:     data,
+			expected: "checksum mismatch for dnote_0.1.0_darwin_amd64.tar.gz",
+		},
+		{
+			name:     "not found",
+			fileName: "dnote_0.1.0_windows_amd64.tar.gz",
+			data:     data,
+			expected: "no checksum was found for dnote_0.1.0_windows_amd64.tar.gz",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := verifyChecksum(checksums, tc.fileName, tc.data)
+
+			if tc.expected == "" {
+				if err != nil {
+					t.Fatal(errors.Wrap(err, "executing"))
+				}
+			} else {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+
+				assert.Equal(t, err.Error()[:len(tc.expected)], tc.expected, "error mismatch")
+			}
+		})
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	publicKey, priv := makeKey(t)
+	anotherKey, _ := makeKey(t)
+	message := []byte("checksums")
+
+	testCases := []struct {
+		name      string
+		publicKey string
+		message   []byte
+		signature []byte
+		expected  string
+	}{
+		{
+			name:      "valid",
+			publicKey: publicKey,
+			message:   message,
+			signature: sign(priv, message),
+			expected:  "",
+		},
+		{
+			name:      "tampered message",
+			publicKey: publicKey,
+			message:   []byte("tampered checksums"),
+			signature: sign(priv, message),
+			expected:  "the signature of the checksums does not match",
+		},
+		{
+			name:      "another key",
+			publicKey: anotherKey,
+			message:   message,
+			signature: sign(priv, message),
+			expected:  "the signature of the checksums does not match",
+		},
+		{
+			name:      "no key",
+			publicKey: "",
+			message:   message,
+			signature: sign(priv, message),
+			expected:  "no public key is available to verify the release",
+		},
+		{
+			name:      "invalid key",
+			publicKey: "aW52YWxpZA==",
+			message:   message,
+			signature: sign(priv, message),
+			expected:  "invalid public key",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := verifySignature(tc.publicKey, tc.message, tc.signature)
+
+			if tc.expected == "" {
+				if err != nil {
+					t.Fatal(errors.Wrap(err, "executing"))
+				}
+			} else {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+
+				assert.Equal(t, err.Error(), tc.expected, "error mismatch")
+			}
+		})
+	}
+}
+
+func TestExtractBinary(t *testing.T) {
+	tarball := makeTarball(t, map[string]string{
+		"./README.md": "readme",
+		"./dnote":     "binary",
+	})
+
+	got, err := extractBinary(tarball, "dnote")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+	assert.Equal(t, string(got), "binary", "content mismatch")
+
+	_, err = extractBinary(tarball, "dnote.exe")
+	assert.Equal(t, err.Error(), "dnote.exe was not found in the release", "error mismatch")
+}
+
+func TestGetLatestVersion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/latest" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write([]byte("cli-v0.10.1\n"))
+	}))
+	defer server.Close()
+
+	got, err := GetLatestVersion(server.URL)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	assert.Equal(t, got, "0.10.1", "version mismatch")
+}
+
+func TestReplaceBinary(t *testing.T) {
+	setup := func(t *testing.T) (string, func()) {
+		dir, err := ioutil.TempDir("", "dnote-upgrade-test")
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "creating a temporary directory"))
+		}
+
+		binaryPath := filepath.Join(dir, "dnote")
+		if err := ioutil.WriteFile(binaryPath, []byte("old"), 0755); err != nil {
+			t.Fatal(errors.Wrap(err, "writing the binary"))
+		}
+
+		return binaryPath, func() { os.RemoveAll(dir) }
+	}
+
+	t.Run("success", func(t *testing.T) {
+		binaryPath, teardown := setup(t)
+		defer teardown()
+
+		var checked string
+		err := replaceBinary(binaryPath, []byte("new"), func(p string) error {
+			b, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+
+			checked = string(b)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "executing"))
+		}
+
+		b, err := ioutil.ReadFile(binaryPath)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "reading the binary"))
+		}
+		files, err := ioutil.ReadDir(filepath.Dir(binaryPath))
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "reading the directory"))
+		}
+
+		assert.Equal(t, checked, "new", "checked content mismatch")
+		assert.Equal(t, string(b), "new", "content mismatch")
+		assert.Equal(t, len(files), 1, "the staged and the backup files should have been removed")
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		binaryPath, teardown := setup(t)
+		defer teardown()
+
+		err := replaceBinary(binaryPath, []byte("new"), func(p string) error {
+			return errors.New("broken binary")
+		})
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+
+		b, err := ioutil.ReadFile(binaryPath)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "reading the binary"))
+		}
+		files, err := ioutil.ReadDir(filepath.Dir(binaryPath))
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "reading the directory"))
+		}
+
+		assert.Equal(t, string(b), "old", "the binary should have been rolled back")
+		assert.Equal(t, len(files), 1, "the staged and the backup files should have been removed")
+	})
+}
+
+func TestInstall(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test binary is a shell script")
+	}
+
+	publicKey, priv := makeKey(t)
+	version := "0.10.1"
+
+	testCases := []struct {
+		name            string
+		binary          string
+		tamper          bool
+		expectedContent string
+		expectedErr     bool
+	}{
+		{
+			name:            "valid release",
+			binary:          fmt.Sprintf("#!/bin/sh\necho 'dnote %s'\n", version),
+			expectedContent: fmt.Sprintf("#!/bin/sh\necho 'dnote %s'\n", version),
+		},
+		{
+			name:            "tampered release",
+			binary:          fmt.Sprintf("#!/bin/sh\necho 'dnote %s'\n", version),
+			tamper:          true,
+			expectedContent: "old",
+			expectedErr:     true,
+		},
+		{
+			name:            "broken binary",
+			binary:          "#!/bin/sh\nexit 1\n",
+			expectedContent: "old",
+			expectedErr:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// set up
+			tarball := makeTarball(t, map[string]string{"./dnote": tc.binary})
+			tarballName := fmt.Sprintf("dnote_%s_linux_amd64.tar.gz", version)
+			checksums := makeChecksums(tarballName, tarball)
+			signature := sign(priv, checksums)
+			if tc.tamper {
+				tarball = makeTarball(t, map[string]string{"./dnote": "#!/bin/sh\necho 'tampered'\n"})
+			}
+
+			files := map[string][]byte{
+				fmt.Sprintf("/cli-v%s/%s", version, tarballName):                     tarball,
+				fmt.Sprintf("/cli-v%s/dnote_%s_checksums.txt", version, version):     checksums,
+				fmt.Sprintf("/cli-v%s/dnote_%s_checksums.txt.sig", version, version): signature,
+			}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, ok := files[r.URL.Path]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+
+				w.Write(b)
+			}))
+			defer server.Close()
+
+			dir, err := ioutil.TempDir("", "dnote-upgrade-test")
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "creating a temporary directory"))
+			}
+			defer os.RemoveAll(dir)
+
+			binaryPath := filepath.Join(dir, "dnote")
+			if err := ioutil.WriteFile(binaryPath, []byte("old"), 0755); err != nil {
+				t.Fatal(errors.Wrap(err, "writing the binary"))
+			}
+
+			release := Release{
+				Source:    server.URL,
+				Version:   version,
+				PublicKey: publicKey,
+				OS:        "linux",
+				Arch:      "amd64",
+			}
+
+			// execute
+			err = release.Install(binaryPath)
+
+			// test
+			if tc.expectedErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+			} else if err != nil {
+				t.Fatal(errors.Wrap(err, "executing"))
+			}
+
+			b, err := ioutil.ReadFile(binaryPath)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "reading the binary"))
+			}
+			assert.Equal(t, string(b), tc.expectedContent, "binary content mismatch")
+		})
+	}
+}
+
+func TestCheckBinary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test binary is a shell script")
+	}
+
+	dir, err := ioutil.TempDir("", "dnote-upgrade-test")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating a temporary directory"))
+	}
+	defer os.RemoveAll(dir)
+
+	dnoteDir := filepath.Join(dir, "dnote-dir")
+	if err := os.Mkdir(dnoteDir, 0755); err != nil {
+		t.Fatal(errors.Wrap(err, "creating the dnote directory"))
+	}
+	prevDnoteDir, hadDnoteDir := os.LookupEnv("DNOTE_DIR")
+	os.Setenv("DNOTE_DIR", dnoteDir)
+	defer func() {
+		if hadDnoteDir {
+			os.Setenv("DNOTE_DIR", prevDnoteDir)
+		} else {
+			os.Unsetenv("DNOTE_DIR")
+		}
+	}()
+
+	binaryPath := filepath.Join(dir, "dnote")
+	binary := "#!/bin/sh\nmkdir -p \"$DNOTE_DIR\" && touch \"$DNOTE_DIR/touched\"\necho 'dnote 0.10.1'\n"
+	if err := ioutil.WriteFile(binaryPath, []byte(binary), 0755); err != nil {
+		t.Fatal(errors.Wrap(err, "writing the binary"))
+	}
+
+	// execute
+	if err := checkBinary(binaryPath, "0.10.1"); err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	// test
+	_, err = os.Stat(filepath.Join(dnoteDir, "touched"))
+	assert.Equal(t, os.IsNotExist(err), true, "the binary should not have used the dnote directory")
+}
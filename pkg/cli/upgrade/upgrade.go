@@ -90,21 +90,16 @@ func fetchLatestStableTag(gh *github.Client, page int) (string, error) {
 func checkVersion(ctx context.DnoteCtx) error {
 	log.Infof("current version is %s\n", ctx.Version)
 
-	// Fetch the latest version
-	gh := github.NewClient(nil)
-	latestTag, err := fetchLatestStableTag(gh, 1)
+	latestVersion, err := GetLatestVersion(GetSource(ctx))
 	if err != nil {
-		return errors.Wrap(err, "fetching the latest stable release")
+		return errors.Wrap(err, "getting the latest version")
 	}
-
-	// releases are tagged in a form of cli-v1.0.0
-	latestVersion := latestTag[5:]
 	log.Infof("latest version is %s\n", latestVersion)
 
 	if latestVersion == ctx.Version {
 		log.Success("you are up-to-date\n\n")
 	} else {
-		log.Infof("to upgrade, see https://github.com/dnote/dnote/pkg/cli/blob/master/README.md\n")
+		log.Infof("to upgrade, run 'dnote upgrade'\n")
 	}
 
 	return nil
@@ -141,6 +141,11 @@ func TestValidateBookName(t *testing.T) {
 			expected: ErrBookNameMultiline,
 		},
 
+		{
+			input:    "infra/k8s",
+			expected: ErrBookNameHasSlash,
+		},
+
 		// reserved book names
 		{
 			input:    "trash",
@@ -158,3 +163,49 @@ func TestValidateBookName(t *testing.T) {
 		assert.Equal(t, actual, tc.expected, fmt.Sprintf("result does not match for the input '%s'", tc.input))
 	}
 }
+
+func TestValidateBookPath(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected error
+	}{
+		{
+			input:    "javascript",
+			expected: nil,
+		},
+		{
+			input:    "infra/k8s/networking",
+			expected: nil,
+		},
+		{
+			input:    "infra/",
+			expected: ErrBookNameEmpty,
+		},
+		{
+			input:    "/infra",
+			expected: ErrBookNameEmpty,
+		},
+		{
+			input:    "infra//k8s",
+			expected: ErrBookNameEmpty,
+		},
+		{
+			input:    "infra/k 8s",
+			expected: ErrBookNameHasSpace,
+		},
+		{
+			input:    "infra/123",
+			expected: ErrBookNameNumeric,
+		},
+		{
+			input:    "infra/trash",
+			expected: ErrBookNameReserved,
+		},
+	}
+
+	for _, tc := range testCases {
+		actual := BookPath(tc.input)
+
+		assert.Equal(t, actual, tc.expected, fmt.Sprintf("result does not match for the input '%s'", tc.input))
+	}
+}
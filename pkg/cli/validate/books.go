@@ -42,6 +42,10 @@ var ErrBookNameEmpty = errors.New("The book name is empty")
 // ErrBookNameMultiline is an error for a book name that has linebreaks
 var ErrBookNameMultiline = errors.New("The book name contains multiple lines")
 
+// ErrBookNameHasSlash is an error for a book name that has a slash, which separates
+// the names of nested books in a path
+var ErrBookNameHasSlash = errors.New("The book name cannot contain slashes")
+
 func isReservedName(name string) bool {
 	for _, n := range reservedBookNames {
 		if name == n {
@@ -74,5 +78,21 @@ func BookName(name string) error {
 		return ErrBookNameMultiline
 	}
 
+	if strings.Contains(name, "/") {
+		return ErrBookNameHasSlash
+	}
+
+	return nil
+}
+
+// BookPath validates a path of nested books such as infra/k8s, in which the
+// name of each book is separated by a slash
+func BookPath(path string) error {
+	for _, name := range strings.Split(path, "/") {
+		if err := BookName(name); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
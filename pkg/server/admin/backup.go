@@ -0,0 +1,97 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package admin
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/pkg/errors"
+)
+
+// connArgs returns the arguments for the PostgreSQL client programs to connect
+// to the database
+func connArgs(c database.Config) []string {
+	return []string{
+		"--host", c.Host,
+		"--port", c.Port,
+		"--username", c.User,
+		"--no-password",
+	}
+}
+
+// connEnv returns the environment for the PostgreSQL client programs to connect
+// to the database. The password is passed in the environment rather than the
+// arguments in order not to expose it in the process list.
+func connEnv(c database.Config) []string {
+	env := append(os.Environ(), "PGPASSWORD="+c.Password)
+	if c.SSLMode != "" {
+		env = append(env, "PGSSLMODE="+c.SSLMode)
+	}
+
+	return env
+}
+
+// backupArgs returns the arguments of pg_dump to back up the database to the file
+// at the given path
+func backupArgs(c database.Config, path string) []string {
+	args := connArgs(c)
+	args = append(args, "--format", "custom", "--no-owner", "--file", path, c.Name)
+
+	return args
+}
+
+// restoreArgs returns the arguments of pg_restore to restore the database from
+// the file at the given path. Existing objects are replaced.
+func restoreArgs(c database.Config, path string) []string {
+	args := connArgs(c)
+	args = append(args, "--clean", "--if-exists", "--no-owner", "--single-transaction", "--dbname", c.Name, path)
+
+	return args
+}
+
+func run(c database.Config, name string, args []string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Env = connEnv(c)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "running %s", name)
+	}
+
+	return nil
+}
+
+// Backup dumps the database to the file at the given path by using pg_dump.
+// The dump is in the custom format of PostgreSQL, and can be restored by Restore.
+func Backup(c database.Config, path string) error {
+	return run(c, "pg_dump", backupArgs(c, path))
+}
+
+// Restore restores the database from the dump at the given path by using
+// pg_restore. The existing data in the database is replaced.
+func Restore(c database.Config, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return errors.Wrap(err, "checking the backup file")
+	}
+
+	return run(c, "pg_restore", restoreArgs(c, path))
+}
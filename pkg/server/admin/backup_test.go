@@ -0,0 +1,62 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package admin
+
+import (
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/server/database"
+)
+
+var testDBConfig = database.Config{
+	Host:     "db.example.com",
+	Port:     "5433",
+	Name:     "dnote",
+	User:     "dnote_user",
+	Password: "secret",
+	SSLMode:  "require",
+}
+
+func TestBackupArgs(t *testing.T) {
+	assert.DeepEqual(t, backupArgs(testDBConfig, "/tmp/dnote.dump"), []string{
+		"--host", "db.example.com",
+		"--port", "5433",
+		"--username", "dnote_user",
+		"--no-password",
+		"--format", "custom", "--no-owner", "--file", "/tmp/dnote.dump", "dnote",
+	}, "args mismatch")
+}
+
+func TestRestoreArgs(t *testing.T) {
+	assert.DeepEqual(t, restoreArgs(testDBConfig, "/tmp/dnote.dump"), []string{
+		"--host", "db.example.com",
+		"--port", "5433",
+		"--username", "dnote_user",
+		"--no-password",
+		"--clean", "--if-exists", "--no-owner", "--single-transaction", "--dbname", "dnote", "/tmp/dnote.dump",
+	}, "args mismatch")
+}
+
+func TestConnEnv(t *testing.T) {
+	env := connEnv(testDBConfig)
+
+	assert.Equal(t, env[len(env)-2], "PGPASSWORD=secret", "PGPASSWORD mismatch")
+	assert.Equal(t, env[len(env)-1], "PGSSLMODE=require", "PGSSLMODE mismatch")
+}
@@ -0,0 +1,198 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package admin
+
+import (
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ProblemMaxUSNBehind indicates that the max_usn of a user is smaller than the
+	// usn of some of the items of the user. Clients would never fetch those items.
+	ProblemMaxUSNBehind = "max_usn_behind"
+	// ProblemDuplicateUSN indicates that an item has the same usn as another item
+	// in the sequence of the user. Clients can miss either item while syncing.
+	ProblemDuplicateUSN = "duplicate_usn"
+	// ProblemZeroUSN indicates that an item was never given a usn. Clients would
+	// never fetch the item.
+	ProblemZeroUSN = "zero_usn"
+)
+
+// usnSourceShared is the source of the items in the books shared with a user
+const usnSourceShared = "shared_usns"
+
+// usnItem is an item in the update sequence of a user
+type usnItem struct {
+	// Source is the table of the item
+	Source string
+	Type   string
+	UUID   string
+	USN    int
+}
+
+// Problem is an inconsistency in the update sequence of a user
+type Problem struct {
+	Kind string
+	// Type and UUID identify the item with the problem. They are empty
+	// if the problem is with the user rather than an item.
+	Type string
+	UUID string
+	// Shared is true if the item is in a book shared with the user
+	Shared bool
+	USN    int
+}
+
+// USNReport is the result of checking the update sequence of a user
+type USNReport struct {
+	UserUUID string
+	MaxUSN   int
+	Problems []Problem
+}
+
+// OK returns whether the update sequence has no problem
+func (r USNReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// getUSNItems returns all items in the update sequence of the given user in the
+// order of usn
+func getUSNItems(db *gorm.DB, userID int) ([]usnItem, error) {
+	var ret []usnItem
+	if err := db.Raw(`SELECT 'notes' AS source, 'note' AS type, uuid::text AS uuid, usn FROM notes WHERE user_id = ?
+UNION ALL SELECT 'books', 'book', uuid::text, usn FROM books WHERE user_id = ?
+UNION ALL SELECT 'shared_usns', type, uuid::text, usn FROM shared_usns WHERE user_id = ?
+ORDER BY usn, source, uuid`, userID, userID, userID).
+		Scan(&ret).Error; err != nil {
+		return nil, errors.Wrap(err, "querying usn")
+	}
+
+	return ret, nil
+}
+
+// checkItems returns the problems in the given update sequence along with the
+// items that need a new usn. The items must be sorted by usn.
+func checkItems(maxUSN int, items []usnItem) ([]Problem, []usnItem) {
+	problems := []Problem{}
+	stale := []usnItem{}
+
+	for i, item := range items {
+		var kind string
+		if item.USN == 0 {
+			kind = ProblemZeroUSN
+		} else if i > 0 && items[i-1].USN == item.USN {
+			kind = ProblemDuplicateUSN
+		} else {
+			continue
+		}
+
+		problems = append(problems, Problem{
+			Kind:   kind,
+			Type:   item.Type,
+			UUID:   item.UUID,
+			Shared: item.Source == usnSourceShared,
+			USN:    item.USN,
+		})
+		stale = append(stale, item)
+	}
+
+	if len(items) > 0 && items[len(items)-1].USN > maxUSN {
+		problems = append(problems, Problem{
+			Kind: ProblemMaxUSNBehind,
+			USN:  items[len(items)-1].USN,
+		})
+	}
+
+	return problems, stale
+}
+
+// CheckUSN checks the update sequence of the given user
+func CheckUSN(db *gorm.DB, user database.User) (USNReport, error) {
+	items, err := getUSNItems(db, user.ID)
+	if err != nil {
+		return USNReport{}, errors.Wrap(err, "getting items")
+	}
+
+	problems, _ := checkItems(user.MaxUSN, items)
+
+	return USNReport{
+		UserUUID: user.UUID,
+		MaxUSN:   user.MaxUSN,
+		Problems: problems,
+	}, nil
+}
+
+func updateItemUSN(tx *gorm.DB, userID int, item usnItem, usn int) error {
+	conn := tx.Table(item.Source).Where("user_id = ? AND uuid = ?", userID, item.UUID)
+	if item.Source == usnSourceShared {
+		conn = conn.Where("type = ?", item.Type)
+	}
+
+	if err := conn.Update("usn", usn).Error; err != nil {
+		return errors.Wrapf(err, "updating %s", item.Source)
+	}
+
+	return nil
+}
+
+// FixUSN fixes the update sequence of the given user by giving new usn to the
+// items with problems and raising the max_usn of the user. Because new usn are
+// greater than any existing usn, clients fetch the items in the next sync.
+// It returns the report of the problems that were fixed.
+func FixUSN(tx *gorm.DB, user database.User) (USNReport, error) {
+	// Lock the user so that no item is given a usn while the sequence is fixed
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", user.ID).First(&user).Error; err != nil {
+		return USNReport{}, errors.Wrap(err, "locking user")
+	}
+
+	items, err := getUSNItems(tx, user.ID)
+	if err != nil {
+		return USNReport{}, errors.Wrap(err, "getting items")
+	}
+
+	problems, stale := checkItems(user.MaxUSN, items)
+	report := USNReport{
+		UserUUID: user.UUID,
+		MaxUSN:   user.MaxUSN,
+		Problems: problems,
+	}
+	if report.OK() {
+		return report, nil
+	}
+
+	maxUSN := user.MaxUSN
+	if len(items) > 0 && items[len(items)-1].USN > maxUSN {
+		maxUSN = items[len(items)-1].USN
+	}
+
+	for _, item := range stale {
+		maxUSN++
+
+		if err := updateItemUSN(tx, user.ID, item, maxUSN); err != nil {
+			return report, errors.Wrapf(err, "updating usn of %s %s", item.Type, item.UUID)
+		}
+	}
+
+	if err := tx.Table("users").Where("id = ?", user.ID).Update("max_usn", maxUSN).Error; err != nil {
+		return report, errors.Wrap(err, "updating max_usn")
+	}
+
+	return report, nil
+}
@@ -0,0 +1,147 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package admin
+
+import (
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestCheckItems(t *testing.T) {
+	testCases := []struct {
+		name             string
+		maxUSN           int
+		items            []usnItem
+		expectedProblems []Problem
+		expectedStale    []usnItem
+	}{
+		{
+			name:             "empty",
+			maxUSN:           0,
+			items:            []usnItem{},
+			expectedProblems: []Problem{},
+			expectedStale:    []usnItem{},
+		},
+		{
+			name:   "consistent",
+			maxUSN: 4,
+			items: []usnItem{
+				{Source: "books", Type: "book", UUID: "b1", USN: 1},
+				{Source: "notes", Type: "note", UUID: "n1", USN: 2},
+				{Source: "shared_usns", Type: "note", UUID: "n2", USN: 3},
+			},
+			expectedProblems: []Problem{},
+			expectedStale:    []usnItem{},
+		},
+		{
+			name:   "max_usn behind",
+			maxUSN: 2,
+			items: []usnItem{
+				{Source: "books", Type: "book", UUID: "b1", USN: 1},
+				{Source: "notes", Type: "note", UUID: "n1", USN: 3},
+			},
+			expectedProblems: []Problem{
+				{Kind: ProblemMaxUSNBehind, USN: 3},
+			},
+			expectedStale: []usnItem{},
+		},
+		{
+			name:   "duplicate and zero",
+			maxUSN: 3,
+			items: []usnItem{
+				{Source: "notes", Type: "note", UUID: "n3", USN: 0},
+				{Source: "books", Type: "book", UUID: "b1", USN: 1},
+				{Source: "notes", Type: "note", UUID: "n1", USN: 2},
+				{Source: "shared_usns", Type: "note", UUID: "n2", USN: 2},
+			},
+			expectedProblems: []Problem{
+				{Kind: ProblemZeroUSN, Type: "note", UUID: "n3", USN: 0},
+				{Kind: ProblemDuplicateUSN, Type: "note", UUID: "n2", Shared: true, USN: 2},
+			},
+			expectedStale: []usnItem{
+				{Source: "notes", Type: "note", UUID: "n3", USN: 0},
+				{Source: "shared_usns", Type: "note", UUID: "n2", USN: 2},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			problems, stale := checkItems(tc.maxUSN, tc.items)
+
+			assert.DeepEqual(t, problems, tc.expectedProblems, "problems mismatch")
+			assert.DeepEqual(t, stale, tc.expectedStale, "stale mismatch")
+		})
+	}
+}
+
+func TestFixUSN(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Set up
+	user := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&user).Update("max_usn", 2), "preparing user max_usn")
+	user.MaxUSN = 2
+
+	b1 := database.Book{UserID: user.ID, Label: "js", USN: 1}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	n1 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "n1", USN: 3}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+	n2 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "n2", USN: 3}
+	testutils.MustExec(t, db.Save(&n2), "preparing n2")
+	n3 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "n3", USN: 0}
+	testutils.MustExec(t, db.Save(&n3), "preparing n3")
+
+	report, err := CheckUSN(db, user)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "checking usn"))
+	}
+	assert.Equal(t, len(report.Problems), 3, "problem count mismatch")
+
+	// Execute
+	tx := db.Begin()
+	fixed, err := FixUSN(tx, user)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "fixing usn"))
+	}
+	tx.Commit()
+
+	// Test
+	assert.DeepEqual(t, fixed, report, "fixed report mismatch")
+
+	var userRecord database.User
+	testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), "finding user")
+	assert.Equal(t, userRecord.MaxUSN, 5, "max_usn mismatch")
+
+	after, err := CheckUSN(db, userRecord)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "checking usn after fix"))
+	}
+	assert.Equal(t, after.OK(), true, "problems remain after fix")
+
+	var n3Record database.Note
+	testutils.MustExec(t, db.Where("id = ?", n3.ID).First(&n3Record), "finding n3")
+	assert.Equal(t, n3Record.USN, 4, "n3 usn mismatch")
+}
@@ -0,0 +1,101 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package admin
+
+import (
+	"time"
+
+	"github.com/dnote/dnote/pkg/server/api/helpers"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUserNotFound is returned if no user matches the given email or uuid
+var ErrUserNotFound = errors.New("user not found")
+
+// FindUser finds the user with the given email or uuid
+func FindUser(db *gorm.DB, identifier string) (database.User, error) {
+	var user database.User
+
+	var conn *gorm.DB
+	if helpers.ValidateUUID(identifier) {
+		conn = db.Where("uuid = ?", identifier).First(&user)
+	} else {
+		conn = db.Joins("INNER JOIN accounts ON accounts.user_id = users.id").
+			Where("accounts.email = ?", identifier).
+			First(&user)
+	}
+
+	if conn.RecordNotFound() {
+		return user, ErrUserNotFound
+	} else if err := conn.Error; err != nil {
+		return user, errors.Wrap(err, "finding user")
+	}
+
+	return user, nil
+}
+
+// UserInfo is the summary of a user
+type UserInfo struct {
+	UUID      string
+	Email     string
+	Admin     bool
+	Disabled  bool
+	MaxUSN    int
+	CreatedAt time.Time
+}
+
+// ListUsers returns the summaries of all users in the order of creation
+func ListUsers(db *gorm.DB) ([]UserInfo, error) {
+	var ret []UserInfo
+	if err := db.Table("users").
+		Select("users.uuid, COALESCE(accounts.email, '') AS email, users.admin, users.disabled_at IS NOT NULL AS disabled, users.max_usn, users.created_at").
+		Joins("LEFT JOIN accounts ON accounts.user_id = users.id").
+		Order("users.id ASC").
+		Scan(&ret).Error; err != nil {
+		return nil, errors.Wrap(err, "querying users")
+	}
+
+	return ret, nil
+}
+
+// ResetPassword sets the password of the given user and signs the user out of all
+// sessions
+func ResetPassword(tx *gorm.DB, user database.User, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.Wrap(err, "hashing password")
+	}
+
+	conn := tx.Model(&database.Account{}).Where("user_id = ?", user.ID).Update("password", string(hashedPassword))
+	if err := conn.Error; err != nil {
+		return errors.Wrap(err, "updating password")
+	}
+	if conn.RowsAffected == 0 {
+		return errors.New("the user does not have an account")
+	}
+
+	if err := tx.Where("user_id = ?", user.ID).Delete(&database.Session{}).Error; err != nil {
+		return errors.Wrap(err, "deleting sessions")
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package admin
+
+import (
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	testutils.InitTestDB()
+}
+
+func TestFindUser(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	u1 := testutils.SetupUserData()
+	testutils.SetupAccountData(u1, "alice@example.com", "pass1234")
+	u2 := testutils.SetupUserData()
+	testutils.SetupAccountData(u2, "bob@example.com", "pass1234")
+
+	testCases := []struct {
+		identifier string
+		expectedID int
+	}{
+		{"alice@example.com", u1.ID},
+		{"bob@example.com", u2.ID},
+		{u1.UUID, u1.ID},
+		{u2.UUID, u2.ID},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.identifier, func(t *testing.T) {
+			user, err := FindUser(db, tc.identifier)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "finding user"))
+			}
+
+			assert.Equal(t, user.ID, tc.expectedID, "user mismatch")
+		})
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := FindUser(db, "chuck@example.com")
+		assert.Equal(t, err, ErrUserNotFound, "error mismatch")
+
+		_, err = FindUser(db, "4fd19336-671e-4ff3-8f22-662b80e22edc")
+		assert.Equal(t, err, ErrUserNotFound, "error mismatch")
+	})
+}
+
+func TestListUsers(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	u1 := testutils.SetupUserData()
+	testutils.SetupAccountData(u1, "alice@example.com", "pass1234")
+	testutils.MustExec(t, db.Model(&u1).Update(map[string]interface{}{"admin": true, "max_usn": 3}), "preparing u1")
+	u2 := testutils.SetupUserData()
+	testutils.SetupAccountData(u2, "bob@example.com", "pass1234")
+	testutils.MustExec(t, db.Model(&u2).Update("disabled_at", u2.CreatedAt), "preparing u2")
+	u3 := testutils.SetupUserData()
+
+	users, err := ListUsers(db)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "listing users"))
+	}
+
+	assert.Equal(t, len(users), 3, "length mismatch")
+
+	assert.Equal(t, users[0].UUID, u1.UUID, "users[0] UUID mismatch")
+	assert.Equal(t, users[0].Email, "alice@example.com", "users[0] Email mismatch")
+	assert.Equal(t, users[0].Admin, true, "users[0] Admin mismatch")
+	assert.Equal(t, users[0].Disabled, false, "users[0] Disabled mismatch")
+	assert.Equal(t, users[0].MaxUSN, 3, "users[0] MaxUSN mismatch")
+
+	assert.Equal(t, users[1].UUID, u2.UUID, "users[1] UUID mismatch")
+	assert.Equal(t, users[1].Email, "bob@example.com", "users[1] Email mismatch")
+	assert.Equal(t, users[1].Admin, false, "users[1] Admin mismatch")
+	assert.Equal(t, users[1].Disabled, true, "users[1] Disabled mismatch")
+
+	assert.Equal(t, users[2].UUID, u3.UUID, "users[2] UUID mismatch")
+	assert.Equal(t, users[2].Email, "", "users[2] Email mismatch")
+}
+
+func TestResetPassword(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	u1 := testutils.SetupUserData()
+	a1 := testutils.SetupAccountData(u1, "alice@example.com", "pass1234")
+	testutils.SetupSession(t, u1)
+
+	u2 := testutils.SetupUserData()
+	s2 := database.Session{Key: "some-session-key", UserID: u2.ID}
+	testutils.MustExec(t, db.Save(&s2), "preparing session")
+
+	tx := db.Begin()
+	if err := ResetPassword(tx, u1, "newpassword"); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "resetting password"))
+	}
+	tx.Commit()
+
+	var account database.Account
+	testutils.MustExec(t, db.Where("id = ?", a1.ID).First(&account), "finding account")
+	if err := bcrypt.CompareHashAndPassword([]byte(account.Password.String), []byte("newpassword")); err != nil {
+		t.Error("password mismatch")
+	}
+
+	var u1SessionCount, u2SessionCount int
+	testutils.MustExec(t, db.Model(&database.Session{}).Where("user_id = ?", u1.ID).Count(&u1SessionCount), "counting u1 sessions")
+	testutils.MustExec(t, db.Model(&database.Session{}).Where("user_id = ?", u2.ID).Count(&u2SessionCount), "counting u2 sessions")
+	assert.Equal(t, u1SessionCount, 0, "u1 session count mismatch")
+	assert.Equal(t, u2SessionCount, 1, "u2 session count mismatch")
+}
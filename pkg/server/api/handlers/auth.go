@@ -27,6 +27,7 @@ import (
 	"github.com/dnote/dnote/pkg/server/api/helpers"
 	"github.com/dnote/dnote/pkg/server/api/operations"
 	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/entitlement"
 	"github.com/dnote/dnote/pkg/server/mailer"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/bcrypt"
@@ -38,15 +39,17 @@ type Session struct {
 	Email         string `json:"email"`
 	EmailVerified bool   `json:"email_verified"`
 	Pro           bool   `json:"pro"`
+	Billing       bool   `json:"billing"`
 	Classic       bool   `json:"classic"`
 }
 
-func makeSession(user database.User, account database.Account) Session {
+func (a *App) makeSession(user database.User, account database.Account) Session {
 	classic := account.AuthKeyHash != ""
 
 	return Session{
 		UUID:          user.UUID,
-		Pro:           user.Cloud,
+		Pro:           a.Entitlements.Entitled(user, entitlement.FeaturePro),
+		Billing:       !a.SelfHosted,
 		Email:         account.Email.String,
 		EmailVerified: account.EmailVerified,
 		Classic:       classic,
@@ -68,7 +71,7 @@ func (a *App) getMe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	session := makeSession(user, account)
+	session := a.makeSession(user, account)
 
 	response := struct {
 		User Session `json:"user"`
@@ -146,7 +149,7 @@ func (a *App) createResetToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := email.Send(); err != nil {
+	if err := a.Mailer.Send(email); err != nil {
 		handleError(w, errors.Wrap(err, "sending email").Error(), nil, http.StatusInternalServerError)
 		return
 	}
@@ -223,5 +226,20 @@ func (a *App) resetPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondWithSession(w, user.ID, http.StatusOK)
+	a.recordAuditEvent(db, r, user.ID, user.ID, database.AuditEventPasswordReset)
+
+	// the password reset link does not prove the possession of the second factor,
+	// and the user must sign in with it
+	if account.TOTPEnabled {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	session, err := a.createSigninSession(db, user, account, a.getSessionInfo(r, ""))
+	if err != nil {
+		handleError(w, "creating session", err, http.StatusInternalServerError)
+		return
+	}
+
+	a.writeSession(w, session, http.StatusOK)
 }
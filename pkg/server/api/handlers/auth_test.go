@@ -165,6 +165,50 @@ func TestResetPassword(t *testing.T) {
 		passwordErr := bcrypt.CompareHashAndPassword([]byte(account.Password.String), []byte("newpassword"))
 		assert.Equal(t, passwordErr, nil, "Password mismatch")
 		assert.Equal(t, verificationToken.UsedAt, (*time.Time)(nil), "verificationToken UsedAt mismatch")
+
+		var sessionCount int
+		testutils.MustExec(t, db.Model(&database.Session{}).Where("user_id = ?", u.ID).Count(&sessionCount), "counting sessions")
+		assert.Equal(t, sessionCount, 1, "session count mismatch")
+	})
+
+	t.Run("two-factor authentication enabled", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		// Setup
+		server := httptest.NewServer(NewRouter(&App{
+			Clock: clock.NewMock(),
+		}))
+		defer server.Close()
+
+		u := testutils.SetupUserData()
+		a := testutils.SetupAccountData(u, "alice@example.com", "oldpassword")
+		testutils.MustExec(t, db.Model(&a).Update("totp_enabled", true), "enabling two-factor authentication")
+		tok := database.Token{
+			UserID: u.ID,
+			Value:  "MivFxYiSMMA4An9dP24DNQ==",
+			Type:   database.TokenTypeResetPassword,
+		}
+		testutils.MustExec(t, db.Save(&tok), "preparing token")
+
+		dat := `{"token": "MivFxYiSMMA4An9dP24DNQ==", "password": "newpassword"}`
+		req := testutils.MakeReq(server, "PATCH", "/reset-password", dat)
+
+		// Execute
+		res := testutils.HTTPDo(t, req)
+
+		// Test
+		assert.StatusCodeEquals(t, res, http.StatusOK, "Status code mismatch")
+
+		var account database.Account
+		var sessionCount int
+		testutils.MustExec(t, db.Where("id = ?", a.ID).First(&account), "finding account")
+		testutils.MustExec(t, db.Model(&database.Session{}).Where("user_id = ?", u.ID).Count(&sessionCount), "counting sessions")
+
+		passwordErr := bcrypt.CompareHashAndPassword([]byte(account.Password.String), []byte("newpassword"))
+		assert.Equal(t, passwordErr, nil, "Password mismatch")
+		assert.Equal(t, sessionCount, 0, "session count mismatch")
+		assert.Equal(t, testutils.GetCookieByName(res.Cookies(), "id"), (*http.Cookie)(nil), "session cookie mismatch")
 	})
 
 	t.Run("nonexistent token", func(t *testing.T) {
@@ -27,7 +27,9 @@ import (
 	"github.com/dnote/dnote/pkg/server/api/operations"
 	"github.com/dnote/dnote/pkg/server/api/presenters"
 	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/entitlement"
 	"github.com/dnote/dnote/pkg/server/log"
+	"github.com/jinzhu/gorm"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -60,6 +62,27 @@ func (a *App) classicMigrate(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// defaultClientKDFIteration is the default number of iterations of the client-side
+// key derivation function
+const defaultClientKDFIteration = 100000
+
+// getMinClientKDFIteration returns the number of iterations of the client-side key
+// derivation function that satisfies the policies of all organizations
+func getMinClientKDFIteration(db *gorm.DB) (int, error) {
+	var result struct {
+		Iteration int
+	}
+	if err := db.Model(&database.Organization{}).Select("COALESCE(MAX(min_client_kdf_iteration), 0) AS iteration").Scan(&result).Error; err != nil {
+		return 0, errors.Wrap(err, "finding maximum policy")
+	}
+
+	if result.Iteration > defaultClientKDFIteration {
+		return result.Iteration, nil
+	}
+
+	return defaultClientKDFIteration, nil
+}
+
 // PresigninResponse is a response for presignin
 type PresigninResponse struct {
 	Iteration int `json:"iteration"`
@@ -84,8 +107,14 @@ func (a *App) classicPresignin(w http.ResponseWriter, r *http.Request) {
 
 	var response PresigninResponse
 	if conn.RecordNotFound() {
+		iteration, err := getMinClientKDFIteration(db)
+		if err != nil {
+			handleError(w, "getting minimum iteration", err, http.StatusInternalServerError)
+			return
+		}
+
 		response = PresigninResponse{
-			Iteration: 100000,
+			Iteration: iteration,
 		}
 	} else {
 		response = PresigninResponse{
@@ -101,8 +130,10 @@ func (a *App) classicPresignin(w http.ResponseWriter, r *http.Request) {
 }
 
 type classicSigninPayload struct {
-	Email   string `json:"email"`
-	AuthKey string `json:"auth_key"`
+	Email        string `json:"email"`
+	AuthKey      string `json:"auth_key"`
+	TOTPCode     string `json:"totp_code"`
+	RecoveryCode string `json:"recovery_code"`
 }
 
 func (a *App) classicSignin(w http.ResponseWriter, r *http.Request) {
@@ -134,26 +165,54 @@ func (a *App) classicSignin(w http.ResponseWriter, r *http.Request) {
 		log.WithFields(log.Fields{
 			"account_id": account.ID,
 		}).Error("Sign in password mismatch")
+		a.recordAuditEvent(db, r, account.UserID, account.UserID, database.AuditEventSigninFailed)
 		http.Error(w, ErrLoginFailure.Error(), http.StatusUnauthorized)
 		return
 	}
 
-	session, err := operations.CreateSession(db, account.UserID)
+	var user database.User
+	if err := db.Where("id = ?", account.UserID).First(&user).Error; err != nil {
+		handleError(w, "finding user", err, http.StatusInternalServerError)
+		return
+	}
+	if user.DisabledAt != nil {
+		http.Error(w, ErrAccountDisabled.Error(), http.StatusForbidden)
+		return
+	}
+
+	org, hasOrg, err := operations.GetOrganization(db, user)
+	if err != nil {
+		handleError(w, "getting organization", err, http.StatusInternalServerError)
+		return
+	}
+	if hasOrg && account.ClientKDFIteration < org.MinClientKDFIteration {
+		http.Error(w, "The key derivation iteration of your account is lower than the minimum required by your organization. Please migrate your account", http.StatusForbidden)
+		return
+	}
+
+	if ok := a.checkSecondFactor(w, r, db, &account, params.TOTPCode, params.RecoveryCode); !ok {
+		return
+	}
+
+	session, err := a.createSigninSession(db, user, account, a.getSessionInfo(r, ""))
 	if err != nil {
 		handleError(w, "creating session", nil, http.StatusBadRequest)
 		return
 	}
 
-	setSessionCookie(w, session.Key, session.ExpiresAt)
+	a.recordAuditEvent(db, r, user.ID, user.ID, database.AuditEventSignin)
+	a.setSessionCookie(w, session.Key, session.ExpiresAt)
 
 	response := struct {
-		Key          string `json:"key"`
-		ExpiresAt    int64  `json:"expires_at"`
-		CipherKeyEnc string `json:"cipher_key_enc"`
+		Key                    string `json:"key"`
+		ExpiresAt              int64  `json:"expires_at"`
+		CipherKeyEnc           string `json:"cipher_key_enc"`
+		TOTPEnrollmentRequired bool   `json:"totp_enrollment_required,omitempty"`
 	}{
-		Key:          session.Key,
-		ExpiresAt:    session.ExpiresAt.Unix(),
-		CipherKeyEnc: account.CipherKeyEnc,
+		Key:                    session.Key,
+		ExpiresAt:              session.ExpiresAt.Unix(),
+		CipherKeyEnc:           account.CipherKeyEnc,
+		TOTPEnrollmentRequired: session.TOTPEnrollmentRequired,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
@@ -199,7 +258,7 @@ func (a *App) classicGetMe(w http.ResponseWriter, r *http.Request) {
 		GithubName:      account.Nickname,
 		GithubAccountID: account.AccountID,
 		APIKey:          user.APIKey,
-		Cloud:           user.Cloud,
+		Cloud:           a.Entitlements.Entitled(user, entitlement.FeaturePro),
 		Email:           account.Email.String,
 		EmailVerified:   account.EmailVerified,
 		Name:            user.Name,
@@ -20,9 +20,94 @@ package handlers
 
 import (
 	"net/http"
+
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/log"
+	"github.com/pkg/errors"
 )
 
 func (a *App) checkHealth(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("ok"))
 }
+
+// checkLiveness responds with success as long as the server is able to handle requests
+func (a *App) checkLiveness(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+// ReadinessResp is a response from the readiness check. Checks is a map from the
+// name of each check to "ok" or the reason of the failure.
+type ReadinessResp struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+func checkDatabase() error {
+	if err := database.DBConn.DB().Ping(); err != nil {
+		return errors.Wrap(err, "pinging database")
+	}
+
+	return nil
+}
+
+func (a *App) checkMigrations() error {
+	countPending := a.CountPendingMigrations
+	if countPending == nil {
+		countPending = database.CountPendingMigrations
+	}
+
+	n, err := countPending()
+	if err != nil {
+		return errors.Wrap(err, "counting pending migrations")
+	}
+	if n > 0 {
+		return errors.Errorf("%d migrations are pending", n)
+	}
+
+	return nil
+}
+
+// checkReadiness responds with success if the server is ready to serve traffic,
+// that is, the database is reachable and all migrations have been applied
+func (a *App) checkReadiness(w http.ResponseWriter, r *http.Request) {
+	resp := ReadinessResp{
+		Ready:  true,
+		Checks: map[string]string{},
+	}
+
+	checks := []struct {
+		name  string
+		check func() error
+	}{
+		{"database", checkDatabase},
+		{"migrations", a.checkMigrations},
+	}
+
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			log.WithFields(log.Fields{
+				"check": c.name,
+			}).ErrorWrap(err, "readiness check failed")
+
+			resp.Ready = false
+			resp.Checks[c.name] = err.Error()
+
+			// the other checks need the database
+			if c.name == "database" {
+				break
+			}
+			continue
+		}
+
+		resp.Checks[c.name] = "ok"
+	}
+
+	statusCode := http.StatusOK
+	if !resp.Ready {
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	respondJSON(w, statusCode, resp)
+}
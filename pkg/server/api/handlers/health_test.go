@@ -19,6 +19,7 @@
 package handlers
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -26,6 +27,7 @@ import (
 	"github.com/dnote/dnote/pkg/assert"
 	"github.com/dnote/dnote/pkg/clock"
 	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
 )
 
 func TestCheckHealth(t *testing.T) {
@@ -44,3 +46,89 @@ func TestCheckHealth(t *testing.T) {
 	// Test
 	assert.StatusCodeEquals(t, res, http.StatusOK, "Status code mismtach")
 }
+
+func TestCheckLiveness(t *testing.T) {
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	// Execute
+	req := testutils.MakeReq(server, "GET", "/healthz", "")
+	res := testutils.HTTPDo(t, req)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusOK, "Status code mismtach")
+}
+
+func TestCheckReadiness(t *testing.T) {
+	testCases := []struct {
+		name               string
+		countPending       func() (int, error)
+		expectedStatusCode int
+		expectedResp       ReadinessResp
+	}{
+		{
+			name:               "ready",
+			countPending:       func() (int, error) { return 0, nil },
+			expectedStatusCode: http.StatusOK,
+			expectedResp: ReadinessResp{
+				Ready: true,
+				Checks: map[string]string{
+					"database":   "ok",
+					"migrations": "ok",
+				},
+			},
+		},
+		{
+			name:               "pending migrations",
+			countPending:       func() (int, error) { return 2, nil },
+			expectedStatusCode: http.StatusServiceUnavailable,
+			expectedResp: ReadinessResp{
+				Ready: false,
+				Checks: map[string]string{
+					"database":   "ok",
+					"migrations": "2 migrations are pending",
+				},
+			},
+		},
+		{
+			name:               "migration state error",
+			countPending:       func() (int, error) { return 0, errors.New("foo") },
+			expectedStatusCode: http.StatusServiceUnavailable,
+			expectedResp: ReadinessResp{
+				Ready: false,
+				Checks: map[string]string{
+					"database":   "ok",
+					"migrations": "counting pending migrations: foo",
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Setup
+			server := httptest.NewServer(NewRouter(&App{
+				Clock:                  clock.NewMock(),
+				CountPendingMigrations: tc.countPending,
+			}))
+			defer server.Close()
+
+			// Execute
+			req := testutils.MakeReq(server, "GET", "/readyz", "")
+			res := testutils.HTTPDo(t, req)
+
+			// Test
+			assert.StatusCodeEquals(t, res, tc.expectedStatusCode, "Status code mismtach")
+
+			var payload ReadinessResp
+			if err := json.NewDecoder(res.Body).Decode(&payload); err != nil {
+				t.Fatal(errors.Wrap(err, "decoding payload"))
+			}
+
+			assert.DeepEqual(t, payload, tc.expectedResp, "payload mismatch")
+		})
+	}
+}
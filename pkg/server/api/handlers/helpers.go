@@ -96,7 +96,13 @@ func validatePassword(password string) error {
 	return nil
 }
 
-func getClientType(origin string) string {
+// getClientType returns the kind of the client that made the request
+func getClientType(r *http.Request) string {
+	if r.Header.Get("CLI-Version") != "" {
+		return "cli"
+	}
+
+	origin := r.Header.Get("Origin")
 	if strings.HasPrefix(origin, "moz-extension://") {
 		return "firefox-extension"
 	}
@@ -119,6 +125,7 @@ func handleError(w http.ResponseWriter, msg string, err error, statusCode int) {
 
 	log.WithFields(log.Fields{
 		"statusCode": statusCode,
+		"requestID":  w.Header().Get(requestIDHeader),
 	}).Error(message)
 
 	statusText := http.StatusText(statusCode)
@@ -19,102 +19,107 @@
 package handlers
 
 import (
+	"fmt"
+	"math"
 	"net/http"
-	"strings"
-	"sync"
+	"strconv"
 	"time"
 
+	"github.com/dnote/dnote/pkg/server/database"
 	"github.com/dnote/dnote/pkg/server/log"
-	"golang.org/x/time/rate"
+	"github.com/dnote/dnote/pkg/server/ratelimit"
+	"github.com/pkg/errors"
 )
 
-type visitor struct {
-	limiter  *rate.Limiter
-	lastSeen time.Time
-}
-
-var visitors = make(map[string]*visitor)
-var mtx sync.RWMutex
-
-func init() {
-	go cleanupVisitors()
-}
-
-// addVisitor adds a new visitor to the map and returns a limiter for the visitor
-func addVisitor(identifier string) *rate.Limiter {
-	// initialize a token bucket
-	limiter := rate.NewLimiter(rate.Every(1*time.Second), 60)
-
-	mtx.Lock()
-	visitors[identifier] = &visitor{
-		limiter:  limiter,
-		lastSeen: time.Now()}
-	mtx.Unlock()
+var (
+	// defaultRateLimit is the rate limit policy for most routes
+	defaultRateLimit = &ratelimit.Policy{Name: "default", Limit: 60, Window: time.Minute}
+	// authRateLimit is the rate limit policy for the routes that accept credentials
+	// or send emails, in order to slow down brute force attacks
+	authRateLimit = &ratelimit.Policy{Name: "auth", Limit: 10, Window: time.Minute}
+	// userRateLimit is the rate limit policy for the routes that clients call
+	// frequently during sync
+	userRateLimit = &ratelimit.Policy{Name: "user", Limit: 600, Window: time.Minute, PerUser: true}
+	// syncRateLimit is the rate limit policy for the routes that create books and notes.
+	// A sync sends a request for each book and note changed on the client, so the budget
+	// is high enough to sync a large number of notes at once.
+	syncRateLimit = &ratelimit.Policy{Name: "sync", Limit: 10000, Window: time.Minute, PerUser: true}
+)
 
-	return limiter
+// lookupIP returns the IP address of the client that made the request. The
+// forwarding headers are only trusted if the request came from a trusted proxy.
+func (a *App) lookupIP(r *http.Request) string {
+	return a.TrustedProxies.ClientIP(r)
 }
 
-// getVisitor returns a limiter for a visitor with the given identifier. It
-// adds the visitor to the map if not seen before.
-func getVisitor(identifier string) *rate.Limiter {
-	mtx.RLock()
-	v, exists := visitors[identifier]
-
-	if !exists {
-		mtx.RUnlock()
-		return addVisitor(identifier)
+// getSessionUserID returns the ID of the user who owns the session used in the request
+func getSessionUserID(r *http.Request, now time.Time) (int, bool, error) {
+	sessionKey, err := getCredential(r)
+	if err != nil {
+		return 0, false, errors.Wrap(err, "getting credential")
+	}
+	if sessionKey == "" {
+		return 0, false, nil
 	}
 
-	v.lastSeen = time.Now()
-	mtx.RUnlock()
+	var session database.Session
+	conn := database.DBConn.Where("key = ? AND expires_at > ?", sessionKey, now).First(&session)
+	if conn.RecordNotFound() {
+		return 0, false, nil
+	} else if err := conn.Error; err != nil {
+		return 0, false, errors.Wrap(err, "finding session")
+	}
 
-	return v.limiter
+	return session.UserID, true, nil
 }
 
-// cleanupVisitors deletes visitors that has not been seen in a while from the
-// map of visitors
-func cleanupVisitors() {
-	for {
-		time.Sleep(time.Minute)
-		mtx.Lock()
-
-		for identifier, v := range visitors {
-			if time.Now().Sub(v.lastSeen) > 3*time.Minute {
-				delete(visitors, identifier)
-			}
+// getRateLimitIdentity returns the identity of the client to which the policy applies
+func (a *App) getRateLimitIdentity(r *http.Request, p ratelimit.Policy) string {
+	if p.PerUser {
+		userID, ok, err := getSessionUserID(r, a.Clock.Now())
+		if err != nil {
+			log.ErrorWrap(err, "getting the user for rate limiting")
+		} else if ok {
+			return fmt.Sprintf("user:%d", userID)
 		}
-
-		mtx.Unlock()
 	}
+
+	return fmt.Sprintf("ip:%s", a.lookupIP(r))
 }
 
-// lookupIP returns the request's IP
-func lookupIP(r *http.Request) string {
-	realIP := r.Header.Get("X-Real-IP")
-	forwardedFor := r.Header.Get("X-Forwarded-For")
+func setRateLimitHeaders(w http.ResponseWriter, res ratelimit.Result) {
+	reset := int(math.Ceil(res.Reset.Seconds()))
 
-	if forwardedFor != "" {
-		parts := strings.Split(forwardedFor, ",")
-		return parts[0]
-	}
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(reset))
 
-	if realIP != "" {
-		return realIP
+	if !res.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(reset))
 	}
-
-	return r.RemoteAddr
 }
 
-// limit is a middleware to rate limit the handler
-func limit(next http.Handler) http.HandlerFunc {
+// limit is a middleware to rate limit the handler using the given policy.
+// If the limiter fails, requests are allowed so that the store does not become
+// a single point of failure.
+func (a *App) limit(next http.Handler, p ratelimit.Policy) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		identifier := lookupIP(r)
-		limiter := getVisitor(identifier)
+		identity := a.getRateLimitIdentity(r, p)
+
+		res, err := a.RateLimiter.Allow(p, identity)
+		if err != nil {
+			log.ErrorWrap(err, "checking the rate limit")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		setRateLimitHeaders(w, res)
 
-		if !limiter.Allow() {
+		if !res.Allowed {
 			http.Error(w, "Too many requests", http.StatusTooManyRequests)
 			log.WithFields(log.Fields{
-				"ip": identifier,
+				"identity": identity,
+				"policy":   p.Name,
 			}).Warn("Too many requests")
 			return
 		}
@@ -0,0 +1,202 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/ratelimit"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func newRateLimitedApp(c clock.Clock) App {
+	return App{
+		Clock:       c,
+		RateLimiter: ratelimit.NewLimiter(ratelimit.NewMemoryStore(), c),
+	}
+}
+
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestLimit(t *testing.T) {
+	c := clock.NewMock()
+	c.SetNow(time.Date(2019, time.November, 1, 10, 0, 30, 0, time.UTC))
+	app := newRateLimitedApp(c)
+	p := ratelimit.Policy{Name: "test", Limit: 2, Window: time.Minute}
+	h := app.limit(http.HandlerFunc(okHandler), p)
+
+	doReq := func(remoteAddr string) *httptest.ResponseRecorder {
+		r, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "constructing request"))
+		}
+		r.RemoteAddr = remoteAddr
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		w := doReq("1.2.3.4:5000")
+
+		assert.Equal(t, w.Code, http.StatusOK, fmt.Sprintf("status code mismatch for request %d", i))
+		assert.Equal(t, w.Header().Get("RateLimit-Limit"), "2", fmt.Sprintf("RateLimit-Limit mismatch for request %d", i))
+		assert.Equal(t, w.Header().Get("RateLimit-Remaining"), fmt.Sprintf("%d", 1-i), fmt.Sprintf("RateLimit-Remaining mismatch for request %d", i))
+		assert.Equal(t, w.Header().Get("RateLimit-Reset"), "30", fmt.Sprintf("RateLimit-Reset mismatch for request %d", i))
+	}
+
+	w := doReq("1.2.3.4:5000")
+	assert.Equal(t, w.Code, http.StatusTooManyRequests, "status code mismatch")
+	assert.Equal(t, w.Header().Get("Retry-After"), "30", "Retry-After mismatch")
+
+	// other clients are not affected
+	w = doReq("5.6.7.8:5000")
+	assert.Equal(t, w.Code, http.StatusOK, "other client status code mismatch")
+}
+
+func TestLimit_PerUser(t *testing.T) {
+	defer testutils.ClearData()
+
+	user := testutils.SetupUserData()
+	session := testutils.SetupSession(t, user)
+
+	c := clock.NewMock()
+	c.SetNow(time.Now())
+	app := newRateLimitedApp(c)
+	p := ratelimit.Policy{Name: "test", Limit: 1, Window: time.Minute, PerUser: true}
+	h := app.limit(http.HandlerFunc(okHandler), p)
+
+	doReq := func(remoteAddr string, authenticated bool) int {
+		r, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "constructing request"))
+		}
+		r.RemoteAddr = remoteAddr
+		if authenticated {
+			r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", session.Key))
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		return w.Code
+	}
+
+	// the same user is limited across IP addresses
+	assert.Equal(t, doReq("1.2.3.4:5000", true), http.StatusOK, "1st request status code mismatch")
+	assert.Equal(t, doReq("5.6.7.8:5000", true), http.StatusTooManyRequests, "2nd request status code mismatch")
+
+	// unauthenticated requests are limited by IP address
+	assert.Equal(t, doReq("1.2.3.4:5000", false), http.StatusOK, "unauthenticated request status code mismatch")
+}
+
+func TestLimit_TrustedProxies(t *testing.T) {
+	c := clock.NewMock()
+	app := newRateLimitedApp(c)
+	proxies, err := ratelimit.ParseTrustedProxies("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.TrustedProxies = proxies
+
+	p := ratelimit.Policy{Name: "test", Limit: 1, Window: time.Minute}
+	h := app.limit(http.HandlerFunc(okHandler), p)
+
+	doReq := func(forwardedFor string) int {
+		r, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "constructing request"))
+		}
+		r.RemoteAddr = "10.0.0.1:5000"
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		return w.Code
+	}
+
+	assert.Equal(t, doReq("1.2.3.4"), http.StatusOK, "1st client status code mismatch")
+	assert.Equal(t, doReq("5.6.7.8"), http.StatusOK, "2nd client status code mismatch")
+	assert.Equal(t, doReq("1.2.3.4"), http.StatusTooManyRequests, "1st client repeated status code mismatch")
+}
+
+func TestLimit_Sync(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	c := clock.NewMock()
+	c.SetNow(time.Now())
+	app := newRateLimitedApp(c)
+	server := httptest.NewServer(NewRouter(&app))
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+	session := testutils.SetupSession(t, user)
+	b1 := database.Book{UserID: user.ID, Label: "js"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+
+	doReq := func(method, url, data string) *http.Response {
+		req := testutils.MakeReq(server, method, url, data)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", session.Key))
+
+		return testutils.HTTPDo(t, req)
+	}
+
+	// a sync sends a request for every note changed on the client, which can be
+	// more than the budget of the routes that clients call frequently
+	count := userRateLimit.Limit + 1
+
+	for i := 0; i < count; i++ {
+		res := doReq("POST", "/v3/notes", fmt.Sprintf(`{"book_uuid": "%s", "content": "n%d content"}`, b1.UUID, i))
+		assert.StatusCodeEquals(t, res, http.StatusCreated, fmt.Sprintf("creating note %d", i))
+		res.Body.Close()
+	}
+
+	var notes []database.Note
+	testutils.MustExec(t, db.Where("user_id = ?", user.ID).Find(&notes), "finding notes")
+	assert.Equal(t, len(notes), count, "note count mismatch")
+
+	for i, note := range notes {
+		res := doReq("PATCH", fmt.Sprintf("/v3/notes/%s", note.UUID), `{"content": "updated content"}`)
+		assert.StatusCodeEquals(t, res, http.StatusOK, fmt.Sprintf("updating note %d", i))
+		res.Body.Close()
+	}
+	for i, note := range notes {
+		res := doReq("DELETE", fmt.Sprintf("/v3/notes/%s", note.UUID), "")
+		assert.StatusCodeEquals(t, res, http.StatusOK, fmt.Sprintf("deleting note %d", i))
+		res.Body.Close()
+	}
+
+	var deletedCount int
+	testutils.MustExec(t, db.Model(&database.Note{}).Where("user_id = ? AND deleted", user.ID).Count(&deletedCount), "counting deleted notes")
+	assert.Equal(t, deletedCount, count, "deleted note count mismatch")
+}
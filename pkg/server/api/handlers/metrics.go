@@ -0,0 +1,63 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"github.com/dnote/dnote/pkg/server/metrics"
+)
+
+var (
+	httpRequestsTotal = metrics.NewCounterVec(
+		"dnote_http_requests_total",
+		"Total number of HTTP requests to the API",
+		"method", "route", "code",
+	)
+	httpRequestDuration = metrics.NewHistogramVec(
+		"dnote_http_request_duration_seconds",
+		"Latency of HTTP requests to the API in seconds",
+		metrics.DefBuckets,
+		"method", "route",
+	)
+	httpRequestsInFlight = metrics.NewGaugeVec(
+		"dnote_http_requests_in_flight",
+		"Number of HTTP requests to the API being served",
+	)
+	syncFragmentsTotal = metrics.NewCounterVec(
+		"dnote_sync_fragments_total",
+		"Total number of sync fragments served",
+	)
+	syncFragmentItemsTotal = metrics.NewCounterVec(
+		"dnote_sync_fragment_items_total",
+		"Total number of items in the sync fragments served",
+		"type",
+	)
+	syncStateRequestsTotal = metrics.NewCounterVec(
+		"dnote_sync_state_requests_total",
+		"Total number of sync state requests served",
+	)
+)
+
+// recordSyncFragment records the metrics for a sync fragment served to a client
+func recordSyncFragment(f SyncFragment) {
+	syncFragmentsTotal.Inc()
+	syncFragmentItemsTotal.Add(float64(len(f.Notes)), "note")
+	syncFragmentItemsTotal.Add(float64(len(f.Books)), "book")
+	syncFragmentItemsTotal.Add(float64(len(f.ExpungedNotes)), "expunged_note")
+	syncFragmentItemsTotal.Add(float64(len(f.ExpungedBooks)), "expunged_book")
+}
@@ -95,7 +95,7 @@ func getNoteBaseQuery(noteUUID string, userID int, search string) *gorm.DB {
 		conn = db
 	}
 
-	conn = conn.Where("notes.uuid = ? AND notes.user_id = ?", noteUUID, userID)
+	conn = conn.Where(fmt.Sprintf("notes.uuid = ? AND (notes.user_id = ? OR notes.book_uuid IN (%s))", sharedBookUUIDsQuery), noteUUID, userID, userID)
 
 	return conn
 }
@@ -281,8 +281,8 @@ func getNotesBaseQuery(userID int, q getNotesQuery) *gorm.DB {
 	db := database.DBConn
 
 	conn := db.Debug().Where(
-		"notes.user_id = ? AND notes.deleted = ? AND notes.encrypted = ?",
-		userID, false, q.Encrypted,
+		fmt.Sprintf("(notes.user_id = ? OR notes.book_uuid IN (%s)) AND notes.deleted = ? AND notes.encrypted = ?", sharedBookUUIDsQuery),
+		userID, userID, false, q.Encrypted,
 	)
 
 	if q.Search != "" {
@@ -308,7 +308,7 @@ func orderGetNotes(conn *gorm.DB) *gorm.DB {
 }
 
 func preloadNote(conn *gorm.DB) *gorm.DB {
-	return conn.Preload("Book").Preload("User")
+	return conn.Preload("Book").Preload("User").Preload("Author").Preload("Editor")
 }
 
 // escapeSearchQuery escapes the query for full text search
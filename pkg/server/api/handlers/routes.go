@@ -22,14 +22,20 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/dnote/dnote/pkg/clock"
 	"github.com/dnote/dnote/pkg/server/api/helpers"
+	"github.com/dnote/dnote/pkg/server/api/operations"
+	"github.com/dnote/dnote/pkg/server/blob"
 	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/entitlement"
 	"github.com/dnote/dnote/pkg/server/log"
+	"github.com/dnote/dnote/pkg/server/mailer"
+	"github.com/dnote/dnote/pkg/server/oidc"
+	"github.com/dnote/dnote/pkg/server/ratelimit"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"github.com/stripe/stripe-go"
@@ -41,12 +47,17 @@ var ErrInvalidAuthHeader = errors.New("Invalid authorization header")
 // ErrForbidden is an error for forbidden requests
 var ErrForbidden = errors.New("forbidden")
 
+// ErrTOTPEnrollmentRequired is an error for requests made with a session that can
+// only be used to enable the two-factor authentication
+var ErrTOTPEnrollmentRequired = errors.New("Your organization requires two-factor authentication. Please enable it to continue")
+
 // Route represents a single route
 type Route struct {
 	Method      string
 	Pattern     string
 	HandlerFunc http.HandlerFunc
-	RateLimit   bool
+	// RateLimit is the rate limit policy for the route. If nil, the route is not rate limited.
+	RateLimit *ratelimit.Policy
 }
 
 type authHeader struct {
@@ -153,7 +164,7 @@ func getCredential(r *http.Request) (string, error) {
 	return ret, nil
 }
 
-func authWithSession(r *http.Request, p *authMiddlewareParams) (database.User, bool, error) {
+func (a *App) authWithSession(r *http.Request, p *authMiddlewareParams) (database.User, bool, error) {
 	db := database.DBConn
 	var user database.User
 
@@ -175,7 +186,7 @@ func authWithSession(r *http.Request, p *authMiddlewareParams) (database.User, b
 		return user, false, errors.Wrap(err, "finding session")
 	}
 
-	if session.ExpiresAt.Before(time.Now()) {
+	if session.ExpiresAt.Before(a.Clock.Now()) {
 		return user, false, nil
 	}
 
@@ -187,12 +198,27 @@ func authWithSession(r *http.Request, p *authMiddlewareParams) (database.User, b
 		return user, false, errors.Wrap(err, "finding user from token")
 	}
 
-	if p != nil && p.ProOnly {
-		if !user.Cloud {
+	if user.DisabledAt != nil {
+		return user, false, nil
+	}
+
+	if session.TOTPEnrollmentRequired && (p == nil || !p.AllowTOTPEnrollment) {
+		return user, false, ErrTOTPEnrollmentRequired
+	}
+
+	if p != nil && !p.entitled(user) {
+		return user, false, ErrForbidden
+	}
+	if p != nil && p.AdminOnly {
+		if !user.Admin {
 			return user, false, ErrForbidden
 		}
 	}
 
+	if err := operations.TouchSession(db, session, a.Clock.Now()); err != nil {
+		return user, false, errors.Wrap(err, "touching session")
+	}
+
 	return user, true, nil
 }
 
@@ -222,25 +248,45 @@ func authWithToken(r *http.Request, tokenType string, p *authMiddlewareParams) (
 		return user, token, false, errors.Wrap(err, "finding user")
 	}
 
-	if p != nil && p.ProOnly {
-		if !user.Cloud {
-			return user, token, false, ErrForbidden
-		}
+	if user.DisabledAt != nil {
+		return user, token, false, nil
+	}
+
+	if p != nil && !p.entitled(user) {
+		return user, token, false, ErrForbidden
 	}
 
 	return user, token, true, nil
 }
 
 type authMiddlewareParams struct {
-	ProOnly bool
+	// Feature is the feature to which the user must be entitled, if any
+	Feature entitlement.Feature
+	// Policy decides whether the user is entitled to the Feature
+	Policy    entitlement.Policy
+	AdminOnly bool
+	// AllowTOTPEnrollment allows the sessions that can only be used to enable
+	// the two-factor authentication
+	AllowTOTPEnrollment bool
 }
 
-func auth(next http.HandlerFunc, p *authMiddlewareParams) http.HandlerFunc {
+// entitled checks if the user is entitled to the feature required by the params
+func (p *authMiddlewareParams) entitled(user database.User) bool {
+	if p.Feature == "" {
+		return true
+	}
+
+	return p.Policy.Entitled(user, p.Feature)
+}
+
+func (a *App) auth(next http.HandlerFunc, p *authMiddlewareParams) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		user, ok, err := authWithSession(r, p)
+		user, ok, err := a.authWithSession(r, p)
 		if !ok || err != nil {
 			if err == ErrForbidden {
 				http.Error(w, "forbidden", http.StatusForbidden)
+			} else if err == ErrTOTPEnrollmentRequired {
+				http.Error(w, err.Error(), http.StatusForbidden)
 			} else {
 				respondUnauthorized(w)
 			}
@@ -252,7 +298,7 @@ func auth(next http.HandlerFunc, p *authMiddlewareParams) http.HandlerFunc {
 	})
 }
 
-func tokenAuth(next http.HandlerFunc, tokenType string, p *authMiddlewareParams) http.HandlerFunc {
+func (a *App) tokenAuth(next http.HandlerFunc, tokenType string, p *authMiddlewareParams) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user, token, ok, err := authWithToken(r, tokenType, p)
 		if err != nil {
@@ -271,7 +317,7 @@ func tokenAuth(next http.HandlerFunc, tokenType string, p *authMiddlewareParams)
 			ctx = context.WithValue(ctx, helpers.KeyToken, token)
 		} else {
 			// If token-based auth fails, fall back to session-based auth
-			user, ok, err = authWithSession(r, p)
+			user, ok, err = a.authWithSession(r, p)
 			if err != nil {
 				// log the error and continue
 				log.ErrorWrap(err, "authenticating with session")
@@ -280,6 +326,8 @@ func tokenAuth(next http.HandlerFunc, tokenType string, p *authMiddlewareParams)
 			if !ok {
 				if err == ErrForbidden {
 					respondForbidden(w)
+				} else if err == ErrTOTPEnrollmentRequired {
+					http.Error(w, err.Error(), http.StatusForbidden)
 				} else {
 					respondUnauthorized(w)
 				}
@@ -299,18 +347,48 @@ func cors(next http.HandlerFunc) http.HandlerFunc {
 		// Allow browser extensions
 		if strings.HasPrefix(origin, "moz-extension://") || strings.HasPrefix(origin, "chrome-extension://") {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", totpRequiredHeader)
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
-// logResponseWriter wraps http.ResponseWriter to expose HTTP status code for logging.
+// minCLIVersion is the earliest version of the CLI that can sync nested books. The
+// older versions require the names of all books to be unique, and fail to sync the
+// books with the same name in different parent books.
+var minCLIVersion = semver{Major: 0, Minor: 11, Patch: 0}
+
+// requireCLIVersion is a middleware that rejects the requests from the versions of
+// the CLI older than minCLIVersion. The development builds, whose version is not a
+// semantic version, and the other clients are allowed.
+func requireCLIVersion(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := r.Header.Get("CLI-Version")
+		if version == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		v, err := parseSemver(version)
+		if err == nil && v.less(minCLIVersion) {
+			msg := fmt.Sprintf("This version of the CLI is not supported. Please upgrade the CLI to %s or later.", minCLIVersion)
+			http.Error(w, msg, http.StatusGone)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logResponseWriter wraps http.ResponseWriter to expose HTTP status code and the size of
+// the response for logging.
 // The optional interfaces of http.ResponseWriter are lost because of the wrapping, and
 // such interfaces should be implemented if needed. (i.e. http.Pusher, http.Flusher, etc.)
 type logResponseWriter struct {
 	http.ResponseWriter
 	statusCode int
+	bytes      int
 }
 
 func (w *logResponseWriter) WriteHeader(code int) {
@@ -318,32 +396,113 @@ func (w *logResponseWriter) WriteHeader(code int) {
 	w.ResponseWriter.WriteHeader(code)
 }
 
-func logging(inner http.Handler) http.HandlerFunc {
+func (w *logResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+
+	return n, err
+}
+
+// getRouteTemplate returns the pattern of the route that matched the request, so that
+// the requests to the same route are grouped together regardless of the path parameters
+func getRouteTemplate(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+
+	tpl, err := route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+
+	return tpl
+}
+
+// logging is a middleware that writes an access log and records the metrics for the requests
+func (a *App) logging(inner http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
+		route := getRouteTemplate(r)
+
+		httpRequestsInFlight.Add(1)
+		defer httpRequestsInFlight.Add(-1)
 
-		lw := logResponseWriter{w, http.StatusOK}
+		lw := logResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 		inner.ServeHTTP(&lw, r)
 
+		duration := time.Since(start)
+		httpRequestsTotal.Inc(r.Method, route, strconv.Itoa(lw.statusCode))
+		httpRequestDuration.Observe(duration.Seconds(), r.Method, route)
+
 		log.WithFields(log.Fields{
+			"requestID":  w.Header().Get(requestIDHeader),
 			"remoteAddr": r.RemoteAddr,
+			"ip":         a.lookupIP(r),
 			"uri":        r.RequestURI,
+			"route":      route,
 			"statusCode": lw.statusCode,
+			"bytes":      lw.bytes,
 			"method":     r.Method,
-			"duration":   fmt.Sprintf("%dms", time.Since(start)/1000000),
+			"duration":   fmt.Sprintf("%dms", duration/time.Millisecond),
 			"userAgent":  r.Header.Get("User-Agent"),
 		}).Info("incoming request")
 	}
 }
 
-func applyMiddleware(h http.HandlerFunc, rateLimit bool) http.Handler {
-	ret := h
-	ret = logging(ret)
+// requestIDHeader is the HTTP header that carries the ID of a request
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the maximum length of a request ID given by a client
+const maxRequestIDLength = 128
+
+// isValidRequestID checks if the request ID given by a client is safe to log and echo
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '-' && c != '_' && c != '.' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// requestID is a middleware that assigns an ID to the request so that its logs can be
+// correlated. The ID given by the client or a proxy in X-Request-ID is used if valid.
+// The ID is echoed in the response and stored in the request context.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !isValidRequestID(id) {
+			var err error
+
+			id, err = helpers.GenUUID()
+			if err != nil {
+				log.ErrorWrap(err, "generating request id")
+			}
+		}
+
+		w.Header().Set(requestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), helpers.KeyRequestID, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (a *App) applyMiddleware(h http.HandlerFunc, rateLimit *ratelimit.Policy) http.Handler {
+	var ret http.Handler = h
 
-	if rateLimit && os.Getenv("GO_ENV") != "TEST" {
-		ret = limit(ret)
+	if rateLimit != nil && a.RateLimiter != nil {
+		ret = a.limit(ret, *rateLimit)
 	}
 
+	// log the requests that are rejected by the rate limiter as well
+	ret = a.logging(ret)
+
 	return ret
 }
 
@@ -351,11 +510,58 @@ func applyMiddleware(h http.HandlerFunc, rateLimit bool) http.Handler {
 type App struct {
 	Clock            clock.Clock
 	StripeAPIBackend stripe.Backend
+	// SelfHosted disables billing and entitles every user to every feature,
+	// unless Entitlements is set
+	SelfHosted bool
+	// Entitlements decides the features to which users have access
+	Entitlements entitlement.Policy
+	// RateLimiter limits the rate of requests. If nil, requests are not rate limited.
+	RateLimiter *ratelimit.Limiter
+	// TrustedProxies are the proxies whose forwarding headers are trusted
+	// when looking up the IP addresses of clients
+	TrustedProxies ratelimit.TrustedProxies
+	// Mailer sends emails. If nil, emails are not sent.
+	Mailer *mailer.Mailer
+	// StripeSecretKey is the secret key of the Stripe API
+	StripeSecretKey string
+	// StripeWebhookSecret is the secret used to verify the Stripe webhook events
+	StripeWebhookSecret string
+	// TLS indicates that the server serves HTTPS, in which case the session
+	// cookie is marked as secure
+	TLS bool
+	// CountPendingMigrations counts the database migrations that have not been
+	// applied. If nil, the migrations in the database package are counted.
+	CountPendingMigrations func() (int, error)
+	// Blobs stores the content of the note attachments. If nil, attachments
+	// are disabled.
+	Blobs blob.Store
+	// AttachmentMaxSize is the maximum size in bytes of an attachment
+	AttachmentMaxSize int64
+	// AttachmentQuota is the maximum total size in bytes of the attachments of
+	// a user. If 0, there is no limit.
+	AttachmentQuota int64
+	// OIDC is the OpenID Connect provider with which users sign in with single
+	// sign-on. If nil, single sign-on is disabled.
+	OIDC *oidc.Provider
+	// SSOAllowSignup lets the people who do not have an account sign up with
+	// single sign-on
+	SSOAllowSignup bool
 }
 
 // init sets up the application based on the configuration
 func (a *App) init() {
-	stripe.Key = os.Getenv("StripeSecretKey")
+	if a.Entitlements == nil {
+		a.Entitlements = entitlement.New(a.SelfHosted)
+	}
+	if a.Mailer == nil {
+		a.Mailer = mailer.New(mailer.Config{})
+	}
+
+	if a.SelfHosted {
+		return
+	}
+
+	stripe.Key = a.StripeSecretKey
 
 	if a.StripeAPIBackend != nil {
 		stripe.SetBackend(stripe.APIBackend, a.StripeAPIBackend)
@@ -366,66 +572,121 @@ func (a *App) init() {
 func NewRouter(app *App) *mux.Router {
 	app.init()
 
-	proOnly := authMiddlewareParams{ProOnly: true}
+	proOnly := authMiddlewareParams{Feature: entitlement.FeaturePro, Policy: app.Entitlements}
+	adminOnly := authMiddlewareParams{AdminOnly: true}
+	totpEnrollment := authMiddlewareParams{AllowTOTPEnrollment: true}
 
 	var routes = []Route{
 		// internal
-		{"GET", "/health", app.checkHealth, false},
-		{"GET", "/me", auth(app.getMe, nil), true},
-		{"POST", "/verification-token", auth(app.createVerificationToken, nil), true},
-		{"PATCH", "/verify-email", app.verifyEmail, true},
-		{"POST", "/reset-token", app.createResetToken, true},
-		{"PATCH", "/reset-password", app.resetPassword, true},
-		{"PATCH", "/account/profile", auth(app.updateProfile, nil), true},
-		{"PATCH", "/account/password", auth(app.updatePassword, nil), true},
-		{"GET", "/account/email-preference", tokenAuth(app.getEmailPreference, database.TokenTypeEmailPreference, nil), true},
-		{"PATCH", "/account/email-preference", tokenAuth(app.updateEmailPreference, database.TokenTypeEmailPreference, nil), true},
-		{"POST", "/subscriptions", auth(app.createSub, nil), true},
-		{"PATCH", "/subscriptions", auth(app.updateSub, nil), true},
-		{"POST", "/webhooks/stripe", app.stripeWebhook, true},
-		{"GET", "/subscriptions", auth(app.getSub, nil), true},
-		{"GET", "/stripe_source", auth(app.getStripeSource, nil), true},
-		{"PATCH", "/stripe_source", auth(app.updateStripeSource, nil), true},
-		{"GET", "/notes", auth(app.getNotes, &proOnly), false},
-		{"GET", "/notes/{noteUUID}", auth(app.getNote, &proOnly), true},
-		{"GET", "/calendar", auth(app.getCalendar, &proOnly), true},
-		{"GET", "/repetition_rules", auth(app.getRepetitionRules, &proOnly), true},
-		{"GET", "/repetition_rules/{repetitionRuleUUID}", tokenAuth(app.getRepetitionRule, database.TokenTypeRepetition, &proOnly), true},
-		{"POST", "/repetition_rules", auth(app.createRepetitionRule, &proOnly), true},
-		{"PATCH", "/repetition_rules/{repetitionRuleUUID}", tokenAuth(app.updateRepetitionRule, database.TokenTypeRepetition, &proOnly), true},
-		{"DELETE", "/repetition_rules/{repetitionRuleUUID}", auth(app.deleteRepetitionRule, &proOnly), true},
+		{"GET", "/health", app.checkHealth, nil},
+		{"GET", "/healthz", app.checkLiveness, nil},
+		{"GET", "/readyz", app.checkReadiness, nil},
+		{"GET", "/me", app.auth(app.getMe, nil), defaultRateLimit},
+		{"POST", "/verification-token", app.auth(app.createVerificationToken, nil), authRateLimit},
+		{"PATCH", "/verify-email", app.verifyEmail, authRateLimit},
+		{"POST", "/reset-token", app.createResetToken, authRateLimit},
+		{"PATCH", "/reset-password", app.resetPassword, authRateLimit},
+		{"PATCH", "/account/profile", app.auth(app.updateProfile, nil), defaultRateLimit},
+		{"PATCH", "/account/password", app.auth(app.updatePassword, nil), authRateLimit},
+		{"GET", "/account/email-preference", app.tokenAuth(app.getEmailPreference, database.TokenTypeEmailPreference, nil), defaultRateLimit},
+		{"PATCH", "/account/email-preference", app.tokenAuth(app.updateEmailPreference, database.TokenTypeEmailPreference, nil), defaultRateLimit},
+		{"GET", "/notes", app.auth(app.getNotes, &proOnly), nil},
+		{"GET", "/notes/{noteUUID}", app.auth(app.getNote, &proOnly), defaultRateLimit},
+		{"GET", "/calendar", app.auth(app.getCalendar, &proOnly), defaultRateLimit},
+		{"GET", "/repetition_rules", app.auth(app.getRepetitionRules, &proOnly), defaultRateLimit},
+		{"GET", "/repetition_rules/{repetitionRuleUUID}", app.tokenAuth(app.getRepetitionRule, database.TokenTypeRepetition, &proOnly), defaultRateLimit},
+		{"POST", "/repetition_rules", app.auth(app.createRepetitionRule, &proOnly), defaultRateLimit},
+		{"PATCH", "/repetition_rules/{repetitionRuleUUID}", app.tokenAuth(app.updateRepetitionRule, database.TokenTypeRepetition, &proOnly), defaultRateLimit},
+		{"DELETE", "/repetition_rules/{repetitionRuleUUID}", app.auth(app.deleteRepetitionRule, &proOnly), defaultRateLimit},
+		{"GET", "/public/notes/{noteUUID}", app.renderPublicNote, defaultRateLimit},
 
 		// migration of classic users
-		{"GET", "/classic/presignin", cors(app.classicPresignin), true},
-		{"POST", "/classic/signin", cors(app.classicSignin), true},
-		{"PATCH", "/classic/migrate", auth(app.classicMigrate, &proOnly), true},
-		{"GET", "/classic/notes", auth(app.classicGetNotes, nil), true},
-		{"PATCH", "/classic/set-password", auth(app.classicSetPassword, nil), true},
+		{"GET", "/classic/presignin", cors(app.classicPresignin), authRateLimit},
+		{"POST", "/classic/signin", cors(app.classicSignin), authRateLimit},
+		{"PATCH", "/classic/migrate", app.auth(app.classicMigrate, &proOnly), defaultRateLimit},
+		{"GET", "/classic/notes", app.auth(app.classicGetNotes, nil), defaultRateLimit},
+		{"PATCH", "/classic/set-password", app.auth(app.classicSetPassword, nil), defaultRateLimit},
 
 		// v3
-		{"GET", "/v3/sync/fragment", cors(auth(app.GetSyncFragment, &proOnly)), true},
-		{"GET", "/v3/sync/state", cors(auth(app.GetSyncState, &proOnly)), true},
-		{"OPTIONS", "/v3/books", cors(app.BooksOptions), true},
-		{"GET", "/v3/books", cors(auth(app.GetBooks, &proOnly)), true},
-		{"GET", "/v3/books/{bookUUID}", cors(auth(app.GetBook, &proOnly)), true},
-		{"POST", "/v3/books", cors(auth(app.CreateBook, &proOnly)), true},
-		{"PATCH", "/v3/books/{bookUUID}", cors(auth(app.UpdateBook, &proOnly)), false},
-		{"DELETE", "/v3/books/{bookUUID}", cors(auth(app.DeleteBook, &proOnly)), false},
-		{"GET", "/v3/demo/books", app.GetDemoBooks, true},
-		{"OPTIONS", "/v3/notes", cors(app.NotesOptions), true},
-		{"POST", "/v3/notes", cors(auth(app.CreateNote, &proOnly)), true},
-		{"PATCH", "/v3/notes/{noteUUID}", auth(app.UpdateNote, &proOnly), false},
-		{"DELETE", "/v3/notes/{noteUUID}", auth(app.DeleteNote, &proOnly), false},
-		{"POST", "/v3/signin", cors(app.signin), true},
-		{"OPTIONS", "/v3/signout", cors(app.signoutOptions), true},
-		{"POST", "/v3/signout", cors(app.signout), true},
-		{"POST", "/v3/register", app.register, true},
+		{"GET", "/v3/sync/fragment", cors(requireCLIVersion(app.auth(app.GetSyncFragment, &proOnly))), userRateLimit},
+		{"GET", "/v3/sync/state", cors(requireCLIVersion(app.auth(app.GetSyncState, &proOnly))), userRateLimit},
+		{"OPTIONS", "/v3/books", cors(app.BooksOptions), userRateLimit},
+		{"GET", "/v3/books", cors(app.auth(app.GetBooks, &proOnly)), userRateLimit},
+		{"GET", "/v3/books/{bookUUID}", cors(app.auth(app.GetBook, &proOnly)), userRateLimit},
+		{"POST", "/v3/books", cors(app.auth(app.CreateBook, &proOnly)), syncRateLimit},
+		{"PATCH", "/v3/books/{bookUUID}", cors(app.auth(app.UpdateBook, &proOnly)), nil},
+		{"DELETE", "/v3/books/{bookUUID}", cors(app.auth(app.DeleteBook, &proOnly)), nil},
+		{"GET", "/v3/books/{bookUUID}/members", app.auth(app.GetBookMembers, &proOnly), userRateLimit},
+		{"PATCH", "/v3/books/{bookUUID}/members/{userUUID}", app.auth(app.UpdateBookMember, &proOnly), userRateLimit},
+		{"DELETE", "/v3/books/{bookUUID}/members/{userUUID}", app.auth(app.RemoveBookMember, &proOnly), userRateLimit},
+		{"GET", "/v3/books/{bookUUID}/invitations", app.auth(app.GetBookInvitations, &proOnly), userRateLimit},
+		{"POST", "/v3/books/{bookUUID}/invitations", app.auth(app.CreateBookInvitation, &proOnly), userRateLimit},
+		{"DELETE", "/v3/books/{bookUUID}/invitations/{invitationUUID}", app.auth(app.DeleteBookInvitation, &proOnly), userRateLimit},
+		{"POST", "/v3/book-invitations/accept", app.auth(app.AcceptBookInvitation, &proOnly), defaultRateLimit},
+		{"GET", "/v3/demo/books", app.GetDemoBooks, defaultRateLimit},
+		{"OPTIONS", "/v3/notes", cors(app.NotesOptions), userRateLimit},
+		{"POST", "/v3/notes", cors(app.auth(app.CreateNote, &proOnly)), syncRateLimit},
+		{"PATCH", "/v3/notes/{noteUUID}", app.auth(app.UpdateNote, &proOnly), nil},
+		{"DELETE", "/v3/notes/{noteUUID}", app.auth(app.DeleteNote, &proOnly), nil},
+		{"POST", "/v3/notes/{noteUUID}/share", app.auth(app.ShareNote, &proOnly), userRateLimit},
+		{"DELETE", "/v3/notes/{noteUUID}/share", app.auth(app.UnshareNote, &proOnly), userRateLimit},
+		{"GET", "/v3/notes/{noteUUID}/backlinks", app.auth(app.GetNoteBacklinks, &proOnly), userRateLimit},
+		{"POST", "/v3/notes/{noteUUID}/attachments", app.auth(app.CreateAttachment, &proOnly), userRateLimit},
+		{"GET", "/v3/attachments/{attachmentUUID}", app.auth(app.GetAttachment, &proOnly), userRateLimit},
+		{"DELETE", "/v3/attachments/{attachmentUUID}", app.auth(app.DeleteAttachment, &proOnly), userRateLimit},
+		{"GET", "/v3/public/notes/{noteUUID}", app.GetPublicNote, defaultRateLimit},
+		{"POST", "/v3/signin", cors(app.signin), authRateLimit},
+		{"GET", "/v3/sso/login", app.ssoLogin, authRateLimit},
+		{"GET", "/v3/sso/callback", app.ssoCallback, authRateLimit},
+		{"POST", "/v3/sso/device", cors(app.startSSODevice), authRateLimit},
+		{"POST", "/v3/sso/device/token", cors(app.pollSSODevice), defaultRateLimit},
+		{"GET", "/v3/session", app.auth(app.getSession, nil), defaultRateLimit},
+		{"POST", "/v3/session/refresh", app.auth(app.refreshSession, nil), defaultRateLimit},
+		{"GET", "/v3/sessions", app.auth(app.getSessions, nil), defaultRateLimit},
+		{"DELETE", "/v3/sessions", app.auth(app.deleteOtherSessions, nil), defaultRateLimit},
+		{"DELETE", "/v3/sessions/{sessionUUID}", app.auth(app.deleteSession, nil), defaultRateLimit},
+		{"POST", "/v3/account/totp", app.auth(app.beginTOTPEnrollment, &totpEnrollment), defaultRateLimit},
+		{"POST", "/v3/account/totp/verify", app.auth(app.enableTOTP, &totpEnrollment), authRateLimit},
+		{"DELETE", "/v3/account/totp", app.auth(app.disableTOTP, nil), authRateLimit},
+		{"POST", "/v3/account/totp/recovery-codes", app.auth(app.regenerateRecoveryCodes, nil), authRateLimit},
+		{"GET", "/v3/account/audit-log", app.auth(app.getAuditLog, nil), defaultRateLimit},
+		{"OPTIONS", "/v3/signout", cors(app.signoutOptions), defaultRateLimit},
+		{"POST", "/v3/signout", cors(app.signout), defaultRateLimit},
+		{"POST", "/v3/register", app.register, authRateLimit},
+		{"GET", "/v3/webhooks", app.auth(app.GetWebhooks, &proOnly), defaultRateLimit},
+		{"POST", "/v3/webhooks", app.auth(app.CreateWebhook, &proOnly), defaultRateLimit},
+		{"GET", "/v3/webhooks/{webhookUUID}", app.auth(app.GetWebhook, &proOnly), defaultRateLimit},
+		{"PATCH", "/v3/webhooks/{webhookUUID}", app.auth(app.UpdateWebhook, &proOnly), defaultRateLimit},
+		{"DELETE", "/v3/webhooks/{webhookUUID}", app.auth(app.DeleteWebhook, &proOnly), defaultRateLimit},
+		{"GET", "/v3/webhooks/{webhookUUID}/deliveries", app.auth(app.GetWebhookDeliveries, &proOnly), defaultRateLimit},
+		{"GET", "/v3/account/export", app.auth(app.ExportArchive, &proOnly), defaultRateLimit},
+		{"POST", "/v3/account/import", app.auth(app.ImportArchive, &proOnly), authRateLimit},
+		{"GET", "/v3/admin/users", app.auth(app.GetOrganizationUsers, &adminOnly), defaultRateLimit},
+		{"PATCH", "/v3/admin/users/{userUUID}", app.auth(app.UpdateOrganizationUser, &adminOnly), defaultRateLimit},
+		{"DELETE", "/v3/admin/users/{userUUID}", app.auth(app.DeleteOrganizationUser, &adminOnly), defaultRateLimit},
+		{"GET", "/v3/admin/organization", app.auth(app.GetOrganization, &adminOnly), defaultRateLimit},
+		{"PATCH", "/v3/admin/organization", app.auth(app.UpdateOrganization, &adminOnly), defaultRateLimit},
+		{"GET", "/v3/admin/invitations", app.auth(app.GetOrganizationInvitations, &adminOnly), defaultRateLimit},
+		{"POST", "/v3/admin/invitations", app.auth(app.CreateOrganizationInvitation, &adminOnly), defaultRateLimit},
+	}
+
+	// billing is not available on self-hosted instances
+	if !app.SelfHosted {
+		routes = append(routes, []Route{
+			{"POST", "/subscriptions", app.auth(app.createSub, nil), defaultRateLimit},
+			{"PATCH", "/subscriptions", app.auth(app.updateSub, nil), defaultRateLimit},
+			{"POST", "/webhooks/stripe", app.stripeWebhook, defaultRateLimit},
+			{"GET", "/subscriptions", app.auth(app.getSub, nil), defaultRateLimit},
+			{"GET", "/stripe_source", app.auth(app.getStripeSource, nil), defaultRateLimit},
+			{"PATCH", "/stripe_source", app.auth(app.updateStripeSource, nil), defaultRateLimit},
+		}...)
 	}
 
 	router := mux.NewRouter().StrictSlash(true)
+	router.Use(requestID)
 
-	router.PathPrefix("/v1").Handler(applyMiddleware(app.notSupported, true))
-	router.PathPrefix("/v2").Handler(applyMiddleware(app.notSupported, true))
+	router.PathPrefix("/v1").Handler(app.applyMiddleware(app.notSupported, defaultRateLimit))
+	router.PathPrefix("/v2").Handler(app.applyMiddleware(app.notSupported, defaultRateLimit))
 
 	for _, route := range routes {
 		handler := route.HandlerFunc
@@ -433,7 +694,7 @@ func NewRouter(app *App) *mux.Router {
 		router.
 			Methods(route.Method).
 			Path(route.Pattern).
-			Handler(applyMiddleware(handler, route.RateLimit))
+			Handler(app.applyMiddleware(handler, route.RateLimit))
 	}
 
 	return router
@@ -27,7 +27,9 @@ import (
 
 	"github.com/dnote/dnote/pkg/assert"
 	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/api/helpers"
 	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/entitlement"
 	"github.com/dnote/dnote/pkg/server/testutils"
 	"github.com/pkg/errors"
 )
@@ -190,24 +192,26 @@ func TestAuthMiddleware(t *testing.T) {
 	// set up
 	db := database.DBConn
 
+	c := clock.NewMock()
+
 	user := testutils.SetupUserData()
 	session := database.Session{
 		Key:       "A9xgggqzTHETy++GDi1NpDNe0iyqosPm9bitdeNGkJU=",
 		UserID:    user.ID,
-		ExpiresAt: time.Now().Add(time.Hour * 24),
+		ExpiresAt: c.Now().Add(time.Hour * 24),
 	}
 	testutils.MustExec(t, db.Save(&session), "preparing session")
 	session2 := database.Session{
 		Key:       "Vvgm3eBXfXGEFWERI7faiRJ3DAzJw+7DdT9J1LEyNfI=",
 		UserID:    user.ID,
-		ExpiresAt: time.Now().Add(-time.Hour * 24),
+		ExpiresAt: c.Now().Add(-time.Hour * 24),
 	}
 	testutils.MustExec(t, db.Save(&session2), "preparing session")
 
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}
-	server := httptest.NewServer(auth(handler, nil))
+	server := httptest.NewServer((&App{Clock: c}).auth(handler, nil))
 	defer server.Close()
 
 	t.Run("with header", func(t *testing.T) {
@@ -299,6 +303,43 @@ func TestAuthMiddleware(t *testing.T) {
 	})
 }
 
+func TestAuthMiddleware_TouchSession(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	lastUsedAt := time.Now().Add(-time.Hour)
+	session := database.Session{
+		Key:        "A9xgggqzTHETy++GDi1NpDNe0iyqosPm9bitdeNGkJU=",
+		UserID:     user.ID,
+		LastUsedAt: lastUsedAt,
+		ExpiresAt:  time.Now().Add(time.Hour * 24),
+	}
+	testutils.MustExec(t, db.Save(&session), "preparing session")
+
+	now := lastUsedAt.Add(30 * time.Minute).UTC().Truncate(time.Second)
+	c := clock.NewMock()
+	c.SetNow(now)
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	server := httptest.NewServer((&App{Clock: c}).auth(handler, nil))
+	defer server.Close()
+
+	// execute
+	req := testutils.MakeReq(server, "GET", "/", "")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", session.Key))
+	res := testutils.HTTPDo(t, req)
+
+	// test
+	assert.StatusCodeEquals(t, res, http.StatusOK, "status code mismatch")
+
+	var got database.Session
+	testutils.MustExec(t, db.Where("id = ?", session.ID).First(&got), "finding session")
+	assert.Equal(t, got.LastUsedAt.Equal(now), true, "last_used_at should be set by the clock")
+}
+
 func TestAuthMiddleware_ProOnly(t *testing.T) {
 	defer testutils.ClearData()
 
@@ -317,8 +358,9 @@ func TestAuthMiddleware_ProOnly(t *testing.T) {
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}
-	server := httptest.NewServer(auth(handler, &authMiddlewareParams{
-		ProOnly: true,
+	server := httptest.NewServer((&App{Clock: clock.NewMock()}).auth(handler, &authMiddlewareParams{
+		Feature: entitlement.FeaturePro,
+		Policy:  entitlement.NewSubscriptionPolicy(),
 	}))
 	defer server.Close()
 
@@ -412,7 +454,7 @@ func TestTokenAuthMiddleWare(t *testing.T) {
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}
-	server := httptest.NewServer(tokenAuth(handler, database.TokenTypeEmailPreference, nil))
+	server := httptest.NewServer((&App{Clock: clock.NewMock()}).tokenAuth(handler, database.TokenTypeEmailPreference, nil))
 	defer server.Close()
 
 	t.Run("with token", func(t *testing.T) {
@@ -520,6 +562,75 @@ func TestTokenAuthMiddleWare(t *testing.T) {
 	})
 }
 
+func TestAuthMiddleware_SelfHosted(t *testing.T) {
+	defer testutils.ClearData()
+
+	// set up
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&user).Update("cloud", false), "preparing user")
+	session := database.Session{
+		Key:       "A9xgggqzTHETy++GDi1NpDNe0iyqosPm9bitdeNGkJU=",
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(time.Hour * 24),
+	}
+	testutils.MustExec(t, db.Save(&session), "preparing session")
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	server := httptest.NewServer((&App{Clock: clock.NewMock()}).auth(handler, &authMiddlewareParams{
+		Feature: entitlement.FeaturePro,
+		Policy:  entitlement.NewSelfHostedPolicy(),
+	}))
+	defer server.Close()
+
+	req := testutils.MakeReq(server, "GET", "/", "")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", session.Key))
+
+	// execute
+	res := testutils.HTTPDo(t, req)
+
+	// test
+	assert.Equal(t, res.StatusCode, http.StatusOK, "status code mismatch")
+}
+
+func TestNewRouter_SelfHosted(t *testing.T) {
+	testCases := []struct {
+		selfHosted     bool
+		expectedStatus int
+	}{
+		{
+			selfHosted:     false,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			selfHosted:     true,
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("self hosted %t", tc.selfHosted), func(t *testing.T) {
+			// set up
+			server := httptest.NewServer(NewRouter(&App{
+				Clock:      clock.NewMock(),
+				SelfHosted: tc.selfHosted,
+			}))
+			defer server.Close()
+
+			req := testutils.MakeReq(server, "GET", "/subscriptions", "")
+
+			// execute
+			res := testutils.HTTPDo(t, req)
+
+			// test
+			assert.Equal(t, res.StatusCode, tc.expectedStatus, "status code mismatch")
+		})
+	}
+}
+
 func TestTokenAuthMiddleWare_ProOnly(t *testing.T) {
 	defer testutils.ClearData()
 
@@ -544,8 +655,9 @@ func TestTokenAuthMiddleWare_ProOnly(t *testing.T) {
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}
-	server := httptest.NewServer(tokenAuth(handler, database.TokenTypeEmailPreference, &authMiddlewareParams{
-		ProOnly: true,
+	server := httptest.NewServer((&App{Clock: clock.NewMock()}).tokenAuth(handler, database.TokenTypeEmailPreference, &authMiddlewareParams{
+		Feature: entitlement.FeaturePro,
+		Policy:  entitlement.NewSubscriptionPolicy(),
 	}))
 	defer server.Close()
 
@@ -697,3 +809,91 @@ func TestNotSupportedVersions(t *testing.T) {
 		})
 	}
 }
+
+func TestRequireCLIVersion(t *testing.T) {
+	testCases := []struct {
+		version  string
+		expected int
+	}{
+		{"", http.StatusOK},
+		{"master", http.StatusOK},
+		{"0.4.8", http.StatusGone},
+		{"0.10.0", http.StatusGone},
+		{"0.11.0", http.StatusOK},
+		{"0.11.2", http.StatusOK},
+		{"1.0.0", http.StatusOK},
+	}
+
+	h := requireCLIVersion(okHandler)
+
+	for _, tc := range testCases {
+		t.Run(tc.version, func(t *testing.T) {
+			r, err := http.NewRequest("GET", "/v3/sync/state", nil)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "constructing request"))
+			}
+			if tc.version != "" {
+				r.Header.Set("CLI-Version", tc.version)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			assert.Equal(t, w.Code, tc.expected, "status code mismatch")
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	// setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	t.Run("generated", func(t *testing.T) {
+		req := testutils.MakeReq(server, "GET", "/health", "")
+		res := testutils.HTTPDo(t, req)
+
+		id := res.Header.Get("X-Request-ID")
+		assert.Equal(t, helpers.ValidateUUID(id), true, "request id is not a uuid")
+	})
+
+	t.Run("given by client", func(t *testing.T) {
+		req := testutils.MakeReq(server, "GET", "/health", "")
+		req.Header.Set("X-Request-ID", "abc-123")
+		res := testutils.HTTPDo(t, req)
+
+		assert.Equal(t, res.Header.Get("X-Request-ID"), "abc-123", "request id mismatch")
+	})
+
+	t.Run("invalid id given by client", func(t *testing.T) {
+		req := testutils.MakeReq(server, "GET", "/health", "")
+		req.Header.Set("X-Request-ID", "foo\"bar")
+		res := testutils.HTTPDo(t, req)
+
+		id := res.Header.Get("X-Request-ID")
+		assert.Equal(t, helpers.ValidateUUID(id), true, "request id is not a uuid")
+	})
+}
+
+func TestLogging_Metrics(t *testing.T) {
+	// setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	route := "/v3/notes/{noteUUID}"
+	before := httpRequestsTotal.Value("DELETE", route, "401")
+	beforeCount := httpRequestDuration.Count("DELETE", route)
+
+	// execute
+	req := testutils.MakeReq(server, "DELETE", "/v3/notes/8bdc0a4b-e3c6-4a2a-8c64-7db5bd8fe1a0", "")
+	res := testutils.HTTPDo(t, req)
+
+	// test
+	assert.StatusCodeEquals(t, res, http.StatusUnauthorized, "")
+	assert.Equal(t, httpRequestsTotal.Value("DELETE", route, "401"), before+1, "request count mismatch")
+	assert.Equal(t, httpRequestDuration.Count("DELETE", route), beforeCount+1, "duration count mismatch")
+}
@@ -19,6 +19,7 @@
 package handlers
 
 import (
+	"fmt"
 	"regexp"
 	"strconv"
 
@@ -60,3 +61,19 @@ func parseSemver(version string) (semver, error) {
 
 	return ret, nil
 }
+
+// less checks if the version is lower than the given version
+func (v semver) less(o semver) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor < o.Minor
+	}
+
+	return v.Patch < o.Patch
+}
+
+func (v semver) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
@@ -23,7 +23,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/dnote/dnote/pkg/server/api/helpers"
@@ -516,8 +515,7 @@ func (a *App) stripeWebhook(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	webhookSecret := os.Getenv("StripeWebhookSecret")
-	event, err := webhook.ConstructEvent(body, req.Header.Get("Stripe-Signature"), webhookSecret)
+	event, err := webhook.ConstructEvent(body, req.Header.Get("Stripe-Signature"), a.StripeWebhookSecret)
 	if err != nil {
 		handleError(w, "verifying stripe webhook signature", err, http.StatusBadRequest)
 		return
@@ -24,6 +24,7 @@ import (
 	"time"
 
 	"github.com/dnote/dnote/pkg/server/api/helpers"
+	"github.com/dnote/dnote/pkg/server/api/operations"
 	"github.com/dnote/dnote/pkg/server/api/presenters"
 	"github.com/dnote/dnote/pkg/server/database"
 	"github.com/dnote/dnote/pkg/server/log"
@@ -87,7 +88,7 @@ func (a *App) updateProfile(w http.ResponseWriter, r *http.Request) {
 
 	tx.Commit()
 
-	respondWithSession(w, user.ID, http.StatusOK)
+	a.respondWithSession(w, r, user.ID, http.StatusOK)
 }
 
 type updateEmailPayload struct {
@@ -201,7 +202,7 @@ func (a *App) createVerificationToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := email.Send(); err != nil {
+	if err := a.Mailer.Send(email); err != nil {
 		handleError(w, "sending email", err, http.StatusInternalServerError)
 		return
 	}
@@ -271,7 +272,9 @@ func (a *App) verifyEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	session := makeSession(user, account)
+	a.recordAuditEvent(db, r, user.ID, user.ID, database.AuditEventEmailVerified)
+
+	session := a.makeSession(user, account)
 	respondJSON(w, http.StatusOK, session)
 }
 
@@ -346,6 +349,8 @@ func (a *App) getEmailPreference(w http.ResponseWriter, r *http.Request) {
 type updatePasswordPayload struct {
 	OldPassword string `json:"old_password"`
 	NewPassword string `json:"new_password"`
+	// RevokeOtherSessions signs out all other devices of the user
+	RevokeOtherSessions bool `json:"revoke_other_sessions"`
 }
 
 func (a *App) updatePassword(w http.ResponseWriter, r *http.Request) {
@@ -398,5 +403,21 @@ func (a *App) updatePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	a.recordAuditEvent(db, r, user.ID, user.ID, database.AuditEventPasswordUpdated)
+
+	if params.RevokeOtherSessions {
+		session, err := findRequestSession(db, r, user)
+		if err != nil {
+			handleError(w, "finding session", err, http.StatusInternalServerError)
+			return
+		}
+		if err := operations.DeleteOtherSessions(db, user.ID, session.ID); err != nil {
+			handleError(w, "deleting sessions", err, http.StatusInternalServerError)
+			return
+		}
+
+		a.recordAuditEvent(db, r, user.ID, user.ID, database.AuditEventSessionRevoked)
+	}
+
 	w.WriteHeader(http.StatusOK)
 }
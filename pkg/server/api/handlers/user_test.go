@@ -70,6 +70,36 @@ func TestUpdatePassword(t *testing.T) {
 		assert.Equal(t, passwordErr, nil, "Password mismatch")
 	})
 
+	t.Run("revoke other sessions", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		// Setup
+		server := httptest.NewServer(NewRouter(&App{
+			Clock: clock.NewMock(),
+		}))
+		defer server.Close()
+
+		user := testutils.SetupUserData()
+		testutils.SetupAccountData(user, "alice@example.com", "oldpassword")
+		otherSession := database.Session{Key: "otherKey", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+		testutils.MustExec(t, db.Save(&otherSession), "preparing other session")
+
+		// Execute
+		dat := `{"old_password": "oldpassword", "new_password": "newpassword", "revoke_other_sessions": true}`
+		req := testutils.MakeReq(server, "PATCH", "/account/password", dat)
+		res := testutils.HTTPAuthDo(t, req, user)
+
+		// Test
+		assert.StatusCodeEquals(t, res, http.StatusOK, "Status code mismsatch")
+
+		var sessionCount, otherCount int
+		testutils.MustExec(t, db.Model(&database.Session{}).Where("user_id = ?", user.ID).Count(&sessionCount), "counting sessions")
+		testutils.MustExec(t, db.Model(&database.Session{}).Where("key = ?", "otherKey").Count(&otherCount), "counting other session")
+		assert.Equal(t, sessionCount, 1, "the current session should remain")
+		assert.Equal(t, otherCount, 0, "the other session should be revoked")
+	})
+
 	t.Run("old password mismatch", func(t *testing.T) {
 		defer testutils.ClearData()
 		db := database.DBConn
@@ -0,0 +1,414 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dnote/dnote/pkg/server/api/helpers"
+	"github.com/dnote/dnote/pkg/server/api/operations"
+	"github.com/dnote/dnote/pkg/server/api/presenters"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/mailer"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// findAdminOrganization finds the organization administered by the user.
+// It writes a response and returns false if the user does not belong to any organization.
+func findAdminOrganization(w http.ResponseWriter, user database.User) (database.Organization, bool) {
+	org, ok, err := operations.GetOrganization(database.DBConn, user)
+	if err != nil {
+		handleError(w, "finding organization", err, http.StatusInternalServerError)
+		return org, false
+	}
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return org, false
+	}
+
+	return org, true
+}
+
+// findOrganizationUser finds the user with the uuid in the request path among the
+// users in the organization of the administrator. It writes a response and returns
+// false if the user cannot be found.
+func findOrganizationUser(w http.ResponseWriter, r *http.Request, admin database.User) (database.User, bool) {
+	var user database.User
+
+	userUUID := mux.Vars(r)["userUUID"]
+	if ok := helpers.ValidateUUID(userUUID); !ok {
+		http.Error(w, "invalid uuid", http.StatusBadRequest)
+		return user, false
+	}
+
+	db := database.DBConn
+	conn := db.Where("uuid = ? AND organization_id = ?", userUUID, admin.OrganizationID).Preload("Account").First(&user)
+	if conn.RecordNotFound() {
+		http.Error(w, "not found", http.StatusNotFound)
+		return user, false
+	} else if err := conn.Error; err != nil {
+		handleError(w, "finding user", err, http.StatusInternalServerError)
+		return user, false
+	}
+
+	return user, true
+}
+
+// GetOrganizationUsers returns the users in the organization of the administrator
+func (a *App) GetOrganizationUsers(w http.ResponseWriter, r *http.Request) {
+	admin, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	page, err := parsePageQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db := database.DBConn
+	conn := db.Where("organization_id = ?", admin.OrganizationID).Preload("Account").Order("id ASC")
+
+	var users []database.User
+	if err := paginate(conn, page).Find(&users).Error; err != nil {
+		handleError(w, "finding users", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, presenters.PresentOrganizationUsers(users))
+}
+
+type updateOrganizationUserPayload struct {
+	Admin    *bool `json:"admin"`
+	Disabled *bool `json:"disabled"`
+}
+
+// UpdateOrganizationUser grants or revokes the administrator role of a user, or
+// disables or enables a user
+func (a *App) UpdateOrganizationUser(w http.ResponseWriter, r *http.Request) {
+	admin, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	var params updateOrganizationUserPayload
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := findOrganizationUser(w, r, admin)
+	if !ok {
+		return
+	}
+	if user.ID == admin.ID {
+		http.Error(w, "cannot update your own account", http.StatusBadRequest)
+		return
+	}
+
+	db := database.DBConn
+	tx := db.Begin()
+
+	if params.Admin != nil {
+		if err := tx.Model(&user).Update("admin", *params.Admin).Error; err != nil {
+			tx.Rollback()
+			handleError(w, "updating admin", err, http.StatusInternalServerError)
+			return
+		}
+	}
+	if params.Disabled != nil {
+		var err error
+		if *params.Disabled {
+			err = operations.DisableUser(tx, user, a.Clock.Now())
+		} else {
+			err = operations.EnableUser(tx, user)
+		}
+
+		if err != nil {
+			tx.Rollback()
+			handleError(w, "updating disabled", err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	tx.Commit()
+
+	if params.Disabled != nil {
+		eventType := database.AuditEventUserEnabled
+		if *params.Disabled {
+			eventType = database.AuditEventUserDisabled
+		}
+		a.recordAuditEvent(db, r, user.ID, admin.ID, eventType)
+	}
+
+	var userRecord database.User
+	if err := db.Where("id = ?", user.ID).Preload("Account").First(&userRecord).Error; err != nil {
+		handleError(w, "finding user", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, presenters.PresentOrganizationUser(userRecord))
+}
+
+// DeleteOrganizationUser permanently deletes a user and all data of the user
+func (a *App) DeleteOrganizationUser(w http.ResponseWriter, r *http.Request) {
+	admin, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	user, ok := findOrganizationUser(w, r, admin)
+	if !ok {
+		return
+	}
+	if user.ID == admin.ID {
+		http.Error(w, "cannot delete your own account", http.StatusBadRequest)
+		return
+	}
+
+	hashes, err := findBlobHashes(database.DBConn, "user_id = ?", user.ID)
+	if err != nil {
+		handleError(w, "finding attachments", err, http.StatusInternalServerError)
+		return
+	}
+
+	tx := database.DBConn.Begin()
+
+	if err := operations.DeleteUser(tx, user); err != nil {
+		tx.Rollback()
+		handleError(w, "deleting user", err, http.StatusInternalServerError)
+		return
+	}
+
+	tx.Commit()
+
+	a.collectBlobs(hashes)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetOrganization returns the organization of the administrator along with its policy
+func (a *App) GetOrganization(w http.ResponseWriter, r *http.Request) {
+	admin, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	org, ok := findAdminOrganization(w, admin)
+	if !ok {
+		return
+	}
+
+	respondJSON(w, http.StatusOK, presenters.PresentOrganization(org))
+}
+
+type updateOrganizationPayload struct {
+	Name                  *string   `json:"name"`
+	AllowedDomains        *[]string `json:"allowed_domains"`
+	InviteOnly            *bool     `json:"invite_only"`
+	MinClientKDFIteration *int      `json:"min_client_kdf_iteration"`
+	DisablePublicNotes    *bool     `json:"disable_public_notes"`
+	RequireTOTP           *bool     `json:"require_totp"`
+}
+
+func validateUpdateOrganizationPayload(p updateOrganizationPayload) error {
+	if p.Name != nil && *p.Name == "" {
+		return errors.New("name cannot be empty")
+	}
+	if p.MinClientKDFIteration != nil && *p.MinClientKDFIteration < 0 {
+		return errors.New("min_client_kdf_iteration cannot be negative")
+	}
+	if p.AllowedDomains != nil {
+		for _, d := range *p.AllowedDomains {
+			if d == "" || strings.Contains(d, "@") {
+				return errors.Errorf("invalid domain '%s'", d)
+			}
+		}
+	}
+
+	return nil
+}
+
+// UpdateOrganization updates the name and the policy of the organization of the administrator
+func (a *App) UpdateOrganization(w http.ResponseWriter, r *http.Request) {
+	admin, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	var params updateOrganizationPayload
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if err := validateUpdateOrganizationPayload(params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	org, ok := findAdminOrganization(w, admin)
+	if !ok {
+		return
+	}
+
+	if params.Name != nil {
+		org.Name = *params.Name
+	}
+	if params.AllowedDomains != nil {
+		domains := []string{}
+		for _, d := range *params.AllowedDomains {
+			domains = append(domains, strings.ToLower(d))
+		}
+
+		org.AllowedDomains = domains
+	}
+	if params.InviteOnly != nil {
+		org.InviteOnly = *params.InviteOnly
+	}
+	if params.MinClientKDFIteration != nil {
+		org.MinClientKDFIteration = *params.MinClientKDFIteration
+	}
+	if params.DisablePublicNotes != nil {
+		org.DisablePublicNotes = *params.DisablePublicNotes
+	}
+	if params.RequireTOTP != nil {
+		org.RequireTOTP = *params.RequireTOTP
+	}
+
+	db := database.DBConn
+	if err := db.Save(&org).Error; err != nil {
+		handleError(w, "updating organization", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, presenters.PresentOrganization(org))
+}
+
+// GetOrganizationInvitations returns the pending invitations to the organization
+func (a *App) GetOrganizationInvitations(w http.ResponseWriter, r *http.Request) {
+	admin, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	org, ok := findAdminOrganization(w, admin)
+	if !ok {
+		return
+	}
+
+	db := database.DBConn
+	var invitations []database.OrganizationInvitation
+	if err := db.Where("organization_id = ? AND accepted_at IS NULL", org.ID).Order("id ASC").Find(&invitations).Error; err != nil {
+		handleError(w, "finding invitations", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, presenters.PresentOrganizationInvitations(invitations))
+}
+
+type createOrganizationInvitationPayload struct {
+	Email string `json:"email"`
+}
+
+// CreateOrganizationInvitation invites a person to sign up as a member of the
+// organization of the administrator
+func (a *App) CreateOrganizationInvitation(w http.ResponseWriter, r *http.Request) {
+	admin, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	var params createOrganizationInvitationPayload
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if !strings.Contains(params.Email, "@") {
+		http.Error(w, "invalid email", http.StatusBadRequest)
+		return
+	}
+
+	org, ok := findAdminOrganization(w, admin)
+	if !ok {
+		return
+	}
+
+	db := database.DBConn
+
+	var count int
+	if err := db.Model(database.Account{}).Where("email = ?", params.Email).Count(&count).Error; err != nil {
+		handleError(w, "checking duplicate user", err, http.StatusInternalServerError)
+		return
+	}
+	if count > 0 {
+		http.Error(w, "Duplicate email", http.StatusBadRequest)
+		return
+	}
+
+	var inviterAccount database.Account
+	if err := db.Where("user_id = ?", admin.ID).First(&inviterAccount).Error; err != nil {
+		handleError(w, "finding account", err, http.StatusInternalServerError)
+		return
+	}
+
+	tx := db.Begin()
+
+	invitation, err := operations.CreateOrganizationInvitation(tx, org, admin, params.Email)
+	if err != nil {
+		tx.Rollback()
+		handleError(w, "creating invitation", err, http.StatusInternalServerError)
+		return
+	}
+
+	subject := fmt.Sprintf("You are invited to join %s on Dnote", org.Name)
+	data := mailer.OrganizationInvitationTmplData{
+		Subject:          subject,
+		InviterEmail:     inviterAccount.Email.String,
+		OrganizationName: org.Name,
+		Token:            invitation.Token,
+	}
+	email := mailer.NewEmail("noreply@getdnote.com", []string{invitation.Email}, subject)
+	if err := email.ParseTemplate(mailer.EmailTypeOrganizationInvitation, data); err != nil {
+		tx.Rollback()
+		handleError(w, "parsing template", err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := a.Mailer.Send(email); err != nil {
+		tx.Rollback()
+		handleError(w, "sending email", err, http.StatusInternalServerError)
+		return
+	}
+
+	tx.Commit()
+
+	respondJSON(w, http.StatusCreated, presenters.PresentOrganizationInvitation(invitation))
+}
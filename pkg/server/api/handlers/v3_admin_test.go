@@ -0,0 +1,284 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/api/presenters"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+)
+
+// setupOrganization creates an organization with an administrator and a member
+func setupOrganization(t *testing.T) (database.Organization, database.User, database.User) {
+	db := database.DBConn
+
+	org := database.Organization{Name: "acme"}
+	testutils.MustExec(t, db.Save(&org), "preparing organization")
+
+	admin := testutils.SetupUserData()
+	testutils.SetupAccountData(admin, "alice@example.com", "pass1234")
+	testutils.MustExec(t, db.Model(&admin).Update(map[string]interface{}{"organization_id": org.ID, "admin": true}), "preparing admin")
+
+	member := testutils.SetupUserData()
+	testutils.SetupAccountData(member, "bob@example.com", "pass1234")
+	testutils.MustExec(t, db.Model(&member).Update("organization_id", org.ID), "preparing member")
+
+	var adminRecord, memberRecord database.User
+	testutils.MustExec(t, db.Where("id = ?", admin.ID).First(&adminRecord), "finding admin")
+	testutils.MustExec(t, db.Where("id = ?", member.ID).First(&memberRecord), "finding member")
+
+	return org, adminRecord, memberRecord
+}
+
+func TestGetOrganizationUsers(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	_, admin, member := setupOrganization(t)
+	outsider := testutils.SetupUserData()
+	testutils.SetupAccountData(outsider, "chuck@example.com", "pass1234")
+
+	t.Run("admin", func(t *testing.T) {
+		// Execute
+		req := testutils.MakeReq(server, "GET", "/v3/admin/users", "")
+		res := testutils.HTTPAuthDo(t, req, admin)
+
+		// Test
+		assert.StatusCodeEquals(t, res, http.StatusOK, "")
+
+		var payload []presenters.OrganizationUser
+		if err := json.NewDecoder(res.Body).Decode(&payload); err != nil {
+			t.Fatal(errors.Wrap(err, "decoding payload"))
+		}
+
+		assert.Equal(t, len(payload), 2, "payload length mismatch")
+		assert.Equal(t, payload[0].UUID, admin.UUID, "payload[0] uuid mismatch")
+		assert.Equal(t, payload[0].Email, "alice@example.com", "payload[0] email mismatch")
+		assert.Equal(t, payload[0].Admin, true, "payload[0] admin mismatch")
+		assert.Equal(t, payload[1].UUID, member.UUID, "payload[1] uuid mismatch")
+		assert.Equal(t, payload[1].Email, "bob@example.com", "payload[1] email mismatch")
+		assert.Equal(t, payload[1].Admin, false, "payload[1] admin mismatch")
+	})
+
+	t.Run("non-admin", func(t *testing.T) {
+		// Setup
+		testutils.MustExec(t, db.Delete(&database.Session{}), "clearing sessions")
+
+		// Execute
+		req := testutils.MakeReq(server, "GET", "/v3/admin/users", "")
+		res := testutils.HTTPAuthDo(t, req, member)
+
+		// Test
+		assert.StatusCodeEquals(t, res, http.StatusForbidden, "")
+	})
+}
+
+func TestUpdateOrganizationUser_Disable(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	_, admin, member := setupOrganization(t)
+	s1 := database.Session{
+		Key:       "someSessionKey",
+		UserID:    member.ID,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	testutils.MustExec(t, db.Save(&s1), "preparing session")
+
+	// Execute
+	endpoint := fmt.Sprintf("/v3/admin/users/%s", member.UUID)
+	req := testutils.MakeReq(server, "PATCH", endpoint, `{"disabled": true}`)
+	res := testutils.HTTPAuthDo(t, req, admin)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusOK, "")
+
+	var memberRecord database.User
+	var sessionCount int
+	testutils.MustExec(t, db.Where("id = ?", member.ID).First(&memberRecord), "finding member")
+	testutils.MustExec(t, db.Model(&database.Session{}).Where("user_id = ?", member.ID).Count(&sessionCount), "counting sessions")
+
+	assert.NotEqual(t, memberRecord.DisabledAt, (*time.Time)(nil), "DisabledAt mismatch")
+	assert.Equal(t, sessionCount, 0, "session count mismatch")
+
+	// a disabled user cannot authenticate
+	testutils.MustExec(t, db.Delete(&database.Session{}), "clearing sessions")
+	req = testutils.MakeReq(server, "GET", "/v3/books", "")
+	res = testutils.HTTPAuthDo(t, req, member)
+	assert.StatusCodeEquals(t, res, http.StatusUnauthorized, "authenticating disabled user")
+}
+
+func TestUpdateOrganizationUser_Self(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	_, admin, _ := setupOrganization(t)
+
+	// Execute
+	endpoint := fmt.Sprintf("/v3/admin/users/%s", admin.UUID)
+	req := testutils.MakeReq(server, "PATCH", endpoint, `{"admin": false}`)
+	res := testutils.HTTPAuthDo(t, req, admin)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusBadRequest, "")
+
+	var adminRecord database.User
+	testutils.MustExec(t, db.Where("id = ?", admin.ID).First(&adminRecord), "finding admin")
+	assert.Equal(t, adminRecord.Admin, true, "Admin mismatch")
+}
+
+func TestDeleteOrganizationUser(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	_, admin, member := setupOrganization(t)
+	outsider := testutils.SetupUserData()
+
+	b1 := database.Book{UserID: member.ID, Label: "js"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	n1 := database.Note{UserID: member.ID, BookUUID: b1.UUID, Body: "n1 content"}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+	b2 := database.Book{UserID: admin.ID, Label: "css"}
+	testutils.MustExec(t, db.Save(&b2), "preparing b2")
+
+	var outsiderRecord database.User
+	testutils.MustExec(t, db.Where("id = ?", outsider.ID).First(&outsiderRecord), "finding outsider")
+
+	// Execute
+	// cannot delete a user outside the organization
+	endpoint := fmt.Sprintf("/v3/admin/users/%s", outsiderRecord.UUID)
+	req := testutils.MakeReq(server, "DELETE", endpoint, "")
+	res := testutils.HTTPAuthDo(t, req, admin)
+	assert.StatusCodeEquals(t, res, http.StatusNotFound, "deleting outsider")
+
+	endpoint = fmt.Sprintf("/v3/admin/users/%s", member.UUID)
+	req = testutils.MakeReq(server, "DELETE", endpoint, "")
+	res = testutils.HTTPAuthDo(t, req, admin)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusNoContent, "")
+
+	var userCount, accountCount, bookCount, noteCount int
+	testutils.MustExec(t, db.Model(&database.User{}).Count(&userCount), "counting users")
+	testutils.MustExec(t, db.Model(&database.Account{}).Count(&accountCount), "counting accounts")
+	testutils.MustExec(t, db.Model(&database.Book{}).Count(&bookCount), "counting books")
+	testutils.MustExec(t, db.Model(&database.Note{}).Count(&noteCount), "counting notes")
+
+	assert.Equal(t, userCount, 2, "user count mismatch")
+	assert.Equal(t, accountCount, 1, "account count mismatch")
+	assert.Equal(t, bookCount, 1, "book count mismatch")
+	assert.Equal(t, noteCount, 0, "note count mismatch")
+}
+
+func TestUpdateOrganization(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	org, admin, _ := setupOrganization(t)
+
+	// Execute
+	dat := `{"allowed_domains": ["Example.com"], "invite_only": true, "min_client_kdf_iteration": 200000, "disable_public_notes": true, "require_totp": true}`
+	req := testutils.MakeReq(server, "PATCH", "/v3/admin/organization", dat)
+	res := testutils.HTTPAuthDo(t, req, admin)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusOK, "")
+
+	var orgRecord database.Organization
+	testutils.MustExec(t, db.Where("id = ?", org.ID).First(&orgRecord), "finding organization")
+
+	assert.Equal(t, orgRecord.Name, "acme", "Name mismatch")
+	assert.DeepEqual(t, []string(orgRecord.AllowedDomains), []string{"example.com"}, "AllowedDomains mismatch")
+	assert.Equal(t, orgRecord.InviteOnly, true, "InviteOnly mismatch")
+	assert.Equal(t, orgRecord.MinClientKDFIteration, 200000, "MinClientKDFIteration mismatch")
+	assert.Equal(t, orgRecord.DisablePublicNotes, true, "DisablePublicNotes mismatch")
+	assert.Equal(t, orgRecord.RequireTOTP, true, "RequireTOTP mismatch")
+}
+
+func TestShareNote_DisabledByOrganization(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	org, _, member := setupOrganization(t)
+	testutils.MustExec(t, db.Model(&org).Update("disable_public_notes", true), "preparing organization")
+	testutils.MustExec(t, db.Model(&member).Update("cloud", true), "preparing member")
+
+	b1 := database.Book{UserID: member.ID, Label: "js"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	n1 := database.Note{UserID: member.ID, BookUUID: b1.UUID, Body: "n1 content"}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+
+	// Execute
+	endpoint := fmt.Sprintf("/v3/notes/%s/share", n1.UUID)
+	req := testutils.MakeReq(server, "POST", endpoint, "")
+	res := testutils.HTTPAuthDo(t, req, member)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusForbidden, "")
+
+	var noteRecord database.Note
+	testutils.MustExec(t, db.Where("id = ?", n1.ID).First(&noteRecord), "finding n1")
+	assert.Equal(t, noteRecord.Public, false, "Public mismatch")
+}
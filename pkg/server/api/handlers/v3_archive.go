@@ -0,0 +1,137 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/dnote/dnote/pkg/server/api/helpers"
+	"github.com/dnote/dnote/pkg/server/api/operations"
+	"github.com/dnote/dnote/pkg/server/archive"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxArchiveSize is the maximum size in bytes of an archive to be imported
+	maxArchiveSize = 64 << 20
+	// maxArchiveDataSize is the maximum size in bytes of the decompressed data of
+	// an archive to be imported
+	maxArchiveDataSize = 512 << 20
+)
+
+// ExportArchive responds with the archive of the data of the user
+func (a *App) ExportArchive(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	now := a.Clock.Now()
+	ar, err := archive.Export(database.DBConn, user, now)
+	if err != nil {
+		handleError(w, "exporting archive", err, http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := archive.Encode(&buf, ar); err != nil {
+		handleError(w, "encoding archive", err, http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("dnote-%s.json.gz", now.UTC().Format("20060102"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// ImportArchive replaces the data of the user with the archive in the request body.
+// The clients of the user perform a full sync afterwards.
+func (a *App) ImportArchive(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	if r.ContentLength > maxArchiveSize {
+		http.Error(w, fmt.Sprintf("archive cannot be larger than %d bytes", maxArchiveSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	body := &io.LimitedReader{R: r.Body, N: maxArchiveSize + 1}
+	ar, err := archive.Decode(body, maxArchiveDataSize)
+	if body.N <= 0 {
+		http.Error(w, fmt.Sprintf("archive cannot be larger than %d bytes", maxArchiveSize), http.StatusRequestEntityTooLarge)
+		return
+	} else if err == archive.ErrTooLarge {
+		http.Error(w, fmt.Sprintf("archive cannot be larger than %d bytes when decompressed", maxArchiveDataSize), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := ar.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The attachments that are not in the archive are deleted by the import
+	hashes, err := findBlobHashes(database.DBConn, "user_id = ?", user.ID)
+	if err != nil {
+		handleError(w, "finding attachments", err, http.StatusInternalServerError)
+		return
+	}
+
+	tx := database.DBConn.Begin()
+	summary, err := archive.Import(tx, user, ar, a.Blobs, a.AttachmentQuota, a.Clock.Now())
+	if err != nil {
+		tx.Rollback()
+
+		if errors.Cause(err) == operations.ErrQuotaExceeded {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		handleError(w, "importing archive", err, http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		handleError(w, "committing transaction", err, http.StatusInternalServerError)
+		return
+	}
+
+	a.collectBlobs(hashes)
+
+	log.WithFields(log.Fields{
+		"user_id":             user.ID,
+		"books":               summary.Books,
+		"notes":               summary.Notes,
+		"skipped_attachments": summary.SkippedAttachments,
+	}).Info("imported archive")
+
+	respondJSON(w, http.StatusOK, summary)
+}
@@ -0,0 +1,214 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/archive"
+	"github.com/dnote/dnote/pkg/server/blob"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestExportImportArchive(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	c := clock.NewMock()
+	c.SetNow(time.Date(2019, time.November, 1, 0, 0, 0, 0, time.UTC))
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: c,
+	}))
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&user).Update("max_usn", 2), "preparing user max_usn")
+	b1 := database.Book{UserID: user.ID, Label: "js", USN: 1}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	n1 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "n1 content", USN: 2}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+
+	// Execute export
+	req := testutils.MakeReq(server, "GET", "/v3/account/export", "")
+	res := testutils.HTTPAuthDo(t, req, user)
+
+	// Test export
+	assert.StatusCodeEquals(t, res, http.StatusOK, "")
+	assert.Equal(t, res.Header.Get("Content-Type"), "application/gzip", "Content-Type mismatch")
+	assert.Equal(t, res.Header.Get("Content-Disposition"), `attachment; filename="dnote-20191101.json.gz"`, "Content-Disposition mismatch")
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reading body"))
+	}
+	ar, err := archive.Decode(bytes.NewReader(body), 0)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "decoding archive"))
+	}
+	assert.Equal(t, len(ar.Books), 1, "book count mismatch")
+	assert.Equal(t, len(ar.Notes), 1, "note count mismatch")
+	assert.Equal(t, ar.Notes[0].Body, "n1 content", "note body mismatch")
+
+	// Execute import after a change
+	testutils.MustExec(t, db.Model(&n1).Update("body", "n1 content edited"), "updating n1")
+	c.SetNow(time.Date(2019, time.November, 2, 0, 0, 0, 0, time.UTC))
+
+	req = testutils.MakeReq(server, "POST", "/v3/account/import", string(body))
+	res = testutils.HTTPAuthDo(t, req, user)
+
+	// Test import
+	assert.StatusCodeEquals(t, res, http.StatusOK, "")
+
+	var summary archive.Summary
+	if err := json.NewDecoder(res.Body).Decode(&summary); err != nil {
+		t.Fatal(errors.Wrap(err, "decoding payload"))
+	}
+	assert.Equal(t, summary, archive.Summary{Books: 1, Notes: 1}, "summary mismatch")
+
+	var noteRecord database.Note
+	testutils.MustExec(t, db.Where("user_id = ?", user.ID).First(&noteRecord), "finding note")
+	assert.Equal(t, noteRecord.UUID, n1.UUID, "note UUID mismatch")
+	assert.Equal(t, noteRecord.Body, "n1 content", "note body mismatch")
+	assert.Equal(t, noteRecord.USN, 4, "note USN mismatch")
+
+	// Test that the clients are made to perform a full sync
+	req = testutils.MakeReq(server, "GET", "/v3/sync/state", "")
+	res = testutils.HTTPAuthDo(t, req, user)
+	assert.StatusCodeEquals(t, res, http.StatusOK, "")
+
+	var state GetSyncStateResp
+	if err := json.NewDecoder(res.Body).Decode(&state); err != nil {
+		t.Fatal(errors.Wrap(err, "decoding sync state"))
+	}
+	assert.Equal(t, state.MaxUSN, 4, "MaxUSN mismatch")
+	assert.Equal(t, state.FullSyncBefore, int(c.Now().Unix()), "FullSyncBefore mismatch")
+}
+
+func TestImportArchive_BadRequest(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+	b1 := database.Book{UserID: user.ID, Label: "js", USN: 1}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+
+	var invalid bytes.Buffer
+	if err := archive.Encode(&invalid, archive.Archive{
+		Version: archive.Version,
+		Notes:   []archive.Note{{UUID: "n1", BookUUID: "b1"}},
+	}); err != nil {
+		t.Fatal(errors.Wrap(err, "encoding archive"))
+	}
+
+	testCases := []struct {
+		name string
+		body string
+	}{
+		{"not an archive", "foo"},
+		{"invalid archive", invalid.String()},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Execute
+			req := testutils.MakeReq(server, "POST", "/v3/account/import", tc.body)
+			res := testutils.HTTPAuthDo(t, req, user)
+
+			// Test
+			assert.StatusCodeEquals(t, res, http.StatusBadRequest, "")
+
+			var bookCount int
+			testutils.MustExec(t, db.Model(&database.Book{}).Where("user_id = ?", user.ID).Count(&bookCount), "counting books")
+			assert.Equal(t, bookCount, 1, "book count mismatch")
+		})
+	}
+}
+
+func TestImportArchive_QuotaExceeded(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	a, cleanup := newAttachmentTestApp(t, 1024, 5)
+	defer cleanup()
+	server := httptest.NewServer(NewRouter(a))
+	defer server.Close()
+
+	content := "foo"
+	hash := blob.Hash([]byte(content))
+	if err := a.Blobs.Put(hash, bytes.NewReader([]byte(content)), int64(len(content))); err != nil {
+		t.Fatal(errors.Wrap(err, "storing content"))
+	}
+
+	user := testutils.SetupUserData()
+	b1 := database.Book{UserID: user.ID, Label: "js", USN: 1}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	n1 := database.Note{UserID: user.ID, BookUUID: b1.UUID, USN: 2}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+	a1 := database.Attachment{NoteUUID: n1.UUID, UserID: user.ID, Name: "a1.txt", ContentType: "text/plain", Size: 3, Hash: hash}
+	testutils.MustExec(t, db.Save(&a1), "preparing a1")
+
+	// The archive claims small sizes, but the content takes 6 bytes in total
+	var body bytes.Buffer
+	if err := archive.Encode(&body, archive.Archive{
+		Version: archive.Version,
+		Books:   []archive.Book{{UUID: "b1", Label: "js"}},
+		Notes: []archive.Note{
+			{
+				UUID:     "n1",
+				BookUUID: "b1",
+				Attachments: []archive.Attachment{
+					{UUID: "a1", Name: "a1.txt", ContentType: "text/plain", Size: 1, Hash: hash},
+					{UUID: "a2", Name: "a2.txt", ContentType: "text/plain", Size: 1, Hash: hash},
+				},
+			},
+		},
+	}); err != nil {
+		t.Fatal(errors.Wrap(err, "encoding archive"))
+	}
+
+	// Execute
+	req := testutils.MakeReq(server, "POST", "/v3/account/import", body.String())
+	res := testutils.HTTPAuthDo(t, req, user)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusRequestEntityTooLarge, "")
+
+	var attachments []database.Attachment
+	testutils.MustExec(t, db.Where("user_id = ?", user.ID).Find(&attachments), "finding attachments")
+	assert.Equal(t, len(attachments), 1, "attachment count mismatch")
+	assert.Equal(t, attachments[0].UUID, a1.UUID, "attachment UUID mismatch")
+}
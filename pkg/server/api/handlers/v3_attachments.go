@@ -0,0 +1,384 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dnote/dnote/pkg/server/api/helpers"
+	"github.com/dnote/dnote/pkg/server/api/operations"
+	"github.com/dnote/dnote/pkg/server/api/presenters"
+	"github.com/dnote/dnote/pkg/server/blob"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/log"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// maxAttachmentNameLength is the maximum length of the name of an attachment
+const maxAttachmentNameLength = 255
+
+func validateAttachmentName(name string) error {
+	if name == "" {
+		return errors.New("name is required")
+	}
+	if utf8.RuneCountInString(name) > maxAttachmentNameLength {
+		return errors.Errorf("name cannot be longer than %d characters", maxAttachmentNameLength)
+	}
+	if strings.ContainsAny(name, "/\\") || name == "." || name == ".." {
+		return errors.New("name must be a file name without a directory")
+	}
+
+	return nil
+}
+
+// checkAttachmentsEnabled writes a response and returns false if the server does
+// not store attachments
+func (a *App) checkAttachmentsEnabled(w http.ResponseWriter) bool {
+	if a.Blobs == nil {
+		http.Error(w, "attachments are not enabled on this server", http.StatusNotImplemented)
+		return false
+	}
+
+	return true
+}
+
+// findBlobHashes returns the hashes of the content of the attachments that match
+// the given condition, so that the content can be collected after the attachments
+// are deleted
+func findBlobHashes(conn *gorm.DB, query string, args ...interface{}) ([]string, error) {
+	var ret []string
+	if err := conn.Model(&database.Attachment{}).Where(query, args...).Pluck("DISTINCT hash", &ret).Error; err != nil {
+		return nil, errors.Wrap(err, "finding attachment hashes")
+	}
+
+	return ret, nil
+}
+
+// collectBlob deletes the content with the given hash from the storage if no
+// attachment has it anymore
+func (a *App) collectBlob(hash string) error {
+	tx := database.DBConn.Begin()
+
+	if err := operations.LockBlob(tx, hash); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	referenced, err := operations.IsBlobReferenced(tx, hash)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "checking blob reference")
+	}
+	if referenced {
+		tx.Rollback()
+		return nil
+	}
+
+	if err := a.Blobs.Delete(hash); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "deleting blob")
+	}
+
+	// Release the lock
+	tx.Rollback()
+
+	return nil
+}
+
+// collectBlobs deletes the content with the given hashes from the storage if no
+// attachment has it anymore. Failures are logged, and leave the content in the
+// storage without affecting the data.
+func (a *App) collectBlobs(hashes []string) {
+	if a.Blobs == nil {
+		return
+	}
+
+	for _, hash := range hashes {
+		if err := a.collectBlob(hash); err != nil {
+			log.WithFields(log.Fields{
+				"hash": hash,
+			}).ErrorWrap(err, "collecting blob")
+		}
+	}
+}
+
+// findAttachment finds the attachment with the uuid in the request path, along with
+// its note. It writes a response and returns false if the attachment does not
+// exist or the user has no access to the note.
+func findAttachment(w http.ResponseWriter, r *http.Request, conn *gorm.DB, user database.User) (database.Attachment, database.Note, bool) {
+	var attachment database.Attachment
+	var note database.Note
+
+	attachmentUUID := mux.Vars(r)["attachmentUUID"]
+	if ok := helpers.ValidateUUID(attachmentUUID); !ok {
+		http.Error(w, "invalid uuid", http.StatusBadRequest)
+		return attachment, note, false
+	}
+
+	c := conn.Where("uuid = ?", attachmentUUID).First(&attachment)
+	if c.RecordNotFound() {
+		http.Error(w, "not found", http.StatusNotFound)
+		return attachment, note, false
+	} else if err := c.Error; err != nil {
+		handleError(w, "finding attachment", err, http.StatusInternalServerError)
+		return attachment, note, false
+	}
+
+	if err := conn.Where("uuid = ?", attachment.NoteUUID).Preload("Book").First(&note).Error; err != nil {
+		handleError(w, "finding note", err, http.StatusInternalServerError)
+		return attachment, note, false
+	}
+
+	role, err := operations.GetBookRole(conn, user.ID, note.Book)
+	if err != nil {
+		handleError(w, "getting book role", err, http.StatusInternalServerError)
+		return attachment, note, false
+	}
+	if role == "" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return attachment, note, false
+	}
+
+	return attachment, note, true
+}
+
+// bufferAttachment writes the body of the request to a temporary file while hashing
+// it. It returns the file, the size and the hash of the content. The size is
+// greater than max if the body is too large, in which case the content is not
+// read entirely.
+func bufferAttachment(body io.Reader, max int64) (*os.File, int64, string, error) {
+	f, err := ioutil.TempFile("", "dnote-attachment")
+	if err != nil {
+		return nil, 0, "", errors.Wrap(err, "creating temporary file")
+	}
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), io.LimitReader(body, max+1))
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, "", errors.Wrap(err, "reading body")
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, "", errors.Wrap(err, "rewinding temporary file")
+	}
+
+	return f, size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// CreateAttachment attaches the content in the request body to a note. The name of
+// the attachment is given by the 'name' query parameter.
+func (a *App) CreateAttachment(w http.ResponseWriter, r *http.Request) {
+	if !a.checkAttachmentsEnabled(w) {
+		return
+	}
+
+	db := database.DBConn
+
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	note, ok := findEditableNote(w, db, mux.Vars(r)["noteUUID"], user)
+	if !ok {
+		return
+	}
+	if note.Deleted {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if err := validateAttachmentName(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	if r.ContentLength > a.AttachmentMaxSize {
+		http.Error(w, fmt.Sprintf("attachment cannot be larger than %d bytes", a.AttachmentMaxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	f, size, hash, err := bufferAttachment(r.Body, a.AttachmentMaxSize)
+	if err != nil {
+		handleError(w, "buffering attachment", err, http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if size > a.AttachmentMaxSize {
+		http.Error(w, fmt.Sprintf("attachment cannot be larger than %d bytes", a.AttachmentMaxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Check the quota before storing the content. It is checked again when the
+	// attachment is created.
+	if a.AttachmentQuota > 0 {
+		usage, err := operations.GetAttachmentUsage(db, note.UserID)
+		if err != nil {
+			handleError(w, "getting attachment usage", err, http.StatusInternalServerError)
+			return
+		}
+		if usage+size > a.AttachmentQuota {
+			http.Error(w, operations.ErrQuotaExceeded.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+
+	// Lock the content so that it is not deleted by a concurrent collection until
+	// the attachment refers to it
+	tx := db.Begin()
+	if err := operations.LockBlob(tx, hash); err != nil {
+		tx.Rollback()
+		handleError(w, "locking attachment content", err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := a.Blobs.Put(hash, f, size); err != nil {
+		tx.Rollback()
+		handleError(w, "storing attachment", err, http.StatusInternalServerError)
+		return
+	}
+
+	attachment, _, err := operations.CreateAttachment(tx, user, a.Clock, note, operations.AttachmentParams{
+		Name:        name,
+		ContentType: contentType,
+		Size:        size,
+		Hash:        hash,
+	}, a.AttachmentQuota)
+	if err != nil {
+		tx.Rollback()
+		a.collectBlobs([]string{hash})
+
+		if errors.Cause(err) == operations.ErrQuotaExceeded {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		handleError(w, "creating attachment", err, http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		a.collectBlobs([]string{hash})
+		handleError(w, "committing transaction", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, presenters.PresentAttachment(attachment))
+}
+
+// GetAttachment responds with the content of an attachment
+func (a *App) GetAttachment(w http.ResponseWriter, r *http.Request) {
+	if !a.checkAttachmentsEnabled(w) {
+		return
+	}
+
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	attachment, _, ok := findAttachment(w, r, database.DBConn, user)
+	if !ok {
+		return
+	}
+
+	rc, err := a.Blobs.Get(attachment.Hash)
+	if err == blob.ErrNotFound {
+		http.Error(w, "the content of the attachment is missing", http.StatusNotFound)
+		return
+	} else if err != nil {
+		handleError(w, "getting attachment content", err, http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, rc); err != nil {
+		log.ErrorWrap(err, "writing attachment content")
+	}
+}
+
+// DeleteAttachment deletes an attachment, and its content if no other attachment
+// has the same content
+func (a *App) DeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	if !a.checkAttachmentsEnabled(w) {
+		return
+	}
+
+	db := database.DBConn
+
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	attachment, _, ok := findAttachment(w, r, db, user)
+	if !ok {
+		return
+	}
+	note, ok := findEditableNote(w, db, attachment.NoteUUID, user)
+	if !ok {
+		return
+	}
+
+	tx := db.Begin()
+	if _, err := operations.DeleteAttachment(tx, user, a.Clock, note, attachment); err != nil {
+		tx.Rollback()
+		handleError(w, "deleting attachment", err, http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		handleError(w, "committing transaction", err, http.StatusInternalServerError)
+		return
+	}
+
+	a.collectBlobs([]string{attachment.Hash})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,330 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/api/operations"
+	"github.com/dnote/dnote/pkg/server/api/presenters"
+	"github.com/dnote/dnote/pkg/server/blob"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func newAttachmentTestApp(t *testing.T, maxSize, quota int64) (*App, func()) {
+	dir, err := ioutil.TempDir("", "dnote-attachments")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating temporary directory"))
+	}
+
+	s, err := blob.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating blob store"))
+	}
+
+	a := &App{
+		Clock:             clock.NewMock(),
+		Blobs:             s,
+		AttachmentMaxSize: maxSize,
+		AttachmentQuota:   quota,
+	}
+
+	return a, func() { os.RemoveAll(dir) }
+}
+
+func TestCreateAttachment(t *testing.T) {
+	content := "body { display: flex; }"
+	hash := blob.Hash([]byte(content))
+
+	testCases := []struct {
+		name           string
+		query          string
+		maxSize        int64
+		quota          int64
+		expectedStatus int
+	}{
+		{
+			name:           "success",
+			query:          "name=flexbox.css",
+			maxSize:        100,
+			quota:          1000,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "too large",
+			query:          "name=flexbox.css",
+			maxSize:        10,
+			quota:          1000,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:           "quota exceeded",
+			query:          "name=flexbox.css",
+			maxSize:        100,
+			quota:          10,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:           "missing name",
+			query:          "",
+			maxSize:        100,
+			quota:          1000,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "name with directory",
+			query:          "name=../flexbox.css",
+			maxSize:        100,
+			quota:          1000,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			app, cleanup := newAttachmentTestApp(t, tc.maxSize, tc.quota)
+			defer cleanup()
+			server := httptest.NewServer(NewRouter(app))
+			defer server.Close()
+
+			user := testutils.SetupUserData()
+			testutils.MustExec(t, db.Model(&user).Update("max_usn", 2), "preparing user max_usn")
+			b1 := database.Book{UserID: user.ID, Label: "css", USN: 1}
+			testutils.MustExec(t, db.Save(&b1), "preparing b1")
+			n1 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "n1 content", USN: 2}
+			testutils.MustExec(t, db.Save(&n1), "preparing n1")
+
+			// Execute
+			req := testutils.MakeReq(server, "POST", fmt.Sprintf("/v3/notes/%s/attachments?%s", n1.UUID, tc.query), content)
+			req.Header.Set("Content-Type", "text/css")
+			res := testutils.HTTPAuthDo(t, req, user)
+
+			// Test
+			assert.StatusCodeEquals(t, res, tc.expectedStatus, "")
+
+			var attachmentCount int
+			var noteRecord database.Note
+			testutils.MustExec(t, db.Model(&database.Attachment{}).Count(&attachmentCount), "counting attachments")
+			testutils.MustExec(t, db.Where("id = ?", n1.ID).First(&noteRecord), "finding note")
+
+			_, err := app.Blobs.Get(hash)
+
+			if tc.expectedStatus != http.StatusCreated {
+				assert.Equal(t, attachmentCount, 0, "attachment count mismatch")
+				assert.Equal(t, noteRecord.USN, 2, "note USN mismatch")
+				assert.Equal(t, err, blob.ErrNotFound, "blob error mismatch")
+				return
+			}
+
+			var payload presenters.Attachment
+			if err := json.NewDecoder(res.Body).Decode(&payload); err != nil {
+				t.Fatal(errors.Wrap(err, "decoding payload"))
+			}
+
+			assert.Equal(t, attachmentCount, 1, "attachment count mismatch")
+			assert.Equal(t, payload.NoteUUID, n1.UUID, "NoteUUID mismatch")
+			assert.Equal(t, payload.Name, "flexbox.css", "Name mismatch")
+			assert.Equal(t, payload.ContentType, "text/css", "ContentType mismatch")
+			assert.Equal(t, payload.Size, int64(len(content)), "Size mismatch")
+			assert.Equal(t, payload.Hash, hash, "Hash mismatch")
+			assert.Equal(t, noteRecord.USN, 3, "note USN mismatch")
+			assert.Equal(t, err, nil, "blob error mismatch")
+		})
+	}
+}
+
+func TestCreateAttachment_Disabled(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+	b1 := database.Book{UserID: user.ID, Label: "css"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	n1 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "n1 content"}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+
+	// Execute
+	req := testutils.MakeReq(server, "POST", fmt.Sprintf("/v3/notes/%s/attachments?name=a.txt", n1.UUID), "content")
+	res := testutils.HTTPAuthDo(t, req, user)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusNotImplemented, "")
+}
+
+func TestGetAttachment(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	app, cleanup := newAttachmentTestApp(t, 100, 0)
+	defer cleanup()
+	server := httptest.NewServer(NewRouter(app))
+	defer server.Close()
+
+	owner := testutils.SetupUserData()
+	member := testutils.SetupUserData()
+	stranger := testutils.SetupUserData()
+
+	b1 := database.Book{UserID: owner.ID, Label: "css"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	n1 := database.Note{UserID: owner.ID, BookUUID: b1.UUID, Body: "n1 content"}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+
+	tx := db.Begin()
+	if _, err := operations.AddBookMember(tx, b1, member, database.BookMemberRoleViewer); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "adding book member"))
+	}
+	tx.Commit()
+
+	content := []byte("body { display: grid; }")
+	hash := blob.Hash(content)
+	if err := app.Blobs.Put(hash, bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatal(errors.Wrap(err, "putting content"))
+	}
+
+	a1 := database.Attachment{NoteUUID: n1.UUID, UserID: owner.ID, Name: "grid.css", ContentType: "text/css", Size: int64(len(content)), Hash: hash}
+	testutils.MustExec(t, db.Save(&a1), "preparing a1")
+
+	testCases := []struct {
+		name           string
+		user           database.User
+		expectedStatus int
+	}{
+		{
+			name:           "owner",
+			user:           owner,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "member",
+			user:           member,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "stranger",
+			user:           stranger,
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Execute
+			req := testutils.MakeReq(server, "GET", fmt.Sprintf("/v3/attachments/%s", a1.UUID), "")
+			res := testutils.HTTPAuthDo(t, req, tc.user)
+
+			// Test
+			assert.StatusCodeEquals(t, res, tc.expectedStatus, "")
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			body, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "reading body"))
+			}
+			assert.Equal(t, string(body), string(content), "content mismatch")
+			assert.Equal(t, res.Header.Get("Content-Type"), "text/css", "Content-Type mismatch")
+			assert.Equal(t, res.Header.Get("Content-Disposition"), "attachment; filename=grid.css", "Content-Disposition mismatch")
+		})
+	}
+}
+
+func TestDeleteAttachment(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	app, cleanup := newAttachmentTestApp(t, 100, 0)
+	defer cleanup()
+	server := httptest.NewServer(NewRouter(app))
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&user).Update("max_usn", 2), "preparing user max_usn")
+	b1 := database.Book{UserID: user.ID, Label: "css", USN: 1}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	n1 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "n1 content", USN: 2}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+
+	// Two attachments share the content
+	var uuids []string
+	for i := 0; i < 2; i++ {
+		req := testutils.MakeReq(server, "POST", fmt.Sprintf("/v3/notes/%s/attachments?name=a%d.txt", n1.UUID, i), "shared content")
+		res := testutils.HTTPAuthDo(t, req, user)
+		assert.StatusCodeEquals(t, res, http.StatusCreated, "creating attachment")
+
+		var payload presenters.Attachment
+		if err := json.NewDecoder(res.Body).Decode(&payload); err != nil {
+			t.Fatal(errors.Wrap(err, "decoding payload"))
+		}
+		uuids = append(uuids, payload.UUID)
+	}
+	hash := blob.Hash([]byte("shared content"))
+
+	// Execute
+	req := testutils.MakeReq(server, "DELETE", fmt.Sprintf("/v3/attachments/%s", uuids[0]), "")
+	res := testutils.HTTPAuthDo(t, req, user)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusNoContent, "")
+
+	var attachmentCount int
+	testutils.MustExec(t, db.Model(&database.Attachment{}).Count(&attachmentCount), "counting attachments")
+	assert.Equal(t, attachmentCount, 1, "attachment count mismatch")
+
+	_, err := app.Blobs.Get(hash)
+	assert.Equal(t, err, nil, "content should remain while referenced")
+
+	// Execute
+	req = testutils.MakeReq(server, "DELETE", fmt.Sprintf("/v3/attachments/%s", uuids[1]), "")
+	res = testutils.HTTPAuthDo(t, req, user)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusNoContent, "")
+
+	_, err = app.Blobs.Get(hash)
+	assert.Equal(t, err, blob.ErrNotFound, "content should be deleted when unreferenced")
+
+	var noteRecord database.Note
+	testutils.MustExec(t, db.Where("id = ?", n1.ID).First(&noteRecord), "finding note")
+	assert.Equal(t, noteRecord.USN, 6, "note USN mismatch")
+}
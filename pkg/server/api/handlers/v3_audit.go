@@ -0,0 +1,106 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/dnote/dnote/pkg/server/api/helpers"
+	"github.com/dnote/dnote/pkg/server/api/operations"
+	"github.com/dnote/dnote/pkg/server/api/presenters"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/log"
+	"github.com/jinzhu/gorm"
+)
+
+// recordAuditEvent records an event on the account of the user of the given id,
+// caused by the actor of the given id. It does not fail the request if the event
+// cannot be saved.
+func (a *App) recordAuditEvent(db *gorm.DB, r *http.Request, userID, actorID int, eventType string) {
+	event := database.AuditEvent{
+		UserID:    userID,
+		ActorID:   actorID,
+		Type:      eventType,
+		IPAddress: a.lookupIP(r),
+		UserAgent: r.UserAgent(),
+	}
+
+	if err := operations.RecordAuditEvent(db, event); err != nil {
+		log.WithFields(log.Fields{
+			"user_id": userID,
+			"type":    eventType,
+		}).ErrorWrap(err, "recording audit event")
+	}
+}
+
+// GetAuditLogResp is the response from get audit log api
+type GetAuditLogResp struct {
+	Events []presenters.AuditEvent `json:"events"`
+	Total  int                     `json:"total"`
+}
+
+// getAuditLog returns the audit events of the account of the user, most recent first
+func (a *App) getAuditLog(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	page, err := parsePageQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db := database.DBConn
+	conn := db.Model(database.AuditEvent{}).Where("user_id = ?", user.ID)
+
+	var total int
+	if err := conn.Count(&total).Error; err != nil {
+		handleError(w, "counting audit events", err, http.StatusInternalServerError)
+		return
+	}
+
+	var events []database.AuditEvent
+	if err := paginate(conn.Order("id DESC"), page).Find(&events).Error; err != nil {
+		handleError(w, "finding audit events", err, http.StatusInternalServerError)
+		return
+	}
+
+	actorIDs := []int{}
+	for _, e := range events {
+		actorIDs = append(actorIDs, e.ActorID)
+	}
+	var actors []database.User
+	if err := db.Where("id IN (?)", actorIDs).Find(&actors).Error; err != nil {
+		handleError(w, "finding actors", err, http.StatusInternalServerError)
+		return
+	}
+	actorUUIDs := map[int]string{}
+	for _, u := range actors {
+		actorUUIDs[u.ID] = u.UUID
+	}
+
+	resp := GetAuditLogResp{
+		Events: presenters.PresentAuditEvents(events, actorUUIDs),
+		Total:  total,
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
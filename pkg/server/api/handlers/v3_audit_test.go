@@ -0,0 +1,100 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestAuditLog(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+	testutils.SetupAccountData(user, "alice@example.com", "pass1234")
+	anotherUser := testutils.SetupUserData()
+	testutils.SetupAccountData(anotherUser, "bob@example.com", "pass1234")
+
+	failReq := testutils.MakeReq(server, "POST", "/v3/signin", `{"email": "alice@example.com", "password": "wrongpassword1234"}`)
+	failReq.Header.Set("User-Agent", "dnote-test")
+	assert.StatusCodeEquals(t, testutils.HTTPDo(t, failReq), http.StatusUnauthorized, "failed signin")
+	signinReq := testutils.MakeReq(server, "POST", "/v3/signin", `{"email": "alice@example.com", "password": "pass1234"}`)
+	signinReq.Header.Set("User-Agent", "dnote-test")
+	assert.StatusCodeEquals(t, testutils.HTTPDo(t, signinReq), http.StatusOK, "signin")
+	anotherReq := testutils.MakeReq(server, "POST", "/v3/signin", `{"email": "bob@example.com", "password": "pass1234"}`)
+	assert.StatusCodeEquals(t, testutils.HTTPDo(t, anotherReq), http.StatusOK, "signin of another user")
+
+	var eventCount int
+	testutils.MustExec(t, db.Model(&database.AuditEvent{}).Count(&eventCount), "counting events")
+	assert.Equal(t, eventCount, 3, "event count mismatch")
+
+	// Execute
+	req := testutils.MakeReq(server, "GET", "/v3/account/audit-log", "")
+	res := testutils.HTTPAuthDo(t, req, user)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusOK, "")
+
+	var got GetAuditLogResp
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(errors.Wrap(err, "decoding payload"))
+	}
+
+	assert.Equal(t, got.Total, 2, "total mismatch")
+	assert.Equal(t, len(got.Events), 2, "event count mismatch")
+	assert.Equal(t, got.Events[0].Type, database.AuditEventSignin, "events[0] type mismatch")
+	assert.Equal(t, got.Events[0].ActorUUID, user.UUID, "events[0] actor mismatch")
+	assert.Equal(t, got.Events[0].UserAgent, "dnote-test", "events[0] user agent mismatch")
+	assert.NotEqual(t, got.Events[0].IPAddress, "", "events[0] ip address mismatch")
+	assert.Equal(t, got.Events[1].Type, database.AuditEventSigninFailed, "events[1] type mismatch")
+}
+
+func TestAuditLog_invalidPage(t *testing.T) {
+	defer testutils.ClearData()
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+
+	// Execute
+	req := testutils.MakeReq(server, "GET", "/v3/account/audit-log?page=0", "")
+	res := testutils.HTTPAuthDo(t, req, user)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusBadRequest, "")
+}
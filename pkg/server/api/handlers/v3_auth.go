@@ -23,8 +23,10 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/dnote/dnote/pkg/server/api/helpers"
 	"github.com/dnote/dnote/pkg/server/api/operations"
 	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/jinzhu/gorm"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -32,13 +34,31 @@ import (
 // ErrLoginFailure is an error for failed login
 var ErrLoginFailure = errors.New("Wrong email and password combination")
 
+// ErrAccountDisabled is an error for a login to an account disabled by an administrator
+var ErrAccountDisabled = errors.New("Your account has been disabled. Please contact your administrator")
+
+// ErrTOTPRequired is an error for a signin to an account with two-factor authentication without a code
+var ErrTOTPRequired = errors.New("Two-factor authentication code is required")
+
+// ErrInvalidTOTP is an error for a wrong two-factor authentication code or recovery code
+var ErrInvalidTOTP = errors.New("Invalid two-factor authentication code")
+
+// totpRequiredHeader is the header of a signin response that tells the client to
+// ask the user for a two-factor authentication code
+const totpRequiredHeader = "TOTP-Required"
+
 // SessionResponse is a response containing a session information
 type SessionResponse struct {
 	Key       string `json:"key"`
 	ExpiresAt int64  `json:"expires_at"`
+	// TOTPEnrollmentRequired is true if the session can only be used to enable the
+	// two-factor authentication required by the organization of the user
+	TOTPEnrollmentRequired bool `json:"totp_enrollment_required,omitempty"`
 }
 
-func setSessionCookie(w http.ResponseWriter, key string, expires time.Time) {
+// setSessionCookie sets the session cookie. The cookie is only sent over HTTPS if
+// the server serves TLS.
+func (a *App) setSessionCookie(w http.ResponseWriter, key string, expires time.Time) {
 	cookie := http.Cookie{
 		Name:     "id",
 		Value:    key,
@@ -46,6 +66,11 @@ func setSessionCookie(w http.ResponseWriter, key string, expires time.Time) {
 		Path:     "/",
 		HttpOnly: true,
 	}
+	if a.TLS {
+		cookie.Secure = true
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+
 	http.SetCookie(w, &cookie)
 }
 
@@ -75,8 +100,82 @@ func touchLastLoginAt(user database.User) error {
 }
 
 type signinPayload struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	TOTPCode     string `json:"totp_code"`
+	RecoveryCode string `json:"recovery_code"`
+	// DeviceName is the name of the device that signs in, shown in the list of sessions
+	DeviceName string `json:"device_name"`
+}
+
+// getSessionInfo returns the information about the client that creates a session
+// with the request. The user agent is used if the client did not name its device.
+func (a *App) getSessionInfo(r *http.Request, deviceName string) operations.SessionInfo {
+	if deviceName == "" {
+		deviceName = r.UserAgent()
+	}
+
+	return operations.SessionInfo{
+		DeviceName: deviceName,
+		ClientType: getClientType(r),
+		IPAddress:  a.lookupIP(r),
+	}
+}
+
+// checkSecondFactor verifies the two-factor authentication code or the recovery
+// code of a signin if the account has the two-factor authentication enabled.
+// It responds and returns false if the signin must not proceed.
+func (a *App) checkSecondFactor(w http.ResponseWriter, r *http.Request, db *gorm.DB, account *database.Account, code, recoveryCode string) bool {
+	if !account.TOTPEnabled {
+		return true
+	}
+
+	if code == "" && recoveryCode == "" {
+		w.Header().Set(totpRequiredHeader, "true")
+		http.Error(w, ErrTOTPRequired.Error(), http.StatusUnauthorized)
+		return false
+	}
+
+	ok, err := operations.VerifySecondFactor(db, account, code, recoveryCode, a.Clock.Now())
+	if err != nil {
+		handleError(w, "verifying second factor", err, http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		a.recordAuditEvent(db, r, account.UserID, account.UserID, database.AuditEventSigninFailed)
+		w.Header().Set(totpRequiredHeader, "true")
+		http.Error(w, ErrInvalidTOTP.Error(), http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+// createSigninSession creates a session for a signin. If the organization of the
+// user requires the two-factor authentication and the account has not enabled it,
+// the session can only be used to enable it.
+func (a *App) createSigninSession(db *gorm.DB, user database.User, account database.Account, info operations.SessionInfo) (database.Session, error) {
+	session, err := operations.CreateSession(db, user.ID, info, a.Clock.Now())
+	if err != nil {
+		return session, errors.Wrap(err, "creating session")
+	}
+
+	if account.TOTPEnabled {
+		return session, nil
+	}
+
+	required, err := operations.RequiresTOTP(db, user)
+	if err != nil {
+		return session, errors.Wrap(err, "checking the organization policy")
+	}
+	if required {
+		session.TOTPEnrollmentRequired = true
+		if err := db.Model(&session).Update("totp_enrollment_required", true).Error; err != nil {
+			return session, errors.Wrap(err, "restricting session")
+		}
+	}
+
+	return session, nil
 }
 
 func (a *App) signin(w http.ResponseWriter, r *http.Request) {
@@ -106,6 +205,7 @@ func (a *App) signin(w http.ResponseWriter, r *http.Request) {
 	password := []byte(params.Password)
 	err = bcrypt.CompareHashAndPassword([]byte(account.Password.String), password)
 	if err != nil {
+		a.recordAuditEvent(db, r, account.UserID, account.UserID, database.AuditEventSigninFailed)
 		http.Error(w, ErrLoginFailure.Error(), http.StatusUnauthorized)
 		return
 	}
@@ -116,6 +216,14 @@ func (a *App) signin(w http.ResponseWriter, r *http.Request) {
 		handleError(w, "finding user", err, http.StatusInternalServerError)
 		return
 	}
+	if user.DisabledAt != nil {
+		http.Error(w, ErrAccountDisabled.Error(), http.StatusForbidden)
+		return
+	}
+
+	if ok := a.checkSecondFactor(w, r, db, &account, params.TOTPCode, params.RecoveryCode); !ok {
+		return
+	}
 
 	err = operations.TouchLastLoginAt(user, db)
 	if err != nil {
@@ -123,7 +231,14 @@ func (a *App) signin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondWithSession(w, account.UserID, http.StatusOK)
+	session, err := a.createSigninSession(db, user, account, a.getSessionInfo(r, params.DeviceName))
+	if err != nil {
+		handleError(w, "creating session", err, http.StatusInternalServerError)
+		return
+	}
+
+	a.recordAuditEvent(db, r, user.ID, user.ID, database.AuditEventSignin)
+	a.writeSession(w, session, http.StatusOK)
 }
 
 func (a *App) signoutOptions(w http.ResponseWriter, r *http.Request) {
@@ -143,19 +258,113 @@ func (a *App) signout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = operations.DeleteSession(database.DBConn, key)
+	db := database.DBConn
+
+	var session database.Session
+	conn := db.Where("key = ?", key).First(&session)
+	if conn.RecordNotFound() {
+		unsetSessionCookie(w)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	} else if err := conn.Error; err != nil {
+		handleError(w, "finding session", err, http.StatusInternalServerError)
+		return
+	}
+
+	err = operations.DeleteSession(db, key)
 	if err != nil {
 		handleError(w, "deleting session", nil, http.StatusInternalServerError)
 		return
 	}
 
+	a.recordAuditEvent(db, r, session.UserID, session.UserID, database.AuditEventSignout)
+
 	unsetSessionCookie(w)
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// GetSessionResp is the response from get session api
+type GetSessionResp struct {
+	Email     string `json:"email"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// findRequestSession finds the session of the given user that authenticated the request
+func findRequestSession(db *gorm.DB, r *http.Request, user database.User) (database.Session, error) {
+	key, err := getCredential(r)
+	if err != nil {
+		return database.Session{}, errors.Wrap(err, "getting credential")
+	}
+
+	var session database.Session
+	if err := db.Where("key = ? AND user_id = ?", key, user.ID).First(&session).Error; err != nil {
+		return database.Session{}, errors.Wrap(err, "finding session")
+	}
+
+	return session, nil
+}
+
+// getSession responds with the information about the session used for the request
+func (a *App) getSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusUnauthorized)
+		return
+	}
+
+	db := database.DBConn
+
+	session, err := findRequestSession(db, r, user)
+	if err != nil {
+		handleError(w, "finding session", err, http.StatusInternalServerError)
+		return
+	}
+
+	var account database.Account
+	if err := db.Where("user_id = ?", user.ID).First(&account).Error; err != nil {
+		handleError(w, "finding account", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, GetSessionResp{
+		Email:     account.Email.String,
+		ExpiresAt: session.ExpiresAt.Unix(),
+	})
+}
+
+// refreshSession replaces the session used for the request with a new session
+// and responds with the new session
+func (a *App) refreshSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	db := database.DBConn
+
+	session, err := findRequestSession(db, r, user)
+	if err != nil {
+		handleError(w, "finding session", err, http.StatusInternalServerError)
+		return
+	}
+
+	newSession, err := operations.RefreshSession(db, session, a.Clock.Now())
+	if err == operations.ErrSessionLifetimeExceeded {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		handleError(w, "refreshing session", err, http.StatusInternalServerError)
+		return
+	}
+
+	a.writeSession(w, newSession, http.StatusOK)
+}
+
 type registerPayload struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email           string `json:"email"`
+	Password        string `json:"password"`
+	InvitationToken string `json:"invitation_token"`
 }
 
 func validateRegisterPayload(p registerPayload) error {
@@ -200,31 +409,72 @@ func (a *App) register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := operations.CreateUser(params.Email, params.Password)
+	policy, err := operations.CheckSignup(db, params.Email, params.InvitationToken)
 	if err != nil {
+		if err == operations.ErrSignupNotAllowed {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		} else if err == operations.ErrInvalidOrganizationInvitation {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		handleError(w, "checking signup policy", err, http.StatusInternalServerError)
+		return
+	}
+
+	user, err := operations.CreateUser(params.Email, params.Password, operations.CreateUserParams{
+		OrganizationID: policy.OrganizationID,
+		Invitation:     policy.Invitation,
+		AcceptedAt:     a.Clock.Now(),
+	})
+	if err != nil {
+		if errors.Cause(err) == operations.ErrInvalidOrganizationInvitation {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		handleError(w, "creating user", err, http.StatusInternalServerError)
 		return
 	}
 
-	respondWithSession(w, user.ID, http.StatusCreated)
+	var account database.Account
+	if err := db.Where("user_id = ?", user.ID).First(&account).Error; err != nil {
+		handleError(w, "finding account", err, http.StatusInternalServerError)
+		return
+	}
+
+	session, err := a.createSigninSession(db, user, account, a.getSessionInfo(r, ""))
+	if err != nil {
+		handleError(w, "creating session", err, http.StatusInternalServerError)
+		return
+	}
+
+	a.writeSession(w, session, http.StatusCreated)
 }
 
 // respondWithSession makes a HTTP response with the session from the user with the given userID.
 // It sets the HTTP-Only cookie for browser clients and also sends a JSON response for non-browser clients.
-func respondWithSession(w http.ResponseWriter, userID int, statusCode int) {
+func (a *App) respondWithSession(w http.ResponseWriter, r *http.Request, userID int, statusCode int) {
 	db := database.DBConn
 
-	session, err := operations.CreateSession(db, userID)
+	session, err := operations.CreateSession(db, userID, a.getSessionInfo(r, ""), a.Clock.Now())
 	if err != nil {
 		handleError(w, "creating session", nil, http.StatusBadRequest)
 		return
 	}
 
-	setSessionCookie(w, session.Key, session.ExpiresAt)
+	a.writeSession(w, session, statusCode)
+}
+
+// writeSession sets the session cookie and responds with the given session
+func (a *App) writeSession(w http.ResponseWriter, session database.Session, statusCode int) {
+	a.setSessionCookie(w, session.Key, session.ExpiresAt)
 
 	response := SessionResponse{
-		Key:       session.Key,
-		ExpiresAt: session.ExpiresAt.Unix(),
+		Key:                    session.Key,
+		ExpiresAt:              session.ExpiresAt.Unix(),
+		TOTPEnrollmentRequired: session.TOTPEnrollmentRequired,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -437,3 +437,407 @@ func TestSignout(t *testing.T) {
 		assert.Equal(t, c, (*http.Cookie)(nil), "id cookie should have not been set")
 	})
 }
+
+func TestRegisterOrganizationRequiresTOTP(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	org := database.Organization{
+		Name:           "acme",
+		AllowedDomains: []string{"example.com"},
+		RequireTOTP:    true,
+	}
+	testutils.MustExec(t, db.Save(&org), "preparing organization")
+
+	dat := `{"email": "alice@example.com", "password": "pass1234"}`
+	req := testutils.MakeReq(server, "POST", "/v3/register", dat)
+
+	// Execute
+	res := testutils.HTTPDo(t, req)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusCreated, "Status mismatch")
+
+	var got SessionResponse
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(errors.Wrap(err, "decoding payload"))
+	}
+	assert.Equal(t, got.TOTPEnrollmentRequired, true, "totp_enrollment_required mismatch")
+
+	var session database.Session
+	testutils.MustExec(t, db.Where("key = ?", got.Key).First(&session), "finding session")
+	assert.Equal(t, session.TOTPEnrollmentRequired, true, "session totp_enrollment_required mismatch")
+
+	// the session can only be used to enable the two-factor authentication
+	req = testutils.MakeReq(server, "GET", "/v3/session", "")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", got.Key))
+	res = testutils.HTTPDo(t, req)
+	assert.StatusCodeEquals(t, res, http.StatusForbidden, "session status mismatch")
+}
+
+func TestRegisterOrganizationPolicy(t *testing.T) {
+	testCases := []struct {
+		email              string
+		inviteOnly         bool
+		invitationEmail    string
+		invitationToken    string
+		expectedStatusCode int
+		expectedOrg        bool
+	}{
+		{
+			email:              "alice@example.com",
+			expectedStatusCode: http.StatusCreated,
+			expectedOrg:        true,
+		},
+		{
+			email:              "alice@EXAMPLE.com",
+			expectedStatusCode: http.StatusCreated,
+			expectedOrg:        true,
+		},
+		{
+			email:              "alice@other.com",
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			email:              "alice@example.com",
+			inviteOnly:         true,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			email:              "alice@other.com",
+			inviteOnly:         true,
+			invitationEmail:    "alice@other.com",
+			invitationToken:    "someToken",
+			expectedStatusCode: http.StatusCreated,
+			expectedOrg:        true,
+		},
+		{
+			email:              "alice@other.com",
+			inviteOnly:         true,
+			invitationEmail:    "bob@other.com",
+			invitationToken:    "someToken",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			server := httptest.NewServer(NewRouter(&App{
+				Clock: clock.NewMock(),
+			}))
+			defer server.Close()
+
+			org := database.Organization{
+				Name:           "acme",
+				AllowedDomains: []string{"example.com"},
+				InviteOnly:     tc.inviteOnly,
+			}
+			testutils.MustExec(t, db.Save(&org), "preparing organization")
+
+			if tc.invitationEmail != "" {
+				invitation := database.OrganizationInvitation{
+					OrganizationID: org.ID,
+					Email:          tc.invitationEmail,
+					Token:          tc.invitationToken,
+				}
+				testutils.MustExec(t, db.Save(&invitation), "preparing invitation")
+			}
+
+			dat := fmt.Sprintf(`{"email": "%s", "password": "pass1234", "invitation_token": "%s"}`, tc.email, tc.invitationToken)
+			req := testutils.MakeReq(server, "POST", "/v3/register", dat)
+
+			// Execute
+			res := testutils.HTTPDo(t, req)
+
+			// Test
+			assert.StatusCodeEquals(t, res, tc.expectedStatusCode, "")
+
+			var userCount int
+			testutils.MustExec(t, db.Model(&database.User{}).Count(&userCount), "counting user")
+
+			if !tc.expectedOrg {
+				assert.Equal(t, userCount, 0, "user count mismatch")
+				return
+			}
+
+			var user database.User
+			testutils.MustExec(t, db.First(&user), "finding user")
+			assert.Equal(t, userCount, 1, "user count mismatch")
+			assert.Equal(t, user.OrganizationID, org.ID, "OrganizationID mismatch")
+			assert.Equal(t, user.Admin, false, "Admin mismatch")
+
+			if tc.invitationToken != "" {
+				var invitation database.OrganizationInvitation
+				testutils.MustExec(t, db.First(&invitation), "finding invitation")
+				assert.NotEqual(t, invitation.AcceptedAt, (*time.Time)(nil), "AcceptedAt mismatch")
+			}
+		})
+	}
+}
+
+func TestSignInDisabledUser(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	u := testutils.SetupUserData()
+	testutils.SetupAccountData(u, "alice@example.com", "pass1234")
+	testutils.MustExec(t, db.Model(&u).Update("disabled_at", time.Now()), "disabling user")
+
+	dat := `{"email": "alice@example.com", "password": "pass1234"}`
+	req := testutils.MakeReq(server, "POST", "/v3/signin", dat)
+
+	// Execute
+	res := testutils.HTTPDo(t, req)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusForbidden, "")
+
+	var sessionCount int
+	testutils.MustExec(t, db.Model(&database.Session{}).Count(&sessionCount), "counting session")
+	assert.Equal(t, sessionCount, 0, "session count mismatch")
+}
+
+func TestSetSessionCookie(t *testing.T) {
+	testCases := []struct {
+		tls              bool
+		expectedSecure   bool
+		expectedSameSite http.SameSite
+	}{
+		{
+			tls:              false,
+			expectedSecure:   false,
+			expectedSameSite: http.SameSite(0),
+		},
+		{
+			tls:              true,
+			expectedSecure:   true,
+			expectedSameSite: http.SameSiteLaxMode,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("tls %t", tc.tls), func(t *testing.T) {
+			a := App{TLS: tc.tls}
+			expires := time.Date(2019, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+			w := httptest.NewRecorder()
+			a.setSessionCookie(w, "someSessionKey", expires)
+
+			res := http.Response{Header: w.Header()}
+			c := testutils.GetCookieByName(res.Cookies(), "id")
+			assert.Equal(t, c.Value, "someSessionKey", "session key mismatch")
+			assert.Equal(t, c.HttpOnly, true, "session HTTPOnly mismatch")
+			assert.Equal(t, c.Secure, tc.expectedSecure, "session Secure mismatch")
+			assert.Equal(t, c.SameSite, tc.expectedSameSite, "session SameSite mismatch")
+		})
+	}
+}
+
+func TestGetSession(t *testing.T) {
+	t.Run("authenticated", func(t *testing.T) {
+		db := database.DBConn
+		defer testutils.ClearData()
+
+		user := testutils.SetupUserData()
+		testutils.SetupAccountData(user, "alice@example.com", "pass1234")
+
+		expiresAt := time.Now().Add(time.Hour * 24)
+		session := database.Session{
+			Key:       "A9xgggqzTHETy++GDi1NpDNe0iyqosPm9bitdeNGkJU=",
+			UserID:    user.ID,
+			ExpiresAt: expiresAt,
+		}
+		testutils.MustExec(t, db.Save(&session), "preparing session")
+
+		// Setup
+		server := httptest.NewServer(NewRouter(&App{
+			Clock: clock.NewMock(),
+		}))
+		defer server.Close()
+
+		// Execute
+		req := testutils.MakeReq(server, "GET", "/v3/session", "")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", "A9xgggqzTHETy++GDi1NpDNe0iyqosPm9bitdeNGkJU="))
+		res := testutils.HTTPDo(t, req)
+
+		// Test
+		assert.StatusCodeEquals(t, res, http.StatusOK, "Status mismatch")
+
+		var got GetSessionResp
+		if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+			t.Fatal(errors.Wrap(err, "decoding payload"))
+		}
+
+		assert.Equal(t, got.Email, "alice@example.com", "email mismatch")
+		assert.Equal(t, got.ExpiresAt, expiresAt.Unix(), "expires_at mismatch")
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		defer testutils.ClearData()
+
+		// Setup
+		server := httptest.NewServer(NewRouter(&App{
+			Clock: clock.NewMock(),
+		}))
+		defer server.Close()
+
+		// Execute
+		req := testutils.MakeReq(server, "GET", "/v3/session", "")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", "A9xgggqzTHETy++GDi1NpDNe0iyqosPm9bitdeNGkJU="))
+		res := testutils.HTTPDo(t, req)
+
+		// Test
+		assert.StatusCodeEquals(t, res, http.StatusUnauthorized, "Status mismatch")
+	})
+}
+
+func TestRefreshSession(t *testing.T) {
+	t.Run("valid session", func(t *testing.T) {
+		db := database.DBConn
+		defer testutils.ClearData()
+
+		c := clock.NewMock()
+		now := time.Date(2019, time.November, 1, 12, 0, 0, 0, time.UTC)
+		c.SetNow(now)
+
+		user := testutils.SetupUserData()
+		authenticatedAt := now.Add(-time.Hour)
+		session := database.Session{
+			Key:             "A9xgggqzTHETy++GDi1NpDNe0iyqosPm9bitdeNGkJU=",
+			UserID:          user.ID,
+			ExpiresAt:       now.Add(time.Hour * 24),
+			AuthenticatedAt: &authenticatedAt,
+		}
+		testutils.MustExec(t, db.Save(&session), "preparing session")
+
+		// Setup
+		server := httptest.NewServer(NewRouter(&App{
+			Clock: c,
+		}))
+		defer server.Close()
+
+		// Execute
+		req := testutils.MakeReq(server, "POST", "/v3/session/refresh", "")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", session.Key))
+		res := testutils.HTTPDo(t, req)
+
+		// Test
+		assert.StatusCodeEquals(t, res, http.StatusOK, "Status mismatch")
+
+		var got SessionResponse
+		if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+			t.Fatal(errors.Wrap(err, "decoding payload"))
+		}
+
+		var newSession database.Session
+		var sessionCount int
+		testutils.MustExec(t, db.Where("key = ?", got.Key).First(&newSession), "finding the new session")
+		testutils.MustExec(t, db.Model(&database.Session{}).Count(&sessionCount), "counting sessions")
+
+		assert.NotEqual(t, got.Key, session.Key, "key should be replaced")
+		assert.Equal(t, got.ExpiresAt, newSession.ExpiresAt.Unix(), "expires_at mismatch")
+		assert.Equal(t, newSession.ExpiresAt.Equal(now.Add(24*100*time.Hour)), true, "new session expires_at mismatch")
+		assert.Equal(t, newSession.AuthenticatedAt.Equal(authenticatedAt), true, "authenticated_at mismatch")
+		assert.Equal(t, newSession.UserID, user.ID, "user id mismatch")
+		assert.Equal(t, sessionCount, 2, "session count mismatch")
+
+		cookie := testutils.GetCookieByName(res.Cookies(), "id")
+		assert.Equal(t, cookie.Value, got.Key, "session cookie mismatch")
+
+		// the previous key should remain valid for a short while
+		req = testutils.MakeReq(server, "GET", "/v3/session", "")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", session.Key))
+		res = testutils.HTTPDo(t, req)
+		assert.StatusCodeEquals(t, res, http.StatusOK, "previous key status mismatch")
+
+		var old database.Session
+		testutils.MustExec(t, db.Where("key = ?", session.Key).First(&old), "finding the previous session")
+		assert.Equal(t, old.ExpiresAt.Equal(now.Add(time.Minute)), true, "previous session should expire after the overlap")
+	})
+
+	t.Run("beyond the maximum lifetime", func(t *testing.T) {
+		db := database.DBConn
+		defer testutils.ClearData()
+
+		c := clock.NewMock()
+		now := time.Date(2019, time.November, 1, 12, 0, 0, 0, time.UTC)
+		c.SetNow(now)
+
+		user := testutils.SetupUserData()
+		authenticatedAt := now.Add(-24 * 400 * time.Hour)
+		session := database.Session{
+			Key:             "A9xgggqzTHETy++GDi1NpDNe0iyqosPm9bitdeNGkJU=",
+			UserID:          user.ID,
+			ExpiresAt:       now.Add(time.Hour * 24),
+			AuthenticatedAt: &authenticatedAt,
+		}
+		testutils.MustExec(t, db.Save(&session), "preparing session")
+
+		// Setup
+		server := httptest.NewServer(NewRouter(&App{
+			Clock: c,
+		}))
+		defer server.Close()
+
+		// Execute
+		req := testutils.MakeReq(server, "POST", "/v3/session/refresh", "")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", session.Key))
+		res := testutils.HTTPDo(t, req)
+
+		// Test
+		assert.StatusCodeEquals(t, res, http.StatusUnauthorized, "Status mismatch")
+
+		var sessionCount int
+		testutils.MustExec(t, db.Model(&database.Session{}).Count(&sessionCount), "counting sessions")
+		assert.Equal(t, sessionCount, 1, "session count mismatch")
+	})
+
+	t.Run("expired session", func(t *testing.T) {
+		db := database.DBConn
+		defer testutils.ClearData()
+
+		c := clock.NewMock()
+
+		user := testutils.SetupUserData()
+		session := database.Session{
+			Key:       "A9xgggqzTHETy++GDi1NpDNe0iyqosPm9bitdeNGkJU=",
+			UserID:    user.ID,
+			ExpiresAt: c.Now().Add(-time.Hour),
+		}
+		testutils.MustExec(t, db.Save(&session), "preparing session")
+
+		// Setup
+		server := httptest.NewServer(NewRouter(&App{
+			Clock: c,
+		}))
+		defer server.Close()
+
+		// Execute
+		req := testutils.MakeReq(server, "POST", "/v3/session/refresh", "")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", session.Key))
+		res := testutils.HTTPDo(t, req)
+
+		// Test
+		assert.StatusCodeEquals(t, res, http.StatusUnauthorized, "Status mismatch")
+
+		var sessionCount int
+		testutils.MustExec(t, db.Model(&database.Session{}).Count(&sessionCount), "counting sessions")
+		assert.Equal(t, sessionCount, 1, "session count mismatch")
+	})
+}
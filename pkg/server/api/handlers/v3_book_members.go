@@ -0,0 +1,479 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dnote/dnote/pkg/server/api/helpers"
+	"github.com/dnote/dnote/pkg/server/api/operations"
+	"github.com/dnote/dnote/pkg/server/api/presenters"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/mailer"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// sharedBookUUIDsQuery is a subquery for the uuids of the books of which a user is a member
+const sharedBookUUIDsQuery = "SELECT books.uuid FROM books INNER JOIN book_members ON book_members.book_id = books.id WHERE book_members.user_id = ?"
+
+// findBook finds the book with the given uuid along with the role of the given user in
+// the book. It writes a response and returns false if the book does not exist, has been
+// deleted, or the user has no access to it.
+func findBook(w http.ResponseWriter, conn *gorm.DB, bookUUID string, user database.User) (database.Book, string, bool) {
+	var book database.Book
+
+	if ok := helpers.ValidateUUID(bookUUID); !ok {
+		http.Error(w, "invalid uuid", http.StatusBadRequest)
+		return book, "", false
+	}
+
+	c := conn.Where("uuid = ? AND NOT deleted", bookUUID).First(&book)
+	if c.RecordNotFound() {
+		http.Error(w, "not found", http.StatusNotFound)
+		return book, "", false
+	} else if err := c.Error; err != nil {
+		handleError(w, "finding book", err, http.StatusInternalServerError)
+		return book, "", false
+	}
+
+	role, err := operations.GetBookRole(conn, user.ID, book)
+	if err != nil {
+		handleError(w, "getting book role", err, http.StatusInternalServerError)
+		return book, "", false
+	}
+	if role == "" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return book, "", false
+	}
+
+	return book, role, true
+}
+
+// findManagedBook finds the book with the uuid in the request path that the user can manage.
+// It writes a response and returns false if such book cannot be found.
+func findManagedBook(w http.ResponseWriter, r *http.Request, user database.User) (database.Book, bool) {
+	db := database.DBConn
+
+	book, role, ok := findBook(w, db, mux.Vars(r)["bookUUID"], user)
+	if !ok {
+		return book, false
+	}
+	if !operations.CanManageBook(role) {
+		http.Error(w, "only the owners of the book can manage it", http.StatusForbidden)
+		return book, false
+	}
+
+	return book, true
+}
+
+// findBookMember finds the membership of the user with the uuid in the request path.
+// It writes a response and returns false if the membership cannot be found.
+func findBookMember(w http.ResponseWriter, r *http.Request, book database.Book) (database.BookMember, bool) {
+	var member database.BookMember
+
+	userUUID := mux.Vars(r)["userUUID"]
+	if ok := helpers.ValidateUUID(userUUID); !ok {
+		http.Error(w, "invalid uuid", http.StatusBadRequest)
+		return member, false
+	}
+
+	db := database.DBConn
+	conn := db.Joins("INNER JOIN users ON users.id = book_members.user_id").
+		Where("book_members.book_id = ? AND users.uuid = ?", book.ID, userUUID).
+		Preload("User").
+		First(&member)
+	if conn.RecordNotFound() {
+		http.Error(w, "not found", http.StatusNotFound)
+		return member, false
+	} else if err := conn.Error; err != nil {
+		handleError(w, "finding book member", err, http.StatusInternalServerError)
+		return member, false
+	}
+
+	return member, true
+}
+
+func getEmails(userIDs []int) (map[int]string, error) {
+	db := database.DBConn
+
+	var accounts []database.Account
+	if err := db.Where("user_id IN (?)", userIDs).Find(&accounts).Error; err != nil {
+		return nil, errors.Wrap(err, "finding accounts")
+	}
+
+	ret := map[int]string{}
+	for _, account := range accounts {
+		ret[account.UserID] = account.Email.String
+	}
+
+	return ret, nil
+}
+
+// GetBookMembers returns the owner and the members of a book
+func (a *App) GetBookMembers(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	db := database.DBConn
+	book, _, ok := findBook(w, db, mux.Vars(r)["bookUUID"], user)
+	if !ok {
+		return
+	}
+
+	var owner database.User
+	if err := db.Where("id = ?", book.UserID).First(&owner).Error; err != nil {
+		handleError(w, "finding book owner", err, http.StatusInternalServerError)
+		return
+	}
+
+	var members []database.BookMember
+	if err := db.Where("book_id = ?", book.ID).Preload("User").Order("id ASC").Find(&members).Error; err != nil {
+		handleError(w, "finding book members", err, http.StatusInternalServerError)
+		return
+	}
+
+	// the user who created the book is always presented as the first owner
+	members = append([]database.BookMember{{
+		Model:  database.Model{CreatedAt: book.CreatedAt},
+		BookID: book.ID,
+		UserID: owner.ID,
+		User:   owner,
+		Role:   database.BookMemberRoleOwner,
+	}}, members...)
+
+	userIDs := []int{}
+	for _, member := range members {
+		userIDs = append(userIDs, member.UserID)
+	}
+	emails, err := getEmails(userIDs)
+	if err != nil {
+		handleError(w, "getting emails", err, http.StatusInternalServerError)
+		return
+	}
+
+	ret := []presenters.BookMember{}
+	for _, member := range members {
+		ret = append(ret, presenters.PresentBookMember(member, emails[member.UserID]))
+	}
+
+	respondJSON(w, http.StatusOK, ret)
+}
+
+type updateBookMemberPayload struct {
+	Role string `json:"role"`
+}
+
+// UpdateBookMember changes the role of a member of a book
+func (a *App) UpdateBookMember(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	var params updateBookMemberPayload
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if err := operations.ValidateBookMemberRole(params.Role); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	book, ok := findManagedBook(w, r, user)
+	if !ok {
+		return
+	}
+	member, ok := findBookMember(w, r, book)
+	if !ok {
+		return
+	}
+
+	db := database.DBConn
+	tx := db.Begin()
+
+	member, err := operations.UpdateBookMemberRole(tx, member, params.Role)
+	if err != nil {
+		tx.Rollback()
+		handleError(w, "updating book member", err, http.StatusInternalServerError)
+		return
+	}
+
+	tx.Commit()
+
+	emails, err := getEmails([]int{member.UserID})
+	if err != nil {
+		handleError(w, "getting emails", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, presenters.PresentBookMember(member, emails[member.UserID]))
+}
+
+// RemoveBookMember removes a member from a book. Owners can remove any member, and
+// other members can remove themselves to leave the book.
+func (a *App) RemoveBookMember(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	db := database.DBConn
+	book, role, ok := findBook(w, db, mux.Vars(r)["bookUUID"], user)
+	if !ok {
+		return
+	}
+	member, ok := findBookMember(w, r, book)
+	if !ok {
+		return
+	}
+
+	if member.UserID != user.ID && !operations.CanManageBook(role) {
+		http.Error(w, "only the owners of the book can manage it", http.StatusForbidden)
+		return
+	}
+
+	tx := db.Begin()
+
+	if err := operations.RemoveBookMember(tx, book, member); err != nil {
+		tx.Rollback()
+		handleError(w, "removing book member", err, http.StatusInternalServerError)
+		return
+	}
+
+	tx.Commit()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type createBookInvitationPayload struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+func validateCreateBookInvitationPayload(p createBookInvitationPayload) error {
+	if p.Email == "" {
+		return errors.New("email is required")
+	}
+	if !strings.Contains(p.Email, "@") {
+		return errors.Errorf("invalid email %s", p.Email)
+	}
+	if err := operations.ValidateBookMemberRole(p.Role); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateBookInvitation invites a user to a book by email
+func (a *App) CreateBookInvitation(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	var params createBookInvitationPayload
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if err := validateCreateBookInvitationPayload(params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	book, ok := findManagedBook(w, r, user)
+	if !ok {
+		return
+	}
+
+	db := database.DBConn
+
+	var inviterAccount database.Account
+	if err := db.Where("user_id = ?", user.ID).First(&inviterAccount).Error; err != nil {
+		handleError(w, "finding account", err, http.StatusInternalServerError)
+		return
+	}
+
+	tx := db.Begin()
+
+	invitation, err := operations.CreateBookInvitation(tx, book, user, params.Email, params.Role, a.Clock.Now())
+	if err != nil {
+		tx.Rollback()
+		handleError(w, "creating invitation", err, http.StatusInternalServerError)
+		return
+	}
+
+	subject := fmt.Sprintf("You are invited to %s on Dnote", book.Label)
+	data := mailer.BookInvitationTmplData{
+		Subject:      subject,
+		InviterEmail: inviterAccount.Email.String,
+		BookLabel:    book.Label,
+		Role:         invitation.Role,
+		Token:        invitation.Token,
+	}
+	email := mailer.NewEmail("noreply@getdnote.com", []string{invitation.Email}, subject)
+	if err := email.ParseTemplate(mailer.EmailTypeBookInvitation, data); err != nil {
+		tx.Rollback()
+		handleError(w, "parsing template", err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := a.Mailer.Send(email); err != nil {
+		tx.Rollback()
+		handleError(w, "sending email", err, http.StatusInternalServerError)
+		return
+	}
+
+	tx.Commit()
+
+	respondJSON(w, http.StatusCreated, presenters.PresentBookInvitation(invitation))
+}
+
+// GetBookInvitations returns the pending invitations to a book
+func (a *App) GetBookInvitations(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	book, ok := findManagedBook(w, r, user)
+	if !ok {
+		return
+	}
+
+	db := database.DBConn
+
+	var invitations []database.BookInvitation
+	if err := db.Where("book_id = ? AND accepted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", book.ID, a.Clock.Now()).Order("id ASC").Find(&invitations).Error; err != nil {
+		handleError(w, "finding invitations", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, presenters.PresentBookInvitations(invitations))
+}
+
+// DeleteBookInvitation revokes a pending invitation to a book
+func (a *App) DeleteBookInvitation(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	book, ok := findManagedBook(w, r, user)
+	if !ok {
+		return
+	}
+
+	invitationUUID := mux.Vars(r)["invitationUUID"]
+	if ok := helpers.ValidateUUID(invitationUUID); !ok {
+		http.Error(w, "invalid uuid", http.StatusBadRequest)
+		return
+	}
+
+	db := database.DBConn
+
+	var invitation database.BookInvitation
+	conn := db.Where("uuid = ? AND book_id = ? AND accepted_at IS NULL", invitationUUID, book.ID).First(&invitation)
+	if conn.RecordNotFound() {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	} else if err := conn.Error; err != nil {
+		handleError(w, "finding invitation", err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.Delete(&invitation).Error; err != nil {
+		handleError(w, "deleting invitation", err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type acceptBookInvitationPayload struct {
+	Token string `json:"token"`
+}
+
+// AcceptBookInvitation makes the user a member of the book to which the user was invited
+func (a *App) AcceptBookInvitation(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	var params acceptBookInvitationPayload
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil || params.Token == "" {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	db := database.DBConn
+
+	var invitation database.BookInvitation
+	conn := db.Where("token = ?", params.Token).First(&invitation)
+	if conn.RecordNotFound() {
+		http.Error(w, "invalid token", http.StatusBadRequest)
+		return
+	} else if err := conn.Error; err != nil {
+		handleError(w, "finding invitation", err, http.StatusInternalServerError)
+		return
+	}
+
+	var account database.Account
+	if err := db.Where("user_id = ?", user.ID).First(&account).Error; err != nil {
+		handleError(w, "finding account", err, http.StatusInternalServerError)
+		return
+	}
+
+	tx := db.Begin()
+
+	member, err := operations.AcceptBookInvitation(tx, invitation, user, account, a.Clock.Now())
+	if err != nil {
+		tx.Rollback()
+
+		cause := errors.Cause(err)
+		if cause == operations.ErrInvitationAccepted || cause == operations.ErrInvitationExpired || cause == operations.ErrInvitationEmailMismatch || cause == operations.ErrBookMemberExists {
+			http.Error(w, cause.Error(), http.StatusBadRequest)
+			return
+		}
+
+		handleError(w, "accepting invitation", err, http.StatusInternalServerError)
+		return
+	}
+
+	tx.Commit()
+
+	member.User = user
+	respondJSON(w, http.StatusOK, presenters.PresentBookMember(member, account.Email.String))
+}
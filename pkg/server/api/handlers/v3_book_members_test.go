@@ -0,0 +1,319 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/api/presenters"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestGetBookMembers(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	owner := testutils.SetupUserData()
+	testutils.SetupAccountData(owner, "alice@example.com", "pass1234")
+	member := testutils.SetupUserData()
+	testutils.SetupAccountData(member, "bob@example.com", "pass1234")
+	outsider := testutils.SetupUserData()
+
+	b1 := database.Book{UserID: owner.ID, Label: "runbooks"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	m1 := database.BookMember{BookID: b1.ID, UserID: member.ID, Role: database.BookMemberRoleViewer}
+	testutils.MustExec(t, db.Save(&m1), "preparing m1")
+
+	testCases := []struct {
+		user               database.User
+		expectedStatusCode int
+	}{
+		{owner, http.StatusOK},
+		{member, http.StatusOK},
+		{outsider, http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("user %d", tc.user.ID), func(t *testing.T) {
+			// Setup
+			testutils.MustExec(t, db.Delete(&database.Session{}), "clearing sessions")
+
+			// Execute
+			endpoint := fmt.Sprintf("/v3/books/%s/members", b1.UUID)
+			req := testutils.MakeReq(server, "GET", endpoint, "")
+			res := testutils.HTTPAuthDo(t, req, tc.user)
+
+			// Test
+			assert.StatusCodeEquals(t, res, tc.expectedStatusCode, "")
+			if tc.expectedStatusCode != http.StatusOK {
+				return
+			}
+
+			var payload []presenters.BookMember
+			if err := json.NewDecoder(res.Body).Decode(&payload); err != nil {
+				t.Fatal(errors.Wrap(err, "decoding payload"))
+			}
+
+			assert.Equal(t, len(payload), 2, "payload length mismatch")
+			assert.Equal(t, payload[0].Email, "alice@example.com", "payload[0] email mismatch")
+			assert.Equal(t, payload[0].Role, database.BookMemberRoleOwner, "payload[0] role mismatch")
+			assert.Equal(t, payload[1].Email, "bob@example.com", "payload[1] email mismatch")
+			assert.Equal(t, payload[1].Role, database.BookMemberRoleViewer, "payload[1] role mismatch")
+		})
+	}
+}
+
+func TestGetBookMembers_DeletedBook(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	owner := testutils.SetupUserData()
+
+	b1 := database.Book{UserID: owner.ID, Label: "", Deleted: true}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+
+	// Execute
+	req := testutils.MakeReq(server, "GET", fmt.Sprintf("/v3/books/%s/members", b1.UUID), "")
+	res := testutils.HTTPAuthDo(t, req, owner)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusNotFound, "")
+}
+
+func TestCreateBookInvitation(t *testing.T) {
+	testCases := []struct {
+		role               string
+		expectedStatusCode int
+		expectedCount      int
+	}{
+		{database.BookMemberRoleOwner, http.StatusCreated, 1},
+		{database.BookMemberRoleEditor, http.StatusForbidden, 0},
+		{database.BookMemberRoleViewer, http.StatusForbidden, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.role, func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			server := httptest.NewServer(NewRouter(&App{
+				Clock: clock.NewMock(),
+			}))
+			defer server.Close()
+
+			owner := testutils.SetupUserData()
+			testutils.SetupAccountData(owner, "alice@example.com", "pass1234")
+
+			b1 := database.Book{UserID: owner.ID, Label: "runbooks"}
+			testutils.MustExec(t, db.Save(&b1), "preparing b1")
+
+			user := owner
+			if tc.role != database.BookMemberRoleOwner {
+				user = testutils.SetupUserData()
+				testutils.SetupAccountData(user, "bob@example.com", "pass1234")
+				m1 := database.BookMember{BookID: b1.ID, UserID: user.ID, Role: tc.role}
+				testutils.MustExec(t, db.Save(&m1), "preparing m1")
+			}
+
+			// Execute
+			endpoint := fmt.Sprintf("/v3/books/%s/invitations", b1.UUID)
+			dat := `{"email": "Charlie@example.com", "role": "editor"}`
+			req := testutils.MakeReq(server, "POST", endpoint, dat)
+			res := testutils.HTTPAuthDo(t, req, user)
+
+			// Test
+			assert.StatusCodeEquals(t, res, tc.expectedStatusCode, "")
+
+			var invitationCount int
+			testutils.MustExec(t, db.Model(&database.BookInvitation{}).Count(&invitationCount), "counting invitations")
+			assert.Equal(t, invitationCount, tc.expectedCount, "invitation count mismatch")
+
+			if tc.expectedCount == 0 {
+				return
+			}
+
+			var invitationRecord database.BookInvitation
+			testutils.MustExec(t, db.First(&invitationRecord), "finding invitation")
+			assert.Equal(t, invitationRecord.BookID, b1.ID, "book_id mismatch")
+			assert.Equal(t, invitationRecord.InviterID, owner.ID, "inviter_id mismatch")
+			assert.Equal(t, invitationRecord.Email, "charlie@example.com", "email mismatch")
+			assert.Equal(t, invitationRecord.Role, database.BookMemberRoleEditor, "role mismatch")
+			assert.NotEqual(t, invitationRecord.Token, "", "token should not be empty")
+		})
+	}
+}
+
+func TestAcceptBookInvitation(t *testing.T) {
+	testCases := []struct {
+		email              string
+		expectedStatusCode int
+		expectedCount      int
+	}{
+		{"charlie@example.com", http.StatusOK, 1},
+		{"dan@example.com", http.StatusBadRequest, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.email, func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			server := httptest.NewServer(NewRouter(&App{
+				Clock: clock.NewMock(),
+			}))
+			defer server.Close()
+
+			owner := testutils.SetupUserData()
+			user := testutils.SetupUserData()
+			testutils.SetupAccountData(user, tc.email, "pass1234")
+
+			b1 := database.Book{UserID: owner.ID, Label: "runbooks"}
+			testutils.MustExec(t, db.Save(&b1), "preparing b1")
+			invitation := database.BookInvitation{
+				BookID:    b1.ID,
+				InviterID: owner.ID,
+				Email:     "charlie@example.com",
+				Role:      database.BookMemberRoleEditor,
+				Token:     "someRandomToken",
+			}
+			testutils.MustExec(t, db.Save(&invitation), "preparing invitation")
+
+			// Execute
+			req := testutils.MakeReq(server, "POST", "/v3/book-invitations/accept", `{"token": "someRandomToken"}`)
+			res := testutils.HTTPAuthDo(t, req, user)
+
+			// Test
+			assert.StatusCodeEquals(t, res, tc.expectedStatusCode, "")
+
+			var memberCount int
+			testutils.MustExec(t, db.Model(&database.BookMember{}).Where("book_id = ? AND user_id = ?", b1.ID, user.ID).Count(&memberCount), "counting book members")
+			assert.Equal(t, memberCount, tc.expectedCount, "member count mismatch")
+		})
+	}
+}
+
+func TestRemoveBookMember_Self(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	owner := testutils.SetupUserData()
+	member := testutils.SetupUserData()
+	anotherMember := testutils.SetupUserData()
+
+	b1 := database.Book{UserID: owner.ID, Label: "runbooks"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	m1 := database.BookMember{BookID: b1.ID, UserID: member.ID, Role: database.BookMemberRoleEditor}
+	testutils.MustExec(t, db.Save(&m1), "preparing m1")
+	m2 := database.BookMember{BookID: b1.ID, UserID: anotherMember.ID, Role: database.BookMemberRoleEditor}
+	testutils.MustExec(t, db.Save(&m2), "preparing m2")
+
+	var memberRecord, anotherMemberRecord database.User
+	testutils.MustExec(t, db.Where("id = ?", member.ID).First(&memberRecord), "finding member")
+	testutils.MustExec(t, db.Where("id = ?", anotherMember.ID).First(&anotherMemberRecord), "finding another member")
+
+	// Execute
+	// a member cannot remove another member
+	endpoint := fmt.Sprintf("/v3/books/%s/members/%s", b1.UUID, anotherMemberRecord.UUID)
+	req := testutils.MakeReq(server, "DELETE", endpoint, "")
+	res := testutils.HTTPAuthDo(t, req, member)
+	assert.StatusCodeEquals(t, res, http.StatusForbidden, "removing another member")
+
+	// a member can leave the book
+	endpoint = fmt.Sprintf("/v3/books/%s/members/%s", b1.UUID, memberRecord.UUID)
+	req = testutils.MakeReq(server, "DELETE", endpoint, "")
+	res = testutils.HTTPAuthDo(t, req, member)
+	assert.StatusCodeEquals(t, res, http.StatusNoContent, "leaving the book")
+
+	// Test
+	var memberCount int
+	testutils.MustExec(t, db.Model(&database.BookMember{}).Count(&memberCount), "counting book members")
+	assert.Equal(t, memberCount, 1, "member count mismatch")
+}
+
+func TestCreateNote_SharedBook(t *testing.T) {
+	testCases := []struct {
+		role               string
+		expectedStatusCode int
+		expectedCount      int
+	}{
+		{database.BookMemberRoleEditor, http.StatusCreated, 1},
+		{database.BookMemberRoleViewer, http.StatusForbidden, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.role, func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			server := httptest.NewServer(NewRouter(&App{
+				Clock: clock.NewMock(),
+			}))
+			defer server.Close()
+
+			owner := testutils.SetupUserData()
+			user := testutils.SetupUserData()
+
+			b1 := database.Book{UserID: owner.ID, Label: "runbooks"}
+			testutils.MustExec(t, db.Save(&b1), "preparing b1")
+			m1 := database.BookMember{BookID: b1.ID, UserID: user.ID, Role: tc.role}
+			testutils.MustExec(t, db.Save(&m1), "preparing m1")
+
+			// Execute
+			dat := fmt.Sprintf(`{"book_uuid": "%s", "content": "note content"}`, b1.UUID)
+			req := testutils.MakeReq(server, "POST", "/v3/notes", dat)
+			res := testutils.HTTPAuthDo(t, req, user)
+
+			// Test
+			assert.StatusCodeEquals(t, res, tc.expectedStatusCode, "")
+
+			var noteCount int
+			testutils.MustExec(t, db.Model(&database.Note{}).Where("user_id = ? AND author_id = ?", owner.ID, user.ID).Count(&noteCount), "counting notes")
+			assert.Equal(t, noteCount, tc.expectedCount, "note count mismatch")
+		})
+	}
+}
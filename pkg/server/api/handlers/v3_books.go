@@ -29,11 +29,13 @@ import (
 	"github.com/dnote/dnote/pkg/server/api/presenters"
 	"github.com/dnote/dnote/pkg/server/database"
 	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
 	"github.com/pkg/errors"
 )
 
 type createBookPayload struct {
-	Name string `json:"name"`
+	Name       string `json:"name"`
+	ParentUUID string `json:"parent_uuid"`
 }
 
 // CreateBookResp is the response from create book api
@@ -71,9 +73,13 @@ func (a *App) CreateBook(w http.ResponseWriter, r *http.Request) {
 
 	db := database.DBConn
 
+	if !validateBookParent(w, db, user, "", params.ParentUUID) {
+		return
+	}
+
 	var bookCount int
 	err = db.Model(database.Book{}).
-		Where("user_id = ? AND label = ?", user.ID, params.Name).
+		Where("user_id = ? AND label = ? AND parent_uuid = ?", user.ID, params.Name, params.ParentUUID).
 		Count(&bookCount).Error
 	if err != nil {
 		handleError(w, "checking duplicate", err, http.StatusInternalServerError)
@@ -84,7 +90,7 @@ func (a *App) CreateBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	book, err := operations.CreateBook(user, a.Clock, params.Name)
+	book, err := operations.CreateBook(user, a.Clock, params.Name, params.ParentUUID)
 	if err != nil {
 		handleError(w, "inserting book", err, http.StatusInternalServerError)
 	}
@@ -94,6 +100,51 @@ func (a *App) CreateBook(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusCreated, resp)
 }
 
+// validateBookParent checks that the book with the given uuid can be nested in the
+// book with the given parentUUID. An empty bookUUID denotes a new book. If the parent
+// is invalid, it responds with an error and returns false.
+func validateBookParent(w http.ResponseWriter, db *gorm.DB, user database.User, bookUUID, parentUUID string) bool {
+	if parentUUID == "" {
+		return true
+	}
+	if !helpers.ValidateUUID(parentUUID) {
+		http.Error(w, "invalid parent_uuid", http.StatusBadRequest)
+		return false
+	}
+
+	var parent database.Book
+	conn := db.Where("uuid = ? AND user_id = ? AND NOT deleted", parentUUID, user.ID).First(&parent)
+	if conn.RecordNotFound() {
+		http.Error(w, "parent book not found", http.StatusBadRequest)
+		return false
+	} else if err := conn.Error; err != nil {
+		handleError(w, "finding the parent book", err, http.StatusInternalServerError)
+		return false
+	}
+
+	if bookUUID == "" {
+		return true
+	}
+
+	// the parent must not be the book itself or any of the books nested in it
+	var count int
+	if err := db.Raw(`WITH RECURSIVE ancestors(uuid, parent_uuid) AS (
+			SELECT uuid::text, parent_uuid FROM books WHERE uuid = ?
+			UNION
+			SELECT books.uuid::text, books.parent_uuid FROM books INNER JOIN ancestors ON books.uuid::text = ancestors.parent_uuid
+		)
+		SELECT count(*) FROM ancestors WHERE uuid = ?`, parentUUID, bookUUID).Row().Scan(&count); err != nil {
+		handleError(w, "checking the parent book", err, http.StatusInternalServerError)
+		return false
+	}
+	if count > 0 {
+		http.Error(w, "cannot move a book into itself", http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
+
 // BooksOptions is a handler for OPTIONS endpoint for notes
 func (a *App) BooksOptions(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
@@ -104,7 +155,7 @@ func respondWithBooks(userID int, query url.Values, w http.ResponseWriter) {
 	db := database.DBConn
 
 	var books []database.Book
-	conn := db.Where("user_id = ? AND NOT deleted", userID).Order("label ASC")
+	conn := db.Where("(user_id = ? OR id IN (SELECT book_id FROM book_members WHERE user_id = ?)) AND NOT deleted", userID, userID).Order("label ASC")
 	name := query.Get("name")
 	encryptedStr := query.Get("encrypted")
 
@@ -169,15 +220,8 @@ func (a *App) GetBook(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bookUUID := vars["bookUUID"]
 
-	var book database.Book
-	conn := db.Where("uuid = ? AND user_id = ?", bookUUID, user.ID).First(&book)
-
-	if conn.RecordNotFound() {
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
-	if err := conn.Error; err != nil {
-		handleError(w, "finding book", err, http.StatusInternalServerError)
+	book, _, ok := findBook(w, db, bookUUID, user)
+	if !ok {
 		return
 	}
 
@@ -186,7 +230,8 @@ func (a *App) GetBook(w http.ResponseWriter, r *http.Request) {
 }
 
 type updateBookPayload struct {
-	Name *string `json:"name"`
+	Name       *string `json:"name"`
+	ParentUUID *string `json:"parent_uuid"`
 }
 
 // UpdateBookResp is the response from create book api
@@ -207,9 +252,14 @@ func (a *App) UpdateBook(w http.ResponseWriter, r *http.Request) {
 	db := database.DBConn
 	tx := db.Begin()
 
-	var book database.Book
-	if err := tx.Where("user_id = ? AND uuid = ?", user.ID, uuid).First(&book).Error; err != nil {
-		handleError(w, "finding book", err, http.StatusInternalServerError)
+	book, role, ok := findBook(w, tx, uuid, user)
+	if !ok {
+		tx.Rollback()
+		return
+	}
+	if !operations.CanManageBook(role) {
+		tx.Rollback()
+		http.Error(w, "only the owners of the book can manage it", http.StatusForbidden)
 		return
 	}
 
@@ -220,7 +270,19 @@ func (a *App) UpdateBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	book, err = operations.UpdateBook(tx, a.Clock, user, book, params.Name)
+	if params.ParentUUID != nil && *params.ParentUUID != book.ParentUUID {
+		if book.UserID != user.ID {
+			tx.Rollback()
+			http.Error(w, "only the owner of the book can move it", http.StatusForbidden)
+			return
+		}
+		if !validateBookParent(w, tx, user, book.UUID, *params.ParentUUID) {
+			tx.Rollback()
+			return
+		}
+	}
+
+	book, err = operations.UpdateBook(tx, a.Clock, user, book, params.Name, params.ParentUUID)
 	if err != nil {
 		tx.Rollback()
 		handleError(w, "updating a book", err, http.StatusInternalServerError)
@@ -240,7 +302,8 @@ type DeleteBookResp struct {
 	Book   presenters.Book `json:"book"`
 }
 
-// DeleteBook removes a book
+// DeleteBook removes a book. A member of a book owned by another user leaves the
+// book instead, so that it is removed only from the member's copy.
 func (a *App) DeleteBook(w http.ResponseWriter, r *http.Request) {
 	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
 	if !ok {
@@ -251,28 +314,84 @@ func (a *App) DeleteBook(w http.ResponseWriter, r *http.Request) {
 	uuid := vars["bookUUID"]
 
 	db := database.DBConn
-	tx := db.Begin()
 
+	// the book is found even if it has been deleted, so that the request can be retried
 	var book database.Book
-	if err := tx.Where("user_id = ? AND uuid = ?", user.ID, uuid).First(&book).Error; err != nil {
+	conn := db.Where("uuid = ?", uuid).First(&book)
+	if conn.RecordNotFound() {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	} else if err := conn.Error; err != nil {
 		handleError(w, "finding book", err, http.StatusInternalServerError)
 		return
 	}
 
+	role, err := operations.GetBookRole(db, user.ID, book)
+	if err != nil {
+		handleError(w, "getting book role", err, http.StatusInternalServerError)
+		return
+	}
+
+	if role == "" {
+		// a former member retrying the request after leaving the book
+		var s database.SharedUSN
+		conn := db.Where("user_id = ? AND type = ? AND uuid = ?", user.ID, database.SharedUSNTypeBook, book.UUID).First(&s)
+		if conn.RecordNotFound() {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		} else if err := conn.Error; err != nil {
+			handleError(w, "finding shared usn", err, http.StatusInternalServerError)
+			return
+		}
+
+		book.USN = s.USN
+		book.Deleted = true
+
+		resp := DeleteBookResp{
+			Status: http.StatusOK,
+			Book:   presenters.PresentBook(book),
+		}
+		respondJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	tx := db.Begin()
+
+	if book.UserID != user.ID {
+		b, err := operations.LeaveBook(tx, user, book)
+		if err != nil {
+			tx.Rollback()
+			handleError(w, "leaving book", err, http.StatusInternalServerError)
+			return
+		}
+
+		tx.Commit()
+
+		resp := DeleteBookResp{
+			Status: http.StatusOK,
+			Book:   presenters.PresentBook(b),
+		}
+		respondJSON(w, http.StatusOK, resp)
+		return
+	}
+
 	var notes []database.Note
 	if err := tx.Where("book_uuid = ? AND NOT deleted", uuid).Order("usn ASC").Find(&notes).Error; err != nil {
+		tx.Rollback()
 		handleError(w, "finding notes", err, http.StatusInternalServerError)
 		return
 	}
 
 	for _, note := range notes {
-		if _, err := operations.DeleteNote(tx, user, note); err != nil {
+		if _, err := operations.DeleteNote(tx, user, a.Clock, note); err != nil {
+			tx.Rollback()
 			handleError(w, "deleting a note", err, http.StatusInternalServerError)
 			return
 		}
 	}
-	b, err := operations.DeleteBook(tx, user, book)
+	b, err := operations.DeleteBook(tx, a.Clock, user, book)
 	if err != nil {
+		tx.Rollback()
 		handleError(w, "deleting book", err, http.StatusInternalServerError)
 		return
 	}
@@ -352,6 +352,78 @@ func TestDeleteBook(t *testing.T) {
 	}
 }
 
+func TestDeleteBook_Member(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	owner := testutils.SetupUserData()
+	member := testutils.SetupUserData()
+	anotherMember := testutils.SetupUserData()
+	stranger := testutils.SetupUserData()
+
+	b1 := database.Book{UserID: owner.ID, Label: "runbooks", USN: 1}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	n1 := database.Note{UserID: owner.ID, BookUUID: b1.UUID, Body: "n1 content", USN: 2}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+	m1 := database.BookMember{BookID: b1.ID, UserID: member.ID, Role: database.BookMemberRoleOwner}
+	testutils.MustExec(t, db.Save(&m1), "preparing m1")
+	m2 := database.BookMember{BookID: b1.ID, UserID: anotherMember.ID, Role: database.BookMemberRoleEditor}
+	testutils.MustExec(t, db.Save(&m2), "preparing m2")
+
+	endpoint := fmt.Sprintf("/v3/books/%s", b1.UUID)
+
+	// Execute
+	res := testutils.HTTPAuthDo(t, testutils.MakeReq(server, "DELETE", endpoint, ""), member)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusOK, "")
+
+	var payload DeleteBookResp
+	if err := json.NewDecoder(res.Body).Decode(&payload); err != nil {
+		t.Fatal(errors.Wrap(err, "decoding payload"))
+	}
+
+	var b1Record database.Book
+	var n1Record database.Note
+	var memberRecord database.User
+	var memberCount int
+	testutils.MustExec(t, db.Where("id = ?", b1.ID).First(&b1Record), "finding b1")
+	testutils.MustExec(t, db.Where("id = ?", n1.ID).First(&n1Record), "finding n1")
+	testutils.MustExec(t, db.Where("id = ?", member.ID).First(&memberRecord), "finding member")
+	testutils.MustExec(t, db.Model(&database.BookMember{}).Where("book_id = ?", b1.ID).Count(&memberCount), "counting members")
+
+	// the book remains for the owner and the other members
+	assert.Equal(t, b1Record.Deleted, false, "b1 deleted mismatch")
+	assert.Equal(t, b1Record.Label, b1.Label, "b1 label mismatch")
+	assert.Equal(t, b1Record.USN, b1.USN, "b1 usn mismatch")
+	assert.Equal(t, n1Record.Deleted, false, "n1 deleted mismatch")
+	assert.Equal(t, n1Record.Body, n1.Body, "n1 content mismatch")
+	assert.Equal(t, memberCount, 1, "member count mismatch")
+
+	var s database.SharedUSN
+	testutils.MustExec(t, db.Where("user_id = ? AND type = ? AND uuid = ?", member.ID, database.SharedUSNTypeBook, b1.UUID).First(&s), "finding shared usn")
+	assert.Equal(t, payload.Book.UUID, b1.UUID, "payload uuid mismatch")
+	assert.Equal(t, payload.Book.USN, s.USN, "payload usn mismatch")
+
+	// the request can be retried after the member left the book
+	res = testutils.HTTPAuthDo(t, testutils.MakeReq(server, "DELETE", endpoint, ""), member)
+	assert.StatusCodeEquals(t, res, http.StatusOK, "retrying")
+
+	var userRecord database.User
+	testutils.MustExec(t, db.Where("id = ?", member.ID).First(&userRecord), "finding member after retrying")
+	assert.Equal(t, userRecord.MaxUSN, memberRecord.MaxUSN, "max_usn should not change when retrying")
+
+	// a user who was never a member cannot find the book
+	res = testutils.HTTPAuthDo(t, testutils.MakeReq(server, "DELETE", endpoint, ""), stranger)
+	assert.StatusCodeEquals(t, res, http.StatusNotFound, "stranger")
+}
+
 func TestCreateBook(t *testing.T) {
 	defer testutils.ClearData()
 	db := database.DBConn
@@ -547,3 +619,197 @@ func TestUpdateBook(t *testing.T) {
 		}()
 	}
 }
+
+func TestCreateBook_parent(t *testing.T) {
+	testCases := []struct {
+		name               string
+		parentUUID         string
+		parentDeleted      bool
+		parentOwnedByOther bool
+		expectedStatus     int
+	}{
+		{
+			name:           "valid parent",
+			parentUUID:     "ead8790f-aff9-4bdf-8eec-f734ccd29202",
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "nonexistent parent",
+			parentUUID:     "0ecaac96-8d72-4e04-8925-5a21b79a16da",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "deleted parent",
+			parentUUID:     "ead8790f-aff9-4bdf-8eec-f734ccd29202",
+			parentDeleted:  true,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:               "parent of another user",
+			parentUUID:         "ead8790f-aff9-4bdf-8eec-f734ccd29202",
+			parentOwnedByOther: true,
+			expectedStatus:     http.StatusBadRequest,
+		},
+		{
+			name:           "invalid parent uuid",
+			parentUUID:     "not-a-uuid",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			server := httptest.NewServer(NewRouter(&App{
+				Clock: clock.NewMock(),
+			}))
+			defer server.Close()
+
+			user := testutils.SetupUserData()
+			anotherUser := testutils.SetupUserData()
+
+			parentUserID := user.ID
+			if tc.parentOwnedByOther {
+				parentUserID = anotherUser.ID
+			}
+			parent := database.Book{
+				UUID:    "ead8790f-aff9-4bdf-8eec-f734ccd29202",
+				UserID:  parentUserID,
+				Label:   "infra",
+				Deleted: tc.parentDeleted,
+			}
+			testutils.MustExec(t, db.Save(&parent), "preparing the parent")
+
+			// Execute
+			payload := fmt.Sprintf(`{"name": "k8s", "parent_uuid": "%s"}`, tc.parentUUID)
+			req := testutils.MakeReq(server, "POST", "/v3/books", payload)
+			res := testutils.HTTPAuthDo(t, req, user)
+
+			// Test
+			assert.StatusCodeEquals(t, res, tc.expectedStatus, "")
+
+			var bookCount int
+			testutils.MustExec(t, db.Model(&database.Book{}).Where("label = ?", "k8s").Count(&bookCount), "counting books")
+			if tc.expectedStatus == http.StatusCreated {
+				var bookRecord database.Book
+				testutils.MustExec(t, db.Where("label = ?", "k8s").First(&bookRecord), "finding book")
+
+				assert.Equal(t, bookCount, 1, "book count mismatch")
+				assert.Equal(t, bookRecord.ParentUUID, tc.parentUUID, "book parent_uuid mismatch")
+			} else {
+				assert.Equal(t, bookCount, 0, "book count mismatch")
+			}
+		})
+	}
+}
+
+func TestCreateBook_duplicateInAnotherParent(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+	b1 := database.Book{UserID: user.ID, Label: "infra"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	b2 := database.Book{UserID: user.ID, Label: "k8s"}
+	testutils.MustExec(t, db.Save(&b2), "preparing b2")
+
+	// Execute
+	payload := fmt.Sprintf(`{"name": "k8s", "parent_uuid": "%s"}`, b1.UUID)
+	req := testutils.MakeReq(server, "POST", "/v3/books", payload)
+	res := testutils.HTTPAuthDo(t, req, user)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusCreated, "")
+
+	var bookCount int
+	testutils.MustExec(t, db.Model(&database.Book{}).Where("label = ?", "k8s").Count(&bookCount), "counting books")
+	assert.Equal(t, bookCount, 2, "book count mismatch")
+}
+
+func TestUpdateBook_parent(t *testing.T) {
+	b1UUID := "ead8790f-aff9-4bdf-8eec-f734ccd29202"
+	b2UUID := "0ecaac96-8d72-4e04-8925-5a21b79a16da"
+	b3UUID := "7c9a8c1e-2d33-4c4b-9f2a-3f0a6d3a4e11"
+
+	testCases := []struct {
+		name               string
+		bookUUID           string
+		parentUUID         string
+		expectedStatus     int
+		expectedParentUUID string
+	}{
+		{
+			name:               "into another book",
+			bookUUID:           b3UUID,
+			parentUUID:         b2UUID,
+			expectedStatus:     http.StatusOK,
+			expectedParentUUID: b2UUID,
+		},
+		{
+			name:               "to the top level",
+			bookUUID:           b2UUID,
+			parentUUID:         "",
+			expectedStatus:     http.StatusOK,
+			expectedParentUUID: "",
+		},
+		{
+			name:               "into itself",
+			bookUUID:           b1UUID,
+			parentUUID:         b1UUID,
+			expectedStatus:     http.StatusBadRequest,
+			expectedParentUUID: "",
+		},
+		{
+			name:               "into a nested book",
+			bookUUID:           b1UUID,
+			parentUUID:         b2UUID,
+			expectedStatus:     http.StatusBadRequest,
+			expectedParentUUID: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			server := httptest.NewServer(NewRouter(&App{
+				Clock: clock.NewMock(),
+			}))
+			defer server.Close()
+
+			user := testutils.SetupUserData()
+
+			// b2 is nested in b1
+			b1 := database.Book{UUID: b1UUID, UserID: user.ID, Label: "infra"}
+			testutils.MustExec(t, db.Save(&b1), "preparing b1")
+			b2 := database.Book{UUID: b2UUID, UserID: user.ID, Label: "k8s", ParentUUID: b1UUID}
+			testutils.MustExec(t, db.Save(&b2), "preparing b2")
+			b3 := database.Book{UUID: b3UUID, UserID: user.ID, Label: "networking"}
+			testutils.MustExec(t, db.Save(&b3), "preparing b3")
+
+			// Execute
+			endpoint := fmt.Sprintf("/v3/books/%s", tc.bookUUID)
+			payload := fmt.Sprintf(`{"parent_uuid": "%s"}`, tc.parentUUID)
+			req := testutils.MakeReq(server, "PATCH", endpoint, payload)
+			res := testutils.HTTPAuthDo(t, req, user)
+
+			// Test
+			assert.StatusCodeEquals(t, res, tc.expectedStatus, "")
+
+			var bookRecord database.Book
+			testutils.MustExec(t, db.Where("uuid = ?", tc.bookUUID).First(&bookRecord), "finding book")
+			assert.Equal(t, bookRecord.ParentUUID, tc.expectedParentUUID, "book parent_uuid mismatch")
+		})
+	}
+}
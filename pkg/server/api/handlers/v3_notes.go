@@ -22,12 +22,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/dnote/dnote/pkg/server/api/helpers"
 	"github.com/dnote/dnote/pkg/server/api/operations"
 	"github.com/dnote/dnote/pkg/server/api/presenters"
 	"github.com/dnote/dnote/pkg/server/database"
 	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
 	"github.com/pkg/errors"
 )
 
@@ -45,6 +47,37 @@ func validateUpdateNotePayload(p updateNotePayload) bool {
 	return p.BookUUID != nil || p.Content != nil
 }
 
+// findEditableNote finds the note with the given uuid that the user can edit, either
+// as the owner or as a member of the book containing it. It writes a response and
+// returns false if such note cannot be found.
+func findEditableNote(w http.ResponseWriter, conn *gorm.DB, noteUUID string, user database.User) (database.Note, bool) {
+	var note database.Note
+	c := conn.Where("uuid = ?", noteUUID).Preload("Book").First(&note)
+	if c.RecordNotFound() {
+		http.Error(w, "not found", http.StatusNotFound)
+		return note, false
+	} else if err := c.Error; err != nil {
+		handleError(w, "finding note", err, http.StatusInternalServerError)
+		return note, false
+	}
+
+	role, err := operations.GetBookRole(conn, user.ID, note.Book)
+	if err != nil {
+		handleError(w, "getting book role", err, http.StatusInternalServerError)
+		return note, false
+	}
+	if role == "" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return note, false
+	}
+	if !operations.CanEditNotes(role) {
+		http.Error(w, "you do not have permission to edit notes in this book", http.StatusForbidden)
+		return note, false
+	}
+
+	return note, true
+}
+
 // UpdateNote updates note
 func (a *App) UpdateNote(w http.ResponseWriter, r *http.Request) {
 	db := database.DBConn
@@ -69,12 +102,29 @@ func (a *App) UpdateNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var note database.Note
-	if err := db.Where("uuid = ? AND user_id = ?", noteUUID, user.ID).First(&note).Error; err != nil {
-		handleError(w, "finding note", err, http.StatusInternalServerError)
+	note, ok := findEditableNote(w, db, noteUUID, user)
+	if !ok {
 		return
 	}
 
+	if params.BookUUID != nil && *params.BookUUID != note.BookUUID {
+		dest, role, ok := findBook(w, db, *params.BookUUID, user)
+		if !ok {
+			return
+		}
+		if !operations.CanEditNotes(role) {
+			http.Error(w, "you do not have permission to edit notes in the destination book", http.StatusForbidden)
+			return
+		}
+		if dest.UserID != note.UserID {
+			http.Error(w, "notes cannot be moved to a book with a different owner", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// do not save the preloaded association along with the note
+	note.Book = database.Book{}
+
 	tx := db.Begin()
 
 	note, err = operations.UpdateNote(tx, user, a.Clock, note, params.BookUUID, params.Content)
@@ -85,7 +135,7 @@ func (a *App) UpdateNote(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var book database.Book
-	if err := tx.Where("uuid = ? AND user_id = ?", note.BookUUID, user.ID).First(&book).Error; err != nil {
+	if err := tx.Where("uuid = ?", note.BookUUID).First(&book).Error; err != nil {
 		tx.Rollback()
 		handleError(w, fmt.Sprintf("finding book %s to preload", note.BookUUID), err, http.StatusInternalServerError)
 		return
@@ -122,15 +172,20 @@ func (a *App) DeleteNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var note database.Note
-	if err := db.Where("uuid = ? AND user_id = ?", noteUUID, user.ID).Preload("Book").First(&note).Error; err != nil {
-		handleError(w, "finding note", err, http.StatusInternalServerError)
+	note, ok := findEditableNote(w, db, noteUUID, user)
+	if !ok {
+		return
+	}
+
+	hashes, err := findBlobHashes(db, "note_uuid = ?", note.UUID)
+	if err != nil {
+		handleError(w, "finding attachments", err, http.StatusInternalServerError)
 		return
 	}
 
 	tx := db.Begin()
 
-	n, err := operations.DeleteNote(tx, user, note)
+	n, err := operations.DeleteNote(tx, user, a.Clock, note)
 	if err != nil {
 		tx.Rollback()
 		handleError(w, "deleting note", err, http.StatusInternalServerError)
@@ -139,6 +194,8 @@ func (a *App) DeleteNote(w http.ResponseWriter, r *http.Request) {
 
 	tx.Commit()
 
+	a.collectBlobs(hashes)
+
 	resp := deleteNoteResp{
 		Status: http.StatusNoContent,
 		Result: presenters.PresentNote(n),
@@ -187,10 +244,13 @@ func (a *App) CreateNote(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var book database.Book
 	db := database.DBConn
-	if err := db.Where("uuid = ? AND user_id = ?", params.BookUUID, user.ID).First(&book).Error; err != nil {
-		handleError(w, "finding book", err, http.StatusInternalServerError)
+	book, role, ok := findBook(w, db, params.BookUUID, user)
+	if !ok {
+		return
+	}
+	if !operations.CanEditNotes(role) {
+		http.Error(w, "you do not have permission to add notes to this book", http.StatusForbidden)
 		return
 	}
 
@@ -210,6 +270,169 @@ func (a *App) CreateNote(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusCreated, resp)
 }
 
+type shareNotePayload struct {
+	// ExpiresAt is a unix timestamp in seconds after which the share link expires
+	ExpiresAt *int64 `json:"expires_at"`
+}
+
+type shareNoteResp struct {
+	Result presenters.NoteShare `json:"result"`
+}
+
+func (a *App) parseShareNotePayload(r *http.Request) (*time.Time, error) {
+	var params shareNotePayload
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			return nil, errors.Wrap(err, "decoding payload")
+		}
+	}
+
+	if params.ExpiresAt == nil {
+		return nil, nil
+	}
+
+	expiresAt := time.Unix(*params.ExpiresAt, 0).UTC()
+	if !expiresAt.After(a.Clock.Now()) {
+		return nil, errors.New("expires_at must be in the future")
+	}
+
+	return &expiresAt, nil
+}
+
+// ShareNote makes a note publicly accessible through its share link
+func (a *App) ShareNote(w http.ResponseWriter, r *http.Request) {
+	db := database.DBConn
+	vars := mux.Vars(r)
+	noteUUID := vars["noteUUID"]
+
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	expiresAt, err := a.parseShareNotePayload(r)
+	if err != nil {
+		handleError(w, "invalid payload", err, http.StatusBadRequest)
+		return
+	}
+
+	allowed, err := operations.CanSharePublicly(db, user)
+	if err != nil {
+		handleError(w, "checking organization policy", err, http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, "public notes are disabled by your organization", http.StatusForbidden)
+		return
+	}
+
+	var note database.Note
+	conn := db.Where("uuid = ? AND user_id = ? AND NOT deleted", noteUUID, user.ID).First(&note)
+	if conn.RecordNotFound() {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	} else if err := conn.Error; err != nil {
+		handleError(w, "finding note", err, http.StatusInternalServerError)
+		return
+	}
+
+	tx := db.Begin()
+
+	note, err = operations.ShareNote(tx, user, a.Clock, note, expiresAt)
+	if err != nil {
+		tx.Rollback()
+		handleError(w, "sharing note", err, http.StatusInternalServerError)
+		return
+	}
+
+	tx.Commit()
+
+	resp := shareNoteResp{
+		Result: presenters.PresentNoteShare(note),
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// UnshareNote revokes the share link of a note
+func (a *App) UnshareNote(w http.ResponseWriter, r *http.Request) {
+	db := database.DBConn
+	vars := mux.Vars(r)
+	noteUUID := vars["noteUUID"]
+
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	var note database.Note
+	conn := db.Where("uuid = ? AND user_id = ? AND NOT deleted", noteUUID, user.ID).First(&note)
+	if conn.RecordNotFound() {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	} else if err := conn.Error; err != nil {
+		handleError(w, "finding note", err, http.StatusInternalServerError)
+		return
+	}
+
+	tx := db.Begin()
+
+	note, err := operations.UnshareNote(tx, user, a.Clock, note)
+	if err != nil {
+		tx.Rollback()
+		handleError(w, "unsharing note", err, http.StatusInternalServerError)
+		return
+	}
+
+	tx.Commit()
+
+	resp := shareNoteResp{
+		Result: presenters.PresentNoteShare(note),
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// GetNoteBacklinks responds with the notes that link to a note. Only the notes that
+// the user can read are included.
+func (a *App) GetNoteBacklinks(w http.ResponseWriter, r *http.Request) {
+	db := database.DBConn
+	vars := mux.Vars(r)
+	noteUUID := vars["noteUUID"]
+
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	if ok := helpers.ValidateUUID(noteUUID); !ok {
+		http.Error(w, "invalid uuid", http.StatusBadRequest)
+		return
+	}
+
+	var note database.Note
+	conn := getNoteBaseQuery(noteUUID, user.ID, "").Where("NOT notes.deleted").First(&note)
+	if conn.RecordNotFound() {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	} else if err := conn.Error; err != nil {
+		handleError(w, "finding note", err, http.StatusInternalServerError)
+		return
+	}
+
+	var notes []database.Note
+	query := fmt.Sprintf("notes.uuid IN (SELECT source_uuid FROM note_links WHERE target_uuid = ?) AND NOT notes.deleted AND (notes.user_id = ? OR notes.book_uuid IN (%s))", sharedBookUUIDsQuery)
+	if err := preloadNote(db.Where(query, note.UUID, user.ID, user.ID)).
+		Order("notes.added_on ASC").
+		Find(&notes).Error; err != nil {
+		handleError(w, "finding backlinks", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, presenters.PresentNotes(notes))
+}
+
 // NotesOptions is a handler for OPTIONS endpoint for notes
 func (a *App) NotesOptions(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Methods", "POST")
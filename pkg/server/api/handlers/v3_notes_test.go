@@ -19,15 +19,19 @@
 package handlers
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/dnote/dnote/pkg/assert"
 	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/api/presenters"
 	"github.com/dnote/dnote/pkg/server/database"
 	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
 )
 
 func init() {
@@ -320,3 +324,206 @@ func TestDeleteNote(t *testing.T) {
 		})
 	}
 }
+
+func TestShareNote(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	c := clock.NewMock()
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: c,
+	}))
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&user).Update("max_usn", 101), "preparing user max_usn")
+
+	b1 := database.Book{
+		UserID: user.ID,
+		Label:  "js",
+	}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	n1 := database.Note{
+		UserID:   user.ID,
+		BookUUID: b1.UUID,
+		Body:     "n1 content",
+		USN:      11,
+	}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+
+	expiresAt := c.Now().Add(24 * time.Hour)
+
+	// Execute
+	dat := fmt.Sprintf(`{"expires_at": %d}`, expiresAt.Unix())
+	req := testutils.MakeReq(server, "POST", fmt.Sprintf("/v3/notes/%s/share", n1.UUID), dat)
+	res := testutils.HTTPAuthDo(t, req, user)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusOK, "")
+
+	var noteRecord database.Note
+	var userRecord database.User
+	testutils.MustExec(t, db.Where("id = ?", n1.ID).First(&noteRecord), "finding note")
+	testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), "finding user record")
+
+	assert.Equal(t, noteRecord.Public, true, "note public mismatch")
+	assert.Equal(t, noteRecord.PublicExpiresAt.Unix(), expiresAt.Unix(), "note public_expires_at mismatch")
+	assert.Equal(t, noteRecord.Body, "n1 content", "note content mismatch")
+	assert.Equal(t, noteRecord.USN, 102, "note usn mismatch")
+	assert.Equal(t, userRecord.MaxUSN, 102, "user max_usn mismatch")
+}
+
+func TestShareNote_BadRequest(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	c := clock.NewMock()
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: c,
+	}))
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&user).Update("max_usn", 101), "preparing user max_usn")
+
+	b1 := database.Book{
+		UserID: user.ID,
+		Label:  "js",
+	}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	n1 := database.Note{
+		UserID:   user.ID,
+		BookUUID: b1.UUID,
+		Body:     "n1 content",
+		USN:      11,
+	}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+
+	// Execute
+	dat := fmt.Sprintf(`{"expires_at": %d}`, c.Now().Add(-time.Hour).Unix())
+	req := testutils.MakeReq(server, "POST", fmt.Sprintf("/v3/notes/%s/share", n1.UUID), dat)
+	res := testutils.HTTPAuthDo(t, req, user)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusBadRequest, "")
+
+	var noteRecord database.Note
+	var userRecord database.User
+	testutils.MustExec(t, db.Where("id = ?", n1.ID).First(&noteRecord), "finding note")
+	testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), "finding user record")
+
+	assert.Equal(t, noteRecord.Public, false, "note public mismatch")
+	assert.Equal(t, noteRecord.USN, 11, "note usn mismatch")
+	assert.Equal(t, userRecord.MaxUSN, 101, "user max_usn mismatch")
+}
+
+func TestUnshareNote(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	c := clock.NewMock()
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: c,
+	}))
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&user).Update("max_usn", 101), "preparing user max_usn")
+
+	b1 := database.Book{
+		UserID: user.ID,
+		Label:  "js",
+	}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+
+	expiresAt := c.Now().Add(24 * time.Hour)
+	n1 := database.Note{
+		UserID:          user.ID,
+		BookUUID:        b1.UUID,
+		Body:            "n1 content",
+		USN:             11,
+		Public:          true,
+		PublicExpiresAt: &expiresAt,
+	}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+
+	// Execute
+	req := testutils.MakeReq(server, "DELETE", fmt.Sprintf("/v3/notes/%s/share", n1.UUID), "")
+	res := testutils.HTTPAuthDo(t, req, user)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusOK, "")
+
+	var noteRecord database.Note
+	var userRecord database.User
+	testutils.MustExec(t, db.Where("id = ?", n1.ID).First(&noteRecord), "finding note")
+	testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), "finding user record")
+
+	assert.Equal(t, noteRecord.Public, false, "note public mismatch")
+	assert.Equal(t, noteRecord.PublicExpiresAt, (*time.Time)(nil), "note public_expires_at mismatch")
+	assert.Equal(t, noteRecord.USN, 102, "note usn mismatch")
+	assert.Equal(t, userRecord.MaxUSN, 102, "user max_usn mismatch")
+}
+
+func TestGetNoteBacklinks(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+	anotherUser := testutils.SetupUserData()
+
+	b1 := database.Book{UserID: user.ID, Label: "js"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	b2 := database.Book{UserID: anotherUser.ID, Label: "js"}
+	testutils.MustExec(t, db.Save(&b2), "preparing b2")
+
+	n1 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "closures", AddedOn: 1}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+	n2 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "see [[js/closures]]", AddedOn: 2}
+	testutils.MustExec(t, db.Save(&n2), "preparing n2")
+	n3 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "", AddedOn: 3, Deleted: true}
+	testutils.MustExec(t, db.Save(&n3), "preparing n3")
+	n4 := database.Note{UserID: anotherUser.ID, BookUUID: b2.UUID, Body: fmt.Sprintf("[[%s]]", n1.UUID), AddedOn: 4}
+	testutils.MustExec(t, db.Save(&n4), "preparing n4")
+
+	for _, source := range []database.Note{n2, n3, n4} {
+		link := database.NoteLink{SourceUUID: source.UUID, TargetUUID: n1.UUID, Text: "link"}
+		testutils.MustExec(t, db.Save(&link), "preparing link")
+	}
+
+	t.Run("owner", func(t *testing.T) {
+		// Execute
+		req := testutils.MakeReq(server, "GET", fmt.Sprintf("/v3/notes/%s/backlinks", n1.UUID), "")
+		res := testutils.HTTPAuthDo(t, req, user)
+
+		// Test
+		assert.StatusCodeEquals(t, res, http.StatusOK, "")
+
+		var payload []presenters.Note
+		if err := json.NewDecoder(res.Body).Decode(&payload); err != nil {
+			t.Fatal(errors.Wrap(err, "decoding payload"))
+		}
+
+		assert.Equal(t, len(payload), 1, "payload length mismatch")
+		assert.Equal(t, payload[0].UUID, n2.UUID, "backlink uuid mismatch")
+		assert.Equal(t, payload[0].Book.Label, "js", "backlink book mismatch")
+	})
+
+	t.Run("stranger", func(t *testing.T) {
+		// Execute
+		req := testutils.MakeReq(server, "GET", fmt.Sprintf("/v3/notes/%s/backlinks", n1.UUID), "")
+		res := testutils.HTTPAuthDo(t, req, anotherUser)
+
+		// Test
+		assert.StatusCodeEquals(t, res, http.StatusNotFound, "")
+	})
+}
@@ -0,0 +1,160 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/dnote/dnote/pkg/server/api/helpers"
+	"github.com/dnote/dnote/pkg/server/api/presenters"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+var publicNoteTmpl = template.Must(template.New("public_note").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.BookLabel}} - Dnote</title>
+<style>
+body { margin: 0; background: #f7f7f7; color: #333; font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; }
+main { max-width: 720px; margin: 40px auto; padding: 24px 32px; background: #fff; border: 1px solid #e4e4e4; border-radius: 4px; }
+header { color: #777; font-size: 14px; border-bottom: 1px solid #eee; padding-bottom: 12px; margin-bottom: 16px; }
+.content { white-space: pre-wrap; word-wrap: break-word; line-height: 1.6; font-size: 16px; }
+footer { max-width: 720px; margin: 0 auto 40px; color: #999; font-size: 12px; text-align: center; }
+</style>
+</head>
+<body>
+<main>
+<header><strong>{{.BookLabel}}</strong> &middot; {{.AddedOn}}</header>
+<div class="content">{{.Body}}</div>
+</main>
+<footer>Shared with Dnote</footer>
+</body>
+</html>
+`))
+
+type publicNoteTmplData struct {
+	BookLabel string
+	AddedOn   string
+	Body      string
+}
+
+// findPublicNote finds a note that is accessible through its share link at the
+// given time. It returns false if no such note exists.
+func findPublicNote(noteUUID string, now time.Time) (database.Note, bool, error) {
+	var note database.Note
+
+	if ok := helpers.ValidateUUID(noteUUID); !ok {
+		return note, false, nil
+	}
+
+	db := database.DBConn
+	conn := db.Where("notes.uuid = ? AND notes.public AND NOT notes.deleted", noteUUID).
+		Where("notes.public_expires_at IS NULL OR notes.public_expires_at > ?", now).
+		Where("notes.user_id NOT IN (SELECT users.id FROM users INNER JOIN organizations ON organizations.id = users.organization_id WHERE organizations.disable_public_notes)").
+		Preload("Book").
+		First(&note)
+	if conn.RecordNotFound() {
+		return note, false, nil
+	} else if err := conn.Error; err != nil {
+		return note, false, errors.Wrap(err, "finding note")
+	}
+
+	return note, true, nil
+}
+
+// viewPublicNote records a view of the public note and returns the note with
+// the updated view count
+func viewPublicNote(note database.Note) (database.Note, error) {
+	db := database.DBConn
+
+	if err := db.Model(&note).UpdateColumn("view_count", gorm.Expr("view_count + 1")).Error; err != nil {
+		return note, errors.Wrap(err, "incrementing view count")
+	}
+
+	note.ViewCount = note.ViewCount + 1
+
+	return note, nil
+}
+
+// GetPublicNote returns a note that has been shared publicly. It does not
+// require authentication.
+func (a *App) GetPublicNote(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	noteUUID := vars["noteUUID"]
+
+	note, ok, err := findPublicNote(noteUUID, a.Clock.Now())
+	if err != nil {
+		handleError(w, "finding public note", err, http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	note, err = viewPublicNote(note)
+	if err != nil {
+		handleError(w, "viewing public note", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, presenters.PresentPublicNote(note))
+}
+
+// renderPublicNote renders an HTML page for a note that has been shared publicly
+func (a *App) renderPublicNote(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	noteUUID := vars["noteUUID"]
+
+	note, ok, err := findPublicNote(noteUUID, a.Clock.Now())
+	if err != nil {
+		handleError(w, "finding public note", err, http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	note, err = viewPublicNote(note)
+	if err != nil {
+		handleError(w, "viewing public note", err, http.StatusInternalServerError)
+		return
+	}
+
+	data := publicNoteTmplData{
+		BookLabel: note.Book.Label,
+		AddedOn:   time.Unix(0, note.AddedOn).UTC().Format("Jan 2, 2006"),
+		Body:      note.Body,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := publicNoteTmpl.Execute(w, data); err != nil {
+		handleError(w, "executing template", err, http.StatusInternalServerError)
+		return
+	}
+}
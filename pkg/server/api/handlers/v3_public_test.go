@@ -0,0 +1,167 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/api/presenters"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func setupPublicNotes(t *testing.T, c clock.Clock) (database.Note, database.Note, database.Note, database.Note) {
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+
+	b1 := database.Book{
+		UserID: user.ID,
+		Label:  "js",
+	}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+
+	future := c.Now().Add(time.Hour)
+	past := c.Now().Add(-time.Hour)
+
+	public := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "public content", Public: true, PublicExpiresAt: &future}
+	testutils.MustExec(t, db.Save(&public), "preparing public note")
+	private := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "private content", Public: false}
+	testutils.MustExec(t, db.Save(&private), "preparing private note")
+	expired := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "expired content", Public: true, PublicExpiresAt: &past}
+	testutils.MustExec(t, db.Save(&expired), "preparing expired note")
+	deleted := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "", Public: true, Deleted: true}
+	testutils.MustExec(t, db.Save(&deleted), "preparing deleted note")
+
+	return public, private, expired, deleted
+}
+
+func TestGetPublicNote(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	c := clock.NewMock()
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: c,
+	}))
+	defer server.Close()
+
+	public, _, _, _ := setupPublicNotes(t, c)
+
+	// Execute
+	req := testutils.MakeReq(server, "GET", fmt.Sprintf("/v3/public/notes/%s", public.UUID), "")
+	res := testutils.HTTPDo(t, req)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusOK, "")
+
+	var payload presenters.PublicNote
+	if err := json.NewDecoder(res.Body).Decode(&payload); err != nil {
+		t.Fatal(errors.Wrap(err, "decoding payload"))
+	}
+
+	assert.Equal(t, payload.UUID, public.UUID, "uuid mismatch")
+	assert.Equal(t, payload.Body, "public content", "content mismatch")
+	assert.Equal(t, payload.BookLabel, "js", "book label mismatch")
+	assert.Equal(t, payload.ViewCount, 1, "view count mismatch")
+
+	var noteRecord database.Note
+	testutils.MustExec(t, db.Where("id = ?", public.ID).First(&noteRecord), "finding note")
+	assert.Equal(t, noteRecord.ViewCount, 1, "view_count mismatch")
+}
+
+func TestGetPublicNote_NotFound(t *testing.T) {
+	defer testutils.ClearData()
+
+	// Setup
+	c := clock.NewMock()
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: c,
+	}))
+	defer server.Close()
+
+	_, private, expired, deleted := setupPublicNotes(t, c)
+
+	testCases := []string{
+		private.UUID,
+		expired.UUID,
+		deleted.UUID,
+		"not-a-uuid",
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc, func(t *testing.T) {
+			// Execute
+			req := testutils.MakeReq(server, "GET", fmt.Sprintf("/v3/public/notes/%s", tc), "")
+			res := testutils.HTTPDo(t, req)
+
+			// Test
+			assert.StatusCodeEquals(t, res, http.StatusNotFound, "")
+		})
+	}
+}
+
+func TestRenderPublicNote(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	c := clock.NewMock()
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: c,
+	}))
+	defer server.Close()
+
+	public, _, expired, _ := setupPublicNotes(t, c)
+	testutils.MustExec(t, db.Model(&public).Update("body", "<script>alert(1)</script>"), "preparing note body")
+
+	t.Run("public", func(t *testing.T) {
+		req := testutils.MakeReq(server, "GET", fmt.Sprintf("/public/notes/%s", public.UUID), "")
+		res := testutils.HTTPDo(t, req)
+
+		assert.StatusCodeEquals(t, res, http.StatusOK, "")
+		assert.Equal(t, res.Header.Get("Content-Type"), "text/html; charset=utf-8", "Content-Type mismatch")
+
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "reading body"))
+		}
+
+		assert.Equal(t, strings.Contains(string(body), "&lt;script&gt;alert(1)&lt;/script&gt;"), true, "content should be escaped")
+		assert.Equal(t, strings.Contains(string(body), "<script>"), false, "content should not be rendered as HTML")
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		req := testutils.MakeReq(server, "GET", fmt.Sprintf("/public/notes/%s", expired.UUID), "")
+		res := testutils.HTTPDo(t, req)
+
+		assert.StatusCodeEquals(t, res, http.StatusNotFound, "")
+	})
+}
@@ -0,0 +1,116 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/dnote/dnote/pkg/server/api/helpers"
+	"github.com/dnote/dnote/pkg/server/api/operations"
+	"github.com/dnote/dnote/pkg/server/api/presenters"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/gorilla/mux"
+)
+
+// getSessions returns the active sessions of the user, most recently used first
+func (a *App) getSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	db := database.DBConn
+
+	current, err := findRequestSession(db, r, user)
+	if err != nil {
+		handleError(w, "finding session", err, http.StatusInternalServerError)
+		return
+	}
+
+	var sessions []database.Session
+	if err := db.Where("user_id = ? AND expires_at > ?", user.ID, a.Clock.Now()).
+		Order("last_used_at DESC").Find(&sessions).Error; err != nil {
+		handleError(w, "finding sessions", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, presenters.PresentSessions(sessions, current.ID))
+}
+
+// deleteSession revokes a session of the user
+func (a *App) deleteSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	sessionUUID := mux.Vars(r)["sessionUUID"]
+	if ok := helpers.ValidateUUID(sessionUUID); !ok {
+		http.Error(w, "invalid uuid", http.StatusBadRequest)
+		return
+	}
+
+	db := database.DBConn
+
+	var session database.Session
+	conn := db.Where("uuid = ? AND user_id = ?", sessionUUID, user.ID).First(&session)
+	if conn.RecordNotFound() {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	} else if err := conn.Error; err != nil {
+		handleError(w, "finding session", err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.Delete(&session).Error; err != nil {
+		handleError(w, "deleting session", err, http.StatusInternalServerError)
+		return
+	}
+
+	a.recordAuditEvent(db, r, user.ID, user.ID, database.AuditEventSessionRevoked)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteOtherSessions revokes all sessions of the user except for the session
+// used for the request
+func (a *App) deleteOtherSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	db := database.DBConn
+
+	current, err := findRequestSession(db, r, user)
+	if err != nil {
+		handleError(w, "finding session", err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := operations.DeleteOtherSessions(db, user.ID, current.ID); err != nil {
+		handleError(w, "deleting sessions", err, http.StatusInternalServerError)
+		return
+	}
+
+	a.recordAuditEvent(db, r, user.ID, user.ID, database.AuditEventSessionRevoked)
+	w.WriteHeader(http.StatusNoContent)
+}
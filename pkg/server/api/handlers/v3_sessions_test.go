@@ -0,0 +1,235 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/api/presenters"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestGetSessions(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	c := clock.NewMock()
+	c.SetNow(time.Now())
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: c,
+	}))
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+	anotherUser := testutils.SetupUserData()
+
+	s1 := database.Session{
+		Key:        "key1",
+		UserID:     user.ID,
+		DeviceName: "laptop",
+		ClientType: "cli",
+		IPAddress:  "10.0.0.1",
+		LastUsedAt: time.Now().Add(-time.Hour),
+		ExpiresAt:  time.Now().Add(time.Hour),
+	}
+	testutils.MustExec(t, db.Save(&s1), "preparing s1")
+	s2 := database.Session{Key: "key2", UserID: user.ID, ExpiresAt: time.Now().Add(-time.Hour)}
+	testutils.MustExec(t, db.Save(&s2), "preparing expired s2")
+	s3 := database.Session{Key: "key3", UserID: anotherUser.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	testutils.MustExec(t, db.Save(&s3), "preparing s3 of another user")
+
+	// Execute
+	req := testutils.MakeReq(server, "GET", "/v3/sessions", "")
+	res := testutils.HTTPAuthDo(t, req, user)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusOK, "")
+
+	var got []presenters.Session
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(errors.Wrap(err, "decoding payload"))
+	}
+
+	assert.Equal(t, len(got), 2, "session count mismatch")
+	assert.Equal(t, got[0].Current, true, "got[0] should be the current session")
+	assert.Equal(t, got[1].UUID, s1.UUID, "got[1] uuid mismatch")
+	assert.Equal(t, got[1].Current, false, "got[1] current mismatch")
+	assert.Equal(t, got[1].DeviceName, "laptop", "got[1] device name mismatch")
+	assert.Equal(t, got[1].ClientType, "cli", "got[1] client type mismatch")
+	assert.Equal(t, got[1].IPAddress, "10.0.0.1", "got[1] ip address mismatch")
+}
+
+func TestDeleteSession(t *testing.T) {
+	t.Run("own session", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		// Setup
+		server := httptest.NewServer(NewRouter(&App{
+			Clock: clock.NewMock(),
+		}))
+		defer server.Close()
+
+		user := testutils.SetupUserData()
+		session := database.Session{Key: "key1", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+		testutils.MustExec(t, db.Save(&session), "preparing session")
+
+		// Execute
+		req := testutils.MakeReq(server, "DELETE", fmt.Sprintf("/v3/sessions/%s", session.UUID), "")
+		res := testutils.HTTPAuthDo(t, req, user)
+
+		// Test
+		assert.StatusCodeEquals(t, res, http.StatusNoContent, "")
+
+		var count, eventCount int
+		testutils.MustExec(t, db.Model(&database.Session{}).Where("id = ?", session.ID).Count(&count), "counting session")
+		testutils.MustExec(t, db.Model(&database.AuditEvent{}).Where("type = ?", database.AuditEventSessionRevoked).Count(&eventCount), "counting audit events")
+		assert.Equal(t, count, 0, "session should be deleted")
+		assert.Equal(t, eventCount, 1, "audit event count mismatch")
+	})
+
+	t.Run("session of another user", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		// Setup
+		server := httptest.NewServer(NewRouter(&App{
+			Clock: clock.NewMock(),
+		}))
+		defer server.Close()
+
+		user := testutils.SetupUserData()
+		anotherUser := testutils.SetupUserData()
+		session := database.Session{Key: "key1", UserID: anotherUser.ID, ExpiresAt: time.Now().Add(time.Hour)}
+		testutils.MustExec(t, db.Save(&session), "preparing session")
+
+		// Execute
+		req := testutils.MakeReq(server, "DELETE", fmt.Sprintf("/v3/sessions/%s", session.UUID), "")
+		res := testutils.HTTPAuthDo(t, req, user)
+
+		// Test
+		assert.StatusCodeEquals(t, res, http.StatusNotFound, "")
+
+		var count int
+		testutils.MustExec(t, db.Model(&database.Session{}).Where("id = ?", session.ID).Count(&count), "counting session")
+		assert.Equal(t, count, 1, "session should not be deleted")
+	})
+}
+
+func TestDeleteOtherSessions(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+	anotherUser := testutils.SetupUserData()
+	s1 := database.Session{Key: "key1", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	testutils.MustExec(t, db.Save(&s1), "preparing s1")
+	s2 := database.Session{Key: "key2", UserID: anotherUser.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	testutils.MustExec(t, db.Save(&s2), "preparing s2")
+
+	// Execute
+	req := testutils.MakeReq(server, "DELETE", "/v3/sessions", "")
+	res := testutils.HTTPAuthDo(t, req, user)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusNoContent, "")
+
+	var userCount, anotherCount int
+	testutils.MustExec(t, db.Model(&database.Session{}).Where("user_id = ?", user.ID).Count(&userCount), "counting sessions")
+	testutils.MustExec(t, db.Model(&database.Session{}).Where("user_id = ?", anotherUser.ID).Count(&anotherCount), "counting sessions of another user")
+	assert.Equal(t, userCount, 1, "only the current session should remain")
+	assert.Equal(t, anotherCount, 1, "sessions of another user should not be affected")
+}
+
+func TestSignIn_SessionInfo(t *testing.T) {
+	testCases := []struct {
+		header             string
+		headerValue        string
+		deviceName         string
+		userAgent          string
+		expectedClientType string
+		expectedDeviceName string
+	}{
+		{
+			header:             "CLI-Version",
+			headerValue:        "0.1.0",
+			deviceName:         "laptop",
+			userAgent:          "Go-http-client/1.1",
+			expectedClientType: "cli",
+			expectedDeviceName: "laptop",
+		},
+		{
+			header:             "Origin",
+			headerValue:        "https://dnote.example.com",
+			deviceName:         "",
+			userAgent:          "Mozilla/5.0",
+			expectedClientType: "web",
+			expectedDeviceName: "Mozilla/5.0",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expectedClientType, func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			server := httptest.NewServer(NewRouter(&App{
+				Clock: clock.NewMock(),
+			}))
+			defer server.Close()
+
+			user := testutils.SetupUserData()
+			testutils.SetupAccountData(user, "alice@example.com", "pass1234")
+
+			// Execute
+			dat := fmt.Sprintf(`{"email": "alice@example.com", "password": "pass1234", "device_name": "%s"}`, tc.deviceName)
+			req := testutils.MakeReq(server, "POST", "/v3/signin", dat)
+			req.Header.Set(tc.header, tc.headerValue)
+			req.Header.Set("User-Agent", tc.userAgent)
+			res := testutils.HTTPDo(t, req)
+
+			// Test
+			assert.StatusCodeEquals(t, res, http.StatusOK, "")
+
+			var session database.Session
+			testutils.MustExec(t, db.Where("user_id = ?", user.ID).First(&session), "finding session")
+			assert.Equal(t, session.ClientType, tc.expectedClientType, "client type mismatch")
+			assert.Equal(t, session.DeviceName, tc.expectedDeviceName, "device name mismatch")
+			assert.NotEqual(t, session.IPAddress, "", "ip address mismatch")
+			assert.NotEqual(t, session.UUID, "", "uuid mismatch")
+		})
+	}
+}
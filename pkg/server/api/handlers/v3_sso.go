@@ -0,0 +1,302 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/dnote/dnote/pkg/server/api/operations"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/oidc"
+	"github.com/pkg/errors"
+)
+
+// ssoAuthRequestTTL is how long a person has to sign in with the identity provider
+const ssoAuthRequestTTL = 10 * time.Minute
+
+// ssoStateCookieName is the name of the cookie that binds the state of a signin
+// with the identity provider to the browser that started it, so that the signin
+// cannot be completed in another browser
+const ssoStateCookieName = "sso_state"
+
+// ErrSSOFailure is an error for a single sign-on that the identity provider did not complete
+var ErrSSOFailure = errors.New("Failed to sign in with your identity provider. Please try again")
+
+// requireSSO responds with 404 and returns false if the single sign-on is not enabled
+func (a *App) requireSSO(w http.ResponseWriter, r *http.Request) bool {
+	if a.OIDC == nil {
+		http.NotFound(w, r)
+		return false
+	}
+
+	return true
+}
+
+// signinWithSSO creates a session for the person signed in by the identity provider.
+// It responds and returns false if the signin must not proceed. The two-factor
+// authentication of the account is not asked, as the identity provider is
+// responsible for authenticating the person.
+func (a *App) signinWithSSO(w http.ResponseWriter, r *http.Request, claims oidc.Claims, deviceName string) (database.Session, bool) {
+	db := database.DBConn
+
+	user, account, err := operations.SigninWithSSO(db, claims, a.SSOAllowSignup, a.Clock.Now())
+	if err != nil {
+		if err == operations.ErrSSOEmailNotVerified || err == operations.ErrSignupNotAllowed || err == operations.ErrInvalidOrganizationInvitation {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return database.Session{}, false
+		}
+
+		handleError(w, "signing in with sso", err, http.StatusInternalServerError)
+		return database.Session{}, false
+	}
+	if user.DisabledAt != nil {
+		http.Error(w, ErrAccountDisabled.Error(), http.StatusForbidden)
+		return database.Session{}, false
+	}
+
+	if err := operations.TouchLastLoginAt(user, db); err != nil {
+		handleError(w, "touching login timestamp", err, http.StatusInternalServerError)
+		return database.Session{}, false
+	}
+
+	session, err := a.createSigninSession(db, user, account, a.getSessionInfo(r, deviceName))
+	if err != nil {
+		handleError(w, "creating session", err, http.StatusInternalServerError)
+		return database.Session{}, false
+	}
+
+	a.recordAuditEvent(db, r, user.ID, user.ID, database.AuditEventSignin)
+
+	return session, true
+}
+
+func (a *App) setSSOStateCookie(w http.ResponseWriter, state string, expires time.Time) {
+	cookie := http.Cookie{
+		Name:     ssoStateCookieName,
+		Value:    state,
+		Expires:  expires,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   a.TLS,
+	}
+
+	http.SetCookie(w, &cookie)
+}
+
+func (a *App) unsetSSOStateCookie(w http.ResponseWriter) {
+	cookie := http.Cookie{
+		Name:     ssoStateCookieName,
+		Value:    "",
+		MaxAge:   -1,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   a.TLS,
+	}
+
+	http.SetCookie(w, &cookie)
+}
+
+// checkSSOStateCookie returns true if the request has the state cookie for the given state
+func checkSSOStateCookie(r *http.Request, state string) bool {
+	c, err := r.Cookie(ssoStateCookieName)
+	if err != nil || c.Value == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(state)) == 1
+}
+
+// ssoLogin redirects the user to the identity provider to sign in
+func (a *App) ssoLogin(w http.ResponseWriter, r *http.Request) {
+	if ok := a.requireSSO(w, r); !ok {
+		return
+	}
+
+	req, err := oidc.NewAuthRequest()
+	if err != nil {
+		handleError(w, "making auth request", err, http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := a.OIDC.AuthCodeURL(req)
+	if err != nil {
+		handleError(w, "getting authorization url", err, http.StatusInternalServerError)
+		return
+	}
+
+	db := database.DBConn
+	now := a.Clock.Now()
+
+	// clean up the requests abandoned by the people who did not come back
+	if err := db.Where("expires_at < ?", now).Delete(&database.OIDCAuthRequest{}).Error; err != nil {
+		handleError(w, "deleting expired auth requests", err, http.StatusInternalServerError)
+		return
+	}
+
+	record := database.OIDCAuthRequest{
+		State:        req.State,
+		Nonce:        req.Nonce,
+		CodeVerifier: req.CodeVerifier,
+		ExpiresAt:    now.Add(ssoAuthRequestTTL),
+	}
+	if err := db.Create(&record).Error; err != nil {
+		handleError(w, "saving auth request", err, http.StatusInternalServerError)
+		return
+	}
+
+	a.setSSOStateCookie(w, req.State, record.ExpiresAt)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// ssoCallback completes the signin with the identity provider, which redirects
+// the user back with an authorization code
+func (a *App) ssoCallback(w http.ResponseWriter, r *http.Request) {
+	if ok := a.requireSSO(w, r); !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	state := q.Get("state")
+	code := q.Get("code")
+	if state == "" {
+		http.Error(w, ErrSSOFailure.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the signin must be completed in the browser that started it
+	validState := checkSSOStateCookie(r, state)
+	a.unsetSSOStateCookie(w)
+	if !validState {
+		http.Error(w, ErrSSOFailure.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db := database.DBConn
+
+	var record database.OIDCAuthRequest
+	conn := db.Where("state = ?", state).First(&record)
+	if conn.RecordNotFound() {
+		http.Error(w, ErrSSOFailure.Error(), http.StatusBadRequest)
+		return
+	} else if err := conn.Error; err != nil {
+		handleError(w, "finding auth request", err, http.StatusInternalServerError)
+		return
+	}
+
+	// an auth request can be used only once
+	if err := db.Delete(&record).Error; err != nil {
+		handleError(w, "deleting auth request", err, http.StatusInternalServerError)
+		return
+	}
+	if a.Clock.Now().After(record.ExpiresAt) || code == "" {
+		http.Error(w, ErrSSOFailure.Error(), http.StatusBadRequest)
+		return
+	}
+
+	claims, err := a.OIDC.Exchange(code, oidc.AuthRequest{
+		State:        record.State,
+		Nonce:        record.Nonce,
+		CodeVerifier: record.CodeVerifier,
+	})
+	if err != nil {
+		handleError(w, "exchanging authorization code", err, http.StatusUnauthorized)
+		return
+	}
+
+	session, ok := a.signinWithSSO(w, r, claims, "")
+	if !ok {
+		return
+	}
+
+	a.setSessionCookie(w, session.Key, session.ExpiresAt)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// startSSODevice starts a device authorization grant with the identity provider,
+// so that a client without a browser, such as the CLI, can sign in with a
+// browser on another device
+func (a *App) startSSODevice(w http.ResponseWriter, r *http.Request) {
+	if ok := a.requireSSO(w, r); !ok {
+		return
+	}
+
+	auth, err := a.OIDC.StartDeviceAuthorization()
+	if err == oidc.ErrDeviceGrantNotSupported {
+		http.Error(w, "The identity provider does not support signing in from another device", http.StatusNotImplemented)
+		return
+	} else if err != nil {
+		handleError(w, "starting device authorization", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, auth)
+}
+
+type ssoDeviceTokenPayload struct {
+	DeviceCode string `json:"device_code"`
+	// DeviceName is the name of the device that signs in, shown in the list of sessions
+	DeviceName string `json:"device_name"`
+}
+
+// SSODeviceError is a response for a device authorization that has not completed.
+// Error is one of authorization_pending, slow_down, access_denied and expired_token.
+type SSODeviceError struct {
+	Error string `json:"error"`
+}
+
+// pollSSODevice responds with a session if the user has approved the device
+// authorization, or with the reason why the client must keep polling or stop
+func (a *App) pollSSODevice(w http.ResponseWriter, r *http.Request) {
+	if ok := a.requireSSO(w, r); !ok {
+		return
+	}
+
+	var params ssoDeviceTokenPayload
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if params.DeviceCode == "" {
+		http.Error(w, "device_code is required", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := a.OIDC.PollDeviceToken(params.DeviceCode)
+	switch err {
+	case nil:
+	case oidc.ErrAuthorizationPending, oidc.ErrSlowDown, oidc.ErrAccessDenied, oidc.ErrExpiredToken:
+		respondJSON(w, http.StatusBadRequest, SSODeviceError{Error: err.Error()})
+		return
+	default:
+		handleError(w, "polling device token", err, http.StatusUnauthorized)
+		return
+	}
+
+	session, ok := a.signinWithSSO(w, r, claims, params.DeviceName)
+	if !ok {
+		return
+	}
+
+	a.writeSession(w, session, http.StatusOK)
+}
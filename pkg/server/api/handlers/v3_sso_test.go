@@ -0,0 +1,397 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/oidc"
+	"github.com/dnote/dnote/pkg/server/oidc/oidctest"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+)
+
+// setupSSO starts a mock identity provider and a server that signs in with it
+func setupSSO(t *testing.T, allowSignup bool) (*httptest.Server, *oidctest.Server, *App) {
+	idp := oidctest.NewServer("dnote")
+	idp.SetUser(oidctest.User{Subject: "user-1", Email: "alice@example.com", EmailVerified: true})
+
+	app := &App{
+		Clock:          clock.NewMock(),
+		SSOAllowSignup: allowSignup,
+	}
+	server := httptest.NewServer(NewRouter(app))
+
+	app.OIDC = oidc.New(oidc.Config{
+		Issuer:       idp.URL,
+		ClientID:     "dnote",
+		ClientSecret: "secret",
+		RedirectURL:  server.URL + "/v3/sso/callback",
+	})
+
+	return server, idp, app
+}
+
+// ssoSignin goes through the signin with the identity provider and returns the
+// response of the callback
+func ssoSignin(t *testing.T, server *httptest.Server) *http.Response {
+	res := testutils.HTTPDo(t, testutils.MakeReq(server, "GET", "/v3/sso/login", ""))
+	assert.StatusCodeEquals(t, res, http.StatusFound, "login status mismatch")
+
+	stateCookie := testutils.GetCookieByName(res.Cookies(), ssoStateCookieName)
+	if stateCookie == nil {
+		t.Fatal("state cookie is not set")
+	}
+
+	authReq, err := http.NewRequest("GET", res.Header.Get("Location"), nil)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "making authorization request"))
+	}
+	res = testutils.HTTPDo(t, authReq)
+	assert.StatusCodeEquals(t, res, http.StatusFound, "authorization status mismatch")
+
+	callbackReq, err := http.NewRequest("GET", res.Header.Get("Location"), nil)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "making callback request"))
+	}
+	callbackReq.AddCookie(stateCookie)
+
+	return testutils.HTTPDo(t, callbackReq)
+}
+
+func getSessionCookie(res *http.Response) string {
+	for _, c := range res.Cookies() {
+		if c.Name == "id" {
+			return c.Value
+		}
+	}
+
+	return ""
+}
+
+func TestSSOCallback(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server, idp, _ := setupSSO(t, true)
+	defer server.Close()
+	defer idp.Close()
+
+	// Execute
+	res := ssoSignin(t, server)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusFound, "")
+	assert.Equal(t, res.Header.Get("Location"), "/", "Location mismatch")
+
+	var account database.Account
+	var session database.Session
+	var requestCount int
+	testutils.MustExec(t, db.Where("email = ?", "alice@example.com").First(&account), "finding account")
+	testutils.MustExec(t, db.Where("user_id = ?", account.UserID).First(&session), "finding session")
+	testutils.MustExec(t, db.Model(&database.OIDCAuthRequest{}).Count(&requestCount), "counting auth requests")
+
+	assert.Equal(t, account.OIDCSubject, "user-1", "oidc_subject mismatch")
+	assert.Equal(t, getSessionCookie(res), session.Key, "session cookie mismatch")
+	assert.Equal(t, requestCount, 0, "auth request should be used once")
+}
+
+func TestSSOCallback_SignupNotAllowed(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server, idp, _ := setupSSO(t, false)
+	defer server.Close()
+	defer idp.Close()
+
+	// Execute
+	res := ssoSignin(t, server)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusForbidden, "")
+
+	var userCount, sessionCount int
+	testutils.MustExec(t, db.Model(&database.User{}).Count(&userCount), "counting users")
+	testutils.MustExec(t, db.Model(&database.Session{}).Count(&sessionCount), "counting sessions")
+
+	assert.Equal(t, userCount, 0, "user count mismatch")
+	assert.Equal(t, sessionCount, 0, "session count mismatch")
+}
+
+func TestSSOCallback_ClassicAccount(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server, idp, _ := setupSSO(t, false)
+	defer server.Close()
+	defer idp.Close()
+
+	user := testutils.SetupUserData()
+	account := testutils.SetupClassicAccountData(user, "alice@example.com")
+
+	// Execute
+	res := ssoSignin(t, server)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusFound, "")
+
+	var accountRecord database.Account
+	testutils.MustExec(t, db.Where("id = ?", account.ID).First(&accountRecord), "finding account")
+	assert.Equal(t, accountRecord.OIDCSubject, "user-1", "oidc_subject mismatch")
+	assert.Equal(t, accountRecord.AuthKeyHash, account.AuthKeyHash, "auth_key_hash mismatch")
+	assert.Equal(t, accountRecord.CipherKeyEnc, account.CipherKeyEnc, "cipher_key_enc mismatch")
+
+	// the account can still sign in with the key derived from the password
+	dat := fmt.Sprintf(`{"email": "%s", "auth_key": "%s"}`, "alice@example.com", "/XCYisXJ6/o+vf6NUEtmrdYzJYPz+T9oAUCtMpOjhzc=")
+	res = testutils.HTTPDo(t, testutils.MakeReq(server, "POST", "/classic/signin", dat))
+	assert.StatusCodeEquals(t, res, http.StatusOK, "classic signin status mismatch")
+}
+
+func TestSSOCallback_DisabledUser(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server, idp, _ := setupSSO(t, false)
+	defer server.Close()
+	defer idp.Close()
+
+	user := testutils.SetupUserData()
+	testutils.SetupAccountData(user, "alice@example.com", "pass1234")
+	testutils.MustExec(t, db.Model(&user).Update("disabled_at", time.Now()), "disabling user")
+
+	// Execute
+	res := ssoSignin(t, server)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusForbidden, "")
+
+	var sessionCount int
+	testutils.MustExec(t, db.Model(&database.Session{}).Count(&sessionCount), "counting sessions")
+	assert.Equal(t, sessionCount, 0, "session count mismatch")
+}
+
+func TestSSOCallback_InvalidState(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server, idp, app := setupSSO(t, true)
+	defer server.Close()
+	defer idp.Close()
+
+	record := database.OIDCAuthRequest{
+		State:        "someState",
+		Nonce:        "someNonce",
+		CodeVerifier: "someVerifier",
+		ExpiresAt:    app.Clock.Now().Add(-time.Minute),
+	}
+	testutils.MustExec(t, db.Save(&record), "preparing auth request")
+
+	testCases := []struct {
+		name        string
+		query       string
+		cookieState string
+	}{
+		{"unknown state", "?code=code-1&state=unknownState", "unknownState"},
+		{"missing state", "?code=code-1", "someState"},
+		{"expired", "?code=code-1&state=someState", "someState"},
+		{"missing cookie", "?code=code-1&state=someState", ""},
+		{"mismatched cookie", "?code=code-1&state=someState", "otherState"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := testutils.MakeReq(server, "GET", "/v3/sso/callback"+tc.query, "")
+			if tc.cookieState != "" {
+				req.AddCookie(&http.Cookie{Name: ssoStateCookieName, Value: tc.cookieState})
+			}
+
+			// Execute
+			res := testutils.HTTPDo(t, req)
+
+			// Test
+			assert.StatusCodeEquals(t, res, http.StatusBadRequest, "")
+			assert.Equal(t, getSessionCookie(res), "", "session cookie mismatch")
+		})
+	}
+}
+
+// TestSSOCallback_OtherBrowser tests that a signin started in one browser cannot
+// be completed in another, such as the browser of a victim of login CSRF
+func TestSSOCallback_OtherBrowser(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server, idp, _ := setupSSO(t, true)
+	defer server.Close()
+	defer idp.Close()
+
+	res := testutils.HTTPDo(t, testutils.MakeReq(server, "GET", "/v3/sso/login", ""))
+	assert.StatusCodeEquals(t, res, http.StatusFound, "login status mismatch")
+
+	authReq, err := http.NewRequest("GET", res.Header.Get("Location"), nil)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "making authorization request"))
+	}
+	res = testutils.HTTPDo(t, authReq)
+	assert.StatusCodeEquals(t, res, http.StatusFound, "authorization status mismatch")
+
+	// Execute
+	callbackReq, err := http.NewRequest("GET", res.Header.Get("Location"), nil)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "making callback request"))
+	}
+	res = testutils.HTTPDo(t, callbackReq)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusBadRequest, "")
+	assert.Equal(t, getSessionCookie(res), "", "session cookie mismatch")
+
+	var sessionCount int
+	testutils.MustExec(t, db.Model(&database.Session{}).Count(&sessionCount), "counting sessions")
+	assert.Equal(t, sessionCount, 0, "session count mismatch")
+}
+
+func TestSSODisabled(t *testing.T) {
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		method string
+		path   string
+	}{
+		{"GET", "/v3/sso/login"},
+		{"GET", "/v3/sso/callback?code=code-1&state=someState"},
+		{"POST", "/v3/sso/device"},
+		{"POST", "/v3/sso/device/token"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			res := testutils.HTTPDo(t, testutils.MakeReq(server, tc.method, tc.path, ""))
+
+			assert.StatusCodeEquals(t, res, http.StatusNotFound, "")
+		})
+	}
+}
+
+func pollSSODeviceToken(t *testing.T, server *httptest.Server, deviceCode string) *http.Response {
+	dat := fmt.Sprintf(`{"device_code": "%s", "device_name": "server-1"}`, deviceCode)
+	req := testutils.MakeReq(server, "POST", "/v3/sso/device/token", dat)
+	req.Header.Set("CLI-Version", "0.1.0")
+
+	return testutils.HTTPDo(t, req)
+}
+
+func assertSSODeviceError(t *testing.T, res *http.Response, expected string) {
+	assert.StatusCodeEquals(t, res, http.StatusBadRequest, "")
+
+	var got SSODeviceError
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(errors.Wrap(err, "decoding payload"))
+	}
+	assert.Equal(t, got.Error, expected, "error mismatch")
+}
+
+func TestSSODevice(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server, idp, _ := setupSSO(t, false)
+	defer server.Close()
+	defer idp.Close()
+
+	user := testutils.SetupUserData()
+	testutils.SetupAccountData(user, "alice@example.com", "pass1234")
+
+	// Execute
+	res := testutils.HTTPDo(t, testutils.MakeReq(server, "POST", "/v3/sso/device", ""))
+	assert.StatusCodeEquals(t, res, http.StatusOK, "")
+
+	var auth oidc.DeviceAuthorization
+	if err := json.NewDecoder(res.Body).Decode(&auth); err != nil {
+		t.Fatal(errors.Wrap(err, "decoding payload"))
+	}
+	assert.NotEqual(t, auth.UserCode, "", "user_code mismatch")
+	assert.NotEqual(t, auth.VerificationURI, "", "verification_uri mismatch")
+
+	// Test
+	assertSSODeviceError(t, pollSSODeviceToken(t, server, auth.DeviceCode), "authorization_pending")
+
+	idp.Approve(auth.UserCode)
+	res = pollSSODeviceToken(t, server, auth.DeviceCode)
+	assert.StatusCodeEquals(t, res, http.StatusOK, "")
+
+	var got SessionResponse
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(errors.Wrap(err, "decoding payload"))
+	}
+
+	var session database.Session
+	testutils.MustExec(t, db.Where("key = ?", got.Key).First(&session), "finding session")
+	assert.Equal(t, session.UserID, user.ID, "session user mismatch")
+	assert.Equal(t, session.DeviceName, "server-1", "device name mismatch")
+	assert.Equal(t, session.ClientType, "cli", "client type mismatch")
+}
+
+func TestSSODevice_Denied(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server, idp, _ := setupSSO(t, true)
+	defer server.Close()
+	defer idp.Close()
+
+	res := testutils.HTTPDo(t, testutils.MakeReq(server, "POST", "/v3/sso/device", ""))
+	assert.StatusCodeEquals(t, res, http.StatusOK, "")
+
+	var auth oidc.DeviceAuthorization
+	if err := json.NewDecoder(res.Body).Decode(&auth); err != nil {
+		t.Fatal(errors.Wrap(err, "decoding payload"))
+	}
+
+	// Execute
+	idp.Deny(auth.UserCode)
+	res = pollSSODeviceToken(t, server, auth.DeviceCode)
+
+	// Test
+	assertSSODeviceError(t, res, "access_denied")
+
+	var sessionCount int
+	testutils.MustExec(t, db.Model(&database.Session{}).Count(&sessionCount), "counting sessions")
+	assert.Equal(t, sessionCount, 0, "session count mismatch")
+}
@@ -62,46 +62,72 @@ type SyncFragNote struct {
 	Body      string    `json:"content"`
 	Public    bool      `json:"public"`
 	Deleted   bool      `json:"deleted"`
+	// Attachments are the metadata of the attachments of the note. Clients
+	// download the content on demand.
+	Attachments []SyncFragAttachment `json:"attachments"`
+}
+
+// SyncFragAttachment represents an attachment of a note in a sync fragment
+type SyncFragAttachment struct {
+	UUID        string `json:"uuid"`
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Hash        string `json:"hash"`
+}
+
+// NewFragAttachment presents the given attachment as a SyncFragAttachment
+func NewFragAttachment(a database.Attachment) SyncFragAttachment {
+	return SyncFragAttachment{
+		UUID:        a.UUID,
+		Name:        a.Name,
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		Hash:        a.Hash,
+	}
 }
 
 // NewFragNote presents the given note as a SyncFragNote
 func NewFragNote(note database.Note) SyncFragNote {
 	return SyncFragNote{
-		UUID:      note.UUID,
-		USN:       note.USN,
-		CreatedAt: note.CreatedAt,
-		UpdatedAt: note.UpdatedAt,
-		AddedOn:   note.AddedOn,
-		EditedOn:  note.EditedOn,
-		Body:      note.Body,
-		Public:    note.Public,
-		Deleted:   note.Deleted,
-		BookUUID:  note.BookUUID,
+		Attachments: []SyncFragAttachment{},
+		UUID:        note.UUID,
+		USN:         note.USN,
+		CreatedAt:   note.CreatedAt,
+		UpdatedAt:   note.UpdatedAt,
+		AddedOn:     note.AddedOn,
+		EditedOn:    note.EditedOn,
+		Body:        note.Body,
+		Public:      note.Public,
+		Deleted:     note.Deleted,
+		BookUUID:    note.BookUUID,
 	}
 }
 
 // SyncFragBook represents a book in a sync fragment and contains only the necessary information
 // for the client to sync the note locally
 type SyncFragBook struct {
-	UUID      string    `json:"uuid"`
-	USN       int       `json:"usn"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	AddedOn   int64     `json:"added_on"`
-	Label     string    `json:"label"`
-	Deleted   bool      `json:"deleted"`
+	UUID       string    `json:"uuid"`
+	USN        int       `json:"usn"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	AddedOn    int64     `json:"added_on"`
+	Label      string    `json:"label"`
+	ParentUUID string    `json:"parent_uuid"`
+	Deleted    bool      `json:"deleted"`
 }
 
 // NewFragBook presents the given book as a SyncFragBook
 func NewFragBook(book database.Book) SyncFragBook {
 	return SyncFragBook{
-		UUID:      book.UUID,
-		USN:       book.USN,
-		CreatedAt: book.CreatedAt,
-		UpdatedAt: book.UpdatedAt,
-		AddedOn:   book.AddedOn,
-		Label:     book.Label,
-		Deleted:   book.Deleted,
+		UUID:       book.UUID,
+		USN:        book.USN,
+		CreatedAt:  book.CreatedAt,
+		UpdatedAt:  book.UpdatedAt,
+		AddedOn:    book.AddedOn,
+		Label:      book.Label,
+		ParentUUID: book.ParentUUID,
+		Deleted:    book.Deleted,
 	}
 }
 
@@ -120,6 +146,119 @@ func (e *queryParamError) Error() string {
 	return fmt.Sprintf("invalid query param %s=%s. %s", e.key, e.value, e.message)
 }
 
+// getSharedItems returns the books and notes in the books shared with the user
+// that changed in the user's sequence. Their usn is replaced with the usn in the
+// user's sequence, and the ones that the user can no longer access are marked deleted
+// so that they are expunged from the client.
+func getSharedItems(userID int, sharedUSNs []database.SharedUSN) ([]usnItem, error) {
+	db := database.DBConn
+
+	var noteUUIDs, bookUUIDs []string
+	for _, s := range sharedUSNs {
+		if s.Type == database.SharedUSNTypeNote {
+			noteUUIDs = append(noteUUIDs, s.UUID)
+		} else {
+			bookUUIDs = append(bookUUIDs, s.UUID)
+		}
+	}
+
+	notes := map[string]database.Note{}
+	if len(noteUUIDs) > 0 {
+		var ns []database.Note
+		if err := db.Where("uuid IN (?)", noteUUIDs).Find(&ns).Error; err != nil {
+			return nil, errors.Wrap(err, "finding shared notes")
+		}
+
+		for _, n := range ns {
+			notes[n.UUID] = n
+			bookUUIDs = append(bookUUIDs, n.BookUUID)
+		}
+	}
+
+	books := map[string]database.Book{}
+	if len(bookUUIDs) > 0 {
+		var bs []database.Book
+		if err := db.Where("uuid IN (?)", bookUUIDs).Find(&bs).Error; err != nil {
+			return nil, errors.Wrap(err, "finding shared books")
+		}
+
+		for _, b := range bs {
+			books[b.UUID] = b
+		}
+	}
+
+	var members []database.BookMember
+	if err := db.Where("user_id = ?", userID).Find(&members).Error; err != nil {
+		return nil, errors.Wrap(err, "finding book memberships")
+	}
+	memberBookIDs := map[int]bool{}
+	for _, m := range members {
+		memberBookIDs[m.BookID] = true
+	}
+
+	canAccess := func(bookUUID string) bool {
+		book, ok := books[bookUUID]
+
+		return ok && !book.Deleted && memberBookIDs[book.ID]
+	}
+
+	var ret []usnItem
+	for _, s := range sharedUSNs {
+		var val interface{}
+
+		if s.Type == database.SharedUSNTypeNote {
+			note, ok := notes[s.UUID]
+			if !ok || !canAccess(note.BookUUID) {
+				note = database.Note{UUID: s.UUID, Deleted: true}
+			}
+
+			note.USN = s.USN
+			val = note
+		} else {
+			book, ok := books[s.UUID]
+			if !ok || !canAccess(book.UUID) {
+				book = database.Book{UUID: s.UUID, Deleted: true}
+			}
+
+			book.USN = s.USN
+			val = book
+		}
+
+		ret = append(ret, usnItem{usn: s.USN, val: val})
+	}
+
+	return ret, nil
+}
+
+// setFragAttachments sets the attachments of the given notes
+func setFragAttachments(notes []SyncFragNote) error {
+	if len(notes) == 0 {
+		return nil
+	}
+
+	var uuids []string
+	for _, n := range notes {
+		uuids = append(uuids, n.UUID)
+	}
+
+	var attachments []database.Attachment
+	if err := database.DBConn.Where("note_uuid IN (?)", uuids).Order("id ASC").Find(&attachments).Error; err != nil {
+		return errors.Wrap(err, "finding attachments")
+	}
+
+	m := map[string][]SyncFragAttachment{}
+	for _, a := range attachments {
+		m[a.NoteUUID] = append(m[a.NoteUUID], NewFragAttachment(a))
+	}
+	for i, n := range notes {
+		if as, ok := m[n.UUID]; ok {
+			notes[i].Attachments = as
+		}
+	}
+
+	return nil
+}
+
 func (a *App) newFragment(userID, userMaxUSN, afterUSN, limit int) (SyncFragment, error) {
 	db := database.DBConn
 
@@ -131,8 +270,16 @@ func (a *App) newFragment(userID, userMaxUSN, afterUSN, limit int) (SyncFragment
 	if err := db.Where("user_id = ? AND usn > ? AND usn <= ?", userID, afterUSN, userMaxUSN).Order("usn ASC").Limit(limit).Find(&books).Error; err != nil {
 		return SyncFragment{}, nil
 	}
+	var sharedUSNs []database.SharedUSN
+	if err := db.Where("user_id = ? AND usn > ? AND usn <= ?", userID, afterUSN, userMaxUSN).Order("usn ASC").Limit(limit).Find(&sharedUSNs).Error; err != nil {
+		return SyncFragment{}, nil
+	}
+
+	items, err := getSharedItems(userID, sharedUSNs)
+	if err != nil {
+		return SyncFragment{}, errors.Wrap(err, "getting shared items")
+	}
 
-	var items []usnItem
 	for _, note := range notes {
 		i := usnItem{
 			usn: note.USN,
@@ -190,6 +337,10 @@ func (a *App) newFragment(userID, userMaxUSN, afterUSN, limit int) (SyncFragment
 		}
 	}
 
+	if err := setFragAttachments(fragNotes); err != nil {
+		return SyncFragment{}, errors.Wrap(err, "setting attachments")
+	}
+
 	ret := SyncFragment{
 		FragMaxUSN:    fragMaxUSN,
 		UserMaxUSN:    userMaxUSN,
@@ -268,6 +419,8 @@ func (a *App) GetSyncFragment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	recordSyncFragment(fragment)
+
 	response := GetSyncFragmentResp{
 		Fragment: fragment,
 	}
@@ -281,6 +434,16 @@ type GetSyncStateResp struct {
 	CurrentTime    int64 `json:"current_time"`
 }
 
+// getFullSyncBefore returns the timestamp before which the clients of the user must
+// perform a full sync
+func getFullSyncBefore(user database.User) int {
+	if user.FullSyncBefore > fullSyncBefore {
+		return int(user.FullSyncBefore)
+	}
+
+	return fullSyncBefore
+}
+
 // GetSyncState responds with a sync fragment
 func (a *App) GetSyncState(w http.ResponseWriter, r *http.Request) {
 	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
@@ -290,7 +453,7 @@ func (a *App) GetSyncState(w http.ResponseWriter, r *http.Request) {
 	}
 
 	response := GetSyncStateResp{
-		FullSyncBefore: fullSyncBefore,
+		FullSyncBefore: getFullSyncBefore(user),
 		MaxUSN:         user.MaxUSN,
 		// TODO: exposing server time means we probably shouldn't seed random generator with time?
 		CurrentTime: a.Clock.Now().Unix(),
@@ -301,5 +464,7 @@ func (a *App) GetSyncState(w http.ResponseWriter, r *http.Request) {
 		"resp":    response,
 	}).Info("getting sync state")
 
+	syncStateRequestsTotal.Inc()
+
 	respondJSON(w, http.StatusOK, response)
 }
@@ -25,6 +25,10 @@ import (
 	"testing"
 
 	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/api/operations"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
 	"github.com/pkg/errors"
 )
 
@@ -91,3 +95,102 @@ func TestParseGetSyncFragmentQuery(t *testing.T) {
 		assert.Equal(t, limit, tc.limit, fmt.Sprintf("limit mismatch for test case %d", idx))
 	}
 }
+
+func TestNewFragment_SharedBook(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	a := &App{Clock: clock.NewMock()}
+
+	owner := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&owner).Update("max_usn", 50), "preparing owner max_usn")
+	member := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&member).Update("max_usn", 2), "preparing member max_usn")
+
+	b1 := database.Book{UserID: owner.ID, Label: "runbooks", USN: 10}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	n1 := database.Note{UserID: owner.ID, BookUUID: b1.UUID, Body: "n1 content", USN: 11}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+	b2 := database.Book{UserID: member.ID, Label: "own book", USN: 2}
+	testutils.MustExec(t, db.Save(&b2), "preparing b2")
+
+	tx := db.Begin()
+	m1, err := operations.AddBookMember(tx, b1, member, database.BookMemberRoleViewer)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "adding book member"))
+	}
+	tx.Commit()
+
+	// Execute
+	frag, err := a.newFragment(member.ID, 4, 0, 100)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting fragment"))
+	}
+
+	// Test
+	assert.Equal(t, frag.FragMaxUSN, 4, "FragMaxUSN mismatch")
+	assert.Equal(t, len(frag.Books), 2, "books length mismatch")
+	assert.Equal(t, frag.Books[0].UUID, b2.UUID, "books[0] uuid mismatch")
+	assert.Equal(t, frag.Books[1].UUID, b1.UUID, "books[1] uuid mismatch")
+	assert.Equal(t, frag.Books[1].USN, 3, "books[1] usn should be in the sequence of the member")
+	assert.Equal(t, len(frag.Notes), 1, "notes length mismatch")
+	assert.Equal(t, frag.Notes[0].UUID, n1.UUID, "notes[0] uuid mismatch")
+	assert.Equal(t, frag.Notes[0].USN, 4, "notes[0] usn should be in the sequence of the member")
+
+	// Execute
+	tx = db.Begin()
+	if err := operations.RemoveBookMember(tx, b1, m1); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "removing book member"))
+	}
+	tx.Commit()
+
+	frag, err = a.newFragment(member.ID, 6, 4, 100)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting fragment after removal"))
+	}
+
+	// Test
+	assert.Equal(t, frag.FragMaxUSN, 6, "FragMaxUSN mismatch after removal")
+	assert.DeepEqual(t, frag.Books, []SyncFragBook{}, "books mismatch after removal")
+	assert.DeepEqual(t, frag.Notes, []SyncFragNote{}, "notes mismatch after removal")
+	assert.DeepEqual(t, frag.ExpungedBooks, []string{b1.UUID}, "expunged books mismatch after removal")
+	assert.DeepEqual(t, frag.ExpungedNotes, []string{n1.UUID}, "expunged notes mismatch after removal")
+}
+
+func TestNewFragment_Attachments(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	a := &App{Clock: clock.NewMock()}
+
+	user := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&user).Update("max_usn", 3), "preparing user max_usn")
+
+	b1 := database.Book{UserID: user.ID, Label: "css", USN: 1}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	n1 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "n1 content", USN: 2}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+	n2 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "n2 content", USN: 3}
+	testutils.MustExec(t, db.Save(&n2), "preparing n2")
+	a1 := database.Attachment{NoteUUID: n1.UUID, UserID: user.ID, Name: "flexbox.png", ContentType: "image/png", Size: 42, Hash: "h1"}
+	testutils.MustExec(t, db.Save(&a1), "preparing a1")
+
+	// Execute
+	frag, err := a.newFragment(user.ID, 3, 0, 100)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting fragment"))
+	}
+
+	// Test
+	assert.Equal(t, len(frag.Notes), 2, "notes length mismatch")
+	assert.Equal(t, frag.Notes[0].UUID, n1.UUID, "notes[0] uuid mismatch")
+	assert.DeepEqual(t, frag.Notes[0].Attachments, []SyncFragAttachment{
+		{UUID: a1.UUID, Name: "flexbox.png", ContentType: "image/png", Size: 42, Hash: "h1"},
+	}, "notes[0] attachments mismatch")
+	assert.Equal(t, frag.Notes[1].UUID, n2.UUID, "notes[1] uuid mismatch")
+	assert.DeepEqual(t, frag.Notes[1].Attachments, []SyncFragAttachment{}, "notes[1] attachments mismatch")
+}
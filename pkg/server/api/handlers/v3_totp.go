@@ -0,0 +1,237 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dnote/dnote/pkg/server/api/helpers"
+	"github.com/dnote/dnote/pkg/server/api/operations"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/totp"
+)
+
+// totpIssuer is the issuer shown by authenticator apps
+const totpIssuer = "Dnote"
+
+// BeginTOTPEnrollmentResp is the response from begin totp enrollment api
+type BeginTOTPEnrollmentResp struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// RecoveryCodesResp is a response containing new recovery codes
+type RecoveryCodesResp struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type totpCodePayload struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// findUserAccount finds the account of the user. It responds with an error and returns
+// false if the account cannot be found.
+func findUserAccount(w http.ResponseWriter, user database.User) (database.Account, bool) {
+	var account database.Account
+	if err := database.DBConn.Where("user_id = ?", user.ID).First(&account).Error; err != nil {
+		handleError(w, "finding account", err, http.StatusInternalServerError)
+		return account, false
+	}
+
+	return account, true
+}
+
+func (a *App) beginTOTPEnrollment(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	account, ok := findUserAccount(w, user)
+	if !ok {
+		return
+	}
+
+	secret, err := operations.BeginTOTPEnrollment(database.DBConn, &account)
+	if err == operations.ErrTOTPEnabled {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		handleError(w, "beginning enrollment", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, BeginTOTPEnrollmentResp{
+		Secret: secret,
+		URI:    totp.URI(secret, totpIssuer, account.Email.String),
+	})
+}
+
+func (a *App) enableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	var params totpCodePayload
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if params.Code == "" {
+		http.Error(w, "code is required", http.StatusBadRequest)
+		return
+	}
+
+	account, ok := findUserAccount(w, user)
+	if !ok {
+		return
+	}
+
+	tx := database.DBConn.Begin()
+	codes, ok, err := operations.EnableTOTP(tx, &account, params.Code, a.Clock.Now())
+	if err == operations.ErrTOTPEnabled {
+		tx.Rollback()
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err == operations.ErrTOTPEnrollmentNotStarted {
+		tx.Rollback()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		tx.Rollback()
+		handleError(w, "enabling two-factor authentication", err, http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		tx.Rollback()
+		http.Error(w, ErrInvalidTOTP.Error(), http.StatusBadRequest)
+		return
+	}
+	tx.Commit()
+
+	a.recordAuditEvent(database.DBConn, r, user.ID, user.ID, database.AuditEventTOTPEnabled)
+	respondJSON(w, http.StatusOK, RecoveryCodesResp{RecoveryCodes: codes})
+}
+
+// verifyTOTPPayload decodes the payload and verifies the second factor of the
+// account. It responds with an error and returns false if the verification fails.
+func (a *App) verifyTOTPPayload(w http.ResponseWriter, r *http.Request, account *database.Account, allowRecoveryCode bool) bool {
+	var params totpCodePayload
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return false
+	}
+	if !allowRecoveryCode {
+		params.RecoveryCode = ""
+	}
+	if params.Code == "" && params.RecoveryCode == "" {
+		http.Error(w, "code is required", http.StatusBadRequest)
+		return false
+	}
+
+	if !account.TOTPEnabled {
+		http.Error(w, operations.ErrTOTPNotEnabled.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	ok, err := operations.VerifySecondFactor(database.DBConn, account, params.Code, params.RecoveryCode, a.Clock.Now())
+	if err != nil {
+		handleError(w, "verifying second factor", err, http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		http.Error(w, ErrInvalidTOTP.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
+
+func (a *App) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	db := database.DBConn
+
+	required, err := operations.RequiresTOTP(db, user)
+	if err != nil {
+		handleError(w, "checking the organization policy", err, http.StatusInternalServerError)
+		return
+	}
+	if required {
+		http.Error(w, "Your organization requires two-factor authentication", http.StatusForbidden)
+		return
+	}
+
+	account, ok := findUserAccount(w, user)
+	if !ok {
+		return
+	}
+	if ok := a.verifyTOTPPayload(w, r, &account, true); !ok {
+		return
+	}
+
+	tx := db.Begin()
+	if err := operations.DisableTOTP(tx, &account); err != nil {
+		tx.Rollback()
+		handleError(w, "disabling two-factor authentication", err, http.StatusInternalServerError)
+		return
+	}
+	tx.Commit()
+
+	a.recordAuditEvent(db, r, user.ID, user.ID, database.AuditEventTOTPDisabled)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	account, ok := findUserAccount(w, user)
+	if !ok {
+		return
+	}
+	if ok := a.verifyTOTPPayload(w, r, &account, false); !ok {
+		return
+	}
+
+	tx := database.DBConn.Begin()
+	codes, err := operations.GenerateRecoveryCodes(tx, user.ID)
+	if err != nil {
+		tx.Rollback()
+		handleError(w, "generating recovery codes", err, http.StatusInternalServerError)
+		return
+	}
+	tx.Commit()
+
+	a.recordAuditEvent(database.DBConn, r, user.ID, user.ID, database.AuditEventRecoveryCodesGenerated)
+
+	respondJSON(w, http.StatusOK, RecoveryCodesResp{RecoveryCodes: codes})
+}
@@ -0,0 +1,271 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/api/operations"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/dnote/dnote/pkg/server/totp"
+	"github.com/pkg/errors"
+)
+
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func mustGenerateTOTP(t *testing.T, secret string, now time.Time) string {
+	code, err := totp.Generate(secret, now)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "generating code"))
+	}
+
+	return code
+}
+
+// setupTOTPAccount sets up a user whose account has the two-factor authentication enabled
+func setupTOTPAccount(t *testing.T) (database.User, []string) {
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	account := testutils.SetupAccountData(user, "alice@example.com", "pass1234")
+	account.TOTPSecret = testTOTPSecret
+	account.TOTPEnabled = true
+	testutils.MustExec(t, db.Save(&account), "preparing account")
+
+	codes, err := operations.GenerateRecoveryCodes(db, user.ID)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "generating recovery codes"))
+	}
+
+	return user, codes
+}
+
+func TestSignIn_TOTP(t *testing.T) {
+	now := time.Date(2019, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("without code", func(t *testing.T) {
+		defer testutils.ClearData()
+
+		c := clock.NewMock()
+		c.SetNow(now)
+		server := httptest.NewServer(NewRouter(&App{Clock: c}))
+		defer server.Close()
+
+		setupTOTPAccount(t)
+
+		// Execute
+		dat := `{"email": "alice@example.com", "password": "pass1234"}`
+		res := testutils.HTTPDo(t, testutils.MakeReq(server, "POST", "/v3/signin", dat))
+
+		// Test
+		assert.StatusCodeEquals(t, res, http.StatusUnauthorized, "")
+		assert.Equal(t, res.Header.Get("TOTP-Required"), "true", "header mismatch")
+	})
+
+	t.Run("wrong code", func(t *testing.T) {
+		defer testutils.ClearData()
+
+		c := clock.NewMock()
+		c.SetNow(now)
+		server := httptest.NewServer(NewRouter(&App{Clock: c}))
+		defer server.Close()
+
+		setupTOTPAccount(t)
+
+		// Execute
+		dat := `{"email": "alice@example.com", "password": "pass1234", "totp_code": "000000"}`
+		res := testutils.HTTPDo(t, testutils.MakeReq(server, "POST", "/v3/signin", dat))
+
+		// Test
+		assert.StatusCodeEquals(t, res, http.StatusUnauthorized, "")
+
+		var sessionCount int
+		testutils.MustExec(t, database.DBConn.Model(&database.Session{}).Count(&sessionCount), "counting sessions")
+		assert.Equal(t, sessionCount, 0, "session count mismatch")
+	})
+
+	t.Run("wrong password with a valid code", func(t *testing.T) {
+		defer testutils.ClearData()
+
+		c := clock.NewMock()
+		c.SetNow(now)
+		server := httptest.NewServer(NewRouter(&App{Clock: c}))
+		defer server.Close()
+
+		setupTOTPAccount(t)
+
+		// Execute
+		dat := fmt.Sprintf(`{"email": "alice@example.com", "password": "wrong", "totp_code": "%s"}`, mustGenerateTOTP(t, testTOTPSecret, now))
+		res := testutils.HTTPDo(t, testutils.MakeReq(server, "POST", "/v3/signin", dat))
+
+		// Test
+		assert.StatusCodeEquals(t, res, http.StatusUnauthorized, "")
+		assert.Equal(t, res.Header.Get("TOTP-Required"), "", "header mismatch")
+	})
+
+	t.Run("valid code", func(t *testing.T) {
+		defer testutils.ClearData()
+
+		c := clock.NewMock()
+		c.SetNow(now)
+		server := httptest.NewServer(NewRouter(&App{Clock: c}))
+		defer server.Close()
+
+		setupTOTPAccount(t)
+		code := mustGenerateTOTP(t, testTOTPSecret, now)
+
+		// Execute
+		dat := fmt.Sprintf(`{"email": "alice@example.com", "password": "pass1234", "totp_code": "%s"}`, code)
+		res := testutils.HTTPDo(t, testutils.MakeReq(server, "POST", "/v3/signin", dat))
+
+		// Test
+		assert.StatusCodeEquals(t, res, http.StatusOK, "")
+		assertSessionResp(t, res)
+
+		// the same code cannot be used again
+		res = testutils.HTTPDo(t, testutils.MakeReq(server, "POST", "/v3/signin", dat))
+		assert.StatusCodeEquals(t, res, http.StatusUnauthorized, "reuse status mismatch")
+	})
+
+	t.Run("recovery code", func(t *testing.T) {
+		defer testutils.ClearData()
+
+		c := clock.NewMock()
+		c.SetNow(now)
+		server := httptest.NewServer(NewRouter(&App{Clock: c}))
+		defer server.Close()
+
+		_, codes := setupTOTPAccount(t)
+
+		// Execute
+		dat := fmt.Sprintf(`{"email": "alice@example.com", "password": "pass1234", "recovery_code": "%s"}`, codes[0])
+		res := testutils.HTTPDo(t, testutils.MakeReq(server, "POST", "/v3/signin", dat))
+
+		// Test
+		assert.StatusCodeEquals(t, res, http.StatusOK, "")
+
+		// the same recovery code cannot be used again
+		res = testutils.HTTPDo(t, testutils.MakeReq(server, "POST", "/v3/signin", dat))
+		assert.StatusCodeEquals(t, res, http.StatusUnauthorized, "reuse status mismatch")
+	})
+}
+
+func TestTOTPRequiredByOrganization(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	now := time.Date(2019, time.November, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewMock()
+	c.SetNow(now)
+	server := httptest.NewServer(NewRouter(&App{Clock: c}))
+	defer server.Close()
+
+	org, _, _ := setupOrganization(t)
+	testutils.MustExec(t, db.Model(&org).Update("require_totp", true), "preparing organization")
+
+	// sign in without the two-factor authentication
+	dat := `{"email": "bob@example.com", "password": "pass1234"}`
+	res := testutils.HTTPDo(t, testutils.MakeReq(server, "POST", "/v3/signin", dat))
+	assert.StatusCodeEquals(t, res, http.StatusOK, "signin status mismatch")
+
+	var session SessionResponse
+	if err := json.NewDecoder(res.Body).Decode(&session); err != nil {
+		t.Fatal(errors.Wrap(err, "decoding session"))
+	}
+	assert.Equal(t, session.TOTPEnrollmentRequired, true, "totp_enrollment_required mismatch")
+
+	doReq := func(method, path, body string) *http.Response {
+		req := testutils.MakeReq(server, method, path, body)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", session.Key))
+		return testutils.HTTPDo(t, req)
+	}
+
+	// the session cannot be used for other purposes
+	res = doReq("GET", "/v3/session", "")
+	assert.StatusCodeEquals(t, res, http.StatusForbidden, "session status mismatch before enrollment")
+
+	// enroll
+	res = doReq("POST", "/v3/account/totp", "")
+	assert.StatusCodeEquals(t, res, http.StatusOK, "begin enrollment status mismatch")
+
+	var enrollment BeginTOTPEnrollmentResp
+	if err := json.NewDecoder(res.Body).Decode(&enrollment); err != nil {
+		t.Fatal(errors.Wrap(err, "decoding enrollment"))
+	}
+	assert.Equal(t, enrollment.URI, totp.URI(enrollment.Secret, "Dnote", "bob@example.com"), "uri mismatch")
+
+	res = doReq("POST", "/v3/account/totp/verify", `{"code": "000000"}`)
+	assert.StatusCodeEquals(t, res, http.StatusBadRequest, "verify status mismatch for a wrong code")
+
+	res = doReq("POST", "/v3/account/totp/verify", fmt.Sprintf(`{"code": "%s"}`, mustGenerateTOTP(t, enrollment.Secret, now)))
+	assert.StatusCodeEquals(t, res, http.StatusOK, "verify status mismatch")
+
+	var recovery RecoveryCodesResp
+	if err := json.NewDecoder(res.Body).Decode(&recovery); err != nil {
+		t.Fatal(errors.Wrap(err, "decoding recovery codes"))
+	}
+	assert.Equal(t, len(recovery.RecoveryCodes), 10, "recovery code count mismatch")
+
+	// the session can now be used
+	res = doReq("GET", "/v3/session", "")
+	assert.StatusCodeEquals(t, res, http.StatusOK, "session status mismatch after enrollment")
+
+	// the organization does not allow disabling
+	res = doReq("DELETE", "/v3/account/totp", fmt.Sprintf(`{"recovery_code": "%s"}`, recovery.RecoveryCodes[0]))
+	assert.StatusCodeEquals(t, res, http.StatusForbidden, "disable status mismatch")
+}
+
+func TestDisableTOTP(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	now := time.Date(2019, time.November, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewMock()
+	c.SetNow(now)
+	server := httptest.NewServer(NewRouter(&App{Clock: c}))
+	defer server.Close()
+
+	user, _ := setupTOTPAccount(t)
+
+	// wrong code
+	req := testutils.MakeReq(server, "DELETE", "/v3/account/totp", `{"code": "000000"}`)
+	res := testutils.HTTPAuthDo(t, req, user)
+	assert.StatusCodeEquals(t, res, http.StatusBadRequest, "status mismatch for a wrong code")
+
+	// valid code
+	dat := fmt.Sprintf(`{"code": "%s"}`, mustGenerateTOTP(t, testTOTPSecret, now))
+	req = testutils.MakeReq(server, "DELETE", "/v3/account/totp", dat)
+	res = testutils.HTTPAuthDo(t, req, user)
+	assert.StatusCodeEquals(t, res, http.StatusNoContent, "status mismatch")
+
+	var account database.Account
+	var codeCount int
+	testutils.MustExec(t, db.Where("user_id = ?", user.ID).First(&account), "finding account")
+	testutils.MustExec(t, db.Model(&database.RecoveryCode{}).Where("user_id = ?", user.ID).Count(&codeCount), "counting recovery codes")
+	assert.Equal(t, account.TOTPEnabled, false, "totp_enabled mismatch")
+	assert.Equal(t, codeCount, 0, "recovery code count mismatch")
+}
@@ -0,0 +1,355 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/dnote/dnote/pkg/server/api/crypt"
+	"github.com/dnote/dnote/pkg/server/api/helpers"
+	"github.com/dnote/dnote/pkg/server/api/presenters"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/webhook"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+type webhookParams struct {
+	URL     *string   `json:"url"`
+	Secret  *string   `json:"secret"`
+	Events  *[]string `json:"events"`
+	Enabled *bool     `json:"enabled"`
+}
+
+func validateWebhookParams(p webhookParams) error {
+	if p.URL != nil {
+		if err := webhook.ValidateURL(*p.URL); err != nil {
+			return errors.Wrap(err, "invalid url")
+		}
+	}
+
+	if p.Events != nil {
+		if len(*p.Events) == 0 {
+			return errors.New("at least one event is required")
+		}
+
+		for _, e := range *p.Events {
+			if err := webhook.ValidateEvent(e); err != nil {
+				return err
+			}
+		}
+	}
+
+	if p.Secret != nil && len(*p.Secret) < 16 {
+		return errors.New("secret should be at least 16 characters long")
+	}
+
+	return nil
+}
+
+func parseWebhookParams(r *http.Request, create bool) (webhookParams, error) {
+	var ret webhookParams
+
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	if err := d.Decode(&ret); err != nil {
+		return ret, errors.Wrap(err, "decoding json")
+	}
+
+	if create {
+		if ret.URL == nil {
+			return ret, errors.New("url is required")
+		}
+		if ret.Events == nil {
+			return ret, errors.New("events is required")
+		}
+	}
+
+	if err := validateWebhookParams(ret); err != nil {
+		return ret, errors.Wrap(err, "validating params")
+	}
+
+	return ret, nil
+}
+
+// findWebhook finds the webhook with the uuid in the request path for the given user.
+// It writes a response and returns false if the webhook cannot be found.
+func findWebhook(w http.ResponseWriter, r *http.Request, user database.User) (database.Webhook, bool) {
+	var ret database.Webhook
+
+	webhookUUID := mux.Vars(r)["webhookUUID"]
+	if ok := helpers.ValidateUUID(webhookUUID); !ok {
+		http.Error(w, "invalid uuid", http.StatusBadRequest)
+		return ret, false
+	}
+
+	db := database.DBConn
+	conn := db.Where("uuid = ? AND user_id = ?", webhookUUID, user.ID).First(&ret)
+	if conn.RecordNotFound() {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return ret, false
+	} else if err := conn.Error; err != nil {
+		handleError(w, "finding the webhook", err, http.StatusInternalServerError)
+		return ret, false
+	}
+
+	return ret, true
+}
+
+// GetWebhooks returns the webhooks of the user
+func (a *App) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	db := database.DBConn
+	var webhooks []database.Webhook
+	if err := db.Where("user_id = ?", user.ID).Order("id ASC").Find(&webhooks).Error; err != nil {
+		handleError(w, "finding webhooks", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, presenters.PresentWebhooks(webhooks))
+}
+
+// GetWebhook returns a webhook of the user
+func (a *App) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	hook, ok := findWebhook(w, r, user)
+	if !ok {
+		return
+	}
+
+	respondJSON(w, http.StatusOK, presenters.PresentWebhook(hook))
+}
+
+// CreateWebhookResp is the response from the create webhook endpoint. The secret
+// is included only in this response, so that clients can store it to verify signatures.
+type CreateWebhookResp struct {
+	Webhook presenters.Webhook `json:"webhook"`
+	Secret  string             `json:"secret"`
+}
+
+// CreateWebhook creates a webhook
+func (a *App) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	params, err := parseWebhookParams(r, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var secret string
+	if params.Secret != nil {
+		secret = *params.Secret
+	} else {
+		secret, err = crypt.GetRandomStr(32)
+		if err != nil {
+			handleError(w, "generating secret", err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	enabled := true
+	if params.Enabled != nil {
+		enabled = *params.Enabled
+	}
+
+	record := database.Webhook{
+		UserID:  user.ID,
+		URL:     *params.URL,
+		Secret:  secret,
+		Events:  pq.StringArray(*params.Events),
+		Enabled: enabled,
+	}
+
+	db := database.DBConn
+	if err := db.Create(&record).Error; err != nil {
+		handleError(w, "creating a webhook", err, http.StatusInternalServerError)
+		return
+	}
+	// gorm does not write back a false value over the column default
+	if !enabled {
+		if err := db.Model(&record).Update("enabled", false).Error; err != nil {
+			handleError(w, "disabling the webhook", err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	resp := CreateWebhookResp{
+		Webhook: presenters.PresentWebhook(record),
+		Secret:  secret,
+	}
+	respondJSON(w, http.StatusCreated, resp)
+}
+
+// UpdateWebhook updates a webhook
+func (a *App) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	params, err := parseWebhookParams(r, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hook, ok := findWebhook(w, r, user)
+	if !ok {
+		return
+	}
+
+	if params.URL != nil {
+		hook.URL = *params.URL
+	}
+	if params.Secret != nil {
+		hook.Secret = *params.Secret
+	}
+	if params.Events != nil {
+		hook.Events = pq.StringArray(*params.Events)
+	}
+	if params.Enabled != nil {
+		hook.Enabled = *params.Enabled
+	}
+
+	db := database.DBConn
+	if err := db.Save(&hook).Error; err != nil {
+		handleError(w, "updating the webhook", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, presenters.PresentWebhook(hook))
+}
+
+// DeleteWebhook deletes a webhook and its delivery log
+func (a *App) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	hook, ok := findWebhook(w, r, user)
+	if !ok {
+		return
+	}
+
+	db := database.DBConn
+	tx := db.Begin()
+
+	if err := tx.Where("webhook_id = ?", hook.ID).Delete(&database.WebhookDelivery{}).Error; err != nil {
+		tx.Rollback()
+		handleError(w, "deleting webhook deliveries", err, http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Delete(&hook).Error; err != nil {
+		tx.Rollback()
+		handleError(w, "deleting the webhook", err, http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		handleError(w, "committing a transaction", err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetWebhookDeliveriesResp is the response from the webhook deliveries endpoint
+type GetWebhookDeliveriesResp struct {
+	Deliveries []presenters.WebhookDelivery `json:"deliveries"`
+	Total      int                          `json:"total"`
+}
+
+func parsePageQuery(q url.Values) (int, error) {
+	pageStr := q.Get("page")
+	if len(pageStr) == 0 {
+		return 1, nil
+	}
+
+	p, err := strconv.Atoi(pageStr)
+	if err != nil || p < 1 {
+		return 0, errors.Errorf("invalid page %s", pageStr)
+	}
+
+	return p, nil
+}
+
+// GetWebhookDeliveries returns the delivery log of a webhook, most recent first
+func (a *App) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	user, ok := r.Context().Value(helpers.KeyUser).(database.User)
+	if !ok {
+		handleError(w, "No authenticated user found", nil, http.StatusInternalServerError)
+		return
+	}
+
+	page, err := parsePageQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hook, ok := findWebhook(w, r, user)
+	if !ok {
+		return
+	}
+
+	db := database.DBConn
+	conn := db.Model(database.WebhookDelivery{}).Where("webhook_id = ?", hook.ID)
+
+	var total int
+	if err := conn.Count(&total).Error; err != nil {
+		handleError(w, "counting deliveries", err, http.StatusInternalServerError)
+		return
+	}
+
+	var deliveries []database.WebhookDelivery
+	if err := paginate(conn.Order("id DESC"), page).Find(&deliveries).Error; err != nil {
+		handleError(w, "finding deliveries", err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := GetWebhookDeliveriesResp{
+		Deliveries: presenters.PresentWebhookDeliveries(deliveries),
+		Total:      total,
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,253 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestCreateWebhook(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+
+	// Execute
+	dat := `{"url": "https://example.com/hook", "events": ["note.created", "book.deleted"]}`
+	req := testutils.MakeReq(server, "POST", "/v3/webhooks", dat)
+	res := testutils.HTTPAuthDo(t, req, user)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusCreated, "")
+
+	var payload CreateWebhookResp
+	if err := json.NewDecoder(res.Body).Decode(&payload); err != nil {
+		t.Fatal(errors.Wrap(err, "decoding payload"))
+	}
+
+	var webhookCount int
+	var webhookRecord database.Webhook
+	testutils.MustExec(t, db.Model(&database.Webhook{}).Count(&webhookCount), "counting webhooks")
+	testutils.MustExec(t, db.First(&webhookRecord), "finding webhook")
+
+	assert.Equal(t, webhookCount, 1, "webhook count mismatch")
+	assert.Equal(t, webhookRecord.UserID, user.ID, "UserID mismatch")
+	assert.Equal(t, webhookRecord.URL, "https://example.com/hook", "URL mismatch")
+	assert.DeepEqual(t, []string(webhookRecord.Events), []string{"note.created", "book.deleted"}, "Events mismatch")
+	assert.Equal(t, webhookRecord.Enabled, true, "Enabled mismatch")
+	assert.NotEqual(t, webhookRecord.Secret, "", "Secret should have been generated")
+
+	assert.Equal(t, payload.Webhook.UUID, webhookRecord.UUID, "payload UUID mismatch")
+	assert.Equal(t, payload.Secret, webhookRecord.Secret, "payload Secret mismatch")
+}
+
+func TestCreateWebhook_BadRequest(t *testing.T) {
+	testCases := []string{
+		// no url
+		`{"events": ["note.created"]}`,
+		// no events
+		`{"url": "https://example.com/hook"}`,
+		// empty events
+		`{"url": "https://example.com/hook", "events": []}`,
+		// unknown event
+		`{"url": "https://example.com/hook", "events": ["note.viewed"]}`,
+		// invalid scheme
+		`{"url": "ftp://example.com/hook", "events": ["note.created"]}`,
+		// short secret
+		`{"url": "https://example.com/hook", "events": ["note.created"], "secret": "foo"}`,
+		// loopback
+		`{"url": "http://127.0.0.1:8080/hook", "events": ["note.created"]}`,
+		`{"url": "http://localhost/hook", "events": ["note.created"]}`,
+		`{"url": "http://[::1]/hook", "events": ["note.created"]}`,
+		// private network
+		`{"url": "http://10.0.0.12/hook", "events": ["note.created"]}`,
+		`{"url": "http://[fd00::1]/hook", "events": ["note.created"]}`,
+		// link-local
+		`{"url": "http://169.254.169.254/latest/meta-data", "events": ["note.created"]}`,
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			server := httptest.NewServer(NewRouter(&App{
+				Clock: clock.NewMock(),
+			}))
+			defer server.Close()
+
+			user := testutils.SetupUserData()
+
+			// Execute
+			req := testutils.MakeReq(server, "POST", "/v3/webhooks", tc)
+			res := testutils.HTTPAuthDo(t, req, user)
+
+			// Test
+			assert.StatusCodeEquals(t, res, http.StatusBadRequest, "")
+
+			var webhookCount int
+			testutils.MustExec(t, db.Model(&database.Webhook{}).Count(&webhookCount), "counting webhooks")
+			assert.Equal(t, webhookCount, 0, "webhook count mismatch")
+		})
+	}
+}
+
+func TestUpdateWebhook(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+	w1 := database.Webhook{
+		UserID:  user.ID,
+		URL:     "https://example.com/hook",
+		Secret:  "secret-secret-secret",
+		Events:  []string{"note.created"},
+		Enabled: true,
+	}
+	testutils.MustExec(t, db.Save(&w1), "preparing w1")
+
+	// Execute
+	dat := `{"events": ["note.updated"], "enabled": false}`
+	endpoint := fmt.Sprintf("/v3/webhooks/%s", w1.UUID)
+	req := testutils.MakeReq(server, "PATCH", endpoint, dat)
+	res := testutils.HTTPAuthDo(t, req, user)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusOK, "")
+
+	var webhookRecord database.Webhook
+	testutils.MustExec(t, db.Where("id = ?", w1.ID).First(&webhookRecord), "finding webhook")
+
+	assert.Equal(t, webhookRecord.URL, "https://example.com/hook", "URL mismatch")
+	assert.DeepEqual(t, []string(webhookRecord.Events), []string{"note.updated"}, "Events mismatch")
+	assert.Equal(t, webhookRecord.Enabled, false, "Enabled mismatch")
+	assert.Equal(t, webhookRecord.Secret, "secret-secret-secret", "Secret mismatch")
+}
+
+func TestDeleteWebhook(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+	anotherUser := testutils.SetupUserData()
+
+	w1 := database.Webhook{UserID: user.ID, URL: "https://example.com/1", Events: []string{"note.created"}}
+	testutils.MustExec(t, db.Save(&w1), "preparing w1")
+	w2 := database.Webhook{UserID: anotherUser.ID, URL: "https://example.com/2", Events: []string{"note.created"}}
+	testutils.MustExec(t, db.Save(&w2), "preparing w2")
+	d1 := database.WebhookDelivery{WebhookID: w1.ID, Event: "note.created", Status: database.WebhookDeliveryStatusPending}
+	testutils.MustExec(t, db.Save(&d1), "preparing d1")
+
+	t.Run("own webhook", func(t *testing.T) {
+		endpoint := fmt.Sprintf("/v3/webhooks/%s", w1.UUID)
+		req := testutils.MakeReq(server, "DELETE", endpoint, "")
+		res := testutils.HTTPAuthDo(t, req, user)
+
+		assert.StatusCodeEquals(t, res, http.StatusOK, "")
+
+		var webhookCount, deliveryCount int
+		testutils.MustExec(t, db.Model(&database.Webhook{}).Count(&webhookCount), "counting webhooks")
+		testutils.MustExec(t, db.Model(&database.WebhookDelivery{}).Count(&deliveryCount), "counting deliveries")
+		assert.Equal(t, webhookCount, 1, "webhook count mismatch")
+		assert.Equal(t, deliveryCount, 0, "delivery count mismatch")
+	})
+
+	t.Run("webhook of another user", func(t *testing.T) {
+		endpoint := fmt.Sprintf("/v3/webhooks/%s", w2.UUID)
+		req := testutils.MakeReq(server, "DELETE", endpoint, "")
+		res := testutils.HTTPAuthDo(t, req, user)
+
+		assert.StatusCodeEquals(t, res, http.StatusNotFound, "")
+
+		var webhookCount int
+		testutils.MustExec(t, db.Model(&database.Webhook{}).Count(&webhookCount), "counting webhooks")
+		assert.Equal(t, webhookCount, 1, "webhook count mismatch")
+	})
+}
+
+func TestGetWebhookDeliveries(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	// Setup
+	server := httptest.NewServer(NewRouter(&App{
+		Clock: clock.NewMock(),
+	}))
+	defer server.Close()
+
+	user := testutils.SetupUserData()
+	w1 := database.Webhook{UserID: user.ID, URL: "https://example.com/1", Events: []string{"note.created"}}
+	testutils.MustExec(t, db.Save(&w1), "preparing w1")
+	w2 := database.Webhook{UserID: user.ID, URL: "https://example.com/2", Events: []string{"note.created"}}
+	testutils.MustExec(t, db.Save(&w2), "preparing w2")
+
+	d1 := database.WebhookDelivery{WebhookID: w1.ID, Event: "note.created", Status: database.WebhookDeliveryStatusSucceeded}
+	testutils.MustExec(t, db.Save(&d1), "preparing d1")
+	d2 := database.WebhookDelivery{WebhookID: w1.ID, Event: "note.created", Status: database.WebhookDeliveryStatusPending}
+	testutils.MustExec(t, db.Save(&d2), "preparing d2")
+	d3 := database.WebhookDelivery{WebhookID: w2.ID, Event: "note.created", Status: database.WebhookDeliveryStatusPending}
+	testutils.MustExec(t, db.Save(&d3), "preparing d3")
+
+	// Execute
+	endpoint := fmt.Sprintf("/v3/webhooks/%s/deliveries", w1.UUID)
+	req := testutils.MakeReq(server, "GET", endpoint, "")
+	res := testutils.HTTPAuthDo(t, req, user)
+
+	// Test
+	assert.StatusCodeEquals(t, res, http.StatusOK, "")
+
+	var payload GetWebhookDeliveriesResp
+	if err := json.NewDecoder(res.Body).Decode(&payload); err != nil {
+		t.Fatal(errors.Wrap(err, "decoding payload"))
+	}
+
+	assert.Equal(t, payload.Total, 2, "total mismatch")
+	assert.Equal(t, len(payload.Deliveries), 2, "deliveries length mismatch")
+	assert.Equal(t, payload.Deliveries[0].UUID, d2.UUID, "deliveries[0] mismatch")
+	assert.Equal(t, payload.Deliveries[1].UUID, d1.UUID, "deliveries[1] mismatch")
+}
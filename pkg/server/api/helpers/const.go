@@ -25,4 +25,6 @@ const (
 	KeyUser key = iota
 	// KeyToken is a key for a token in a context
 	KeyToken
+	// KeyRequestID is a key for the ID of a request in a context
+	KeyRequestID
 )
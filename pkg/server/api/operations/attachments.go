@@ -0,0 +1,138 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/api/helpers"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// ErrQuotaExceeded is an error for an attachment that would make the total size
+// of the attachments of a user exceed the quota
+var ErrQuotaExceeded = errors.New("attachment quota exceeded")
+
+// AttachmentParams is the metadata of an attachment whose content has been stored
+type AttachmentParams struct {
+	Name        string
+	ContentType string
+	Size        int64
+	Hash        string
+}
+
+// GetAttachmentUsage returns the total size in bytes of the attachments of the user
+func GetAttachmentUsage(db *gorm.DB, userID int) (int64, error) {
+	var ret struct {
+		Total int64
+	}
+	if err := db.Table("attachments").Select("COALESCE(SUM(size), 0) AS total").Where("user_id = ?", userID).Scan(&ret).Error; err != nil {
+		return 0, errors.Wrap(err, "summing attachment sizes")
+	}
+
+	return ret.Total, nil
+}
+
+// CreateAttachment attaches the content described by the params to the note. The
+// attachment counts against the quota of the note owner, and is rejected with
+// ErrQuotaExceeded if it does not fit. A quota of 0 means no limit. The note is
+// updated with the next usn so that clients sync the attachment.
+func CreateAttachment(tx *gorm.DB, user database.User, clock clock.Clock, note database.Note, p AttachmentParams, quota int64) (database.Attachment, database.Note, error) {
+	// Lock the owner so that concurrent uploads cannot exceed the quota together
+	var owner database.User
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", note.UserID).First(&owner).Error; err != nil {
+		return database.Attachment{}, note, errors.Wrap(err, "locking note owner")
+	}
+
+	if quota > 0 {
+		usage, err := GetAttachmentUsage(tx, owner.ID)
+		if err != nil {
+			return database.Attachment{}, note, err
+		}
+		if usage+p.Size > quota {
+			return database.Attachment{}, note, ErrQuotaExceeded
+		}
+	}
+
+	uuid, err := helpers.GenUUID()
+	if err != nil {
+		return database.Attachment{}, note, err
+	}
+
+	attachment := database.Attachment{
+		UUID:        uuid,
+		NoteUUID:    note.UUID,
+		UserID:      owner.ID,
+		UploaderID:  user.ID,
+		Name:        p.Name,
+		ContentType: p.ContentType,
+		Size:        p.Size,
+		Hash:        p.Hash,
+	}
+	if err := tx.Create(&attachment).Error; err != nil {
+		return attachment, note, errors.Wrap(err, "inserting attachment")
+	}
+
+	n, err := UpdateNote(tx, user, clock, note, nil, nil)
+	if err != nil {
+		return attachment, note, errors.Wrap(err, "updating note")
+	}
+
+	return attachment, n, nil
+}
+
+// DeleteAttachment deletes the attachment from the note, and updates the note with
+// the next usn so that clients sync the deletion. The content of the attachment
+// is not deleted from the storage. See IsBlobReferenced.
+func DeleteAttachment(tx *gorm.DB, user database.User, clock clock.Clock, note database.Note, attachment database.Attachment) (database.Note, error) {
+	if err := tx.Delete(&attachment).Error; err != nil {
+		return note, errors.Wrap(err, "deleting attachment")
+	}
+
+	n, err := UpdateNote(tx, user, clock, note, nil, nil)
+	if err != nil {
+		return note, errors.Wrap(err, "updating note")
+	}
+
+	return n, nil
+}
+
+// LockBlob locks the content with the given hash until the end of the transaction.
+// The content is locked while it is stored for a new attachment and while it is
+// checked for references before deletion, so that it is not deleted from the
+// storage right before an attachment refers to it.
+func LockBlob(tx *gorm.DB, hash string) error {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", hash).Error; err != nil {
+		return errors.Wrap(err, "locking blob")
+	}
+
+	return nil
+}
+
+// IsBlobReferenced checks if any attachment has the content with the given hash.
+// Content that is not referenced can be deleted from the storage.
+func IsBlobReferenced(db *gorm.DB, hash string) (bool, error) {
+	var count int
+	if err := db.Model(&database.Attachment{}).Where("hash = ?", hash).Count(&count).Error; err != nil {
+		return false, errors.Wrap(err, "counting attachments")
+	}
+
+	return count > 0, nil
+}
@@ -0,0 +1,189 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestCreateAttachment(t *testing.T) {
+	testCases := []struct {
+		quota         int64
+		existingSize  int64
+		expectedError error
+	}{
+		{
+			quota:         0,
+			existingSize:  1000,
+			expectedError: nil,
+		},
+		{
+			quota:         1000,
+			existingSize:  900,
+			expectedError: nil,
+		},
+		{
+			quota:         1000,
+			existingSize:  901,
+			expectedError: ErrQuotaExceeded,
+		},
+	}
+
+	for _, tc := range testCases {
+		func() {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			user := testutils.SetupUserData()
+			testutils.MustExec(t, db.Model(&user).Update("max_usn", 4), "preparing user max_usn")
+
+			b1 := database.Book{UserID: user.ID, Label: "testBook"}
+			testutils.MustExec(t, db.Save(&b1), "preparing b1")
+			note := database.Note{UserID: user.ID, Body: "test content", BookUUID: b1.UUID, USN: 4}
+			testutils.MustExec(t, db.Save(&note), "preparing note")
+
+			a1 := database.Attachment{NoteUUID: note.UUID, UserID: user.ID, Name: "a1.png", Size: tc.existingSize, Hash: "a1"}
+			testutils.MustExec(t, db.Save(&a1), "preparing a1")
+
+			p := AttachmentParams{
+				Name:        "diagram.png",
+				ContentType: "image/png",
+				Size:        100,
+				Hash:        "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+			}
+
+			tx := db.Begin()
+			attachment, n, err := CreateAttachment(tx, user, clock.NewMock(), note, p, tc.quota)
+			if err != nil {
+				tx.Rollback()
+
+				assert.Equal(t, err, tc.expectedError, "error mismatch")
+				return
+			}
+			tx.Commit()
+
+			assert.Equal(t, tc.expectedError, nil, "expected an error")
+
+			var attachmentCount int
+			var attachmentRecord database.Attachment
+			var userRecord database.User
+			testutils.MustExec(t, db.Model(&database.Attachment{}).Count(&attachmentCount), "counting attachments")
+			testutils.MustExec(t, db.Where("uuid = ?", attachment.UUID).First(&attachmentRecord), "finding attachment")
+			testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), "finding user")
+
+			assert.Equal(t, attachmentCount, 2, "attachment count mismatch")
+			assert.Equal(t, attachmentRecord.NoteUUID, note.UUID, "attachment NoteUUID mismatch")
+			assert.Equal(t, attachmentRecord.UserID, user.ID, "attachment UserID mismatch")
+			assert.Equal(t, attachmentRecord.Name, p.Name, "attachment Name mismatch")
+			assert.Equal(t, attachmentRecord.Size, p.Size, "attachment Size mismatch")
+			assert.Equal(t, attachmentRecord.Hash, p.Hash, "attachment Hash mismatch")
+			assert.Equal(t, n.USN, 5, "note USN mismatch")
+			assert.Equal(t, userRecord.MaxUSN, 5, "user MaxUSN mismatch")
+		}()
+	}
+}
+
+func TestDeleteAttachment(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&user).Update("max_usn", 4), "preparing user max_usn")
+
+	b1 := database.Book{UserID: user.ID, Label: "testBook"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	note := database.Note{UserID: user.ID, Body: "test content", BookUUID: b1.UUID, USN: 4}
+	testutils.MustExec(t, db.Save(&note), "preparing note")
+
+	a1 := database.Attachment{NoteUUID: note.UUID, UserID: user.ID, Name: "a1.png", Size: 10, Hash: "h1"}
+	testutils.MustExec(t, db.Save(&a1), "preparing a1")
+	a2 := database.Attachment{NoteUUID: note.UUID, UserID: user.ID, Name: "a2.png", Size: 10, Hash: "h1"}
+	testutils.MustExec(t, db.Save(&a2), "preparing a2")
+
+	tx := db.Begin()
+	n, err := DeleteAttachment(tx, user, clock.NewMock(), note, a1)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "deleting attachment"))
+	}
+	tx.Commit()
+
+	var attachmentCount int
+	testutils.MustExec(t, db.Model(&database.Attachment{}).Count(&attachmentCount), "counting attachments")
+	assert.Equal(t, attachmentCount, 1, "attachment count mismatch")
+	assert.Equal(t, n.USN, 5, "note USN mismatch")
+
+	// the content is still referenced by a2
+	referenced, err := IsBlobReferenced(db, "h1")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "checking reference"))
+	}
+	assert.Equal(t, referenced, true, "h1 referenced mismatch")
+
+	referenced, err = IsBlobReferenced(db, "h2")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "checking reference"))
+	}
+	assert.Equal(t, referenced, false, "h2 referenced mismatch")
+}
+
+func TestLockBlob(t *testing.T) {
+	db := database.DBConn
+	hash := "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+	tx1 := db.Begin()
+	if err := LockBlob(tx1, hash); err != nil {
+		tx1.Rollback()
+		t.Fatal(errors.Wrap(err, "locking in tx1"))
+	}
+
+	locked := make(chan error, 1)
+	go func() {
+		tx2 := db.Begin()
+		defer tx2.Rollback()
+
+		locked <- LockBlob(tx2, hash)
+	}()
+
+	// the lock is held until the end of the first transaction
+	select {
+	case <-locked:
+		tx1.Rollback()
+		t.Fatal("the second transaction acquired the lock held by the first transaction")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	tx1.Commit()
+
+	select {
+	case err := <-locked:
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "locking in tx2"))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the second transaction did not acquire the lock")
+	}
+}
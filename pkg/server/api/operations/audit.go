@@ -0,0 +1,47 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"time"
+
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// RecordAuditEvent saves the given audit event
+func RecordAuditEvent(db *gorm.DB, event database.AuditEvent) error {
+	if err := db.Create(&event).Error; err != nil {
+		return errors.Wrap(err, "saving audit event")
+	}
+
+	return nil
+}
+
+// PurgeAuditEvents deletes the audit events created before the given time and
+// returns the number of deleted events
+func PurgeAuditEvents(db *gorm.DB, before time.Time) (int, error) {
+	conn := db.Where("created_at < ?", before).Delete(&database.AuditEvent{})
+	if err := conn.Error; err != nil {
+		return 0, errors.Wrap(err, "deleting audit events")
+	}
+
+	return int(conn.RowsAffected), nil
+}
@@ -0,0 +1,56 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestPurgeAuditEvents(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	now := time.Date(2019, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	e1 := database.AuditEvent{UserID: user.ID, ActorID: user.ID, Type: database.AuditEventSignin}
+	testutils.MustExec(t, db.Save(&e1), "preparing e1")
+	testutils.MustExec(t, db.Model(&e1).Update("created_at", now.Add(-48*time.Hour)), "preparing e1 created_at")
+	e2 := database.AuditEvent{UserID: user.ID, ActorID: user.ID, Type: database.AuditEventSignout}
+	testutils.MustExec(t, db.Save(&e2), "preparing e2")
+	testutils.MustExec(t, db.Model(&e2).Update("created_at", now.Add(-time.Hour)), "preparing e2 created_at")
+
+	count, err := PurgeAuditEvents(db, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	assert.Equal(t, count, 1, "count mismatch")
+
+	var events []database.AuditEvent
+	testutils.MustExec(t, db.Find(&events), "finding events")
+	assert.Equal(t, len(events), 1, "event count mismatch")
+	assert.Equal(t, events[0].ID, e2.ID, "remaining event mismatch")
+}
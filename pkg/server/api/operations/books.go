@@ -22,12 +22,14 @@ import (
 	"github.com/dnote/dnote/pkg/clock"
 	"github.com/dnote/dnote/pkg/server/api/helpers"
 	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/webhook"
 	"github.com/jinzhu/gorm"
 	"github.com/pkg/errors"
 )
 
-// CreateBook creates a book with the next usn and updates the user's max_usn
-func CreateBook(user database.User, clock clock.Clock, name string) (database.Book, error) {
+// CreateBook creates a book with the next usn and updates the user's max_usn.
+// The book is nested in the book with the given parentUUID unless it is empty.
+func CreateBook(user database.User, clock clock.Clock, name, parentUUID string) (database.Book, error) {
 	db := database.DBConn
 	tx := db.Begin()
 
@@ -43,25 +45,32 @@ func CreateBook(user database.User, clock clock.Clock, name string) (database.Bo
 	}
 
 	book := database.Book{
-		UUID:      uuid,
-		UserID:    user.ID,
-		Label:     name,
-		AddedOn:   clock.Now().UnixNano(),
-		USN:       nextUSN,
-		Encrypted: false,
+		UUID:       uuid,
+		UserID:     user.ID,
+		Label:      name,
+		ParentUUID: parentUUID,
+		AddedOn:    clock.Now().UnixNano(),
+		USN:        nextUSN,
+		Encrypted:  false,
 	}
 	if err := tx.Create(&book).Error; err != nil {
 		tx.Rollback()
 		return book, errors.Wrap(err, "inserting book")
 	}
 
+	if err := webhook.Enqueue(tx, clock, user.ID, database.WebhookEventBookCreated, webhook.NewBookData(book)); err != nil {
+		tx.Rollback()
+		return book, errors.Wrap(err, "enqueueing webhook deliveries")
+	}
+
 	tx.Commit()
 
 	return book, nil
 }
 
-// DeleteBook marks a book deleted with the next usn and updates the user's max_usn
-func DeleteBook(tx *gorm.DB, user database.User, book database.Book) (database.Book, error) {
+// DeleteBook marks a book deleted with the next usn and updates the user's max_usn.
+// The books nested in the book are moved into the parent of the book.
+func DeleteBook(tx *gorm.DB, c clock.Clock, user database.User, book database.Book) (database.Book, error) {
 	if user.ID != book.UserID {
 		return book, errors.New("Not allowed")
 	}
@@ -71,6 +80,9 @@ func DeleteBook(tx *gorm.DB, user database.User, book database.Book) (database.B
 		return book, errors.Wrap(err, "incrementing user max_usn")
 	}
 
+	// present the book before its label is cleared
+	data := webhook.NewBookData(book)
+
 	if err := tx.Model(&book).
 		Update(map[string]interface{}{
 			"usn":     nextUSN,
@@ -80,16 +92,53 @@ func DeleteBook(tx *gorm.DB, user database.User, book database.Book) (database.B
 		return book, errors.Wrap(err, "deleting book")
 	}
 
+	data.USN = nextUSN
+	if err := webhook.Enqueue(tx, c, user.ID, database.WebhookEventBookDeleted, data); err != nil {
+		return book, errors.Wrap(err, "enqueueing webhook deliveries")
+	}
+
+	if _, err := recordSharedChange(tx, book.UUID, user.ID, database.SharedUSNTypeBook, book.UUID); err != nil {
+		return book, errors.Wrap(err, "recording the change for book members")
+	}
+
+	var children []database.Book
+	if err := tx.Where("parent_uuid = ? AND NOT deleted", book.UUID).Order("id ASC").Find(&children).Error; err != nil {
+		return book, errors.Wrap(err, "finding nested books")
+	}
+	for _, child := range children {
+		childUSN, err := incrementUserUSN(tx, child.UserID)
+		if err != nil {
+			return book, errors.Wrap(err, "incrementing user max_usn")
+		}
+
+		if err := tx.Model(&child).
+			Update(map[string]interface{}{
+				"usn":         childUSN,
+				"parent_uuid": book.ParentUUID,
+			}).Error; err != nil {
+			return book, errors.Wrap(err, "moving a nested book")
+		}
+	}
+
 	return book, nil
 }
 
-// UpdateBook updaates the book, the usn and the user's max_usn
-func UpdateBook(tx *gorm.DB, c clock.Clock, user database.User, book database.Book, label *string) (database.Book, error) {
-	if user.ID != book.UserID {
+// UpdateBook updaates the book, the usn and the max_usn of the book owner. If the user is
+// a member of the book rather than its owner, the usn of the returned book is in the
+// sequence of the user. Only the owner can move the book by changing its parentUUID.
+func UpdateBook(tx *gorm.DB, c clock.Clock, user database.User, book database.Book, label, parentUUID *string) (database.Book, error) {
+	role, err := GetBookRole(tx, user.ID, book)
+	if err != nil {
+		return book, errors.Wrap(err, "getting book role")
+	}
+	if !CanManageBook(role) {
+		return book, errors.New("Not allowed")
+	}
+	if parentUUID != nil && *parentUUID != book.ParentUUID && user.ID != book.UserID {
 		return book, errors.New("Not allowed")
 	}
 
-	nextUSN, err := incrementUserUSN(tx, user.ID)
+	nextUSN, err := incrementUserUSN(tx, book.UserID)
 	if err != nil {
 		return book, errors.Wrap(err, "incrementing user max_usn")
 	}
@@ -97,6 +146,9 @@ func UpdateBook(tx *gorm.DB, c clock.Clock, user database.User, book database.Bo
 	if label != nil {
 		book.Label = *label
 	}
+	if parentUUID != nil {
+		book.ParentUUID = *parentUUID
+	}
 
 	book.USN = nextUSN
 	book.EditedOn = c.Now().UnixNano()
@@ -108,5 +160,17 @@ func UpdateBook(tx *gorm.DB, c clock.Clock, user database.User, book database.Bo
 		return book, errors.Wrap(err, "updating the book")
 	}
 
+	if err := webhook.Enqueue(tx, c, book.UserID, database.WebhookEventBookUpdated, webhook.NewBookData(book)); err != nil {
+		return book, errors.Wrap(err, "enqueueing webhook deliveries")
+	}
+
+	memberUSN, err := recordSharedChange(tx, book.UUID, user.ID, database.SharedUSNTypeBook, book.UUID)
+	if err != nil {
+		return book, errors.Wrap(err, "recording the change for book members")
+	}
+	if memberUSN != 0 {
+		book.USN = memberUSN
+	}
+
 	return book, nil
 }
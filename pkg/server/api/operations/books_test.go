@@ -69,7 +69,7 @@ func TestCreateBook(t *testing.T) {
 
 			c := clock.NewMock()
 
-			book, err := CreateBook(user, c, tc.label)
+			book, err := CreateBook(user, c, tc.label, "")
 			if err != nil {
 				t.Fatal(errors.Wrap(err, "creating book"))
 			}
@@ -136,7 +136,7 @@ func TestDeleteBook(t *testing.T) {
 			testutils.MustExec(t, db.Save(&book), fmt.Sprintf("preparing book for test case %d", idx))
 
 			tx := db.Begin()
-			ret, err := DeleteBook(tx, user, book)
+			ret, err := DeleteBook(tx, clock.NewMock(), user, book)
 			if err != nil {
 				tx.Rollback()
 				t.Fatal(errors.Wrap(err, "deleting book"))
@@ -217,7 +217,7 @@ func TestUpdateBook(t *testing.T) {
 
 			tx := db.Begin()
 
-			book, err := UpdateBook(tx, c, user, b, tc.payloadLabel)
+			book, err := UpdateBook(tx, c, user, b, tc.payloadLabel, nil)
 			if err != nil {
 				tx.Rollback()
 				t.Fatal(errors.Wrap(err, "updating book"))
@@ -247,3 +247,81 @@ func TestUpdateBook(t *testing.T) {
 		}()
 	}
 }
+
+func TestDeleteBook_nested(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&user).Update("max_usn", 10), "preparing user max_usn")
+
+	b1 := database.Book{UserID: user.ID, Label: "infra"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	b2 := database.Book{UserID: user.ID, Label: "k8s", ParentUUID: b1.UUID}
+	testutils.MustExec(t, db.Save(&b2), "preparing b2")
+	b3 := database.Book{UserID: user.ID, Label: "networking", ParentUUID: b2.UUID, USN: 3}
+	testutils.MustExec(t, db.Save(&b3), "preparing b3")
+
+	tx := db.Begin()
+	if _, err := DeleteBook(tx, clock.NewMock(), user, b2); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "deleting book"))
+	}
+	tx.Commit()
+
+	var b3Record database.Book
+	var userRecord database.User
+	testutils.MustExec(t, db.Where("id = ?", b3.ID).First(&b3Record), "finding b3")
+	testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), "finding user")
+
+	assert.Equal(t, b3Record.ParentUUID, b1.UUID, "b3 parent_uuid mismatch")
+	assert.Equal(t, b3Record.Deleted, false, "b3 deleted flag mismatch")
+	assert.Equal(t, b3Record.USN, 12, "b3 usn mismatch")
+	assert.Equal(t, userRecord.MaxUSN, 12, "user max_usn mismatch")
+}
+
+func TestUpdateBook_parent(t *testing.T) {
+	t.Run("owner", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		user := testutils.SetupUserData()
+		b1 := database.Book{UserID: user.ID, Label: "infra"}
+		testutils.MustExec(t, db.Save(&b1), "preparing b1")
+		b2 := database.Book{UserID: user.ID, Label: "k8s"}
+		testutils.MustExec(t, db.Save(&b2), "preparing b2")
+
+		tx := db.Begin()
+		book, err := UpdateBook(tx, clock.NewMock(), user, b2, nil, &b1.UUID)
+		if err != nil {
+			tx.Rollback()
+			t.Fatal(errors.Wrap(err, "updating book"))
+		}
+		tx.Commit()
+
+		var bookRecord database.Book
+		testutils.MustExec(t, db.Where("id = ?", b2.ID).First(&bookRecord), "finding book")
+		assert.Equal(t, bookRecord.ParentUUID, b1.UUID, "book parent_uuid mismatch")
+		assert.Equal(t, bookRecord.Label, "k8s", "book label mismatch")
+		assert.Equal(t, book.ParentUUID, b1.UUID, "returned book parent_uuid mismatch")
+	})
+
+	t.Run("member", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		owner := testutils.SetupUserData()
+		member := testutils.SetupUserData()
+		b1 := database.Book{UserID: member.ID, Label: "infra"}
+		testutils.MustExec(t, db.Save(&b1), "preparing b1")
+		b2 := database.Book{UserID: owner.ID, Label: "k8s"}
+		testutils.MustExec(t, db.Save(&b2), "preparing b2")
+		testutils.MustExec(t, db.Save(&database.BookMember{BookID: b2.ID, UserID: member.ID, Role: database.BookMemberRoleOwner}), "preparing member")
+
+		tx := db.Begin()
+		_, err := UpdateBook(tx, clock.NewMock(), member, b2, nil, &b1.UUID)
+		tx.Rollback()
+
+		assert.Equal(t, err.Error(), "Not allowed", "error mismatch")
+	})
+}
@@ -0,0 +1,132 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"strings"
+
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/wikilink"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// findBookByPath returns the uuid of the book of the given user at the given path,
+// in which the names of the nested books are separated by slashes. It returns an
+// empty string if no such book exists.
+func findBookByPath(tx *gorm.DB, userID int, path string) (string, error) {
+	var parentUUID string
+	for _, label := range strings.Split(path, "/") {
+		var book database.Book
+		conn := tx.Where("user_id = ? AND label = ? AND parent_uuid = ? AND NOT deleted", userID, label, parentUUID).First(&book)
+		if conn.RecordNotFound() {
+			return "", nil
+		} else if err := conn.Error; err != nil {
+			return "", errors.Wrap(err, "finding the book")
+		}
+
+		parentUUID = book.UUID
+	}
+
+	return parentUUID, nil
+}
+
+// resolveLink returns the uuid of the note that the given link refers to. A link
+// by book and title refers to a note in a book of the given user. It returns an
+// empty string if no such note exists.
+func resolveLink(tx *gorm.DB, userID int, l wikilink.Link) (string, error) {
+	if l.UUID != "" {
+		return l.UUID, nil
+	}
+
+	bookUUID, err := findBookByPath(tx, userID, l.Book)
+	if err != nil {
+		return "", err
+	}
+	if bookUUID == "" {
+		return "", nil
+	}
+
+	var notes []database.Note
+	if err := tx.Select("notes.uuid, notes.body").
+		Where("notes.book_uuid = ? AND notes.deleted = ?", bookUUID, false).
+		Order("notes.added_on ASC").
+		Find(&notes).Error; err != nil {
+		return "", errors.Wrap(err, "finding notes in the book")
+	}
+
+	for _, n := range notes {
+		if wikilink.Title(n.Body) == l.Title {
+			return n.UUID, nil
+		}
+	}
+
+	return "", nil
+}
+
+// UpdateNoteLinks replaces the links from the given note with the links in its
+// body. A link that no longer resolves, for instance because its target was moved
+// to another book, keeps the target it had before.
+func UpdateNoteLinks(tx *gorm.DB, note database.Note) error {
+	var prevLinks []database.NoteLink
+	if err := tx.Where("source_uuid = ?", note.UUID).Find(&prevLinks).Error; err != nil {
+		return errors.Wrap(err, "finding links")
+	}
+
+	prev := map[string]string{}
+	for _, l := range prevLinks {
+		prev[l.Text] = l.TargetUUID
+	}
+
+	if err := deleteNoteLinks(tx, note.UUID); err != nil {
+		return err
+	}
+
+	for _, l := range wikilink.Parse(note.Body) {
+		target, err := resolveLink(tx, note.UserID, l)
+		if err != nil {
+			return errors.Wrapf(err, "resolving the link %s", l.Text)
+		}
+		if target == "" {
+			target = prev[l.Text]
+		}
+		if target == "" || target == note.UUID {
+			continue
+		}
+
+		link := database.NoteLink{
+			SourceUUID: note.UUID,
+			TargetUUID: target,
+			Text:       l.Text,
+		}
+		if err := tx.Create(&link).Error; err != nil {
+			return errors.Wrap(err, "inserting a link")
+		}
+	}
+
+	return nil
+}
+
+func deleteNoteLinks(tx *gorm.DB, noteUUID string) error {
+	if err := tx.Where("source_uuid = ?", noteUUID).Delete(&database.NoteLink{}).Error; err != nil {
+		return errors.Wrap(err, "deleting links")
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func getLinkTargets(t *testing.T, sourceUUID string) map[string]string {
+	var links []database.NoteLink
+	testutils.MustExec(t, database.DBConn.Where("source_uuid = ?", sourceUUID).Find(&links), "finding links")
+
+	ret := map[string]string{}
+	for _, l := range links {
+		ret[l.Text] = l.TargetUUID
+	}
+
+	return ret
+}
+
+func TestNoteLinks(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+	c := clock.NewMock()
+
+	user := testutils.SetupUserData()
+	anotherUser := testutils.SetupUserData()
+
+	b1 := database.Book{UserID: user.ID, Label: "js"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	b2 := database.Book{UserID: user.ID, Label: "go"}
+	testutils.MustExec(t, db.Save(&b2), "preparing b2")
+	b3 := database.Book{UserID: anotherUser.ID, Label: "js"}
+	testutils.MustExec(t, db.Save(&b3), "preparing b3")
+
+	n0, err := CreateNote(anotherUser, c, b3.UUID, "closures", nil, nil, false)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating n0"))
+	}
+	n1, err := CreateNote(user, c, b1.UUID, "# closures\ncontent", nil, nil, false)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating n1"))
+	}
+	n2, err := CreateNote(user, c, b2.UUID, "goroutines", nil, nil, false)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating n2"))
+	}
+	n3, err := CreateNote(user, c, b1.UUID, fmt.Sprintf("see [[js/closures]], [[%s]] and [[js/missing]]", n2.UUID), nil, nil, false)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating n3"))
+	}
+
+	assert.DeepEqual(t, getLinkTargets(t, n3.UUID), map[string]string{
+		"js/closures": n1.UUID,
+		n2.UUID:       n2.UUID,
+	}, "links mismatch after create")
+	assert.DeepEqual(t, getLinkTargets(t, n0.UUID), map[string]string{}, "n0 links mismatch")
+
+	// moving the target and renaming its book does not break the link
+	tx := db.Begin()
+	if _, err := UpdateNote(tx, user, c, n1, &b2.UUID, nil); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "moving n1"))
+	}
+	tx.Commit()
+	testutils.MustExec(t, db.Model(&b1).Update("label", "javascript"), "renaming b1")
+
+	content := "see [[js/closures]] only"
+	tx = db.Begin()
+	if _, err := UpdateNote(tx, user, c, n3, nil, &content); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "editing n3"))
+	}
+	tx.Commit()
+
+	assert.DeepEqual(t, getLinkTargets(t, n3.UUID), map[string]string{
+		"js/closures": n1.UUID,
+	}, "links mismatch after update")
+
+	tx = db.Begin()
+	if _, err := DeleteNote(tx, user, clock.NewMock(), n3); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "deleting n3"))
+	}
+	tx.Commit()
+
+	var linkCount int
+	testutils.MustExec(t, db.Model(&database.NoteLink{}).Count(&linkCount), "counting links")
+	assert.Equal(t, linkCount, 0, "link count mismatch")
+}
+
+func TestNoteLinks_nestedBook(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+	c := clock.NewMock()
+
+	user := testutils.SetupUserData()
+
+	b1 := database.Book{UserID: user.ID, Label: "infra"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	b2 := database.Book{UserID: user.ID, Label: "k8s", ParentUUID: b1.UUID}
+	testutils.MustExec(t, db.Save(&b2), "preparing b2")
+	// a top level book with the same label should not be confused with the nested one
+	b3 := database.Book{UserID: user.ID, Label: "k8s"}
+	testutils.MustExec(t, db.Save(&b3), "preparing b3")
+
+	n1, err := CreateNote(user, c, b2.UUID, "networking", nil, nil, false)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating n1"))
+	}
+	n2, err := CreateNote(user, c, b3.UUID, "networking", nil, nil, false)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating n2"))
+	}
+	n3, err := CreateNote(user, c, b3.UUID, "see [[infra/k8s/networking]] and [[k8s/networking]]", nil, nil, false)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating n3"))
+	}
+
+	assert.DeepEqual(t, getLinkTargets(t, n3.UUID), map[string]string{
+		"infra/k8s/networking": n1.UUID,
+		"k8s/networking":       n2.UUID,
+	}, "links mismatch")
+}
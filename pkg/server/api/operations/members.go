@@ -0,0 +1,297 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"strings"
+	"time"
+
+	"github.com/dnote/dnote/pkg/server/api/crypt"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrBookMemberExists is an error for adding a user who is already a member of a book
+	ErrBookMemberExists = errors.New("the user is already a member of the book")
+	// ErrInvitationEmailMismatch is an error for accepting an invitation sent to another email
+	ErrInvitationEmailMismatch = errors.New("the invitation was sent to a different email")
+	// ErrInvitationAccepted is an error for accepting an invitation more than once
+	ErrInvitationAccepted = errors.New("the invitation has already been accepted")
+	// ErrInvitationExpired is an error for accepting an invitation after it expired
+	ErrInvitationExpired = errors.New("the invitation has expired")
+	// ErrNotBookMember is an error for leaving a book of which the user is not a member
+	ErrNotBookMember = errors.New("the user is not a member of the book")
+)
+
+// BookInvitationTTL is the duration for which a book invitation can be accepted
+const BookInvitationTTL = 7 * 24 * time.Hour
+
+// ValidateBookMemberRole validates the given role of a book member
+func ValidateBookMemberRole(role string) error {
+	switch role {
+	case database.BookMemberRoleOwner, database.BookMemberRoleEditor, database.BookMemberRoleViewer:
+		return nil
+	}
+
+	return errors.Errorf("invalid role %s", role)
+}
+
+// GetBookRole returns the role of the user in the given book. The user who
+// created the book is always an owner. It returns an empty string if the user
+// has no access to the book.
+func GetBookRole(tx *gorm.DB, userID int, book database.Book) (string, error) {
+	if book.UserID == userID {
+		return database.BookMemberRoleOwner, nil
+	}
+
+	var member database.BookMember
+	conn := tx.Where("book_id = ? AND user_id = ?", book.ID, userID).First(&member)
+	if conn.RecordNotFound() {
+		return "", nil
+	} else if err := conn.Error; err != nil {
+		return "", errors.Wrap(err, "finding book member")
+	}
+
+	return member.Role, nil
+}
+
+// CanEditNotes returns true if the given role can add, edit and remove notes in a book
+func CanEditNotes(role string) bool {
+	return role == database.BookMemberRoleOwner || role == database.BookMemberRoleEditor
+}
+
+// CanManageBook returns true if the given role can rename a book and manage its members
+func CanManageBook(role string) bool {
+	return role == database.BookMemberRoleOwner
+}
+
+// bumpSharedUSN increments the max_usn of the given user and records it as the
+// usn of the shared item in the user's sequence. It returns the new usn.
+func bumpSharedUSN(tx *gorm.DB, userID int, itemType, uuid string) (int, error) {
+	nextUSN, err := incrementUserUSN(tx, userID)
+	if err != nil {
+		return 0, errors.Wrap(err, "incrementing user max_usn")
+	}
+
+	var s database.SharedUSN
+	if err := tx.
+		Where(database.SharedUSN{UserID: userID, Type: itemType, UUID: uuid}).
+		Assign(database.SharedUSN{USN: nextUSN}).
+		FirstOrCreate(&s).Error; err != nil {
+		return 0, errors.Wrap(err, "saving shared usn")
+	}
+
+	return nextUSN, nil
+}
+
+// recordSharedChange records a change of an item in the book with the given uuid
+// for all members of the book, so that the change is included in their sync
+// fragments. It returns the usn of the change in the sequence of the actor if
+// the actor is a member of the book, and 0 otherwise.
+func recordSharedChange(tx *gorm.DB, bookUUID string, actorID int, itemType, uuid string) (int, error) {
+	var members []database.BookMember
+	if err := tx.Joins("INNER JOIN books ON books.id = book_members.book_id").
+		Where("books.uuid = ?", bookUUID).
+		Order("book_members.id ASC").
+		Find(&members).Error; err != nil {
+		return 0, errors.Wrap(err, "finding book members")
+	}
+
+	var ret int
+	for _, member := range members {
+		usn, err := bumpSharedUSN(tx, member.UserID, itemType, uuid)
+		if err != nil {
+			return 0, errors.Wrapf(err, "bumping usn for member %d", member.UserID)
+		}
+
+		if member.UserID == actorID {
+			ret = usn
+		}
+	}
+
+	return ret, nil
+}
+
+// syncBookToUser records the book and all of its notes as changed for the given
+// user, so that they are added to, or removed from, the user's local copy in
+// the next sync depending on whether the user has access to the book.
+func syncBookToUser(tx *gorm.DB, book database.Book, userID int) error {
+	if _, err := bumpSharedUSN(tx, userID, database.SharedUSNTypeBook, book.UUID); err != nil {
+		return errors.Wrap(err, "bumping usn for the book")
+	}
+
+	var notes []database.Note
+	if err := tx.Where("book_uuid = ? AND NOT deleted", book.UUID).Order("usn ASC").Find(&notes).Error; err != nil {
+		return errors.Wrap(err, "finding notes")
+	}
+
+	for _, note := range notes {
+		if _, err := bumpSharedUSN(tx, userID, database.SharedUSNTypeNote, note.UUID); err != nil {
+			return errors.Wrapf(err, "bumping usn for the note %s", note.UUID)
+		}
+	}
+
+	return nil
+}
+
+// AddBookMember adds the user as a member of the book with the given role
+func AddBookMember(tx *gorm.DB, book database.Book, user database.User, role string) (database.BookMember, error) {
+	if err := ValidateBookMemberRole(role); err != nil {
+		return database.BookMember{}, err
+	}
+
+	currentRole, err := GetBookRole(tx, user.ID, book)
+	if err != nil {
+		return database.BookMember{}, errors.Wrap(err, "getting the current role")
+	}
+	if currentRole != "" {
+		return database.BookMember{}, ErrBookMemberExists
+	}
+
+	member := database.BookMember{
+		BookID: book.ID,
+		UserID: user.ID,
+		Role:   role,
+	}
+	if err := tx.Create(&member).Error; err != nil {
+		return member, errors.Wrap(err, "inserting book member")
+	}
+
+	if err := syncBookToUser(tx, book, user.ID); err != nil {
+		return member, errors.Wrap(err, "syncing the book to the member")
+	}
+
+	return member, nil
+}
+
+// UpdateBookMemberRole changes the role of a book member
+func UpdateBookMemberRole(tx *gorm.DB, member database.BookMember, role string) (database.BookMember, error) {
+	if err := ValidateBookMemberRole(role); err != nil {
+		return member, err
+	}
+
+	if err := tx.Model(&member).Update("role", role).Error; err != nil {
+		return member, errors.Wrap(err, "updating role")
+	}
+
+	return member, nil
+}
+
+// RemoveBookMember removes a member from the book. The book and its notes are
+// removed from the local copies of the member in the next sync.
+func RemoveBookMember(tx *gorm.DB, book database.Book, member database.BookMember) error {
+	if err := tx.Delete(&member).Error; err != nil {
+		return errors.Wrap(err, "deleting book member")
+	}
+
+	if err := syncBookToUser(tx, book, member.UserID); err != nil {
+		return errors.Wrap(err, "syncing the book to the former member")
+	}
+
+	return nil
+}
+
+// LeaveBook removes the user from the members of the book, which remains for the
+// other members. It returns the book as deleted, with the usn of the removal in the
+// sequence of the user.
+func LeaveBook(tx *gorm.DB, user database.User, book database.Book) (database.Book, error) {
+	var member database.BookMember
+	conn := tx.Where("book_id = ? AND user_id = ?", book.ID, user.ID).First(&member)
+	if conn.RecordNotFound() {
+		return book, ErrNotBookMember
+	} else if err := conn.Error; err != nil {
+		return book, errors.Wrap(err, "finding book member")
+	}
+
+	if err := RemoveBookMember(tx, book, member); err != nil {
+		return book, err
+	}
+
+	var s database.SharedUSN
+	if err := tx.Where("user_id = ? AND type = ? AND uuid = ?", user.ID, database.SharedUSNTypeBook, book.UUID).First(&s).Error; err != nil {
+		return book, errors.Wrap(err, "finding shared usn")
+	}
+
+	book.USN = s.USN
+	book.Deleted = true
+
+	return book, nil
+}
+
+// CreateBookInvitation creates an invitation for the owner of the given email
+// to become a member of the book. The invitation expires after BookInvitationTTL.
+func CreateBookInvitation(tx *gorm.DB, book database.Book, inviter database.User, email, role string, now time.Time) (database.BookInvitation, error) {
+	if err := ValidateBookMemberRole(role); err != nil {
+		return database.BookInvitation{}, err
+	}
+
+	token, err := crypt.GetRandomStr(32)
+	if err != nil {
+		return database.BookInvitation{}, errors.Wrap(err, "generating token")
+	}
+
+	expiresAt := now.Add(BookInvitationTTL)
+
+	invitation := database.BookInvitation{
+		BookID:    book.ID,
+		InviterID: inviter.ID,
+		Email:     strings.ToLower(email),
+		Role:      role,
+		Token:     token,
+		ExpiresAt: &expiresAt,
+	}
+	if err := tx.Create(&invitation).Error; err != nil {
+		return invitation, errors.Wrap(err, "inserting invitation")
+	}
+
+	return invitation, nil
+}
+
+// AcceptBookInvitation adds the user as a member of the book for which the
+// invitation was made. The invitation must have been sent to the email of the user,
+// and must not have expired.
+func AcceptBookInvitation(tx *gorm.DB, invitation database.BookInvitation, user database.User, account database.Account, now time.Time) (database.BookMember, error) {
+	if invitation.AcceptedAt != nil {
+		return database.BookMember{}, ErrInvitationAccepted
+	}
+	if invitation.ExpiresAt != nil && !now.Before(*invitation.ExpiresAt) {
+		return database.BookMember{}, ErrInvitationExpired
+	}
+	if !strings.EqualFold(account.Email.String, invitation.Email) {
+		return database.BookMember{}, ErrInvitationEmailMismatch
+	}
+
+	var book database.Book
+	if err := tx.Where("id = ?", invitation.BookID).First(&book).Error; err != nil {
+		return database.BookMember{}, errors.Wrap(err, "finding book")
+	}
+
+	member, err := AddBookMember(tx, book, user, invitation.Role)
+	if err != nil {
+		return member, errors.Wrap(err, "adding book member")
+	}
+
+	if err := tx.Model(&invitation).Update("accepted_at", now).Error; err != nil {
+		return member, errors.Wrap(err, "marking invitation accepted")
+	}
+
+	return member, nil
+}
@@ -0,0 +1,307 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestAddBookMember(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	owner := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&owner).Update("max_usn", 10), "preparing owner max_usn")
+	member := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&member).Update("max_usn", 3), "preparing member max_usn")
+
+	b1 := database.Book{UserID: owner.ID, Label: "runbooks", USN: 1}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	n1 := database.Note{UserID: owner.ID, BookUUID: b1.UUID, Body: "n1 content", USN: 2}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+	n2 := database.Note{UserID: owner.ID, BookUUID: b1.UUID, Body: "", USN: 3, Deleted: true}
+	testutils.MustExec(t, db.Save(&n2), "preparing n2")
+
+	tx := db.Begin()
+	if _, err := AddBookMember(tx, b1, member, database.BookMemberRoleEditor); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "adding book member"))
+	}
+	tx.Commit()
+
+	var memberRecord database.BookMember
+	var ownerRecord, memberUserRecord database.User
+	var sharedBookUSN, sharedNoteUSN database.SharedUSN
+	var sharedUSNCount int
+	testutils.MustExec(t, db.Where("book_id = ? AND user_id = ?", b1.ID, member.ID).First(&memberRecord), "finding book member")
+	testutils.MustExec(t, db.Where("id = ?", owner.ID).First(&ownerRecord), "finding owner")
+	testutils.MustExec(t, db.Where("id = ?", member.ID).First(&memberUserRecord), "finding member")
+	testutils.MustExec(t, db.Model(&database.SharedUSN{}).Count(&sharedUSNCount), "counting shared usns")
+	testutils.MustExec(t, db.Where("uuid = ? AND type = ?", b1.UUID, database.SharedUSNTypeBook).First(&sharedBookUSN), "finding shared usn for b1")
+	testutils.MustExec(t, db.Where("uuid = ? AND type = ?", n1.UUID, database.SharedUSNTypeNote).First(&sharedNoteUSN), "finding shared usn for n1")
+
+	assert.Equal(t, memberRecord.Role, database.BookMemberRoleEditor, "role mismatch")
+	assert.Equal(t, ownerRecord.MaxUSN, 10, "owner max_usn mismatch")
+	assert.Equal(t, memberUserRecord.MaxUSN, 5, "member max_usn mismatch")
+	assert.Equal(t, sharedUSNCount, 2, "shared usn count mismatch")
+	assert.Equal(t, sharedBookUSN.UserID, member.ID, "b1 shared usn user_id mismatch")
+	assert.Equal(t, sharedBookUSN.USN, 4, "b1 shared usn mismatch")
+	assert.Equal(t, sharedNoteUSN.UserID, member.ID, "n1 shared usn user_id mismatch")
+	assert.Equal(t, sharedNoteUSN.USN, 5, "n1 shared usn mismatch")
+}
+
+func TestAddBookMember_Exists(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	owner := testutils.SetupUserData()
+	member := testutils.SetupUserData()
+
+	b1 := database.Book{UserID: owner.ID, Label: "runbooks"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	m1 := database.BookMember{BookID: b1.ID, UserID: member.ID, Role: database.BookMemberRoleViewer}
+	testutils.MustExec(t, db.Save(&m1), "preparing m1")
+
+	testCases := []database.User{owner, member}
+
+	for _, tc := range testCases {
+		tx := db.Begin()
+		_, err := AddBookMember(tx, b1, tc, database.BookMemberRoleEditor)
+		tx.Rollback()
+
+		assert.Equal(t, err, ErrBookMemberExists, "error mismatch")
+	}
+}
+
+func TestRemoveBookMember(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	owner := testutils.SetupUserData()
+	member := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&member).Update("max_usn", 7), "preparing member max_usn")
+
+	b1 := database.Book{UserID: owner.ID, Label: "runbooks"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	n1 := database.Note{UserID: owner.ID, BookUUID: b1.UUID, Body: "n1 content"}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+	m1 := database.BookMember{BookID: b1.ID, UserID: member.ID, Role: database.BookMemberRoleViewer}
+	testutils.MustExec(t, db.Save(&m1), "preparing m1")
+
+	tx := db.Begin()
+	if err := RemoveBookMember(tx, b1, m1); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "removing book member"))
+	}
+	tx.Commit()
+
+	var memberCount int
+	var memberUserRecord database.User
+	var sharedNoteUSN database.SharedUSN
+	testutils.MustExec(t, db.Model(&database.BookMember{}).Count(&memberCount), "counting book members")
+	testutils.MustExec(t, db.Where("id = ?", member.ID).First(&memberUserRecord), "finding member")
+	testutils.MustExec(t, db.Where("uuid = ? AND type = ?", n1.UUID, database.SharedUSNTypeNote).First(&sharedNoteUSN), "finding shared usn for n1")
+
+	assert.Equal(t, memberCount, 0, "member count mismatch")
+	assert.Equal(t, memberUserRecord.MaxUSN, 9, "member max_usn mismatch")
+	assert.Equal(t, sharedNoteUSN.USN, 9, "n1 shared usn mismatch")
+}
+
+func TestCreateNote_SharedBook(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	owner := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&owner).Update("max_usn", 10), "preparing owner max_usn")
+	member := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&member).Update("max_usn", 20), "preparing member max_usn")
+	viewer := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&viewer).Update("max_usn", 30), "preparing viewer max_usn")
+
+	b1 := database.Book{UserID: owner.ID, Label: "runbooks"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	m1 := database.BookMember{BookID: b1.ID, UserID: member.ID, Role: database.BookMemberRoleEditor}
+	testutils.MustExec(t, db.Save(&m1), "preparing m1")
+	m2 := database.BookMember{BookID: b1.ID, UserID: viewer.ID, Role: database.BookMemberRoleViewer}
+	testutils.MustExec(t, db.Save(&m2), "preparing m2")
+
+	note, err := CreateNote(member, clock.NewMock(), b1.UUID, "note content", nil, nil, false)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating note"))
+	}
+
+	var noteRecord database.Note
+	var ownerRecord, memberRecord, viewerRecord database.User
+	testutils.MustExec(t, db.Where("uuid = ?", note.UUID).First(&noteRecord), "finding note")
+	testutils.MustExec(t, db.Where("id = ?", owner.ID).First(&ownerRecord), "finding owner")
+	testutils.MustExec(t, db.Where("id = ?", member.ID).First(&memberRecord), "finding member")
+	testutils.MustExec(t, db.Where("id = ?", viewer.ID).First(&viewerRecord), "finding viewer")
+
+	assert.Equal(t, noteRecord.UserID, owner.ID, "note user_id mismatch")
+	assert.Equal(t, noteRecord.AuthorID, member.ID, "note author_id mismatch")
+	assert.Equal(t, noteRecord.EditorID, member.ID, "note editor_id mismatch")
+	assert.Equal(t, noteRecord.USN, 11, "note usn mismatch")
+	assert.Equal(t, ownerRecord.MaxUSN, 11, "owner max_usn mismatch")
+	assert.Equal(t, memberRecord.MaxUSN, 21, "member max_usn mismatch")
+	assert.Equal(t, viewerRecord.MaxUSN, 31, "viewer max_usn mismatch")
+	assert.Equal(t, note.USN, 21, "returned note usn should be in the sequence of the member")
+}
+
+func TestAcceptBookInvitation(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	owner := testutils.SetupUserData()
+	invitee := testutils.SetupUserData()
+	account := testutils.SetupAccountData(invitee, "alice@example.com", "pass1234")
+
+	b1 := database.Book{UserID: owner.ID, Label: "runbooks"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+
+	now := time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	tx := db.Begin()
+	invitation, err := CreateBookInvitation(tx, b1, owner, "Alice@example.com", database.BookMemberRoleViewer, now.Add(-time.Hour))
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "creating invitation"))
+	}
+	tx.Commit()
+
+	assert.Equal(t, invitation.ExpiresAt.Unix(), now.Add(-time.Hour).Add(BookInvitationTTL).Unix(), "expires_at mismatch")
+
+	tx = db.Begin()
+	if _, err := AcceptBookInvitation(tx, invitation, invitee, account, now); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "accepting invitation"))
+	}
+	tx.Commit()
+
+	var memberRecord database.BookMember
+	var invitationRecord database.BookInvitation
+	testutils.MustExec(t, db.Where("book_id = ? AND user_id = ?", b1.ID, invitee.ID).First(&memberRecord), "finding book member")
+	testutils.MustExec(t, db.Where("id = ?", invitation.ID).First(&invitationRecord), "finding invitation")
+
+	assert.Equal(t, memberRecord.Role, database.BookMemberRoleViewer, "role mismatch")
+	assert.Equal(t, invitationRecord.AcceptedAt.Unix(), now.Unix(), "accepted_at mismatch")
+
+	tx = db.Begin()
+	_, err = AcceptBookInvitation(tx, invitationRecord, invitee, account, now)
+	tx.Rollback()
+	assert.Equal(t, err, ErrInvitationAccepted, "error mismatch for accepted invitation")
+}
+
+func TestAcceptBookInvitation_EmailMismatch(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	owner := testutils.SetupUserData()
+	other := testutils.SetupUserData()
+	account := testutils.SetupAccountData(other, "bob@example.com", "pass1234")
+
+	b1 := database.Book{UserID: owner.ID, Label: "runbooks"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	invitation := database.BookInvitation{BookID: b1.ID, InviterID: owner.ID, Email: "alice@example.com", Role: database.BookMemberRoleViewer, Token: "someToken"}
+	testutils.MustExec(t, db.Save(&invitation), "preparing invitation")
+
+	tx := db.Begin()
+	_, err := AcceptBookInvitation(tx, invitation, other, account, time.Now())
+	tx.Rollback()
+
+	var memberCount int
+	testutils.MustExec(t, db.Model(&database.BookMember{}).Count(&memberCount), "counting book members")
+
+	assert.Equal(t, err, ErrInvitationEmailMismatch, "error mismatch")
+	assert.Equal(t, memberCount, 0, "member count mismatch")
+}
+
+func TestAcceptBookInvitation_Expired(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	owner := testutils.SetupUserData()
+	invitee := testutils.SetupUserData()
+	account := testutils.SetupAccountData(invitee, "alice@example.com", "pass1234")
+
+	b1 := database.Book{UserID: owner.ID, Label: "runbooks"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+
+	now := time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	tx := db.Begin()
+	invitation, err := CreateBookInvitation(tx, b1, owner, "alice@example.com", database.BookMemberRoleViewer, now.Add(-BookInvitationTTL))
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "creating invitation"))
+	}
+	tx.Commit()
+
+	tx = db.Begin()
+	_, err = AcceptBookInvitation(tx, invitation, invitee, account, now)
+	tx.Rollback()
+
+	var memberCount int
+	testutils.MustExec(t, db.Model(&database.BookMember{}).Count(&memberCount), "counting book members")
+
+	assert.Equal(t, err, ErrInvitationExpired, "error mismatch")
+	assert.Equal(t, memberCount, 0, "member count mismatch")
+}
+
+func TestLeaveBook(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	owner := testutils.SetupUserData()
+	member := testutils.SetupUserData()
+	stranger := testutils.SetupUserData()
+
+	b1 := database.Book{UserID: owner.ID, Label: "runbooks"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	m1 := database.BookMember{BookID: b1.ID, UserID: member.ID, Role: database.BookMemberRoleEditor}
+	testutils.MustExec(t, db.Save(&m1), "preparing m1")
+
+	tx := db.Begin()
+	book, err := LeaveBook(tx, member, b1)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "leaving book"))
+	}
+	tx.Commit()
+
+	var memberRecord database.User
+	var memberCount int
+	testutils.MustExec(t, db.Where("id = ?", member.ID).First(&memberRecord), "finding member")
+	testutils.MustExec(t, db.Model(&database.BookMember{}).Count(&memberCount), "counting book members")
+
+	assert.Equal(t, memberCount, 0, "member count mismatch")
+	assert.Equal(t, book.Deleted, true, "deleted mismatch")
+	assert.Equal(t, book.USN, memberRecord.MaxUSN, "usn mismatch")
+
+	tx = db.Begin()
+	_, err = LeaveBook(tx, stranger, b1)
+	tx.Rollback()
+	assert.Equal(t, err, ErrNotBookMember, "error mismatch for a user who is not a member")
+}
@@ -19,20 +19,30 @@
 package operations
 
 import (
+	"time"
+
 	"github.com/dnote/dnote/pkg/clock"
 	"github.com/dnote/dnote/pkg/server/api/helpers"
 	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/webhook"
 	"github.com/jinzhu/gorm"
 	"github.com/pkg/errors"
 )
 
-// CreateNote creates a note with the next usn and updates the user's max_usn.
-// It returns the created note.
+// CreateNote creates a note with the next usn and updates the max_usn of the book owner.
+// It returns the created note. If the user is a member of the book rather than its owner,
+// the usn of the returned note is in the sequence of the user.
 func CreateNote(user database.User, clock clock.Clock, bookUUID, content string, addedOn *int64, editedOn *int64, public bool) (database.Note, error) {
 	db := database.DBConn
 	tx := db.Begin()
 
-	nextUSN, err := incrementUserUSN(tx, user.ID)
+	var book database.Book
+	if err := tx.Where("uuid = ?", bookUUID).First(&book).Error; err != nil {
+		tx.Rollback()
+		return database.Note{}, errors.Wrap(err, "finding book")
+	}
+
+	nextUSN, err := incrementUserUSN(tx, book.UserID)
 	if err != nil {
 		tx.Rollback()
 		return database.Note{}, errors.Wrap(err, "incrementing user max_usn")
@@ -60,7 +70,9 @@ func CreateNote(user database.User, clock clock.Clock, bookUUID, content string,
 	note := database.Note{
 		UUID:      uuid,
 		BookUUID:  bookUUID,
-		UserID:    user.ID,
+		UserID:    book.UserID,
+		AuthorID:  user.ID,
+		EditorID:  user.ID,
 		AddedOn:   noteAddedOn,
 		EditedOn:  noteEditedOn,
 		USN:       nextUSN,
@@ -72,19 +84,63 @@ func CreateNote(user database.User, clock clock.Clock, bookUUID, content string,
 		tx.Rollback()
 		return note, errors.Wrap(err, "inserting note")
 	}
+	if err := UpdateNoteLinks(tx, note); err != nil {
+		tx.Rollback()
+		return note, errors.Wrap(err, "updating links")
+	}
+
+	if err := webhook.Enqueue(tx, clock, book.UserID, database.WebhookEventNoteCreated, webhook.NewNoteData(note)); err != nil {
+		tx.Rollback()
+		return note, errors.Wrap(err, "enqueueing webhook deliveries")
+	}
+
+	memberUSN, err := recordSharedChange(tx, bookUUID, user.ID, database.SharedUSNTypeNote, note.UUID)
+	if err != nil {
+		tx.Rollback()
+		return note, errors.Wrap(err, "recording the change for book members")
+	}
+	if memberUSN != 0 {
+		note.USN = memberUSN
+	}
 
 	tx.Commit()
 
 	return note, nil
 }
 
-// UpdateNote creates a note with the next usn and updates the user's max_usn
+// recordNoteChange records a change of the note for the members of the books that
+// contain the note before and after the change. It returns the usn of the change in
+// the sequence of the actor if the actor is a member rather than the owner.
+func recordNoteChange(tx *gorm.DB, note database.Note, prevBookUUID string, actorID int) (int, error) {
+	memberUSN, err := recordSharedChange(tx, prevBookUUID, actorID, database.SharedUSNTypeNote, note.UUID)
+	if err != nil {
+		return 0, err
+	}
+
+	if note.BookUUID != prevBookUUID {
+		usn, err := recordSharedChange(tx, note.BookUUID, actorID, database.SharedUSNTypeNote, note.UUID)
+		if err != nil {
+			return 0, err
+		}
+		if usn != 0 {
+			memberUSN = usn
+		}
+	}
+
+	return memberUSN, nil
+}
+
+// UpdateNote updates a note with the next usn and updates the max_usn of the note owner.
+// If the user is a member of the book rather than its owner, the usn of the returned
+// note is in the sequence of the user.
 func UpdateNote(tx *gorm.DB, user database.User, clock clock.Clock, note database.Note, bookUUID, content *string) (database.Note, error) {
-	nextUSN, err := incrementUserUSN(tx, user.ID)
+	nextUSN, err := incrementUserUSN(tx, note.UserID)
 	if err != nil {
 		return note, errors.Wrap(err, "incrementing user max_usn")
 	}
 
+	prevBookUUID := note.BookUUID
+
 	if bookUUID != nil {
 		note.BookUUID = *bookUUID
 	}
@@ -94,6 +150,7 @@ func UpdateNote(tx *gorm.DB, user database.User, clock clock.Clock, note databas
 
 	note.USN = nextUSN
 	note.EditedOn = clock.Now().UnixNano()
+	note.EditorID = user.ID
 	note.Deleted = false
 	// TODO: remove after all users are migrated
 	note.Encrypted = false
@@ -101,25 +158,119 @@ func UpdateNote(tx *gorm.DB, user database.User, clock clock.Clock, note databas
 	if err := tx.Save(&note).Error; err != nil {
 		return note, errors.Wrap(err, "editing note")
 	}
+	if content != nil {
+		if err := UpdateNoteLinks(tx, note); err != nil {
+			return note, errors.Wrap(err, "updating links")
+		}
+	}
+
+	if err := webhook.Enqueue(tx, clock, note.UserID, database.WebhookEventNoteUpdated, webhook.NewNoteData(note)); err != nil {
+		return note, errors.Wrap(err, "enqueueing webhook deliveries")
+	}
+
+	memberUSN, err := recordNoteChange(tx, note, prevBookUUID, user.ID)
+	if err != nil {
+		return note, errors.Wrap(err, "recording the change for book members")
+	}
+	if memberUSN != 0 {
+		note.USN = memberUSN
+	}
 
 	return note, nil
 }
 
-// DeleteNote marks a note deleted with the next usn and updates the user's max_usn
-func DeleteNote(tx *gorm.DB, user database.User, note database.Note) (database.Note, error) {
-	nextUSN, err := incrementUserUSN(tx, user.ID)
+// DeleteNote marks a note deleted with the next usn and updates the max_usn of the note owner.
+// If the user is a member of the book rather than its owner, the usn of the returned
+// note is in the sequence of the user.
+func DeleteNote(tx *gorm.DB, user database.User, clock clock.Clock, note database.Note) (database.Note, error) {
+	nextUSN, err := incrementUserUSN(tx, note.UserID)
 	if err != nil {
 		return note, errors.Wrap(err, "incrementing user max_usn")
 	}
 
 	if err := tx.Model(&note).
 		Update(map[string]interface{}{
-			"usn":     nextUSN,
-			"deleted": true,
-			"body":    "",
+			"usn":       nextUSN,
+			"deleted":   true,
+			"body":      "",
+			"editor_id": user.ID,
 		}).Error; err != nil {
 		return note, errors.Wrap(err, "deleting note")
 	}
+	if err := tx.Where("note_uuid = ?", note.UUID).Delete(&database.Attachment{}).Error; err != nil {
+		return note, errors.Wrap(err, "deleting attachments")
+	}
+	if err := deleteNoteLinks(tx, note.UUID); err != nil {
+		return note, err
+	}
+
+	if err := webhook.Enqueue(tx, clock, note.UserID, database.WebhookEventNoteDeleted, webhook.NewNoteData(note)); err != nil {
+		return note, errors.Wrap(err, "enqueueing webhook deliveries")
+	}
+
+	memberUSN, err := recordNoteChange(tx, note, note.BookUUID, user.ID)
+	if err != nil {
+		return note, errors.Wrap(err, "recording the change for book members")
+	}
+	if memberUSN != 0 {
+		note.USN = memberUSN
+	}
+
+	return note, nil
+}
+
+// ShareNote makes a note publicly accessible through its share link with the next
+// usn and updates the user's max_usn. If expiresAt is nil, the link does not expire.
+func ShareNote(tx *gorm.DB, user database.User, clock clock.Clock, note database.Note, expiresAt *time.Time) (database.Note, error) {
+	nextUSN, err := incrementUserUSN(tx, user.ID)
+	if err != nil {
+		return note, errors.Wrap(err, "incrementing user max_usn")
+	}
+
+	if err := tx.Model(&note).
+		Update(map[string]interface{}{
+			"usn":               nextUSN,
+			"public":            true,
+			"public_expires_at": expiresAt,
+		}).Error; err != nil {
+		return note, errors.Wrap(err, "sharing note")
+	}
+
+	if err := webhook.Enqueue(tx, clock, user.ID, database.WebhookEventNoteUpdated, webhook.NewNoteData(note)); err != nil {
+		return note, errors.Wrap(err, "enqueueing webhook deliveries")
+	}
+
+	if _, err := recordNoteChange(tx, note, note.BookUUID, user.ID); err != nil {
+		return note, errors.Wrap(err, "recording the change for book members")
+	}
+
+	return note, nil
+}
+
+// UnshareNote revokes the share link of a note with the next usn and updates the
+// user's max_usn
+func UnshareNote(tx *gorm.DB, user database.User, clock clock.Clock, note database.Note) (database.Note, error) {
+	nextUSN, err := incrementUserUSN(tx, user.ID)
+	if err != nil {
+		return note, errors.Wrap(err, "incrementing user max_usn")
+	}
+
+	if err := tx.Model(&note).
+		Update(map[string]interface{}{
+			"usn":               nextUSN,
+			"public":            false,
+			"public_expires_at": nil,
+		}).Error; err != nil {
+		return note, errors.Wrap(err, "unsharing note")
+	}
+
+	if err := webhook.Enqueue(tx, clock, user.ID, database.WebhookEventNoteUpdated, webhook.NewNoteData(note)); err != nil {
+		return note, errors.Wrap(err, "enqueueing webhook deliveries")
+	}
+
+	if _, err := recordNoteChange(tx, note, note.BookUUID, user.ID); err != nil {
+		return note, errors.Wrap(err, "recording the change for book members")
+	}
 
 	return note, nil
 }
@@ -225,7 +225,7 @@ func TestDeleteNote(t *testing.T) {
 			testutils.MustExec(t, db.Save(&note), fmt.Sprintf("preparing note for test case %d", idx))
 
 			tx := db.Begin()
-			ret, err := DeleteNote(tx, user, note)
+			ret, err := DeleteNote(tx, user, clock.NewMock(), note)
 			if err != nil {
 				tx.Rollback()
 				t.Fatal(errors.Wrap(err, "deleting note"))
@@ -255,3 +255,97 @@ func TestDeleteNote(t *testing.T) {
 		}()
 	}
 }
+
+func TestShareNote(t *testing.T) {
+	expiresAt := time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		userUSN     int
+		expiresAt   *time.Time
+		expectedUSN int
+	}{
+		{
+			userUSN:     8,
+			expiresAt:   nil,
+			expectedUSN: 9,
+		},
+		{
+			userUSN:     102229,
+			expiresAt:   &expiresAt,
+			expectedUSN: 102230,
+		},
+	}
+
+	for idx, tc := range testCases {
+		func() {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			user := testutils.SetupUserData()
+			testutils.MustExec(t, db.Model(&user).Update("max_usn", tc.userUSN), fmt.Sprintf("preparing user max_usn for test case %d", idx))
+
+			b1 := database.Book{UserID: user.ID, Label: "js", Deleted: false}
+			testutils.MustExec(t, db.Save(&b1), fmt.Sprintf("preparing b1 for test case %d", idx))
+
+			note := database.Note{UserID: user.ID, Deleted: false, Body: "test content", BookUUID: b1.UUID}
+			testutils.MustExec(t, db.Save(&note), fmt.Sprintf("preparing note for test case %d", idx))
+
+			tx := db.Begin()
+			if _, err := ShareNote(tx, user, clock.NewMock(), note, tc.expiresAt); err != nil {
+				tx.Rollback()
+				t.Fatal(errors.Wrap(err, "sharing note"))
+			}
+			tx.Commit()
+
+			var noteRecord database.Note
+			var userRecord database.User
+
+			testutils.MustExec(t, db.First(&noteRecord), fmt.Sprintf("finding note for test case %d", idx))
+			testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), fmt.Sprintf("finding user for test case %d", idx))
+
+			assert.Equal(t, noteRecord.Public, true, "note Public mismatch")
+			assert.Equal(t, noteRecord.Body, "test content", "note Body mismatch")
+			assert.Equal(t, noteRecord.USN, tc.expectedUSN, "note USN mismatch")
+			if tc.expiresAt == nil {
+				assert.Equal(t, noteRecord.PublicExpiresAt, (*time.Time)(nil), "note PublicExpiresAt mismatch")
+			} else {
+				assert.Equal(t, noteRecord.PublicExpiresAt.Unix(), tc.expiresAt.Unix(), "note PublicExpiresAt mismatch")
+			}
+
+			assert.Equal(t, userRecord.MaxUSN, tc.expectedUSN, "user MaxUSN mismatch")
+		}()
+	}
+}
+
+func TestUnshareNote(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&user).Update("max_usn", 8), "preparing user max_usn")
+
+	b1 := database.Book{UserID: user.ID, Label: "js", Deleted: false}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+
+	expiresAt := time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)
+	note := database.Note{UserID: user.ID, Deleted: false, Body: "test content", BookUUID: b1.UUID, Public: true, PublicExpiresAt: &expiresAt}
+	testutils.MustExec(t, db.Save(&note), "preparing note")
+
+	tx := db.Begin()
+	if _, err := UnshareNote(tx, user, clock.NewMock(), note); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "unsharing note"))
+	}
+	tx.Commit()
+
+	var noteRecord database.Note
+	var userRecord database.User
+
+	testutils.MustExec(t, db.First(&noteRecord), "finding note")
+	testutils.MustExec(t, db.Where("id = ?", user.ID).First(&userRecord), "finding user")
+
+	assert.Equal(t, noteRecord.Public, false, "note Public mismatch")
+	assert.Equal(t, noteRecord.PublicExpiresAt, (*time.Time)(nil), "note PublicExpiresAt mismatch")
+	assert.Equal(t, noteRecord.USN, 9, "note USN mismatch")
+	assert.Equal(t, userRecord.MaxUSN, 9, "user MaxUSN mismatch")
+}
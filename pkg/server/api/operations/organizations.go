@@ -0,0 +1,305 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"strings"
+	"time"
+
+	"github.com/dnote/dnote/pkg/server/api/crypt"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrSignupNotAllowed is an error for a signup that the policy of the instance does not allow
+	ErrSignupNotAllowed = errors.New("Signup is not allowed for this email. Please ask an administrator for an invitation")
+	// ErrInvalidOrganizationInvitation is an error for an invitation that cannot be used
+	ErrInvalidOrganizationInvitation = errors.New("The invitation is invalid or has already been used")
+)
+
+// getEmailDomain returns the lowercased domain part of the given email
+func getEmailDomain(email string) string {
+	idx := strings.LastIndex(email, "@")
+	if idx == -1 {
+		return ""
+	}
+
+	return strings.ToLower(email[idx+1:])
+}
+
+// IsDomainAllowed checks if the domain of the given email is one of the allowed
+// domains of the organization
+func IsDomainAllowed(org database.Organization, email string) bool {
+	domain := getEmailDomain(email)
+	if domain == "" {
+		return false
+	}
+
+	for _, d := range org.AllowedDomains {
+		if strings.ToLower(d) == domain {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SignupPolicy is the result of checking a signup against the organizations of the instance
+type SignupPolicy struct {
+	// OrganizationID is the id of the organization that the new user joins.
+	// It is 0 if no organization is configured.
+	OrganizationID int
+	// Invitation is the invitation used for the signup, if any
+	Invitation *database.OrganizationInvitation
+}
+
+// CheckSignup decides whether a person with the given email can sign up, and which
+// organization the person joins. If no organization exists, signup is open to anyone.
+// Otherwise, the person must either have a pending invitation, or have an email in one
+// of the allowed domains of an organization that does not require invitations.
+func CheckSignup(db *gorm.DB, email, invitationToken string) (SignupPolicy, error) {
+	var ret SignupPolicy
+
+	if invitationToken != "" {
+		var invitation database.OrganizationInvitation
+		conn := db.Where("token = ?", invitationToken).First(&invitation)
+		if conn.RecordNotFound() {
+			return ret, ErrInvalidOrganizationInvitation
+		} else if err := conn.Error; err != nil {
+			return ret, errors.Wrap(err, "finding invitation")
+		}
+
+		if invitation.AcceptedAt != nil || !strings.EqualFold(invitation.Email, email) {
+			return ret, ErrInvalidOrganizationInvitation
+		}
+
+		ret.OrganizationID = invitation.OrganizationID
+		ret.Invitation = &invitation
+
+		return ret, nil
+	}
+
+	var orgs []database.Organization
+	if err := db.Order("id ASC").Find(&orgs).Error; err != nil {
+		return ret, errors.Wrap(err, "finding organizations")
+	}
+	if len(orgs) == 0 {
+		return ret, nil
+	}
+
+	for _, org := range orgs {
+		if !org.InviteOnly && IsDomainAllowed(org, email) {
+			ret.OrganizationID = org.ID
+			return ret, nil
+		}
+	}
+
+	return ret, ErrSignupNotAllowed
+}
+
+// acceptOrganizationInvitation marks the invitation accepted. It returns
+// ErrInvalidOrganizationInvitation if the invitation has already been accepted,
+// including by a concurrent signup.
+func acceptOrganizationInvitation(tx *gorm.DB, invitation database.OrganizationInvitation, now time.Time) error {
+	conn := tx.Model(&database.OrganizationInvitation{}).
+		Where("id = ? AND accepted_at IS NULL", invitation.ID).
+		Update("accepted_at", now)
+	if err := conn.Error; err != nil {
+		return errors.Wrap(err, "marking invitation accepted")
+	}
+	if conn.RowsAffected == 0 {
+		return ErrInvalidOrganizationInvitation
+	}
+
+	return nil
+}
+
+// GetOrganization returns the organization of the user. The second return value
+// is false if the user does not belong to any organization.
+func GetOrganization(db *gorm.DB, user database.User) (database.Organization, bool, error) {
+	var org database.Organization
+
+	if user.OrganizationID == 0 {
+		return org, false, nil
+	}
+
+	conn := db.Where("id = ?", user.OrganizationID).First(&org)
+	if conn.RecordNotFound() {
+		return org, false, nil
+	} else if err := conn.Error; err != nil {
+		return org, false, errors.Wrap(err, "finding organization")
+	}
+
+	return org, true, nil
+}
+
+// CanSharePublicly checks if the policy of the organization of the user allows
+// making notes publicly accessible
+func CanSharePublicly(db *gorm.DB, user database.User) (bool, error) {
+	org, ok, err := GetOrganization(db, user)
+	if err != nil {
+		return false, errors.Wrap(err, "getting organization")
+	}
+	if !ok {
+		return true, nil
+	}
+
+	return !org.DisablePublicNotes, nil
+}
+
+// CreateOrganization creates an organization with the given name
+func CreateOrganization(tx *gorm.DB, name string, allowedDomains []string) (database.Organization, error) {
+	domains := []string{}
+	for _, d := range allowedDomains {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d != "" {
+			domains = append(domains, d)
+		}
+	}
+
+	org := database.Organization{
+		Name:           name,
+		AllowedDomains: domains,
+	}
+	if err := tx.Create(&org).Error; err != nil {
+		return org, errors.Wrap(err, "inserting organization")
+	}
+
+	return org, nil
+}
+
+// CreateOrganizationInvitation creates an invitation for the owner of the given
+// email to sign up as a member of the organization
+func CreateOrganizationInvitation(tx *gorm.DB, org database.Organization, inviter database.User, email string) (database.OrganizationInvitation, error) {
+	token, err := crypt.GetRandomStr(32)
+	if err != nil {
+		return database.OrganizationInvitation{}, errors.Wrap(err, "generating token")
+	}
+
+	invitation := database.OrganizationInvitation{
+		OrganizationID: org.ID,
+		InviterID:      inviter.ID,
+		Email:          strings.ToLower(email),
+		Token:          token,
+	}
+	if err := tx.Create(&invitation).Error; err != nil {
+		return invitation, errors.Wrap(err, "inserting invitation")
+	}
+
+	return invitation, nil
+}
+
+// DisableUser prevents the user from signing in and invalidates all sessions of the user
+func DisableUser(tx *gorm.DB, user database.User, now time.Time) error {
+	if err := tx.Model(&user).Update("disabled_at", now).Error; err != nil {
+		return errors.Wrap(err, "updating disabled_at")
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&database.Session{}).Error; err != nil {
+		return errors.Wrap(err, "deleting sessions")
+	}
+
+	return nil
+}
+
+// EnableUser allows a disabled user to sign in again
+func EnableUser(tx *gorm.DB, user database.User) error {
+	if err := tx.Model(&user).Update("disabled_at", gorm.Expr("NULL")).Error; err != nil {
+		return errors.Wrap(err, "updating disabled_at")
+	}
+
+	return nil
+}
+
+// DeleteUserContent permanently deletes the books, notes, repetition rules and digests
+// of the user. The members of the books of the user lose access to them in the next sync.
+func DeleteUserContent(tx *gorm.DB, user database.User) error {
+	if err := tx.Exec("DELETE FROM digest_notes WHERE digest_uuid IN (SELECT uuid FROM digests WHERE user_id = ?)", user.ID).Error; err != nil {
+		return errors.Wrap(err, "deleting digest notes")
+	}
+	if err := tx.Exec("DELETE FROM repetition_rule_books WHERE repetition_rule_id IN (SELECT id FROM repetition_rules WHERE user_id = ?)", user.ID).Error; err != nil {
+		return errors.Wrap(err, "deleting repetition rule books")
+	}
+
+	var members []database.BookMember
+	if err := tx.Preload("Book").Where("book_id IN (SELECT id FROM books WHERE user_id = ?)", user.ID).Find(&members).Error; err != nil {
+		return errors.Wrap(err, "finding book members")
+	}
+	for _, member := range members {
+		if err := RemoveBookMember(tx, member.Book, member); err != nil {
+			return errors.Wrapf(err, "removing book member %d", member.ID)
+		}
+	}
+	if err := tx.Where("book_id IN (SELECT id FROM books WHERE user_id = ?)", user.ID).Delete(&database.BookInvitation{}).Error; err != nil {
+		return errors.Wrap(err, "deleting book invitations")
+	}
+	if err := tx.Where("source_uuid IN (SELECT uuid FROM notes WHERE user_id = ?)", user.ID).Delete(&database.NoteLink{}).Error; err != nil {
+		return errors.Wrap(err, "deleting note links")
+	}
+
+	models := []interface{}{
+		&database.Attachment{},
+		&database.Note{},
+		&database.Book{},
+		&database.Digest{},
+		&database.RepetitionRule{},
+	}
+	for _, m := range models {
+		if err := tx.Where("user_id = ?", user.ID).Delete(m).Error; err != nil {
+			return errors.Wrapf(err, "deleting %T", m)
+		}
+	}
+
+	return nil
+}
+
+// DeleteUser permanently deletes the user and all data that belongs to the user
+func DeleteUser(tx *gorm.DB, user database.User) error {
+	if err := DeleteUserContent(tx, user); err != nil {
+		return errors.Wrap(err, "deleting content")
+	}
+	if err := tx.Where("webhook_id IN (SELECT id FROM webhooks WHERE user_id = ?)", user.ID).Delete(&database.WebhookDelivery{}).Error; err != nil {
+		return errors.Wrap(err, "deleting webhook deliveries")
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&database.BookMember{}).Error; err != nil {
+		return errors.Wrap(err, "deleting book memberships")
+	}
+
+	models := []interface{}{
+		&database.Webhook{},
+		&database.SharedUSN{},
+		&database.Session{},
+		&database.Token{},
+		&database.Notification{},
+		&database.EmailPreference{},
+		&database.Account{},
+	}
+	for _, m := range models {
+		if err := tx.Where("user_id = ?", user.ID).Delete(m).Error; err != nil {
+			return errors.Wrapf(err, "deleting %T", m)
+		}
+	}
+
+	if err := tx.Delete(&user).Error; err != nil {
+		return errors.Wrap(err, "deleting user")
+	}
+
+	return nil
+}
@@ -0,0 +1,222 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestIsDomainAllowed(t *testing.T) {
+	org := database.Organization{AllowedDomains: []string{"example.com", "corp.example.org"}}
+
+	testCases := []struct {
+		email    string
+		expected bool
+	}{
+		{"alice@example.com", true},
+		{"alice@Example.COM", true},
+		{"alice@corp.example.org", true},
+		{"alice@example.org", false},
+		{"alice@sub.example.com", false},
+		{"alice", false},
+		{"", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.email, func(t *testing.T) {
+			assert.Equal(t, IsDomainAllowed(org, tc.email), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestCheckSignup_NoOrganization(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	policy, err := CheckSignup(db, "alice@example.com", "")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "checking signup"))
+	}
+
+	assert.Equal(t, policy.OrganizationID, 0, "OrganizationID mismatch")
+	assert.Equal(t, policy.Invitation, (*database.OrganizationInvitation)(nil), "Invitation mismatch")
+}
+
+func TestCheckSignup(t *testing.T) {
+	testCases := []struct {
+		email         string
+		token         string
+		inviteOnly    bool
+		expectedErr   error
+		expectedOrgID bool
+	}{
+		{"alice@example.com", "", false, nil, true},
+		{"alice@other.com", "", false, ErrSignupNotAllowed, false},
+		{"alice@example.com", "", true, ErrSignupNotAllowed, false},
+		{"bob@other.com", "someToken", true, nil, true},
+		{"BOB@other.com", "someToken", true, nil, true},
+		{"alice@example.com", "someToken", true, ErrInvalidOrganizationInvitation, false},
+		{"bob@other.com", "wrongToken", true, ErrInvalidOrganizationInvitation, false},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			org := database.Organization{Name: "acme", AllowedDomains: []string{"example.com"}, InviteOnly: tc.inviteOnly}
+			testutils.MustExec(t, db.Save(&org), "preparing organization")
+			invitation := database.OrganizationInvitation{OrganizationID: org.ID, Email: "bob@other.com", Token: "someToken"}
+			testutils.MustExec(t, db.Save(&invitation), "preparing invitation")
+
+			policy, err := CheckSignup(db, tc.email, tc.token)
+
+			assert.Equal(t, err, tc.expectedErr, "error mismatch")
+			if tc.expectedOrgID {
+				assert.Equal(t, policy.OrganizationID, org.ID, "OrganizationID mismatch")
+			} else {
+				assert.Equal(t, policy.OrganizationID, 0, "OrganizationID mismatch")
+			}
+		})
+	}
+}
+
+func TestCreateUser_Invitation(t *testing.T) {
+	t.Run("pending", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		org := database.Organization{Name: "acme", InviteOnly: true}
+		testutils.MustExec(t, db.Save(&org), "preparing organization")
+		invitation := database.OrganizationInvitation{OrganizationID: org.ID, Email: "bob@other.com", Token: "someToken"}
+		testutils.MustExec(t, db.Save(&invitation), "preparing invitation")
+
+		now := time.Date(2019, time.December, 4, 9, 0, 0, 0, time.UTC)
+		user, err := CreateUser("bob@other.com", "pass1234", CreateUserParams{
+			OrganizationID: org.ID,
+			Invitation:     &invitation,
+			AcceptedAt:     now,
+		})
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "creating user"))
+		}
+
+		var invitationRecord database.OrganizationInvitation
+		testutils.MustExec(t, db.Where("id = ?", invitation.ID).First(&invitationRecord), "finding invitation")
+		if invitationRecord.AcceptedAt == nil {
+			t.Fatal("invitation was not accepted")
+		}
+		assert.Equal(t, invitationRecord.AcceptedAt.UTC(), now, "AcceptedAt mismatch")
+		assert.Equal(t, user.OrganizationID, org.ID, "OrganizationID mismatch")
+	})
+
+	t.Run("already accepted", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		org := database.Organization{Name: "acme", InviteOnly: true}
+		testutils.MustExec(t, db.Save(&org), "preparing organization")
+		invitation := database.OrganizationInvitation{OrganizationID: org.ID, Email: "bob@other.com", Token: "someToken"}
+		testutils.MustExec(t, db.Save(&invitation), "preparing invitation")
+
+		// another signup accepts the invitation after the policy has been checked
+		acceptedAt := time.Date(2019, time.December, 3, 9, 0, 0, 0, time.UTC)
+		testutils.MustExec(t, db.Model(&database.OrganizationInvitation{}).Where("id = ?", invitation.ID).Update("accepted_at", acceptedAt), "accepting invitation")
+
+		_, err := CreateUser("bob@other.com", "pass1234", CreateUserParams{
+			OrganizationID: org.ID,
+			Invitation:     &invitation,
+			AcceptedAt:     time.Date(2019, time.December, 4, 9, 0, 0, 0, time.UTC),
+		})
+		assert.Equal(t, err, ErrInvalidOrganizationInvitation, "error mismatch")
+
+		var userCount, accountCount int
+		testutils.MustExec(t, db.Model(&database.User{}).Count(&userCount), "counting users")
+		testutils.MustExec(t, db.Model(&database.Account{}).Count(&accountCount), "counting accounts")
+		assert.Equal(t, userCount, 0, "userCount mismatch")
+		assert.Equal(t, accountCount, 0, "accountCount mismatch")
+
+		var invitationRecord database.OrganizationInvitation
+		testutils.MustExec(t, db.Where("id = ?", invitation.ID).First(&invitationRecord), "finding invitation")
+		assert.Equal(t, invitationRecord.AcceptedAt.UTC(), acceptedAt, "AcceptedAt mismatch")
+	})
+}
+
+func TestDeleteUser(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	testutils.SetupAccountData(user, "alice@example.com", "pass1234")
+	anotherUser := testutils.SetupUserData()
+	testutils.SetupAccountData(anotherUser, "bob@example.com", "pass1234")
+
+	b1 := database.Book{UserID: user.ID, Label: "js"}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	n1 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "n1 content"}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+	b2 := database.Book{UserID: anotherUser.ID, Label: "css"}
+	testutils.MustExec(t, db.Save(&b2), "preparing b2")
+	n2 := database.Note{UserID: anotherUser.ID, BookUUID: b2.UUID, Body: "n2 content"}
+	testutils.MustExec(t, db.Save(&n2), "preparing n2")
+
+	m1 := database.BookMember{BookID: b1.ID, UserID: anotherUser.ID, Role: database.BookMemberRoleViewer}
+	testutils.MustExec(t, db.Save(&m1), "preparing m1")
+	m2 := database.BookMember{BookID: b2.ID, UserID: user.ID, Role: database.BookMemberRoleViewer}
+	testutils.MustExec(t, db.Save(&m2), "preparing m2")
+	s1 := database.Session{UserID: user.ID, Key: "someKey"}
+	testutils.MustExec(t, db.Save(&s1), "preparing s1")
+	r1 := database.RepetitionRule{UserID: user.ID, Title: "r1", Books: []database.Book{b1}}
+	testutils.MustExec(t, db.Save(&r1), "preparing r1")
+
+	tx := db.Begin()
+	if err := DeleteUser(tx, user); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "deleting user"))
+	}
+	tx.Commit()
+
+	var userCount, accountCount, bookCount, noteCount, memberCount, sessionCount, ruleCount int
+	testutils.MustExec(t, db.Model(&database.User{}).Count(&userCount), "counting users")
+	testutils.MustExec(t, db.Model(&database.Account{}).Count(&accountCount), "counting accounts")
+	testutils.MustExec(t, db.Model(&database.Book{}).Count(&bookCount), "counting books")
+	testutils.MustExec(t, db.Model(&database.Note{}).Count(&noteCount), "counting notes")
+	testutils.MustExec(t, db.Model(&database.BookMember{}).Count(&memberCount), "counting book members")
+	testutils.MustExec(t, db.Model(&database.Session{}).Count(&sessionCount), "counting sessions")
+	testutils.MustExec(t, db.Model(&database.RepetitionRule{}).Count(&ruleCount), "counting repetition rules")
+
+	assert.Equal(t, userCount, 1, "user count mismatch")
+	assert.Equal(t, accountCount, 1, "account count mismatch")
+	assert.Equal(t, bookCount, 1, "book count mismatch")
+	assert.Equal(t, noteCount, 1, "note count mismatch")
+	assert.Equal(t, memberCount, 0, "member count mismatch")
+	assert.Equal(t, sessionCount, 0, "session count mismatch")
+	assert.Equal(t, ruleCount, 0, "repetition rule count mismatch")
+
+	var n2Record database.Note
+	testutils.MustExec(t, db.Where("id = ?", n2.ID).First(&n2Record), "finding n2")
+	assert.Equal(t, n2Record.Body, "n2 content", "n2 body mismatch")
+}
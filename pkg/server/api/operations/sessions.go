@@ -20,6 +20,7 @@ package operations
 
 import (
 	"time"
+	"unicode/utf8"
 
 	"github.com/dnote/dnote/pkg/server/api/crypt"
 	"github.com/dnote/dnote/pkg/server/database"
@@ -27,18 +28,62 @@ import (
 	"github.com/pkg/errors"
 )
 
-// CreateSession returns a new session for the user of the given id
-func CreateSession(db *gorm.DB, userID int) (database.Session, error) {
+// SessionInfo is the information about the client that creates a session
+type SessionInfo struct {
+	DeviceName string
+	ClientType string
+	IPAddress  string
+}
+
+// maxDeviceNameLength is the maximum length of the device name of a session
+const maxDeviceNameLength = 255
+
+const (
+	// sessionTTL is the duration for which a session is valid
+	sessionTTL = 24 * 100 * time.Hour
+	// maxSessionLifetime is the duration after signing in beyond which sessions
+	// can no longer be refreshed, after which the user must sign in again
+	maxSessionLifetime = 24 * 365 * time.Hour
+	// sessionRefreshOverlap is the duration for which the key of a refreshed session
+	// remains valid, so that the requests made with it concurrently with the
+	// refresh do not fail
+	sessionRefreshOverlap = time.Minute
+)
+
+// truncateDeviceName truncates the given device name to the maximum length in bytes
+// without splitting a multi-byte character
+func truncateDeviceName(name string) string {
+	if len(name) <= maxDeviceNameLength {
+		return name
+	}
+
+	i := maxDeviceNameLength
+	for i > 0 && !utf8.RuneStart(name[i]) {
+		i--
+	}
+
+	return name[:i]
+}
+
+// ErrSessionLifetimeExceeded is an error for refreshing a session of a user who
+// signed in longer than the maximum lifetime ago
+var ErrSessionLifetimeExceeded = errors.New("The session can no longer be refreshed. Please sign in again")
+
+func createSession(db *gorm.DB, userID int, info SessionInfo, now, authenticatedAt, expiresAt time.Time) (database.Session, error) {
 	key, err := crypt.GetRandomStr(32)
 	if err != nil {
 		return database.Session{}, errors.Wrap(err, "generating key")
 	}
 
 	session := database.Session{
-		UserID:     userID,
-		Key:        key,
-		LastUsedAt: time.Now(),
-		ExpiresAt:  time.Now().Add(24 * 100 * time.Hour),
+		UserID:          userID,
+		Key:             key,
+		LastUsedAt:      now,
+		ExpiresAt:       expiresAt,
+		AuthenticatedAt: &authenticatedAt,
+		DeviceName:      truncateDeviceName(info.DeviceName),
+		ClientType:      info.ClientType,
+		IPAddress:       info.IPAddress,
 	}
 
 	if err := db.Save(&session).Error; err != nil {
@@ -48,6 +93,67 @@ func CreateSession(db *gorm.DB, userID int) (database.Session, error) {
 	return session, nil
 }
 
+// CreateSession returns a new session for the user of the given id, who signs in
+// at the given time
+func CreateSession(db *gorm.DB, userID int, info SessionInfo, now time.Time) (database.Session, error) {
+	return createSession(db, userID, info, now, now, now.Add(sessionTTL))
+}
+
+// RefreshSession replaces the given session with a new session for the same user
+// and returns the new session. The key of the given session remains valid for a
+// short while, so that the requests already in flight with it succeed. The new
+// session does not outlive the maximum lifetime since the user signed in.
+func RefreshSession(db *gorm.DB, session database.Session, now time.Time) (database.Session, error) {
+	authenticatedAt := session.CreatedAt
+	if session.AuthenticatedAt != nil {
+		authenticatedAt = *session.AuthenticatedAt
+	}
+	deadline := authenticatedAt.Add(maxSessionLifetime)
+	if !now.Before(deadline) {
+		return database.Session{}, ErrSessionLifetimeExceeded
+	}
+
+	expiresAt := now.Add(sessionTTL)
+	if expiresAt.After(deadline) {
+		expiresAt = deadline
+	}
+
+	info := SessionInfo{
+		DeviceName: session.DeviceName,
+		ClientType: session.ClientType,
+		IPAddress:  session.IPAddress,
+	}
+
+	tx := db.Begin()
+
+	ret, err := createSession(tx, session.UserID, info, now, authenticatedAt, expiresAt)
+	if err != nil {
+		tx.Rollback()
+		return database.Session{}, errors.Wrap(err, "creating session")
+	}
+
+	if session.TOTPEnrollmentRequired {
+		ret.TOTPEnrollmentRequired = true
+		if err := tx.Model(&ret).UpdateColumn("totp_enrollment_required", true).Error; err != nil {
+			tx.Rollback()
+			return database.Session{}, errors.Wrap(err, "restricting session")
+		}
+	}
+
+	overlapEnd := now.Add(sessionRefreshOverlap)
+	if err := tx.Model(&database.Session{}).Where("id = ? AND expires_at > ?", session.ID, overlapEnd).
+		UpdateColumn("expires_at", overlapEnd).Error; err != nil {
+		tx.Rollback()
+		return database.Session{}, errors.Wrap(err, "expiring the previous session")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return database.Session{}, errors.Wrap(err, "committing a transaction")
+	}
+
+	return ret, nil
+}
+
 // DeleteUserSessions deletes all existing sessions for the given user. It effectively
 // invalidates all existing sessions.
 func DeleteUserSessions(db *gorm.DB, userID int) error {
@@ -58,6 +164,34 @@ func DeleteUserSessions(db *gorm.DB, userID int) error {
 	return nil
 }
 
+// DeleteOtherSessions deletes all sessions of the given user except for the session
+// of the given id
+func DeleteOtherSessions(db *gorm.DB, userID, sessionID int) error {
+	if err := db.Where("user_id = ? AND id <> ?", userID, sessionID).Delete(&database.Session{}).Error; err != nil {
+		return errors.Wrap(err, "deleting sessions")
+	}
+
+	return nil
+}
+
+// sessionTouchInterval is the minimum interval at which the last used time of a
+// session is updated, in order not to write to the database on every request
+const sessionTouchInterval = time.Minute
+
+// TouchSession updates the last used time of the given session to the given time
+// unless it was updated recently
+func TouchSession(db *gorm.DB, session database.Session, now time.Time) error {
+	if now.Sub(session.LastUsedAt) < sessionTouchInterval {
+		return nil
+	}
+
+	if err := db.Model(&session).UpdateColumn("last_used_at", now).Error; err != nil {
+		return errors.Wrap(err, "updating last_used_at")
+	}
+
+	return nil
+}
+
 // DeleteSession deletes the session that match the given info
 func DeleteSession(db *gorm.DB, sessionKey string) error {
 	if err := db.Debug().Where("key = ?", sessionKey).Delete(&database.Session{}).Error; err != nil {
@@ -0,0 +1,244 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestCreateSession(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	now := time.Date(2019, time.November, 1, 12, 0, 0, 0, time.UTC)
+
+	got, err := CreateSession(db, user.ID, SessionInfo{DeviceName: "laptop", ClientType: "cli"}, now)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	assert.Equal(t, got.UserID, user.ID, "user id mismatch")
+	assert.Equal(t, got.DeviceName, "laptop", "device name mismatch")
+	assert.Equal(t, got.ExpiresAt.Equal(now.Add(sessionTTL)), true, "expires_at mismatch")
+	assert.Equal(t, got.LastUsedAt.Equal(now), true, "last_used_at mismatch")
+	assert.Equal(t, got.AuthenticatedAt.Equal(now), true, "authenticated_at mismatch")
+}
+
+func TestRefreshSession(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	now := time.Date(2019, time.November, 1, 12, 0, 0, 0, time.UTC)
+	authenticatedAt := now.Add(-time.Hour)
+
+	user := testutils.SetupUserData()
+	anotherUser := testutils.SetupUserData()
+	session := database.Session{
+		Key:             "key1",
+		UserID:          user.ID,
+		ExpiresAt:       now.Add(time.Hour * 24),
+		AuthenticatedAt: &authenticatedAt,
+		DeviceName:      "laptop",
+		ClientType:      "cli",
+	}
+	testutils.MustExec(t, db.Save(&session), "preparing session")
+	anotherSession := database.Session{Key: "anotherKey", UserID: anotherUser.ID, ExpiresAt: now.Add(time.Hour * 24)}
+	testutils.MustExec(t, db.Save(&anotherSession), "preparing another session")
+
+	got, err := RefreshSession(db, session, now)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	assert.Equal(t, got.UserID, user.ID, "user id mismatch")
+	assert.NotEqual(t, got.Key, session.Key, "key should be replaced")
+	assert.Equal(t, got.ExpiresAt.Equal(now.Add(sessionTTL)), true, "expires_at mismatch")
+	assert.Equal(t, got.DeviceName, "laptop", "device name mismatch")
+	assert.Equal(t, got.ClientType, "cli", "client type mismatch")
+	assert.Equal(t, got.AuthenticatedAt.Equal(authenticatedAt), true, "authenticated_at should be kept")
+
+	var sessionCount int
+	var old, another database.Session
+	testutils.MustExec(t, db.Model(&database.Session{}).Where("user_id = ?", user.ID).Count(&sessionCount), "counting sessions")
+	testutils.MustExec(t, db.Where("key = ?", session.Key).First(&old), "finding previous session")
+	testutils.MustExec(t, db.Where("key = ?", anotherSession.Key).First(&another), "finding session of another user")
+	assert.Equal(t, sessionCount, 2, "session count mismatch")
+	assert.Equal(t, old.ExpiresAt.Equal(now.Add(sessionRefreshOverlap)), true, "previous session should expire after the overlap")
+	assert.Equal(t, another.ExpiresAt.Equal(anotherSession.ExpiresAt), true, "session of another user should not be affected")
+}
+
+func TestRefreshSession_Lifetime(t *testing.T) {
+	now := time.Date(2019, time.November, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name              string
+		authenticatedAt   time.Time
+		expectedErr       error
+		expectedExpiresAt time.Time
+	}{
+		{
+			name:              "well within the lifetime",
+			authenticatedAt:   now.Add(-time.Hour),
+			expectedErr:       nil,
+			expectedExpiresAt: now.Add(sessionTTL),
+		},
+		{
+			name:              "near the end of the lifetime",
+			authenticatedAt:   now.Add(-maxSessionLifetime + time.Hour),
+			expectedErr:       nil,
+			expectedExpiresAt: now.Add(time.Hour),
+		},
+		{
+			name:            "at the end of the lifetime",
+			authenticatedAt: now.Add(-maxSessionLifetime),
+			expectedErr:     ErrSessionLifetimeExceeded,
+		},
+		{
+			name:            "beyond the lifetime",
+			authenticatedAt: now.Add(-maxSessionLifetime - time.Hour),
+			expectedErr:     ErrSessionLifetimeExceeded,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			user := testutils.SetupUserData()
+			session := database.Session{
+				Key:             "key1",
+				UserID:          user.ID,
+				ExpiresAt:       now.Add(time.Hour * 24),
+				AuthenticatedAt: &tc.authenticatedAt,
+			}
+			testutils.MustExec(t, db.Save(&session), "preparing session")
+
+			got, err := RefreshSession(db, session, now)
+
+			assert.Equal(t, err, tc.expectedErr, "error mismatch")
+			if tc.expectedErr == nil {
+				assert.Equal(t, got.ExpiresAt.Equal(tc.expectedExpiresAt), true, "expires_at mismatch")
+			}
+		})
+	}
+}
+
+func TestDeleteOtherSessions(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	anotherUser := testutils.SetupUserData()
+	s1 := database.Session{Key: "key1", UserID: user.ID}
+	testutils.MustExec(t, db.Save(&s1), "preparing s1")
+	s2 := database.Session{Key: "key2", UserID: user.ID}
+	testutils.MustExec(t, db.Save(&s2), "preparing s2")
+	s3 := database.Session{Key: "key3", UserID: anotherUser.ID}
+	testutils.MustExec(t, db.Save(&s3), "preparing s3")
+
+	if err := DeleteOtherSessions(db, user.ID, s1.ID); err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	var sessions []database.Session
+	testutils.MustExec(t, db.Order("id ASC").Find(&sessions), "finding sessions")
+	assert.Equal(t, len(sessions), 2, "session count mismatch")
+	assert.Equal(t, sessions[0].Key, "key1", "sessions[0] mismatch")
+	assert.Equal(t, sessions[1].Key, "key3", "sessions[1] mismatch")
+}
+
+func TestTouchSession(t *testing.T) {
+	testCases := []struct {
+		elapsed  time.Duration
+		expected bool
+	}{
+		{
+			elapsed:  time.Second,
+			expected: false,
+		},
+		{
+			elapsed:  2 * time.Minute,
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.elapsed.String(), func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			user := testutils.SetupUserData()
+			lastUsedAt := time.Date(2019, time.March, 1, 0, 0, 0, 0, time.UTC)
+			session := database.Session{Key: "key1", UserID: user.ID, LastUsedAt: lastUsedAt}
+			testutils.MustExec(t, db.Save(&session), "preparing session")
+
+			now := lastUsedAt.Add(tc.elapsed)
+			if err := TouchSession(db, session, now); err != nil {
+				t.Fatal(errors.Wrap(err, "executing"))
+			}
+
+			var got database.Session
+			testutils.MustExec(t, db.Where("id = ?", session.ID).First(&got), "finding session")
+			assert.Equal(t, got.LastUsedAt.Equal(now), tc.expected, "last_used_at mismatch")
+		})
+	}
+}
+
+func TestTruncateDeviceName(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "short",
+			input:    "laptop",
+			expected: "laptop",
+		},
+		{
+			name:     "ascii",
+			input:    strings.Repeat("a", 300),
+			expected: strings.Repeat("a", 255),
+		},
+		{
+			name:     "multi-byte",
+			input:    strings.Repeat("a", 254) + "日本",
+			expected: strings.Repeat("a", 254),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := truncateDeviceName(tc.input)
+
+			assert.Equal(t, got, tc.expected, "result mismatch")
+			assert.Equal(t, utf8.ValidString(got), true, "result should be valid utf-8")
+		})
+	}
+}
@@ -0,0 +1,129 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"strings"
+	"time"
+
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/oidc"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// ErrSSOEmailNotVerified is an error for a single sign-on of a person whose email
+// is not verified by the identity provider
+var ErrSSOEmailNotVerified = errors.New("Your identity provider has not verified your email")
+
+// findPendingInvitation returns the token of the pending organization invitation
+// for the given email, if any
+func findPendingInvitation(db *gorm.DB, email string) (string, error) {
+	var invitation database.OrganizationInvitation
+	conn := db.Where("email = ? AND accepted_at IS NULL", strings.ToLower(email)).Order("id DESC").First(&invitation)
+	if conn.RecordNotFound() {
+		return "", nil
+	} else if err := conn.Error; err != nil {
+		return "", errors.Wrap(err, "finding invitation")
+	}
+
+	return invitation.Token, nil
+}
+
+// signupWithSSO creates a user for the person signed in by the identity provider,
+// subject to the signup policy of the organizations
+func signupWithSSO(db *gorm.DB, claims oidc.Claims, now time.Time) (database.User, error) {
+	token, err := findPendingInvitation(db, claims.Email)
+	if err != nil {
+		return database.User{}, errors.Wrap(err, "finding pending invitation")
+	}
+
+	policy, err := CheckSignup(db, claims.Email, token)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	user, err := CreateUser(claims.Email, "", CreateUserParams{
+		OrganizationID: policy.OrganizationID,
+		OIDCIssuer:     claims.Issuer,
+		OIDCSubject:    claims.Subject,
+		Invitation:     policy.Invitation,
+		AcceptedAt:     now,
+	})
+	if errors.Cause(err) == ErrInvalidOrganizationInvitation {
+		return database.User{}, ErrInvalidOrganizationInvitation
+	} else if err != nil {
+		return database.User{}, errors.Wrap(err, "creating user")
+	}
+
+	return user, nil
+}
+
+// SigninWithSSO returns the user and the account of the person signed in by the
+// identity provider. An account that has signed in with the provider before is
+// found by the subject. Otherwise, the account with the same verified email is
+// linked to the provider, keeping its password and encryption keys, so that the
+// person can sign in either way. If no account exists and allowSignup is true,
+// a user without a password is created.
+func SigninWithSSO(db *gorm.DB, claims oidc.Claims, allowSignup bool, now time.Time) (database.User, database.Account, error) {
+	var user database.User
+	var account database.Account
+
+	conn := db.Where("oidc_issuer = ? AND oidc_subject = ?", claims.Issuer, claims.Subject).First(&account)
+	if conn.Error != nil && !conn.RecordNotFound() {
+		return user, account, errors.Wrap(conn.Error, "finding account by subject")
+	}
+
+	if conn.RecordNotFound() {
+		if claims.Email == "" || !claims.EmailVerified {
+			return user, account, ErrSSOEmailNotVerified
+		}
+
+		conn = db.Where("email = ?", claims.Email).First(&account)
+		if conn.RecordNotFound() {
+			if !allowSignup {
+				return user, account, ErrSignupNotAllowed
+			}
+
+			u, err := signupWithSSO(db, claims, now)
+			if err != nil {
+				return user, account, err
+			}
+			if err := db.Where("user_id = ?", u.ID).First(&account).Error; err != nil {
+				return user, account, errors.Wrap(err, "finding the new account")
+			}
+		} else if err := conn.Error; err != nil {
+			return user, account, errors.Wrap(err, "finding account by email")
+		} else {
+			if err := db.Model(&account).Updates(map[string]interface{}{
+				"oidc_issuer":    claims.Issuer,
+				"oidc_subject":   claims.Subject,
+				"email_verified": true,
+			}).Error; err != nil {
+				return user, account, errors.Wrap(err, "linking account")
+			}
+		}
+	}
+
+	if err := db.Where("id = ?", account.UserID).First(&user).Error; err != nil {
+		return user, account, errors.Wrap(err, "finding user")
+	}
+
+	return user, account, nil
+}
@@ -0,0 +1,166 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/oidc"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func TestSigninWithSSO_Subject(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	now := time.Date(2019, time.November, 1, 0, 0, 0, 0, time.UTC)
+	user := testutils.SetupUserData()
+	account := testutils.SetupAccountData(user, "alice@example.com", "pass1234")
+	testutils.MustExec(t, db.Model(&account).Updates(map[string]interface{}{"oidc_issuer": "https://idp.example.com", "oidc_subject": "user-1"}), "linking account")
+
+	// the email at the provider may change
+	claims := oidc.Claims{Issuer: "https://idp.example.com", Subject: "user-1", Email: "alice@new.example.com"}
+	gotUser, gotAccount, err := SigninWithSSO(db, claims, false, now)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "signing in"))
+	}
+
+	assert.Equal(t, gotUser.ID, user.ID, "user mismatch")
+	assert.Equal(t, gotAccount.ID, account.ID, "account mismatch")
+}
+
+func TestSigninWithSSO_LinkClassicAccount(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	now := time.Date(2019, time.November, 1, 0, 0, 0, 0, time.UTC)
+	user := testutils.SetupUserData()
+	account := testutils.SetupClassicAccountData(user, "alice@example.com")
+
+	claims := oidc.Claims{Issuer: "https://idp.example.com", Subject: "user-1", Email: "alice@example.com", EmailVerified: true}
+	gotUser, _, err := SigninWithSSO(db, claims, false, now)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "signing in"))
+	}
+
+	var accountRecord database.Account
+	testutils.MustExec(t, db.Where("id = ?", account.ID).First(&accountRecord), "finding account")
+
+	assert.Equal(t, gotUser.ID, user.ID, "user mismatch")
+	assert.Equal(t, accountRecord.OIDCIssuer, "https://idp.example.com", "oidc_issuer mismatch")
+	assert.Equal(t, accountRecord.OIDCSubject, "user-1", "oidc_subject mismatch")
+	assert.Equal(t, accountRecord.EmailVerified, true, "email_verified mismatch")
+	// the keys of the client-side encryption are untouched
+	assert.Equal(t, accountRecord.AuthKeyHash, account.AuthKeyHash, "auth_key_hash mismatch")
+	assert.Equal(t, accountRecord.CipherKeyEnc, account.CipherKeyEnc, "cipher_key_enc mismatch")
+	assert.Equal(t, accountRecord.Salt, account.Salt, "salt mismatch")
+}
+
+func TestSigninWithSSO_EmailNotVerified(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	now := time.Date(2019, time.November, 1, 0, 0, 0, 0, time.UTC)
+	user := testutils.SetupUserData()
+	account := testutils.SetupAccountData(user, "alice@example.com", "pass1234")
+
+	claims := oidc.Claims{Issuer: "https://idp.example.com", Subject: "user-1", Email: "alice@example.com", EmailVerified: false}
+	_, _, err := SigninWithSSO(db, claims, true, now)
+
+	var accountRecord database.Account
+	testutils.MustExec(t, db.Where("id = ?", account.ID).First(&accountRecord), "finding account")
+
+	assert.Equal(t, err, ErrSSOEmailNotVerified, "error mismatch")
+	assert.Equal(t, accountRecord.OIDCSubject, "", "oidc_subject mismatch")
+}
+
+func TestSigninWithSSO_Signup(t *testing.T) {
+	now := time.Date(2019, time.November, 1, 0, 0, 0, 0, time.UTC)
+	claims := oidc.Claims{Issuer: "https://idp.example.com", Subject: "user-1", Email: "alice@example.com", EmailVerified: true}
+
+	t.Run("not allowed", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		_, _, err := SigninWithSSO(db, claims, false, now)
+
+		var userCount int
+		testutils.MustExec(t, db.Model(&database.User{}).Count(&userCount), "counting users")
+
+		assert.Equal(t, err, ErrSignupNotAllowed, "error mismatch")
+		assert.Equal(t, userCount, 0, "user count mismatch")
+	})
+
+	t.Run("allowed", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		user, account, err := SigninWithSSO(db, claims, true, now)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "signing in"))
+		}
+
+		var tokenCount int
+		testutils.MustExec(t, db.Model(&database.Token{}).Where("user_id = ?", user.ID).Count(&tokenCount), "counting tokens")
+
+		assert.Equal(t, account.UserID, user.ID, "account user mismatch")
+		assert.Equal(t, account.Email.String, "alice@example.com", "email mismatch")
+		assert.Equal(t, account.EmailVerified, true, "email_verified mismatch")
+		assert.Equal(t, account.Password.Valid, false, "password should be null")
+		assert.Equal(t, account.OIDCSubject, "user-1", "oidc_subject mismatch")
+		assert.Equal(t, tokenCount, 0, "verification token count mismatch")
+	})
+
+	t.Run("organization invitation", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		org := database.Organization{Name: "acme", AllowedDomains: []string{"acme.com"}, InviteOnly: true}
+		testutils.MustExec(t, db.Save(&org), "preparing organization")
+		invitation := database.OrganizationInvitation{OrganizationID: org.ID, Email: "alice@example.com", Token: "someToken"}
+		testutils.MustExec(t, db.Save(&invitation), "preparing invitation")
+
+		user, _, err := SigninWithSSO(db, claims, true, now)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "signing in"))
+		}
+
+		var invitationRecord database.OrganizationInvitation
+		testutils.MustExec(t, db.Where("id = ?", invitation.ID).First(&invitationRecord), "finding invitation")
+
+		assert.Equal(t, user.OrganizationID, org.ID, "organization mismatch")
+		assert.NotEqual(t, invitationRecord.AcceptedAt, (*time.Time)(nil), "accepted_at mismatch")
+	})
+
+	t.Run("organization policy", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		org := database.Organization{Name: "acme", AllowedDomains: []string{"acme.com"}}
+		testutils.MustExec(t, db.Save(&org), "preparing organization")
+
+		_, _, err := SigninWithSSO(db, claims, true, now)
+
+		assert.Equal(t, err, ErrSignupNotAllowed, "error mismatch")
+	})
+}
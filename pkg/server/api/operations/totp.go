@@ -0,0 +1,242 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/totp"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// recoveryCodeCount is the number of recovery codes generated for a user
+const recoveryCodeCount = 10
+
+var (
+	// ErrTOTPEnabled is an error for enrolling in the two-factor authentication when it is already enabled
+	ErrTOTPEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrTOTPNotEnabled is an error for an operation that requires the two-factor authentication to be enabled
+	ErrTOTPNotEnabled = errors.New("two-factor authentication is not enabled")
+	// ErrTOTPEnrollmentNotStarted is an error for verifying a code before the enrollment begins
+	ErrTOTPEnrollmentNotStarted = errors.New("two-factor authentication enrollment has not started")
+)
+
+// BeginTOTPEnrollment generates a new secret for the account. The two-factor
+// authentication is not enabled until a code for the secret is verified.
+func BeginTOTPEnrollment(tx *gorm.DB, account *database.Account) (string, error) {
+	if account.TOTPEnabled {
+		return "", ErrTOTPEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", errors.Wrap(err, "generating secret")
+	}
+
+	account.TOTPSecret = secret
+	account.TOTPLastStep = 0
+	if err := tx.Save(account).Error; err != nil {
+		return "", errors.Wrap(err, "saving secret")
+	}
+
+	return secret, nil
+}
+
+// VerifyTOTP checks the code against the secret of the account. A code can be
+// used only once.
+func VerifyTOTP(tx *gorm.DB, account *database.Account, code string, now time.Time) (bool, error) {
+	if account.TOTPSecret == "" {
+		return false, nil
+	}
+
+	step, ok := totp.Validate(account.TOTPSecret, code, now)
+	if !ok || step <= account.TOTPLastStep {
+		return false, nil
+	}
+
+	account.TOTPLastStep = step
+	if err := tx.Model(account).Update("totp_last_step", step).Error; err != nil {
+		return false, errors.Wrap(err, "updating the last step")
+	}
+
+	return true, nil
+}
+
+// EnableTOTP enables the two-factor authentication for the account if the code
+// is valid for the secret generated by BeginTOTPEnrollment, and returns new
+// recovery codes. It returns false if the code is invalid.
+func EnableTOTP(tx *gorm.DB, account *database.Account, code string, now time.Time) ([]string, bool, error) {
+	if account.TOTPEnabled {
+		return nil, false, ErrTOTPEnabled
+	}
+	if account.TOTPSecret == "" {
+		return nil, false, ErrTOTPEnrollmentNotStarted
+	}
+
+	ok, err := VerifyTOTP(tx, account, code, now)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "verifying code")
+	}
+	if !ok {
+		return nil, false, nil
+	}
+
+	account.TOTPEnabled = true
+	if err := tx.Model(account).Update("totp_enabled", true).Error; err != nil {
+		return nil, false, errors.Wrap(err, "enabling two-factor authentication")
+	}
+
+	if err := tx.Model(&database.Session{}).Where("user_id = ?", account.UserID).Update("totp_enrollment_required", false).Error; err != nil {
+		return nil, false, errors.Wrap(err, "lifting the restriction of sessions")
+	}
+
+	codes, err := GenerateRecoveryCodes(tx, account.UserID)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "generating recovery codes")
+	}
+
+	return codes, true, nil
+}
+
+// DisableTOTP disables the two-factor authentication for the account and
+// deletes the recovery codes
+func DisableTOTP(tx *gorm.DB, account *database.Account) error {
+	if !account.TOTPEnabled {
+		return ErrTOTPNotEnabled
+	}
+
+	account.TOTPEnabled = false
+	account.TOTPSecret = ""
+	account.TOTPLastStep = 0
+	if err := tx.Save(account).Error; err != nil {
+		return errors.Wrap(err, "disabling two-factor authentication")
+	}
+
+	if err := tx.Where("user_id = ?", account.UserID).Delete(&database.RecoveryCode{}).Error; err != nil {
+		return errors.Wrap(err, "deleting recovery codes")
+	}
+
+	return nil
+}
+
+// normalizeRecoveryCode removes the formatting from a recovery code entered by a user
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.Replace(code, "-", "", -1)
+	code = strings.Replace(code, " ", "", -1)
+
+	return code
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "reading random bits")
+	}
+
+	s := hex.EncodeToString(b)
+
+	return fmt.Sprintf("%s-%s", s[:5], s[5:]), nil
+}
+
+// GenerateRecoveryCodes replaces the recovery codes of the user with new codes
+// and returns them. Only the hashes of the codes are stored.
+func GenerateRecoveryCodes(tx *gorm.DB, userID int) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&database.RecoveryCode{}).Error; err != nil {
+		return nil, errors.Wrap(err, "deleting existing recovery codes")
+	}
+
+	codes := []string{}
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, errors.Wrap(err, "generating a recovery code")
+		}
+
+		rc := database.RecoveryCode{
+			UserID:   userID,
+			CodeHash: hashRecoveryCode(code),
+		}
+		if err := tx.Save(&rc).Error; err != nil {
+			return nil, errors.Wrap(err, "saving a recovery code")
+		}
+
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode marks the recovery code of the user as used. It returns false
+// if the code does not exist or has already been used.
+func UseRecoveryCode(tx *gorm.DB, userID int, code string, now time.Time) (bool, error) {
+	if normalizeRecoveryCode(code) == "" {
+		return false, nil
+	}
+
+	var rc database.RecoveryCode
+	conn := tx.Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashRecoveryCode(code)).First(&rc)
+	if conn.RecordNotFound() {
+		return false, nil
+	} else if err := conn.Error; err != nil {
+		return false, errors.Wrap(err, "finding recovery code")
+	}
+
+	if err := tx.Model(&rc).Update("used_at", now).Error; err != nil {
+		return false, errors.Wrap(err, "marking the recovery code as used")
+	}
+
+	return true, nil
+}
+
+// VerifySecondFactor verifies either the two-factor authentication code or the
+// recovery code of the user of the account
+func VerifySecondFactor(tx *gorm.DB, account *database.Account, code, recoveryCode string, now time.Time) (bool, error) {
+	if code != "" {
+		return VerifyTOTP(tx, account, code, now)
+	}
+
+	return UseRecoveryCode(tx, account.UserID, recoveryCode, now)
+}
+
+// RequiresTOTP checks if the organization of the user requires the two-factor authentication
+func RequiresTOTP(db *gorm.DB, user database.User) (bool, error) {
+	org, ok, err := GetOrganization(db, user)
+	if err != nil {
+		return false, errors.Wrap(err, "getting organization")
+	}
+	if !ok {
+		return false, nil
+	}
+
+	return org.RequireTOTP, nil
+}
@@ -0,0 +1,199 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package operations
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/dnote/dnote/pkg/server/totp"
+	"github.com/pkg/errors"
+)
+
+func mustGenerateTOTP(t *testing.T, secret string, now time.Time) string {
+	code, err := totp.Generate(secret, now)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "generating code"))
+	}
+
+	return code
+}
+
+func TestEnableTOTP(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	now := time.Date(2019, time.November, 1, 0, 0, 0, 0, time.UTC)
+	user := testutils.SetupUserData()
+	account := testutils.SetupAccountData(user, "alice@example.com", "pass1234")
+	session := database.Session{Key: "someKey", UserID: user.ID, TOTPEnrollmentRequired: true}
+	testutils.MustExec(t, db.Save(&session), "preparing session")
+
+	secret, err := BeginTOTPEnrollment(db, &account)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning enrollment"))
+	}
+
+	// invalid code
+	codes, ok, err := EnableTOTP(db, &account, "000000", now)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "enabling with an invalid code"))
+	}
+	assert.Equal(t, ok, false, "invalid code should be rejected")
+	assert.Equal(t, len(codes), 0, "codes count mismatch")
+
+	// valid code
+	codes, ok, err = EnableTOTP(db, &account, mustGenerateTOTP(t, secret, now), now)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "enabling with a valid code"))
+	}
+	assert.Equal(t, ok, true, "valid code should be accepted")
+	assert.Equal(t, len(codes), recoveryCodeCount, "codes count mismatch")
+
+	var accountRecord database.Account
+	var sessionRecord database.Session
+	var codeCount int
+	testutils.MustExec(t, db.Where("id = ?", account.ID).First(&accountRecord), "finding account")
+	testutils.MustExec(t, db.Where("id = ?", session.ID).First(&sessionRecord), "finding session")
+	testutils.MustExec(t, db.Model(&database.RecoveryCode{}).Where("user_id = ?", user.ID).Count(&codeCount), "counting recovery codes")
+
+	assert.Equal(t, accountRecord.TOTPEnabled, true, "totp_enabled mismatch")
+	assert.Equal(t, accountRecord.TOTPSecret, secret, "totp_secret mismatch")
+	assert.Equal(t, sessionRecord.TOTPEnrollmentRequired, false, "session restriction should be lifted")
+	assert.Equal(t, codeCount, recoveryCodeCount, "stored recovery code count mismatch")
+
+	// already enabled
+	_, err = BeginTOTPEnrollment(db, &account)
+	assert.Equal(t, err, ErrTOTPEnabled, "error mismatch")
+}
+
+func TestVerifyTOTP_reuse(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	now := time.Date(2019, time.November, 1, 0, 0, 0, 0, time.UTC)
+	user := testutils.SetupUserData()
+	account := testutils.SetupAccountData(user, "alice@example.com", "pass1234")
+	secret, err := BeginTOTPEnrollment(db, &account)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "beginning enrollment"))
+	}
+
+	code := mustGenerateTOTP(t, secret, now)
+
+	ok, err := VerifyTOTP(db, &account, code, now)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "verifying for the first time"))
+	}
+	assert.Equal(t, ok, true, "first use should be accepted")
+
+	ok, err = VerifyTOTP(db, &account, code, now.Add(10*time.Second))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "verifying for the second time"))
+	}
+	assert.Equal(t, ok, false, "reuse should be rejected")
+
+	ok, err = VerifyTOTP(db, &account, mustGenerateTOTP(t, secret, now.Add(totp.Period*time.Second)), now.Add(totp.Period*time.Second))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "verifying the next code"))
+	}
+	assert.Equal(t, ok, true, "the code of the next period should be accepted")
+}
+
+func TestUseRecoveryCode(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	now := time.Date(2019, time.November, 1, 0, 0, 0, 0, time.UTC)
+	user := testutils.SetupUserData()
+	anotherUser := testutils.SetupUserData()
+
+	codes, err := GenerateRecoveryCodes(db, user.ID)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "generating recovery codes"))
+	}
+
+	ok, err := UseRecoveryCode(db, anotherUser.ID, codes[0], now)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "using a code of another user"))
+	}
+	assert.Equal(t, ok, false, "a code of another user should be rejected")
+
+	ok, err = UseRecoveryCode(db, user.ID, " "+strings.ToUpper(codes[0])+" ", now)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "using a code"))
+	}
+	assert.Equal(t, ok, true, "a code should be accepted regardless of the formatting")
+
+	ok, err = UseRecoveryCode(db, user.ID, codes[0], now)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "reusing a code"))
+	}
+	assert.Equal(t, ok, false, "a used code should be rejected")
+
+	ok, err = UseRecoveryCode(db, user.ID, "", now)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "using an empty code"))
+	}
+	assert.Equal(t, ok, false, "an empty code should be rejected")
+
+	// regenerating invalidates the previous codes
+	if _, err := GenerateRecoveryCodes(db, user.ID); err != nil {
+		t.Fatal(errors.Wrap(err, "regenerating recovery codes"))
+	}
+	ok, err = UseRecoveryCode(db, user.ID, codes[1], now)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "using a previous code"))
+	}
+	assert.Equal(t, ok, false, "a previous code should be rejected")
+}
+
+func TestDisableTOTP(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	account := testutils.SetupAccountData(user, "alice@example.com", "pass1234")
+
+	assert.Equal(t, DisableTOTP(db, &account), ErrTOTPNotEnabled, "error mismatch")
+
+	account.TOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	account.TOTPEnabled = true
+	testutils.MustExec(t, db.Save(&account), "preparing account")
+	if _, err := GenerateRecoveryCodes(db, user.ID); err != nil {
+		t.Fatal(errors.Wrap(err, "generating recovery codes"))
+	}
+
+	if err := DisableTOTP(db, &account); err != nil {
+		t.Fatal(errors.Wrap(err, "executing"))
+	}
+
+	var accountRecord database.Account
+	var codeCount int
+	testutils.MustExec(t, db.Where("id = ?", account.ID).First(&accountRecord), "finding account")
+	testutils.MustExec(t, db.Model(&database.RecoveryCode{}).Where("user_id = ?", user.ID).Count(&codeCount), "counting recovery codes")
+
+	assert.Equal(t, accountRecord.TOTPEnabled, false, "totp_enabled mismatch")
+	assert.Equal(t, accountRecord.TOTPSecret, "", "totp_secret mismatch")
+	assert.Equal(t, codeCount, 0, "recovery codes should be deleted")
+}
@@ -103,35 +103,71 @@ func createDefaultRepetitionRule(user database.User, tx *gorm.DB) error {
 	return nil
 }
 
+// CreateUserParams is the optional attributes of a new user
+type CreateUserParams struct {
+	OrganizationID int
+	Admin          bool
+	// OIDCIssuer and OIDCSubject link the account to the user at an OpenID Connect
+	// provider. The email of such account is verified by the provider.
+	OIDCIssuer  string
+	OIDCSubject string
+	// Invitation is the organization invitation with which the user signs up. It is
+	// marked accepted at AcceptedAt in the same transaction as the creation of the
+	// user, and the user is not created if it has already been accepted.
+	Invitation *database.OrganizationInvitation
+	AcceptedAt time.Time
+}
+
 // CreateUser creates a user
-func CreateUser(email, password string) (database.User, error) {
+func CreateUser(email, password string, p CreateUserParams) (database.User, error) {
 	db := database.DBConn
 	tx := db.Begin()
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		tx.Rollback()
-		return database.User{}, errors.Wrap(err, "hashing password")
+	if p.Invitation != nil {
+		if err := acceptOrganizationInvitation(tx, *p.Invitation, p.AcceptedAt); err != nil {
+			tx.Rollback()
+			return database.User{}, err
+		}
+	}
+
+	// an account signing in with single sign-on has no password
+	var hashedPassword database.NullString
+	if password != "" {
+		h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			tx.Rollback()
+			return database.User{}, errors.Wrap(err, "hashing password")
+		}
+
+		hashedPassword = database.ToNullString(string(h))
 	}
 
-	user := database.User{}
-	if err = tx.Save(&user).Error; err != nil {
+	user := database.User{
+		OrganizationID: p.OrganizationID,
+		Admin:          p.Admin,
+	}
+	if err := tx.Save(&user).Error; err != nil {
 		tx.Rollback()
 		return database.User{}, errors.Wrap(err, "saving user")
 	}
 	account := database.Account{
-		Email:    database.ToNullString(email),
-		Password: database.ToNullString(string(hashedPassword)),
-		UserID:   user.ID,
-	}
-	if err = tx.Save(&account).Error; err != nil {
+		Email:         database.ToNullString(email),
+		Password:      hashedPassword,
+		UserID:        user.ID,
+		OIDCIssuer:    p.OIDCIssuer,
+		OIDCSubject:   p.OIDCSubject,
+		EmailVerified: p.OIDCSubject != "",
+	}
+	if err := tx.Save(&account).Error; err != nil {
 		tx.Rollback()
 		return database.User{}, errors.Wrap(err, "saving account")
 	}
 
-	if err := createEmailVerificaitonToken(user, tx); err != nil {
-		tx.Rollback()
-		return database.User{}, errors.Wrap(err, "creating email verificaiton token")
+	if !account.EmailVerified {
+		if err := createEmailVerificaitonToken(user, tx); err != nil {
+			tx.Rollback()
+			return database.User{}, errors.Wrap(err, "creating email verificaiton token")
+		}
 	}
 	if err := createEmailPreference(user, tx); err != nil {
 		tx.Rollback()
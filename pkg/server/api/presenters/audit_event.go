@@ -0,0 +1,54 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package presenters
+
+import (
+	"time"
+
+	"github.com/dnote/dnote/pkg/server/database"
+)
+
+// AuditEvent is a presented audit event
+type AuditEvent struct {
+	ID        int       `json:"id"`
+	Type      string    `json:"type"`
+	ActorUUID string    `json:"actor_uuid"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PresentAuditEvents presents audit events. actorUUIDs maps the ids of the actors
+// to their uuids.
+func PresentAuditEvents(events []database.AuditEvent, actorUUIDs map[int]string) []AuditEvent {
+	ret := []AuditEvent{}
+
+	for _, e := range events {
+		ret = append(ret, AuditEvent{
+			ID:        e.ID,
+			Type:      e.Type,
+			ActorUUID: actorUUIDs[e.ActorID],
+			IPAddress: e.IPAddress,
+			UserAgent: e.UserAgent,
+			CreatedAt: FormatTS(e.CreatedAt),
+		})
+	}
+
+	return ret
+}
@@ -26,21 +26,23 @@ import (
 
 // Book is a result of PresentBooks
 type Book struct {
-	UUID      string    `json:"uuid"`
-	USN       int       `json:"usn"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Label     string    `json:"label"`
+	UUID       string    `json:"uuid"`
+	USN        int       `json:"usn"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Label      string    `json:"label"`
+	ParentUUID string    `json:"parent_uuid"`
 }
 
 // PresentBook presents a book
 func PresentBook(book database.Book) Book {
 	return Book{
-		UUID:      book.UUID,
-		USN:       book.USN,
-		CreatedAt: FormatTS(book.CreatedAt),
-		UpdatedAt: FormatTS(book.UpdatedAt),
-		Label:     book.Label,
+		UUID:       book.UUID,
+		USN:        book.USN,
+		CreatedAt:  FormatTS(book.CreatedAt),
+		UpdatedAt:  FormatTS(book.UpdatedAt),
+		Label:      book.Label,
+		ParentUUID: book.ParentUUID,
 	}
 }
 
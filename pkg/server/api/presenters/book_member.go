@@ -0,0 +1,77 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package presenters
+
+import (
+	"time"
+
+	"github.com/dnote/dnote/pkg/server/database"
+)
+
+// BookMember is a result of PresentBookMember
+type BookMember struct {
+	UserUUID  string    `json:"user_uuid"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PresentBookMember presents a book member with the given email
+func PresentBookMember(member database.BookMember, email string) BookMember {
+	return BookMember{
+		UserUUID:  member.User.UUID,
+		Email:     email,
+		Role:      member.Role,
+		CreatedAt: FormatTS(member.CreatedAt),
+	}
+}
+
+// BookInvitation is a result of PresentBookInvitation
+type BookInvitation struct {
+	UUID       string     `json:"uuid"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	CreatedAt  time.Time  `json:"created_at"`
+	AcceptedAt *time.Time `json:"accepted_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// PresentBookInvitation presents a book invitation
+func PresentBookInvitation(invitation database.BookInvitation) BookInvitation {
+	return BookInvitation{
+		UUID:       invitation.UUID,
+		Email:      invitation.Email,
+		Role:       invitation.Role,
+		CreatedAt:  FormatTS(invitation.CreatedAt),
+		AcceptedAt: formatNullableTS(invitation.AcceptedAt),
+		ExpiresAt:  formatNullableTS(invitation.ExpiresAt),
+	}
+}
+
+// PresentBookInvitations presents book invitations
+func PresentBookInvitations(invitations []database.BookInvitation) []BookInvitation {
+	ret := []BookInvitation{}
+
+	for _, invitation := range invitations {
+		p := PresentBookInvitation(invitation)
+		ret = append(ret, p)
+	}
+
+	return ret
+}
@@ -27,3 +27,13 @@ import (
 func FormatTS(ts time.Time) time.Time {
 	return ts.UTC().Round(time.Microsecond)
 }
+
+// formatNullableTS formats the given timestamp using FormatTS if it is not nil
+func formatNullableTS(ts *time.Time) *time.Time {
+	if ts == nil {
+		return nil
+	}
+
+	ret := FormatTS(*ts)
+	return &ret
+}
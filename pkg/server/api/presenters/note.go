@@ -35,6 +35,9 @@ type Note struct {
 	USN       int       `json:"usn"`
 	Book      NoteBook  `json:"book"`
 	User      NoteUser  `json:"user"`
+	// Author and Editor are the users who created and last edited the note
+	Author NoteUser `json:"author"`
+	Editor NoteUser `json:"editor"`
 }
 
 // NoteBook is a nested book for PresentNotesResult
@@ -67,6 +70,14 @@ func PresentNote(note database.Note) Note {
 			Name: note.User.Name,
 			UUID: note.User.UUID,
 		},
+		Author: NoteUser{
+			Name: note.Author.Name,
+			UUID: note.Author.UUID,
+		},
+		Editor: NoteUser{
+			Name: note.Editor.Name,
+			UUID: note.Editor.UUID,
+		},
 	}
 
 	return ret
@@ -83,3 +94,44 @@ func PresentNotes(notes []database.Note) []Note {
 
 	return ret
 }
+
+// NoteShare is a result of PresentNoteShare
+type NoteShare struct {
+	NoteUUID  string     `json:"note_uuid"`
+	Public    bool       `json:"public"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	ViewCount int        `json:"view_count"`
+}
+
+// PresentNoteShare presents the sharing state of a note
+func PresentNoteShare(note database.Note) NoteShare {
+	return NoteShare{
+		NoteUUID:  note.UUID,
+		Public:    note.Public,
+		ExpiresAt: formatNullableTS(note.PublicExpiresAt),
+		ViewCount: note.ViewCount,
+	}
+}
+
+// PublicNote is a result of PresentPublicNote. It omits any information
+// about the owner of the note.
+type PublicNote struct {
+	UUID      string `json:"uuid"`
+	Body      string `json:"content"`
+	BookLabel string `json:"book_label"`
+	AddedOn   int64  `json:"added_on"`
+	EditedOn  int64  `json:"edited_on"`
+	ViewCount int    `json:"view_count"`
+}
+
+// PresentPublicNote presents a publicly shared note
+func PresentPublicNote(note database.Note) PublicNote {
+	return PublicNote{
+		UUID:      note.UUID,
+		Body:      note.Body,
+		BookLabel: note.Book.Label,
+		AddedOn:   note.AddedOn,
+		EditedOn:  note.EditedOn,
+		ViewCount: note.ViewCount,
+	}
+}
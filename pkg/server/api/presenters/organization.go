@@ -0,0 +1,118 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package presenters
+
+import (
+	"time"
+
+	"github.com/dnote/dnote/pkg/server/database"
+)
+
+// Organization is a result of PresentOrganization
+type Organization struct {
+	UUID                  string    `json:"uuid"`
+	Name                  string    `json:"name"`
+	AllowedDomains        []string  `json:"allowed_domains"`
+	InviteOnly            bool      `json:"invite_only"`
+	MinClientKDFIteration int       `json:"min_client_kdf_iteration"`
+	DisablePublicNotes    bool      `json:"disable_public_notes"`
+	RequireTOTP           bool      `json:"require_totp"`
+	CreatedAt             time.Time `json:"created_at"`
+}
+
+// PresentOrganization presents an organization
+func PresentOrganization(org database.Organization) Organization {
+	domains := []string{}
+	domains = append(domains, org.AllowedDomains...)
+
+	return Organization{
+		UUID:                  org.UUID,
+		Name:                  org.Name,
+		AllowedDomains:        domains,
+		InviteOnly:            org.InviteOnly,
+		MinClientKDFIteration: org.MinClientKDFIteration,
+		DisablePublicNotes:    org.DisablePublicNotes,
+		RequireTOTP:           org.RequireTOTP,
+		CreatedAt:             FormatTS(org.CreatedAt),
+	}
+}
+
+// OrganizationUser is a result of PresentOrganizationUser
+type OrganizationUser struct {
+	UUID        string     `json:"uuid"`
+	Email       string     `json:"email"`
+	Admin       bool       `json:"admin"`
+	Disabled    bool       `json:"disabled"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+// PresentOrganizationUser presents a user of an organization to administrators
+func PresentOrganizationUser(user database.User) OrganizationUser {
+	return OrganizationUser{
+		UUID:        user.UUID,
+		Email:       user.Account.Email.String,
+		Admin:       user.Admin,
+		Disabled:    user.DisabledAt != nil,
+		CreatedAt:   FormatTS(user.CreatedAt),
+		LastLoginAt: formatNullableTS(user.LastLoginAt),
+	}
+}
+
+// PresentOrganizationUsers presents users of an organization
+func PresentOrganizationUsers(users []database.User) []OrganizationUser {
+	ret := []OrganizationUser{}
+
+	for _, user := range users {
+		p := PresentOrganizationUser(user)
+		ret = append(ret, p)
+	}
+
+	return ret
+}
+
+// OrganizationInvitation is a result of PresentOrganizationInvitation
+type OrganizationInvitation struct {
+	UUID       string     `json:"uuid"`
+	Email      string     `json:"email"`
+	CreatedAt  time.Time  `json:"created_at"`
+	AcceptedAt *time.Time `json:"accepted_at"`
+}
+
+// PresentOrganizationInvitation presents an organization invitation
+func PresentOrganizationInvitation(invitation database.OrganizationInvitation) OrganizationInvitation {
+	return OrganizationInvitation{
+		UUID:       invitation.UUID,
+		Email:      invitation.Email,
+		CreatedAt:  FormatTS(invitation.CreatedAt),
+		AcceptedAt: formatNullableTS(invitation.AcceptedAt),
+	}
+}
+
+// PresentOrganizationInvitations presents organization invitations
+func PresentOrganizationInvitations(invitations []database.OrganizationInvitation) []OrganizationInvitation {
+	ret := []OrganizationInvitation{}
+
+	for _, invitation := range invitations {
+		p := PresentOrganizationInvitation(invitation)
+		ret = append(ret, p)
+	}
+
+	return ret
+}
@@ -0,0 +1,58 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package presenters
+
+import (
+	"time"
+
+	"github.com/dnote/dnote/pkg/server/database"
+)
+
+// Session is a presented session. It does not include the key of the session.
+type Session struct {
+	UUID       string    `json:"uuid"`
+	DeviceName string    `json:"device_name"`
+	ClientType string    `json:"client_type"`
+	IPAddress  string    `json:"ip_address"`
+	Current    bool      `json:"current"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// PresentSessions presents sessions. The session of the given id is marked as
+// the current session.
+func PresentSessions(sessions []database.Session, currentID int) []Session {
+	ret := []Session{}
+
+	for _, s := range sessions {
+		ret = append(ret, Session{
+			UUID:       s.UUID,
+			DeviceName: s.DeviceName,
+			ClientType: s.ClientType,
+			IPAddress:  s.IPAddress,
+			Current:    s.ID == currentID,
+			LastUsedAt: FormatTS(s.LastUsedAt),
+			ExpiresAt:  FormatTS(s.ExpiresAt),
+			CreatedAt:  FormatTS(s.CreatedAt),
+		})
+	}
+
+	return ret
+}
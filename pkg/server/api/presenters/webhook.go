@@ -0,0 +1,104 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package presenters
+
+import (
+	"time"
+
+	"github.com/dnote/dnote/pkg/server/database"
+)
+
+// Webhook is a presented webhook
+type Webhook struct {
+	UUID      string    `json:"uuid"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PresentWebhook presents a webhook
+func PresentWebhook(w database.Webhook) Webhook {
+	events := []string{}
+	events = append(events, w.Events...)
+
+	return Webhook{
+		UUID:      w.UUID,
+		URL:       w.URL,
+		Events:    events,
+		Enabled:   w.Enabled,
+		CreatedAt: FormatTS(w.CreatedAt),
+		UpdatedAt: FormatTS(w.UpdatedAt),
+	}
+}
+
+// PresentWebhooks presents webhooks
+func PresentWebhooks(ws []database.Webhook) []Webhook {
+	ret := []Webhook{}
+
+	for _, w := range ws {
+		p := PresentWebhook(w)
+		ret = append(ret, p)
+	}
+
+	return ret
+}
+
+// WebhookDelivery is a presented webhook delivery
+type WebhookDelivery struct {
+	UUID          string     `json:"uuid"`
+	Event         string     `json:"event"`
+	Payload       string     `json:"payload"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	ResponseCode  int        `json:"response_code"`
+	Error         string     `json:"error"`
+	NextAttemptAt *time.Time `json:"next_attempt_at"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// PresentWebhookDelivery presents a webhook delivery
+func PresentWebhookDelivery(d database.WebhookDelivery) WebhookDelivery {
+	return WebhookDelivery{
+		UUID:          d.UUID,
+		Event:         d.Event,
+		Payload:       d.Payload,
+		Status:        d.Status,
+		Attempts:      d.Attempts,
+		ResponseCode:  d.ResponseCode,
+		Error:         d.Error,
+		NextAttemptAt: formatNullableTS(d.NextAttemptAt),
+		DeliveredAt:   formatNullableTS(d.DeliveredAt),
+		CreatedAt:     FormatTS(d.CreatedAt),
+	}
+}
+
+// PresentWebhookDeliveries presents webhook deliveries
+func PresentWebhookDeliveries(ds []database.WebhookDelivery) []WebhookDelivery {
+	ret := []WebhookDelivery{}
+
+	for _, d := range ds {
+		p := PresentWebhookDelivery(d)
+		ret = append(ret, p)
+	}
+
+	return ret
+}
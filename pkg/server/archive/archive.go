@@ -0,0 +1,251 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package archive exports the data of a user to a versioned archive, and imports
+// it back on the same or a different instance
+package archive
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/dnote/dnote/pkg/server/blob"
+	"github.com/pkg/errors"
+)
+
+// Version is the version of the archive format. It is incremented whenever the
+// format changes in a way that older versions of the server cannot read.
+const Version = 1
+
+// Archive is a snapshot of the data of a user
+type Archive struct {
+	Version         int              `json:"version"`
+	ExportedAt      time.Time        `json:"exported_at"`
+	Books           []Book           `json:"books"`
+	Notes           []Note           `json:"notes"`
+	RepetitionRules []RepetitionRule `json:"repetition_rules"`
+	Digests         []Digest         `json:"digests"`
+}
+
+// Book is a book in an archive
+type Book struct {
+	UUID       string `json:"uuid"`
+	ParentUUID string `json:"parent_uuid,omitempty"`
+	Label      string `json:"label"`
+	AddedOn    int64  `json:"added_on"`
+	EditedOn   int64  `json:"edited_on"`
+}
+
+// Note is a note in an archive
+type Note struct {
+	UUID            string     `json:"uuid"`
+	BookUUID        string     `json:"book_uuid"`
+	Body            string     `json:"body"`
+	AddedOn         int64      `json:"added_on"`
+	EditedOn        int64      `json:"edited_on"`
+	Public          bool       `json:"public"`
+	PublicExpiresAt *time.Time `json:"public_expires_at"`
+	// Attachments are the metadata of the attachments of the note. The content
+	// is not included, and remains in the storage of the instance. An attachment
+	// is imported only if the content is available to the user on the instance.
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// Attachment is an attachment of a note in an archive
+type Attachment struct {
+	UUID        string `json:"uuid"`
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Hash        string `json:"hash"`
+}
+
+// RepetitionRule is a repetition rule in an archive
+type RepetitionRule struct {
+	UUID       string   `json:"uuid"`
+	Title      string   `json:"title"`
+	Enabled    bool     `json:"enabled"`
+	Hour       int      `json:"hour"`
+	Minute     int      `json:"minute"`
+	Frequency  int64    `json:"frequency"`
+	LastActive int64    `json:"last_active"`
+	NextActive int64    `json:"next_active"`
+	BookDomain string   `json:"book_domain"`
+	BookUUIDs  []string `json:"book_uuids"`
+	NoteCount  int      `json:"note_count"`
+}
+
+// Digest is a digest in an archive
+type Digest struct {
+	UUID      string    `json:"uuid"`
+	RuleUUID  string    `json:"rule_uuid"`
+	NoteUUIDs []string  `json:"note_uuids"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ErrUnsupportedVersion is returned if an archive was produced by an incompatible
+// version of the server
+var ErrUnsupportedVersion = errors.New("unsupported archive version")
+
+// ErrTooLarge is returned if the decompressed archive is larger than the maximum size
+var ErrTooLarge = errors.New("archive is too large")
+
+// Encode writes the archive to the given writer in gzipped JSON
+func Encode(w io.Writer, a Archive) error {
+	zw := gzip.NewWriter(w)
+
+	if err := json.NewEncoder(zw).Encode(a); err != nil {
+		return errors.Wrap(err, "encoding archive")
+	}
+	if err := zw.Close(); err != nil {
+		return errors.Wrap(err, "flushing archive")
+	}
+
+	return nil
+}
+
+// Decode reads an archive written by Encode from the given reader. It returns
+// ErrTooLarge if the decompressed archive is larger than maxSize bytes. If maxSize
+// is 0, the size is not limited.
+func Decode(r io.Reader, maxSize int64) (Archive, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return Archive{}, errors.Wrap(err, "reading gzip header")
+	}
+	defer zr.Close()
+
+	var src io.Reader = zr
+	var lr *io.LimitedReader
+	if maxSize > 0 {
+		lr = &io.LimitedReader{R: zr, N: maxSize + 1}
+		src = lr
+	}
+
+	var ret Archive
+	if err := json.NewDecoder(src).Decode(&ret); err != nil {
+		if lr != nil && lr.N <= 0 {
+			return Archive{}, ErrTooLarge
+		}
+
+		return Archive{}, errors.Wrap(err, "decoding archive")
+	}
+	if lr != nil && lr.N <= 0 {
+		return Archive{}, ErrTooLarge
+	}
+
+	if ret.Version < 1 || ret.Version > Version {
+		return Archive{}, ErrUnsupportedVersion
+	}
+
+	return ret, nil
+}
+
+// ValidationError is an error for an archive whose data is inconsistent
+type ValidationError struct {
+	message string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("invalid archive: %s", e.message)
+}
+
+func invalidf(format string, a ...interface{}) ValidationError {
+	return ValidationError{message: fmt.Sprintf(format, a...)}
+}
+
+// Validate checks that the archive can be imported
+func (a Archive) Validate() error {
+	bookUUIDs := map[string]bool{}
+	labels := map[string]bool{}
+	parents := map[string]string{}
+	for _, b := range a.Books {
+		if b.UUID == "" || bookUUIDs[b.UUID] {
+			return invalidf("duplicate or empty book uuid '%s'", b.UUID)
+		}
+		// labels are unique among the books in the same parent
+		key := b.ParentUUID + "/" + b.Label
+		if b.Label == "" || labels[key] {
+			return invalidf("duplicate or empty book label '%s'", b.Label)
+		}
+
+		bookUUIDs[b.UUID] = true
+		labels[key] = true
+		parents[b.UUID] = b.ParentUUID
+	}
+	for _, b := range a.Books {
+		if b.ParentUUID != "" && !bookUUIDs[b.ParentUUID] {
+			return invalidf("book %s is in an unknown book %s", b.UUID, b.ParentUUID)
+		}
+
+		// walking up the parents must reach a top level book
+		seen := map[string]bool{}
+		for uuid := b.UUID; uuid != ""; uuid = parents[uuid] {
+			if seen[uuid] {
+				return invalidf("book %s is nested in itself", b.UUID)
+			}
+			seen[uuid] = true
+		}
+	}
+
+	noteUUIDs := map[string]bool{}
+	for _, n := range a.Notes {
+		if n.UUID == "" || noteUUIDs[n.UUID] {
+			return invalidf("duplicate or empty note uuid '%s'", n.UUID)
+		}
+		if !bookUUIDs[n.BookUUID] {
+			return invalidf("note %s is in an unknown book %s", n.UUID, n.BookUUID)
+		}
+		for _, at := range n.Attachments {
+			if at.Name == "" || !blob.ValidateKey(at.Hash) {
+				return invalidf("note %s has an invalid attachment '%s'", n.UUID, at.UUID)
+			}
+		}
+
+		noteUUIDs[n.UUID] = true
+	}
+
+	ruleUUIDs := map[string]bool{}
+	for _, r := range a.RepetitionRules {
+		if r.UUID == "" || ruleUUIDs[r.UUID] {
+			return invalidf("duplicate or empty repetition rule uuid '%s'", r.UUID)
+		}
+		for _, uuid := range r.BookUUIDs {
+			if !bookUUIDs[uuid] {
+				return invalidf("repetition rule %s has an unknown book %s", r.UUID, uuid)
+			}
+		}
+
+		ruleUUIDs[r.UUID] = true
+	}
+
+	for _, d := range a.Digests {
+		if !ruleUUIDs[d.RuleUUID] {
+			return invalidf("digest %s has an unknown repetition rule %s", d.UUID, d.RuleUUID)
+		}
+		for _, uuid := range d.NoteUUIDs {
+			if !noteUUIDs[uuid] {
+				return invalidf("digest %s has an unknown note %s", d.UUID, uuid)
+			}
+		}
+	}
+
+	return nil
+}
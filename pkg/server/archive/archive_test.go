@@ -0,0 +1,195 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	testutils.InitTestDB()
+}
+
+func TestEncodeDecode(t *testing.T) {
+	a := Archive{
+		Version:    Version,
+		ExportedAt: time.Date(2019, time.November, 1, 0, 0, 0, 0, time.UTC),
+		Books: []Book{
+			{UUID: "b1-uuid", Label: "js", AddedOn: 1, EditedOn: 2},
+		},
+		Notes: []Note{
+			{UUID: "n1-uuid", BookUUID: "b1-uuid", Body: "n1 content", AddedOn: 3},
+		},
+		RepetitionRules: []RepetitionRule{
+			{UUID: "r1-uuid", Title: "r1", BookUUIDs: []string{"b1-uuid"}},
+		},
+		Digests: []Digest{
+			{UUID: "d1-uuid", RuleUUID: "r1-uuid", NoteUUIDs: []string{"n1-uuid"}, CreatedAt: time.Date(2019, time.October, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, a); err != nil {
+		t.Fatal(errors.Wrap(err, "encoding"))
+	}
+
+	got, err := Decode(&buf, 0)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "decoding"))
+	}
+
+	assert.DeepEqual(t, got, a, "archive mismatch")
+}
+
+func TestDecode_Version(t *testing.T) {
+	testCases := []string{
+		`{"version": 0}`,
+		`{"version": 2}`,
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc, func(t *testing.T) {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			zw.Write([]byte(tc))
+			zw.Close()
+
+			_, err := Decode(&buf, 0)
+			assert.Equal(t, err, ErrUnsupportedVersion, "error mismatch")
+		})
+	}
+}
+
+func TestDecode_TooLarge(t *testing.T) {
+	a := Archive{
+		Version: Version,
+		Notes: []Note{
+			{UUID: "n1-uuid", BookUUID: "b1-uuid", Body: strings.Repeat("a", 1000)},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, a); err != nil {
+		t.Fatal(errors.Wrap(err, "encoding"))
+	}
+	b := buf.Bytes()
+
+	_, err := Decode(bytes.NewReader(b), 500)
+	assert.Equal(t, err, ErrTooLarge, "error mismatch for a small limit")
+
+	got, err := Decode(bytes.NewReader(b), 2000)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "decoding with a large limit"))
+	}
+	assert.DeepEqual(t, got, a, "archive mismatch")
+}
+
+func TestValidate(t *testing.T) {
+	books := []Book{{UUID: "b1", Label: "js"}, {UUID: "b2", Label: "css"}}
+	notes := []Note{{UUID: "n1", BookUUID: "b1"}}
+	rules := []RepetitionRule{{UUID: "r1", BookUUIDs: []string{"b2"}}}
+
+	testCases := []struct {
+		name     string
+		archive  Archive
+		expected string
+	}{
+		{
+			name:     "valid",
+			archive:  Archive{Books: books, Notes: notes, RepetitionRules: rules, Digests: []Digest{{UUID: "d1", RuleUUID: "r1", NoteUUIDs: []string{"n1"}}}},
+			expected: "",
+		},
+		{
+			name:     "duplicate book label",
+			archive:  Archive{Books: []Book{{UUID: "b1", Label: "js"}, {UUID: "b2", Label: "js"}}},
+			expected: "invalid archive: duplicate or empty book label 'js'",
+		},
+		{
+			name:     "same book label in different parents",
+			archive:  Archive{Books: []Book{{UUID: "b1", Label: "js"}, {UUID: "b2", Label: "web"}, {UUID: "b3", Label: "js", ParentUUID: "b2"}}},
+			expected: "",
+		},
+		{
+			name:     "book in unknown book",
+			archive:  Archive{Books: []Book{{UUID: "b1", Label: "js", ParentUUID: "b3"}}},
+			expected: "invalid archive: book b1 is in an unknown book b3",
+		},
+		{
+			name:     "book nested in itself",
+			archive:  Archive{Books: []Book{{UUID: "b1", Label: "js", ParentUUID: "b2"}, {UUID: "b2", Label: "css", ParentUUID: "b1"}}},
+			expected: "invalid archive: book b1 is nested in itself",
+		},
+		{
+			name:     "duplicate book uuid",
+			archive:  Archive{Books: []Book{{UUID: "b1", Label: "js"}, {UUID: "b1", Label: "css"}}},
+			expected: "invalid archive: duplicate or empty book uuid 'b1'",
+		},
+		{
+			name:     "note in unknown book",
+			archive:  Archive{Books: books, Notes: []Note{{UUID: "n1", BookUUID: "b3"}}},
+			expected: "invalid archive: note n1 is in an unknown book b3",
+		},
+		{
+			name:     "attachment with invalid hash",
+			archive:  Archive{Books: books, Notes: []Note{{UUID: "n1", BookUUID: "b1", Attachments: []Attachment{{UUID: "a1", Name: "a.png", Hash: "abc"}}}}},
+			expected: "invalid archive: note n1 has an invalid attachment 'a1'",
+		},
+		{
+			name:     "rule with unknown book",
+			archive:  Archive{Books: books, RepetitionRules: []RepetitionRule{{UUID: "r1", BookUUIDs: []string{"b3"}}}},
+			expected: "invalid archive: repetition rule r1 has an unknown book b3",
+		},
+		{
+			name:     "digest with unknown rule",
+			archive:  Archive{Books: books, Notes: notes, RepetitionRules: rules, Digests: []Digest{{UUID: "d1", RuleUUID: "r2"}}},
+			expected: "invalid archive: digest d1 has an unknown repetition rule r2",
+		},
+		{
+			name:     "digest with unknown note",
+			archive:  Archive{Books: books, Notes: notes, RepetitionRules: rules, Digests: []Digest{{UUID: "d1", RuleUUID: "r1", NoteUUIDs: []string{"n2"}}}},
+			expected: "invalid archive: digest d1 has an unknown note n2",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.archive.Validate()
+
+			if tc.expected == "" {
+				if err != nil {
+					t.Fatal(errors.Wrap(err, "validating"))
+				}
+			} else {
+				if err == nil {
+					t.Fatal("no error")
+				}
+
+				assert.Equal(t, err.Error(), tc.expected, "error mismatch")
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"time"
+
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// Export returns the archive of the books, notes, repetition rules and digests of
+// the given user. Deleted books and notes are not included. The attachments of the
+// notes are included without their content.
+func Export(db *gorm.DB, user database.User, now time.Time) (Archive, error) {
+	ret := Archive{
+		Version:         Version,
+		ExportedAt:      now.UTC(),
+		Books:           []Book{},
+		Notes:           []Note{},
+		RepetitionRules: []RepetitionRule{},
+		Digests:         []Digest{},
+	}
+
+	var books []database.Book
+	if err := db.Where("user_id = ? AND NOT deleted", user.ID).Order("id ASC").Find(&books).Error; err != nil {
+		return ret, errors.Wrap(err, "finding books")
+	}
+	for _, b := range books {
+		ret.Books = append(ret.Books, Book{
+			UUID:       b.UUID,
+			ParentUUID: b.ParentUUID,
+			Label:      b.Label,
+			AddedOn:    b.AddedOn,
+			EditedOn:   b.EditedOn,
+		})
+	}
+
+	var notes []database.Note
+	if err := db.Where("user_id = ? AND NOT deleted", user.ID).Order("id ASC").Find(&notes).Error; err != nil {
+		return ret, errors.Wrap(err, "finding notes")
+	}
+	var attachments []database.Attachment
+	if err := db.Where("note_uuid IN (SELECT uuid FROM notes WHERE user_id = ? AND NOT deleted)", user.ID).Order("id ASC").Find(&attachments).Error; err != nil {
+		return ret, errors.Wrap(err, "finding attachments")
+	}
+	noteAttachments := map[string][]Attachment{}
+	for _, a := range attachments {
+		noteAttachments[a.NoteUUID] = append(noteAttachments[a.NoteUUID], Attachment{
+			UUID:        a.UUID,
+			Name:        a.Name,
+			ContentType: a.ContentType,
+			Size:        a.Size,
+			Hash:        a.Hash,
+		})
+	}
+
+	noteUUIDs := map[string]bool{}
+	for _, n := range notes {
+		ret.Notes = append(ret.Notes, Note{
+			UUID:            n.UUID,
+			BookUUID:        n.BookUUID,
+			Body:            n.Body,
+			AddedOn:         n.AddedOn,
+			EditedOn:        n.EditedOn,
+			Public:          n.Public,
+			PublicExpiresAt: n.PublicExpiresAt,
+			Attachments:     noteAttachments[n.UUID],
+		})
+
+		noteUUIDs[n.UUID] = true
+	}
+
+	var rules []database.RepetitionRule
+	if err := db.Preload("Books").Where("user_id = ?", user.ID).Order("id ASC").Find(&rules).Error; err != nil {
+		return ret, errors.Wrap(err, "finding repetition rules")
+	}
+	ruleUUIDs := map[int]string{}
+	for _, r := range rules {
+		bookUUIDs := []string{}
+		for _, b := range r.Books {
+			if !b.Deleted {
+				bookUUIDs = append(bookUUIDs, b.UUID)
+			}
+		}
+
+		ret.RepetitionRules = append(ret.RepetitionRules, RepetitionRule{
+			UUID:       r.UUID,
+			Title:      r.Title,
+			Enabled:    r.Enabled,
+			Hour:       r.Hour,
+			Minute:     r.Minute,
+			Frequency:  r.Frequency,
+			LastActive: r.LastActive,
+			NextActive: r.NextActive,
+			BookDomain: r.BookDomain,
+			BookUUIDs:  bookUUIDs,
+			NoteCount:  r.NoteCount,
+		})
+
+		ruleUUIDs[r.ID] = r.UUID
+	}
+
+	var digests []database.Digest
+	if err := db.Preload("Notes").Where("user_id = ?", user.ID).Order("created_at ASC").Find(&digests).Error; err != nil {
+		return ret, errors.Wrap(err, "finding digests")
+	}
+	for _, d := range digests {
+		ruleUUID, ok := ruleUUIDs[d.RuleID]
+		if !ok {
+			// the rule of the digest was deleted
+			continue
+		}
+
+		uuids := []string{}
+		for _, n := range d.Notes {
+			if noteUUIDs[n.UUID] {
+				uuids = append(uuids, n.UUID)
+			}
+		}
+
+		ret.Digests = append(ret.Digests, Digest{
+			UUID:      d.UUID,
+			RuleUUID:  ruleUUID,
+			NoteUUIDs: uuids,
+			CreatedAt: d.CreatedAt,
+		})
+	}
+
+	return ret, nil
+}
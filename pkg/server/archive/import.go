@@ -0,0 +1,335 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"time"
+
+	"github.com/dnote/dnote/pkg/server/api/helpers"
+	"github.com/dnote/dnote/pkg/server/api/operations"
+	"github.com/dnote/dnote/pkg/server/blob"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// Summary is the number of the items imported from an archive
+type Summary struct {
+	Books           int `json:"books"`
+	Notes           int `json:"notes"`
+	RepetitionRules int `json:"repetition_rules"`
+	Digests         int `json:"digests"`
+	Attachments     int `json:"attachments"`
+	// SkippedAttachments is the number of the attachments that were not imported
+	// because their content is not available to the user on this instance
+	SkippedAttachments int `json:"skipped_attachments"`
+}
+
+// remapUUIDs returns a map from the given uuids to the uuids of the records to
+// be imported. A new uuid is generated for those that are invalid or already
+// used by the records of the given table.
+func remapUUIDs(tx *gorm.DB, table string, uuids []string) (map[string]string, error) {
+	valid := []string{}
+	for _, uuid := range uuids {
+		if helpers.ValidateUUID(uuid) {
+			valid = append(valid, uuid)
+		}
+	}
+
+	taken := map[string]bool{}
+	if len(valid) > 0 {
+		var existing []string
+		if err := tx.Table(table).Where("uuid IN (?)", valid).Pluck("uuid", &existing).Error; err != nil {
+			return nil, errors.Wrapf(err, "finding existing %s", table)
+		}
+
+		for _, uuid := range existing {
+			taken[uuid] = true
+		}
+	}
+
+	ret := map[string]string{}
+	for _, uuid := range uuids {
+		if helpers.ValidateUUID(uuid) && !taken[uuid] {
+			ret[uuid] = uuid
+			continue
+		}
+
+		newUUID, err := helpers.GenUUID()
+		if err != nil {
+			return nil, err
+		}
+
+		ret[uuid] = newUUID
+	}
+
+	return ret, nil
+}
+
+// findContentSize returns the size of the content with the given hash in the
+// storage, and false if the content cannot be attached. Only the content that the
+// user already has an attachment with can be attached, so that an archive cannot
+// refer to the content of another user by its hash.
+func findContentSize(tx *gorm.DB, blobs blob.Store, owned map[string]bool, hash string) (int64, bool, error) {
+	if blobs == nil || !owned[hash] {
+		return 0, false, nil
+	}
+
+	// Lock the content so that it is not deleted by a concurrent collection until
+	// the attachment refers to it
+	if err := operations.LockBlob(tx, hash); err != nil {
+		return 0, false, err
+	}
+
+	size, err := blobs.Size(hash)
+	if err == blob.ErrNotFound {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, errors.Wrap(err, "getting content size")
+	}
+
+	return size, true, nil
+}
+
+// Import replaces the books, notes, repetition rules and digests of the given user
+// with the ones in the archive. The records are given new ids, and new uuids if
+// their uuids are already taken on this instance. Every book and note is given a
+// new usn, and the clients of the user are made to perform a full sync so that
+// their local copies match the imported data.
+//
+// An attachment is imported only if the user already has an attachment with its
+// content in the given store, and is skipped otherwise. Its size is taken from the
+// store. The import fails with operations.ErrQuotaExceeded if the attachments do
+// not fit in the quota. A quota of 0 means no limit.
+func Import(tx *gorm.DB, user database.User, a Archive, blobs blob.Store, quota int64, now time.Time) (Summary, error) {
+	if err := a.Validate(); err != nil {
+		return Summary{}, err
+	}
+
+	// Lock the user so that no item is given a usn during the import
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").Where("id = ?", user.ID).First(&user).Error; err != nil {
+		return Summary{}, errors.Wrap(err, "locking user")
+	}
+
+	var ownedHashes []string
+	if err := tx.Model(&database.Attachment{}).Where("user_id = ?", user.ID).Pluck("DISTINCT hash", &ownedHashes).Error; err != nil {
+		return Summary{}, errors.Wrap(err, "finding attachment hashes")
+	}
+	owned := map[string]bool{}
+	for _, hash := range ownedHashes {
+		owned[hash] = true
+	}
+
+	if err := operations.DeleteUserContent(tx, user); err != nil {
+		return Summary{}, errors.Wrap(err, "deleting the existing data")
+	}
+
+	usage, err := operations.GetAttachmentUsage(tx, user.ID)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	var bookUUIDs, noteUUIDs, attachmentUUIDs, ruleUUIDs, digestUUIDs []string
+	for _, b := range a.Books {
+		bookUUIDs = append(bookUUIDs, b.UUID)
+	}
+	for _, n := range a.Notes {
+		noteUUIDs = append(noteUUIDs, n.UUID)
+
+		for _, at := range n.Attachments {
+			attachmentUUIDs = append(attachmentUUIDs, at.UUID)
+		}
+	}
+	for _, r := range a.RepetitionRules {
+		ruleUUIDs = append(ruleUUIDs, r.UUID)
+	}
+	for _, d := range a.Digests {
+		digestUUIDs = append(digestUUIDs, d.UUID)
+	}
+
+	bookMap, err := remapUUIDs(tx, "books", bookUUIDs)
+	if err != nil {
+		return Summary{}, errors.Wrap(err, "remapping book uuids")
+	}
+	noteMap, err := remapUUIDs(tx, "notes", noteUUIDs)
+	if err != nil {
+		return Summary{}, errors.Wrap(err, "remapping note uuids")
+	}
+	attachmentMap, err := remapUUIDs(tx, "attachments", attachmentUUIDs)
+	if err != nil {
+		return Summary{}, errors.Wrap(err, "remapping attachment uuids")
+	}
+	ruleMap, err := remapUUIDs(tx, "repetition_rules", ruleUUIDs)
+	if err != nil {
+		return Summary{}, errors.Wrap(err, "remapping repetition rule uuids")
+	}
+	digestMap, err := remapUUIDs(tx, "digests", digestUUIDs)
+	if err != nil {
+		return Summary{}, errors.Wrap(err, "remapping digest uuids")
+	}
+
+	summary := Summary{
+		Books:           len(a.Books),
+		Notes:           len(a.Notes),
+		RepetitionRules: len(a.RepetitionRules),
+		Digests:         len(a.Digests),
+	}
+
+	// The new usn are greater than any usn that the clients have seen
+	maxUSN := user.MaxUSN
+
+	books := map[string]database.Book{}
+	for _, b := range a.Books {
+		maxUSN++
+
+		book := database.Book{
+			UUID:       bookMap[b.UUID],
+			UserID:     user.ID,
+			Label:      b.Label,
+			ParentUUID: bookMap[b.ParentUUID],
+			AddedOn:    b.AddedOn,
+			EditedOn:   b.EditedOn,
+			USN:        maxUSN,
+		}
+		if err := tx.Create(&book).Error; err != nil {
+			return Summary{}, errors.Wrapf(err, "inserting book %s", b.UUID)
+		}
+
+		books[b.UUID] = book
+	}
+
+	notes := map[string]database.Note{}
+	for _, n := range a.Notes {
+		maxUSN++
+
+		note := database.Note{
+			UUID:            noteMap[n.UUID],
+			BookUUID:        bookMap[n.BookUUID],
+			UserID:          user.ID,
+			AuthorID:        user.ID,
+			EditorID:        user.ID,
+			Body:            n.Body,
+			AddedOn:         n.AddedOn,
+			EditedOn:        n.EditedOn,
+			Public:          n.Public,
+			PublicExpiresAt: n.PublicExpiresAt,
+			USN:             maxUSN,
+		}
+		if err := tx.Create(&note).Error; err != nil {
+			return Summary{}, errors.Wrapf(err, "inserting note %s", n.UUID)
+		}
+
+		for _, at := range n.Attachments {
+			size, ok, err := findContentSize(tx, blobs, owned, at.Hash)
+			if err != nil {
+				return Summary{}, errors.Wrapf(err, "finding the content of attachment %s", at.UUID)
+			}
+			if !ok {
+				summary.SkippedAttachments++
+				continue
+			}
+
+			if quota > 0 && usage+size > quota {
+				return Summary{}, operations.ErrQuotaExceeded
+			}
+			usage += size
+
+			attachment := database.Attachment{
+				UUID:        attachmentMap[at.UUID],
+				NoteUUID:    note.UUID,
+				UserID:      user.ID,
+				UploaderID:  user.ID,
+				Name:        at.Name,
+				ContentType: at.ContentType,
+				Size:        size,
+				Hash:        at.Hash,
+			}
+			if err := tx.Create(&attachment).Error; err != nil {
+				return Summary{}, errors.Wrapf(err, "inserting attachment %s", at.UUID)
+			}
+
+			summary.Attachments++
+		}
+
+		notes[n.UUID] = note
+	}
+
+	// The links are resolved after all notes are inserted, so that a link can refer
+	// to a note that comes later in the archive
+	for _, n := range a.Notes {
+		if err := operations.UpdateNoteLinks(tx, notes[n.UUID]); err != nil {
+			return Summary{}, errors.Wrapf(err, "updating the links of note %s", n.UUID)
+		}
+	}
+
+	rules := map[string]database.RepetitionRule{}
+	for _, r := range a.RepetitionRules {
+		ruleBooks := []database.Book{}
+		for _, uuid := range r.BookUUIDs {
+			ruleBooks = append(ruleBooks, books[uuid])
+		}
+
+		rule := database.RepetitionRule{
+			UUID:       ruleMap[r.UUID],
+			UserID:     user.ID,
+			Title:      r.Title,
+			Enabled:    r.Enabled,
+			Hour:       r.Hour,
+			Minute:     r.Minute,
+			Frequency:  r.Frequency,
+			LastActive: r.LastActive,
+			NextActive: r.NextActive,
+			BookDomain: r.BookDomain,
+			Books:      ruleBooks,
+			NoteCount:  r.NoteCount,
+		}
+		if err := tx.Create(&rule).Error; err != nil {
+			return Summary{}, errors.Wrapf(err, "inserting repetition rule %s", r.UUID)
+		}
+
+		rules[r.UUID] = rule
+	}
+
+	for _, d := range a.Digests {
+		digestNotes := []database.Note{}
+		for _, uuid := range d.NoteUUIDs {
+			digestNotes = append(digestNotes, notes[uuid])
+		}
+
+		digest := database.Digest{
+			UUID:      digestMap[d.UUID],
+			RuleID:    rules[d.RuleUUID].ID,
+			UserID:    user.ID,
+			Notes:     digestNotes,
+			CreatedAt: d.CreatedAt,
+		}
+		if err := tx.Create(&digest).Error; err != nil {
+			return Summary{}, errors.Wrapf(err, "inserting digest %s", d.UUID)
+		}
+	}
+
+	if err := tx.Model(&user).Updates(map[string]interface{}{
+		"max_usn":          maxUSN,
+		"full_sync_before": now.Unix(),
+	}).Error; err != nil {
+		return Summary{}, errors.Wrap(err, "updating user")
+	}
+
+	return summary, nil
+}
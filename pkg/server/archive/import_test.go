@@ -0,0 +1,495 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package archive
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/server/api/operations"
+	"github.com/dnote/dnote/pkg/server/blob"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+)
+
+// testHash is the hash of the content of the test attachments
+const testHash = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+// newTestBlobStore returns a store that has the content of the test attachments
+func newTestBlobStore(t *testing.T) (blob.Store, func()) {
+	dir, err := ioutil.TempDir("", "dnote-archive")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating temporary directory"))
+	}
+
+	s, err := blob.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating blob store"))
+	}
+	if err := s.Put(testHash, bytes.NewReader([]byte("foo")), 3); err != nil {
+		t.Fatal(errors.Wrap(err, "storing content"))
+	}
+
+	return s, func() { os.RemoveAll(dir) }
+}
+
+type exportTestData struct {
+	User   database.User
+	Book1  database.Book
+	Book2  database.Book
+	Note1  database.Note
+	Note2  database.Note
+	Att1   database.Attachment
+	Rule1  database.RepetitionRule
+	Digest database.Digest
+}
+
+func setupExportTestData(t *testing.T) exportTestData {
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	testutils.MustExec(t, db.Model(&user).Update("max_usn", 10), "preparing user max_usn")
+
+	b1 := database.Book{UserID: user.ID, Label: "js", USN: 1, AddedOn: 100}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	b2 := database.Book{UserID: user.ID, Label: "css", USN: 2, AddedOn: 200}
+	testutils.MustExec(t, db.Save(&b2), "preparing b2")
+	b3 := database.Book{UserID: user.ID, Label: "", USN: 3, Deleted: true}
+	testutils.MustExec(t, db.Save(&b3), "preparing b3")
+
+	n1 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "n1 content", USN: 4, AddedOn: 300, Public: true}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+	n2 := database.Note{UserID: user.ID, BookUUID: b2.UUID, Body: "n2 content", USN: 7, AddedOn: 400, EditedOn: 500}
+	testutils.MustExec(t, db.Save(&n2), "preparing n2")
+	n3 := database.Note{UserID: user.ID, BookUUID: b1.UUID, Body: "", USN: 10, Deleted: true}
+	testutils.MustExec(t, db.Save(&n3), "preparing n3")
+
+	a1 := database.Attachment{NoteUUID: n1.UUID, UserID: user.ID, Name: "closure.png", ContentType: "image/png", Size: 42, Hash: testHash}
+	testutils.MustExec(t, db.Save(&a1), "preparing a1")
+
+	r1 := database.RepetitionRule{
+		UserID:     user.ID,
+		Title:      "r1",
+		Enabled:    true,
+		Hour:       8,
+		Minute:     30,
+		Frequency:  604800000,
+		NextActive: 1000,
+		BookDomain: database.BookDomainIncluding,
+		Books:      []database.Book{b1},
+		NoteCount:  5,
+	}
+	testutils.MustExec(t, db.Save(&r1), "preparing r1")
+
+	d1 := database.Digest{
+		RuleID:    r1.ID,
+		UserID:    user.ID,
+		Notes:     []database.Note{n1, n3},
+		CreatedAt: time.Date(2019, time.October, 1, 0, 0, 0, 0, time.UTC),
+	}
+	testutils.MustExec(t, db.Save(&d1), "preparing d1")
+
+	return exportTestData{
+		User:   user,
+		Book1:  b1,
+		Book2:  b2,
+		Note1:  n1,
+		Note2:  n2,
+		Att1:   a1,
+		Rule1:  r1,
+		Digest: d1,
+	}
+}
+
+func TestExport(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	d := setupExportTestData(t)
+	now := time.Date(2019, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+	a, err := Export(db, d.User, now)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "exporting"))
+	}
+
+	assert.Equal(t, a.Version, Version, "Version mismatch")
+	assert.Equal(t, a.ExportedAt, now, "ExportedAt mismatch")
+	assert.DeepEqual(t, a.Books, []Book{
+		{UUID: d.Book1.UUID, Label: "js", AddedOn: 100},
+		{UUID: d.Book2.UUID, Label: "css", AddedOn: 200},
+	}, "Books mismatch")
+	assert.DeepEqual(t, a.Notes, []Note{
+		{
+			UUID:     d.Note1.UUID,
+			BookUUID: d.Book1.UUID,
+			Body:     "n1 content",
+			AddedOn:  300,
+			Public:   true,
+			Attachments: []Attachment{
+				{UUID: d.Att1.UUID, Name: "closure.png", ContentType: "image/png", Size: 42, Hash: testHash},
+			},
+		},
+		{UUID: d.Note2.UUID, BookUUID: d.Book2.UUID, Body: "n2 content", AddedOn: 400, EditedOn: 500},
+	}, "Notes mismatch")
+	assert.DeepEqual(t, a.RepetitionRules, []RepetitionRule{
+		{
+			UUID:       d.Rule1.UUID,
+			Title:      "r1",
+			Enabled:    true,
+			Hour:       8,
+			Minute:     30,
+			Frequency:  604800000,
+			NextActive: 1000,
+			BookDomain: database.BookDomainIncluding,
+			BookUUIDs:  []string{d.Book1.UUID},
+			NoteCount:  5,
+		},
+	}, "RepetitionRules mismatch")
+
+	assert.Equal(t, len(a.Digests), 1, "Digests length mismatch")
+	assert.Equal(t, a.Digests[0].UUID, d.Digest.UUID, "Digest UUID mismatch")
+	assert.Equal(t, a.Digests[0].RuleUUID, d.Rule1.UUID, "Digest RuleUUID mismatch")
+	assert.DeepEqual(t, a.Digests[0].NoteUUIDs, []string{d.Note1.UUID}, "Digest NoteUUIDs mismatch")
+}
+
+func importArchive(t *testing.T, user database.User, a Archive, blobs blob.Store, now time.Time) Summary {
+	db := database.DBConn
+
+	tx := db.Begin()
+	summary, err := Import(tx, user, a, blobs, 0, now)
+	if err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "importing"))
+	}
+	tx.Commit()
+
+	return summary
+}
+
+func TestImport_SameUser(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	blobs, cleanup := newTestBlobStore(t)
+	defer cleanup()
+
+	d := setupExportTestData(t)
+	now := time.Date(2019, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+	a, err := Export(db, d.User, now)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "exporting"))
+	}
+
+	// Changes after the export are discarded by the import
+	n4 := database.Note{UserID: d.User.ID, BookUUID: d.Book1.UUID, Body: "n4 content", USN: 11}
+	testutils.MustExec(t, db.Save(&n4), "preparing n4")
+	testutils.MustExec(t, db.Model(&d.User).Update("max_usn", 11), "preparing user max_usn")
+
+	summary := importArchive(t, d.User, a, blobs, now)
+	assert.Equal(t, summary, Summary{Books: 2, Notes: 2, RepetitionRules: 1, Digests: 1, Attachments: 1}, "summary mismatch")
+
+	var user database.User
+	testutils.MustExec(t, db.Where("id = ?", d.User.ID).First(&user), "finding user")
+	assert.Equal(t, user.MaxUSN, 15, "MaxUSN mismatch")
+	assert.Equal(t, user.FullSyncBefore, now.Unix(), "FullSyncBefore mismatch")
+
+	var books []database.Book
+	testutils.MustExec(t, db.Where("user_id = ?", user.ID).Order("usn ASC").Find(&books), "finding books")
+	assert.Equal(t, len(books), 2, "book count mismatch")
+	assert.Equal(t, books[0].UUID, d.Book1.UUID, "books[0] UUID mismatch")
+	assert.Equal(t, books[0].Label, "js", "books[0] Label mismatch")
+	assert.Equal(t, books[0].USN, 12, "books[0] USN mismatch")
+	assert.Equal(t, books[1].UUID, d.Book2.UUID, "books[1] UUID mismatch")
+	assert.Equal(t, books[1].USN, 13, "books[1] USN mismatch")
+
+	var notes []database.Note
+	testutils.MustExec(t, db.Where("user_id = ?", user.ID).Order("usn ASC").Find(&notes), "finding notes")
+	assert.Equal(t, len(notes), 2, "note count mismatch")
+	assert.Equal(t, notes[0].UUID, d.Note1.UUID, "notes[0] UUID mismatch")
+	assert.Equal(t, notes[0].Body, "n1 content", "notes[0] Body mismatch")
+	assert.Equal(t, notes[0].USN, 14, "notes[0] USN mismatch")
+	assert.Equal(t, notes[1].UUID, d.Note2.UUID, "notes[1] UUID mismatch")
+	assert.Equal(t, notes[1].USN, 15, "notes[1] USN mismatch")
+
+	var attachments []database.Attachment
+	testutils.MustExec(t, db.Where("user_id = ?", user.ID).Find(&attachments), "finding attachments")
+	assert.Equal(t, len(attachments), 1, "attachment count mismatch")
+	assert.Equal(t, attachments[0].UUID, d.Att1.UUID, "attachment UUID mismatch")
+	assert.Equal(t, attachments[0].NoteUUID, d.Note1.UUID, "attachment NoteUUID mismatch")
+	assert.Equal(t, attachments[0].Hash, testHash, "attachment Hash mismatch")
+	// the size is taken from the storage
+	assert.Equal(t, attachments[0].Size, int64(3), "attachment Size mismatch")
+
+	var rule database.RepetitionRule
+	testutils.MustExec(t, db.Preload("Books").Where("user_id = ?", user.ID).First(&rule), "finding rule")
+	assert.Equal(t, rule.UUID, d.Rule1.UUID, "rule UUID mismatch")
+	assert.NotEqual(t, rule.ID, d.Rule1.ID, "rule ID was not remapped")
+	assert.Equal(t, len(rule.Books), 1, "rule book count mismatch")
+	assert.Equal(t, rule.Books[0].UUID, d.Book1.UUID, "rule book mismatch")
+
+	var digest database.Digest
+	testutils.MustExec(t, db.Preload("Notes").Where("user_id = ?", user.ID).First(&digest), "finding digest")
+	assert.Equal(t, digest.RuleID, rule.ID, "digest RuleID mismatch")
+	assert.Equal(t, len(digest.Notes), 1, "digest note count mismatch")
+	assert.Equal(t, digest.Notes[0].UUID, d.Note1.UUID, "digest note mismatch")
+}
+
+func TestImport_AnotherUser(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	d := setupExportTestData(t)
+	now := time.Date(2019, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+	a, err := Export(db, d.User, now)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "exporting"))
+	}
+
+	blobs, cleanup := newTestBlobStore(t)
+	defer cleanup()
+
+	// The attachment is skipped because its content belongs to the original user
+	anotherUser := testutils.SetupUserData()
+	summary := importArchive(t, anotherUser, a, blobs, now)
+	assert.Equal(t, summary, Summary{Books: 2, Notes: 2, RepetitionRules: 1, Digests: 1, SkippedAttachments: 1}, "summary mismatch")
+
+	var user database.User
+	testutils.MustExec(t, db.Where("id = ?", anotherUser.ID).First(&user), "finding user")
+	assert.Equal(t, user.MaxUSN, 4, "MaxUSN mismatch")
+
+	// The uuids taken by the original user are remapped
+	var books []database.Book
+	testutils.MustExec(t, db.Where("user_id = ?", user.ID).Order("usn ASC").Find(&books), "finding books")
+	assert.Equal(t, len(books), 2, "book count mismatch")
+	assert.NotEqual(t, books[0].UUID, d.Book1.UUID, "books[0] UUID was not remapped")
+	assert.Equal(t, books[0].Label, "js", "books[0] Label mismatch")
+
+	var notes []database.Note
+	testutils.MustExec(t, db.Where("user_id = ?", user.ID).Order("usn ASC").Find(&notes), "finding notes")
+	assert.Equal(t, len(notes), 2, "note count mismatch")
+	assert.NotEqual(t, notes[0].UUID, d.Note1.UUID, "notes[0] UUID was not remapped")
+	assert.Equal(t, notes[0].BookUUID, books[0].UUID, "notes[0] BookUUID mismatch")
+	assert.Equal(t, notes[1].BookUUID, books[1].UUID, "notes[1] BookUUID mismatch")
+
+	var digest database.Digest
+	testutils.MustExec(t, db.Preload("Notes").Where("user_id = ?", user.ID).First(&digest), "finding digest")
+	assert.NotEqual(t, digest.UUID, d.Digest.UUID, "digest UUID was not remapped")
+	assert.Equal(t, digest.Notes[0].UUID, notes[0].UUID, "digest note mismatch")
+
+	var attachmentCount int
+	testutils.MustExec(t, db.Model(&database.Attachment{}).Where("user_id = ?", user.ID).Count(&attachmentCount), "counting attachments")
+	assert.Equal(t, attachmentCount, 0, "attachment count mismatch")
+
+	// The data of the original user is intact
+	var originalNoteCount int
+	testutils.MustExec(t, db.Model(&database.Note{}).Where("user_id = ?", d.User.ID).Count(&originalNoteCount), "counting original notes")
+	assert.Equal(t, originalNoteCount, 3, "original note count mismatch")
+}
+
+func TestImport_Links(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	a := Archive{
+		Version: Version,
+		Books:   []Book{{UUID: "b1", Label: "js"}},
+		Notes: []Note{
+			{UUID: "n1", BookUUID: "b1", Body: "see [[js/closure]]"},
+			{UUID: "n2", BookUUID: "b1", Body: "# closure\nn2 content"},
+		},
+	}
+
+	importArchive(t, user, a, nil, time.Date(2019, time.November, 1, 0, 0, 0, 0, time.UTC))
+
+	var notes []database.Note
+	testutils.MustExec(t, db.Where("user_id = ?", user.ID).Order("usn ASC").Find(&notes), "finding notes")
+	assert.Equal(t, len(notes), 2, "note count mismatch")
+
+	// a link is resolved even if its target comes later in the archive
+	var links []database.NoteLink
+	testutils.MustExec(t, db.Find(&links), "finding links")
+	assert.Equal(t, len(links), 1, "link count mismatch")
+	assert.Equal(t, links[0].SourceUUID, notes[0].UUID, "SourceUUID mismatch")
+	assert.Equal(t, links[0].TargetUUID, notes[1].UUID, "TargetUUID mismatch")
+	assert.Equal(t, links[0].Text, "js/closure", "Text mismatch")
+}
+
+func TestImport_Invalid(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	d := setupExportTestData(t)
+
+	a := Archive{
+		Version: Version,
+		Notes:   []Note{{UUID: "n1", BookUUID: "b1"}},
+	}
+
+	tx := db.Begin()
+	_, err := Import(tx, d.User, a, nil, 0, time.Now())
+	tx.Rollback()
+
+	if _, ok := err.(ValidationError); !ok {
+		t.Fatalf("expected a validation error but got %v", err)
+	}
+
+	var noteCount int
+	testutils.MustExec(t, db.Model(&database.Note{}).Where("user_id = ?", d.User.ID).Count(&noteCount), "counting notes")
+	assert.Equal(t, noteCount, 3, "note count mismatch")
+}
+
+func TestImport_Attachments(t *testing.T) {
+	blobs, cleanup := newTestBlobStore(t)
+	defer cleanup()
+
+	// otherHash is the hash of the content of another user
+	otherHash := blob.Hash([]byte("bar"))
+
+	testCases := []struct {
+		name               string
+		hash               string
+		size               int64
+		blobs              blob.Store
+		quota              int64
+		expectedErr        error
+		expectedSize       int64
+		expectedSkipped    int
+		expectedAttachment bool
+	}{
+		{
+			name:               "size from storage",
+			hash:               testHash,
+			size:               1,
+			blobs:              blobs,
+			expectedSize:       3,
+			expectedAttachment: true,
+		},
+		{
+			name:               "within quota",
+			hash:               testHash,
+			size:               1,
+			blobs:              blobs,
+			quota:              3,
+			expectedSize:       3,
+			expectedAttachment: true,
+		},
+		{
+			name:        "over quota",
+			hash:        testHash,
+			size:        1,
+			blobs:       blobs,
+			quota:       2,
+			expectedErr: operations.ErrQuotaExceeded,
+		},
+		{
+			name:            "content of another user",
+			hash:            otherHash,
+			size:            3,
+			blobs:           blobs,
+			expectedSkipped: 1,
+		},
+		{
+			name:            "attachments disabled",
+			hash:            testHash,
+			size:            3,
+			blobs:           nil,
+			expectedSkipped: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Setup
+			user := testutils.SetupUserData()
+			b1 := database.Book{UserID: user.ID, Label: "js"}
+			testutils.MustExec(t, db.Save(&b1), "preparing b1")
+			n1 := database.Note{UserID: user.ID, BookUUID: b1.UUID}
+			testutils.MustExec(t, db.Save(&n1), "preparing n1")
+			a1 := database.Attachment{NoteUUID: n1.UUID, UserID: user.ID, Name: "a1.txt", ContentType: "text/plain", Size: 3, Hash: testHash}
+			testutils.MustExec(t, db.Save(&a1), "preparing a1")
+
+			// Another user has an attachment with the other content
+			anotherUser := testutils.SetupUserData()
+			b2 := database.Book{UserID: anotherUser.ID, Label: "css"}
+			testutils.MustExec(t, db.Save(&b2), "preparing b2")
+			n2 := database.Note{UserID: anotherUser.ID, BookUUID: b2.UUID}
+			testutils.MustExec(t, db.Save(&n2), "preparing n2")
+			a2 := database.Attachment{NoteUUID: n2.UUID, UserID: anotherUser.ID, Name: "a2.txt", ContentType: "text/plain", Size: 3, Hash: otherHash}
+			testutils.MustExec(t, db.Save(&a2), "preparing a2")
+			if err := blobs.Put(otherHash, bytes.NewReader([]byte("bar")), 3); err != nil {
+				t.Fatal(errors.Wrap(err, "storing content"))
+			}
+
+			a := Archive{
+				Version: Version,
+				Books:   []Book{{UUID: "b1", Label: "js"}},
+				Notes: []Note{
+					{
+						UUID:     "n1",
+						BookUUID: "b1",
+						Attachments: []Attachment{
+							{UUID: "a1", Name: "a1.txt", ContentType: "text/plain", Size: tc.size, Hash: tc.hash},
+						},
+					},
+				},
+			}
+
+			// Execute
+			tx := db.Begin()
+			summary, err := Import(tx, user, a, tc.blobs, tc.quota, time.Now())
+			if err != nil {
+				tx.Rollback()
+			} else {
+				tx.Commit()
+			}
+
+			// Test
+			assert.Equal(t, errors.Cause(err), tc.expectedErr, "error mismatch")
+			if tc.expectedErr != nil {
+				return
+			}
+
+			assert.Equal(t, summary.SkippedAttachments, tc.expectedSkipped, "SkippedAttachments mismatch")
+
+			var attachments []database.Attachment
+			testutils.MustExec(t, db.Where("user_id = ?", user.ID).Find(&attachments), "finding attachments")
+			if !tc.expectedAttachment {
+				assert.Equal(t, len(attachments), 0, "attachment count mismatch")
+				return
+			}
+
+			assert.Equal(t, summary.Attachments, 1, "Attachments mismatch")
+			assert.Equal(t, len(attachments), 1, "attachment count mismatch")
+			assert.Equal(t, attachments[0].Hash, tc.hash, "Hash mismatch")
+			assert.Equal(t, attachments[0].Size, tc.expectedSize, "Size mismatch")
+		})
+	}
+}
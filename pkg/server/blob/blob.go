@@ -0,0 +1,68 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package blob stores the content of attachments. Content is addressed by its
+// SHA-256 hash, so identical files are stored once.
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned if no content is stored under a key
+var ErrNotFound = errors.New("blob not found")
+
+// ErrInvalidKey is returned if a key is not a SHA-256 hash in hex
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// ErrHashMismatch is returned if content does not match the key under which it
+// is stored
+var ErrHashMismatch = errors.New("content does not match the key")
+
+// Store stores content under the SHA-256 hash of the content in hex
+type Store interface {
+	// Put stores the content of the given size under the given key. The key must
+	// be the hash of the content.
+	Put(key string, r io.Reader, size int64) error
+	// Get returns the content stored under the given key, or ErrNotFound
+	Get(key string) (io.ReadCloser, error)
+	// Size returns the size in bytes of the content stored under the given key,
+	// or ErrNotFound
+	Size(key string) (int64, error)
+	// Delete deletes the content stored under the given key, if any
+	Delete(key string) error
+}
+
+var keyRegexp = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// ValidateKey checks if the given string is a valid key
+func ValidateKey(key string) bool {
+	return keyRegexp.MatchString(key)
+}
+
+// Hash returns the key of the given content
+func Hash(b []byte) string {
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:])
+}
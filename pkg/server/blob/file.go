@@ -0,0 +1,143 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// FileStore stores content in a directory on the filesystem
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a store that keeps content in the given directory. The
+// directory is created if it does not exist.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, errors.Wrapf(err, "creating directory %s", dir)
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+// path returns the path of the file for the given key. Files are spread across
+// subdirectories by the first two characters of the key.
+func (s *FileStore) path(key string) string {
+	return filepath.Join(s.dir, key[:2], key)
+}
+
+// Put stores the content under the given key
+func (s *FileStore) Put(key string, r io.Reader, size int64) error {
+	if !ValidateKey(key) {
+		return ErrInvalidKey
+	}
+
+	p := s.path(key)
+	if _, err := os.Stat(p); err == nil {
+		// the same content is already stored
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+		return errors.Wrap(err, "creating directory")
+	}
+
+	// write to a temporary file first so that a partially written file is never
+	// served under the key
+	f, err := ioutil.TempFile(filepath.Dir(p), "tmp-")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary file")
+	}
+	defer os.Remove(f.Name())
+
+	h := sha256.New()
+	n, err := io.Copy(f, io.TeeReader(r, h))
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "writing file")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "closing file")
+	}
+	if n != size {
+		return errors.Errorf("expected %d bytes but got %d", size, n)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != key {
+		return ErrHashMismatch
+	}
+
+	if err := os.Rename(f.Name(), p); err != nil {
+		return errors.Wrap(err, "renaming file")
+	}
+
+	return nil
+}
+
+// Get returns the content stored under the given key
+func (s *FileStore) Get(key string) (io.ReadCloser, error) {
+	if !ValidateKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	f, err := os.Open(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, errors.Wrap(err, "opening file")
+	}
+
+	return f, nil
+}
+
+// Size returns the size of the content stored under the given key
+func (s *FileStore) Size(key string) (int64, error) {
+	if !ValidateKey(key) {
+		return 0, ErrInvalidKey
+	}
+
+	fi, err := os.Stat(s.path(key))
+	if os.IsNotExist(err) {
+		return 0, ErrNotFound
+	} else if err != nil {
+		return 0, errors.Wrap(err, "getting file info")
+	}
+
+	return fi.Size(), nil
+}
+
+// Delete deletes the content stored under the given key
+func (s *FileStore) Delete(key string) error {
+	if !ValidateKey(key) {
+		return ErrInvalidKey
+	}
+
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "removing file")
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package blob
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+// testStore tests the behavior common to all stores
+func testStore(t *testing.T, s Store) {
+	content := []byte("hello world")
+	key := Hash(content)
+
+	t.Run("get missing", func(t *testing.T) {
+		_, err := s.Get(key)
+		assert.Equal(t, err, ErrNotFound, "Get error mismatch")
+
+		_, err = s.Size(key)
+		assert.Equal(t, err, ErrNotFound, "Size error mismatch")
+	})
+
+	t.Run("put and get", func(t *testing.T) {
+		if err := s.Put(key, bytes.NewReader(content), int64(len(content))); err != nil {
+			t.Fatal(errors.Wrap(err, "putting"))
+		}
+		// putting the same content again is a no-op
+		if err := s.Put(key, bytes.NewReader(content), int64(len(content))); err != nil {
+			t.Fatal(errors.Wrap(err, "putting again"))
+		}
+
+		rc, err := s.Get(key)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting"))
+		}
+		defer rc.Close()
+
+		b, err := ioutil.ReadAll(rc)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "reading"))
+		}
+		assert.Equal(t, string(b), "hello world", "content mismatch")
+
+		size, err := s.Size(key)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "getting size"))
+		}
+		assert.Equal(t, size, int64(len(content)), "size mismatch")
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if err := s.Delete(key); err != nil {
+			t.Fatal(errors.Wrap(err, "deleting"))
+		}
+		// deleting missing content is a no-op
+		if err := s.Delete(key); err != nil {
+			t.Fatal(errors.Wrap(err, "deleting again"))
+		}
+
+		_, err := s.Get(key)
+		assert.Equal(t, err, ErrNotFound, "error mismatch")
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		_, err := s.Get("../etc/passwd")
+		assert.Equal(t, err, ErrInvalidKey, "Get error mismatch")
+
+		err = s.Put("ABC", bytes.NewReader(content), int64(len(content)))
+		assert.Equal(t, err, ErrInvalidKey, "Put error mismatch")
+
+		_, err = s.Size("../etc/passwd")
+		assert.Equal(t, err, ErrInvalidKey, "Size error mismatch")
+	})
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dnote-blob")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating temporary directory"))
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewFileStore(filepath.Join(dir, "attachments"))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating store"))
+	}
+
+	testStore(t, s)
+
+	t.Run("hash mismatch", func(t *testing.T) {
+		key := Hash([]byte("foo"))
+		err := s.Put(key, bytes.NewReader([]byte("bar")), 3)
+		assert.Equal(t, err, ErrHashMismatch, "error mismatch")
+
+		_, err = s.Get(key)
+		assert.Equal(t, err, ErrNotFound, "content should not have been stored")
+	})
+}
+
+func TestValidateKey(t *testing.T) {
+	testCases := []struct {
+		key      string
+		expected bool
+	}{
+		{Hash([]byte("foo")), true},
+		{"2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", true},
+		{"2C26B46B68FFC68FF99B453C1D30413413422D706483BFA0F98A5E886266E7AE", false},
+		{"2c26b46b68ffc68f", false},
+		{"", false},
+		{"../../../../../../../../../../../../../../../../../../../../etc/passwd", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.key, func(t *testing.T) {
+			assert.Equal(t, ValidateKey(tc.key), tc.expected, "result mismatch")
+		})
+	}
+}
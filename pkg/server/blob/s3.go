@@ -0,0 +1,261 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package blob
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/pkg/errors"
+)
+
+// emptyPayloadHash is the SHA-256 hash of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Config is the configuration of an S3-compatible object storage
+type S3Config struct {
+	// Endpoint is the base URL of the storage, such as https://s3.amazonaws.com,
+	// or http://localhost:9000 for a local MinIO server
+	Endpoint string
+	// Region defaults to us-east-1
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// S3Store stores content as objects in a bucket of an S3-compatible object storage.
+// Requests are signed with AWS Signature Version 4, and objects are addressed in
+// the path style so that storages without virtual-hosted buckets are supported.
+type S3Store struct {
+	config     S3Config
+	endpoint   *url.URL
+	httpClient *http.Client
+	clock      clock.Clock
+}
+
+// NewS3Store returns a store backed by the given S3-compatible object storage
+func NewS3Store(c S3Config) (*S3Store, error) {
+	u, err := url.Parse(strings.TrimRight(c.Endpoint, "/"))
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing endpoint")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("endpoint must use http or https, got '%s'", c.Endpoint)
+	}
+	if c.Bucket == "" {
+		return nil, errors.New("bucket is required")
+	}
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
+
+	return &S3Store{
+		config:     c,
+		endpoint:   u,
+		httpClient: &http.Client{},
+		clock:      clock.New(),
+	}, nil
+}
+
+func (s *S3Store) objectURL(key string) string {
+	return fmt.Sprintf("%s/%s/%s", s.endpoint.String(), s.config.Bucket, key)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// signature returns the signature of the given string for the credential scope
+// of the given date and region
+func signature(secretAccessKey, date, region, stringToSign string) string {
+	k := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+
+	return hex.EncodeToString(hmacSHA256(k, stringToSign))
+}
+
+// canonicalRequest returns the canonical form of the request that is signed. Only
+// the host and the x-amz-* headers are signed.
+func canonicalRequest(req *http.Request, payloadHash string) string {
+	headers := fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n",
+		req.URL.Host, payloadHash, req.Header.Get("x-amz-date"))
+
+	return strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		headers,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+}
+
+const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+
+// sign adds the headers of AWS Signature Version 4 to the request
+func (s *S3Store) sign(req *http.Request, payloadHash string) {
+	now := s.clock.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.config.Region)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex(canonicalRequest(req, payloadHash)),
+	}, "\n")
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKeyID, scope, signedHeaders, signature(s.config.SecretAccessKey, date, s.config.Region, stringToSign)))
+}
+
+func (s *S3Store) do(method, key string, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.objectURL(key), body)
+	if err != nil {
+		return nil, errors.Wrap(err, "constructing request")
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	s.sign(req, payloadHash)
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "making request")
+	}
+
+	return res, nil
+}
+
+// responseError returns an error with the status and the body of the response
+func responseError(res *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+
+	return errors.Errorf("storage responded with %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// Put stores the content under the given key. The key is signed as the hash of
+// the payload, so the storage rejects content that does not match the key.
+func (s *S3Store) Put(key string, r io.Reader, size int64) error {
+	if !ValidateKey(key) {
+		return ErrInvalidKey
+	}
+
+	res, err := s.do("PUT", key, r, size, key)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return responseError(res)
+	}
+
+	return nil
+}
+
+// Get returns the content stored under the given key
+func (s *S3Store) Get(key string) (io.ReadCloser, error) {
+	if !ValidateKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	res, err := s.do("GET", key, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, ErrNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		return nil, responseError(res)
+	}
+
+	return res.Body, nil
+}
+
+// Size returns the size of the content stored under the given key
+func (s *S3Store) Size(key string) (int64, error) {
+	if !ValidateKey(key) {
+		return 0, ErrInvalidKey
+	}
+
+	res, err := s.do("HEAD", key, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return 0, ErrNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		return 0, responseError(res)
+	}
+
+	return res.ContentLength, nil
+}
+
+// Delete deletes the content stored under the given key
+func (s *S3Store) Delete(key string) error {
+	if !ValidateKey(key) {
+		return ErrInvalidKey
+	}
+
+	res, err := s.do("DELETE", key, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return responseError(res)
+	}
+}
@@ -0,0 +1,558 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/admin"
+	"github.com/dnote/dnote/pkg/server/api/operations"
+	"github.com/dnote/dnote/pkg/server/archive"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/entitlement"
+	"github.com/dnote/dnote/pkg/server/job/repetition"
+	"github.com/dnote/dnote/pkg/server/mailer"
+	"github.com/pkg/errors"
+)
+
+// exit prints the message and exits the program with a non-zero status
+func exit(format string, a ...interface{}) {
+	fmt.Printf(format+"\n", a...)
+	os.Exit(1)
+}
+
+// findUser finds the user with the email or the uuid given as the first argument
+// of the command, and exits the program if the user is not found
+func findUser(fs *flag.FlagSet) database.User {
+	if fs.NArg() == 0 {
+		exit("Please provide the email or the uuid of the user")
+	}
+
+	user, err := admin.FindUser(database.DBConn, fs.Arg(0))
+	if err == admin.ErrUserNotFound {
+		exit("No user was found with %s", fs.Arg(0))
+	} else if err != nil {
+		panic(errors.Wrap(err, "finding user"))
+	}
+
+	return user
+}
+
+func migrateCmd(args []string) {
+	if len(args) == 0 {
+		fmt.Printf(`Usage:
+  dnote-server migrate [command]
+
+Available commands:
+  up: Apply all pending migrations
+  down: Roll back the most recent migrations
+  status: Print the state of the migrations
+`)
+		return
+	}
+
+	switch args[0] {
+	case "up":
+		initDB(loadConfig())
+		defer database.Close()
+	case "down":
+		fs := flag.NewFlagSet("migrate down", flag.ExitOnError)
+		n := fs.Int("n", 1, "number of migrations to roll back")
+		fs.Parse(args[1:])
+
+		if *n < 1 {
+			exit("Please provide -n greater than 0")
+		}
+
+		openDB(loadConfig())
+		defer database.Close()
+
+		count, err := database.MigrateDown(*n)
+		if err != nil {
+			panic(errors.Wrap(err, "rolling back migrations"))
+		}
+
+		fmt.Printf("Rolled back %d migrations\n", count)
+	case "status":
+		openDB(loadConfig())
+		defer database.Close()
+
+		statuses, err := database.GetMigrationStatus()
+		if err != nil {
+			panic(errors.Wrap(err, "getting migration status"))
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "MIGRATION\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+
+			fmt.Fprintf(w, "%s\t%s\n", s.ID, appliedAt)
+		}
+		w.Flush()
+	default:
+		exit("Unknown command migrate %s", args[0])
+	}
+}
+
+func userCreateCmd(args []string) {
+	fs := flag.NewFlagSet("user create", flag.ExitOnError)
+	email := fs.String("email", "", "email of the user")
+	password := fs.String("password", "", "password of the user")
+	isAdmin := fs.Bool("admin", false, "make the user an administrator of the organization")
+	orgName := fs.String("org", "", "name of an existing organization to add the user to")
+	fs.Parse(args)
+
+	if *email == "" || len(*password) < 8 {
+		exit("Please provide -email and a -password longer than 8 characters")
+	}
+
+	initDB(loadConfig())
+	defer database.Close()
+
+	db := database.DBConn
+
+	if _, err := admin.FindUser(db, *email); err == nil {
+		exit("A user with the email %s already exists", *email)
+	} else if err != admin.ErrUserNotFound {
+		panic(errors.Wrap(err, "checking duplicate user"))
+	}
+
+	var orgID int
+	if *orgName != "" {
+		var org database.Organization
+		conn := db.Where("name = ?", *orgName).First(&org)
+		if conn.RecordNotFound() {
+			exit("No organization was found with the name %s", *orgName)
+		} else if err := conn.Error; err != nil {
+			panic(errors.Wrap(err, "finding organization"))
+		}
+
+		orgID = org.ID
+	} else if *isAdmin {
+		exit("Please provide the -org of which the user is an administrator")
+	}
+
+	user, err := operations.CreateUser(*email, *password, operations.CreateUserParams{
+		OrganizationID: orgID,
+		Admin:          *isAdmin,
+	})
+	if err != nil {
+		panic(errors.Wrap(err, "creating user"))
+	}
+
+	fmt.Printf("Created the user %s (%s)\n", *email, user.UUID)
+}
+
+func userListCmd(args []string) {
+	fs := flag.NewFlagSet("user list", flag.ExitOnError)
+	fs.Parse(args)
+
+	initDB(loadConfig())
+	defer database.Close()
+
+	users, err := admin.ListUsers(database.DBConn)
+	if err != nil {
+		panic(errors.Wrap(err, "listing users"))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "UUID\tEMAIL\tADMIN\tDISABLED\tMAX USN\tCREATED AT")
+	for _, u := range users {
+		fmt.Fprintf(w, "%s\t%s\t%t\t%t\t%d\t%s\n", u.UUID, u.Email, u.Admin, u.Disabled, u.MaxUSN, u.CreatedAt.Format(time.RFC3339))
+	}
+	w.Flush()
+}
+
+func userDisableCmd(args []string) {
+	fs := flag.NewFlagSet("user disable", flag.ExitOnError)
+	fs.Parse(args)
+
+	initDB(loadConfig())
+	defer database.Close()
+
+	user := findUser(fs)
+
+	tx := database.DBConn.Begin()
+	if err := operations.DisableUser(tx, user, time.Now()); err != nil {
+		tx.Rollback()
+		panic(errors.Wrap(err, "disabling user"))
+	}
+	tx.Commit()
+
+	fmt.Printf("Disabled the user %s and signed the user out of all sessions\n", fs.Arg(0))
+}
+
+func userCreateTokenCmd(args []string) {
+	fs := flag.NewFlagSet("user create-token", flag.ExitOnError)
+	deviceName := fs.String("device", "", "name of the device that will use the token")
+	fs.Parse(args)
+
+	initDB(loadConfig())
+	defer database.Close()
+
+	user := findUser(fs)
+
+	info := operations.SessionInfo{
+		DeviceName: *deviceName,
+		ClientType: "token",
+	}
+	session, err := operations.CreateSession(database.DBConn, user.ID, info, time.Now())
+	if err != nil {
+		panic(errors.Wrap(err, "creating session"))
+	}
+
+	// print only the token to the standard output so that it can be piped to the CLI
+	fmt.Println(session.Key)
+	fmt.Fprintf(os.Stderr, "The token expires at %s\n", session.ExpiresAt.Format(time.RFC3339))
+}
+
+func userResetTOTPCmd(args []string) {
+	fs := flag.NewFlagSet("user reset-totp", flag.ExitOnError)
+	fs.Parse(args)
+
+	initDB(loadConfig())
+	defer database.Close()
+
+	user := findUser(fs)
+
+	var account database.Account
+	if err := database.DBConn.Where("user_id = ?", user.ID).First(&account).Error; err != nil {
+		panic(errors.Wrap(err, "finding account"))
+	}
+
+	tx := database.DBConn.Begin()
+	if err := operations.DisableTOTP(tx, &account); err != nil {
+		tx.Rollback()
+		panic(errors.Wrap(err, "disabling two-factor authentication"))
+	}
+	tx.Commit()
+
+	fmt.Printf("Disabled the two-factor authentication of the user %s\n", fs.Arg(0))
+}
+
+func userResetPasswordCmd(args []string) {
+	fs := flag.NewFlagSet("user reset-password", flag.ExitOnError)
+	password := fs.String("password", "", "new password. If empty, it is read from the standard input")
+	fs.Parse(args)
+
+	if *password == "" {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			exit("Please provide the new password in the standard input or -password")
+		}
+
+		*password = strings.TrimRight(line, "\r\n")
+	}
+	if len(*password) < 8 {
+		exit("Password should be longer than 8 characters")
+	}
+
+	initDB(loadConfig())
+	defer database.Close()
+
+	user := findUser(fs)
+
+	tx := database.DBConn.Begin()
+	if err := admin.ResetPassword(tx, user, *password); err != nil {
+		tx.Rollback()
+		panic(errors.Wrap(err, "resetting password"))
+	}
+	tx.Commit()
+
+	fmt.Printf("Reset the password of the user %s and signed the user out of all sessions\n", fs.Arg(0))
+}
+
+func userExportCmd(args []string) {
+	fs := flag.NewFlagSet("user export", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() < 2 {
+		exit("Please provide the email or the uuid of the user, and the path to the archive")
+	}
+
+	initDB(loadConfig())
+	defer database.Close()
+
+	user := findUser(fs)
+
+	a, err := archive.Export(database.DBConn, user, time.Now())
+	if err != nil {
+		panic(errors.Wrap(err, "exporting archive"))
+	}
+
+	f, err := os.Create(fs.Arg(1))
+	if err != nil {
+		panic(errors.Wrap(err, "creating the archive file"))
+	}
+	defer f.Close()
+
+	if err := archive.Encode(f, a); err != nil {
+		panic(errors.Wrap(err, "writing archive"))
+	}
+
+	fmt.Printf("Exported %d books, %d notes, %d repetition rules and %d digests to %s\n", len(a.Books), len(a.Notes), len(a.RepetitionRules), len(a.Digests), fs.Arg(1))
+}
+
+func userImportCmd(args []string) {
+	fs := flag.NewFlagSet("user import", flag.ExitOnError)
+	yes := fs.Bool("yes", false, "confirm that the existing data of the user is replaced")
+	fs.Parse(args)
+
+	if fs.NArg() < 2 {
+		exit("Please provide the email or the uuid of the user, and the path to the archive")
+	}
+	if !*yes {
+		exit("Importing replaces the existing data of the user. Please provide -yes to confirm")
+	}
+
+	f, err := os.Open(fs.Arg(1))
+	if err != nil {
+		exit("Could not open the archive: %s", err.Error())
+	}
+	defer f.Close()
+
+	a, err := archive.Decode(f, 0)
+	if err != nil {
+		exit("Could not read the archive: %s", err.Error())
+	}
+	if err := a.Validate(); err != nil {
+		exit("%s", err.Error())
+	}
+
+	cfg := loadConfig()
+	initDB(cfg)
+	defer database.Close()
+
+	user := findUser(fs)
+
+	tx := database.DBConn.Begin()
+	summary, err := archive.Import(tx, user, a, initBlobStore(cfg), cfg.Attachments.Quota, time.Now())
+	if err == operations.ErrQuotaExceeded {
+		tx.Rollback()
+		exit("The attachments in the archive exceed the attachment quota")
+	} else if err != nil {
+		tx.Rollback()
+		panic(errors.Wrap(err, "importing archive"))
+	}
+	tx.Commit()
+
+	fmt.Printf("Imported %d books, %d notes, %d attachments, %d repetition rules and %d digests\n", summary.Books, summary.Notes, summary.Attachments, summary.RepetitionRules, summary.Digests)
+	if summary.SkippedAttachments > 0 {
+		fmt.Printf("Skipped %d attachments whose content is not available to the user on this instance\n", summary.SkippedAttachments)
+	}
+}
+
+func userCmd(args []string) {
+	if len(args) == 0 {
+		fmt.Printf(`Usage:
+  dnote-server user [command]
+
+Available commands:
+  create: Create a user
+  list: List all users
+  disable <email|uuid>: Disable a user and sign the user out
+  reset-password <email|uuid>: Set a new password of a user
+  create-token [-device name] <email|uuid>: Issue a session token with which the CLI can log in
+  reset-totp <email|uuid>: Disable the two-factor authentication of a user who lost access to it
+  export <email|uuid> <path>: Export the data of a user to an archive
+  import <email|uuid> <path>: Replace the data of a user with an archive
+`)
+		return
+	}
+
+	switch args[0] {
+	case "create":
+		userCreateCmd(args[1:])
+	case "list":
+		userListCmd(args[1:])
+	case "disable":
+		userDisableCmd(args[1:])
+	case "reset-password":
+		userResetPasswordCmd(args[1:])
+	case "create-token":
+		userCreateTokenCmd(args[1:])
+	case "reset-totp":
+		userResetTOTPCmd(args[1:])
+	case "export":
+		userExportCmd(args[1:])
+	case "import":
+		userImportCmd(args[1:])
+	default:
+		exit("Unknown command user %s", args[0])
+	}
+}
+
+func digestCmd(args []string) {
+	if len(args) == 0 || args[0] != "run" {
+		fmt.Printf(`Usage:
+  dnote-server digest run -user <email|uuid>
+
+Deliver the digests of all enabled repetition rules of a user right away.
+The schedules of the rules are not affected.
+`)
+		return
+	}
+
+	fs := flag.NewFlagSet("digest run", flag.ExitOnError)
+	identifier := fs.String("user", "", "email or uuid of the user")
+	fs.Parse(args[1:])
+
+	if *identifier == "" {
+		exit("Please provide -user")
+	}
+
+	cfg := loadConfig()
+	initDB(cfg)
+	defer database.Close()
+
+	user, err := admin.FindUser(database.DBConn, *identifier)
+	if err == admin.ErrUserNotFound {
+		exit("No user was found with %s", *identifier)
+	} else if err != nil {
+		panic(errors.Wrap(err, "finding user"))
+	}
+
+	mailer.InitTemplates(nil)
+	m := mailer.New(cfg.Mailer())
+
+	count, err := repetition.DoUser(clock.New(), entitlement.New(cfg.SelfHosted), m, user)
+	if err == repetition.ErrNotEntitled {
+		exit("The user %s is not entitled to digests", *identifier)
+	} else if err != nil {
+		panic(errors.Wrap(err, "delivering digests"))
+	}
+
+	fmt.Printf("Delivered %d digests\n", count)
+}
+
+func backupCmd(args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		exit("Please provide the path to the backup file")
+	}
+
+	cfg := loadConfig()
+	if err := admin.Backup(cfg.Database(), fs.Arg(0)); err != nil {
+		panic(errors.Wrap(err, "backing up the database"))
+	}
+
+	fmt.Printf("Backed up the database to %s\n", fs.Arg(0))
+}
+
+func restoreCmd(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	yes := fs.Bool("yes", false, "confirm that the existing data in the database is replaced")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		exit("Please provide the path to the backup file")
+	}
+	if !*yes {
+		exit("Restoring replaces the existing data in the database. Please provide -yes to confirm")
+	}
+
+	cfg := loadConfig()
+	if err := admin.Restore(cfg.Database(), fs.Arg(0)); err != nil {
+		panic(errors.Wrap(err, "restoring the database"))
+	}
+
+	fmt.Printf("Restored the database from %s\n", fs.Arg(0))
+}
+
+func doctorCmd(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	identifier := fs.String("user", "", "email or uuid of the user to check. If empty, all users are checked")
+	fix := fs.Bool("fix", false, "fix the problems by giving new usn to the affected items")
+	fs.Parse(args)
+
+	initDB(loadConfig())
+	defer database.Close()
+
+	db := database.DBConn
+
+	var users []database.User
+	if *identifier != "" {
+		user, err := admin.FindUser(db, *identifier)
+		if err == admin.ErrUserNotFound {
+			exit("No user was found with %s", *identifier)
+		} else if err != nil {
+			panic(errors.Wrap(err, "finding user"))
+		}
+
+		users = append(users, user)
+	} else if err := db.Order("id ASC").Find(&users).Error; err != nil {
+		panic(errors.Wrap(err, "finding users"))
+	}
+
+	var problemCount int
+	for _, user := range users {
+		var report admin.USNReport
+		var err error
+
+		if *fix {
+			tx := db.Begin()
+			report, err = admin.FixUSN(tx, user)
+			if err != nil {
+				tx.Rollback()
+				panic(errors.Wrapf(err, "fixing usn of the user %s", user.UUID))
+			}
+			tx.Commit()
+		} else {
+			report, err = admin.CheckUSN(db, user)
+			if err != nil {
+				panic(errors.Wrapf(err, "checking usn of the user %s", user.UUID))
+			}
+		}
+
+		for _, p := range report.Problems {
+			if p.UUID == "" {
+				fmt.Printf("user %s: %s (max_usn %d, greatest usn %d)\n", report.UserUUID, p.Kind, report.MaxUSN, p.USN)
+			} else {
+				fmt.Printf("user %s: %s (%s %s, usn %d, shared %t)\n", report.UserUUID, p.Kind, p.Type, p.UUID, p.USN, p.Shared)
+			}
+		}
+
+		problemCount += len(report.Problems)
+	}
+
+	if problemCount == 0 {
+		fmt.Printf("Checked %d users. No problem was found\n", len(users))
+		return
+	}
+
+	if *fix {
+		fmt.Printf("Checked %d users. Fixed %d problems\n", len(users), problemCount)
+		return
+	}
+
+	exit("Checked %d users. Found %d problems. Run with -fix to fix them", len(users), problemCount)
+}
@@ -0,0 +1,626 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package config provides the configuration of the server, which is loaded from
+// a YAML file and environment variables
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dnote/dnote/pkg/server/blob"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/mailer"
+	"github.com/dnote/dnote/pkg/server/oidc"
+	"github.com/dnote/dnote/pkg/server/ratelimit"
+	"github.com/dnote/dnote/pkg/server/webhook"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// EnvProduction is the environment in which emails are sent and the database
+	// connection requires SSL by default
+	EnvProduction = "PRODUCTION"
+	// EnvDevelopment is the environment for local development
+	EnvDevelopment = "DEVELOPMENT"
+	// EnvTest is the environment for running tests
+	EnvTest = "TEST"
+)
+
+// DBConfig is the configuration of the database connection
+type DBConfig struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	Name     string `yaml:"name"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	// SSLMode is the sslmode of the connection. It defaults to "require" in
+	// production and "disable" otherwise.
+	SSLMode string `yaml:"ssl_mode"`
+}
+
+// SMTPConfig is the configuration of the SMTP server used to send emails
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// RateLimitConfig is the configuration of the rate limiting
+type RateLimitConfig struct {
+	// Store is either "memory" or "postgres"
+	Store string `yaml:"store"`
+	// TrustedProxies are the IP addresses or CIDR ranges of the reverse proxies
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// StripeConfig is the configuration of the billing
+type StripeConfig struct {
+	SecretKey     string `yaml:"secret_key"`
+	WebhookSecret string `yaml:"webhook_secret"`
+}
+
+// ACMEConfig is the configuration of obtaining certificates automatically over ACME
+type ACMEConfig struct {
+	// Domains are the domain names for which certificates are obtained. ACME is
+	// enabled if not empty.
+	Domains []string `yaml:"domains"`
+	Email   string   `yaml:"email"`
+	// CacheDir is the directory in which the certificates and the account key are kept
+	CacheDir string `yaml:"cache_dir"`
+	// DirectoryURL is the URL of the ACME directory. It defaults to Let's Encrypt.
+	DirectoryURL string `yaml:"directory_url"`
+	// CAFile is the path to PEM encoded certificates to trust when connecting to
+	// the ACME directory, such as the root of a local test server
+	CAFile string `yaml:"ca_file"`
+}
+
+// TLSConfig is the configuration of HTTPS
+type TLSConfig struct {
+	// CertFile and KeyFile are the paths to the certificate and its private key
+	CertFile string     `yaml:"cert_file"`
+	KeyFile  string     `yaml:"key_file"`
+	ACME     ACMEConfig `yaml:"acme"`
+	// HTTPPort is the port on which plain HTTP requests are redirected to HTTPS and
+	// the ACME HTTP challenges are answered. If empty, plain HTTP is not served.
+	HTTPPort string `yaml:"http_port"`
+}
+
+// S3Config is the configuration of an S3-compatible object storage
+type S3Config struct {
+	// Endpoint is the base URL of the storage, such as http://localhost:9000
+	Endpoint        string `yaml:"endpoint"`
+	Region          string `yaml:"region"`
+	Bucket          string `yaml:"bucket"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+}
+
+// AttachmentsConfig is the configuration of the storage of note attachments
+type AttachmentsConfig struct {
+	// Store is either "filesystem" or "s3". If empty, attachments are disabled.
+	Store string `yaml:"store"`
+	// Dir is the directory in which the filesystem store keeps the content
+	Dir string   `yaml:"dir"`
+	S3  S3Config `yaml:"s3"`
+	// MaxSize is the maximum size in bytes of an attachment
+	MaxSize int64 `yaml:"max_size"`
+	// Quota is the maximum total size in bytes of the attachments of a user. If 0,
+	// there is no limit.
+	Quota int64 `yaml:"quota"`
+}
+
+// OIDCConfig is the configuration of the single sign-on with an OpenID Connect provider
+type OIDCConfig struct {
+	// Issuer is the URL of the provider. Single sign-on is enabled if not empty.
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// RedirectURL is the URL of the callback endpoint registered with the provider,
+	// such as https://dnote.example.com/api/v3/sso/callback
+	RedirectURL string `yaml:"redirect_url"`
+	// AllowSignup lets the people who do not have an account sign up with the
+	// provider, subject to the policy of the organizations
+	AllowSignup bool `yaml:"allow_signup"`
+}
+
+// MetricsConfig is the configuration of the Prometheus metrics
+type MetricsConfig struct {
+	// Addr is the address on which the metrics are served, such as 127.0.0.1:9090.
+	// It should not be reachable from the internet. If empty, metrics are not served.
+	Addr string `yaml:"addr"`
+}
+
+// WebhooksConfig is the configuration of the webhooks
+type WebhooksConfig struct {
+	// AllowedNetworks are the IP addresses or CIDR ranges of the private networks
+	// to which the webhooks can deliver, such as the network of a chat or a wiki
+	// hosted alongside the server. Other private addresses are refused.
+	AllowedNetworks []string `yaml:"allowed_networks"`
+}
+
+// Config is the configuration of the server
+type Config struct {
+	// Env is one of PRODUCTION, DEVELOPMENT and TEST
+	Env        string `yaml:"env"`
+	Port       string `yaml:"port"`
+	SelfHosted bool   `yaml:"self_hosted"`
+	// ShutdownTimeout is how long the server waits for the requests and the jobs
+	// in progress to finish when shutting down
+	ShutdownTimeout time.Duration   `yaml:"shutdown_timeout"`
+	DB              DBConfig        `yaml:"db"`
+	SMTP            SMTPConfig      `yaml:"smtp"`
+	RateLimit       RateLimitConfig `yaml:"rate_limit"`
+	Stripe          StripeConfig    `yaml:"stripe"`
+	// TLS makes the server serve HTTPS on Port
+	TLS         TLSConfig         `yaml:"tls"`
+	Attachments AttachmentsConfig `yaml:"attachments"`
+	// AuditLogRetention is how long the audit events are kept. If 0, they are kept forever.
+	AuditLogRetention time.Duration  `yaml:"audit_log_retention"`
+	OIDC              OIDCConfig     `yaml:"oidc"`
+	Metrics           MetricsConfig  `yaml:"metrics"`
+	Webhooks          WebhooksConfig `yaml:"webhooks"`
+}
+
+// Default returns the default configuration
+func Default() Config {
+	return Config{
+		Env:             EnvDevelopment,
+		Port:            "3000",
+		ShutdownTimeout: 30 * time.Second,
+		DB: DBConfig{
+			Port: "5432",
+		},
+		SMTP: SMTPConfig{
+			Port: 465,
+		},
+		RateLimit: RateLimitConfig{
+			Store: "memory",
+		},
+		Attachments: AttachmentsConfig{
+			MaxSize: 10 << 20,
+			Quota:   1 << 30,
+		},
+		AuditLogRetention: 365 * 24 * time.Hour,
+	}
+}
+
+// TLSEnabled checks if the server serves HTTPS
+func (c Config) TLSEnabled() bool {
+	return c.TLS.CertFile != "" || len(c.TLS.ACME.Domains) > 0
+}
+
+// IsProd checks if the server runs in production
+func (c Config) IsProd() bool {
+	return c.Env == EnvProduction
+}
+
+// Database returns the configuration of the database connection
+func (c Config) Database() database.Config {
+	sslMode := c.DB.SSLMode
+	if sslMode == "" {
+		if c.IsProd() {
+			sslMode = "require"
+		} else {
+			sslMode = "disable"
+		}
+	}
+
+	return database.Config{
+		Host:     c.DB.Host,
+		Port:     c.DB.Port,
+		Name:     c.DB.Name,
+		User:     c.DB.User,
+		Password: c.DB.Password,
+		SSLMode:  sslMode,
+	}
+}
+
+// Mailer returns the configuration of the mailer. Emails are only sent in production
+// if an SMTP server is configured. The bodies of the emails that are not sent are
+// printed for development, but never in production.
+func (c Config) Mailer() mailer.Config {
+	return mailer.Config{
+		Host:      c.SMTP.Host,
+		Port:      c.SMTP.Port,
+		Username:  c.SMTP.Username,
+		Password:  c.SMTP.Password,
+		Enabled:   c.IsProd() && c.SMTP.Host != "",
+		PrintBody: !c.IsProd(),
+	}
+}
+
+// S3 returns the configuration of the S3-compatible storage of the attachments
+func (c Config) S3() blob.S3Config {
+	return blob.S3Config{
+		Endpoint:        c.Attachments.S3.Endpoint,
+		Region:          c.Attachments.S3.Region,
+		Bucket:          c.Attachments.S3.Bucket,
+		AccessKeyID:     c.Attachments.S3.AccessKeyID,
+		SecretAccessKey: c.Attachments.S3.SecretAccessKey,
+	}
+}
+
+// SSOEnabled checks if the single sign-on with an OpenID Connect provider is enabled
+func (c Config) SSOEnabled() bool {
+	return c.OIDC.Issuer != ""
+}
+
+// OIDCProvider returns the configuration of the client of the OpenID Connect provider
+func (c Config) OIDCProvider() oidc.Config {
+	return oidc.Config{
+		Issuer:       c.OIDC.Issuer,
+		ClientID:     c.OIDC.ClientID,
+		ClientSecret: c.OIDC.ClientSecret,
+		RedirectURL:  c.OIDC.RedirectURL,
+	}
+}
+
+// TrustedProxies returns the parsed list of the trusted proxies
+func (c Config) TrustedProxies() (ratelimit.TrustedProxies, error) {
+	return ratelimit.ParseTrustedProxies(strings.Join(c.RateLimit.TrustedProxies, ","))
+}
+
+// WebhookAllowedNetworks returns the parsed list of the private networks to which
+// the webhooks can deliver
+func (c Config) WebhookAllowedNetworks() ([]*net.IPNet, error) {
+	return webhook.ParseNetworks(c.Webhooks.AllowedNetworks)
+}
+
+// splitList splits a comma-separated list
+func splitList(s string) []string {
+	var ret []string
+
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			ret = append(ret, part)
+		}
+	}
+
+	return ret
+}
+
+// lookupEnvFunc looks up an environment variable
+type lookupEnvFunc func(key string) (string, bool)
+
+// applyEnv overrides the configuration with the environment variables that are set
+func applyEnv(c *Config, lookup lookupEnvFunc) error {
+	strVars := map[string]*string{
+		"GO_ENV":              &c.Env,
+		"Port":                &c.Port,
+		"DBHost":              &c.DB.Host,
+		"DBPort":              &c.DB.Port,
+		"DBName":              &c.DB.Name,
+		"DBUser":              &c.DB.User,
+		"DBPassword":          &c.DB.Password,
+		"DBSSLMode":           &c.DB.SSLMode,
+		"SmtpHost":            &c.SMTP.Host,
+		"SmtpUsername":        &c.SMTP.Username,
+		"SmtpPassword":        &c.SMTP.Password,
+		"RateLimitStore":      &c.RateLimit.Store,
+		"StripeSecretKey":     &c.Stripe.SecretKey,
+		"StripeWebhookSecret": &c.Stripe.WebhookSecret,
+		"TLSCertFile":         &c.TLS.CertFile,
+		"TLSKeyFile":          &c.TLS.KeyFile,
+		"TLSHTTPPort":         &c.TLS.HTTPPort,
+		"ACMEEmail":           &c.TLS.ACME.Email,
+		"ACMECacheDir":        &c.TLS.ACME.CacheDir,
+		"ACMEDirectoryURL":    &c.TLS.ACME.DirectoryURL,
+		"ACMECAFile":          &c.TLS.ACME.CAFile,
+		"AttachmentStore":     &c.Attachments.Store,
+		"AttachmentDir":       &c.Attachments.Dir,
+		"S3Endpoint":          &c.Attachments.S3.Endpoint,
+		"S3Region":            &c.Attachments.S3.Region,
+		"S3Bucket":            &c.Attachments.S3.Bucket,
+		"S3AccessKeyID":       &c.Attachments.S3.AccessKeyID,
+		"S3SecretAccessKey":   &c.Attachments.S3.SecretAccessKey,
+		"OIDCIssuer":          &c.OIDC.Issuer,
+		"OIDCClientID":        &c.OIDC.ClientID,
+		"OIDCClientSecret":    &c.OIDC.ClientSecret,
+		"OIDCRedirectURL":     &c.OIDC.RedirectURL,
+		"MetricsAddr":         &c.Metrics.Addr,
+	}
+	for key, ptr := range strVars {
+		if v, ok := lookup(key); ok {
+			*ptr = v
+		}
+	}
+
+	if v, ok := lookup("SelfHosted"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.Errorf("SelfHosted must be true or false, got '%s'", v)
+		}
+		c.SelfHosted = b
+	}
+	if v, ok := lookup("OIDCAllowSignup"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.Errorf("OIDCAllowSignup must be true or false, got '%s'", v)
+		}
+		c.OIDC.AllowSignup = b
+	}
+	if v, ok := lookup("ShutdownTimeout"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return errors.Errorf("ShutdownTimeout must be a duration such as 30s, got '%s'", v)
+		}
+		c.ShutdownTimeout = d
+	}
+	if v, ok := lookup("AuditLogRetention"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return errors.Errorf("AuditLogRetention must be a duration such as 8760h, got '%s'", v)
+		}
+		c.AuditLogRetention = d
+	}
+	if v, ok := lookup("SmtpPort"); ok {
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			return errors.Errorf("SmtpPort must be a number, got '%s'", v)
+		}
+		c.SMTP.Port = p
+	}
+	if v, ok := lookup("AttachmentMaxSize"); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return errors.Errorf("AttachmentMaxSize must be a number of bytes, got '%s'", v)
+		}
+		c.Attachments.MaxSize = n
+	}
+	if v, ok := lookup("AttachmentQuota"); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return errors.Errorf("AttachmentQuota must be a number of bytes, got '%s'", v)
+		}
+		c.Attachments.Quota = n
+	}
+	if v, ok := lookup("TrustedProxies"); ok {
+		c.RateLimit.TrustedProxies = splitList(v)
+	}
+	if v, ok := lookup("WebhookAllowedNetworks"); ok {
+		c.Webhooks.AllowedNetworks = splitList(v)
+	}
+	if v, ok := lookup("ACMEDomains"); ok {
+		c.TLS.ACME.Domains = splitList(v)
+	}
+
+	return nil
+}
+
+// ValidationError is an error for an invalid configuration. It lists all problems.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("invalid configuration:\n  - %s", strings.Join(e.Problems, "\n  - "))
+}
+
+func isValidPort(s string) bool {
+	p, err := strconv.Atoi(s)
+	if err != nil {
+		return false
+	}
+
+	return p > 0 && p <= 65535
+}
+
+func (c TLSConfig) validate() []string {
+	var problems []string
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		problems = append(problems, "tls.cert_file and tls.key_file must be set together")
+	}
+	if c.CertFile != "" && len(c.ACME.Domains) > 0 {
+		problems = append(problems, "tls.cert_file and tls.acme cannot be used together")
+	}
+	if len(c.ACME.Domains) > 0 && c.ACME.CacheDir == "" {
+		problems = append(problems, "tls.acme.cache_dir is required to use ACME")
+	}
+	if c.HTTPPort != "" && !isValidPort(c.HTTPPort) {
+		problems = append(problems, fmt.Sprintf("tls.http_port must be a number between 1 and 65535, got '%s'", c.HTTPPort))
+	}
+
+	return problems
+}
+
+func (c OIDCConfig) validate() []string {
+	var problems []string
+
+	if c.Issuer == "" {
+		return problems
+	}
+
+	if u, err := url.Parse(c.Issuer); err != nil || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("oidc.issuer must be a URL, got '%s'", c.Issuer))
+	}
+	if c.ClientID == "" {
+		problems = append(problems, "oidc.client_id is required to use single sign-on")
+	}
+	if c.RedirectURL == "" {
+		problems = append(problems, "oidc.redirect_url is required to use single sign-on")
+	}
+
+	return problems
+}
+
+func (c MetricsConfig) validate() []string {
+	var problems []string
+
+	if c.Addr == "" {
+		return problems
+	}
+
+	if _, port, err := net.SplitHostPort(c.Addr); err != nil || !isValidPort(port) {
+		problems = append(problems, fmt.Sprintf("metrics.addr must be a host and a port such as 127.0.0.1:9090, got '%s'", c.Addr))
+	}
+
+	return problems
+}
+
+func (c AttachmentsConfig) validate() []string {
+	var problems []string
+
+	switch c.Store {
+	case "":
+	case "filesystem":
+		if c.Dir == "" {
+			problems = append(problems, "attachments.dir is required to use the filesystem store")
+		}
+	case "s3":
+		if c.S3.Endpoint == "" {
+			problems = append(problems, "attachments.s3.endpoint is required to use the s3 store")
+		}
+		if c.S3.Bucket == "" {
+			problems = append(problems, "attachments.s3.bucket is required to use the s3 store")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("attachments.store must be either filesystem or s3, got '%s'", c.Store))
+	}
+
+	if c.MaxSize <= 0 {
+		problems = append(problems, fmt.Sprintf("attachments.max_size must be positive, got %d", c.MaxSize))
+	}
+	if c.Quota < 0 {
+		problems = append(problems, fmt.Sprintf("attachments.quota must not be negative, got %d", c.Quota))
+	}
+
+	return problems
+}
+
+// Validate checks if the configuration is valid
+func (c Config) Validate() error {
+	var problems []string
+
+	if c.Env != EnvProduction && c.Env != EnvDevelopment && c.Env != EnvTest {
+		problems = append(problems, fmt.Sprintf("env must be one of %s, %s and %s, got '%s'", EnvProduction, EnvDevelopment, EnvTest, c.Env))
+	}
+	if !isValidPort(c.Port) {
+		problems = append(problems, fmt.Sprintf("port must be a number between 1 and 65535, got '%s'", c.Port))
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		problems = append(problems, fmt.Sprintf("shutdown_timeout must be positive, got %s", c.ShutdownTimeout))
+	}
+	if c.AuditLogRetention < 0 {
+		problems = append(problems, fmt.Sprintf("audit_log_retention must not be negative, got %s", c.AuditLogRetention))
+	}
+
+	if c.DB.Host == "" {
+		problems = append(problems, "db.host is required")
+	}
+	if !isValidPort(c.DB.Port) {
+		problems = append(problems, fmt.Sprintf("db.port must be a number between 1 and 65535, got '%s'", c.DB.Port))
+	}
+	if c.DB.Name == "" {
+		problems = append(problems, "db.name is required")
+	}
+	if c.DB.User == "" {
+		problems = append(problems, "db.user is required")
+	}
+	switch c.DB.SSLMode {
+	case "", "disable", "require", "verify-ca", "verify-full":
+	default:
+		problems = append(problems, fmt.Sprintf("db.ssl_mode must be one of disable, require, verify-ca and verify-full, got '%s'", c.DB.SSLMode))
+	}
+
+	if c.SMTP.Port <= 0 || c.SMTP.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("smtp.port must be a number between 1 and 65535, got %d", c.SMTP.Port))
+	}
+
+	switch c.RateLimit.Store {
+	case "memory", "postgres":
+	default:
+		problems = append(problems, fmt.Sprintf("rate_limit.store must be either memory or postgres, got '%s'", c.RateLimit.Store))
+	}
+	if _, err := c.TrustedProxies(); err != nil {
+		problems = append(problems, fmt.Sprintf("rate_limit.trusted_proxies is invalid: %s", err.Error()))
+	}
+	if _, err := c.WebhookAllowedNetworks(); err != nil {
+		problems = append(problems, fmt.Sprintf("webhooks.allowed_networks is invalid: %s", err.Error()))
+	}
+
+	problems = append(problems, c.TLS.validate()...)
+	problems = append(problems, c.Attachments.validate()...)
+	problems = append(problems, c.OIDC.validate()...)
+	problems = append(problems, c.Metrics.validate()...)
+
+	if len(problems) > 0 {
+		return ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+// parseFile reads the configuration file at the given path into the configuration
+func parseFile(c *Config, path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+	default:
+		return errors.Errorf("unsupported configuration file format '%s'. Please use YAML", filepath.Ext(path))
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "reading file")
+	}
+
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return errors.Wrap(err, "parsing YAML")
+	}
+
+	return nil
+}
+
+func load(path string, lookup lookupEnvFunc) (Config, error) {
+	c := Default()
+
+	if path != "" {
+		if err := parseFile(&c, path); err != nil {
+			return c, errors.Wrapf(err, "loading configuration file %s", path)
+		}
+	}
+
+	if err := applyEnv(&c, lookup); err != nil {
+		return c, errors.Wrap(err, "reading environment variables")
+	}
+
+	if err := c.Validate(); err != nil {
+		return c, err
+	}
+
+	return c, nil
+}
+
+// Load returns the configuration from the file at the given path, if not empty,
+// overridden by the environment variables. It returns an error if the configuration
+// is invalid.
+func Load(path string) (Config, error) {
+	return load(path, os.LookupEnv)
+}
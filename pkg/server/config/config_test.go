@@ -0,0 +1,440 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/mailer"
+	"github.com/pkg/errors"
+)
+
+func makeLookup(env map[string]string) lookupEnvFunc {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(errors.Wrap(err, "writing file"))
+	}
+
+	return path
+}
+
+func TestLoad_File(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dnote-config")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "making temp dir"))
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeFile(t, dir, "dnote.yml", `env: PRODUCTION
+port: "8080"
+self_hosted: true
+shutdown_timeout: 10s
+audit_log_retention: 720h
+db:
+  host: db.example.com
+  name: dnote
+  user: dnote
+  password: secret
+smtp:
+  host: smtp.example.com
+  port: 587
+rate_limit:
+  store: postgres
+  trusted_proxies:
+    - 127.0.0.1
+webhooks:
+  allowed_networks:
+    - 10.0.0.0/8
+`)
+
+	c, err := load(path, makeLookup(map[string]string{
+		"DBPassword": "override",
+	}))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "loading config"))
+	}
+
+	assert.Equal(t, c.Env, EnvProduction, "Env mismatch")
+	assert.Equal(t, c.Port, "8080", "Port mismatch")
+	assert.Equal(t, c.SelfHosted, true, "SelfHosted mismatch")
+	assert.Equal(t, c.ShutdownTimeout, 10*time.Second, "ShutdownTimeout mismatch")
+	assert.Equal(t, c.AuditLogRetention, 720*time.Hour, "AuditLogRetention mismatch")
+	assert.Equal(t, c.RateLimit.Store, "postgres", "RateLimit.Store mismatch")
+	assert.DeepEqual(t, c.RateLimit.TrustedProxies, []string{"127.0.0.1"}, "RateLimit.TrustedProxies mismatch")
+	assert.DeepEqual(t, c.Webhooks.AllowedNetworks, []string{"10.0.0.0/8"}, "Webhooks.AllowedNetworks mismatch")
+
+	assert.DeepEqual(t, c.Database(), database.Config{
+		Host:     "db.example.com",
+		Port:     "5432",
+		Name:     "dnote",
+		User:     "dnote",
+		Password: "override",
+		SSLMode:  "require",
+	}, "Database mismatch")
+	assert.DeepEqual(t, c.Mailer(), mailer.Config{
+		Host:    "smtp.example.com",
+		Port:    587,
+		Enabled: true,
+	}, "Mailer mismatch")
+}
+
+func TestLoad_Env(t *testing.T) {
+	c, err := load("", makeLookup(map[string]string{
+		"GO_ENV":                 "TEST",
+		"DBHost":                 "localhost",
+		"DBPort":                 "5433",
+		"DBName":                 "dnote_test",
+		"DBUser":                 "postgres",
+		"SmtpHost":               "smtp.example.com",
+		"SmtpPort":               "2525",
+		"SelfHosted":             "true",
+		"TrustedProxies":         "10.0.0.1, 10.0.0.2",
+		"AttachmentQuota":        "0",
+		"OIDCIssuer":             "https://accounts.example.com",
+		"OIDCClientID":           "dnote",
+		"OIDCAllowSignup":        "true",
+		"MetricsAddr":            "127.0.0.1:9090",
+		"WebhookAllowedNetworks": "10.0.0.0/8, 192.168.1.5",
+	}))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "loading config"))
+	}
+
+	assert.Equal(t, c.Env, EnvTest, "Env mismatch")
+	assert.Equal(t, c.Port, "3000", "Port mismatch")
+	assert.Equal(t, c.SelfHosted, true, "SelfHosted mismatch")
+	assert.Equal(t, c.SMTP.Port, 2525, "SMTP.Port mismatch")
+	assert.DeepEqual(t, c.RateLimit.TrustedProxies, []string{"10.0.0.1", "10.0.0.2"}, "RateLimit.TrustedProxies mismatch")
+	assert.Equal(t, c.Attachments.Quota, int64(0), "Attachments.Quota mismatch")
+	assert.Equal(t, c.Attachments.MaxSize, int64(10<<20), "Attachments.MaxSize mismatch")
+	assert.Equal(t, c.AuditLogRetention, 365*24*time.Hour, "AuditLogRetention mismatch")
+	assert.Equal(t, c.Database().SSLMode, "disable", "SSLMode mismatch")
+	assert.Equal(t, c.Database().Port, "5433", "DB port mismatch")
+	assert.Equal(t, c.SSOEnabled(), true, "SSOEnabled mismatch")
+	assert.Equal(t, c.Metrics.Addr, "127.0.0.1:9090", "Metrics.Addr mismatch")
+	assert.DeepEqual(t, c.Webhooks.AllowedNetworks, []string{"10.0.0.0/8", "192.168.1.5"}, "Webhooks.AllowedNetworks mismatch")
+	assert.DeepEqual(t, c.Mailer(), mailer.Config{
+		Host:      "smtp.example.com",
+		Port:      2525,
+		Enabled:   false,
+		PrintBody: true,
+	}, "Mailer mismatch")
+	assert.Equal(t, c.OIDCProvider().Issuer, "https://accounts.example.com", "OIDC.Issuer mismatch")
+	assert.Equal(t, c.OIDC.AllowSignup, true, "OIDC.AllowSignup mismatch")
+	// emails are not sent outside production
+	assert.Equal(t, c.Mailer().Enabled, false, "Mailer.Enabled mismatch")
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dnote-config")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "making temp dir"))
+	}
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		name string
+		path string
+		env  map[string]string
+	}{
+		{
+			name: "unknown field",
+			path: writeFile(t, dir, "unknown.yml", "db:\n  hots: localhost\n"),
+		},
+		{
+			name: "unsupported format",
+			path: writeFile(t, dir, "dnote.json", "{}"),
+		},
+		{
+			name: "missing file",
+			path: filepath.Join(dir, "missing.yml"),
+		},
+		{
+			name: "invalid boolean",
+			env:  map[string]string{"SelfHosted": "yes please"},
+		},
+		{
+			name: "invalid shutdown timeout",
+			env:  map[string]string{"ShutdownTimeout": "30"},
+		},
+		{
+			name: "invalid audit log retention",
+			env:  map[string]string{"AuditLogRetention": "1 year"},
+		},
+		{
+			name: "invalid oidc allow signup",
+			env:  map[string]string{"OIDCAllowSignup": "maybe"},
+		},
+		{
+			name: "invalid smtp port",
+			env:  map[string]string{"SmtpPort": "smtp"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := load(tc.path, makeLookup(tc.env))
+
+			assert.NotEqual(t, err, nil, "error mismatch")
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := Default()
+	valid.DB.Host = "localhost"
+	valid.DB.Name = "dnote"
+	valid.DB.User = "postgres"
+
+	assert.Equal(t, valid.Validate(), nil, "valid config error mismatch")
+
+	c := valid
+	c.Env = "STAGING"
+	c.Port = "abc"
+	c.DB.Host = ""
+	c.DB.SSLMode = "prefer"
+	c.RateLimit.Store = "redis"
+	c.RateLimit.TrustedProxies = []string{"not-an-ip"}
+	c.Webhooks.AllowedNetworks = []string{"10.0.0.0/33"}
+	c.Metrics.Addr = "9090"
+
+	err := c.Validate()
+	verr, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("error is not a ValidationError: %v", err)
+	}
+
+	assert.DeepEqual(t, verr.Problems, []string{
+		"env must be one of PRODUCTION, DEVELOPMENT and TEST, got 'STAGING'",
+		"port must be a number between 1 and 65535, got 'abc'",
+		"db.host is required",
+		"db.ssl_mode must be one of disable, require, verify-ca and verify-full, got 'prefer'",
+		"rate_limit.store must be either memory or postgres, got 'redis'",
+		"rate_limit.trusted_proxies is invalid: invalid IP address 'not-an-ip'",
+		"webhooks.allowed_networks is invalid: parsing '10.0.0.0/33': invalid CIDR address: 10.0.0.0/33",
+		"metrics.addr must be a host and a port such as 127.0.0.1:9090, got '9090'",
+	}, "problems mismatch")
+}
+
+func TestValidate_TLS(t *testing.T) {
+	base := Default()
+	base.DB.Host = "localhost"
+	base.DB.Name = "dnote"
+	base.DB.User = "postgres"
+
+	testCases := []struct {
+		name             string
+		tls              TLSConfig
+		expectedEnabled  bool
+		expectedProblems []string
+	}{
+		{
+			name:            "disabled",
+			tls:             TLSConfig{},
+			expectedEnabled: false,
+		},
+		{
+			name:            "certificate",
+			tls:             TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", HTTPPort: "80"},
+			expectedEnabled: true,
+		},
+		{
+			name:            "acme",
+			tls:             TLSConfig{ACME: ACMEConfig{Domains: []string{"example.com"}, CacheDir: "/var/lib/dnote"}},
+			expectedEnabled: true,
+		},
+		{
+			name:             "missing key",
+			tls:              TLSConfig{CertFile: "cert.pem"},
+			expectedEnabled:  true,
+			expectedProblems: []string{"tls.cert_file and tls.key_file must be set together"},
+		},
+		{
+			name: "certificate and acme",
+			tls: TLSConfig{
+				CertFile: "cert.pem",
+				KeyFile:  "key.pem",
+				ACME:     ACMEConfig{Domains: []string{"example.com"}, CacheDir: "/var/lib/dnote"},
+				HTTPPort: "http",
+			},
+			expectedEnabled: true,
+			expectedProblems: []string{
+				"tls.cert_file and tls.acme cannot be used together",
+				"tls.http_port must be a number between 1 and 65535, got 'http'",
+			},
+		},
+		{
+			name:             "acme without cache",
+			tls:              TLSConfig{ACME: ACMEConfig{Domains: []string{"example.com"}}},
+			expectedEnabled:  true,
+			expectedProblems: []string{"tls.acme.cache_dir is required to use ACME"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := base
+			c.TLS = tc.tls
+
+			assert.Equal(t, c.TLSEnabled(), tc.expectedEnabled, "TLSEnabled mismatch")
+
+			err := c.Validate()
+			if tc.expectedProblems == nil {
+				assert.Equal(t, err, nil, "error mismatch")
+				return
+			}
+
+			verr, ok := err.(ValidationError)
+			if !ok {
+				t.Fatalf("error is not a ValidationError: %v", err)
+			}
+			assert.DeepEqual(t, verr.Problems, tc.expectedProblems, "problems mismatch")
+		})
+	}
+}
+
+func TestValidate_Attachments(t *testing.T) {
+	base := Default()
+	base.DB.Host = "localhost"
+	base.DB.Name = "dnote"
+	base.DB.User = "postgres"
+
+	testCases := []struct {
+		name             string
+		attachments      AttachmentsConfig
+		expectedProblems []string
+	}{
+		{
+			name:        "disabled",
+			attachments: AttachmentsConfig{MaxSize: 100},
+		},
+		{
+			name:        "filesystem",
+			attachments: AttachmentsConfig{Store: "filesystem", Dir: "/var/lib/dnote/attachments", MaxSize: 100},
+		},
+		{
+			name:        "s3",
+			attachments: AttachmentsConfig{Store: "s3", S3: S3Config{Endpoint: "http://localhost:9000", Bucket: "dnote"}, MaxSize: 100, Quota: 1000},
+		},
+		{
+			name:             "filesystem without dir",
+			attachments:      AttachmentsConfig{Store: "filesystem", MaxSize: 100},
+			expectedProblems: []string{"attachments.dir is required to use the filesystem store"},
+		},
+		{
+			name:        "s3 without endpoint and bucket",
+			attachments: AttachmentsConfig{Store: "s3", MaxSize: 100},
+			expectedProblems: []string{
+				"attachments.s3.endpoint is required to use the s3 store",
+				"attachments.s3.bucket is required to use the s3 store",
+			},
+		},
+		{
+			name:        "invalid",
+			attachments: AttachmentsConfig{Store: "gcs", MaxSize: 0, Quota: -1},
+			expectedProblems: []string{
+				"attachments.store must be either filesystem or s3, got 'gcs'",
+				"attachments.max_size must be positive, got 0",
+				"attachments.quota must not be negative, got -1",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := base
+			c.Attachments = tc.attachments
+
+			err := c.Validate()
+			if tc.expectedProblems == nil {
+				assert.Equal(t, err, nil, "error mismatch")
+				return
+			}
+
+			verr, ok := err.(ValidationError)
+			if !ok {
+				t.Fatalf("error is not a ValidationError: %v", err)
+			}
+			assert.DeepEqual(t, verr.Problems, tc.expectedProblems, "problems mismatch")
+		})
+	}
+}
+
+func TestValidate_OIDC(t *testing.T) {
+	base := Default()
+	base.DB.Host = "localhost"
+	base.DB.Name = "dnote"
+	base.DB.User = "postgres"
+
+	testCases := []struct {
+		name             string
+		oidc             OIDCConfig
+		expectedProblems []string
+	}{
+		{
+			name: "disabled",
+			oidc: OIDCConfig{},
+		},
+		{
+			name: "enabled",
+			oidc: OIDCConfig{Issuer: "https://accounts.example.com", ClientID: "dnote", RedirectURL: "https://dnote.example.com/api/v3/sso/callback"},
+		},
+		{
+			name: "missing client",
+			oidc: OIDCConfig{Issuer: "accounts.example.com"},
+			expectedProblems: []string{
+				"oidc.issuer must be a URL, got 'accounts.example.com'",
+				"oidc.client_id is required to use single sign-on",
+				"oidc.redirect_url is required to use single sign-on",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := base
+			c.OIDC = tc.oidc
+
+			err := c.Validate()
+			if tc.expectedProblems == nil {
+				assert.Equal(t, err, nil, "error mismatch")
+				return
+			}
+
+			verr, ok := err.(ValidationError)
+			if !ok {
+				t.Fatalf("error is not a ValidationError: %v", err)
+			}
+			assert.DeepEqual(t, verr.Problems, tc.expectedProblems, "problems mismatch")
+		})
+	}
+}
@@ -37,3 +37,70 @@ const (
 	// BookDomainExluding incidates that all books except for some specified books are eligible to be the source books
 	BookDomainExluding = "excluding"
 )
+
+const (
+	// WebhookEventNoteCreated is an event for a note creation
+	WebhookEventNoteCreated = "note.created"
+	// WebhookEventNoteUpdated is an event for a note update
+	WebhookEventNoteUpdated = "note.updated"
+	// WebhookEventNoteDeleted is an event for a note deletion
+	WebhookEventNoteDeleted = "note.deleted"
+	// WebhookEventBookCreated is an event for a book creation
+	WebhookEventBookCreated = "book.created"
+	// WebhookEventBookUpdated is an event for a book update
+	WebhookEventBookUpdated = "book.updated"
+	// WebhookEventBookDeleted is an event for a book deletion
+	WebhookEventBookDeleted = "book.deleted"
+)
+
+const (
+	// WebhookDeliveryStatusPending indicates that a delivery has not succeeded yet and will be attempted
+	WebhookDeliveryStatusPending = "pending"
+	// WebhookDeliveryStatusSucceeded indicates that a delivery was accepted by the receiver
+	WebhookDeliveryStatusSucceeded = "succeeded"
+	// WebhookDeliveryStatusFailed indicates that a delivery was abandoned after exhausting the retries
+	WebhookDeliveryStatusFailed = "failed"
+)
+
+const (
+	// BookMemberRoleOwner is a role that can edit and manage the members of a book
+	BookMemberRoleOwner = "owner"
+	// BookMemberRoleEditor is a role that can add, edit and remove notes in a book
+	BookMemberRoleEditor = "editor"
+	// BookMemberRoleViewer is a role that can only read the notes in a book
+	BookMemberRoleViewer = "viewer"
+)
+
+const (
+	// SharedUSNTypeBook indicates that a SharedUSN is for a book
+	SharedUSNTypeBook = "book"
+	// SharedUSNTypeNote indicates that a SharedUSN is for a note
+	SharedUSNTypeNote = "note"
+)
+
+const (
+	// AuditEventSignin is an event for a successful signin
+	AuditEventSignin = "signin"
+	// AuditEventSigninFailed is an event for a signin with a wrong password or second factor
+	AuditEventSigninFailed = "signin.failed"
+	// AuditEventSignout is an event for a deletion of a session by signing out
+	AuditEventSignout = "signout"
+	// AuditEventSessionRevoked is an event for a revocation of sessions from the list of sessions
+	AuditEventSessionRevoked = "session.revoked"
+	// AuditEventPasswordUpdated is an event for a change of the password by the user
+	AuditEventPasswordUpdated = "password.updated"
+	// AuditEventPasswordReset is an event for a reset of the password with a reset token
+	AuditEventPasswordReset = "password.reset"
+	// AuditEventEmailVerified is an event for a verification of the email
+	AuditEventEmailVerified = "email.verified"
+	// AuditEventTOTPEnabled is an event for enabling the two-factor authentication
+	AuditEventTOTPEnabled = "totp.enabled"
+	// AuditEventTOTPDisabled is an event for disabling the two-factor authentication
+	AuditEventTOTPDisabled = "totp.disabled"
+	// AuditEventRecoveryCodesGenerated is an event for a generation of new recovery codes
+	AuditEventRecoveryCodesGenerated = "totp.recovery_codes_generated"
+	// AuditEventUserDisabled is an event for disabling a user by an administrator
+	AuditEventUserDisabled = "user.disabled"
+	// AuditEventUserEnabled is an event for enabling a user by an administrator
+	AuditEventUserEnabled = "user.enabled"
+)
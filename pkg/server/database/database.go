@@ -20,7 +20,6 @@ package database
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/jinzhu/gorm"
 	"github.com/pkg/errors"
@@ -41,6 +40,8 @@ type Config struct {
 	Name     string
 	User     string
 	Password string
+	// SSLMode is the sslmode of the connection. It defaults to "disable".
+	SSLMode string
 }
 
 // ErrConfigMissingHost is an error for an incomplete configuration missing the host
@@ -77,10 +78,8 @@ func getPGConnectionString(c Config) (string, error) {
 		return "", errors.Wrap(err, "invalid database config")
 	}
 
-	var sslmode string
-	if os.Getenv("GO_ENV") == "PRODUCTION" {
-		sslmode = "require"
-	} else {
+	sslmode := c.SSLMode
+	if sslmode == "" {
 		sslmode = "disable"
 	}
 
@@ -135,6 +134,19 @@ func InitSchema() {
 		Session{},
 		Digest{},
 		RepetitionRule{},
+		Webhook{},
+		WebhookDelivery{},
+		BookMember{},
+		BookInvitation{},
+		SharedUSN{},
+		Organization{},
+		OrganizationInvitation{},
+		RateLimitCounter{},
+		Attachment{},
+		NoteLink{},
+		RecoveryCode{},
+		AuditEvent{},
+		OIDCAuthRequest{},
 	).Error; err != nil {
 		panic(err)
 	}
@@ -20,22 +20,25 @@ package database
 
 import (
 	"log"
+	"time"
 
 	"github.com/gobuffalo/packr/v2"
 	"github.com/pkg/errors"
 	"github.com/rubenv/sql-migrate"
 )
 
-// Migrate runs the migrations
-func Migrate() error {
-	migrations := &migrate.PackrMigrationSource{
+func getMigrationSource() migrate.MigrationSource {
+	return &migrate.PackrMigrationSource{
 		Box: packr.New("migrations", "../database/migrations/"),
 	}
+}
 
+// Migrate runs the migrations
+func Migrate() error {
 	migrate.SetTable(MigrationTableName)
 
 	db := DBConn.DB()
-	n, err := migrate.Exec(db, "postgres", migrations, migrate.Up)
+	n, err := migrate.Exec(db, "postgres", getMigrationSource(), migrate.Up)
 	if err != nil {
 		return errors.Wrap(err, "running migrations")
 	}
@@ -44,3 +47,70 @@ func Migrate() error {
 
 	return nil
 }
+
+// CountPendingMigrations returns the number of migrations that have not been applied
+func CountPendingMigrations() (int, error) {
+	migrate.SetTable(MigrationTableName)
+
+	db := DBConn.DB()
+	planned, _, err := migrate.PlanMigration(db, "postgres", getMigrationSource(), migrate.Up, 0)
+	if err != nil {
+		return 0, errors.Wrap(err, "planning migrations")
+	}
+
+	return len(planned), nil
+}
+
+// MigrateDown rolls back at most the given number of the most recent migrations,
+// and returns the number of migrations rolled back
+func MigrateDown(max int) (int, error) {
+	migrate.SetTable(MigrationTableName)
+
+	db := DBConn.DB()
+	n, err := migrate.ExecMax(db, "postgres", getMigrationSource(), migrate.Down, max)
+	if err != nil {
+		return n, errors.Wrap(err, "rolling back migrations")
+	}
+
+	return n, nil
+}
+
+// MigrationStatus is the state of a migration
+type MigrationStatus struct {
+	ID string
+	// AppliedAt is nil if the migration is pending
+	AppliedAt *time.Time
+}
+
+// GetMigrationStatus returns the state of all known migrations in order
+func GetMigrationStatus() ([]MigrationStatus, error) {
+	migrate.SetTable(MigrationTableName)
+
+	db := DBConn.DB()
+
+	migrations, err := getMigrationSource().FindMigrations()
+	if err != nil {
+		return nil, errors.Wrap(err, "finding migrations")
+	}
+	records, err := migrate.GetMigrationRecords(db, "postgres")
+	if err != nil {
+		return nil, errors.Wrap(err, "getting migration records")
+	}
+
+	appliedAt := map[string]time.Time{}
+	for _, r := range records {
+		appliedAt[r.Id] = r.AppliedAt
+	}
+
+	ret := []MigrationStatus{}
+	for _, m := range migrations {
+		s := MigrationStatus{ID: m.Id}
+		if t, ok := appliedAt[m.Id]; ok {
+			s.AppliedAt = &t
+		}
+
+		ret = append(ret, s)
+	}
+
+	return ret, nil
+}
@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/dnote/dnote/pkg/server/config"
 	"github.com/dnote/dnote/pkg/server/database"
 	"github.com/joho/godotenv"
 	"github.com/pkg/errors"
@@ -31,31 +32,34 @@ import (
 
 var (
 	migrationDir = flag.String("migrationDir", "../migrations", "the path to the directory with migraiton files")
+	configPath   = flag.String("config", "", "the path to the server configuration file in YAML")
+	envFile      = flag.String("envFile", "../../.env.dev", "the path to a file with environment variables to load, if it exists")
 )
 
-func init() {
-	fmt.Println("Migrating Dnote database...")
-
-	// Load env
-	if os.Getenv("GO_ENV") != "PRODUCTION" {
-		if err := godotenv.Load("../../.env.dev"); err != nil {
-			panic(err)
+// initDB opens the connection to the database using the server configuration
+func initDB() {
+	// Load env for development
+	if _, err := os.Stat(*envFile); err == nil {
+		if err := godotenv.Load(*envFile); err != nil {
+			panic(errors.Wrap(err, "loading env file"))
 		}
 	}
 
-	c := database.Config{
-		Host:     os.Getenv("DBHost"),
-		Port:     os.Getenv("DBPort"),
-		Name:     os.Getenv("DBName"),
-		User:     os.Getenv("DBUser"),
-		Password: os.Getenv("DBPassword"),
+	c, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
 	}
-	database.Open(c)
+
+	database.Open(c.Database())
 }
 
 func main() {
 	flag.Parse()
 
+	fmt.Println("Migrating Dnote database...")
+	initDB()
+
 	db := database.DBConn
 
 	migrations := &migrate.FileMigrationSource{
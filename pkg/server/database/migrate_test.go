@@ -0,0 +1,132 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package database
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/pkg/errors"
+)
+
+// runMigrationUp applies the migration with the given id without recording it
+func runMigrationUp(t *testing.T, id string) {
+	migrations, err := getMigrationSource().FindMigrations()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "finding migrations"))
+	}
+
+	for _, m := range migrations {
+		if m.Id != id {
+			continue
+		}
+
+		for _, stmt := range m.Up {
+			if err := DBConn.Exec(stmt).Error; err != nil {
+				t.Fatal(errors.Wrapf(err, "running %s", id))
+			}
+		}
+
+		return
+	}
+
+	t.Fatalf("migration %s not found", id)
+}
+
+func TestMigration_BackfillNoteLinks(t *testing.T) {
+	Open(Config{
+		Host:     os.Getenv("DBHost"),
+		Port:     os.Getenv("DBPort"),
+		Name:     os.Getenv("DBName"),
+		User:     os.Getenv("DBUser"),
+		Password: os.Getenv("DBPassword"),
+	})
+	InitSchema()
+
+	db := DBConn
+	defer func() {
+		db.Delete(&NoteLink{})
+		db.Delete(&Note{})
+		db.Delete(&Book{})
+	}()
+
+	mustSave := func(v interface{}, message string) {
+		if err := db.Save(v).Error; err != nil {
+			t.Fatal(errors.Wrap(err, message))
+		}
+	}
+
+	userID := 1
+	b1 := Book{UserID: userID, Label: "infra"}
+	mustSave(&b1, "preparing b1")
+	b2 := Book{UserID: userID, Label: "k8s", ParentUUID: b1.UUID}
+	mustSave(&b2, "preparing b2")
+	b3 := Book{UserID: userID, Label: "js", Deleted: true}
+	mustSave(&b3, "preparing b3")
+
+	n1 := Note{UserID: userID, BookUUID: b2.UUID, Body: "# Pods\nn1 content", AddedOn: 1}
+	mustSave(&n1, "preparing n1")
+	n2 := Note{UserID: userID, BookUUID: b2.UUID, Body: "Pods\nn2 content", AddedOn: 2}
+	mustSave(&n2, "preparing n2")
+	n3 := Note{UserID: userID, BookUUID: b3.UUID, Body: "closures", AddedOn: 3}
+	mustSave(&n3, "preparing n3")
+	n4 := Note{UserID: userID, BookUUID: b1.UUID, AddedOn: 4}
+	mustSave(&n4, "preparing n4")
+	n5 := Note{UserID: userID, BookUUID: b1.UUID, Body: "see [[infra/k8s/Pods]]", AddedOn: 5}
+	mustSave(&n5, "preparing n5")
+	n6 := Note{UserID: userID, BookUUID: b1.UUID, Body: "see [[infra/k8s/Pods]]", AddedOn: 6, Deleted: true}
+	mustSave(&n6, "preparing n6")
+
+	// n4 links to the earliest note with the title, a note by uuid, itself, a deleted
+	// book and a missing note
+	n4Body := "see [[ infra/k8s/Pods ]], [[" + n2.UUID + "]], [[" + n4.UUID + "]], [[js/closures]], [[infra/k8s/missing]] and [[infra/k8s/Pods]]"
+	if err := db.Model(&n4).Update("body", n4Body).Error; err != nil {
+		t.Fatal(errors.Wrap(err, "preparing n4 body"))
+	}
+
+	// n5 already has its links recorded
+	l1 := NoteLink{SourceUUID: n5.UUID, TargetUUID: n2.UUID, Text: "infra/k8s/Pods"}
+	mustSave(&l1, "preparing l1")
+
+	runMigrationUp(t, "20191211093015-backfill-note-links.sql")
+
+	var n4Links []NoteLink
+	if err := db.Where("source_uuid = ?", n4.UUID).Order("text ASC").Find(&n4Links).Error; err != nil {
+		t.Fatal(errors.Wrap(err, "finding n4 links"))
+	}
+	assert.Equal(t, len(n4Links), 2, "n4 link count mismatch")
+	assert.Equal(t, n4Links[0].TargetUUID, n2.UUID, "n4Links[0] TargetUUID mismatch")
+	assert.Equal(t, n4Links[0].Text, n2.UUID, "n4Links[0] Text mismatch")
+	assert.Equal(t, n4Links[1].TargetUUID, n1.UUID, "n4Links[1] TargetUUID mismatch")
+	assert.Equal(t, n4Links[1].Text, "infra/k8s/Pods", "n4Links[1] Text mismatch")
+
+	var n5Links []NoteLink
+	if err := db.Where("source_uuid = ?", n5.UUID).Find(&n5Links).Error; err != nil {
+		t.Fatal(errors.Wrap(err, "finding n5 links"))
+	}
+	assert.Equal(t, len(n5Links), 1, "n5 link count mismatch")
+	assert.Equal(t, n5Links[0].TargetUUID, n2.UUID, "n5 link TargetUUID mismatch")
+
+	var n6LinkCount int
+	if err := db.Model(&NoteLink{}).Where("source_uuid = ?", n6.UUID).Count(&n6LinkCount).Error; err != nil {
+		t.Fatal(errors.Wrap(err, "counting n6 links"))
+	}
+	assert.Equal(t, n6LinkCount, 0, "n6 link count mismatch")
+}
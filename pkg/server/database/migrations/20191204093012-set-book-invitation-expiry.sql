@@ -0,0 +1,12 @@
+-- set-book-invitation-expiry.sql sets the expiry of the pending book invitations
+-- that were created before the invitations expired
+
+-- +migrate Up
+
+UPDATE book_invitations SET expires_at = created_at + INTERVAL '7 days'
+WHERE expires_at IS NULL AND accepted_at IS NULL;
+
+-- +migrate Down
+
+UPDATE book_invitations SET expires_at = NULL
+WHERE expires_at = created_at + INTERVAL '7 days' AND accepted_at IS NULL;
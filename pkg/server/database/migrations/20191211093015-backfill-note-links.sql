@@ -0,0 +1,70 @@
+-- backfill-note-links.sql records the links in the notes that were written before
+-- the links between notes were recorded. The links are parsed from the bodies in
+-- the same way as when a note is saved. A [[note-uuid]] link refers to the note
+-- with the uuid, and a [[book/title]] link refers to the earliest note with the
+-- title in the book at the path. The notes that already have links are skipped.
+
+-- +migrate Up
+
+-- +migrate StatementBegin
+WITH RECURSIVE book_paths(uuid, user_id, path) AS (
+  SELECT uuid::text, user_id, label::text FROM books
+  WHERE parent_uuid = '' AND NOT deleted AND position('/' IN label) = 0
+  UNION ALL
+  SELECT books.uuid::text, books.user_id, book_paths.path || '/' || books.label
+  FROM books
+  INNER JOIN book_paths ON books.parent_uuid = book_paths.uuid AND books.user_id = book_paths.user_id
+  WHERE NOT books.deleted AND position('/' IN books.label) = 0
+),
+links AS (
+  SELECT DISTINCT notes.uuid::text AS source_uuid, notes.user_id, btrim(m[1], E' \t\r\f\x0B') AS text
+  FROM notes, regexp_matches(notes.body, '\[\[([^\[\]\n]+)\]\]', 'g') AS m
+  WHERE NOT notes.deleted
+    AND NOT EXISTS (SELECT 1 FROM note_links WHERE note_links.source_uuid = notes.uuid)
+),
+uuid_links AS (
+  SELECT source_uuid, lower(text) AS target_uuid, text FROM links
+  WHERE text ~ '^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$'
+),
+path_links AS (
+  SELECT source_uuid, user_id, text,
+    btrim(substring(text FROM '^(.*)/'), E' \t\r\f\x0B') AS book,
+    btrim(substring(text FROM '/([^/]*)$'), E' \t\r\f\x0B') AS title
+  FROM links
+  WHERE text !~ '^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$'
+    AND position('/' IN text) > 0
+),
+note_titles AS (
+  SELECT notes.uuid::text AS uuid, notes.book_uuid::text AS book_uuid, notes.added_on, (
+    SELECT btrim(ltrim(btrim(lines.line, E' \t\r\f\x0B'), '#'), E' \t\r\f\x0B')
+    FROM unnest(string_to_array(notes.body, E'\n')) WITH ORDINALITY AS lines(line, idx)
+    WHERE btrim(ltrim(btrim(lines.line, E' \t\r\f\x0B'), '#'), E' \t\r\f\x0B') <> ''
+    ORDER BY lines.idx
+    LIMIT 1
+  ) AS title
+  FROM notes
+  WHERE NOT notes.deleted
+),
+resolved_path_links AS (
+  SELECT DISTINCT ON (path_links.source_uuid, path_links.text)
+    path_links.source_uuid, note_titles.uuid AS target_uuid, path_links.text
+  FROM path_links
+  INNER JOIN book_paths ON book_paths.user_id = path_links.user_id AND book_paths.path = path_links.book
+  INNER JOIN note_titles ON note_titles.book_uuid = book_paths.uuid AND note_titles.title = path_links.title
+  WHERE path_links.book <> '' AND path_links.title <> ''
+  ORDER BY path_links.source_uuid, path_links.text, note_titles.added_on ASC
+)
+INSERT INTO note_links (source_uuid, target_uuid, text, created_at, updated_at)
+SELECT source_uuid::uuid, target_uuid::uuid, text, now(), now()
+FROM (
+  SELECT source_uuid, target_uuid, text FROM uuid_links
+  UNION ALL
+  SELECT source_uuid, target_uuid, text FROM resolved_path_links
+) AS resolved
+WHERE target_uuid <> source_uuid;
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- The backfilled links cannot be told apart from the links recorded afterwards,
+-- and are kept.
@@ -20,6 +20,8 @@ package database
 
 import (
 	"time"
+
+	"github.com/lib/pq"
 )
 
 // Model is the base model definition
@@ -32,15 +34,16 @@ type Model struct {
 // Book is a model for a book
 type Book struct {
 	Model
-	UUID      string `json:"uuid" gorm:"index;type:uuid;default:uuid_generate_v4()"`
-	UserID    int    `json:"user_id" gorm:"index"`
-	Label     string `json:"label" gorm:"index"`
-	Notes     []Note `json:"notes" gorm:"foreignkey:book_uuid"`
-	AddedOn   int64  `json:"added_on"`
-	EditedOn  int64  `json:"edited_on"`
-	USN       int    `json:"-" gorm:"index"`
-	Deleted   bool   `json:"-" gorm:"default:false"`
-	Encrypted bool   `json:"-" gorm:"default:false"`
+	UUID       string `json:"uuid" gorm:"index;type:uuid;default:uuid_generate_v4()"`
+	UserID     int    `json:"user_id" gorm:"index"`
+	Label      string `json:"label" gorm:"index"`
+	ParentUUID string `json:"parent_uuid" gorm:"index;not null;default:''"`
+	Notes      []Note `json:"notes" gorm:"foreignkey:book_uuid"`
+	AddedOn    int64  `json:"added_on"`
+	EditedOn   int64  `json:"edited_on"`
+	USN        int    `json:"-" gorm:"index"`
+	Deleted    bool   `json:"-" gorm:"default:false"`
+	Encrypted  bool   `json:"-" gorm:"default:false"`
 }
 
 // Note is a model for a note
@@ -59,6 +62,16 @@ type Note struct {
 	USN       int    `json:"-" gorm:"index"`
 	Deleted   bool   `json:"-" gorm:"default:false"`
 	Encrypted bool   `json:"-" gorm:"default:false"`
+	// PublicExpiresAt is the time after which a public note stops being
+	// accessible through its share link. A nil value means no expiry.
+	PublicExpiresAt *time.Time `json:"-"`
+	ViewCount       int        `json:"-" gorm:"default:0"`
+	// AuthorID and EditorID are the users who created and last edited the note.
+	// They differ from UserID if the note is in a book shared with other users.
+	Author   User `json:"-"`
+	AuthorID int  `json:"-" gorm:"index"`
+	Editor   User `json:"-"`
+	EditorID int  `json:"-"`
 }
 
 // User is a model for a user
@@ -74,6 +87,13 @@ type User struct {
 	APIKey           string     `json:"-" gorm:"index"`                 // Deprecated
 	Name             string     `json:"name"`                           // Deprecated
 	Encrypted        bool       `json:"encrypted" gorm:"default:false"` // Deprecated
+	OrganizationID   int        `json:"-" gorm:"index"`
+	Admin            bool       `json:"-" gorm:"default:false"`
+	DisabledAt       *time.Time `json:"-"`
+	// FullSyncBefore is the unix timestamp before which the clients of the user must
+	// perform a full sync rather than an incremental sync, for instance because the
+	// data of the user was restored from an archive
+	FullSyncBefore int64 `json:"-" gorm:"default:0"`
 }
 
 // Account is a model for an account
@@ -91,6 +111,38 @@ type Account struct {
 	AuthKeyHash        string
 	Salt               string
 	CipherKeyEnc       string
+	// TOTPSecret is the secret of the two-factor authentication. It is set when the
+	// enrollment begins, and the two-factor authentication is enabled once a code is verified.
+	TOTPSecret  string `json:"-"`
+	TOTPEnabled bool   `gorm:"default:false"`
+	// TOTPLastStep is the time step of the last code used, to prevent the reuse of a code
+	TOTPLastStep int64 `json:"-" gorm:"default:0"`
+	// OIDCIssuer and OIDCSubject identify the user at the OpenID Connect provider
+	// with which the account signs in with single sign-on
+	OIDCIssuer  string `json:"-"`
+	OIDCSubject string `json:"-" gorm:"index"`
+}
+
+// AuditEvent is a record of an account or security event of a user
+type AuditEvent struct {
+	Model
+	// UserID is the id of the user whose account the event concerns
+	UserID int `gorm:"index"`
+	// ActorID is the id of the user who caused the event. It is different from
+	// UserID if an administrator acted on the account.
+	ActorID   int
+	Type      string `gorm:"index"`
+	IPAddress string
+	UserAgent string
+}
+
+// RecoveryCode is a single-use code with which a user can sign in in place of
+// a two-factor authentication code
+type RecoveryCode struct {
+	Model
+	UserID   int    `gorm:"index"`
+	CodeHash string `gorm:"index"`
+	UsedAt   *time.Time
 }
 
 // Token is a model for a token
@@ -119,10 +171,24 @@ type EmailPreference struct {
 // Session represents a user session
 type Session struct {
 	Model
+	UUID       string `gorm:"type:uuid;index;default:uuid_generate_v4()"`
 	UserID     int    `gorm:"index"`
 	Key        string `gorm:"index"`
 	LastUsedAt time.Time
 	ExpiresAt  time.Time
+	// AuthenticatedAt is the time at which the user signed in. A session that
+	// replaces another session keeps the time of the session it replaces.
+	AuthenticatedAt *time.Time
+	// DeviceName is the name of the device on which the session was created
+	DeviceName string
+	// ClientType is the kind of the client that created the session, such as
+	// web or cli
+	ClientType string
+	// IPAddress is the address of the client that created the session
+	IPAddress string
+	// TOTPEnrollmentRequired is true if the session can only be used to enable
+	// the two-factor authentication required by the organization of the user
+	TOTPEnrollmentRequired bool `gorm:"default:false"`
 }
 
 // Digest is a digest of notes
@@ -154,3 +220,134 @@ type RepetitionRule struct {
 	Books      []Book `gorm:"many2many:repetition_rule_books;"`
 	NoteCount  int    `json:"note_count"`
 }
+
+// Webhook is an endpoint to which events about the user's data are delivered
+type Webhook struct {
+	Model
+	UUID    string         `json:"uuid" gorm:"type:uuid;index;default:uuid_generate_v4()"`
+	UserID  int            `json:"user_id" gorm:"index"`
+	URL     string         `json:"url"`
+	Secret  string         `json:"-"`
+	Events  pq.StringArray `json:"events" gorm:"type:text[]"`
+	Enabled bool           `json:"enabled" gorm:"default:true"`
+}
+
+// WebhookDelivery is an attempt to deliver an event to a webhook
+type WebhookDelivery struct {
+	Model
+	UUID          string     `json:"uuid" gorm:"type:uuid;index;default:uuid_generate_v4()"`
+	WebhookID     int        `json:"-" gorm:"index"`
+	Event         string     `json:"event"`
+	Payload       string     `json:"payload" gorm:"type:text"`
+	Status        string     `json:"status" gorm:"index"`
+	Attempts      int        `json:"attempts" gorm:"default:0"`
+	ResponseCode  int        `json:"response_code"`
+	Error         string     `json:"error"`
+	NextAttemptAt *time.Time `json:"next_attempt_at" gorm:"index"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+}
+
+// BookMember is a membership of a user in a book owned by another user
+type BookMember struct {
+	Model
+	BookID int    `gorm:"unique_index:idx_book_members_book_id_user_id"`
+	Book   Book   `json:"-"`
+	UserID int    `gorm:"unique_index:idx_book_members_book_id_user_id;index"`
+	User   User   `json:"-"`
+	Role   string `json:"role"`
+}
+
+// BookInvitation is an invitation sent by email for a user to become a member of a book
+type BookInvitation struct {
+	Model
+	UUID       string `json:"uuid" gorm:"type:uuid;index;default:uuid_generate_v4()"`
+	BookID     int    `gorm:"index"`
+	Book       Book   `json:"-"`
+	InviterID  int
+	Email      string `gorm:"index"`
+	Role       string
+	Token      string `json:"-" gorm:"index"`
+	AcceptedAt *time.Time
+	ExpiresAt  *time.Time
+}
+
+// SharedUSN is the update sequence number of a shared book or note in the
+// sequence of a member who does not own it. It allows members to sync the
+// content of shared books without breaking the per-user USN model.
+type SharedUSN struct {
+	Model
+	UserID int    `gorm:"unique_index:idx_shared_usns_user_id_type_uuid"`
+	Type   string `gorm:"unique_index:idx_shared_usns_user_id_type_uuid"`
+	UUID   string `gorm:"unique_index:idx_shared_usns_user_id_type_uuid;type:uuid"`
+	USN    int    `gorm:"index"`
+}
+
+// Organization is a group of users on a self-hosted instance that share
+// a signup and security policy
+type Organization struct {
+	Model
+	UUID                  string         `json:"uuid" gorm:"type:uuid;index;default:uuid_generate_v4()"`
+	Name                  string         `json:"name"`
+	AllowedDomains        pq.StringArray `json:"allowed_domains" gorm:"type:text[]"`
+	InviteOnly            bool           `json:"invite_only" gorm:"default:false"`
+	MinClientKDFIteration int            `json:"min_client_kdf_iteration" gorm:"default:0"`
+	DisablePublicNotes    bool           `json:"disable_public_notes" gorm:"default:false"`
+	RequireTOTP           bool           `json:"require_totp" gorm:"default:false"`
+}
+
+// OrganizationInvitation is an invitation sent by email for a person to sign up
+// as a member of an organization
+type OrganizationInvitation struct {
+	Model
+	UUID           string `json:"uuid" gorm:"type:uuid;index;default:uuid_generate_v4()"`
+	OrganizationID int    `gorm:"index"`
+	InviterID      int
+	Email          string `gorm:"index"`
+	Token          string `json:"-" gorm:"index"`
+	AcceptedAt     *time.Time
+}
+
+// RateLimitCounter is the number of requests made for a key in a window of time
+type RateLimitCounter struct {
+	Model
+	Key         string    `gorm:"unique_index:idx_rate_limit_counters_key_window_start"`
+	WindowStart time.Time `gorm:"unique_index:idx_rate_limit_counters_key_window_start"`
+	ExpiresAt   time.Time `gorm:"index"`
+	Count       int       `gorm:"default:0"`
+}
+
+// Attachment is a file attached to a note. Its content is stored in the blob
+// storage under Hash, and is shared by all attachments with the same content.
+type Attachment struct {
+	Model
+	UUID     string `json:"uuid" gorm:"type:uuid;index;default:uuid_generate_v4()"`
+	NoteUUID string `json:"note_uuid" gorm:"index;type:uuid"`
+	// UserID is the owner of the note, whose quota the attachment counts against
+	UserID      int    `json:"-" gorm:"index"`
+	UploaderID  int    `json:"-"`
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Hash        string `json:"hash" gorm:"index"`
+}
+
+// NoteLink is a link from a note to another note, written in the body of the
+// source as [[note-uuid]] or [[book/title]]. It refers to the target by uuid so
+// that it keeps working after the target is moved or its book is renamed.
+type NoteLink struct {
+	Model
+	SourceUUID string `json:"source_uuid" gorm:"index;type:uuid"`
+	TargetUUID string `json:"target_uuid" gorm:"index;type:uuid"`
+	// Text is the text of the link between the brackets
+	Text string `json:"text"`
+}
+
+// OIDCAuthRequest is a pending signin with the OpenID Connect provider. It keeps
+// the secrets of the request until the provider redirects the user back.
+type OIDCAuthRequest struct {
+	Model
+	State        string `gorm:"index"`
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
@@ -0,0 +1,78 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package entitlement decides the features to which users have access
+package entitlement
+
+import (
+	"github.com/dnote/dnote/pkg/server/database"
+)
+
+// Feature is a set of functionalities that requires an entitlement
+type Feature string
+
+const (
+	// FeaturePro represents the functionalities of Dnote Pro, such as sync,
+	// spaced repetition and sharing
+	FeaturePro Feature = "pro"
+)
+
+// Policy decides whether a user is entitled to a feature
+type Policy interface {
+	Entitled(user database.User, feature Feature) bool
+}
+
+// subscriptionPolicy entitles the users with a paid subscription to all features
+type subscriptionPolicy struct{}
+
+func (p subscriptionPolicy) Entitled(user database.User, feature Feature) bool {
+	switch feature {
+	case FeaturePro:
+		return user.Cloud
+	default:
+		return false
+	}
+}
+
+// NewSubscriptionPolicy returns a policy that entitles users based on their
+// subscriptions. It is used by the hosted service which handles billing.
+func NewSubscriptionPolicy() Policy {
+	return subscriptionPolicy{}
+}
+
+// allPolicy entitles every user to every feature
+type allPolicy struct{}
+
+func (p allPolicy) Entitled(user database.User, feature Feature) bool {
+	return true
+}
+
+// NewSelfHostedPolicy returns a policy that entitles every user to every
+// feature. It is used by self-hosted instances which do not handle billing.
+func NewSelfHostedPolicy() Policy {
+	return allPolicy{}
+}
+
+// New returns the policy for the given hosting mode
+func New(selfHosted bool) Policy {
+	if selfHosted {
+		return NewSelfHostedPolicy()
+	}
+
+	return NewSubscriptionPolicy()
+}
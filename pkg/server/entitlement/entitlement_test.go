@@ -0,0 +1,70 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package entitlement
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/server/database"
+)
+
+func TestSubscriptionPolicy(t *testing.T) {
+	testCases := []struct {
+		cloud    bool
+		feature  Feature
+		expected bool
+	}{
+		{true, FeaturePro, true},
+		{false, FeaturePro, false},
+		{true, Feature("unknown"), false},
+	}
+
+	p := NewSubscriptionPolicy()
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("cloud %t feature %s", tc.cloud, tc.feature), func(t *testing.T) {
+			user := database.User{Cloud: tc.cloud}
+
+			assert.Equal(t, p.Entitled(user, tc.feature), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestSelfHostedPolicy(t *testing.T) {
+	testCases := []struct {
+		cloud   bool
+		feature Feature
+	}{
+		{true, FeaturePro},
+		{false, FeaturePro},
+		{false, Feature("unknown")},
+	}
+
+	p := NewSelfHostedPolicy()
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("cloud %t feature %s", tc.cloud, tc.feature), func(t *testing.T) {
+			user := database.User{Cloud: tc.cloud}
+
+			assert.Equal(t, p.Entitled(user, tc.feature), true, "result mismatch")
+		})
+	}
+}
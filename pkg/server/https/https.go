@@ -0,0 +1,166 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package https provides the TLS configuration of the server, using either a
+// certificate on disk or certificates obtained automatically over ACME
+package https
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/dnote/dnote/pkg/server/config"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Setup is the configuration of the HTTPS and HTTP listeners of the server
+type Setup struct {
+	// TLSConfig is the configuration of the HTTPS listener
+	TLSConfig *tls.Config
+	// HTTPHandler serves plain HTTP requests by redirecting them to HTTPS. If ACME
+	// is used, it also answers the HTTP challenges.
+	HTTPHandler http.Handler
+}
+
+// RedirectHandler returns a handler that redirects requests to the same URL over
+// HTTPS on the given port
+func RedirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+
+		u := url.URL{
+			Scheme:   "https",
+			Host:     host,
+			Path:     r.URL.Path,
+			RawQuery: r.URL.RawQuery,
+		}
+
+		// preserve the method and the body of non-GET requests
+		statusCode := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			statusCode = http.StatusPermanentRedirect
+		}
+
+		http.Redirect(w, r, u.String(), statusCode)
+	})
+}
+
+func newTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+// newCertSetup returns a setup that serves the certificate on disk
+func newCertSetup(c config.TLSConfig, httpsPort string) (Setup, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return Setup{}, errors.Wrap(err, "loading certificate")
+	}
+
+	tlsConfig := newTLSConfig()
+	tlsConfig.Certificates = []tls.Certificate{cert}
+
+	return Setup{
+		TLSConfig:   tlsConfig,
+		HTTPHandler: RedirectHandler(httpsPort),
+	}, nil
+}
+
+// newACMEClient returns a client of the ACME directory in the configuration
+func newACMEClient(c config.ACMEConfig) (*acme.Client, error) {
+	client := &acme.Client{
+		DirectoryURL: c.DirectoryURL,
+	}
+	if client.DirectoryURL == "" {
+		client.DirectoryURL = acme.LetsEncryptURL
+	}
+
+	if c.CAFile != "" {
+		b, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading CA file")
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("no certificate found in %s", c.CAFile)
+		}
+
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		}
+	}
+
+	return client, nil
+}
+
+// newACMESetup returns a setup that obtains and renews certificates over ACME,
+// keeping them in the cache directory
+func newACMESetup(c config.ACMEConfig, httpsPort string) (Setup, error) {
+	client, err := newACMEClient(c)
+	if err != nil {
+		return Setup{}, errors.Wrap(err, "initializing ACME client")
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(c.CacheDir),
+		HostPolicy: autocert.HostWhitelist(c.Domains...),
+		Email:      c.Email,
+		Client:     client,
+	}
+
+	tlsConfig := newTLSConfig()
+	tlsConfig.GetCertificate = m.GetCertificate
+	// answer the TLS-ALPN challenges
+	tlsConfig.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+
+	return Setup{
+		TLSConfig:   tlsConfig,
+		HTTPHandler: m.HTTPHandler(RedirectHandler(httpsPort)),
+	}, nil
+}
+
+// New returns the setup for the given configuration. The HTTPS listener is
+// expected to listen on httpsPort.
+func New(c config.TLSConfig, httpsPort string) (Setup, error) {
+	if len(c.ACME.Domains) > 0 {
+		return newACMESetup(c.ACME, httpsPort)
+	}
+	if c.CertFile != "" {
+		return newCertSetup(c, httpsPort)
+	}
+
+	return Setup{}, errors.New("neither a certificate nor ACME is configured")
+}
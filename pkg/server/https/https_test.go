@@ -0,0 +1,268 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package https
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/server/config"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme"
+)
+
+// writeCert writes a self-signed certificate and its key to the directory
+func writeCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "generating key"))
+	}
+
+	tpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tpl, &tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating certificate"))
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "marshalling key"))
+	}
+
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(errors.Wrap(err, "writing certificate"))
+	}
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(errors.Wrap(err, "writing key"))
+	}
+
+	return certPath, keyPath
+}
+
+func TestRedirectHandler(t *testing.T) {
+	testCases := []struct {
+		method             string
+		target             string
+		httpsPort          string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{
+			method:             "GET",
+			target:             "http://example.com/notes?q=foo",
+			httpsPort:          "443",
+			expectedStatusCode: http.StatusMovedPermanently,
+			expectedLocation:   "https://example.com/notes?q=foo",
+		},
+		{
+			method:             "GET",
+			target:             "http://example.com:8080/",
+			httpsPort:          "8443",
+			expectedStatusCode: http.StatusMovedPermanently,
+			expectedLocation:   "https://example.com:8443/",
+		},
+		{
+			method:             "POST",
+			target:             "http://example.com/api/v3/signin",
+			httpsPort:          "443",
+			expectedStatusCode: http.StatusPermanentRedirect,
+			expectedLocation:   "https://example.com/api/v3/signin",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s %s", tc.method, tc.target), func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.target, nil)
+			w := httptest.NewRecorder()
+
+			RedirectHandler(tc.httpsPort).ServeHTTP(w, r)
+
+			assert.Equal(t, w.Code, tc.expectedStatusCode, "status code mismatch")
+			assert.Equal(t, w.Header().Get("Location"), tc.expectedLocation, "location mismatch")
+		})
+	}
+}
+
+func TestNew_Certificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dnote-https")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "making temp dir"))
+	}
+	defer os.RemoveAll(dir)
+
+	certPath, keyPath := writeCert(t, dir)
+
+	s, err := New(config.TLSConfig{CertFile: certPath, KeyFile: keyPath}, "443")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "setting up"))
+	}
+
+	assert.Equal(t, len(s.TLSConfig.Certificates), 1, "certificate count mismatch")
+
+	// serve HTTPS with the certificate
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	server.TLS = s.TLSConfig
+	server.StartTLS()
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	b, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.AppendCertsFromPEM(b)
+	client := server.Client()
+	client.Transport.(*http.Transport).TLSClientConfig.RootCAs = pool
+	client.Transport.(*http.Transport).TLSClientConfig.ServerName = "localhost"
+
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "making request"))
+	}
+	defer res.Body.Close()
+	assert.Equal(t, res.StatusCode, http.StatusOK, "status code mismatch")
+}
+
+func TestNew_CertificateNotFound(t *testing.T) {
+	_, err := New(config.TLSConfig{CertFile: "/nonexistent/cert.pem", KeyFile: "/nonexistent/key.pem"}, "443")
+
+	assert.NotEqual(t, err, nil, "error mismatch")
+}
+
+func TestNew_ACME(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dnote-https")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "making temp dir"))
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := New(config.TLSConfig{
+		ACME: config.ACMEConfig{
+			Domains:  []string{"example.com"},
+			CacheDir: dir,
+		},
+	}, "443")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "setting up"))
+	}
+
+	if s.TLSConfig.GetCertificate == nil {
+		t.Error("GetCertificate is not set")
+	}
+	assert.DeepEqual(t, s.TLSConfig.NextProtos, []string{"h2", "http/1.1", acme.ALPNProto}, "NextProtos mismatch")
+
+	t.Run("redirect", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "http://example.com/notes", nil)
+		w := httptest.NewRecorder()
+		s.HTTPHandler.ServeHTTP(w, r)
+
+		assert.Equal(t, w.Code, http.StatusMovedPermanently, "status code mismatch")
+		assert.Equal(t, w.Header().Get("Location"), "https://example.com/notes", "location mismatch")
+	})
+
+	t.Run("challenge", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "http://example.com/.well-known/acme-challenge/unknown-token", nil)
+		w := httptest.NewRecorder()
+		s.HTTPHandler.ServeHTTP(w, r)
+
+		// the challenge is answered by the manager rather than redirected
+		assert.Equal(t, w.Code, http.StatusNotFound, "status code mismatch")
+	})
+}
+
+func TestNewACMEClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dnote-https")
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "making temp dir"))
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("default directory", func(t *testing.T) {
+		client, err := newACMEClient(config.ACMEConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, client.DirectoryURL, acme.LetsEncryptURL, "DirectoryURL mismatch")
+	})
+
+	t.Run("local directory", func(t *testing.T) {
+		// a stand-in for a local ACME server such as pebble, whose certificate is self-signed
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("{}"))
+		}))
+		defer server.Close()
+
+		caPath := filepath.Join(dir, "ca.pem")
+		ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		if err := ioutil.WriteFile(caPath, ca, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		client, err := newACMEClient(config.ACMEConfig{
+			DirectoryURL: server.URL,
+			CAFile:       caPath,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, client.DirectoryURL, server.URL, "DirectoryURL mismatch")
+
+		res, err := client.HTTPClient.Get(server.URL)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "connecting to the directory"))
+		}
+		res.Body.Close()
+	})
+
+	t.Run("invalid CA file", func(t *testing.T) {
+		caPath := filepath.Join(dir, "invalid.pem")
+		if err := ioutil.WriteFile(caPath, []byte("foo"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := newACMEClient(config.ACMEConfig{CAFile: caPath})
+
+		assert.NotEqual(t, err, nil, "error mismatch")
+	})
+}
@@ -19,34 +19,148 @@
 package job
 
 import (
-	"log"
+	"context"
+	"sync"
+	"time"
 
 	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/api/operations"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/entitlement"
 	"github.com/dnote/dnote/pkg/server/job/repetition"
+	"github.com/dnote/dnote/pkg/server/log"
+	"github.com/dnote/dnote/pkg/server/mailer"
+	"github.com/dnote/dnote/pkg/server/metrics"
+	"github.com/dnote/dnote/pkg/server/webhook"
 	"github.com/pkg/errors"
 	"github.com/robfig/cron"
 )
 
-func scheduleJob(c *cron.Cron, spec string, cmd func()) {
+var (
+	jobRunsTotal = metrics.NewCounterVec(
+		"dnote_job_runs_total",
+		"Total number of background job runs",
+		"job", "result",
+	)
+	jobDuration = metrics.NewHistogramVec(
+		"dnote_job_duration_seconds",
+		"Duration of background job runs in seconds",
+		metrics.DefBuckets,
+		"job",
+	)
+)
+
+// runJob runs the job with the given name, and logs and records the result
+func runJob(name string, cmd func() error) {
+	start := time.Now()
+	err := cmd()
+	jobDuration.Observe(time.Since(start).Seconds(), name)
+
+	if err != nil {
+		jobRunsTotal.Inc(name, "error")
+		log.WithFields(log.Fields{
+			"job": name,
+		}).ErrorWrap(err, "running job")
+		return
+	}
+
+	jobRunsTotal.Inc(name, "success")
+}
+
+// Runner runs the background tasks on a schedule
+type Runner struct {
+	cron    *cron.Cron
+	mtx     sync.Mutex
+	wg      sync.WaitGroup
+	stopped bool
+}
+
+// NewRunner returns a new runner of the background tasks. The given policy decides
+// the users for whom the tasks that require entitlements are performed, and the
+// given mailer sends the emails. The audit events older than the given retention
+// are deleted, unless the retention is zero.
+func NewRunner(p entitlement.Policy, m *mailer.Mailer, auditLogRetention time.Duration) *Runner {
+	r := &Runner{
+		cron: cron.New(),
+	}
+
+	cl := clock.New()
+	r.schedule("* * * * *", "repetition", func() error { return repetition.Do(cl, p, m) })
+	r.schedule("* * * * *", "webhook", func() error { return webhook.Do(cl) })
+	if auditLogRetention > 0 {
+		r.schedule("0 0 * * *", "audit_log", func() error { return purgeAuditLog(cl, auditLogRetention) })
+	}
+
+	return r
+}
+
+// purgeAuditLog deletes the audit events older than the given retention
+func purgeAuditLog(c clock.Clock, retention time.Duration) error {
+	count, err := operations.PurgeAuditEvents(database.DBConn, c.Now().Add(-retention))
+	if err != nil {
+		return errors.Wrap(err, "purging audit events")
+	}
+
+	log.WithFields(log.Fields{
+		"count": count,
+	}).Info("Purged audit events")
+
+	return nil
+}
+
+func (r *Runner) schedule(spec, name string, cmd func() error) {
 	s, err := cron.ParseStandard(spec)
 	if err != nil {
 		panic(errors.Wrap(err, "parsing schedule"))
 	}
 
-	c.Schedule(s, cron.FuncJob(cmd))
+	r.cron.Schedule(s, cron.FuncJob(func() {
+		r.run(name, cmd)
+	}))
 }
 
-// Run starts the background tasks and blocks forever.
-func Run() {
-	log.Println("Started background tasks")
+// run runs the job unless the runner is stopped, keeping track of the running jobs
+func (r *Runner) run(name string, cmd func() error) {
+	r.mtx.Lock()
+	if r.stopped {
+		r.mtx.Unlock()
+		return
+	}
+	r.wg.Add(1)
+	r.mtx.Unlock()
 
-	cl := clock.New()
+	defer r.wg.Done()
 
-	// Schedule jobs
-	c := cron.New()
-	scheduleJob(c, "* * * * *", func() { repetition.Do(cl) })
-	c.Start()
+	runJob(name, cmd)
+}
+
+// Start starts the background tasks in the background
+func (r *Runner) Start() {
+	r.cron.Start()
+
+	log.Info("Started background tasks")
+}
 
-	// Block forever
-	select {}
+// Stop stops scheduling the background tasks and waits for the running ones to
+// finish, or for the context to be done
+func (r *Runner) Stop(ctx context.Context) error {
+	r.cron.Stop()
+
+	r.mtx.Lock()
+	r.stopped = true
+	r.mtx.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Info("Stopped background tasks")
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "waiting for running jobs")
+	}
 }
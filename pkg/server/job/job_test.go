@@ -0,0 +1,68 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package job
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/robfig/cron"
+)
+
+func TestRunnerStop(t *testing.T) {
+	r := &Runner{cron: cron.New()}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go r.run("test", func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+
+	// the context is done before the running job finishes
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := r.Stop(ctx)
+	assert.NotEqual(t, err, nil, "error mismatch while the job is running")
+
+	close(release)
+
+	err = r.Stop(context.Background())
+	assert.Equal(t, err, nil, "error mismatch after the job finished")
+}
+
+func TestRunnerStop_SkipsNewJobs(t *testing.T) {
+	r := &Runner{cron: cron.New()}
+
+	if err := r.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ran := false
+	r.run("test", func() error {
+		ran = true
+		return nil
+	})
+
+	assert.Equal(t, ran, false, "job ran after the runner stopped")
+}
@@ -24,6 +24,7 @@ import (
 
 	"github.com/dnote/dnote/pkg/clock"
 	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/entitlement"
 	"github.com/dnote/dnote/pkg/server/log"
 	"github.com/dnote/dnote/pkg/server/mailer"
 	"github.com/jinzhu/gorm"
@@ -118,7 +119,7 @@ func build(tx *gorm.DB, rule database.RepetitionRule) (database.Digest, error) {
 	return digest, nil
 }
 
-func notify(now time.Time, user database.User, digest database.Digest, rule database.RepetitionRule) error {
+func notify(now time.Time, user database.User, digest database.Digest, rule database.RepetitionRule, m *mailer.Mailer) error {
 	db := database.DBConn
 
 	var account database.Account
@@ -138,7 +139,7 @@ func notify(now time.Time, user database.User, digest database.Digest, rule data
 		return errors.Wrap(err, "making email")
 	}
 
-	err = email.Send()
+	err = m.Send(email)
 	if err != nil {
 		return errors.Wrap(err, "sending email")
 	}
@@ -174,7 +175,7 @@ func touchTimestamp(tx *gorm.DB, rule database.RepetitionRule, now time.Time) er
 	return nil
 }
 
-func process(now time.Time, rule database.RepetitionRule) error {
+func process(now time.Time, rule database.RepetitionRule, p entitlement.Policy, m *mailer.Mailer) error {
 	log.WithFields(log.Fields{
 		"uuid": rule.UUID,
 	}).Info("processing repetition")
@@ -190,7 +191,7 @@ func process(now time.Time, rule database.RepetitionRule) error {
 	if err := tx.Where("id = ?", rule.UserID).First(&user).Error; err != nil {
 		return errors.Wrap(err, "getting user")
 	}
-	if !user.Cloud {
+	if !p.Entitled(user, entitlement.FeaturePro) {
 		log.WithFields(log.Fields{
 			"user_id": user.ID,
 		}).Info("Skipping repetition due to lack of subscription")
@@ -213,7 +214,7 @@ func process(now time.Time, rule database.RepetitionRule) error {
 		return errors.Wrap(err, "committing transaction")
 	}
 
-	if err := notify(now, user, digest, rule); err != nil {
+	if err := notify(now, user, digest, rule, m); err != nil {
 		return errors.Wrap(err, "notifying user")
 	}
 
@@ -224,8 +225,10 @@ func process(now time.Time, rule database.RepetitionRule) error {
 	return nil
 }
 
-// Do creates spaced repetitions and delivers the results based on the rules
-func Do(c clock.Clock) error {
+// Do creates spaced repetitions and delivers the results based on the rules using
+// the given mailer. Repetitions are only created for the users entitled to them by
+// the given policy.
+func Do(c clock.Clock, p entitlement.Policy, m *mailer.Mailer) error {
 	now := c.Now().UTC()
 
 	rules, err := getEligibleRules(now)
@@ -240,7 +243,7 @@ func Do(c clock.Clock) error {
 	}).Info("processing rules")
 
 	for _, rule := range rules {
-		if err := process(now, rule); err != nil {
+		if err := process(now, rule, p, m); err != nil {
 			log.WithFields(log.Fields{
 				"rule uuid": rule.UUID,
 			}).ErrorWrap(err, "Could not process the repetition rule")
@@ -250,3 +253,39 @@ func Do(c clock.Clock) error {
 
 	return nil
 }
+
+// ErrNotEntitled is returned if a user is not entitled to repetitions
+var ErrNotEntitled = errors.New("the user is not entitled to repetitions")
+
+// DoUser creates spaced repetitions for all enabled rules of the given user and
+// delivers them right away, regardless of the schedules of the rules. The schedules
+// are not affected. It returns the number of the repetitions that were delivered.
+func DoUser(c clock.Clock, p entitlement.Policy, m *mailer.Mailer, user database.User) (int, error) {
+	if !p.Entitled(user, entitlement.FeaturePro) {
+		return 0, ErrNotEntitled
+	}
+
+	now := c.Now().UTC()
+	db := database.DBConn
+
+	var rules []database.RepetitionRule
+	if err := db.Where("user_id = ? AND enabled", user.ID).Order("id ASC").Find(&rules).Error; err != nil {
+		return 0, errors.Wrap(err, "getting repetition rules")
+	}
+
+	var count int
+	for _, rule := range rules {
+		digest, err := build(db, rule)
+		if err != nil {
+			return count, errors.Wrapf(err, "building repetition for the rule %s", rule.UUID)
+		}
+
+		if err := notify(now, user, digest, rule, m); err != nil {
+			return count, errors.Wrapf(err, "notifying user for the rule %s", rule.UUID)
+		}
+
+		count++
+	}
+
+	return count, nil
+}
@@ -19,6 +19,7 @@
 package repetition
 
 import (
+	"fmt"
 	"sort"
 	"testing"
 	"time"
@@ -26,7 +27,10 @@ import (
 	"github.com/dnote/dnote/pkg/assert"
 	"github.com/dnote/dnote/pkg/clock"
 	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/entitlement"
+	"github.com/dnote/dnote/pkg/server/mailer"
 	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
 )
 
 func init() {
@@ -82,60 +86,60 @@ func TestDo(t *testing.T) {
 		// Test
 		// 1 day later
 		c.SetNow(time.Date(2009, time.November, 2, 12, 2, 1, 0, time.UTC))
-		Do(c)
+		Do(c, entitlement.NewSubscriptionPolicy(), mailer.New(mailer.Config{}))
 		assertLastActive(t, r1.UUID, int64(0))
 		assertRepetitionCount(t, r1, 0)
 
 		// 2 days later
 		c.SetNow(time.Date(2009, time.November, 3, 12, 2, 1, 0, time.UTC))
-		Do(c)
+		Do(c, entitlement.NewSubscriptionPolicy(), mailer.New(mailer.Config{}))
 		assertLastActive(t, r1.UUID, int64(0))
 		assertRepetitionCount(t, r1, 0)
 
 		// 3 days later - should be processed
 		c.SetNow(time.Date(2009, time.November, 4, 12, 1, 1, 0, time.UTC))
-		Do(c)
+		Do(c, entitlement.NewSubscriptionPolicy(), mailer.New(mailer.Config{}))
 		assertLastActive(t, r1.UUID, int64(0))
 		assertRepetitionCount(t, r1, 0)
 
 		c.SetNow(time.Date(2009, time.November, 4, 12, 2, 1, 0, time.UTC))
-		Do(c)
+		Do(c, entitlement.NewSubscriptionPolicy(), mailer.New(mailer.Config{}))
 		assertLastActive(t, r1.UUID, int64(1257336120000))
 		assertRepetitionCount(t, r1, 1)
 
 		c.SetNow(time.Date(2009, time.November, 4, 12, 3, 1, 0, time.UTC))
-		Do(c)
+		Do(c, entitlement.NewSubscriptionPolicy(), mailer.New(mailer.Config{}))
 		assertLastActive(t, r1.UUID, int64(1257336120000))
 		assertRepetitionCount(t, r1, 1)
 
 		// 4 day later
 		c.SetNow(time.Date(2009, time.November, 5, 12, 2, 1, 0, time.UTC))
-		Do(c)
+		Do(c, entitlement.NewSubscriptionPolicy(), mailer.New(mailer.Config{}))
 		assertLastActive(t, r1.UUID, int64(1257336120000))
 		assertRepetitionCount(t, r1, 1)
 		// 5 days later
 		c.SetNow(time.Date(2009, time.November, 6, 12, 2, 1, 0, time.UTC))
-		Do(c)
+		Do(c, entitlement.NewSubscriptionPolicy(), mailer.New(mailer.Config{}))
 		assertLastActive(t, r1.UUID, int64(1257336120000))
 		assertRepetitionCount(t, r1, 1)
 		// 6 days later - should be processed
 		c.SetNow(time.Date(2009, time.November, 7, 12, 2, 1, 0, time.UTC))
-		Do(c)
+		Do(c, entitlement.NewSubscriptionPolicy(), mailer.New(mailer.Config{}))
 		assertLastActive(t, r1.UUID, int64(1257595320000))
 		assertRepetitionCount(t, r1, 2)
 		// 7 days later
 		c.SetNow(time.Date(2009, time.November, 8, 12, 2, 1, 0, time.UTC))
-		Do(c)
+		Do(c, entitlement.NewSubscriptionPolicy(), mailer.New(mailer.Config{}))
 		assertLastActive(t, r1.UUID, int64(1257595320000))
 		assertRepetitionCount(t, r1, 2)
 		// 8 days later
 		c.SetNow(time.Date(2009, time.November, 9, 12, 2, 1, 0, time.UTC))
-		Do(c)
+		Do(c, entitlement.NewSubscriptionPolicy(), mailer.New(mailer.Config{}))
 		assertLastActive(t, r1.UUID, int64(1257595320000))
 		assertRepetitionCount(t, r1, 2)
 		// 9 days later - should be processed
 		c.SetNow(time.Date(2009, time.November, 10, 12, 2, 1, 0, time.UTC))
-		Do(c)
+		Do(c, entitlement.NewSubscriptionPolicy(), mailer.New(mailer.Config{}))
 		assertLastActive(t, r1.UUID, int64(1257854520000))
 		assertRepetitionCount(t, r1, 3)
 	})
@@ -170,13 +174,149 @@ func TestDo_Disabled(t *testing.T) {
 	// Execute
 	c := clock.NewMock()
 	c.SetNow(time.Date(2009, time.November, 4, 12, 2, 0, 0, time.UTC))
-	Do(c)
+	Do(c, entitlement.NewSubscriptionPolicy(), mailer.New(mailer.Config{}))
 
 	// Test
 	assertLastActive(t, r1.UUID, int64(0))
 	assertRepetitionCount(t, r1, 0)
 }
 
+func TestDo_Entitlement(t *testing.T) {
+	testCases := []struct {
+		policy        entitlement.Policy
+		expectedCount int
+	}{
+		{entitlement.NewSubscriptionPolicy(), 0},
+		{entitlement.NewSelfHostedPolicy(), 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%T", tc.policy), func(t *testing.T) {
+			defer testutils.ClearData()
+			db := database.DBConn
+
+			// Set up
+			user := testutils.SetupUserData()
+			testutils.MustExec(t, db.Model(&user).Update("cloud", false), "preparing user")
+
+			t0 := time.Date(2009, time.November, 1, 0, 0, 0, 0, time.UTC)
+			t1 := time.Date(2009, time.November, 4, 12, 2, 0, 0, time.UTC)
+			r1 := database.RepetitionRule{
+				Title:      "Rule 1",
+				Frequency:  (time.Hour * 24 * 3).Milliseconds(), // three days
+				Hour:       12,
+				Minute:     2,
+				LastActive: 0,
+				NextActive: t1.UnixNano() / int64(time.Millisecond),
+				UserID:     user.ID,
+				Enabled:    true,
+				BookDomain: database.BookDomainAll,
+				Model: database.Model{
+					CreatedAt: t0,
+					UpdatedAt: t0,
+				},
+			}
+			testutils.MustExec(t, db.Save(&r1), "preparing rule1")
+
+			// Execute
+			c := clock.NewMock()
+			c.SetNow(time.Date(2009, time.November, 4, 12, 2, 0, 0, time.UTC))
+			Do(c, tc.policy, mailer.New(mailer.Config{}))
+
+			// Test
+			assertRepetitionCount(t, r1, tc.expectedCount)
+		})
+	}
+}
+
+func TestDoUser(t *testing.T) {
+	t.Run("delivers the enabled rules regardless of the schedule", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		// Set up
+		user := testutils.SetupUserData()
+		t0 := time.Date(2009, time.November, 1, 0, 0, 0, 0, time.UTC)
+		t1 := time.Date(2009, time.November, 4, 12, 2, 0, 0, time.UTC)
+		r1 := database.RepetitionRule{
+			Title:      "Rule 1",
+			Frequency:  (time.Hour * 24 * 3).Milliseconds(), // three days
+			Hour:       12,
+			Minute:     2,
+			LastActive: 0,
+			NextActive: t1.UnixNano() / int64(time.Millisecond),
+			UserID:     user.ID,
+			Enabled:    true,
+			BookDomain: database.BookDomainAll,
+			Model: database.Model{
+				CreatedAt: t0,
+				UpdatedAt: t0,
+			},
+		}
+		testutils.MustExec(t, db.Save(&r1), "preparing rule1")
+		r2 := database.RepetitionRule{
+			Title:      "Rule 2",
+			Frequency:  (time.Hour * 24 * 3).Milliseconds(), // three days
+			Hour:       12,
+			Minute:     2,
+			LastActive: 0,
+			NextActive: t1.UnixNano() / int64(time.Millisecond),
+			UserID:     user.ID,
+			Enabled:    false,
+			BookDomain: database.BookDomainAll,
+			Model: database.Model{
+				CreatedAt: t0,
+				UpdatedAt: t0,
+			},
+		}
+		testutils.MustExec(t, db.Save(&r2), "preparing rule2")
+
+		// Execute
+		c := clock.NewMock()
+		c.SetNow(time.Date(2009, time.November, 2, 8, 0, 0, 0, time.UTC))
+		count, err := DoUser(c, entitlement.NewSubscriptionPolicy(), mailer.New(mailer.Config{}), user)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "executing"))
+		}
+
+		// Test
+		assert.Equal(t, count, 1, "count mismatch")
+		assertRepetitionCount(t, r1, 1)
+		assertRepetitionCount(t, r2, 0)
+		assertLastActive(t, r1.UUID, int64(0))
+
+		var rule database.RepetitionRule
+		testutils.MustExec(t, db.Where("uuid = ?", r1.UUID).First(&rule), "finding rule1")
+		assert.Equal(t, rule.NextActive, r1.NextActive, "NextActive mismatch")
+	})
+
+	t.Run("not entitled", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		// Set up
+		user := testutils.SetupUserData()
+		testutils.MustExec(t, db.Model(&user).Update("cloud", false), "preparing user")
+
+		r1 := database.RepetitionRule{
+			Title:      "Rule 1",
+			Frequency:  (time.Hour * 24 * 3).Milliseconds(), // three days
+			UserID:     user.ID,
+			Enabled:    true,
+			BookDomain: database.BookDomainAll,
+		}
+		testutils.MustExec(t, db.Save(&r1), "preparing rule1")
+
+		// Execute
+		count, err := DoUser(clock.NewMock(), entitlement.NewSubscriptionPolicy(), mailer.New(mailer.Config{}), user)
+
+		// Test
+		assert.Equal(t, err, ErrNotEntitled, "error mismatch")
+		assert.Equal(t, count, 0, "count mismatch")
+		assertRepetitionCount(t, r1, 0)
+	})
+}
+
 func TestDo_BalancedStrategy(t *testing.T) {
 	type testData struct {
 		User  database.User
@@ -266,7 +406,7 @@ func TestDo_BalancedStrategy(t *testing.T) {
 		c := clock.NewMock()
 
 		c.SetNow(time.Date(2009, time.November, 8, 21, 0, 0, 0, time.UTC))
-		Do(c)
+		Do(c, entitlement.NewSubscriptionPolicy(), mailer.New(mailer.Config{}))
 
 		// Test
 		assertLastActive(t, r1.UUID, int64(1257681600000))
@@ -322,7 +462,7 @@ func TestDo_BalancedStrategy(t *testing.T) {
 		c := clock.NewMock()
 
 		c.SetNow(time.Date(2009, time.November, 8, 21, 0, 1, 0, time.UTC))
-		Do(c)
+		Do(c, entitlement.NewSubscriptionPolicy(), mailer.New(mailer.Config{}))
 
 		// Test
 		assertLastActive(t, r1.UUID, int64(1257681600000))
@@ -377,7 +517,7 @@ func TestDo_BalancedStrategy(t *testing.T) {
 		c := clock.NewMock()
 
 		c.SetNow(time.Date(2009, time.November, 8, 21, 0, 0, 0, time.UTC))
-		Do(c)
+		Do(c, entitlement.NewSubscriptionPolicy(), mailer.New(mailer.Config{}))
 
 		// Test
 		assertLastActive(t, r1.UUID, int64(1257681600000))
@@ -27,12 +27,36 @@ import (
 	"github.com/pkg/errors"
 )
 
+// getRuleBookIDs returns the ids of the books of the rule with the given id, and
+// the books nested in them
 func getRuleBookIDs(ruleID int) ([]int, error) {
 	db := database.DBConn
-	var ret []int
-	if err := db.Table("repetition_rule_books").Select("book_id").Where("repetition_rule_id = ?", ruleID).Pluck("book_id", &ret).Error; err != nil {
+
+	rows, err := db.Raw(`WITH RECURSIVE rule_books(id, uuid) AS (
+			SELECT books.id, books.uuid::text
+			FROM books
+			INNER JOIN repetition_rule_books ON repetition_rule_books.book_id = books.id
+			WHERE repetition_rule_books.repetition_rule_id = ?
+			UNION
+			SELECT books.id, books.uuid::text
+			FROM books
+			INNER JOIN rule_books ON books.parent_uuid = rule_books.uuid
+		)
+		SELECT id FROM rule_books`, ruleID).Rows()
+	if err != nil {
 		return nil, errors.Wrap(err, "querying book_ids")
 	}
+	defer rows.Close()
+
+	ret := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.Wrap(err, "scanning a book_id")
+		}
+
+		ret = append(ret, id)
+	}
 
 	return ret, nil
 }
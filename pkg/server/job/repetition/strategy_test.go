@@ -112,3 +112,67 @@ func TestApplyBookDomain(t *testing.T) {
 		assert.DeepEqual(t, result, expected, "result mismatch")
 	})
 }
+
+func TestApplyBookDomain_nested(t *testing.T) {
+	defer testutils.ClearData()
+
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	b1 := database.Book{
+		UserID: user.ID,
+		Label:  "infra",
+	}
+	testutils.MustExec(t, db.Save(&b1), "preparing b1")
+	b2 := database.Book{
+		UserID:     user.ID,
+		Label:      "k8s",
+		ParentUUID: b1.UUID,
+	}
+	testutils.MustExec(t, db.Save(&b2), "preparing b2")
+	b3 := database.Book{
+		UserID: user.ID,
+		Label:  "golang",
+	}
+	testutils.MustExec(t, db.Save(&b3), "preparing b3")
+
+	n1 := database.Note{
+		UserID:   user.ID,
+		BookUUID: b1.UUID,
+	}
+	testutils.MustExec(t, db.Save(&n1), "preparing n1")
+	n2 := database.Note{
+		UserID:   user.ID,
+		BookUUID: b2.UUID,
+	}
+	testutils.MustExec(t, db.Save(&n2), "preparing n2")
+	n3 := database.Note{
+		UserID:   user.ID,
+		BookUUID: b3.UUID,
+	}
+	testutils.MustExec(t, db.Save(&n3), "preparing n3")
+
+	var n1Record, n2Record, n3Record database.Note
+	testutils.MustExec(t, db.Where("uuid = ?", n1.UUID).First(&n1Record), "finding n1")
+	testutils.MustExec(t, db.Where("uuid = ?", n2.UUID).First(&n2Record), "finding n2")
+	testutils.MustExec(t, db.Where("uuid = ?", n3.UUID).First(&n3Record), "finding n3")
+
+	rule := database.RepetitionRule{
+		UserID:     user.ID,
+		BookDomain: database.BookDomainIncluding,
+		Books:      []database.Book{b1},
+	}
+	testutils.MustExec(t, db.Save(&rule), "preparing rule")
+
+	conn, err := applyBookDomain(db, rule)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "executing").Error())
+	}
+
+	var result []database.Note
+	testutils.MustExec(t, conn.Order("id ASC").Find(&result), "finding notes")
+
+	// the notes in the nested book should be included
+	expected := []database.Note{n1Record, n2Record}
+	assert.DeepEqual(t, result, expected, "result mismatch")
+}
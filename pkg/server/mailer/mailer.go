@@ -23,10 +23,10 @@ import (
 	"bytes"
 	"fmt"
 	"html/template"
-	"os"
 	"path"
 
 	"github.com/aymerick/douceur/inliner"
+	"github.com/dnote/dnote/pkg/server/metrics"
 	"github.com/gobuffalo/packr/v2"
 	"github.com/pkg/errors"
 	"gopkg.in/gomail.v2"
@@ -38,6 +38,23 @@ type Email struct {
 	to      []string
 	subject string
 	Body    string
+	// templateName is the name of the template used for the body
+	templateName string
+}
+
+var emailsTotal = metrics.NewCounterVec(
+	"dnote_mailer_emails_total",
+	"Total number of emails processed by the mailer",
+	"type", "result",
+)
+
+// emailType returns the type of the email used in the metrics
+func (e *Email) emailType() string {
+	if e.templateName == "" {
+		return "unknown"
+	}
+
+	return e.templateName
 }
 
 var (
@@ -49,6 +66,10 @@ var (
 	EmailTypeWeeklyDigest = "digest"
 	// EmailTypeEmailVerification represents an email verification email
 	EmailTypeEmailVerification = "email_verification"
+	// EmailTypeBookInvitation represents an invitation to a shared book
+	EmailTypeBookInvitation = "book_invitation"
+	// EmailTypeOrganizationInvitation represents an invitation to an organization
+	EmailTypeOrganizationInvitation = "organization_invitation"
 )
 
 func getTemplatePath(templateDirPath, filename string) string {
@@ -109,10 +130,20 @@ func InitTemplates(srcDir *string) {
 	if err != nil {
 		panic(errors.Wrap(err, "initializing password reset template"))
 	}
+	bookInvitationTmpl, err := initTemplate(box, EmailTypeBookInvitation)
+	if err != nil {
+		panic(errors.Wrap(err, "initializing book invitation template"))
+	}
+	organizationInvitationTmpl, err := initTemplate(box, EmailTypeOrganizationInvitation)
+	if err != nil {
+		panic(errors.Wrap(err, "initializing organization invitation template"))
+	}
 
 	T[EmailTypeWeeklyDigest] = weeklyDigestTmpl
 	T[EmailTypeEmailVerification] = emailVerificationTmpl
 	T[EmailTypeResetPassword] = passwowrdResetTmpl
+	T[EmailTypeBookInvitation] = bookInvitationTmpl
+	T[EmailTypeOrganizationInvitation] = organizationInvitationTmpl
 }
 
 // NewEmail returns a pointer to an Email struct with the given data
@@ -124,30 +155,58 @@ func NewEmail(from string, to []string, subject string) *Email {
 	}
 }
 
+// Config is the configuration of the mailer
+type Config struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// Enabled makes the mailer send emails through the SMTP server. Otherwise,
+	// emails are printed to the standard output.
+	Enabled bool
+	// PrintBody prints the body of the emails that are not sent. It must not be
+	// set in production because the bodies contain secret tokens, such as the
+	// ones for resetting passwords.
+	PrintBody bool
+}
+
+// Mailer sends emails
+type Mailer struct {
+	config Config
+}
+
+// New returns a new mailer with the given configuration
+func New(c Config) *Mailer {
+	return &Mailer{config: c}
+}
+
 // Send sends the email
-func (e *Email) Send() error {
-	// If not production, never actually send an email
-	if os.Getenv("GO_ENV") != "PRODUCTION" {
-		fmt.Println("Not sending email because not production")
+func (m *Mailer) Send(e *Email) error {
+	if !m.config.Enabled {
+		fmt.Println("Not sending email because the mailer is not enabled")
 		fmt.Println(e.subject, e.to, e.from)
-		fmt.Println("Body", e.Body)
+		if m.config.PrintBody {
+			fmt.Println("Body", e.Body)
+		}
+		emailsTotal.Inc(e.emailType(), "skipped")
 		return nil
 	}
 
-	smtpPort := 465
+	msg := gomail.NewMessage()
+	msg.SetHeader("From", e.from)
+	msg.SetHeader("To", e.to...)
+	msg.SetHeader("Subject", e.subject)
+	msg.SetBody("text/html", e.Body)
 
-	m := gomail.NewMessage()
-	m.SetHeader("From", e.from)
-	m.SetHeader("To", e.to...)
-	m.SetHeader("Subject", e.subject)
-	m.SetBody("text/html", e.Body)
+	d := gomail.NewPlainDialer(m.config.Host, m.config.Port, m.config.Username, m.config.Password)
 
-	d := gomail.NewPlainDialer(os.Getenv("SmtpHost"), smtpPort, os.Getenv("SmtpUsername"), os.Getenv("SmtpPassword"))
-
-	if err := d.DialAndSend(m); err != nil {
+	if err := d.DialAndSend(msg); err != nil {
+		emailsTotal.Inc(e.emailType(), "error")
 		return err
 	}
 
+	emailsTotal.Inc(e.emailType(), "sent")
+
 	return nil
 }
 
@@ -170,5 +229,6 @@ func (e *Email) ParseTemplate(templateName string, data interface{}) error {
 	}
 
 	e.Body = html
+	e.templateName = templateName
 	return nil
 }
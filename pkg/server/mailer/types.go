@@ -57,3 +57,20 @@ func NewNoteInfo(note database.Note, stage int) DigestNoteInfo {
 		Stage:     stage,
 	}
 }
+
+// BookInvitationTmplData is a template data for book invitation emails
+type BookInvitationTmplData struct {
+	Subject      string
+	InviterEmail string
+	BookLabel    string
+	Role         string
+	Token        string
+}
+
+// OrganizationInvitationTmplData is a template data for organization invitation emails
+type OrganizationInvitationTmplData struct {
+	Subject          string
+	InviterEmail     string
+	OrganizationName string
+	Token            string
+}
@@ -19,17 +19,30 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/dnote/dnote/pkg/clock"
 	"github.com/dnote/dnote/pkg/server/api/handlers"
+	"github.com/dnote/dnote/pkg/server/api/operations"
+	"github.com/dnote/dnote/pkg/server/blob"
+	"github.com/dnote/dnote/pkg/server/config"
 	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/entitlement"
+	"github.com/dnote/dnote/pkg/server/https"
 	"github.com/dnote/dnote/pkg/server/job"
 	"github.com/dnote/dnote/pkg/server/mailer"
+	"github.com/dnote/dnote/pkg/server/metrics"
+	"github.com/dnote/dnote/pkg/server/oidc"
+	"github.com/dnote/dnote/pkg/server/ratelimit"
+	"github.com/dnote/dnote/pkg/server/webhook"
 
 	"github.com/gobuffalo/packr/v2"
 	"github.com/gorilla/mux"
@@ -37,7 +50,9 @@ import (
 )
 
 var versionTag = "master"
-var port = flag.String("port", "3000", "port to connect to")
+var configPath = flag.String("config", "", "path to the configuration file in YAML")
+var port = flag.String("port", "", "port to connect to. Overrides the configuration")
+var selfHosted = flag.Bool("selfHosted", false, "disable billing and entitle every user to all features. Overrides the configuration")
 
 var rootBox *packr.Box
 
@@ -89,12 +104,105 @@ func getSWHandler() http.HandlerFunc {
 	}
 }
 
-func initServer() *mux.Router {
+// loadConfig loads the configuration from the file given by the -config flag and
+// the environment variables, and applies the overrides given by the flags. It exits
+// the program with the validation errors if the configuration is invalid.
+func loadConfig() config.Config {
+	c, err := config.Load(*configPath)
+	if err == nil {
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "port":
+				c.Port = *port
+			case "selfHosted":
+				c.SelfHosted = *selfHosted
+			}
+		})
+
+		err = c.Validate()
+	}
+
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	return c
+}
+
+// initRateLimiter returns a rate limiter backed by the given store
+func initRateLimiter(c clock.Clock, store string) *ratelimit.Limiter {
+	var s ratelimit.Store
+
+	switch store {
+	case "postgres":
+		// share the counters between multiple instances of the server
+		s = ratelimit.NewPostgresStore(database.DBConn)
+	default:
+		s = ratelimit.NewMemoryStore()
+	}
+
+	return ratelimit.NewLimiter(s, c)
+}
+
+// initBlobStore returns the store of the attachments, or nil if attachments
+// are disabled
+func initBlobStore(cfg config.Config) blob.Store {
+	switch cfg.Attachments.Store {
+	case "filesystem":
+		s, err := blob.NewFileStore(cfg.Attachments.Dir)
+		if err != nil {
+			panic(errors.Wrap(err, "initializing the filesystem store"))
+		}
+
+		return s
+	case "s3":
+		s, err := blob.NewS3Store(cfg.S3())
+		if err != nil {
+			panic(errors.Wrap(err, "initializing the s3 store"))
+		}
+
+		return s
+	default:
+		return nil
+	}
+}
+
+// initOIDCProvider returns the OpenID Connect provider for single sign-on, or nil
+// if single sign-on is disabled
+func initOIDCProvider(cfg config.Config) *oidc.Provider {
+	if !cfg.SSOEnabled() {
+		return nil
+	}
+
+	return oidc.New(cfg.OIDCProvider())
+}
+
+func initServer(cfg config.Config, p entitlement.Policy, m *mailer.Mailer) *mux.Router {
 	srv := mux.NewRouter()
 
+	trustedProxies, err := cfg.TrustedProxies()
+	if err != nil {
+		panic(errors.Wrap(err, "parsing trusted proxies"))
+	}
+
+	c := clock.New()
 	apiRouter := handlers.NewRouter(&handlers.App{
-		Clock:            clock.New(),
-		StripeAPIBackend: nil,
+		Clock:               c,
+		StripeAPIBackend:    nil,
+		SelfHosted:          cfg.SelfHosted,
+		Entitlements:        p,
+		RateLimiter:         initRateLimiter(c, cfg.RateLimit.Store),
+		TrustedProxies:      trustedProxies,
+		Mailer:              m,
+		StripeSecretKey:     cfg.Stripe.SecretKey,
+		StripeWebhookSecret: cfg.Stripe.WebhookSecret,
+		TLS:                 cfg.TLSEnabled(),
+		Blobs:               initBlobStore(cfg),
+		AttachmentMaxSize:   cfg.Attachments.MaxSize,
+		AttachmentQuota:     cfg.Attachments.Quota,
+		OIDC:                initOIDCProvider(cfg),
+		SSOAllowSignup:      cfg.OIDC.AllowSignup,
 	})
 
 	srv.PathPrefix("/api").Handler(http.StripPrefix("/api", apiRouter))
@@ -108,33 +216,198 @@ func initServer() *mux.Router {
 	return srv
 }
 
-func startCmd() {
-	c := database.Config{
-		Host:     os.Getenv("DBHost"),
-		Port:     os.Getenv("DBPort"),
-		Name:     os.Getenv("DBName"),
-		User:     os.Getenv("DBUser"),
-		Password: os.Getenv("DBPassword"),
-	}
-	database.Open(c)
-	database.InitSchema()
-	defer database.Close()
+// initMetricsServer returns the handler of the server for the metrics. It is
+// served on a separate address so that the metrics are not exposed publicly.
+func initMetricsServer() *mux.Router {
+	srv := mux.NewRouter()
+	srv.Handle("/metrics", metrics.Handler())
 
-	mailer.InitTemplates(nil)
+	return srv
+}
+
+// openDB opens the database connection without changing the schema
+func openDB(c config.Config) {
+	database.Open(c.Database())
+}
+
+// initDB opens the database connection and brings the schema up to date
+func initDB(c config.Config) {
+	openDB(c)
+	database.InitSchema()
 
 	// Perform database migration
 	if err := database.Migrate(); err != nil {
 		panic(errors.Wrap(err, "running migrations"))
 	}
+}
+
+func startCmd() {
+	cfg := loadConfig()
+
+	initDB(cfg)
+	defer database.Close()
+
+	mailer.InitTemplates(nil)
+	m := mailer.New(cfg.Mailer())
+
+	p := entitlement.New(cfg.SelfHosted)
+
+	webhookNetworks, err := cfg.WebhookAllowedNetworks()
+	if err != nil {
+		panic(errors.Wrap(err, "parsing webhook allowed networks"))
+	}
+	webhook.SetAllowedNetworks(webhookNetworks)
 
-	// Run job in the background
-	go job.Run()
+	// Run jobs in the background
+	runner := job.NewRunner(p, m, cfg.AuditLogRetention)
+	runner.Start()
 
-	srv := initServer()
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%s", cfg.Port),
+		Handler: initServer(cfg, p, m),
+	}
+	// httpSrv serves plain HTTP alongside HTTPS, if configured
+	var httpSrv *http.Server
+	// metricsSrv serves the metrics on an internal address, if configured
+	var metricsSrv *http.Server
+
+	serverErr := make(chan error, 3)
+
+	if cfg.Metrics.Addr != "" {
+		metricsSrv = &http.Server{
+			Addr:    cfg.Metrics.Addr,
+			Handler: initMetricsServer(),
+		}
+
+		go func() {
+			log.Printf("Serving metrics on %s", cfg.Metrics.Addr)
+			serverErr <- metricsSrv.ListenAndServe()
+		}()
+	}
+
+	if cfg.TLSEnabled() {
+		setup, err := https.New(cfg.TLS, cfg.Port)
+		if err != nil {
+			panic(errors.Wrap(err, "setting up TLS"))
+		}
+		srv.TLSConfig = setup.TLSConfig
+
+		go func() {
+			log.Printf("Dnote version %s is running on port %s with TLS", versionTag, cfg.Port)
+			serverErr <- srv.ListenAndServeTLS("", "")
+		}()
+
+		if cfg.TLS.HTTPPort != "" {
+			httpSrv = &http.Server{
+				Addr:    fmt.Sprintf(":%s", cfg.TLS.HTTPPort),
+				Handler: setup.HTTPHandler,
+			}
+
+			go func() {
+				log.Printf("Redirecting HTTP requests on port %s to HTTPS", cfg.TLS.HTTPPort)
+				serverErr <- httpSrv.ListenAndServe()
+			}()
+		}
+	} else {
+		go func() {
+			log.Printf("Dnote version %s is running on port %s", versionTag, cfg.Port)
+			serverErr <- srv.ListenAndServe()
+		}()
+	}
 
-	log.Printf("Dnote version %s is running on port %s", versionTag, *port)
-	addr := fmt.Sprintf(":%s", *port)
-	log.Println(http.ListenAndServe(addr, srv))
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serverErr:
+		log.Println(err)
+	case s := <-sig:
+		log.Printf("Received %s. Shutting down", s)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	// Stop accepting requests and wait for the ones in progress to finish
+	if httpSrv != nil {
+		if err := httpSrv.Shutdown(ctx); err != nil {
+			log.Println(errors.Wrap(err, "shutting down the HTTP redirect server"))
+		}
+	}
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(ctx); err != nil {
+			log.Println(errors.Wrap(err, "shutting down the metrics server"))
+		}
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println(errors.Wrap(err, "shutting down the HTTP server"))
+	}
+	if err := runner.Stop(ctx); err != nil {
+		log.Println(errors.Wrap(err, "stopping the background tasks"))
+	}
+
+	log.Println("Shut down")
+}
+
+// createAdminCmd creates an administrator in an organization. It is used to
+// bootstrap a self-hosted instance, on which signup is restricted as soon as an
+// organization exists.
+func createAdminCmd(args []string) {
+	fs := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	email := fs.String("email", "", "email of the administrator")
+	password := fs.String("password", "", "password of the administrator")
+	orgName := fs.String("org", "Default", "name of the organization. It is created if it does not exist")
+	domains := fs.String("domains", "", "comma-separated email domains allowed to sign up to a new organization")
+	fs.Parse(args)
+
+	if *email == "" || len(*password) < 8 {
+		fmt.Println("Please provide -email and a -password longer than 8 characters")
+		os.Exit(1)
+	}
+
+	initDB(loadConfig())
+	defer database.Close()
+
+	db := database.DBConn
+
+	var count int
+	if err := db.Model(database.Account{}).Where("email = ?", *email).Count(&count).Error; err != nil {
+		panic(errors.Wrap(err, "checking duplicate user"))
+	}
+	if count > 0 {
+		fmt.Printf("A user with the email %s already exists\n", *email)
+		os.Exit(1)
+	}
+
+	var org database.Organization
+	conn := db.Where("name = ?", *orgName).First(&org)
+	if conn.RecordNotFound() {
+		var allowedDomains []string
+		if *domains != "" {
+			allowedDomains = strings.Split(*domains, ",")
+		}
+
+		tx := db.Begin()
+		o, err := operations.CreateOrganization(tx, *orgName, allowedDomains)
+		if err != nil {
+			tx.Rollback()
+			panic(errors.Wrap(err, "creating organization"))
+		}
+		tx.Commit()
+
+		org = o
+	} else if err := conn.Error; err != nil {
+		panic(errors.Wrap(err, "finding organization"))
+	}
+
+	if _, err := operations.CreateUser(*email, *password, operations.CreateUserParams{
+		OrganizationID: org.ID,
+		Admin:          true,
+	}); err != nil {
+		panic(errors.Wrap(err, "creating user"))
+	}
+
+	fmt.Printf("Created the administrator %s in the organization %s\n", *email, org.Name)
 }
 
 func versionCmd() {
@@ -150,14 +423,40 @@ func main() {
 		fmt.Printf(`Dnote Server - A simple notebook for developers
 
 Usage:
-  dnote-server [command]
+  dnote-server [flags] [command]
 
 Available commands:
   start: Start the server
+  create-admin: Create an administrator of an organization
+  migrate: Apply, roll back, or print the state of the migrations
+  user: Manage users, and export or import their data
+  digest: Deliver the digests of a user right away
+  backup: Back up the database to a file
+  restore: Restore the database from a backup file
+  doctor: Check the consistency of the sync data of users
   version: Print the version
+
+Flags:
+  -config: Path to the configuration file in YAML
+  -port: Port to listen on
+  -selfHosted: Run in the self-hosting mode
 `)
 	case "start":
 		startCmd()
+	case "create-admin":
+		createAdminCmd(flag.Args()[1:])
+	case "migrate":
+		migrateCmd(flag.Args()[1:])
+	case "user":
+		userCmd(flag.Args()[1:])
+	case "digest":
+		digestCmd(flag.Args()[1:])
+	case "backup":
+		backupCmd(flag.Args()[1:])
+	case "restore":
+		restoreCmd(flag.Args()[1:])
+	case "doctor":
+		doctorCmd(flag.Args()[1:])
 	case "version":
 		versionCmd()
 	default:
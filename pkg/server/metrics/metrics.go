@@ -0,0 +1,458 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package metrics provides counters, gauges and histograms that are exposed
+// in the Prometheus text exposition format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+
+	// labelSeparator joins label values into a key. It cannot appear in valid UTF-8.
+	labelSeparator = "\xff"
+)
+
+// DefBuckets are the default buckets of histograms, suitable for measuring
+// latencies in seconds
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultRegistry is the registry to which the metrics are added by default
+var DefaultRegistry = NewRegistry()
+
+type metric interface {
+	desc() *desc
+	writeSamples(w io.Writer) error
+}
+
+// desc describes a metric
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) key(labelValues []string) string {
+	if len(labelValues) != len(d.labels) {
+		panic(errors.Errorf("metric %s expects %d label values but got %d", d.name, len(d.labels), len(labelValues)))
+	}
+
+	return strings.Join(labelValues, labelSeparator)
+}
+
+// Registry is a collection of metrics
+type Registry struct {
+	mtx     sync.Mutex
+	metrics map[string]metric
+}
+
+// NewRegistry returns a new empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: map[string]metric{},
+	}
+}
+
+func (r *Registry) register(m metric) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	name := m.desc().name
+	if _, ok := r.metrics[name]; ok {
+		panic(errors.Errorf("metric %s is already registered", name))
+	}
+
+	r.metrics[name] = m
+}
+
+// Write writes all metrics in the registry in the Prometheus text format
+func (r *Registry) Write(w io.Writer) error {
+	r.mtx.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := make([]metric, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		metrics = append(metrics, r.metrics[name])
+	}
+	r.mtx.Unlock()
+
+	bw := bufio.NewWriter(w)
+
+	for _, m := range metrics {
+		d := m.desc()
+
+		if _, err := fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.typ); err != nil {
+			return errors.Wrapf(err, "writing header of %s", d.name)
+		}
+		if err := m.writeSamples(bw); err != nil {
+			return errors.Wrapf(err, "writing samples of %s", d.name)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Handler returns an HTTP handler that serves the metrics in the registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		if err := r.Write(w); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	})
+}
+
+// Handler returns an HTTP handler that serves the metrics in the default registry
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
+
+// series is a set of label values and the value of a metric for them
+type series struct {
+	labelValues []string
+	value       float64
+}
+
+// vec holds the series of a counter or a gauge
+type vec struct {
+	d      desc
+	mtx    sync.Mutex
+	series map[string]*series
+}
+
+func (v *vec) desc() *desc {
+	return &v.d
+}
+
+func (v *vec) add(delta float64, labelValues []string) {
+	key := v.d.key(labelValues)
+
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		v.series[key] = s
+	}
+
+	s.value += delta
+}
+
+func (v *vec) set(value float64, labelValues []string) {
+	key := v.d.key(labelValues)
+
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	v.series[key] = &series{labelValues: append([]string{}, labelValues...), value: value}
+}
+
+func (v *vec) get(labelValues []string) float64 {
+	key := v.d.key(labelValues)
+
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	if s, ok := v.series[key]; ok {
+		return s.value
+	}
+
+	return 0
+}
+
+func (v *vec) writeSamples(w io.Writer) error {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	for _, key := range sortedKeys(v.series) {
+		s := v.series[key]
+
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", v.d.name, formatLabels(v.d.labels, s.labelValues, "", ""), formatValue(s.value)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CounterVec is a set of counters partitioned by label values
+type CounterVec struct {
+	vec
+}
+
+// NewCounterVec creates a counter and adds it to the registry
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		vec: vec{
+			d:      desc{name: name, help: help, typ: typeCounter, labels: labels},
+			series: map[string]*series{},
+		},
+	}
+	r.register(c)
+
+	return c
+}
+
+// NewCounterVec creates a counter and adds it to the default registry
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return DefaultRegistry.NewCounterVec(name, help, labels...)
+}
+
+// Inc increments the counter for the given label values by 1
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.add(1, labelValues)
+}
+
+// Add increments the counter for the given label values by the given amount
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(errors.Errorf("counter %s cannot decrease", c.d.name))
+	}
+
+	c.add(delta, labelValues)
+}
+
+// Value returns the current value of the counter for the given label values
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	return c.get(labelValues)
+}
+
+// GaugeVec is a set of gauges partitioned by label values
+type GaugeVec struct {
+	vec
+}
+
+// NewGaugeVec creates a gauge and adds it to the registry
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{
+		vec: vec{
+			d:      desc{name: name, help: help, typ: typeGauge, labels: labels},
+			series: map[string]*series{},
+		},
+	}
+	r.register(g)
+
+	return g
+}
+
+// NewGaugeVec creates a gauge and adds it to the default registry
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return DefaultRegistry.NewGaugeVec(name, help, labels...)
+}
+
+// Set sets the gauge for the given label values
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.set(value, labelValues)
+}
+
+// Add adds the given amount, which can be negative, to the gauge for the given label values
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.add(delta, labelValues)
+}
+
+// Value returns the current value of the gauge for the given label values
+func (g *GaugeVec) Value(labelValues ...string) float64 {
+	return g.get(labelValues)
+}
+
+// histogramSeries is the state of a histogram for a set of label values
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+// HistogramVec is a set of histograms partitioned by label values
+type HistogramVec struct {
+	d       desc
+	buckets []float64
+	mtx     sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+// NewHistogramVec creates a histogram with the given upper bounds of buckets
+// and adds it to the registry
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	b := append([]float64{}, buckets...)
+	sort.Float64s(b)
+
+	h := &HistogramVec{
+		d:       desc{name: name, help: help, typ: typeHistogram, labels: labels},
+		buckets: b,
+		series:  map[string]*histogramSeries{},
+	}
+	r.register(h)
+
+	return h
+}
+
+// NewHistogramVec creates a histogram and adds it to the default registry
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return DefaultRegistry.NewHistogramVec(name, help, buckets, labels...)
+}
+
+func (h *HistogramVec) desc() *desc {
+	return &h.d
+}
+
+// Observe adds an observation to the histogram for the given label values
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := h.d.key(labelValues)
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			labelValues: append([]string{}, labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = s
+	}
+
+	for i, upperBound := range h.buckets {
+		if value <= upperBound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+// Count returns the number of observations for the given label values
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	key := h.d.key(labelValues)
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if s, ok := h.series[key]; ok {
+		return s.count
+	}
+
+	return 0
+}
+
+func (h *HistogramVec) writeSamples(w io.Writer) error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := h.series[key]
+
+		for i, upperBound := range h.buckets {
+			labels := formatLabels(h.d.labels, s.labelValues, "le", formatValue(upperBound))
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.d.name, labels, s.counts[i]); err != nil {
+				return err
+			}
+		}
+
+		labels := formatLabels(h.d.labels, s.labelValues, "le", "+Inf")
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.d.name, labels, s.count); err != nil {
+			return err
+		}
+
+		labels = formatLabels(h.d.labels, s.labelValues, "", "")
+		if _, err := fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", h.d.name, labels, formatValue(s.sum), h.d.name, labels, s.count); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sortedKeys(m map[string]*series) []string {
+	ret := make([]string, 0, len(m))
+	for key := range m {
+		ret = append(ret, key)
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
+// formatLabels formats the label pairs, with an optional extra pair at the end
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	pairs := []string{}
+
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabelValue(values[i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, escapeLabelValue(extraValue)))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("{%s}", strings.Join(pairs, ","))
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
@@ -0,0 +1,126 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+
+	c := r.NewCounterVec("test_requests_total", "Total number of requests", "method", "code")
+	c.Inc("GET", "200")
+	c.Inc("GET", "200")
+	c.Add(3, "POST", "500")
+
+	g := r.NewGaugeVec("test_in_flight", "Number of requests\nin flight")
+	g.Add(2)
+	g.Add(-1)
+
+	h := r.NewHistogramVec("test_duration_seconds", "Duration", []float64{1, 0.1}, "route")
+	h.Observe(0.0625, `/a"b`)
+	h.Observe(0.5, `/a"b`)
+	h.Observe(2, `/a"b`)
+
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP test_duration_seconds Duration
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/a\"b",le="0.1"} 1
+test_duration_seconds_bucket{route="/a\"b",le="1"} 2
+test_duration_seconds_bucket{route="/a\"b",le="+Inf"} 3
+test_duration_seconds_sum{route="/a\"b"} 2.5625
+test_duration_seconds_count{route="/a\"b"} 3
+# HELP test_in_flight Number of requests\nin flight
+# TYPE test_in_flight gauge
+test_in_flight 1
+# HELP test_requests_total Total number of requests
+# TYPE test_requests_total counter
+test_requests_total{method="GET",code="200"} 2
+test_requests_total{method="POST",code="500"} 3
+`
+
+	assert.Equal(t, buf.String(), expected, "output mismatch")
+}
+
+func TestCounterVecValue(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_total", "Total", "type")
+
+	assert.Equal(t, c.Value("a"), float64(0), "initial value mismatch")
+
+	c.Inc("a")
+	c.Add(2, "a")
+	c.Inc("b")
+
+	assert.Equal(t, c.Value("a"), float64(3), "value a mismatch")
+	assert.Equal(t, c.Value("b"), float64(1), "value b mismatch")
+}
+
+func TestRegister_Duplicate(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("test_total", "Total")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a duplicate metric did not panic")
+		}
+	}()
+
+	r.NewGaugeVec("test_total", "Total")
+}
+
+func TestLabelCountMismatch(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_total", "Total", "type")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("incrementing with wrong number of label values did not panic")
+		}
+	}()
+
+	c.Inc("a", "b")
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_total", "Total")
+	c.Inc()
+
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, req)
+
+	assert.Equal(t, w.Code, http.StatusOK, "status code mismatch")
+	assert.Equal(t, w.Header().Get("Content-Type"), "text/plain; version=0.0.4; charset=utf-8", "content type mismatch")
+	assert.Equal(t, w.Body.String(), "# HELP test_total Total\n# TYPE test_total counter\ntest_total 1\n", "body mismatch")
+}
@@ -0,0 +1,374 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package oidc implements the parts of OpenID Connect used for the single sign-on
+// with an identity provider: the discovery, the authorization code flow with PKCE,
+// the device authorization grant, and the verification of ID tokens.
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrAuthorizationPending is an error for polling the token of a device
+	// authorization that the user has not approved yet
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	// ErrSlowDown is an error for polling the token of a device authorization
+	// too often. The client should increase the polling interval.
+	ErrSlowDown = errors.New("slow_down")
+	// ErrAccessDenied is an error for a device authorization denied by the user
+	ErrAccessDenied = errors.New("access_denied")
+	// ErrExpiredToken is an error for a device authorization that has expired
+	ErrExpiredToken = errors.New("expired_token")
+	// ErrDeviceGrantNotSupported is an error for using the device authorization
+	// grant with a provider that does not support it
+	ErrDeviceGrantNotSupported = errors.New("the identity provider does not support the device authorization grant")
+)
+
+// scopes are the scopes requested from the provider
+var scopes = []string{"openid", "email", "profile"}
+
+// Config is the configuration of the client of an OpenID Connect provider
+type Config struct {
+	// Issuer is the URL of the provider, under which the discovery document is served
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the URL to which the provider redirects the user after
+	// the authorization
+	RedirectURL string
+}
+
+// Metadata is the discovery document of a provider
+type Metadata struct {
+	Issuer                      string `json:"issuer"`
+	AuthorizationEndpoint       string `json:"authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	JWKSURI                     string `json:"jwks_uri"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+}
+
+// Provider is a client of an OpenID Connect provider. The discovery document
+// and the signing keys are fetched when they are first needed, and cached.
+type Provider struct {
+	Config Config
+	// Clock is used to check the expiry of ID tokens
+	Clock      clock.Clock
+	HTTPClient *http.Client
+
+	mtx      sync.Mutex
+	metadata *Metadata
+	keys     map[string]*rsa.PublicKey
+	// keysFetchedAt is the time at which the keys were last fetched, and keysMtx
+	// serializes the fetches
+	keysFetchedAt time.Time
+	keysMtx       sync.Mutex
+}
+
+// New returns a new provider with the given configuration
+func New(c Config) *Provider {
+	return &Provider{
+		Config: c,
+		Clock:  clock.New(),
+		HTTPClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// decodeResponse decodes the JSON body of the given response into v
+func decodeResponse(res *http.Response, v interface{}) error {
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return errors.Wrap(err, "reading the response")
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return errors.Wrapf(err, "decoding the response with status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// getMetadata returns the discovery document of the provider
+func (p *Provider) getMetadata() (Metadata, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.metadata != nil {
+		return *p.metadata, nil
+	}
+
+	endpoint := strings.TrimSuffix(p.Config.Issuer, "/") + "/.well-known/openid-configuration"
+	res, err := p.HTTPClient.Get(endpoint)
+	if err != nil {
+		return Metadata{}, errors.Wrap(err, "requesting the discovery document")
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return Metadata{}, errors.Errorf("the discovery document responded with status %d", res.StatusCode)
+	}
+
+	var m Metadata
+	if err := decodeResponse(res, &m); err != nil {
+		return Metadata{}, errors.Wrap(err, "decoding the discovery document")
+	}
+	if strings.TrimSuffix(m.Issuer, "/") != strings.TrimSuffix(p.Config.Issuer, "/") {
+		return Metadata{}, errors.Errorf("issuer mismatch. expected %s, got %s", p.Config.Issuer, m.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return Metadata{}, errors.New("the discovery document is missing endpoints")
+	}
+
+	p.metadata = &m
+
+	return m, nil
+}
+
+// randomString returns a URL-safe random string of the given number of bytes
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "reading random bytes")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge returns the PKCE code challenge of the given verifier using the
+// S256 method
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthRequest is the state of an authorization request that is kept until the
+// provider redirects the user back
+type AuthRequest struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+// NewAuthRequest returns a new authorization request with random values
+func NewAuthRequest() (AuthRequest, error) {
+	state, err := randomString(24)
+	if err != nil {
+		return AuthRequest{}, errors.Wrap(err, "generating state")
+	}
+	nonce, err := randomString(24)
+	if err != nil {
+		return AuthRequest{}, errors.Wrap(err, "generating nonce")
+	}
+	verifier, err := randomString(32)
+	if err != nil {
+		return AuthRequest{}, errors.Wrap(err, "generating code verifier")
+	}
+
+	return AuthRequest{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+	}, nil
+}
+
+// AuthCodeURL returns the URL of the provider to which the user is sent to sign in
+func (p *Provider) AuthCodeURL(req AuthRequest) (string, error) {
+	m, err := p.getMetadata()
+	if err != nil {
+		return "", errors.Wrap(err, "getting metadata")
+	}
+
+	u, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return "", errors.Wrap(err, "parsing the authorization endpoint")
+	}
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.Config.ClientID)
+	q.Set("redirect_uri", p.Config.RedirectURL)
+	q.Set("scope", strings.Join(scopes, " "))
+	q.Set("state", req.State)
+	q.Set("nonce", req.Nonce)
+	q.Set("code_challenge", CodeChallenge(req.CodeVerifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// tokenResponse is a response from the token endpoint
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// requestToken makes a request to the token endpoint and returns the ID token
+func (p *Provider) requestToken(form url.Values) (string, error) {
+	m, err := p.getMetadata()
+	if err != nil {
+		return "", errors.Wrap(err, "getting metadata")
+	}
+
+	form.Set("client_id", p.Config.ClientID)
+	if p.Config.ClientSecret != "" {
+		form.Set("client_secret", p.Config.ClientSecret)
+	}
+
+	res, err := p.HTTPClient.PostForm(m.TokenEndpoint, form)
+	if err != nil {
+		return "", errors.Wrap(err, "requesting token")
+	}
+
+	var resp tokenResponse
+	if err := decodeResponse(res, &resp); err != nil {
+		return "", errors.Wrap(err, "decoding token response")
+	}
+
+	switch resp.Error {
+	case "":
+	case ErrAuthorizationPending.Error():
+		return "", ErrAuthorizationPending
+	case ErrSlowDown.Error():
+		return "", ErrSlowDown
+	case ErrAccessDenied.Error():
+		return "", ErrAccessDenied
+	case ErrExpiredToken.Error():
+		return "", ErrExpiredToken
+	default:
+		return "", errors.Errorf("token endpoint responded with %s: %s", resp.Error, resp.ErrorDescription)
+	}
+
+	if resp.IDToken == "" {
+		return "", errors.New("token response does not have an ID token")
+	}
+
+	return resp.IDToken, nil
+}
+
+// Exchange exchanges the authorization code for an ID token and returns its
+// verified claims. The nonce must match the one of the authorization request.
+func (p *Provider) Exchange(code string, req AuthRequest) (Claims, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.Config.RedirectURL)
+	form.Set("code_verifier", req.CodeVerifier)
+
+	idToken, err := p.requestToken(form)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	claims, err := p.Verify(idToken)
+	if err != nil {
+		return Claims{}, errors.Wrap(err, "verifying ID token")
+	}
+	if claims.Nonce != req.Nonce {
+		return Claims{}, errors.New("nonce mismatch")
+	}
+
+	return claims, nil
+}
+
+// DeviceAuthorization is a response from the device authorization endpoint
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval,omitempty"`
+}
+
+// StartDeviceAuthorization starts a device authorization grant, in which the
+// user approves the signin on another device
+func (p *Provider) StartDeviceAuthorization() (DeviceAuthorization, error) {
+	m, err := p.getMetadata()
+	if err != nil {
+		return DeviceAuthorization{}, errors.Wrap(err, "getting metadata")
+	}
+	if m.DeviceAuthorizationEndpoint == "" {
+		return DeviceAuthorization{}, ErrDeviceGrantNotSupported
+	}
+
+	form := url.Values{}
+	form.Set("client_id", p.Config.ClientID)
+	if p.Config.ClientSecret != "" {
+		form.Set("client_secret", p.Config.ClientSecret)
+	}
+	form.Set("scope", strings.Join(scopes, " "))
+
+	res, err := p.HTTPClient.PostForm(m.DeviceAuthorizationEndpoint, form)
+	if err != nil {
+		return DeviceAuthorization{}, errors.Wrap(err, "requesting device authorization")
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return DeviceAuthorization{}, errors.Errorf("device authorization endpoint responded with status %d", res.StatusCode)
+	}
+
+	var ret DeviceAuthorization
+	if err := decodeResponse(res, &ret); err != nil {
+		return DeviceAuthorization{}, errors.Wrap(err, "decoding device authorization")
+	}
+	if ret.DeviceCode == "" || ret.UserCode == "" {
+		return DeviceAuthorization{}, errors.New("device authorization is missing codes")
+	}
+
+	return ret, nil
+}
+
+// PollDeviceToken requests the ID token of a device authorization and returns
+// its verified claims. It returns ErrAuthorizationPending until the user approves.
+func (p *Provider) PollDeviceToken(deviceCode string) (Claims, error) {
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:device_code")
+	form.Set("device_code", deviceCode)
+
+	idToken, err := p.requestToken(form)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	claims, err := p.Verify(idToken)
+	if err != nil {
+		return Claims{}, errors.Wrap(err, "verifying ID token")
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,325 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package oidc
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/oidc/oidctest"
+	"github.com/pkg/errors"
+)
+
+const testClientID = "dnote"
+
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Server) {
+	s := oidctest.NewServer(testClientID)
+	s.SetUser(oidctest.User{Subject: "user-1", Email: "alice@example.com", EmailVerified: true})
+
+	p := New(Config{
+		Issuer:       s.URL,
+		ClientID:     testClientID,
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:3000/api/v3/sso/callback",
+	})
+
+	return p, s
+}
+
+// authorize follows the authorization URL and returns the code and the state
+// with which the provider redirects back
+func authorize(t *testing.T, p *Provider, req AuthRequest) (string, string) {
+	authURL, err := p.AuthCodeURL(req)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "getting authorization url"))
+	}
+
+	hc := http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := hc.Get(authURL)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "authorizing"))
+	}
+	assert.Equal(t, res.StatusCode, http.StatusFound, "status code mismatch")
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "parsing location"))
+	}
+
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// example from RFC 7636 Appendix B
+	got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+
+	assert.Equal(t, got, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", "challenge mismatch")
+}
+
+func TestExchange(t *testing.T) {
+	p, s := newTestProvider(t)
+	defer s.Close()
+
+	req, err := NewAuthRequest()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "creating auth request"))
+	}
+
+	code, state := authorize(t, p, req)
+	assert.Equal(t, state, req.State, "state mismatch")
+
+	claims, err := p.Exchange(code, req)
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "exchanging"))
+	}
+
+	assert.Equal(t, claims.Subject, "user-1", "subject mismatch")
+	assert.Equal(t, claims.Email, "alice@example.com", "email mismatch")
+	assert.Equal(t, claims.EmailVerified, true, "email_verified mismatch")
+	assert.Equal(t, claims.Nonce, req.Nonce, "nonce mismatch")
+}
+
+func TestExchange_invalid(t *testing.T) {
+	testCases := []struct {
+		name   string
+		mutate func(req *AuthRequest)
+	}{
+		{
+			name: "wrong code verifier",
+			mutate: func(req *AuthRequest) {
+				req.CodeVerifier = "wrong"
+			},
+		},
+		{
+			name: "wrong nonce",
+			mutate: func(req *AuthRequest) {
+				req.Nonce = "wrong"
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, s := newTestProvider(t)
+			defer s.Close()
+
+			req, err := NewAuthRequest()
+			if err != nil {
+				t.Fatal(errors.Wrap(err, "creating auth request"))
+			}
+			code, _ := authorize(t, p, req)
+
+			tc.mutate(&req)
+			_, err = p.Exchange(code, req)
+
+			assert.NotEqual(t, err, nil, "error should not be nil")
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	p, s := newTestProvider(t)
+	defer s.Close()
+
+	now := time.Now()
+	c := clock.NewMock()
+	c.SetNow(now)
+	p.Clock = c
+
+	other := oidctest.NewServer(testClientID)
+	defer other.Close()
+
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": s.URL,
+			"sub": "user-1",
+			"aud": []string{"another-client", testClientID},
+			"exp": now.Add(time.Hour).Unix(),
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		token    func() string
+		expected bool
+	}{
+		{
+			name: "valid",
+			token: func() string {
+				return s.Sign(validClaims())
+			},
+			expected: true,
+		},
+		{
+			name: "expired",
+			token: func() string {
+				claims := validClaims()
+				claims["exp"] = now.Add(-time.Hour).Unix()
+				return s.Sign(claims)
+			},
+			expected: false,
+		},
+		{
+			name: "another audience",
+			token: func() string {
+				claims := validClaims()
+				claims["aud"] = "another-client"
+				return s.Sign(claims)
+			},
+			expected: false,
+		},
+		{
+			name: "another issuer",
+			token: func() string {
+				claims := validClaims()
+				claims["iss"] = other.URL
+				return s.Sign(claims)
+			},
+			expected: false,
+		},
+		{
+			name: "signed with another key",
+			token: func() string {
+				return other.Sign(validClaims())
+			},
+			expected: false,
+		},
+		{
+			name: "malformed",
+			token: func() string {
+				return "not-a-token"
+			},
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			claims, err := p.Verify(tc.token())
+
+			assert.Equal(t, err == nil, tc.expected, "validity mismatch")
+			if tc.expected {
+				assert.Equal(t, claims.Subject, "user-1", "subject mismatch")
+			}
+		})
+	}
+}
+
+// countingTransport counts the requests made to the given path
+type countingTransport struct {
+	path  string
+	count int
+}
+
+func (t *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Path == t.path {
+		t.count++
+	}
+
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestVerify_UnknownKey(t *testing.T) {
+	p, s := newTestProvider(t)
+	defer s.Close()
+
+	now := time.Now()
+	c := clock.NewMock()
+	c.SetNow(now)
+	p.Clock = c
+
+	transport := &countingTransport{path: "/jwks"}
+	p.HTTPClient.Transport = transport
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"unknown","typ":"JWT"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user-1"}`))
+	token := header + "." + payload + ".c2lnbmF0dXJl"
+
+	for i := 0; i < 3; i++ {
+		if _, err := p.Verify(token); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+	assert.Equal(t, transport.count, 1, "the keys should be fetched once within the interval")
+
+	c.SetNow(now.Add(keysRefetchInterval))
+	if _, err := p.Verify(token); err == nil {
+		t.Fatal("expected an error")
+	}
+	assert.Equal(t, transport.count, 2, "the keys should be fetched again after the interval")
+
+	// a known key is still verified
+	claims, err := p.Verify(s.Sign(map[string]interface{}{
+		"iss": s.URL,
+		"sub": "user-1",
+		"aud": testClientID,
+		"exp": now.Add(time.Hour).Unix(),
+	}))
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "verifying a token signed with a known key"))
+	}
+	assert.Equal(t, claims.Subject, "user-1", "subject mismatch")
+}
+
+func TestDeviceAuthorization(t *testing.T) {
+	t.Run("approved", func(t *testing.T) {
+		p, s := newTestProvider(t)
+		defer s.Close()
+
+		d, err := p.StartDeviceAuthorization()
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "starting"))
+		}
+
+		_, err = p.PollDeviceToken(d.DeviceCode)
+		assert.Equal(t, err, ErrAuthorizationPending, "error mismatch before approval")
+
+		s.Approve(d.UserCode)
+
+		claims, err := p.PollDeviceToken(d.DeviceCode)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "polling"))
+		}
+		assert.Equal(t, claims.Subject, "user-1", "subject mismatch")
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		p, s := newTestProvider(t)
+		defer s.Close()
+
+		d, err := p.StartDeviceAuthorization()
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "starting"))
+		}
+
+		s.Deny(d.UserCode)
+
+		_, err = p.PollDeviceToken(d.DeviceCode)
+		assert.Equal(t, err, ErrAccessDenied, "error mismatch")
+	})
+}
@@ -0,0 +1,356 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package oidctest provides a mock OpenID Connect provider for tests. It approves
+// every authorization request as the configured user, and device authorizations
+// once they are approved with Approve.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// keyID is the id of the signing key of the provider
+const keyID = "test-key"
+
+// User is the user as whom the provider signs in
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type authCode struct {
+	challenge   string
+	nonce       string
+	redirectURI string
+}
+
+type deviceAuth struct {
+	userCode string
+	approved bool
+	denied   bool
+}
+
+// Server is a mock OpenID Connect provider
+type Server struct {
+	*httptest.Server
+	ClientID string
+	Key      *rsa.PrivateKey
+
+	mtx     sync.Mutex
+	user    User
+	codes   map[string]authCode
+	devices map[string]*deviceAuth
+	counter int
+}
+
+// NewServer starts a new mock provider for the client of the given id
+func NewServer(clientID string) *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(errors.Wrap(err, "generating key"))
+	}
+
+	s := &Server{
+		ClientID: clientID,
+		Key:      key,
+		codes:    map[string]authCode{},
+		devices:  map[string]*deviceAuth{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/jwks", s.jwks)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/device", s.device)
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// SetUser sets the user as whom the provider signs in
+func (s *Server) SetUser(u User) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.user = u
+}
+
+// Approve approves the device authorization of the given user code
+func (s *Server) Approve(userCode string) {
+	s.setDeviceState(userCode, true)
+}
+
+// Deny denies the device authorization of the given user code
+func (s *Server) Deny(userCode string) {
+	s.setDeviceState(userCode, false)
+}
+
+func (s *Server) setDeviceState(userCode string, approved bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, d := range s.devices {
+		if d.userCode == userCode {
+			d.approved = approved
+			d.denied = !approved
+		}
+	}
+}
+
+// nextCode returns a new unique code with the given prefix
+func (s *Server) nextCode(prefix string) string {
+	s.counter++
+
+	return fmt.Sprintf("%s-%d", prefix, s.counter)
+}
+
+// Sign signs the given claims into an ID token with the key of the provider
+func (s *Server) Sign(claims map[string]interface{}) string {
+	header := map[string]string{"alg": "RS256", "kid": keyID, "typ": "JWT"}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		panic(errors.Wrap(err, "encoding header"))
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		panic(errors.Wrap(err, "encoding claims"))
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.Key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(errors.Wrap(err, "signing"))
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// IDToken returns a valid ID token of the current user with the given nonce
+func (s *Server) IDToken(nonce string) string {
+	s.mtx.Lock()
+	u := s.user
+	s.mtx.Unlock()
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":            s.URL,
+		"sub":            u.Subject,
+		"aud":            s.ClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"email":          u.Email,
+		"email_verified": u.EmailVerified,
+		"name":           u.Name,
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	return s.Sign(claims)
+}
+
+func respondJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
+
+func respondError(w http.ResponseWriter, code string) {
+	respondJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, map[string]string{
+		"issuer":                        s.URL,
+		"authorization_endpoint":        s.URL + "/authorize",
+		"token_endpoint":                s.URL + "/token",
+		"jwks_uri":                      s.URL + "/jwks",
+		"device_authorization_endpoint": s.URL + "/device",
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := s.Key.PublicKey
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": keyID,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			},
+		},
+	})
+}
+
+// authorize signs in as the current user right away and redirects back with a code
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != s.ClientID {
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	}
+	if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	s.mtx.Lock()
+	code := s.nextCode("code")
+	s.codes[code] = authCode{
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		redirectURI: q.Get("redirect_uri"),
+	}
+	s.mtx.Unlock()
+
+	rq := redirectURI.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	redirectURI.RawQuery = rq.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondError(w, "invalid_request")
+		return
+	}
+	if r.PostForm.Get("client_id") != s.ClientID {
+		respondError(w, "invalid_client")
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		s.exchangeCode(w, r)
+	case "urn:ietf:params:oauth:grant-type:device_code":
+		s.exchangeDeviceCode(w, r)
+	default:
+		respondError(w, "unsupported_grant_type")
+	}
+}
+
+func (s *Server) exchangeCode(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	code, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	s.mtx.Unlock()
+
+	if !ok || code.redirectURI != r.PostForm.Get("redirect_uri") {
+		respondError(w, "invalid_grant")
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != code.challenge {
+		respondError(w, "invalid_grant")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"id_token":     s.IDToken(code.nonce),
+	})
+}
+
+func (s *Server) exchangeDeviceCode(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	d, ok := s.devices[r.PostForm.Get("device_code")]
+	var approved, denied bool
+	if ok {
+		approved, denied = d.approved, d.denied
+	}
+	s.mtx.Unlock()
+
+	if !ok {
+		respondError(w, "expired_token")
+		return
+	}
+	if denied {
+		respondError(w, "access_denied")
+		return
+	}
+	if !approved {
+		respondError(w, "authorization_pending")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"id_token":     s.IDToken(""),
+	})
+}
+
+func (s *Server) device(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondError(w, "invalid_request")
+		return
+	}
+	if r.PostForm.Get("client_id") != s.ClientID {
+		respondError(w, "invalid_client")
+		return
+	}
+
+	s.mtx.Lock()
+	deviceCode := s.nextCode("device")
+	userCode := s.nextCode("USER")
+	s.devices[deviceCode] = &deviceAuth{userCode: userCode}
+	s.mtx.Unlock()
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"device_code":               deviceCode,
+		"user_code":                 userCode,
+		"verification_uri":          s.URL + "/activate",
+		"verification_uri_complete": s.URL + "/activate?user_code=" + userCode,
+		"expires_in":                600,
+		"interval":                  5,
+	})
+}
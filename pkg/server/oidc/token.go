@@ -0,0 +1,260 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package oidc
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// clockSkew is the allowed difference between the clocks of the server and the provider
+const clockSkew = time.Minute
+
+// audience is the aud claim, which is either a string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return errors.Wrap(err, "decoding audience")
+	}
+	*a = ss
+
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Claims are the claims of an ID token
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify verifies the signature and the claims of the given ID token and returns
+// the claims. Only tokens signed with RS256 are supported.
+func (p *Provider) Verify(idToken string) (Claims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return Claims{}, errors.New("malformed token")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Claims{}, errors.Wrap(err, "decoding header")
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return Claims{}, errors.Wrap(err, "decoding header")
+	}
+	if header.Alg != "RS256" {
+		return Claims{}, errors.Errorf("unsupported algorithm %s", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, errors.Wrap(err, "decoding signature")
+	}
+
+	key, err := p.getKey(header.Kid)
+	if err != nil {
+		return Claims{}, errors.Wrap(err, "getting signing key")
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return Claims{}, errors.New("invalid signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Claims{}, errors.Wrap(err, "decoding payload")
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return Claims{}, errors.Wrap(err, "decoding claims")
+	}
+
+	if strings.TrimSuffix(claims.Issuer, "/") != strings.TrimSuffix(p.Config.Issuer, "/") {
+		return Claims{}, errors.Errorf("issuer mismatch. expected %s, got %s", p.Config.Issuer, claims.Issuer)
+	}
+	if !claims.Audience.contains(p.Config.ClientID) {
+		return Claims{}, errors.New("the token was not issued for this client")
+	}
+	if claims.Subject == "" {
+		return Claims{}, errors.New("the token does not have a subject")
+	}
+
+	now := p.Clock.Now()
+	if now.After(time.Unix(claims.Expiry, 0).Add(clockSkew)) {
+		return Claims{}, errors.New("the token has expired")
+	}
+
+	return claims, nil
+}
+
+// jwk is a JSON web key
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// parseRSAKey parses the RSA public key in the given JSON web key
+func parseRSAKey(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding modulus")
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding exponent")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+// fetchKeys fetches the RSA signing keys of the provider
+func (p *Provider) fetchKeys() (map[string]*rsa.PublicKey, error) {
+	m, err := p.getMetadata()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting metadata")
+	}
+
+	res, err := p.HTTPClient.Get(m.JWKSURI)
+	if err != nil {
+		return nil, errors.Wrap(err, "requesting keys")
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, errors.Errorf("the key set responded with status %d", res.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := decodeResponse(res, &set); err != nil {
+		return nil, errors.Wrap(err, "decoding keys")
+	}
+
+	ret := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+
+		key, err := parseRSAKey(k)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing key %s", k.Kid)
+		}
+		ret[k.Kid] = key
+	}
+
+	return ret, nil
+}
+
+// keysRefetchInterval is the minimum interval at which the keys are fetched again
+// for an unknown key, so that the tokens with made-up key ids do not make the
+// provider be requested on every verification
+const keysRefetchInterval = time.Minute
+
+// getKey returns the signing key of the given id. The keys are fetched again if
+// the key is not known, in case the provider has rotated its keys, unless they
+// were fetched recently.
+func (p *Provider) getKey(kid string) (*rsa.PublicKey, error) {
+	p.mtx.Lock()
+	key, ok := p.keys[kid]
+	p.mtx.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	p.keysMtx.Lock()
+	defer p.keysMtx.Unlock()
+
+	// the keys might have been fetched while waiting for the lock
+	p.mtx.Lock()
+	key, ok = p.keys[kid]
+	fetchedAt := p.keysFetchedAt
+	p.mtx.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	now := p.Clock.Now()
+	if !fetchedAt.IsZero() && now.Sub(fetchedAt) < keysRefetchInterval {
+		return nil, errors.Errorf("unknown key %s", kid)
+	}
+
+	keys, err := p.fetchKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mtx.Lock()
+	p.keys = keys
+	p.keysFetchedAt = now
+	p.mtx.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, errors.Errorf("unknown key %s", kid)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,79 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	windowStart time.Time
+	expiresAt   time.Time
+	count       int
+}
+
+// MemoryStore is a store that keeps the counts in the memory of the process.
+// The limits are not shared by multiple instances of the server.
+type MemoryStore struct {
+	mtx       sync.Mutex
+	entries   map[string]*memoryEntry
+	lastSweep time.Time
+}
+
+// NewMemoryStore returns a new in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: map[string]*memoryEntry{},
+	}
+}
+
+// sweep deletes the entries whose windows have ended. The caller must hold the lock.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, e := range s.entries {
+		if !now.Before(e.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+
+	s.lastSweep = now
+}
+
+// Increment records a request for the key in the window that starts at the given time
+func (s *MemoryStore) Increment(key string, windowStart time.Time, window time.Duration) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if windowStart.Sub(s.lastSweep) > time.Minute {
+		s.sweep(windowStart)
+	}
+
+	e, ok := s.entries[key]
+	if !ok || !e.windowStart.Equal(windowStart) {
+		e = &memoryEntry{
+			windowStart: windowStart,
+			expiresAt:   windowStart.Add(window),
+		}
+		s.entries[key] = e
+	}
+
+	e.count++
+
+	return e.count, nil
+}
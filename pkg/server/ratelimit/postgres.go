@@ -0,0 +1,80 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// PostgresStore is a store that keeps the counts in the database, so that the
+// limits are shared by all instances of the server using the same database
+type PostgresStore struct {
+	db        *gorm.DB
+	mtx       sync.Mutex
+	lastPurge time.Time
+}
+
+// NewPostgresStore returns a new store backed by the given database
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{
+		db: db,
+	}
+}
+
+// purge deletes the counters whose windows have ended, at most once a minute
+func (s *PostgresStore) purge(now time.Time) error {
+	s.mtx.Lock()
+	if now.Sub(s.lastPurge) < time.Minute {
+		s.mtx.Unlock()
+		return nil
+	}
+	s.lastPurge = now
+	s.mtx.Unlock()
+
+	if err := s.db.Where("expires_at <= ?", now).Delete(&database.RateLimitCounter{}).Error; err != nil {
+		return errors.Wrap(err, "deleting expired counters")
+	}
+
+	return nil
+}
+
+// Increment records a request for the key in the window that starts at the given time
+func (s *PostgresStore) Increment(key string, windowStart time.Time, window time.Duration) (int, error) {
+	if err := s.purge(windowStart); err != nil {
+		return 0, errors.Wrap(err, "purging counters")
+	}
+
+	var result struct {
+		Count int
+	}
+
+	if err := s.db.Raw(`INSERT INTO rate_limit_counters (key, window_start, expires_at, count, created_at, updated_at)
+	VALUES (?, ?, ?, 1, NOW(), NOW())
+	ON CONFLICT (key, window_start) DO UPDATE SET count = rate_limit_counters.count + 1, updated_at = NOW()
+	RETURNING count`, key, windowStart.UTC(), windowStart.Add(window).UTC()).Scan(&result).Error; err != nil {
+		return 0, errors.Wrap(err, "upserting counter")
+	}
+
+	return result.Count, nil
+}
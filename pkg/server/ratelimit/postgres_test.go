@@ -0,0 +1,81 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
+)
+
+func init() {
+	testutils.InitTestDB()
+}
+
+func TestPostgresStoreIncrement(t *testing.T) {
+	defer testutils.ClearData()
+
+	s := NewPostgresStore(database.DBConn)
+	start := time.Date(2019, time.November, 1, 10, 0, 0, 0, time.UTC)
+
+	for i := 1; i <= 3; i++ {
+		count, err := s.Increment("default:1.2.3.4", start, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, count, i, "count mismatch")
+	}
+
+	count, err := s.Increment("default:5.6.7.8", start, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, count, 1, "other key count mismatch")
+
+	count, err = s.Increment("default:1.2.3.4", start.Add(time.Minute), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, count, 1, "next window count mismatch")
+}
+
+func TestPostgresStorePurge(t *testing.T) {
+	defer testutils.ClearData()
+
+	db := database.DBConn
+	s := NewPostgresStore(db)
+	start := time.Date(2019, time.November, 1, 10, 0, 0, 0, time.UTC)
+
+	if _, err := s.Increment("default:1.2.3.4", start, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Increment("default:1.2.3.4", start.Add(5*time.Minute), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	var counters []database.RateLimitCounter
+	testutils.MustExec(t, db.Find(&counters), "finding counters")
+
+	assert.Equal(t, len(counters), 1, "counter count mismatch")
+	assert.Equal(t, counters[0].WindowStart.Unix(), start.Add(5*time.Minute).Unix(), "window start mismatch")
+}
@@ -0,0 +1,120 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ratelimit
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// TrustedProxies is a list of networks of the reverse proxies whose forwarding
+// headers can be trusted
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a comma-separated list of IP addresses and CIDR ranges
+func ParseTrustedProxies(s string) (TrustedProxies, error) {
+	ret := TrustedProxies{}
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, errors.Errorf("invalid IP address '%s'", part)
+			}
+
+			if ip.To4() != nil {
+				part = part + "/32"
+			} else {
+				part = part + "/128"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing '%s'", part)
+		}
+
+		ret = append(ret, n)
+	}
+
+	return ret, nil
+}
+
+// contains checks if the given address belongs to any of the trusted networks
+func (t TrustedProxies) contains(addr string) bool {
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range t {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getRemoteIP returns the IP address of the immediate peer of the request
+func getRemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// ClientIP returns the IP address of the client that made the request. The forwarding
+// headers are only considered if the request comes from a trusted proxy, in which case
+// the rightmost address in X-Forwarded-For that is not a trusted proxy is returned.
+func (t TrustedProxies) ClientIP(r *http.Request) string {
+	remoteIP := getRemoteIP(r)
+	if !t.contains(remoteIP) {
+		return remoteIP
+	}
+
+	if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+		parts := strings.Split(forwardedFor, ",")
+
+		for i := len(parts) - 1; i >= 0; i-- {
+			addr := strings.TrimSpace(parts[i])
+			if !t.contains(addr) {
+				return addr
+			}
+		}
+
+		return strings.TrimSpace(parts[0])
+	}
+
+	if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+		return strings.TrimSpace(realIP)
+	}
+
+	return remoteIP
+}
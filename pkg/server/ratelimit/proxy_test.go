@@ -0,0 +1,110 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected []string
+	}{
+		{"", []string{}},
+		{"10.0.0.1", []string{"10.0.0.1/32"}},
+		{"10.0.0.0/8, 172.16.0.0/12", []string{"10.0.0.0/8", "172.16.0.0/12"}},
+		{"::1", []string{"::1/128"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("input %s", tc.input), func(t *testing.T) {
+			result, err := ParseTrustedProxies(tc.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []string{}
+			for _, n := range result {
+				got = append(got, n.String())
+			}
+
+			assert.DeepEqual(t, got, tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestParseTrustedProxies_Invalid(t *testing.T) {
+	testCases := []string{"foo", "10.0.0.1/99", "10.0.0.1,bar"}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("input %s", tc), func(t *testing.T) {
+			_, err := ParseTrustedProxies(tc)
+
+			assert.NotEqual(t, err, nil, "error mismatch")
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		remoteAddr   string
+		forwardedFor string
+		realIP       string
+		expected     string
+	}{
+		// direct requests cannot spoof their addresses
+		{"1.2.3.4:5000", "", "", "1.2.3.4"},
+		{"1.2.3.4:5000", "5.6.7.8", "", "1.2.3.4"},
+		{"1.2.3.4:5000", "", "5.6.7.8", "1.2.3.4"},
+		// requests through a trusted proxy
+		{"10.0.0.1:5000", "5.6.7.8", "", "5.6.7.8"},
+		{"10.0.0.1:5000", "9.9.9.9, 5.6.7.8, 10.0.0.2", "", "5.6.7.8"},
+		{"10.0.0.1:5000", "10.0.0.3, 10.0.0.2", "", "10.0.0.3"},
+		{"10.0.0.1:5000", "", "5.6.7.8", "5.6.7.8"},
+		{"10.0.0.1:5000", "", "", "10.0.0.1"},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			r, err := http.NewRequest("GET", "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.RemoteAddr = tc.remoteAddr
+			if tc.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+			if tc.realIP != "" {
+				r.Header.Set("X-Real-IP", tc.realIP)
+			}
+
+			assert.Equal(t, proxies.ClientIP(r), tc.expected, "result mismatch")
+		})
+	}
+}
@@ -0,0 +1,101 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package ratelimit provides rate limiting of requests based on policies,
+// backed by stores that can be shared by multiple instances of the server
+package ratelimit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/pkg/errors"
+)
+
+// Policy is a rule that allows at most Limit requests in every Window
+type Policy struct {
+	// Name identifies the policy. The routes with the same policy share the limit.
+	Name   string
+	Limit  int
+	Window time.Duration
+	// PerUser makes the limit apply to each authenticated user rather than to
+	// each IP address. Unauthenticated requests are still limited per IP address.
+	PerUser bool
+}
+
+// Store keeps the number of requests made in a window of time
+type Store interface {
+	// Increment records a request for the key in the window that starts at the given
+	// time, and returns the number of requests for the key in the window
+	Increment(key string, windowStart time.Time, window time.Duration) (int, error)
+}
+
+// Result is the outcome of checking a request against a policy
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the duration until the current window ends
+	Reset time.Duration
+}
+
+// Limiter checks requests against policies using a store
+type Limiter struct {
+	store Store
+	clock clock.Clock
+}
+
+// NewLimiter returns a new limiter backed by the given store
+func NewLimiter(store Store, c clock.Clock) *Limiter {
+	return &Limiter{
+		store: store,
+		clock: c,
+	}
+}
+
+// Allow records a request made by the given identity and checks if the request
+// is allowed by the policy
+func (l *Limiter) Allow(p Policy, identity string) (Result, error) {
+	if p.Window <= 0 || p.Limit <= 0 {
+		return Result{}, errors.Errorf("invalid policy %s", p.Name)
+	}
+
+	now := l.clock.Now()
+	windowStart := now.Truncate(p.Window)
+	key := fmt.Sprintf("%s:%s", p.Name, identity)
+
+	count, err := l.store.Increment(key, windowStart, p.Window)
+	if err != nil {
+		return Result{}, errors.Wrap(err, "incrementing count")
+	}
+
+	remaining := p.Limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	ret := Result{
+		Allowed:   count <= p.Limit,
+		Limit:     p.Limit,
+		Remaining: remaining,
+		Reset:     windowStart.Add(p.Window).Sub(now),
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,115 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/clock"
+)
+
+func TestLimiterAllow(t *testing.T) {
+	c := clock.NewMock()
+	c.SetNow(time.Date(2019, time.November, 1, 10, 0, 10, 0, time.UTC))
+
+	l := NewLimiter(NewMemoryStore(), c)
+	p := Policy{Name: "test", Limit: 2, Window: time.Minute}
+
+	res, err := l.Allow(p, "1.2.3.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, res.Allowed, true, "1st request Allowed mismatch")
+	assert.Equal(t, res.Limit, 2, "1st request Limit mismatch")
+	assert.Equal(t, res.Remaining, 1, "1st request Remaining mismatch")
+	assert.Equal(t, res.Reset, 50*time.Second, "1st request Reset mismatch")
+
+	res, err = l.Allow(p, "1.2.3.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, res.Allowed, true, "2nd request Allowed mismatch")
+	assert.Equal(t, res.Remaining, 0, "2nd request Remaining mismatch")
+
+	res, err = l.Allow(p, "1.2.3.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, res.Allowed, false, "3rd request Allowed mismatch")
+	assert.Equal(t, res.Remaining, 0, "3rd request Remaining mismatch")
+
+	// other identities and policies have their own limits
+	res, err = l.Allow(p, "5.6.7.8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, res.Allowed, true, "other identity Allowed mismatch")
+
+	res, err = l.Allow(Policy{Name: "other", Limit: 2, Window: time.Minute}, "1.2.3.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, res.Allowed, true, "other policy Allowed mismatch")
+
+	// the limit is reset in the next window
+	c.SetNow(time.Date(2019, time.November, 1, 10, 1, 0, 0, time.UTC))
+
+	res, err = l.Allow(p, "1.2.3.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, res.Allowed, true, "next window Allowed mismatch")
+	assert.Equal(t, res.Remaining, 1, "next window Remaining mismatch")
+	assert.Equal(t, res.Reset, time.Minute, "next window Reset mismatch")
+}
+
+func TestLimiterAllow_InvalidPolicy(t *testing.T) {
+	l := NewLimiter(NewMemoryStore(), clock.NewMock())
+
+	testCases := []Policy{
+		{Name: "zero limit", Limit: 0, Window: time.Minute},
+		{Name: "zero window", Limit: 10, Window: 0},
+	}
+
+	for _, p := range testCases {
+		t.Run(p.Name, func(t *testing.T) {
+			_, err := l.Allow(p, "1.2.3.4")
+
+			assert.NotEqual(t, err, nil, "error mismatch")
+		})
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	s := NewMemoryStore()
+	start := time.Date(2019, time.November, 1, 10, 0, 0, 0, time.UTC)
+
+	if _, err := s.Increment("a", start, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Increment("b", start.Add(2*time.Minute), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(s.entries), 1, "entries count mismatch")
+	_, ok := s.entries["b"]
+	assert.Equal(t, ok, true, "entry b mismatch")
+}
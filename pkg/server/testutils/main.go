@@ -184,6 +184,45 @@ func ClearData() {
 	if err := db.Delete(&database.RepetitionRule{}).Error; err != nil {
 		panic(errors.Wrap(err, "Failed to clear digests"))
 	}
+	if err := db.Delete(&database.Webhook{}).Error; err != nil {
+		panic(errors.Wrap(err, "Failed to clear webhooks"))
+	}
+	if err := db.Delete(&database.WebhookDelivery{}).Error; err != nil {
+		panic(errors.Wrap(err, "Failed to clear webhook deliveries"))
+	}
+	if err := db.Delete(&database.BookMember{}).Error; err != nil {
+		panic(errors.Wrap(err, "Failed to clear book members"))
+	}
+	if err := db.Delete(&database.BookInvitation{}).Error; err != nil {
+		panic(errors.Wrap(err, "Failed to clear book invitations"))
+	}
+	if err := db.Delete(&database.SharedUSN{}).Error; err != nil {
+		panic(errors.Wrap(err, "Failed to clear shared usns"))
+	}
+	if err := db.Delete(&database.Organization{}).Error; err != nil {
+		panic(errors.Wrap(err, "Failed to clear organizations"))
+	}
+	if err := db.Delete(&database.OrganizationInvitation{}).Error; err != nil {
+		panic(errors.Wrap(err, "Failed to clear organization invitations"))
+	}
+	if err := db.Delete(&database.RateLimitCounter{}).Error; err != nil {
+		panic(errors.Wrap(err, "Failed to clear rate limit counters"))
+	}
+	if err := db.Delete(&database.Attachment{}).Error; err != nil {
+		panic(errors.Wrap(err, "Failed to clear attachments"))
+	}
+	if err := db.Delete(&database.NoteLink{}).Error; err != nil {
+		panic(errors.Wrap(err, "Failed to clear note links"))
+	}
+	if err := db.Delete(&database.RecoveryCode{}).Error; err != nil {
+		panic(errors.Wrap(err, "Failed to clear recovery codes"))
+	}
+	if err := db.Delete(&database.AuditEvent{}).Error; err != nil {
+		panic(errors.Wrap(err, "Failed to clear audit events"))
+	}
+	if err := db.Delete(&database.OIDCAuthRequest{}).Error; err != nil {
+		panic(errors.Wrap(err, "Failed to clear OIDC auth requests"))
+	}
 }
 
 // HTTPDo makes an HTTP request and returns a response
@@ -0,0 +1,124 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package totp
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+)
+
+// rfcSecret is the base32 encoding of the secret used by the test vectors in RFC 6238
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestGenerate(t *testing.T) {
+	testCases := []struct {
+		unix     int64
+		expected string
+	}{
+		{unix: 59, expected: "287082"},
+		{unix: 1111111109, expected: "081804"},
+		{unix: 1111111111, expected: "050471"},
+		{unix: 1234567890, expected: "005924"},
+		{unix: 2000000000, expected: "279037"},
+		{unix: 20000000000, expected: "353130"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("time %d", tc.unix), func(t *testing.T) {
+			got, err := Generate(rfcSecret, time.Unix(tc.unix, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, got, tc.expected, "code mismatch")
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	testCases := []struct {
+		code         string
+		expectedStep int64
+		expectedOK   bool
+	}{
+		{code: "050471", expectedStep: Step(now), expectedOK: true},
+		{code: "050 471", expectedStep: Step(now), expectedOK: true},
+		// previous period
+		{code: "081804", expectedStep: Step(now) - 1, expectedOK: true},
+		{code: "000000", expectedStep: 0, expectedOK: false},
+		{code: "05047", expectedStep: 0, expectedOK: false},
+		{code: "", expectedStep: 0, expectedOK: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("code %s", tc.code), func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tc.code, now)
+
+			assert.Equal(t, ok, tc.expectedOK, "ok mismatch")
+			assert.Equal(t, step, tc.expectedStep, "step mismatch")
+		})
+	}
+}
+
+func TestValidate_outsideSkew(t *testing.T) {
+	code, err := Generate(rfcSecret, time.Unix(1111111111, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ok := Validate(rfcSecret, code, time.Unix(1111111111+3*Period, 0))
+	assert.Equal(t, ok, false, "code from an old period should be rejected")
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := decodeSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(key), secretSize, "secret size mismatch")
+
+	now := time.Now()
+	code, err := Generate(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ok := Validate(secret, code, now)
+	assert.Equal(t, ok, true, "generated code should be valid")
+}
+
+func TestURI(t *testing.T) {
+	got := URI(rfcSecret, "Dnote", "alice@example.com")
+
+	assert.Equal(t, strings.HasPrefix(got, "otpauth://totp/Dnote:alice@example.com?"), true, "prefix mismatch")
+	assert.Equal(t, strings.Contains(got, "secret="+rfcSecret), true, "secret missing")
+	assert.Equal(t, strings.Contains(got, "issuer=Dnote"), true, "issuer missing")
+	assert.Equal(t, strings.Contains(got, "digits=6"), true, "digits missing")
+	assert.Equal(t, strings.Contains(got, "period=30"), true, "period missing")
+}
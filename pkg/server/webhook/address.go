@@ -0,0 +1,213 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package webhook
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// allowedNetworks are the private networks to which the deliveries are allowed,
+// such as the network of a chat or a wiki hosted alongside the server. They are
+// set by SetAllowedNetworks when the server starts.
+var allowedNetworks = []*net.IPNet{}
+
+// SetAllowedNetworks allows the deliveries to the addresses in the given networks
+// even if they are private
+func SetAllowedNetworks(networks []*net.IPNet) {
+	allowedNetworks = networks
+}
+
+// ParseNetworks parses the given IP addresses or CIDR ranges. An address is
+// treated as a network of that single address.
+func ParseNetworks(vals []string) ([]*net.IPNet, error) {
+	ret := []*net.IPNet{}
+
+	for _, val := range vals {
+		val = strings.TrimSpace(val)
+		if val == "" {
+			continue
+		}
+
+		if !strings.Contains(val, "/") {
+			ip := net.ParseIP(val)
+			if ip == nil {
+				return nil, errors.Errorf("invalid IP address '%s'", val)
+			}
+
+			if ip.To4() != nil {
+				val = val + "/32"
+			} else {
+				val = val + "/128"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(val)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing '%s'", val)
+		}
+
+		ret = append(ret, n)
+	}
+
+	return ret, nil
+}
+
+// isAllowedIP checks if the given address is in one of the allowed networks
+func isAllowedIP(ip net.IP) bool {
+	for _, n := range allowedNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isPrivateIP checks if the given address is not reachable on the public
+// internet, such as a loopback, private or link-local address
+func isPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var privateNetworks = mustParseCIDRs(
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	// shared address space used by carrier-grade NAT
+	"100.64.0.0/10",
+	"fc00::/7",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	ret := []*net.IPNet{}
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+
+		ret = append(ret, n)
+	}
+
+	return ret
+}
+
+// checkIP returns an error if a delivery must not be made to the given address
+func checkIP(ip net.IP) error {
+	if isPrivateIP(ip) && !isAllowedIP(ip) {
+		return errors.Errorf("%s is in a private network", ip)
+	}
+
+	return nil
+}
+
+// lookupIP resolves the IP addresses of the given host
+var lookupIP = func(host string) ([]net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(context.Background(), host)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []net.IP{}
+	for _, a := range addrs {
+		ret = append(ret, a.IP)
+	}
+
+	return ret, nil
+}
+
+// ValidateURL returns an error if the given URL cannot be the endpoint of a
+// webhook. The endpoint must use http or https, and its host must not be in a
+// private network other than the allowed networks, so that the webhooks cannot
+// be used to reach the services in the network of the server. As the host can resolve to a different address
+// later, the address is checked again when a delivery is made.
+func ValidateURL(val string) error {
+	u, err := url.Parse(val)
+	if err != nil {
+		return errors.Wrap(err, "parsing url")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("url must use http or https")
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return errors.New("url must have a host")
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		if err := checkIP(net.IPv4(127, 0, 0, 1)); err != nil {
+			return errors.New("url must not point to the local host")
+		}
+
+		return nil
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return checkIP(ip)
+	}
+
+	// a host that cannot be resolved yet is not rejected, because it might be
+	// a temporary failure of DNS
+	ips, err := lookupIP(host)
+	if err != nil {
+		return nil
+	}
+	for _, ip := range ips {
+		if err := checkIP(ip); err != nil {
+			return errors.Wrapf(err, "resolving %s", host)
+		}
+	}
+
+	return nil
+}
+
+// dialControl rejects the connections to the addresses in the private networks
+// other than the allowed networks.
+// It checks the address after the host is resolved, so that a host cannot be
+// made to resolve to a private address after it was validated.
+func dialControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.Wrap(err, "splitting the address")
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return errors.Errorf("invalid address %s", host)
+	}
+
+	return checkIP(ip)
+}
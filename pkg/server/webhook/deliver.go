@@ -0,0 +1,201 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxAttempts is the number of attempts after which a delivery is abandoned
+	maxAttempts = 6
+	// batchSize is the maximum number of deliveries processed in one run
+	batchSize = 100
+	// claimDuration is the duration for which the deliveries claimed by a run are not
+	// attempted by another run. It is longer than the time it takes to process a batch.
+	claimDuration = 30 * time.Minute
+
+	// HeaderEvent is the header containing the event type of the delivery
+	HeaderEvent = "X-Dnote-Event"
+	// HeaderDelivery is the header containing the uuid of the delivery
+	HeaderDelivery = "X-Dnote-Delivery"
+	// HeaderSignature is the header containing the HMAC signature of the body
+	HeaderSignature = "X-Dnote-Signature"
+)
+
+var httpClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: dialControl,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+// Sign returns the signature of the given body computed with the given secret.
+// The signature is a hex encoded HMAC-SHA256 prefixed by the name of the algorithm.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return fmt.Sprintf("sha256=%s", hex.EncodeToString(mac.Sum(nil)))
+}
+
+// getBackoff returns the duration to wait before the next attempt
+// given the number of attempts made so far
+func getBackoff(attempts int) time.Duration {
+	return time.Duration(1<<uint(attempts)) * time.Minute
+}
+
+func send(hook database.Webhook, d database.WebhookDelivery) (int, error) {
+	body := []byte(d.Payload)
+
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, errors.Wrap(err, "constructing http request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Dnote-Webhook")
+	req.Header.Set(HeaderEvent, d.Event)
+	req.Header.Set(HeaderDelivery, d.UUID)
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, body))
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "making http request")
+	}
+	defer res.Body.Close()
+
+	// Drain the body so that the connection can be reused
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, errors.Errorf("receiver responded with %d", res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
+
+func process(now time.Time, d database.WebhookDelivery) error {
+	db := database.DBConn
+
+	var hook database.Webhook
+	if err := db.Where("id = ?", d.WebhookID).First(&hook).Error; err != nil {
+		return errors.Wrap(err, "finding webhook")
+	}
+
+	code, sendErr := send(hook, d)
+
+	d.Attempts = d.Attempts + 1
+	d.ResponseCode = code
+
+	if sendErr == nil {
+		d.Status = database.WebhookDeliveryStatusSucceeded
+		d.Error = ""
+		d.DeliveredAt = &now
+		d.NextAttemptAt = nil
+	} else {
+		d.Error = sendErr.Error()
+
+		if d.Attempts >= maxAttempts {
+			d.Status = database.WebhookDeliveryStatusFailed
+			d.NextAttemptAt = nil
+		} else {
+			next := now.Add(getBackoff(d.Attempts))
+			d.NextAttemptAt = &next
+		}
+	}
+
+	if err := db.Save(&d).Error; err != nil {
+		return errors.Wrap(err, "saving the delivery")
+	}
+
+	return nil
+}
+
+// claim marks the deliveries that are due as being attempted, and returns them.
+// The deliveries are locked while being claimed so that the concurrent runs, such
+// as the ones in other instances of the server, do not attempt the same delivery.
+// A delivery that is not processed, because the run was interrupted, is attempted
+// again once the claim expires.
+func claim(now time.Time) ([]database.WebhookDelivery, error) {
+	db := database.DBConn
+
+	var ret []database.WebhookDelivery
+	if err := db.Raw(`UPDATE webhook_deliveries SET next_attempt_at = ?
+	WHERE id IN (
+		SELECT webhook_deliveries.id
+		FROM webhook_deliveries
+		INNER JOIN webhooks ON webhooks.id = webhook_deliveries.webhook_id
+		WHERE webhooks.enabled AND webhook_deliveries.status = ?
+			AND (webhook_deliveries.next_attempt_at IS NULL OR webhook_deliveries.next_attempt_at <= ?)
+		ORDER BY webhook_deliveries.id ASC
+		LIMIT ?
+		FOR UPDATE OF webhook_deliveries SKIP LOCKED
+	)
+	RETURNING *`, now.Add(claimDuration), database.WebhookDeliveryStatusPending, now, batchSize).
+		Scan(&ret).Error; err != nil {
+		return nil, errors.Wrap(err, "claiming deliveries")
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID < ret[j].ID
+	})
+
+	return ret, nil
+}
+
+// Do attempts the deliveries that are due
+func Do(c clock.Clock) error {
+	now := c.Now().UTC()
+
+	deliveries, err := claim(now)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range deliveries {
+		if err := process(now, d); err != nil {
+			log.WithFields(log.Fields{
+				"delivery_uuid": d.UUID,
+			}).ErrorWrap(err, "Could not process the webhook delivery")
+			continue
+		}
+	}
+
+	return nil
+}
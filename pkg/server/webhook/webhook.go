@@ -0,0 +1,137 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package webhook provides the delivery of events about user data to the
+// endpoints configured by the users
+package webhook
+
+import (
+	"encoding/json"
+
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+)
+
+// Events is a list of all events that can be subscribed to
+var Events = []string{
+	database.WebhookEventNoteCreated,
+	database.WebhookEventNoteUpdated,
+	database.WebhookEventNoteDeleted,
+	database.WebhookEventBookCreated,
+	database.WebhookEventBookUpdated,
+	database.WebhookEventBookDeleted,
+}
+
+// ValidateEvent returns an error if the given event is not supported
+func ValidateEvent(event string) error {
+	for _, e := range Events {
+		if e == event {
+			return nil
+		}
+	}
+
+	return errors.Errorf("unsupported event %s", event)
+}
+
+// Envelope is the body of a delivery
+type Envelope struct {
+	Event     string      `json:"event"`
+	Timestamp int64       `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// NoteData is the data about a note included in the note events
+type NoteData struct {
+	UUID     string `json:"uuid"`
+	BookUUID string `json:"book_uuid"`
+	Content  string `json:"content"`
+	AddedOn  int64  `json:"added_on"`
+	EditedOn int64  `json:"edited_on"`
+	Public   bool   `json:"public"`
+	USN      int    `json:"usn"`
+}
+
+// NewNoteData returns a NoteData for the given note
+func NewNoteData(note database.Note) NoteData {
+	return NoteData{
+		UUID:     note.UUID,
+		BookUUID: note.BookUUID,
+		Content:  note.Body,
+		AddedOn:  note.AddedOn,
+		EditedOn: note.EditedOn,
+		Public:   note.Public,
+		USN:      note.USN,
+	}
+}
+
+// BookData is the data about a book included in the book events
+type BookData struct {
+	UUID       string `json:"uuid"`
+	Label      string `json:"label"`
+	ParentUUID string `json:"parent_uuid"`
+	USN        int    `json:"usn"`
+}
+
+// NewBookData returns a BookData for the given book
+func NewBookData(book database.Book) BookData {
+	return BookData{
+		UUID:       book.UUID,
+		Label:      book.Label,
+		ParentUUID: book.ParentUUID,
+		USN:        book.USN,
+	}
+}
+
+// Enqueue records a pending delivery of the event for every enabled webhook of the user
+// that subscribes to the event. It is meant to be called in the same transaction as the
+// change that the event describes, so that the deliveries exist only if the change is committed.
+func Enqueue(tx *gorm.DB, c clock.Clock, userID int, event string, data interface{}) error {
+	var hooks []database.Webhook
+	if err := tx.Where("user_id = ? AND enabled AND ? = ANY(events)", userID, event).Find(&hooks).Error; err != nil {
+		return errors.Wrap(err, "finding webhooks")
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	envelope := Envelope{
+		Event:     event,
+		Timestamp: c.Now().Unix(),
+		Data:      data,
+	}
+	b, err := json.Marshal(envelope)
+	if err != nil {
+		return errors.Wrap(err, "marshalling the payload")
+	}
+
+	for _, hook := range hooks {
+		d := database.WebhookDelivery{
+			WebhookID: hook.ID,
+			Event:     event,
+			Payload:   string(b),
+			Status:    database.WebhookDeliveryStatusPending,
+		}
+		if err := tx.Create(&d).Error; err != nil {
+			return errors.Wrapf(err, "creating a delivery for webhook %s", hook.UUID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,404 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dnote/dnote/pkg/assert"
+	"github.com/dnote/dnote/pkg/clock"
+	"github.com/dnote/dnote/pkg/server/database"
+	"github.com/dnote/dnote/pkg/server/testutils"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	testutils.InitTestDB()
+}
+
+func TestSign(t *testing.T) {
+	got := Sign("secret", []byte(`{"event":"note.created"}`))
+
+	assert.Equal(t, got, "sha256=3d565b4d500d2bfec099463976a887178a81e45f976234a74fb0daea4f029659", "signature mismatch")
+	assert.NotEqual(t, got, Sign("another-secret", []byte(`{"event":"note.created"}`)), "signature should depend on the secret")
+}
+
+func TestEnqueue(t *testing.T) {
+	defer testutils.ClearData()
+	db := database.DBConn
+
+	user := testutils.SetupUserData()
+	anotherUser := testutils.SetupUserData()
+
+	w1 := database.Webhook{UserID: user.ID, URL: "https://example.com/1", Events: []string{database.WebhookEventNoteCreated}, Enabled: true}
+	testutils.MustExec(t, db.Save(&w1), "preparing w1")
+	w2 := database.Webhook{UserID: user.ID, URL: "https://example.com/2", Events: []string{database.WebhookEventBookCreated}, Enabled: true}
+	testutils.MustExec(t, db.Save(&w2), "preparing w2")
+	w3 := database.Webhook{UserID: user.ID, URL: "https://example.com/3", Events: []string{database.WebhookEventNoteCreated}, Enabled: true}
+	testutils.MustExec(t, db.Save(&w3), "preparing w3")
+	testutils.MustExec(t, db.Model(&w3).Update("enabled", false), "disabling w3")
+	w4 := database.Webhook{UserID: anotherUser.ID, URL: "https://example.com/4", Events: []string{database.WebhookEventNoteCreated}, Enabled: true}
+	testutils.MustExec(t, db.Save(&w4), "preparing w4")
+
+	c := clock.NewMock()
+	c.SetNow(time.Date(2019, time.November, 1, 2, 3, 0, 0, time.UTC))
+
+	// Execute
+	tx := db.Begin()
+	data := NewNoteData(database.Note{UUID: "2d3a1c4e-3b5a-4d65-9b0b-2f1e0c6f1d11", Body: "foo"})
+	if err := Enqueue(tx, c, user.ID, database.WebhookEventNoteCreated, data); err != nil {
+		tx.Rollback()
+		t.Fatal(errors.Wrap(err, "enqueueing"))
+	}
+	tx.Commit()
+
+	// Test
+	var deliveries []database.WebhookDelivery
+	testutils.MustExec(t, db.Find(&deliveries), "finding deliveries")
+
+	assert.Equal(t, len(deliveries), 1, "delivery count mismatch")
+	assert.Equal(t, deliveries[0].WebhookID, w1.ID, "WebhookID mismatch")
+	assert.Equal(t, deliveries[0].Event, database.WebhookEventNoteCreated, "Event mismatch")
+	assert.Equal(t, deliveries[0].Status, database.WebhookDeliveryStatusPending, "Status mismatch")
+
+	var envelope Envelope
+	if err := json.Unmarshal([]byte(deliveries[0].Payload), &envelope); err != nil {
+		t.Fatal(errors.Wrap(err, "decoding the payload"))
+	}
+	assert.Equal(t, envelope.Timestamp, c.Now().Unix(), "Timestamp mismatch")
+}
+
+// allowPrivate allows the deliveries to the receivers of the tests, which listen
+// on the loopback, and returns a function to restore the setting
+func allowPrivate() func() {
+	SetAllowedNetworks(mustParseCIDRs("127.0.0.0/8", "::1/128"))
+
+	return func() {
+		SetAllowedNetworks([]*net.IPNet{})
+	}
+}
+
+func TestDo(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		defer allowPrivate()()
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		var gotSignature, gotEvent, gotBody string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := ioutil.ReadAll(r.Body)
+			gotBody = string(b)
+			gotSignature = r.Header.Get(HeaderSignature)
+			gotEvent = r.Header.Get(HeaderEvent)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer ts.Close()
+
+		user := testutils.SetupUserData()
+		w1 := database.Webhook{UserID: user.ID, URL: ts.URL, Secret: "secret", Events: []string{database.WebhookEventNoteCreated}, Enabled: true}
+		testutils.MustExec(t, db.Save(&w1), "preparing w1")
+		d1 := database.WebhookDelivery{WebhookID: w1.ID, Event: database.WebhookEventNoteCreated, Payload: `{"event":"note.created"}`, Status: database.WebhookDeliveryStatusPending}
+		testutils.MustExec(t, db.Save(&d1), "preparing d1")
+
+		c := clock.NewMock()
+		c.SetNow(time.Date(2019, time.November, 1, 2, 3, 0, 0, time.UTC))
+
+		// Execute
+		if err := Do(c); err != nil {
+			t.Fatal(errors.Wrap(err, "running"))
+		}
+
+		// Test
+		var deliveryRecord database.WebhookDelivery
+		testutils.MustExec(t, db.Where("id = ?", d1.ID).First(&deliveryRecord), "finding d1")
+
+		assert.Equal(t, gotBody, d1.Payload, "body mismatch")
+		assert.Equal(t, gotEvent, database.WebhookEventNoteCreated, "event header mismatch")
+		assert.Equal(t, gotSignature, Sign("secret", []byte(d1.Payload)), "signature mismatch")
+		assert.Equal(t, deliveryRecord.Status, database.WebhookDeliveryStatusSucceeded, "Status mismatch")
+		assert.Equal(t, deliveryRecord.Attempts, 1, "Attempts mismatch")
+		assert.Equal(t, deliveryRecord.ResponseCode, http.StatusNoContent, "ResponseCode mismatch")
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		defer allowPrivate()()
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer ts.Close()
+
+		user := testutils.SetupUserData()
+		w1 := database.Webhook{UserID: user.ID, URL: ts.URL, Secret: "secret", Events: []string{database.WebhookEventNoteCreated}, Enabled: true}
+		testutils.MustExec(t, db.Save(&w1), "preparing w1")
+		d1 := database.WebhookDelivery{WebhookID: w1.ID, Event: database.WebhookEventNoteCreated, Payload: "{}", Status: database.WebhookDeliveryStatusPending}
+		testutils.MustExec(t, db.Save(&d1), "preparing d1")
+		d2 := database.WebhookDelivery{WebhookID: w1.ID, Event: database.WebhookEventNoteCreated, Payload: "{}", Status: database.WebhookDeliveryStatusPending, Attempts: maxAttempts - 1}
+		testutils.MustExec(t, db.Save(&d2), "preparing d2")
+
+		now := time.Date(2019, time.November, 1, 2, 3, 0, 0, time.UTC)
+		c := clock.NewMock()
+		c.SetNow(now)
+
+		// Execute
+		if err := Do(c); err != nil {
+			t.Fatal(errors.Wrap(err, "running"))
+		}
+
+		// Test
+		var d1Record, d2Record database.WebhookDelivery
+		testutils.MustExec(t, db.Where("id = ?", d1.ID).First(&d1Record), "finding d1")
+		testutils.MustExec(t, db.Where("id = ?", d2.ID).First(&d2Record), "finding d2")
+
+		assert.Equal(t, d1Record.Status, database.WebhookDeliveryStatusPending, "d1 Status mismatch")
+		assert.Equal(t, d1Record.Attempts, 1, "d1 Attempts mismatch")
+		assert.Equal(t, d1Record.ResponseCode, http.StatusInternalServerError, "d1 ResponseCode mismatch")
+		assert.Equal(t, d1Record.NextAttemptAt.UTC(), now.Add(2*time.Minute), "d1 NextAttemptAt mismatch")
+
+		assert.Equal(t, d2Record.Status, database.WebhookDeliveryStatusFailed, "d2 Status mismatch")
+		assert.Equal(t, d2Record.Attempts, maxAttempts, "d2 Attempts mismatch")
+	})
+	t.Run("claimed", func(t *testing.T) {
+		defer allowPrivate()()
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		var count int
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			count++
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer ts.Close()
+
+		now := time.Date(2019, time.November, 1, 2, 3, 0, 0, time.UTC)
+		c := clock.NewMock()
+		c.SetNow(now)
+
+		user := testutils.SetupUserData()
+		w1 := database.Webhook{UserID: user.ID, URL: ts.URL, Secret: "secret", Events: []string{database.WebhookEventNoteCreated}, Enabled: true}
+		testutils.MustExec(t, db.Save(&w1), "preparing w1")
+		d1 := database.WebhookDelivery{WebhookID: w1.ID, Event: database.WebhookEventNoteCreated, Payload: "{}", Status: database.WebhookDeliveryStatusPending}
+		testutils.MustExec(t, db.Save(&d1), "preparing d1")
+
+		// Execute
+		claimed, err := claim(now)
+		if err != nil {
+			t.Fatal(errors.Wrap(err, "claiming"))
+		}
+		// another run while the delivery is being attempted
+		if err := Do(c); err != nil {
+			t.Fatal(errors.Wrap(err, "running"))
+		}
+
+		// Test
+		assert.Equal(t, len(claimed), 1, "claimed count mismatch")
+		assert.Equal(t, count, 0, "the claimed delivery should not have been attempted")
+
+		var d1Record database.WebhookDelivery
+		testutils.MustExec(t, db.Where("id = ?", d1.ID).First(&d1Record), "finding d1")
+		assert.Equal(t, d1Record.Attempts, 0, "Attempts mismatch")
+		assert.Equal(t, d1Record.NextAttemptAt.UTC(), now.Add(claimDuration), "NextAttemptAt mismatch")
+
+		// the claim expires if the run was interrupted
+		c.SetNow(now.Add(claimDuration))
+		if err := Do(c); err != nil {
+			t.Fatal(errors.Wrap(err, "running after the claim expired"))
+		}
+		assert.Equal(t, count, 1, "the delivery should have been attempted after the claim expired")
+	})
+
+	t.Run("private network", func(t *testing.T) {
+		defer testutils.ClearData()
+		db := database.DBConn
+
+		var count int
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			count++
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer ts.Close()
+
+		user := testutils.SetupUserData()
+		w1 := database.Webhook{UserID: user.ID, URL: ts.URL, Secret: "secret", Events: []string{database.WebhookEventNoteCreated}, Enabled: true}
+		testutils.MustExec(t, db.Save(&w1), "preparing w1")
+		d1 := database.WebhookDelivery{WebhookID: w1.ID, Event: database.WebhookEventNoteCreated, Payload: "{}", Status: database.WebhookDeliveryStatusPending}
+		testutils.MustExec(t, db.Save(&d1), "preparing d1")
+
+		c := clock.NewMock()
+		c.SetNow(time.Date(2019, time.November, 1, 2, 3, 0, 0, time.UTC))
+
+		// Execute
+		if err := Do(c); err != nil {
+			t.Fatal(errors.Wrap(err, "running"))
+		}
+
+		// Test
+		var d1Record database.WebhookDelivery
+		testutils.MustExec(t, db.Where("id = ?", d1.ID).First(&d1Record), "finding d1")
+
+		assert.Equal(t, count, 0, "the receiver should not have been reached")
+		assert.Equal(t, d1Record.Status, database.WebhookDeliveryStatusPending, "Status mismatch")
+		assert.Equal(t, d1Record.Attempts, 1, "Attempts mismatch")
+		assert.Equal(t, d1Record.ResponseCode, 0, "ResponseCode mismatch")
+	})
+}
+
+func TestValidateURL(t *testing.T) {
+	defaultLookupIP := lookupIP
+	defer func() {
+		lookupIP = defaultLookupIP
+	}()
+
+	lookupIP = func(host string) ([]net.IP, error) {
+		switch host {
+		case "example.com":
+			return []net.IP{net.ParseIP("93.184.216.34")}, nil
+		case "internal.example.com":
+			return []net.IP{net.ParseIP("93.184.216.34"), net.ParseIP("192.168.1.20")}, nil
+		default:
+			return nil, errors.New("no such host")
+		}
+	}
+
+	testCases := []struct {
+		url      string
+		expected bool
+	}{
+		{"https://example.com/hook", true},
+		{"http://93.184.216.34:8080/hook", true},
+		// cannot be resolved yet
+		{"https://unknown.example.com/hook", true},
+		{"ftp://example.com/hook", false},
+		{"https:///hook", false},
+		{"http://localhost:3000/hook", false},
+		{"http://api.localhost/hook", false},
+		{"http://127.0.0.1/hook", false},
+		{"http://[::1]/hook", false},
+		{"http://0.0.0.0/hook", false},
+		{"http://10.1.2.3/hook", false},
+		{"http://172.16.0.1/hook", false},
+		{"http://192.168.1.1/hook", false},
+		{"http://100.64.0.1/hook", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://[fe80::1]/hook", false},
+		{"http://[fd12:3456::1]/hook", false},
+		{"https://internal.example.com/hook", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.url, func(t *testing.T) {
+			err := ValidateURL(tc.url)
+			assert.Equal(t, err == nil, tc.expected, fmt.Sprintf("result mismatch: %v", err))
+		})
+	}
+}
+
+func TestValidateURL_AllowedNetworks(t *testing.T) {
+	defaultLookupIP := lookupIP
+	defer func() {
+		lookupIP = defaultLookupIP
+	}()
+
+	lookupIP = func(host string) ([]net.IP, error) {
+		switch host {
+		case "wiki.internal":
+			return []net.IP{net.ParseIP("10.1.2.3")}, nil
+		default:
+			return nil, errors.New("no such host")
+		}
+	}
+
+	networks, err := ParseNetworks([]string{"10.1.0.0/16", "192.168.1.5"})
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "parsing networks"))
+	}
+	SetAllowedNetworks(networks)
+	defer SetAllowedNetworks([]*net.IPNet{})
+
+	testCases := []struct {
+		url      string
+		expected bool
+	}{
+		{"http://10.1.2.3/hook", true},
+		{"http://wiki.internal/hook", true},
+		{"http://192.168.1.5/hook", true},
+		{"http://192.168.1.6/hook", false},
+		{"http://10.2.0.1/hook", false},
+		{"http://127.0.0.1/hook", false},
+		{"http://localhost:3000/hook", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.url, func(t *testing.T) {
+			err := ValidateURL(tc.url)
+			assert.Equal(t, err == nil, tc.expected, fmt.Sprintf("result mismatch: %v", err))
+		})
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	testCases := []struct {
+		input    []string
+		expected []string
+		valid    bool
+	}{
+		{
+			input:    []string{},
+			expected: []string{},
+			valid:    true,
+		},
+		{
+			input:    []string{"10.0.0.0/8", " 192.168.1.5 ", "fd00::1"},
+			expected: []string{"10.0.0.0/8", "192.168.1.5/32", "fd00::1/128"},
+			valid:    true,
+		},
+		{
+			input: []string{"not-an-address"},
+			valid: false,
+		},
+		{
+			input: []string{"10.0.0.0/33"},
+			valid: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%v", tc.input), func(t *testing.T) {
+			got, err := ParseNetworks(tc.input)
+
+			assert.Equal(t, err == nil, tc.valid, fmt.Sprintf("validity mismatch: %v", err))
+			if tc.valid {
+				strs := []string{}
+				for _, n := range got {
+					strs = append(strs, n.String())
+				}
+				assert.DeepEqual(t, strs, tc.expected, "result mismatch")
+			}
+		})
+	}
+}
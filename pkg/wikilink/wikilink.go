@@ -0,0 +1,91 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package wikilink parses the links between notes written in the body of a note
+// as [[note-uuid]] or [[book/title]]
+package wikilink
+
+import (
+	"regexp"
+	"strings"
+)
+
+var linkRegex = regexp.MustCompile(`\[\[([^\[\]\n]+)\]\]`)
+var uuidRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Link is a link to a note. It refers to the note either by UUID, or by the
+// path of the book and the title of the note.
+type Link struct {
+	// Text is the text between the brackets
+	Text  string
+	UUID  string
+	Book  string
+	Title string
+}
+
+// Parse returns the links in the given body in the order of their first
+// appearance. Text that does not form a valid link is ignored.
+func Parse(body string) []Link {
+	ret := []Link{}
+	seen := map[string]bool{}
+
+	for _, m := range linkRegex.FindAllStringSubmatch(body, -1) {
+		text := strings.TrimSpace(m[1])
+		if seen[text] {
+			continue
+		}
+
+		var l Link
+		if uuidRegex.MatchString(text) {
+			l = Link{Text: text, UUID: strings.ToLower(text)}
+		} else {
+			// The book is a path of nested books such as infra/k8s, so the
+			// title is after the last slash.
+			idx := strings.LastIndex(text, "/")
+			if idx == -1 {
+				continue
+			}
+
+			book := strings.TrimSpace(text[:idx])
+			title := strings.TrimSpace(text[idx+1:])
+			if book == "" || title == "" {
+				continue
+			}
+
+			l = Link{Text: text, Book: book, Title: title}
+		}
+
+		seen[text] = true
+		ret = append(ret, l)
+	}
+
+	return ret
+}
+
+// Title returns the title of a note with the given body, which is its first
+// non-empty line without the leading heading marks of Markdown.
+func Title(body string) string {
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#"))
+		if line != "" {
+			return line
+		}
+	}
+
+	return ""
+}
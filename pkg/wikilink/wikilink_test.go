@@ -0,0 +1,109 @@
+/* Copyright (C) 2019 Monomax Software Pty Ltd
+ *
+ * This file is part of Dnote.
+ *
+ * Dnote is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * Dnote is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with Dnote.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package wikilink
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dnote/dnote/pkg/assert"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		body     string
+		expected []Link
+	}{
+		{
+			body:     "no links",
+			expected: []Link{},
+		},
+		{
+			body: "see [[8f2d4bd4-7b1e-4b3d-8c39-2a9b0e5b1c3a]]",
+			expected: []Link{
+				{Text: "8f2d4bd4-7b1e-4b3d-8c39-2a9b0e5b1c3a", UUID: "8f2d4bd4-7b1e-4b3d-8c39-2a9b0e5b1c3a"},
+			},
+		},
+		{
+			body: "see [[8F2D4BD4-7B1E-4B3D-8C39-2A9B0E5B1C3A]]",
+			expected: []Link{
+				{Text: "8F2D4BD4-7B1E-4B3D-8C39-2A9B0E5B1C3A", UUID: "8f2d4bd4-7b1e-4b3d-8c39-2a9b0e5b1c3a"},
+			},
+		},
+		{
+			body: "see [[js/closures]] and [[ infra/k8s / networking ]]",
+			expected: []Link{
+				{Text: "js/closures", Book: "js", Title: "closures"},
+				{Text: "infra/k8s / networking", Book: "infra/k8s", Title: "networking"},
+			},
+		},
+		{
+			body: "[[js/closures]] twice [[js/closures]]",
+			expected: []Link{
+				{Text: "js/closures", Book: "js", Title: "closures"},
+			},
+		},
+		{
+			body:     "[[]] [[js]] [[/closures]] [[js/]] [[js\n/closures]] [js/closures]",
+			expected: []Link{},
+		},
+		{
+			body: "[[[js/closures]]]",
+			expected: []Link{
+				{Text: "js/closures", Book: "js", Title: "closures"},
+			},
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			assert.DeepEqual(t, Parse(tc.body), tc.expected, "result mismatch")
+		})
+	}
+}
+
+func TestTitle(t *testing.T) {
+	testCases := []struct {
+		body     string
+		expected string
+	}{
+		{
+			body:     "closures",
+			expected: "closures",
+		},
+		{
+			body:     "\n\n  # Closures  \nbody",
+			expected: "Closures",
+		},
+		{
+			body:     "### \ncontent",
+			expected: "content",
+		},
+		{
+			body:     "",
+			expected: "",
+		},
+	}
+
+	for idx, tc := range testCases {
+		t.Run(fmt.Sprintf("test case %d", idx), func(t *testing.T) {
+			assert.Equal(t, Title(tc.body), tc.expected, "result mismatch")
+		})
+	}
+}
@@ -20,7 +20,7 @@ import React, { useState } from 'react';
 import Helmet from 'react-helmet';
 import { Link, withRouter, RouteComponentProps } from 'react-router-dom';
 
-import { homePathDef } from 'web/libs/paths';
+import { homePathDef, loginPathDef } from 'web/libs/paths';
 import services from 'web/libs/services';
 import Form from './Form';
 import Logo from '../../Icons/Logo';
@@ -58,18 +58,31 @@ const PasswordResetConfirm: React.FunctionComponent<Props> = ({ match, history }
 
     services.users
       .resetPassword({ token, password })
-      .then(() => {
-        return dispatch(getCurrentUser());
-      })
-      .then(() => {
-        dispatch(
-          setMessage({
-            message: 'Your password was successfully reset.',
-            kind: 'info',
-            path: homePathDef
-          })
-        );
-        history.push('/');
+      .then(session => {
+        // no session is issued for an account with the two-factor authentication
+        if (!session) {
+          dispatch(
+            setMessage({
+              message:
+                'Your password was successfully reset. Please log in with your two-factor authentication code.',
+              kind: 'info',
+              path: loginPathDef
+            })
+          );
+          history.push(loginPathDef);
+          return null;
+        }
+
+        return dispatch(getCurrentUser()).then(() => {
+          dispatch(
+            setMessage({
+              message: 'Your password was successfully reset.',
+              kind: 'info',
+              path: homePathDef
+            })
+          );
+          history.push('/');
+        });
       })
       .catch(err => {
         setSubmitting(false);
@@ -36,6 +36,7 @@ const PasswordModal: React.FunctionComponent<Props> = ({ isOpen, onDismiss }) =>
   const [oldPassword, setOldPassword] = useState('');
   const [newPassword, setNewPassword] = useState('');
   const [newPasswordConfirmation, setNewPasswordConfirmation] = useState('');
+  const [revokeOtherSessions, setRevokeOtherSessions] = useState(true);
   const [inProgress, setInProgress] = useState(false);
   const [successMsg, setSuccessMsg] = useState('');
   const [failureMsg, setFailureMsg] = useState('');
@@ -45,6 +46,7 @@ const PasswordModal: React.FunctionComponent<Props> = ({ isOpen, onDismiss }) =>
       setOldPassword('');
       setNewPassword('');
       setNewPasswordConfirmation('');
+      setRevokeOtherSessions(true);
     }
   }, [isOpen]);
 
@@ -62,7 +64,8 @@ const PasswordModal: React.FunctionComponent<Props> = ({ isOpen, onDismiss }) =>
 
       await services.users.updatePassword({
         oldPassword,
-        newPassword
+        newPassword,
+        revokeOtherSessions
       });
 
       setSuccessMsg('Updated the password');
@@ -171,6 +174,24 @@ const PasswordModal: React.FunctionComponent<Props> = ({ isOpen, onDismiss }) =>
               className="form-control"
             />
           </div>
+          <div className={modalStyles['input-row']}>
+            <input
+              id="revoke-other-sessions-input"
+              type="checkbox"
+              checked={revokeOtherSessions}
+              onChange={e => {
+                const val = e.target.checked;
+
+                setRevokeOtherSessions(val);
+              }}
+            />
+            <label
+              className="input-label"
+              htmlFor="revoke-other-sessions-input"
+            >
+              Sign out of all other devices
+            </label>
+          </div>
 
           <div className={modalStyles.actions}>
             <Button
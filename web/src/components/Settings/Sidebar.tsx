@@ -21,11 +21,18 @@ import classnames from 'classnames';
 import { NavLink } from 'react-router-dom';
 
 import { SettingSections, getSettingsPath } from 'web/libs/paths';
+import { useSelector } from '../../store';
 import styles from './Sidebar.scss';
 
 interface Props {}
 
 const Sidebar: React.FunctionComponent<Props> = () => {
+  const { user } = useSelector(state => {
+    return {
+      user: state.auth.user.data
+    };
+  });
+
   return (
     <nav className={styles.wrapper}>
       <ul className={classnames('list-unstyled')}>
@@ -38,15 +45,17 @@ const Sidebar: React.FunctionComponent<Props> = () => {
             Account
           </NavLink>
         </li>
-        <li>
-          <NavLink
-            className={styles.item}
-            activeClassName={styles.active}
-            to={getSettingsPath(SettingSections.billing)}
-          >
-            Billing
-          </NavLink>
-        </li>
+        {user.billing && (
+          <li>
+            <NavLink
+              className={styles.item}
+              activeClassName={styles.active}
+              to={getSettingsPath(SettingSections.billing)}
+            >
+              Billing
+            </NavLink>
+          </li>
+        )}
       </ul>
     </nav>
   );
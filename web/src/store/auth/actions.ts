@@ -129,6 +129,7 @@ export function getCurrentUser(
               email: '',
               emailVerified: false,
               pro: false,
+              billing: false,
               classic: false
             })
           );
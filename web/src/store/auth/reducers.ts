@@ -48,6 +48,7 @@ export const initialState: AuthState = {
       email: '',
       emailVerified: false,
       pro: false,
+      billing: false,
       classic: false
     },
     errorMessage: ''
@@ -95,6 +96,7 @@ function reduceUsers(
           email: user.email,
           emailVerified: user.emailVerified,
           pro: user.pro,
+          billing: user.billing,
           classic: user.classic
         },
         errorMessage: '',
@@ -23,6 +23,8 @@ export interface UserData {
   email: string;
   emailVerified: boolean;
   pro: boolean;
+  // billing is false if the server does not handle payments, e.g. when self-hosted
+  billing: boolean;
   // TODO: remove once all classic users have been migrated
   classic: boolean;
 }